@@ -2,12 +2,32 @@ package main
 
 import (
 	"blockchain-system/core"
+	"flag"
 	"fmt"
 	"math/big"
+	"os"
 	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := runSimulate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "simulate:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "shards" {
+		if err := runShards(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "shards:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fs := flag.NewFlagSet("blockchain-system", flag.ExitOnError)
+	force := fs.Bool("force", false, "start even if the startup consistency check finds critical problems")
+	fs.Parse(os.Args[1:])
+
 	// === 1. Blockchain Initialization ===
 	bc := core.NewBlockchain()
 	bc.AddBlock("First Block after Genesis")
@@ -19,6 +39,7 @@ func main() {
 	bc.AddBlock("Seventh Block")
 	bc.AddBlock("Eighth Block")
 
+	fmt.Println("Chain ID:", bc.ChainID())
 	for _, block := range bc.Blocks {
 		fmt.Println("Index:", block.Index)
 		fmt.Println("Timestamp:", block.Timestamp)
@@ -33,13 +54,13 @@ func main() {
 	for _, block := range bc.Blocks {
 		sm.DistributeBlock(block)
 	}
-	sm.PrintShardState()
+	sm.PrintShardState(os.Stdout)
 
 	// Demonstrate logarithmic-time shard discovery
 	fmt.Println("\n[INFO] Demonstrating logarithmic-time shard discovery")
 	shardID := 0 // Example shard ID
-	if shard, exists := sm.FindShard(shardID); exists {
-		fmt.Printf("Found Shard #%d with %d blocks\n", shard.ID, len(shard.Blocks))
+	if shard, exists := sm.GetShardView(shardID); exists {
+		fmt.Printf("Found Shard #%d with %d blocks\n", shard.ID, shard.BlockCount)
 	} else {
 		fmt.Printf("Shard #%d not found\n", shardID)
 	}
@@ -52,50 +73,116 @@ func main() {
 		fmt.Printf("Cannot reconstruct state for Shard #%d\n", shardID)
 	}
 
-	// === 3. Atomic Cross-Shard Transfer with Homomorphic Authentication ===
-	fmt.Println("\n[INFO] Simulating atomic cross-shard transfer")
+	// === 3. Atomic Cross-Shard Value Transfer with Homomorphic Authentication ===
+	fmt.Println("\n[INFO] Simulating atomic cross-shard value transfer")
 	// Use enhanced sync manager with homomorphic authentication
 	enhancedSyncManager := core.NewEnhancedSyncManager("secret-key-123")
-	shards := sm.Shards.GetAllShards()
-	if len(shards) >= 2 {
-		// Successful transfer
-		fmt.Println("\n[INFO] Attempting successful transfer")
-		if enhancedSyncManager.CreateAuthenticatedTransfer(shards[0], shards[1], 0) {
-			verified := enhancedSyncManager.VerifyAndApplyTransfer(shards[0], shards[1], 0)
-			fmt.Printf("Transfer from Shard #%d to #%d: %v\n", shards[0].ID, shards[1].ID, verified)
-		}
-		sm.PrintShardState()
-
-		// Simulate failed transfer (e.g., invalid commitment)
-		fmt.Println("\n[INFO] Attempting failed transfer to demonstrate rollback")
-		// Use a new sync manager with a wrong key to force rollback
-		faultySyncManager := core.NewEnhancedSyncManager("wrong-key")
-		if faultySyncManager.CreateAuthenticatedTransfer(shards[0], shards[1], 0) {
-			verified := faultySyncManager.VerifyAndApplyTransfer(shards[0], shards[1], 0)
-			fmt.Printf("Transfer from Shard #%d to #%d: %v (should fail and rollback)\n", shards[0].ID, shards[1].ID, verified)
-		}
-		sm.PrintShardState()
+	sm.PartitionAddressSpace()
+	sender, recipient := "0xa11ce", "zoe"
+	senderShard, _ := sm.ShardForAddress(sender)
+	recipientShard, _ := sm.ShardForAddress(recipient)
+	senderShard.Credit(sender, 100)
+	fmt.Printf("%s lives on Shard #%d, %s on Shard #%d\n", sender, senderShard.ID, recipient, recipientShard.ID)
+
+	// Successful transfer
+	fmt.Println("\n[INFO] Attempting successful transfer")
+	err := sm.TransferValue(enhancedSyncManager, core.Transaction{From: sender, To: recipient, Amount: 40})
+	fmt.Printf("Transfer of 40 from %s to %s: %v\n", sender, recipient, err == nil)
+	fmt.Printf("Balances: %s=%d %s=%d\n", sender, senderShard.BalanceOf(sender), recipient, recipientShard.BalanceOf(recipient))
+
+	// Overdraft is rejected before anything is debited
+	fmt.Println("\n[INFO] Attempting overdraft to demonstrate rejection")
+	err = sm.TransferValue(enhancedSyncManager, core.Transaction{From: sender, To: recipient, Amount: 500, Nonce: 1})
+	fmt.Printf("Transfer of 500 from %s to %s: %v (%v)\n", sender, recipient, err == nil, err)
+	fmt.Printf("Balances: %s=%d %s=%d\n", sender, senderShard.BalanceOf(sender), recipient, recipientShard.BalanceOf(recipient))
+
+	// Block transfer whose key is replaced with a wrong key between prepare and commit
+	fmt.Println("\n[INFO] Attempting block transfer with a wrong key to demonstrate rollback")
+	keyring := core.NewHomomorphicAuthenticator("secret-key-123")
+	faultySyncManager := core.NewEnhancedSyncManagerWithKeyring(keyring)
+	if err := faultySyncManager.CreateAuthenticatedTransfer(senderShard, recipientShard, 0); err == nil {
+		keyring.RotateKey(core.DefaultKeyID, []byte("wrong-key"))
+		err = faultySyncManager.VerifyAndApplyTransfer(senderShard, recipientShard, 0)
+		fmt.Printf("Block transfer from Shard #%d to #%d: %v\n", senderShard.ID, recipientShard.ID, err)
 	} else {
-		fmt.Println("Not enough shards for transfer demo")
+		fmt.Printf("Block transfer could not be prepared: %v\n", err)
+	}
+
+	fmt.Println("\nTransfer journal:")
+	for _, record := range append(enhancedSyncManager.Journal(), faultySyncManager.Journal()...) {
+		fmt.Printf("  %s %s: %s %v", record.Kind, record.ID, record.Outcome, record.Phases)
+		if record.Reason != "" {
+			fmt.Printf(" (%s)", record.Reason)
+		}
+		fmt.Println()
 	}
 
 	// === 4. Shard Merging ===
 	fmt.Println("\nChecking for underutilized shards to merge...")
-	sm.MergeShards(2)
-	sm.PrintShardState()
+	if err := sm.MergeShards(2); err != nil {
+		fmt.Println("Shard merge aborted:", err)
+	}
+	sm.PrintShardState(os.Stdout)
 
 	// === 5. BFT Consensus Round ===
-	bft := core.NewBFTManager(10)
+	stakes := core.NewStakeRegistry()
+	bft := core.NewBFTManager(10, core.WithStakeRegistry(stakes))
+	for _, node := range bft.Nodes {
+		stakes.Bond(node.ID, uint64(1000*(node.ID+1)))
+	}
 	bft.RunConsensus()
 
 	// === 6. Hybrid Consensus ===
-	consensus := &core.ConsensusManager{BFT: bft}
+	consensus := core.NewConsensusManager(bft)
+	consensus.SetElectionMode(core.ReputationWeighted)
 	consensus.RunHybridConsensus()
 
+	// Certify each block with 2f+1 signed commit votes; Byzantine nodes
+	// equivocate instead of staying silent
+	for _, node := range bft.Nodes {
+		if node.Byzantine {
+			node.Behavior = core.EquivocatingBehavior{}
+		}
+	}
+	for _, block := range bc.Blocks[1:] {
+		cert, err := bft.Decide(0, block.Index, block.Hash)
+		if err != nil {
+			fmt.Printf("Block #%d not certified: %v\n", block.Index, err)
+			continue
+		}
+		bc.AttachCertificate(cert)
+	}
+	if err := bc.Validate(core.WithCertificateKeys(bft.PublicKeys())); err != nil {
+		fmt.Println("Chain validation failed:", err)
+	} else {
+		fmt.Println("Chain and commit certificates are valid")
+	}
+	for _, node := range bft.Nodes {
+		if node.Byzantine {
+			fmt.Printf("Equivocating node #%d reputation: %.2f, stake after slashing: %d\n",
+				node.ID, node.Reputation, stakes.Stake(node.ID))
+		}
+	}
+
 	// === 7. Zero-Knowledge Proof Demo ===
 	zk := &core.ZKProver{}
 	zk.TestZKP()
 
+	// Shard state transition proof for appending one block
+	provingShard := core.NewShard(0)
+	provingShard.EnableTransitionProofs(true)
+	for _, block := range bc.Blocks[:3] {
+		provingShard.AddBlock(block)
+	}
+	oldRoot := provingShard.GetRoot()
+	provingShard.AddBlock(bc.Blocks[3])
+	zk.Source = provingShard
+	transition := zk.ProveStateTransition(oldRoot, provingShard.GetRoot(), bc.Blocks[3].Hash)
+	fmt.Println("State Transition Proof Valid?",
+		zk.VerifyStateTransition(oldRoot, provingShard.GetRoot(), bc.Blocks[3].Hash, transition))
+	fmt.Println("State Transition Proof Valid for Wrong Block?",
+		zk.VerifyStateTransition(oldRoot, provingShard.GetRoot(), bc.Blocks[2].Hash, transition))
+
 	// === 8. RSA Cryptographic Accumulator Demo ===
 	fmt.Println("\n=== RSA Cryptographic Accumulator Demonstration ===")
 	acc := core.NewRSAAccumulator()
@@ -121,7 +208,14 @@ func main() {
 	for _, block := range bc.Blocks {
 		smgr.AddBlock(block)
 	}
-	smgr.PrintState()
+	smgr.PrintState(os.Stdout)
+
+	// The chain, forest and state manager must agree before going on
+	fmt.Println("\n=== Startup Consistency Check ===")
+	if err := checkConsistency(bc, sm, smgr, *force); err != nil {
+		fmt.Fprintln(os.Stderr, "startup:", err)
+		os.Exit(1)
+	}
 
 	// Demonstrate retrieving data from trie
 	fmt.Println("\nRetrieving block data from succinct trie:")
@@ -148,11 +242,21 @@ func main() {
 	}
 
 	// Setup MPC protocol with threshold 3 (need at least 3 honest nodes)
-	mpcProtocol := core.NewMPCProtocol(nodes, 3)
+	mpcProtocol, err := core.NewMPCProtocol(nodes, 3)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "MPC setup:", err)
+		os.Exit(1)
+	}
 
 	// Simulate threshold signing
-	signature := mpcProtocol.SimulateMPCSignature("Important blockchain message")
-	fmt.Println("MPC Signature verification:", len(signature) > 0)
+	mpcMessage := "Important blockchain message"
+	signature, err := mpcProtocol.SimulateMPCSignature(mpcMessage)
+	if err != nil {
+		fmt.Println("MPC signing failed:", err)
+	} else {
+		fmt.Println("MPC Signature verification:",
+			core.VerifyThresholdSignature(mpcProtocol.GroupPublicKey(), mpcMessage, signature))
+	}
 
 	// === 11. Probabilistic Verification with Bloom Filters ===
 	fmt.Println("\n=== Probabilistic Verification Demonstration ===")
@@ -183,6 +287,10 @@ func main() {
 	}
 	fmt.Printf("Bloom filter false positive rate: %.6f%%\n",
 		bloomFilter.FalsePositiveRate()*100)
+	if encoded, err := bloomFilter.MarshalBinary(); err == nil {
+		fmt.Printf("Bloom filter encodes to %d bytes, estimated items: %.1f\n",
+			len(encoded), bloomFilter.EstimateCardinality())
+	}
 
 	// === 12. Advanced CAP Optimization Test ===
 	fmt.Println("\n=== Advanced CAP Theorem Optimization Test ===")
@@ -274,6 +382,9 @@ func demoAdaptiveCAP(acm *core.AdaptiveCapacityManager) {
 	for nodeID, capacity := range globalView {
 		fmt.Printf("- %s: %.2f\n", nodeID, capacity)
 	}
+	summary := acm.SummarizeNode("node1")
+	fmt.Printf("\nnode1 summary: %d samples, latency min/avg/p95 %s/%s/%s, mean error rate %.3f\n",
+		summary.Samples, summary.MinLatency, summary.AvgLatency, summary.P95Latency, summary.MeanErrorRate)
 	// === Consistency Orchestration Simulation ===
 	orch := core.NewOrchestrator()
 
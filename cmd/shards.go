@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+
+	"blockchain-system/core"
+)
+
+// runShards implements the shards subcommand: it distributes a demo chain
+// over a forest and reports what plan-rebalance or plan-merge would change,
+// without applying it
+func runShards(args []string) error {
+	if len(args) == 0 || (args[0] != "plan-rebalance" && args[0] != "plan-merge") {
+		return errors.New("usage: shards plan-rebalance|plan-merge [flags]")
+	}
+	fs := flag.NewFlagSet("shards "+args[0], flag.ExitOnError)
+	blocks := fs.Int("blocks", 12, "demo blocks to distribute before planning")
+	max := fs.Int("max", 2, "split threshold plan-rebalance plans at")
+	threshold := fs.Int("threshold", core.MaxBlocksPerShard, "block count below which plan-merge merges shards")
+	asJSON := fs.Bool("json", false, "print the plan as JSON")
+	fs.Parse(args[1:])
+
+	bc := core.NewBlockchain()
+	for i := 1; i <= *blocks; i++ {
+		bc.AddBlock(fmt.Sprintf("Demo Block %d", i))
+	}
+	sm := core.NewShardManager()
+	for _, block := range bc.Blocks {
+		sm.DistributeBlock(block)
+	}
+
+	var plan core.Plan
+	if args[0] == "plan-rebalance" {
+		if err := sm.SetThresholds(1, *max); err != nil {
+			return err
+		}
+		plan = sm.PlanRebalance()
+	} else {
+		plan = sm.PlanMerge(*threshold)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	summary := plan.Summary()
+	fmt.Printf("Forest root: %s\n", summary.ForestRoot)
+	for _, shard := range summary.Shards {
+		fmt.Printf("Shard #%d: %d → %d blocks\n", shard.ID, shard.Before, shard.After)
+	}
+	fmt.Printf("New shards: %v | Removed shards: %v | Blocks moved: %d (~%d bytes)\n",
+		summary.NewShardIDs, summary.RemovedShardIDs, summary.BlocksMoved, summary.BytesMoved)
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"blockchain-system/simulation"
+)
+
+// runSimulate implements the simulate subcommand: it runs the scenario in
+// the --config file, or the default scenario, and writes the JSON report
+// to --out or standard output
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	config := fs.String("config", "", "scenario JSON file; defaults are used when empty")
+	out := fs.String("out", "", "report file; standard output when empty")
+	fs.Parse(args)
+
+	scenario := simulation.NewScenario()
+	if *config != "" {
+		f, err := os.Open(*config)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if scenario, err = simulation.LoadScenario(f); err != nil {
+			return fmt.Errorf("%s: %w", *config, err)
+		}
+	}
+
+	report, err := scenario.Run()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return os.WriteFile(*out, append(data, '\n'), 0o644)
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"blockchain-system/core"
+)
+
+// checkConsistency runs the startup consistency check over the chain,
+// forest and state manager, printing every finding. Critical findings stop
+// the start unless force is set.
+func checkConsistency(bc *core.Blockchain, sm *core.ShardManager, st *core.StateManager, force bool) error {
+	report := core.CheckSystemConsistency(bc, sm, st)
+	fmt.Printf("Checked %d chain, %d shard and %d state blocks: %d critical, %d warning, %d info\n",
+		report.ChainBlocks, report.ShardBlocks, report.StateBlocks,
+		report.Count(core.SeverityCritical), report.Count(core.SeverityWarning), report.Count(core.SeverityInfo))
+	for _, finding := range report.Findings {
+		fmt.Println(" ", finding)
+	}
+	if report.Critical() && !force {
+		return fmt.Errorf("%d critical consistency findings; rerun with --force to start anyway", report.Count(core.SeverityCritical))
+	}
+	return nil
+}
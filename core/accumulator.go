@@ -1,11 +1,8 @@
 package core
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"math/big"
-	"math/rand"
-	"time"
 )
 
 // RSAAccumulator represents an RSA-based cryptographic accumulator
@@ -15,13 +12,23 @@ type RSAAccumulator struct {
 	State    *big.Int            // Current accumulator state
 	Elements []string            // For demo: store elements (in production, store only state)
 	Proofs   map[string]*big.Int // Membership proofs for elements
+	hashFn   Hasher              // nil is SHA-256
+	log      []AdditionEntry     // Every change to State, oldest first
 }
 
-// NewRSAAccumulator initializes a new RSA accumulator
-func NewRSAAccumulator() *RSAAccumulator {
-	// Seed random number generator
-	rand.Seed(time.Now().UnixNano())
+// AccumulatorOption configures an RSAAccumulator
+type AccumulatorOption func(*RSAAccumulator)
+
+// WithAccumulatorHasher derives element primes with a hash function other
+// than SHA-256
+func WithAccumulatorHasher(h Hasher) AccumulatorOption {
+	return func(acc *RSAAccumulator) {
+		acc.hashFn = h
+	}
+}
 
+// NewRSAAccumulator initializes a new RSA accumulator
+func NewRSAAccumulator(opts ...AccumulatorOption) *RSAAccumulator {
 	// Generate two safe primes (simplified for demo; in production, use crypto/rsa)
 	p := big.NewInt(251) // Example safe prime
 	q := big.NewInt(239) // Example safe prime
@@ -31,19 +38,30 @@ func NewRSAAccumulator() *RSAAccumulator {
 	// Initialize accumulator state as g
 	state := new(big.Int).Set(g)
 
-	return &RSAAccumulator{
+	acc := &RSAAccumulator{
 		N:        n,
 		G:        g,
 		State:    state,
 		Elements: []string{},
 		Proofs:   make(map[string]*big.Int),
 	}
+	for _, opt := range opts {
+		opt(acc)
+	}
+	return acc
 }
 
 // hashToPrime converts a string to a prime number (simplified for demo)
 func (acc *RSAAccumulator) hashToPrime(data string) *big.Int {
-	hash := sha256.Sum256([]byte(data))
-	hashInt := new(big.Int).SetBytes(hash[:])
+	return hashToPrime(acc.hashFn, data)
+}
+
+// hashToPrime derives an element's prime with h, SHA-256 if nil
+func hashToPrime(h Hasher, data string) *big.Int {
+	if h == nil {
+		h = defaultHasher
+	}
+	hashInt := new(big.Int).SetBytes(h.Sum([]byte(data)))
 	// Ensure it's odd (for simplicity, add 1 if even)
 	if hashInt.Bit(0) == 0 {
 		hashInt.Add(hashInt, big.NewInt(1))
@@ -52,13 +70,28 @@ func (acc *RSAAccumulator) hashToPrime(data string) *big.Int {
 	return hashInt
 }
 
-// AddElement adds an element to the accumulator
+// AddElement adds an element to the accumulator and records it in the
+// addition log
 func (acc *RSAAccumulator) AddElement(element string) {
+	prior := new(big.Int).Set(acc.State)
+	acc.add(element)
+	acc.appendLog(element, prior, false)
+}
+
+// add adds an element without logging it
+func (acc *RSAAccumulator) add(element string) {
 	prime := acc.hashToPrime(element)
 	// Update state: state = state^prime mod N
 	acc.State.Exp(acc.State, prime, acc.N)
 	acc.Elements = append(acc.Elements, element)
 
+	// Refresh existing witnesses so they verify against the new state
+	for e, proof := range acc.Proofs {
+		if e != element {
+			proof.Exp(proof, prime, acc.N)
+		}
+	}
+
 	// Generate membership proof: proof = G^(product of all other primes) mod N
 	proof := new(big.Int).Set(acc.G)
 	for _, e := range acc.Elements {
@@ -69,12 +102,55 @@ func (acc *RSAAccumulator) AddElement(element string) {
 	acc.Proofs[element] = proof
 }
 
-// VerifyMembership checks if an element is in the accumulator
-func (acc *RSAAccumulator) VerifyMembership(element string, proof *big.Int) bool {
+// RemoveElement deletes an element by recomputing the state and all witnesses
+// from the remaining elements (no trapdoor is kept, so this is O(n^2)). The
+// removal is recorded in the addition log.
+func (acc *RSAAccumulator) RemoveElement(element string) bool {
+	index := -1
+	for i, e := range acc.Elements {
+		if e == element {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return false
+	}
+
+	remaining := append([]string{}, acc.Elements[:index]...)
+	remaining = append(remaining, acc.Elements[index+1:]...)
+
+	prior := acc.State
+	acc.State = new(big.Int).Set(acc.G)
+	acc.Elements = []string{}
+	acc.Proofs = make(map[string]*big.Int)
+	for _, e := range remaining {
+		acc.add(e)
+	}
+	acc.appendLog(element, prior, true)
+	return true
+}
+
+// CheckMembership checks that proof witnesses element in the accumulator,
+// returning ErrInvalidProof if it does not
+func (acc *RSAAccumulator) CheckMembership(element string, proof *big.Int) error {
+	if proof == nil {
+		return fmt.Errorf("%w: no witness for %s", ErrInvalidProof, element)
+	}
 	prime := acc.hashToPrime(element)
 	// Verify: proof^prime mod N == state
 	result := new(big.Int).Exp(proof, prime, acc.N)
-	return result.Cmp(acc.State) == 0
+	if result.Cmp(acc.State) != 0 {
+		return fmt.Errorf("%w: witness does not accumulate %s", ErrInvalidProof, element)
+	}
+	return nil
+}
+
+// VerifyMembership checks if an element is in the accumulator
+//
+// Deprecated: use CheckMembership, which reports why a proof fails.
+func (acc *RSAAccumulator) VerifyMembership(element string, proof *big.Int) bool {
+	return acc.CheckMembership(element, proof) == nil
 }
 
 // TestAccumulator demonstrates the RSA accumulator
@@ -99,14 +175,14 @@ func (acc *RSAAccumulator) TestAccumulator() {
 	for _, e := range elements {
 		proof, exists := acc.Proofs[e]
 		if exists {
-			valid := acc.VerifyMembership(e, proof)
-			fmt.Printf("Membership proof for %s: %v\n", e, valid)
+			err := acc.CheckMembership(e, proof)
+			fmt.Printf("Membership proof for %s: %v\n", e, err == nil)
 		}
 	}
 
 	// Test non-member
 	nonMember := "block_hash_4"
 	fakeProof := new(big.Int).Set(acc.G) // Invalid proof
-	valid := acc.VerifyMembership(nonMember, fakeProof)
-	fmt.Printf("Membership proof for %s (non-member): %v\n", nonMember, valid)
+	err := acc.CheckMembership(nonMember, fakeProof)
+	fmt.Printf("Membership proof for %s (non-member): %v\n", nonMember, err == nil)
 }
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+)
+
+// Errors returned when importing accumulators and verifying their logs
+var (
+	ErrAccumulatorLog     = errors.New("accumulator log does not reproduce its state")
+	ErrCorruptAccumulator = errors.New("corrupt accumulator export")
+)
+
+// AdditionEntry records one change to an accumulator's state. Hash chains
+// the entry to every one before it, so altering any earlier entry changes
+// every later hash.
+type AdditionEntry struct {
+	Element    string    `json:"element"`
+	PriorState string    `json:"prior_state"` // Hex state before the change
+	NewState   string    `json:"new_state"`   // Hex state after the change
+	Timestamp  time.Time `json:"timestamp"`
+	Removed    bool      `json:"removed,omitempty"` // Element was removed rather than added
+	Hash       string    `json:"hash"`              // Rolling hash over the previous entry's hash and this entry
+}
+
+// entryHash computes an entry's rolling hash from the previous entry's
+func entryHash(h Hasher, prev string, e AdditionEntry) string {
+	return hexSum(h, []byte(fmt.Sprintf("%s|%s|%s|%s|%d|%t", prev, e.Element, e.PriorState, e.NewState, e.Timestamp.UnixNano(), e.Removed)))
+}
+
+// appendLog records a change from prior to the current state
+func (acc *RSAAccumulator) appendLog(element string, prior *big.Int, removed bool) {
+	entry := AdditionEntry{
+		Element:    element,
+		PriorState: prior.Text(16),
+		NewState:   acc.State.Text(16),
+		Timestamp:  time.Now(),
+		Removed:    removed,
+	}
+	prev := ""
+	if len(acc.log) > 0 {
+		prev = acc.log[len(acc.log)-1].Hash
+	}
+	entry.Hash = entryHash(acc.hashFn, prev, entry)
+	acc.log = append(acc.log, entry)
+}
+
+// AdditionLog returns every change to the accumulator state, oldest first
+func (acc *RSAAccumulator) AdditionLog() []AdditionEntry {
+	return append([]AdditionEntry(nil), acc.log...)
+}
+
+// VerifyLog replays the addition log from G and checks that every entry is
+// chained to the one before it, moves the state from its prior to its new
+// state, and that the last entry leaves the current state
+func (acc *RSAAccumulator) VerifyLog() error {
+	replay := &RSAAccumulator{N: acc.N, G: acc.G, State: new(big.Int).Set(acc.G), Proofs: make(map[string]*big.Int), hashFn: acc.hashFn}
+	prev := ""
+	for i, entry := range acc.log {
+		if entry.Hash != entryHash(acc.hashFn, prev, entry) {
+			return fmt.Errorf("%w: entry %d hash", ErrAccumulatorLog, i)
+		}
+		if entry.PriorState != replay.State.Text(16) {
+			return fmt.Errorf("%w: entry %d prior state", ErrAccumulatorLog, i)
+		}
+		if entry.Removed {
+			if !replay.RemoveElement(entry.Element) {
+				return fmt.Errorf("%w: entry %d removes absent element %q", ErrAccumulatorLog, i, entry.Element)
+			}
+		} else {
+			replay.add(entry.Element)
+		}
+		if entry.NewState != replay.State.Text(16) {
+			return fmt.Errorf("%w: entry %d new state", ErrAccumulatorLog, i)
+		}
+		prev = entry.Hash
+	}
+	if replay.State.Cmp(acc.State) != 0 {
+		return fmt.Errorf("%w: replayed state %s, current %s", ErrAccumulatorLog, replay.State.Text(16), acc.State.Text(16))
+	}
+	return nil
+}
+
+// accumulatorExport is the serialized form of an RSAAccumulator
+type accumulatorExport struct {
+	N        string          `json:"n"` // Hex
+	G        string          `json:"g"` // Hex
+	State    string          `json:"state"`
+	Hasher   string          `json:"hasher,omitempty"` // Hash function name, empty for SHA-256
+	Elements []string        `json:"elements,omitempty"`
+	Log      []AdditionEntry `json:"log,omitempty"`
+}
+
+// Export writes the accumulator's parameters, state, elements and addition
+// log as JSON. Accumulators keeping no elements export only their state.
+func (acc *RSAAccumulator) Export(w io.Writer) error {
+	return json.NewEncoder(w).Encode(accumulatorExport{
+		N:        acc.N.Text(16),
+		G:        acc.G.Text(16),
+		State:    acc.State.Text(16),
+		Hasher:   hasherName(acc.hashFn),
+		Elements: acc.Elements,
+		Log:      acc.log,
+	})
+}
+
+// ImportAccumulator reads an accumulator written by Export. The addition
+// log, if any, must reproduce the state, as must the elements if exported;
+// membership proofs are recomputed for them.
+func ImportAccumulator(r io.Reader) (*RSAAccumulator, error) {
+	var export accumulatorExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptAccumulator, err)
+	}
+	h, err := LookupHasher(export.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	n, nOK := new(big.Int).SetString(export.N, 16)
+	g, gOK := new(big.Int).SetString(export.G, 16)
+	state, stateOK := new(big.Int).SetString(export.State, 16)
+	if !nOK || !gOK || !stateOK || n.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: malformed parameters", ErrCorruptAccumulator)
+	}
+
+	acc := &RSAAccumulator{N: n, G: g, State: new(big.Int).Set(g), Elements: []string{}, Proofs: make(map[string]*big.Int)}
+	if export.Hasher != "" {
+		acc.hashFn = h
+	}
+	if len(export.Elements) > 0 {
+		for _, e := range export.Elements {
+			acc.add(e)
+		}
+		if acc.State.Cmp(state) != 0 {
+			return nil, fmt.Errorf("%w: elements do not reproduce state %s", ErrCorruptAccumulator, export.State)
+		}
+	}
+	acc.State = state
+	acc.log = export.Log
+	if len(acc.log) > 0 {
+		if err := acc.VerifyLog(); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// loggedAccumulator adds five elements and removes one
+func loggedAccumulator(t *testing.T) *RSAAccumulator {
+	t.Helper()
+	acc := NewRSAAccumulator()
+	for _, e := range testLeaves(5) {
+		acc.AddElement(e)
+	}
+	if !acc.RemoveElement("tx-2") {
+		t.Fatal("element not removed")
+	}
+	return acc
+}
+
+func TestAccumulatorExportRoundTrip(t *testing.T) {
+	acc := loggedAccumulator(t)
+	if err := acc.VerifyLog(); err != nil {
+		t.Fatal(err)
+	}
+	log := acc.AdditionLog()
+	if len(log) != 6 || !log[5].Removed || log[5].Element != "tx-2" || log[5].NewState != acc.State.Text(16) {
+		t.Fatalf("log %+v", log)
+	}
+
+	var buf bytes.Buffer
+	if err := acc.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ImportAccumulator(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.State.Cmp(acc.State) != 0 || len(imported.AdditionLog()) != 6 {
+		t.Fatalf("imported state %s with %d log entries", imported.State, len(imported.AdditionLog()))
+	}
+	for _, e := range acc.Elements {
+		if !imported.VerifyMembership(e, imported.Proofs[e]) {
+			t.Fatalf("imported proof for %s rejected", e)
+		}
+	}
+	// Adding to the import extends the same log
+	imported.AddElement("tx-9")
+	if err := imported.VerifyLog(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccumulatorLogDetectsAlteredElement(t *testing.T) {
+	acc := loggedAccumulator(t)
+
+	// Editing an element breaks the rolling hash
+	acc.log[1].Element = "tx-forged"
+	if err := acc.VerifyLog(); !errors.Is(err, ErrAccumulatorLog) {
+		t.Fatalf("log with an altered element: %v", err)
+	}
+
+	// Rehashing the chain from the edit on still fails the replay
+	prev := acc.log[0].Hash
+	for i := 1; i < len(acc.log); i++ {
+		acc.log[i].Hash = entryHash(acc.hashFn, prev, acc.log[i])
+		prev = acc.log[i].Hash
+	}
+	if err := acc.VerifyLog(); !errors.Is(err, ErrAccumulatorLog) {
+		t.Fatalf("rehashed log with an altered element: %v", err)
+	}
+}
+
+func TestImportRejectsTamperedExport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := loggedAccumulator(t).Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var export accumulatorExport
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tamper := range map[string]func(*accumulatorExport){
+		"log element": func(e *accumulatorExport) { e.Log[3].Element = "tx-forged" },
+		"elements":    func(e *accumulatorExport) { e.Elements = append(e.Elements, "tx-forged") },
+		"state":       func(e *accumulatorExport) { e.State = "2a" },
+		"dropped log": func(e *accumulatorExport) { e.Log = e.Log[:4] },
+	} {
+		tampered := export
+		tampered.Log = append([]AdditionEntry(nil), export.Log...)
+		tampered.Elements = append([]string(nil), export.Elements...)
+		tamper(&tampered)
+		data, err := json.Marshal(tampered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ImportAccumulator(bytes.NewReader(data)); err == nil {
+			t.Errorf("export with tampered %s imported", name)
+		}
+	}
+	if _, err := ImportAccumulator(bytes.NewReader([]byte("{"))); !errors.Is(err, ErrCorruptAccumulator) {
+		t.Fatalf("truncated export: %v", err)
+	}
+}
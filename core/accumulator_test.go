@@ -0,0 +1,111 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// accumulatorSetup distributes a short chain over a manager keeping shard
+// accumulators and returns it with two of its shards
+func accumulatorSetup(t *testing.T) (*ShardManager, *Shard, *Shard) {
+	t.Helper()
+	sm := NewShardManager(WithShardAccumulators())
+	bc := NewBlockchain()
+	for _, data := range testLeaves(7) {
+		if err := bc.AddBlock(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks {
+		sm.DistributeBlock(b)
+	}
+	shards := sm.Shards.GetAllShards()
+	if len(shards) < 2 {
+		t.Fatalf("chain distributed over %d shards, want at least 2", len(shards))
+	}
+	return sm, shards[0], shards[1]
+}
+
+func TestShardAccumulatorProvesEveryBlock(t *testing.T) {
+	sm, _, _ := accumulatorSetup(t)
+	for _, view := range sm.GetAllShardViews() {
+		shard, err := sm.FindShard(view.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range view.Blocks {
+			proof, err := shard.ProveBlockMembership(b.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sm.VerifyBlockInShard(view.ID, b.Hash, proof); err != nil {
+				t.Errorf("block %s in shard #%d: %v", b.Hash, view.ID, err)
+			}
+		}
+	}
+}
+
+func TestAccumulatorProofsAfterBlockMovesOut(t *testing.T) {
+	sm, source, destination := accumulatorSetup(t)
+	moved := source.Blocks[0].Hash
+	staleMoved, err := source.ProveBlockMembership(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := make(map[string]*big.Int)
+	for _, b := range source.Blocks[1:] {
+		proof, err := source.ProveBlockMembership(b.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale[b.Hash] = proof
+	}
+
+	if err := NewSyncManager().SyncBlock(source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The moved block is no longer provable in its old shard
+	if _, err := source.ProveBlockMembership(moved); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("moved block still provable in the source: %v", err)
+	}
+	if err := sm.VerifyBlockInShard(source.ID, moved, staleMoved); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("stale proof of the moved block: %v", err)
+	}
+	proof, err := destination.ProveBlockMembership(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.VerifyBlockInShard(destination.ID, moved, proof); err != nil {
+		t.Errorf("moved block in its new shard: %v", err)
+	}
+
+	// Blocks left behind get refreshed proofs against the new state
+	for hash, old := range stale {
+		fresh, err := source.ProveBlockMembership(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.VerifyBlockInShard(source.ID, hash, fresh); err != nil {
+			t.Errorf("refreshed proof of %s: %v", hash, err)
+		}
+		if fresh.Cmp(old) == 0 {
+			t.Errorf("proof of %s not refreshed after a removal", hash)
+		}
+	}
+
+	// A light client holding only the accumulator state can check proofs
+	view := source.View()
+	state, ok := new(big.Int).SetString(view.AccumulatorState, 16)
+	if !ok {
+		t.Fatalf("view accumulator state %q", view.AccumulatorState)
+	}
+	client := &RSAAccumulator{N: source.Accumulator.N, G: source.Accumulator.G, State: state}
+	for hash := range stale {
+		fresh, _ := source.ProveBlockMembership(hash)
+		if err := client.CheckMembership(hash, fresh); err != nil {
+			t.Errorf("state-only check of %s: %v", hash, err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"math"
+	"sort"
 	"sync"
 	"time"
 )
@@ -124,26 +126,65 @@ type AdaptiveCapacityManager struct {
 	historyLimit   int
 	policy         AdaptiveCapacityPolicy
 	vectorClock    *VectorClock
+	now            func() time.Time
+	tombstones     map[string]Tombstone // Decommissioned nodes, see DecommissionNode
+	retention      time.Duration
 	mu             sync.RWMutex
 }
 
+// NodeSummary aggregates the stored metric window of one node
+type NodeSummary struct {
+	NodeID        string
+	Samples       int
+	MinLatency    time.Duration
+	AvgLatency    time.Duration
+	P95Latency    time.Duration
+	MeanErrorRate float64
+}
+
+// AdaptiveCapacityOption configures an AdaptiveCapacityManager
+type AdaptiveCapacityOption func(*AdaptiveCapacityManager)
+
+// WithCapacityClock replaces the clock used to stamp metrics recorded
+// without a timestamp
+func WithCapacityClock(now func() time.Time) AdaptiveCapacityOption {
+	return func(acm *AdaptiveCapacityManager) {
+		acm.now = now
+	}
+}
+
 // NewAdaptiveCapacityManager creates a new adaptive capacity manager
-func NewAdaptiveCapacityManager(nodeID string) *AdaptiveCapacityManager {
-	return &AdaptiveCapacityManager{
+func NewAdaptiveCapacityManager(nodeID string, opts ...AdaptiveCapacityOption) *AdaptiveCapacityManager {
+	acm := &AdaptiveCapacityManager{
 		nodeCapacities: make(map[string]float64),
 		nodeLastUpdate: make(map[string]time.Time),
 		metricHistory:  make(map[string][]NetworkMetrics),
 		historyLimit:   100,
 		policy:         NewDefaultAdaptivePolicy(),
 		vectorClock:    NewVectorClock(),
+		now:            time.Now,
+		tombstones:     make(map[string]Tombstone),
+		retention:      DefaultTombstoneRetention,
+	}
+	for _, opt := range opts {
+		opt(acm)
 	}
+	return acm
 }
 
-// RecordMetrics records new network metrics for a node
+// RecordMetrics records new network metrics for a node, unless they predate
+// its tombstone
 func (acm *AdaptiveCapacityManager) RecordMetrics(metrics NetworkMetrics) {
 	acm.mu.Lock()
 	defer acm.mu.Unlock()
 
+	if metrics.Timestamp.IsZero() {
+		metrics.Timestamp = acm.now()
+	}
+	if acm.buried(metrics.NodeID, metrics) {
+		return
+	}
+
 	// Update vector clock
 	acm.vectorClock.Update(metrics.NodeID)
 
@@ -166,7 +207,7 @@ func (acm *AdaptiveCapacityManager) RecordMetrics(metrics NetworkMetrics) {
 
 	// Update node capacity
 	acm.nodeCapacities[metrics.NodeID] = acm.policy.AdjustCapacity(metrics)
-	acm.nodeLastUpdate[metrics.NodeID] = time.Now()
+	acm.nodeLastUpdate[metrics.NodeID] = acm.now()
 }
 
 // GetNodeCapacity returns the current capacity for a given node
@@ -185,7 +226,9 @@ func (acm *AdaptiveCapacityManager) GetNodeCapacity(nodeID string) float64 {
 	return 100.0 // Fallback default
 }
 
-// SyncWithPeer syncs capacity information with another node
+// SyncWithPeer syncs capacity information with another node. Metrics about
+// decommissioned nodes are ignored unless newer than their tombstones;
+// apply the peer's tombstones with MergeTombstones first.
 func (acm *AdaptiveCapacityManager) SyncWithPeer(peerMetrics map[string]NetworkMetrics, peerVC *VectorClock) {
 	acm.mu.Lock()
 	defer acm.mu.Unlock()
@@ -197,6 +240,9 @@ func (acm *AdaptiveCapacityManager) SyncWithPeer(peerMetrics map[string]NetworkM
 
 	// Process metrics from peer
 	for nodeID, metrics := range peerMetrics {
+		if acm.buried(nodeID, metrics) {
+			continue
+		}
 		// Only process metrics that are newer than what we have
 		if lastUpdate, exists := acm.nodeLastUpdate[nodeID]; !exists || metrics.Timestamp.After(lastUpdate) {
 			// Add to history
@@ -243,3 +289,78 @@ func (acm *AdaptiveCapacityManager) SetPolicy(policy AdaptiveCapacityPolicy) {
 	defer acm.mu.Unlock()
 	acm.policy = policy
 }
+
+// copyMetrics copies metrics, cloning vector clocks so callers cannot
+// mutate the stored history
+func copyMetrics(metrics NetworkMetrics) NetworkMetrics {
+	if metrics.VectorClock != nil {
+		metrics.VectorClock = metrics.VectorClock.Clone()
+	}
+	return metrics
+}
+
+// GetMetricHistory returns a node's stored metrics recorded at or after since,
+// oldest first
+func (acm *AdaptiveCapacityManager) GetMetricHistory(nodeID string, since time.Time) []NetworkMetrics {
+	acm.mu.RLock()
+	defer acm.mu.RUnlock()
+
+	var history []NetworkMetrics
+	for _, metrics := range acm.metricHistory[nodeID] {
+		if !metrics.Timestamp.Before(since) {
+			history = append(history, copyMetrics(metrics))
+		}
+	}
+	return history
+}
+
+// GetLatestMetrics returns the most recently stored metrics for a node
+func (acm *AdaptiveCapacityManager) GetLatestMetrics(nodeID string) (NetworkMetrics, bool) {
+	acm.mu.RLock()
+	defer acm.mu.RUnlock()
+
+	history := acm.metricHistory[nodeID]
+	if len(history) == 0 {
+		return NetworkMetrics{}, false
+	}
+	return copyMetrics(history[len(history)-1]), true
+}
+
+// SummarizeNode computes latency and error statistics over a node's stored
+// window. The history is copied under the read lock and summarized unlocked.
+func (acm *AdaptiveCapacityManager) SummarizeNode(nodeID string) NodeSummary {
+	acm.mu.RLock()
+	history := make([]NetworkMetrics, len(acm.metricHistory[nodeID]))
+	copy(history, acm.metricHistory[nodeID])
+	acm.mu.RUnlock()
+
+	summary := NodeSummary{NodeID: nodeID, Samples: len(history)}
+	if len(history) == 0 {
+		return summary
+	}
+
+	latencies := make([]time.Duration, len(history))
+	var totalLatency time.Duration
+	var totalErrors float64
+	for i, metrics := range history {
+		latencies[i] = metrics.Latency
+		totalLatency += metrics.Latency
+		totalErrors += metrics.ErrorRate
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	summary.MinLatency = latencies[0]
+	summary.AvgLatency = totalLatency / time.Duration(len(history))
+	summary.P95Latency = latencies[nearestRank(len(latencies), 0.95)]
+	summary.MeanErrorRate = totalErrors / float64(len(history))
+	return summary
+}
+
+// nearestRank returns the index of the p-th percentile in n sorted samples
+func nearestRank(n int, p float64) int {
+	rank := int(math.Ceil(p * float64(n)))
+	if rank < 1 {
+		rank = 1
+	}
+	return rank - 1
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for WithCapacityClock
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestMetricHistoryWindowing(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	acm := NewAdaptiveCapacityManager("node-1", WithCapacityClock(clock.Now))
+	start := clock.Now()
+	for i := 0; i < 10; i++ {
+		acm.RecordMetrics(NetworkMetrics{NodeID: "node-1", Latency: time.Duration(i) * time.Millisecond})
+		clock.Advance(time.Minute)
+	}
+
+	if got := len(acm.GetMetricHistory("node-1", time.Time{})); got != 10 {
+		t.Fatalf("%d samples in the full window", got)
+	}
+	recent := acm.GetMetricHistory("node-1", start.Add(7*time.Minute))
+	if len(recent) != 3 || recent[0].Latency != 7*time.Millisecond || recent[2].Latency != 9*time.Millisecond {
+		t.Fatalf("window from minute 7 holds %+v", recent)
+	}
+	if got := acm.GetMetricHistory("node-1", clock.Now()); len(got) != 0 {
+		t.Fatalf("%d samples from the future", len(got))
+	}
+	if got := acm.GetMetricHistory("unknown", time.Time{}); len(got) != 0 {
+		t.Fatal("history for an unknown node")
+	}
+
+	latest, ok := acm.GetLatestMetrics("node-1")
+	if !ok || latest.Latency != 9*time.Millisecond || !latest.Timestamp.Equal(start.Add(9*time.Minute)) {
+		t.Fatalf("latest metrics %+v, %v", latest, ok)
+	}
+	if _, ok := acm.GetLatestMetrics("unknown"); ok {
+		t.Fatal("latest metrics for an unknown node")
+	}
+
+	// The stored window is bounded by the history limit
+	for i := 0; i < 150; i++ {
+		acm.RecordMetrics(NetworkMetrics{NodeID: "node-1"})
+	}
+	if got := len(acm.GetMetricHistory("node-1", time.Time{})); got != 100 {
+		t.Fatalf("%d samples stored, limit 100", got)
+	}
+}
+
+func TestMetricHistoryReturnsCopies(t *testing.T) {
+	acm := NewAdaptiveCapacityManager("node-1")
+	clock := NewVectorClock()
+	clock.Update("peer")
+	acm.RecordMetrics(NetworkMetrics{NodeID: "node-1", Latency: time.Millisecond, VectorClock: clock})
+
+	history := acm.GetMetricHistory("node-1", time.Time{})
+	history[0].Latency = time.Hour
+	history[0].VectorClock.Update("peer")
+	latest, _ := acm.GetLatestMetrics("node-1")
+	latest.VectorClock.Update("peer")
+
+	stored, _ := acm.GetLatestMetrics("node-1")
+	if stored.Latency != time.Millisecond || stored.VectorClock.Get("peer") != 1 {
+		t.Fatalf("callers mutated the stored history: %v, clock %d", stored.Latency, stored.VectorClock.Get("peer"))
+	}
+}
+
+func TestSummarizeNodePercentiles(t *testing.T) {
+	acm := NewAdaptiveCapacityManager("node-1")
+	// Latencies 1..20 ms recorded out of order; errors alternate 0 and 0.1
+	for _, ms := range []int{20, 3, 17, 1, 9, 12, 5, 14, 2, 19, 8, 11, 6, 16, 4, 18, 7, 13, 10, 15} {
+		errorRate := 0.0
+		if ms%2 == 0 {
+			errorRate = 0.1
+		}
+		acm.RecordMetrics(NetworkMetrics{NodeID: "node-1", Latency: time.Duration(ms) * time.Millisecond, ErrorRate: errorRate})
+	}
+	summary := acm.SummarizeNode("node-1")
+	if summary.Samples != 20 || summary.MinLatency != time.Millisecond {
+		t.Fatalf("summary %+v", summary)
+	}
+	if summary.AvgLatency != 10500*time.Microsecond {
+		t.Fatalf("average latency %v, want 10.5ms", summary.AvgLatency)
+	}
+	// Nearest rank: ceil(0.95 * 20) = 19th smallest
+	if summary.P95Latency != 19*time.Millisecond {
+		t.Fatalf("p95 latency %v, want 19ms", summary.P95Latency)
+	}
+	if summary.MeanErrorRate < 0.0499 || summary.MeanErrorRate > 0.0501 {
+		t.Fatalf("mean error rate %v, want 0.05", summary.MeanErrorRate)
+	}
+
+	for n, want := range map[int]int{1: 0, 2: 1, 10: 9, 21: 19, 100: 94} {
+		if got := nearestRank(n, 0.95); got != want {
+			t.Errorf("p95 of %d samples at index %d, want %d", n, got, want)
+		}
+	}
+	if empty := acm.SummarizeNode("unknown"); empty.Samples != 0 || empty.P95Latency != 0 {
+		t.Fatalf("summary of an unknown node %+v", empty)
+	}
+}
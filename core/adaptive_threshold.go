@@ -0,0 +1,176 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHealthyCapacity is the mean node capacity below which the network
+// is treated as degraded even if the consistency level is still Strong
+const DefaultHealthyCapacity = 50.0
+
+// ThresholdDecision is the outcome of one AdaptiveThresholdController evaluation
+type ThresholdDecision struct {
+	Level     ConsistencyLevel
+	Capacity  float64 // Mean capacity across known nodes
+	Scale     int     // Multiplier applied to the base thresholds
+	Min       int
+	Max       int
+	Changed   bool // Whether the thresholds differ from the previous decision
+	Timestamp time.Time
+}
+
+// AdaptiveThresholdController widens the shard split and merge thresholds
+// when the network degrades, so rebalancing keeps fewer, larger shards and
+// less cross-shard coordination, and restores them once it recovers
+type AdaptiveThresholdController struct {
+	shards          *ShardManager
+	orchestrator    *ConsistencyOrchestrator
+	capacity        *AdaptiveCapacityManager
+	baseMin         int
+	baseMax         int
+	healthyCapacity float64
+	onDecision      func(ThresholdDecision)
+	last            ThresholdDecision
+	stop            chan struct{}
+	mutex           sync.Mutex
+}
+
+// ThresholdControllerOption configures an AdaptiveThresholdController
+type ThresholdControllerOption func(*AdaptiveThresholdController)
+
+// WithThresholdCallback reports every decision to fn
+func WithThresholdCallback(fn func(ThresholdDecision)) ThresholdControllerOption {
+	return func(c *AdaptiveThresholdController) {
+		c.onDecision = fn
+	}
+}
+
+// WithHealthyCapacity sets the mean capacity below which the network counts as degraded
+func WithHealthyCapacity(capacity float64) ThresholdControllerOption {
+	return func(c *AdaptiveThresholdController) {
+		c.healthyCapacity = capacity
+	}
+}
+
+// NewAdaptiveThresholdController scales the manager's current thresholds;
+// capacity may be nil to rely on the consistency level alone
+func NewAdaptiveThresholdController(sm *ShardManager, co *ConsistencyOrchestrator, capacity *AdaptiveCapacityManager, opts ...ThresholdControllerOption) *AdaptiveThresholdController {
+	baseMin, baseMax := sm.Thresholds()
+	c := &AdaptiveThresholdController{
+		shards:          sm,
+		orchestrator:    co,
+		capacity:        capacity,
+		baseMin:         baseMin,
+		baseMax:         baseMax,
+		healthyCapacity: DefaultHealthyCapacity,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.last = ThresholdDecision{Level: Strong, Scale: 1, Min: baseMin, Max: baseMax}
+	return c
+}
+
+// Evaluate reads the current network conditions and applies the resulting
+// thresholds to the shard manager
+func (c *AdaptiveThresholdController) Evaluate() ThresholdDecision {
+	decision := c.decide()
+	if c.onDecision != nil {
+		c.onDecision(decision)
+	}
+	return decision
+}
+
+// decide computes and applies the thresholds for current conditions
+func (c *AdaptiveThresholdController) decide() ThresholdDecision {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	decision := ThresholdDecision{
+		Level:     c.orchestrator.Level(),
+		Capacity:  c.meanCapacity(),
+		Timestamp: time.Now(),
+	}
+
+	// Weaker consistency means slower or lossier links between shards
+	switch decision.Level {
+	case Eventual:
+		decision.Scale = 4
+	case Causal:
+		decision.Scale = 2
+	default:
+		decision.Scale = 1
+	}
+	if decision.Capacity < c.healthyCapacity {
+		decision.Scale *= 2
+	}
+	decision.Min = c.baseMin * decision.Scale
+	decision.Max = c.baseMax * decision.Scale
+	decision.Changed = decision.Min != c.last.Min || decision.Max != c.last.Max
+
+	if decision.Changed {
+		c.shards.SetThresholds(decision.Min, decision.Max)
+	}
+	c.last = decision
+	return decision
+}
+
+// meanCapacity averages the capacity of every known node, assuming a healthy
+// network when no metrics have been recorded
+func (c *AdaptiveThresholdController) meanCapacity() float64 {
+	if c.capacity == nil {
+		return c.healthyCapacity
+	}
+	view := c.capacity.GetGlobalView()
+	if len(view) == 0 {
+		return c.healthyCapacity
+	}
+	total := 0.0
+	for _, capacity := range view {
+		total += capacity
+	}
+	return total / float64(len(view))
+}
+
+// LastDecision returns the most recent decision
+func (c *AdaptiveThresholdController) LastDecision() ThresholdDecision {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.last
+}
+
+// Start evaluates conditions every interval until Stop is called
+func (c *AdaptiveThresholdController) Start(interval time.Duration) {
+	c.mutex.Lock()
+	if c.stop != nil {
+		c.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	c.stop = stop
+	c.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Evaluate()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts periodic evaluation
+func (c *AdaptiveThresholdController) Stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
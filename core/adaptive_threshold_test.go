@@ -0,0 +1,115 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLatencySpikeStopsSplitting(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	co := NewOrchestrator()
+	capacity := NewAdaptiveCapacityManager("node-1")
+	var decisions []ThresholdDecision
+	controller := NewAdaptiveThresholdController(sm, co, capacity, WithThresholdCallback(func(d ThresholdDecision) {
+		decisions = append(decisions, d)
+	}))
+
+	// Latency spike: the orchestrator degrades to Eventual and the node's
+	// capacity drops below healthy
+	co.EvaluateNetwork(400*time.Millisecond, 0.1)
+	capacity.RecordMetrics(NetworkMetrics{NodeID: "node-1", Latency: 2 * time.Second, ErrorRate: 0.3})
+	spike := controller.Evaluate()
+	if spike.Level != Eventual || spike.Scale != 8 || !spike.Changed {
+		t.Fatalf("spike decision %+v", spike)
+	}
+	if min, max := sm.Thresholds(); min != 8 || max != 24 {
+		t.Fatalf("thresholds %d/%d during the spike, want 8/24", min, max)
+	}
+
+	prev := GenesisBlock()
+	for _, data := range testLeaves(20) {
+		prev = mustGenerateBlock(t, prev, data)
+		sm.DistributeBlock(prev)
+	}
+	if err := sm.RebalanceShards(); err != nil {
+		t.Fatal(err)
+	}
+	if shards := len(sm.Shards.GetAllShards()); shards != 1 {
+		t.Fatalf("%d shards during the spike, want the blocks kept in one", shards)
+	}
+
+	// Unchanged conditions give an unchanged decision
+	if again := controller.Evaluate(); again.Changed {
+		t.Fatal("repeated evaluation reported a change")
+	}
+
+	co.EvaluateNetwork(10*time.Millisecond, 0)
+	capacity.RecordMetrics(NetworkMetrics{NodeID: "node-1", Latency: 10 * time.Millisecond})
+	recovered := controller.Evaluate()
+	if recovered.Level != Strong || recovered.Scale != 1 || !recovered.Changed {
+		t.Fatalf("recovered decision %+v", recovered)
+	}
+	for shards := 0; shards != len(sm.Shards.GetAllShards()); {
+		shards = len(sm.Shards.GetAllShards())
+		if err := sm.RebalanceShards(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, shard := range sm.Shards.GetAllShards() {
+		if len(shard.Blocks) > 3 {
+			t.Fatalf("shard #%d holds %d blocks after recovery", shard.ID, len(shard.Blocks))
+		}
+	}
+	if len(decisions) != 3 || controller.LastDecision() != recovered {
+		t.Fatalf("callback saw %d decisions", len(decisions))
+	}
+}
+
+func TestThresholdControllerCausalLevel(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(2, 5); err != nil {
+		t.Fatal(err)
+	}
+	co := NewOrchestrator()
+	controller := NewAdaptiveThresholdController(sm, co, nil)
+	co.EvaluateNetwork(150*time.Millisecond, 0)
+	if d := controller.Evaluate(); d.Level != Causal || d.Min != 4 || d.Max != 10 {
+		t.Fatalf("causal decision %+v", d)
+	}
+}
+
+func TestThresholdControllerPeriodicEvaluation(t *testing.T) {
+	sm := NewShardManager()
+	co := NewOrchestrator()
+	var mutex sync.Mutex
+	evaluated := 0
+	controller := NewAdaptiveThresholdController(sm, co, nil, WithThresholdCallback(func(ThresholdDecision) {
+		mutex.Lock()
+		evaluated++
+		mutex.Unlock()
+	}))
+	controller.Start(time.Millisecond)
+	controller.Start(time.Millisecond) // A second Start is ignored
+	co.EvaluateNetwork(time.Second, 0)
+	deadline := time.Now().Add(2 * time.Second)
+	for controller.LastDecision().Level != Eventual {
+		if time.Now().After(deadline) {
+			t.Fatal("periodic evaluation never saw the degraded network")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	controller.Stop()
+	mutex.Lock()
+	stopped := evaluated
+	mutex.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if evaluated > stopped+1 {
+		t.Fatalf("%d evaluations after Stop", evaluated-stopped)
+	}
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrOverCapacity is returned when admission control rejects a write
+// because the node is over its current capacity
+var ErrOverCapacity = errors.New("node over capacity")
+
+// DefaultOverCapacityRetry is the retry-after suggested while a node's
+// capacity is zero
+const DefaultOverCapacityRetry = time.Second
+
+// OverCapacityError reports a rejected write with how long to wait before
+// retrying; an HTTP front end would answer 429 with a Retry-After header
+type OverCapacityError struct {
+	NodeID     string
+	RetryAfter time.Duration
+}
+
+func (e *OverCapacityError) Error() string {
+	return fmt.Sprintf("%v: node %s, retry after %v", ErrOverCapacity, e.NodeID, e.RetryAfter)
+}
+
+// Is lets errors.Is match OverCapacityError against ErrOverCapacity
+func (e *OverCapacityError) Is(target error) bool {
+	return target == ErrOverCapacity
+}
+
+// AdmissionController gates block and transaction ingestion with a token
+// bucket refilled at the local node's capacity, in admissions per second,
+// as computed by an AdaptiveCapacityManager. The rate follows capacity:
+// time up to each admission is credited at the rate in force before it,
+// after which the rate is refreshed from the latest RecordMetrics.
+type AdmissionController struct {
+	chain    *Blockchain // Optional; target of AddBlock
+	mempool  *Mempool    // Optional; target of Submit
+	capacity *AdaptiveCapacityManager
+	nodeID   string
+	burst    float64 // Bucket size in seconds of capacity
+	maxRate  float64 // Cap on the rate, zero for none
+	now      func() time.Time
+	tokens   float64
+	rate     float64 // Tokens per second at the last refill
+	last     time.Time
+	writes   sync.Mutex // Serializes chain writes
+	mutex    sync.Mutex
+}
+
+// AdmissionOption configures an AdmissionController
+type AdmissionOption func(*AdmissionController)
+
+// WithAdmissionClock replaces time.Now for refilling the bucket
+func WithAdmissionClock(now func() time.Time) AdmissionOption {
+	return func(ac *AdmissionController) {
+		ac.now = now
+	}
+}
+
+// WithAdmissionBurst sizes the bucket to seconds of capacity, at least one
+// admission; the default is one second
+func WithAdmissionBurst(seconds float64) AdmissionOption {
+	return func(ac *AdmissionController) {
+		ac.burst = seconds
+	}
+}
+
+// WithMaxAdmissionRate caps admissions per second below the node's
+// capacity, e.g. to bound how many blocks a second a chain takes
+func WithMaxAdmissionRate(perSecond float64) AdmissionOption {
+	return func(ac *AdmissionController) {
+		ac.maxRate = perSecond
+	}
+}
+
+// NewAdmissionController admits writes to chain and mempool, either of
+// which may be nil, at the capacity capacity reports for nodeID. The
+// bucket starts full.
+func NewAdmissionController(chain *Blockchain, mempool *Mempool, capacity *AdaptiveCapacityManager, nodeID string, opts ...AdmissionOption) *AdmissionController {
+	ac := &AdmissionController{chain: chain, mempool: mempool, capacity: capacity, nodeID: nodeID, burst: 1, now: time.Now}
+	for _, opt := range opts {
+		opt(ac)
+	}
+	ac.rate = ac.currentRate()
+	ac.tokens = ac.size()
+	ac.last = ac.now()
+	return ac
+}
+
+// size returns the bucket size at the current rate
+func (ac *AdmissionController) size() float64 {
+	return math.Max(ac.rate*ac.burst, 1)
+}
+
+// refill credits the time since the last refill at the previous rate and
+// then refreshes the rate; the caller must hold the mutex
+func (ac *AdmissionController) refill() {
+	now := ac.now()
+	if elapsed := now.Sub(ac.last).Seconds(); elapsed > 0 {
+		ac.tokens += elapsed * ac.rate
+		ac.last = now
+	}
+	ac.rate = ac.currentRate()
+	ac.tokens = math.Min(ac.tokens, ac.size())
+}
+
+// currentRate returns the node's capacity, capped at maxRate if set
+func (ac *AdmissionController) currentRate() float64 {
+	rate := ac.capacity.GetNodeCapacity(ac.nodeID)
+	if ac.maxRate > 0 {
+		rate = math.Min(rate, ac.maxRate)
+	}
+	return rate
+}
+
+// Admit takes one token, or returns an OverCapacityError suggesting when
+// one will be available
+func (ac *AdmissionController) Admit() error {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	ac.refill()
+	if ac.tokens >= 1 {
+		ac.tokens--
+		return nil
+	}
+	retry := DefaultOverCapacityRetry
+	if ac.rate > 0 {
+		retry = time.Duration(math.Ceil((1 - ac.tokens) / ac.rate * float64(time.Second)))
+	}
+	return &OverCapacityError{NodeID: ac.nodeID, RetryAfter: retry}
+}
+
+// Available returns the tokens currently in the bucket
+func (ac *AdmissionController) Available() float64 {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	ac.refill()
+	return ac.tokens
+}
+
+// AddBlock admits and appends a data block to the chain
+func (ac *AdmissionController) AddBlock(data string) error {
+	if ac.chain == nil {
+		return errors.New("admission controller has no chain")
+	}
+	if err := ac.Admit(); err != nil {
+		return err
+	}
+	ac.writes.Lock()
+	defer ac.writes.Unlock()
+	return ac.chain.AddBlock(data)
+}
+
+// Submit admits a transaction into the mempool
+func (ac *AdmissionController) Submit(tx Transaction) error {
+	if ac.mempool == nil {
+		return errors.New("admission controller has no mempool")
+	}
+	if err := ac.Admit(); err != nil {
+		return err
+	}
+	return ac.mempool.Submit(tx)
+}
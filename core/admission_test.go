@@ -0,0 +1,145 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// admissionSetup returns a controller over a fresh chain admitting at the
+// capacity of node-a, 100 a second until metrics say otherwise
+func admissionSetup(opts ...AdmissionOption) (*AdmissionController, *AdaptiveCapacityManager, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	acm := NewAdaptiveCapacityManager("node-a", WithCapacityClock(clock.Now))
+	opts = append([]AdmissionOption{WithAdmissionClock(clock.Now)}, opts...)
+	return NewAdmissionController(NewBlockchain(), NewMempool(1000), acm, "node-a", opts...), acm, clock
+}
+
+// admitN admits n writes, failing the test if any is rejected
+func admitN(t *testing.T, ac *AdmissionController, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ac.Admit(); err != nil {
+			t.Fatalf("admission %d of %d: %v", i+1, n, err)
+		}
+	}
+}
+
+// checkRejected fails unless the next admission is rejected with retry
+func checkRejected(t *testing.T, ac *AdmissionController, retry time.Duration) {
+	t.Helper()
+	err := ac.Admit()
+	var over *OverCapacityError
+	if !errors.Is(err, ErrOverCapacity) || !errors.As(err, &over) {
+		t.Fatalf("admission over capacity: %v", err)
+	}
+	if over.NodeID != "node-a" || over.RetryAfter != retry {
+		t.Fatalf("rejected for %s with retry after %v, want %v", over.NodeID, over.RetryAfter, retry)
+	}
+}
+
+func TestAdmissionRateFollowsCapacity(t *testing.T) {
+	ac, acm, clock := admissionSetup()
+
+	// The bucket starts with a second of capacity
+	admitN(t, ac, 100)
+	checkRejected(t, ac, 10*time.Millisecond)
+
+	// Errors degrade capacity to 10 a second. The 100ms before the metrics
+	// is credited at the old rate, but the bucket shrinks to the new size.
+	acm.RecordMetrics(NetworkMetrics{NodeID: "node-a", ErrorRate: 0.45})
+	clock.Advance(100 * time.Millisecond)
+	if got := ac.Available(); got != 10 {
+		t.Fatalf("%v tokens after degrading, want 10", got)
+	}
+	admitN(t, ac, 10)
+	checkRejected(t, ac, 100*time.Millisecond)
+	clock.Advance(time.Second)
+	admitN(t, ac, 10)
+	checkRejected(t, ac, 100*time.Millisecond)
+
+	// Recovery restores the rate from the next refill on
+	acm.RecordMetrics(NetworkMetrics{NodeID: "node-a"})
+	if got := ac.Available(); got != 0 {
+		t.Fatalf("%v tokens right after recovering", got)
+	}
+	clock.Advance(500 * time.Millisecond)
+	admitN(t, ac, 50)
+	checkRejected(t, ac, 10*time.Millisecond)
+
+	// At zero capacity the bucket holds one token and never refills
+	acm.RecordMetrics(NetworkMetrics{NodeID: "node-a", ErrorRate: 0.6})
+	clock.Advance(time.Hour)
+	admitN(t, ac, 1)
+	clock.Advance(time.Hour)
+	checkRejected(t, ac, DefaultOverCapacityRetry)
+}
+
+func TestAdmissionBurstAndMaxRate(t *testing.T) {
+	ac, _, clock := admissionSetup(WithAdmissionBurst(0.5), WithMaxAdmissionRate(20))
+	admitN(t, ac, 10)
+	checkRejected(t, ac, 50*time.Millisecond)
+	clock.Advance(time.Minute)
+	if got := ac.Available(); got != 10 {
+		t.Fatalf("bucket of half a second at 20 a second holds %v", got)
+	}
+}
+
+func TestAdmissionWrapsWrites(t *testing.T) {
+	ac, _, _ := admissionSetup(WithMaxAdmissionRate(2), WithAdmissionBurst(1))
+	if err := ac.AddBlock("admitted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.Submit(Transaction{From: "alice", To: "bob", Amount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.AddBlock("rejected"); !errors.Is(err, ErrOverCapacity) {
+		t.Fatalf("block over capacity: %v", err)
+	}
+	if len(ac.chain.Blocks) != 2 || ac.mempool.Size() != 1 {
+		t.Fatalf("chain of %d blocks, mempool of %d", len(ac.chain.Blocks), ac.mempool.Size())
+	}
+
+	acm := NewAdaptiveCapacityManager("node-a")
+	if err := NewAdmissionController(nil, nil, acm, "node-a").AddBlock("x"); err == nil {
+		t.Fatal("block added without a chain")
+	}
+	if err := NewAdmissionController(nil, nil, acm, "node-a").Submit(Transaction{}); err == nil {
+		t.Fatal("transaction submitted without a mempool")
+	}
+}
+
+func TestAdmissionNeverOverAdmits(t *testing.T) {
+	ac, _, _ := admissionSetup()
+	const workers, attempts = 32, 20
+
+	var admitted int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < attempts; i++ {
+				err := ac.AddBlock("concurrent")
+				switch {
+				case err == nil:
+					atomic.AddInt64(&admitted, 1)
+				case !errors.Is(err, ErrOverCapacity):
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The clock never moved, so exactly the initial bucket is admitted
+	if admitted != 100 {
+		t.Fatalf("admitted %d of %d writes with 100 tokens", admitted, workers*attempts)
+	}
+	if len(ac.chain.Blocks) != 101 {
+		t.Fatalf("chain holds %d blocks after 100 admissions", len(ac.chain.Blocks))
+	}
+}
@@ -0,0 +1,201 @@
+package core
+
+import "sort"
+
+// AffinityPolicy places blocks by their affinity key: a block goes to a
+// shard already holding its key if one has room, or failing that if one
+// also holds other keys, so that splitting it makes room; otherwise to the
+// least loaded shard with room, as do blocks without a key. Oversized shards are
+// split between affinity groups rather than at their midpoint, so a key's
+// blocks stay together unless the group alone outgrows a shard.
+const AffinityPolicy SplitStrategy = "affinity"
+
+// ShardsForAffinity returns the IDs of the shards holding blocks with the
+// given affinity key, in ascending order, as recorded by placement, splits
+// and merges; blocks moved by transfers are not tracked. Keys spread over
+// several shards when their first shard filled up.
+func (sm *ShardManager) ShardsForAffinity(key string) []int {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	var ids []int
+	for _, id := range sm.affinity[key] {
+		if _, exists := sm.Shards.FindShard(id); exists {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// affinityShard returns the shard a block is distributed to under
+// AffinityPolicy; the caller must hold the manager lock
+func (sm *ShardManager) affinityShard(block Block) *Shard {
+	if block.Affinity != "" {
+		ids := sm.affinity[block.Affinity]
+		var shared *Shard
+		for i := len(ids) - 1; i >= 0; i-- {
+			shard, exists := sm.Shards.FindShard(ids[i])
+			if !exists {
+				continue
+			}
+			if shard.blockCount() < sm.maxBlocks {
+				return shard
+			}
+			if shared == nil && shard.holdsOtherAffinity(block.Affinity) {
+				shared = shard
+			}
+		}
+		if shared != nil {
+			return shared
+		}
+	}
+
+	// Capacity-aware fallback: the shard with the most room, or the last
+	// shard if all are full so that rebalancing splits it
+	shards := sm.Shards.GetAllShards()
+	best, fewest := shards[len(shards)-1], sm.maxBlocks
+	for _, shard := range shards {
+		if count := shard.blockCount(); count < fewest {
+			best, fewest = shard, count
+		}
+	}
+	return best
+}
+
+// recordAffinity notes that a shard holds blocks with the given key; the
+// caller must hold the manager lock
+func (sm *ShardManager) recordAffinity(key string, shardID int) {
+	if key == "" {
+		return
+	}
+	if sm.affinity == nil {
+		sm.affinity = make(map[string][]int)
+	}
+	for _, id := range sm.affinity[key] {
+		if id == shardID {
+			return
+		}
+	}
+	sm.affinity[key] = append(sm.affinity[key], shardID)
+}
+
+// moveAffinity updates the mapping after a split moved right out of the
+// shard fromID, keeping left, into the new shard toID; the caller must hold
+// the manager lock
+func (sm *ShardManager) moveAffinity(left, right []Block, fromID, toID int) {
+	kept := make(map[string]bool)
+	for _, b := range left {
+		kept[b.Affinity] = true
+	}
+	for _, b := range right {
+		if b.Affinity == "" {
+			continue
+		}
+		if !kept[b.Affinity] {
+			sm.forgetAffinity(b.Affinity, fromID)
+		}
+		sm.recordAffinity(b.Affinity, toID)
+	}
+}
+
+// mergeAffinity points keys held by the shard fromID at the shard toID it
+// was merged into; the caller must hold the manager lock
+func (sm *ShardManager) mergeAffinity(fromID, toID int) {
+	for key, ids := range sm.affinity {
+		for _, id := range ids {
+			if id == fromID {
+				sm.forgetAffinity(key, fromID)
+				sm.recordAffinity(key, toID)
+				break
+			}
+		}
+	}
+}
+
+// forgetAffinity removes a shard from a key's mapping
+func (sm *ShardManager) forgetAffinity(key string, shardID int) {
+	ids := sm.affinity[key]
+	for i, id := range ids {
+		if id == shardID {
+			sm.affinity[key] = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(sm.affinity[key]) == 0 {
+		delete(sm.affinity, key)
+	}
+}
+
+// splitByAffinity divides an oversized shard's blocks into the blocks kept
+// and the blocks moved to a new shard. Whole affinity groups, most recently
+// started first, move while they fit in half the blocks, then while the
+// kept side is above max; blocks without a key are groups of one. Both
+// sides keep the shard's block order. If no such split leaves both sides
+// non-empty and at most max, it falls back to the midpoint.
+func splitByAffinity(blocks []Block, max int) ([]Block, []Block) {
+	var order []string
+	sizes := make(map[string]int)
+	groupOf := func(i int) string {
+		if blocks[i].Affinity == "" {
+			return "\x00" + blocks[i].Hash
+		}
+		return blocks[i].Affinity
+	}
+	for i := range blocks {
+		group := groupOf(i)
+		if sizes[group] == 0 {
+			order = append(order, group)
+		}
+		sizes[group]++
+	}
+
+	target := len(blocks) / 2
+	moved := make(map[string]bool)
+	count := 0
+	move := func(limit int, more func() bool) {
+		for i := len(order) - 1; i >= 0 && more(); i-- {
+			if size := sizes[order[i]]; !moved[order[i]] && count+size <= limit {
+				moved[order[i]] = true
+				count += size
+			}
+		}
+	}
+	move(target, func() bool { return count < target })
+	move(max, func() bool { return len(blocks)-count > max })
+	if count == 0 || len(blocks)-count > max {
+		mid := len(blocks) / 2
+		return blocks[:mid:mid], blocks[mid:]
+	}
+
+	left := make([]Block, 0, len(blocks)-count)
+	right := make([]Block, 0, count)
+	for i, b := range blocks {
+		if moved[groupOf(i)] {
+			right = append(right, b)
+		} else {
+			left = append(left, b)
+		}
+	}
+	return left, right
+}
+
+// holdsOtherAffinity reports whether the shard has blocks without the
+// given affinity key
+func (s *Shard) holdsOtherAffinity(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, b := range s.Blocks {
+		if b.Affinity != key {
+			return true
+		}
+	}
+	return false
+}
+
+// blockCount returns the number of blocks in the shard
+func (s *Shard) blockCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.Blocks)
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// affinityManager distributes blocks with the given affinity keys, in
+// order, to an AffinityPolicy manager splitting shards above max blocks
+func affinityManager(t *testing.T, max int, keys ...string) (*ShardManager, []Block) {
+	t.Helper()
+	bc := NewBlockchain()
+	for i, key := range keys {
+		if err := bc.AddBlockWithAffinity(fmt.Sprintf("block-%d", i), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sm := NewShardManager(WithSplitStrategy(AffinityPolicy))
+	if err := sm.SetThresholds(1, max); err != nil {
+		t.Fatal(err)
+	}
+	blocks := bc.Blocks[1:]
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+	return sm, blocks
+}
+
+// holdingAffinity returns the IDs of the shards actually holding blocks
+// with the key, in ascending order
+func holdingAffinity(sm *ShardManager, key string) []int {
+	var ids []int
+	for _, view := range sm.GetAllShardViews() {
+		for _, b := range view.Blocks {
+			if b.Affinity == key {
+				ids = append(ids, view.ID)
+				break
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// checkAffinity fails unless ShardsForAffinity agrees with the layout for
+// every key, and each key in together is held by a single shard
+func checkAffinity(t *testing.T, sm *ShardManager, keys []string, together ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if got, want := sm.ShardsForAffinity(key), holdingAffinity(sm, key); !reflect.DeepEqual(got, want) {
+			t.Fatalf("ShardsForAffinity(%q) = %v, blocks held by %v", key, got, want)
+		}
+	}
+	for _, key := range together {
+		if ids := holdingAffinity(sm, key); len(ids) != 1 {
+			t.Fatalf("affinity group %q spread over shards %v", key, ids)
+		}
+	}
+}
+
+func TestAffinityCannotAbsorbLaterFields(t *testing.T) {
+	b := fixedBlocks(1)[0]
+	relabeled := b
+	b.Affinity, b.Priority = "k", PriorityHigh
+	relabeled.Affinity = "kpriority:high"
+	if calculateHash(b) == calculateHash(relabeled) {
+		t.Fatal("moving the priority into the affinity key kept the block hash")
+	}
+}
+
+func TestAffinityPolicyColocatesGroups(t *testing.T) {
+	// Interleaved tenants, with blocks without a key between them
+	keys := []string{"a", "b", "", "c", "a", "b", "c", "", "a", "b", "c", "a", "", "b", "c"}
+	sm, blocks := affinityManager(t, 8, keys...)
+	if sm.Shards.Size() < 2 {
+		t.Fatalf("%d blocks held in %d shard", len(blocks), sm.Shards.Size())
+	}
+	checkAffinity(t, sm, []string{"a", "b", "c"}, "a", "b", "c")
+	if ids := sm.ShardsForAffinity("unknown"); ids != nil {
+		t.Fatalf("unknown key held by %v", ids)
+	}
+}
+
+func TestAffinityRebalanceKeepsGroupsTogether(t *testing.T) {
+	keys := []string{"a", "a", "a", "b", "b", "b", "b", "c", "c", "c"}
+	sm, _ := affinityManager(t, len(keys), keys...)
+	if sm.Shards.Size() != 1 {
+		t.Fatalf("%d shards before rebalancing", sm.Shards.Size())
+	}
+
+	// The midpoint falls inside group b; the affinity split moves a and c
+	if err := sm.SetThresholds(1, 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.RebalanceShards(); err != nil {
+		t.Fatal(err)
+	}
+	if sm.Shards.Size() != 2 {
+		t.Fatalf("%d shards after rebalancing", sm.Shards.Size())
+	}
+	checkAffinity(t, sm, []string{"a", "b", "c"}, "a", "b", "c")
+	if reflect.DeepEqual(holdingAffinity(sm, "a"), holdingAffinity(sm, "b")) {
+		t.Fatal("groups a and b still share a shard")
+	}
+
+	// Merging brings the groups back into one shard
+	if err := sm.MergeShards(len(keys)); err != nil {
+		t.Fatal(err)
+	}
+	if sm.Shards.Size() != 1 {
+		t.Fatalf("%d shards after merging", sm.Shards.Size())
+	}
+	checkAffinity(t, sm, []string{"a", "b", "c"}, "a", "b", "c")
+}
+
+func TestAffinityGroupOutgrowingShardSpans(t *testing.T) {
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = "big"
+	}
+	keys = append(keys, "small", "small")
+	sm, _ := affinityManager(t, 4, keys...)
+	if ids := sm.ShardsForAffinity("big"); len(ids) < 3 {
+		t.Fatalf("10 blocks of one key in shards %v of at most 4", ids)
+	}
+	checkAffinity(t, sm, []string{"big", "small"}, "small")
+}
+
+func TestSplitByAffinity(t *testing.T) {
+	group := func(keys ...string) []Block {
+		blocks := make([]Block, len(keys))
+		for i, key := range keys {
+			blocks[i] = Block{Index: i, Hash: fmt.Sprint(i), Affinity: key}
+		}
+		return blocks
+	}
+	affinities := func(blocks []Block) string {
+		s := ""
+		for _, b := range blocks {
+			if b.Affinity == "" {
+				s += "-"
+			}
+			s += b.Affinity
+		}
+		return s
+	}
+	for _, tc := range []struct {
+		keys        []string
+		max         int
+		left, right string
+	}{
+		{[]string{"a", "a", "a", "b", "b", "b", "b", "c", "c", "c"}, 6, "bbbb", "aaaccc"},
+		{[]string{"a", "b", "a", "b"}, 3, "aa", "bb"},
+		{[]string{"a", "", "", "a"}, 3, "aa", "--"},
+		// A single group cannot be kept together, so the midpoint is used
+		{[]string{"a", "a", "a", "a"}, 3, "aa", "aa"},
+	} {
+		left, right := splitByAffinity(group(tc.keys...), tc.max)
+		if affinities(left) != tc.left || affinities(right) != tc.right {
+			t.Errorf("split of %v under %d: %q and %q, want %q and %q", tc.keys, tc.max,
+				affinities(left), affinities(right), tc.left, tc.right)
+		}
+	}
+}
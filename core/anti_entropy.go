@@ -0,0 +1,245 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Errors returned by anti-entropy synchronization
+var (
+	ErrSyncMismatch = errors.New("peer data does not match its tree")
+	ErrShardChanged = errors.New("shard changed during sync")
+)
+
+// TreeSummaryRequest asks a peer for its copy's root and shape
+type TreeSummaryRequest struct {
+	ShardID int `json:"shard_id"`
+}
+
+// TreeSummary describes a peer's copy of a shard
+type TreeSummary struct {
+	ShardID   int    `json:"shard_id"`
+	Root      string `json:"root"`
+	LeafCount int    `json:"leaf_count"`
+	Levels    int    `json:"levels"` // Tree levels including the leaves, 0 if empty
+}
+
+// NodeHashRequest asks for the hashes of some nodes of one tree level
+type NodeHashRequest struct {
+	ShardID int   `json:"shard_id"`
+	Level   int   `json:"level"`
+	Indices []int `json:"indices"`
+}
+
+// NodeHashResponse carries the requested hashes in request order, with ""
+// for nodes the peer's tree does not have
+type NodeHashResponse struct {
+	ShardID int      `json:"shard_id"`
+	Level   int      `json:"level"`
+	Hashes  []string `json:"hashes"`
+}
+
+// BlockFetchRequest asks for the blocks at some leaf indices
+type BlockFetchRequest struct {
+	ShardID int   `json:"shard_id"`
+	Indices []int `json:"indices"`
+}
+
+// BlockFetchResponse carries the requested blocks in request order
+type BlockFetchResponse struct {
+	ShardID int     `json:"shard_id"`
+	Blocks  []Block `json:"blocks"`
+}
+
+// ShardPeer serves a copy of a shard to anti-entropy sync, in process or
+// over the network
+type ShardPeer interface {
+	TreeSummary(req TreeSummaryRequest) (TreeSummary, error)
+	NodeHashes(req NodeHashRequest) (NodeHashResponse, error)
+	FetchBlocks(req BlockFetchRequest) (BlockFetchResponse, error)
+}
+
+// LocalShardPeer serves an in-process shard, caching its tree levels until
+// the shard changes
+type LocalShardPeer struct {
+	shard   *Shard
+	version uint64
+	levels  [][]string
+	mutex   sync.Mutex
+}
+
+// NewLocalShardPeer serves shard as a peer
+func NewLocalShardPeer(shard *Shard) *LocalShardPeer {
+	return &LocalShardPeer{shard: shard}
+}
+
+// treeLevels returns the shard's tree levels, rebuilding them after changes
+func (p *LocalShardPeer) treeLevels(shardID int) ([][]string, error) {
+	if shardID != p.shard.ID {
+		return nil, fmt.Errorf("%w: #%d", ErrShardNotFound, shardID)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.shard.mutex.Lock()
+	defer p.shard.mutex.Unlock()
+	if p.levels == nil || p.version != p.shard.version {
+		p.levels = shardTree(p.shard).levels()
+		p.version = p.shard.version
+	}
+	return p.levels, nil
+}
+
+// TreeSummary implements ShardPeer
+func (p *LocalShardPeer) TreeSummary(req TreeSummaryRequest) (TreeSummary, error) {
+	levels, err := p.treeLevels(req.ShardID)
+	if err != nil {
+		return TreeSummary{}, err
+	}
+	summary := TreeSummary{ShardID: req.ShardID, Root: NewMerkleTree(nil).GetRootHash(), Levels: len(levels)}
+	if len(levels) > 0 {
+		summary.Root = levels[len(levels)-1][0]
+		summary.LeafCount = len(levels[0])
+	}
+	return summary, nil
+}
+
+// NodeHashes implements ShardPeer
+func (p *LocalShardPeer) NodeHashes(req NodeHashRequest) (NodeHashResponse, error) {
+	levels, err := p.treeLevels(req.ShardID)
+	if err != nil {
+		return NodeHashResponse{}, err
+	}
+	return NodeHashResponse{ShardID: req.ShardID, Level: req.Level, Hashes: nodeHashes(levels, req.Level, req.Indices)}, nil
+}
+
+// FetchBlocks implements ShardPeer
+func (p *LocalShardPeer) FetchBlocks(req BlockFetchRequest) (BlockFetchResponse, error) {
+	if req.ShardID != p.shard.ID {
+		return BlockFetchResponse{}, fmt.Errorf("%w: #%d", ErrShardNotFound, req.ShardID)
+	}
+	p.shard.mutex.Lock()
+	defer p.shard.mutex.Unlock()
+	resp := BlockFetchResponse{ShardID: req.ShardID, Blocks: make([]Block, len(req.Indices))}
+	for i, index := range req.Indices {
+		b, err := p.shard.fullBlock(index)
+		if err != nil {
+			return BlockFetchResponse{}, err
+		}
+		resp.Blocks[i] = b
+	}
+	return resp, nil
+}
+
+// shardTree returns the shard's tree, building one if it has none; the
+// caller must hold the shard mutex
+func shardTree(s *Shard) *MerkleTree {
+	if s.Tree == nil {
+		return newBlockTree(s.Blocks)
+	}
+	return s.Tree
+}
+
+// AntiEntropyStats reports the work done by one sync
+type AntiEntropyStats struct {
+	Rounds            int `json:"rounds"`             // Requests sent to the peer
+	Comparisons       int `json:"comparisons"`        // Node hashes compared
+	BlocksTransferred int `json:"blocks_transferred"` // Blocks fetched from the peer
+}
+
+// ShardSyncer brings a local shard copy in line with a peer's by walking
+// both Merkle trees top-down and fetching only the blocks under differing
+// leaves
+type ShardSyncer struct {
+	local *Shard
+	peer  ShardPeer
+}
+
+// NewShardSyncer syncs local from peer
+func NewShardSyncer(local *Shard, peer ShardPeer) *ShardSyncer {
+	return &ShardSyncer{local: local, peer: peer}
+}
+
+// Sync makes the local shard identical to the peer's copy. Fetched blocks
+// are checked against the peer's leaf hashes and the result against its
+// root; the local shard is left unchanged on error.
+func (s *ShardSyncer) Sync() (AntiEntropyStats, error) {
+	var stats AntiEntropyStats
+	summary, err := s.peer.TreeSummary(TreeSummaryRequest{ShardID: s.local.ID})
+	stats.Rounds++
+	if err != nil {
+		return stats, err
+	}
+
+	s.local.mutex.Lock()
+	tree := shardTree(s.local)
+	local, version := tree.levels(), s.local.version
+	s.local.mutex.Unlock()
+	if tree.GetRootHash() == summary.Root && len(tree.Leaves) == summary.LeafCount {
+		return stats, nil
+	}
+
+	// Walk the trees, remembering the peer's leaf hashes
+	var remoteLeaves map[int]string
+	differing, comparisons, err := walkDiff(local, func(level int, indices []int) ([]string, error) {
+		resp, err := s.peer.NodeHashes(NodeHashRequest{ShardID: s.local.ID, Level: level, Indices: indices})
+		stats.Rounds++
+		if err != nil {
+			return nil, err
+		}
+		if level == 0 && len(resp.Hashes) == len(indices) {
+			remoteLeaves = make(map[int]string, len(indices))
+			for i, index := range indices {
+				remoteLeaves[index] = resp.Hashes[i]
+			}
+		}
+		return resp.Hashes, nil
+	}, summary.Levels)
+	stats.Comparisons = comparisons
+	if err != nil {
+		return stats, err
+	}
+
+	var wanted []int
+	for _, index := range differing {
+		if index < summary.LeafCount {
+			wanted = append(wanted, index)
+		}
+	}
+	fetched := make(map[int]Block, len(wanted))
+	if len(wanted) > 0 {
+		resp, err := s.peer.FetchBlocks(BlockFetchRequest{ShardID: s.local.ID, Indices: wanted})
+		stats.Rounds++
+		if err != nil {
+			return stats, err
+		}
+		if len(resp.Blocks) != len(wanted) {
+			return stats, fmt.Errorf("%w: %d blocks for %d indices", ErrSyncMismatch, len(resp.Blocks), len(wanted))
+		}
+		for i, index := range wanted {
+			b := resp.Blocks[i]
+			if b.ComputeHash() != b.Hash || blockLeaf(b) != remoteLeaves[index] {
+				return stats, fmt.Errorf("%w: block at index %d", ErrSyncMismatch, index)
+			}
+			fetched[index] = b
+		}
+		stats.BlocksTransferred = len(wanted)
+	}
+
+	s.local.mutex.Lock()
+	defer s.local.mutex.Unlock()
+	if s.local.version != version {
+		return stats, fmt.Errorf("%w: shard #%d", ErrShardChanged, s.local.ID)
+	}
+	blocks := make([]Block, summary.LeafCount)
+	copy(blocks, s.local.Blocks)
+	for index, b := range fetched {
+		blocks[index] = s.local.stripBody(b)
+	}
+	if root := newBlockTree(blocks).GetRootHash(); root != summary.Root {
+		return stats, fmt.Errorf("%w: synced root %s, peer has %s", ErrSyncMismatch, root, summary.Root)
+	}
+	s.local.Blocks = blocks
+	s.local.rebuild()
+	return stats, nil
+}
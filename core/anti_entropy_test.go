@@ -0,0 +1,150 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"testing"
+)
+
+// shardOf returns shard 0 holding blocks, built in one pass
+func shardOf(blocks []Block) *Shard {
+	shard := NewShard(0)
+	shard.Blocks = append([]Block{}, blocks...)
+	shard.rebuild()
+	return shard
+}
+
+// alteredBlocks returns a copy of blocks with new data at the given indices
+func alteredBlocks(blocks []Block, indices ...int) []Block {
+	altered := append([]Block{}, blocks...)
+	for _, i := range indices {
+		altered[i].Data += "-altered"
+		altered[i].Hash = calculateHash(altered[i])
+	}
+	return altered
+}
+
+// jsonPeer passes every request and response through JSON, as the p2p
+// layer would
+type jsonPeer struct {
+	peer ShardPeer
+}
+
+func (p jsonPeer) TreeSummary(req TreeSummaryRequest) (TreeSummary, error) {
+	var resp TreeSummary
+	return resp, roundTrip(req, &req, func() (any, error) { return p.peer.TreeSummary(req) }, &resp)
+}
+
+func (p jsonPeer) NodeHashes(req NodeHashRequest) (NodeHashResponse, error) {
+	var resp NodeHashResponse
+	return resp, roundTrip(req, &req, func() (any, error) { return p.peer.NodeHashes(req) }, &resp)
+}
+
+func (p jsonPeer) FetchBlocks(req BlockFetchRequest) (BlockFetchResponse, error) {
+	var resp BlockFetchResponse
+	return resp, roundTrip(req, &req, func() (any, error) { return p.peer.FetchBlocks(req) }, &resp)
+}
+
+// roundTrip decodes req into decoded, serves it and decodes the response
+// into resp
+func roundTrip(req, decoded any, serve func() (any, error), resp any) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return err
+	}
+	served, err := serve()
+	if err != nil {
+		return err
+	}
+	if data, err = json.Marshal(served); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, resp)
+}
+
+// tamperingPeer serves altered blocks while reporting the honest tree
+type tamperingPeer struct {
+	*LocalShardPeer
+}
+
+func (p tamperingPeer) FetchBlocks(req BlockFetchRequest) (BlockFetchResponse, error) {
+	resp, err := p.LocalShardPeer.FetchBlocks(req)
+	if err == nil && len(resp.Blocks) > 0 {
+		resp.Blocks[0].Data = "tampered"
+		resp.Blocks[0].Hash = calculateHash(resp.Blocks[0])
+	}
+	return resp, err
+}
+
+func TestShardSyncTransfersOnlyDifferingBlocks(t *testing.T) {
+	const n = 10000
+	blocks := fixedBlocks(n)
+	differing := []int{17, 5000, 9998}
+	local, remote := shardOf(alteredBlocks(blocks, differing...)), shardOf(blocks)
+
+	if diff := local.Tree.Diff(remote.Tree); len(diff) != 3 || diff[0] != 17 || diff[1] != 5000 || diff[2] != 9998 {
+		t.Fatalf("diff %v", diff)
+	}
+	stats, err := NewShardSyncer(local, jsonPeer{NewLocalShardPeer(remote)}).Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.BlocksTransferred != 3 {
+		t.Fatalf("transferred %d blocks", stats.BlocksTransferred)
+	}
+	// Each differing leaf costs at most two comparisons per level
+	levels := bits.Len(uint(n - 1))
+	if limit := 1 + 2*len(differing)*levels; stats.Comparisons > limit {
+		t.Fatalf("%d comparisons for %d differing of %d leaves, want at most %d", stats.Comparisons, len(differing), n, limit)
+	}
+	// A summary, one request per tree level and one block fetch
+	if stats.Rounds != 1+(levels+1)+1 {
+		t.Fatalf("%d rounds for a %d-level tree", stats.Rounds, levels+1)
+	}
+	if local.GetRoot() != remote.GetRoot() {
+		t.Fatal("roots differ after sync")
+	}
+
+	// In-sync copies compare roots only
+	stats, err = NewShardSyncer(local, NewLocalShardPeer(remote)).Sync()
+	if err != nil || stats.Rounds != 1 || stats.Comparisons != 0 || stats.BlocksTransferred != 0 {
+		t.Fatalf("second sync %+v: %v", stats, err)
+	}
+}
+
+func TestShardSyncCatchesUpAndTruncates(t *testing.T) {
+	blocks := fixedBlocks(100)
+
+	lagging := shardOf(blocks[:60])
+	stats, err := NewShardSyncer(lagging, NewLocalShardPeer(shardOf(blocks))).Sync()
+	if err != nil || stats.BlocksTransferred != 40 || lagging.GetRoot() != shardOf(blocks).GetRoot() {
+		t.Fatalf("catching up: %+v, %v", stats, err)
+	}
+
+	ahead := shardOf(blocks)
+	stats, err = NewShardSyncer(ahead, NewLocalShardPeer(shardOf(blocks[:60]))).Sync()
+	if err != nil || stats.BlocksTransferred != 0 || len(ahead.View().Blocks) != 60 {
+		t.Fatalf("truncating: %+v, %v", stats, err)
+	}
+}
+
+func TestShardSyncRejectsTamperedBlocks(t *testing.T) {
+	blocks := fixedBlocks(64)
+	local := shardOf(alteredBlocks(blocks, 3))
+	root := local.GetRoot()
+
+	_, err := NewShardSyncer(local, tamperingPeer{NewLocalShardPeer(shardOf(blocks))}).Sync()
+	if !errors.Is(err, ErrSyncMismatch) {
+		t.Fatalf("tampered block: %v", err)
+	}
+	if local.GetRoot() != root {
+		t.Fatal("local shard changed by a failed sync")
+	}
+	if _, err := NewLocalShardPeer(local).TreeSummary(TreeSummaryRequest{ShardID: 9}); !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("summary of another shard: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Errors returned when compacting and restoring the archive
+var (
+	ErrNothingToCompact      = errors.New("no archived blocks to compact")
+	ErrArchiveNotCompactable = errors.New("archive trie does not support deletion")
+	ErrSegmentNotFound       = errors.New("archive segment not found")
+	ErrInvalidSegment        = errors.New("invalid archive segment")
+)
+
+// ArchiveSegment is a sealed run of archived blocks removed from memory by
+// CompactArchive. Root is the Merkle root of a trie over just these blocks,
+// and Signature seals the segment's range and root.
+type ArchiveSegment struct {
+	FirstIndex int             `json:"first_index"`
+	LastIndex  int             `json:"last_index"`
+	Blocks     []ArchivedBlock `json:"blocks"` // In index order
+	Root       string          `json:"root"`
+	Signature  string          `json:"signature"` // Hex ed25519 signature, see SegmentPublicKey
+}
+
+// SegmentRef is what a StateManager keeps of a compacted segment
+type SegmentRef struct {
+	FirstIndex int    `json:"first_index"`
+	LastIndex  int    `json:"last_index"`
+	Count      int    `json:"count"`
+	Root       string `json:"root"`
+}
+
+// Ref returns the reference a StateManager keeps for the segment
+func (seg ArchiveSegment) Ref() SegmentRef {
+	return SegmentRef{FirstIndex: seg.FirstIndex, LastIndex: seg.LastIndex, Count: len(seg.Blocks), Root: seg.Root}
+}
+
+// contains reports whether index falls in the segment's range
+func (ref SegmentRef) contains(index int) bool {
+	return index >= ref.FirstIndex && index <= ref.LastIndex
+}
+
+// ArchiveSink stores sealed archive segments by root, e.g. on disk or on an
+// archive node
+type ArchiveSink interface {
+	PutSegment(seg ArchiveSegment) error
+	GetSegment(root string) (ArchiveSegment, error)
+}
+
+// MemoryArchiveSink is an in-memory ArchiveSink
+type MemoryArchiveSink struct {
+	segments map[string]ArchiveSegment
+	mutex    sync.RWMutex
+}
+
+// NewMemoryArchiveSink creates an empty sink
+func NewMemoryArchiveSink() *MemoryArchiveSink {
+	return &MemoryArchiveSink{segments: make(map[string]ArchiveSegment)}
+}
+
+// PutSegment implements ArchiveSink
+func (s *MemoryArchiveSink) PutSegment(seg ArchiveSegment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.segments[seg.Root] = seg
+	return nil
+}
+
+// GetSegment implements ArchiveSink
+func (s *MemoryArchiveSink) GetSegment(root string) (ArchiveSegment, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	seg, exists := s.segments[root]
+	if !exists {
+		return ArchiveSegment{}, fmt.Errorf("%w: %s", ErrSegmentNotFound, root)
+	}
+	return seg, nil
+}
+
+// WithArchiveSink writes segments sealed by CompactArchive to sink, from
+// which RestoreBlock reads them back
+func WithArchiveSink(sink ArchiveSink) StateManagerOption {
+	return func(sm *StateManager) {
+		sm.Sink = sink
+	}
+}
+
+// WithSegmentKey signs archive segments with key; without it a key is
+// generated on the first compaction
+func WithSegmentKey(key ed25519.PrivateKey) StateManagerOption {
+	return func(sm *StateManager) {
+		sm.segmentKey = key
+	}
+}
+
+// SegmentPublicKey returns the key archive segments are signed with
+func (sm *StateManager) SegmentPublicKey() ed25519.PublicKey {
+	if sm.segmentKey == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Sprintf("generating segment key: %v", err))
+		}
+		sm.segmentKey = key
+	}
+	return sm.segmentKey.Public().(ed25519.PublicKey)
+}
+
+// segmentMessage is the encoding a segment signature covers
+func segmentMessage(seg ArchiveSegment) []byte {
+	return []byte(fmt.Sprintf("archive-segment|%d|%d|%d|%s", seg.FirstIndex, seg.LastIndex, len(seg.Blocks), seg.Root))
+}
+
+// archivedValue is what the tries store for an archived block, matching
+// stateValue
+func archivedValue(b ArchivedBlock) string {
+	if b.Data == "" && b.DataHash != "" {
+		return b.DataHash
+	}
+	return b.Data
+}
+
+// segmentRoot returns the Merkle root of a trie over blocks
+func segmentRoot(blocks []ArchivedBlock) string {
+	trie := NewSuccinctTrie()
+	for _, b := range blocks {
+		trie.Insert(b.Hash, archivedValue(b))
+	}
+	return trie.GetMerkleRoot()
+}
+
+// VerifyArchiveSegment checks that a segment's root matches its blocks and
+// that pub signed it
+func VerifyArchiveSegment(pub ed25519.PublicKey, seg ArchiveSegment) error {
+	if len(seg.Blocks) == 0 {
+		return fmt.Errorf("%w: no blocks", ErrInvalidSegment)
+	}
+	for _, b := range seg.Blocks {
+		if b.Index < seg.FirstIndex || b.Index > seg.LastIndex {
+			return fmt.Errorf("%w: block #%d outside [%d, %d]", ErrInvalidSegment, b.Index, seg.FirstIndex, seg.LastIndex)
+		}
+	}
+	if segmentRoot(seg.Blocks) != seg.Root {
+		return fmt.Errorf("%w: root %s does not match its blocks", ErrInvalidSegment, seg.Root)
+	}
+	sig, err := hex.DecodeString(seg.Signature)
+	if err != nil || len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, segmentMessage(seg), sig) {
+		return fmt.Errorf("%w: bad signature on %s", ErrInvalidSegment, seg.Root)
+	}
+	return nil
+}
+
+// CompactArchive removes archived blocks with an index below olderThan from
+// memory and seals them into a segment. The segment is written to the sink,
+// if there is one, before anything is removed; otherwise the caller must
+// store it. Its root joins the manager's segment list, which GetArchiveRoot
+// commits to alongside the live archive trie.
+func (sm *StateManager) CompactArchive(olderThan int) (ArchiveSegment, error) {
+	deleter, ok := sm.ArchiveTrie.(interface{ Delete(key string) bool })
+	if !ok {
+		return ArchiveSegment{}, ErrArchiveNotCompactable
+	}
+
+	var sealed, kept []ArchivedBlock
+	for _, b := range sm.PrunedBlocks {
+		if b.Index < olderThan {
+			sealed = append(sealed, b)
+		} else {
+			kept = append(kept, b)
+		}
+	}
+	if len(sealed) == 0 {
+		return ArchiveSegment{}, fmt.Errorf("%w: none below #%d", ErrNothingToCompact, olderThan)
+	}
+	sort.SliceStable(sealed, func(i, j int) bool { return sealed[i].Index < sealed[j].Index })
+
+	seg := ArchiveSegment{
+		FirstIndex: sealed[0].Index,
+		LastIndex:  sealed[len(sealed)-1].Index,
+		Blocks:     sealed,
+		Root:       segmentRoot(sealed),
+	}
+	sm.SegmentPublicKey()
+	seg.Signature = hex.EncodeToString(ed25519.Sign(sm.segmentKey, segmentMessage(seg)))
+	if sm.Sink != nil {
+		if err := sm.Sink.PutSegment(seg); err != nil {
+			return ArchiveSegment{}, fmt.Errorf("writing archive segment: %w", err)
+		}
+	}
+
+	for _, b := range sealed {
+		deleter.Delete(b.Hash)
+	}
+	if kept == nil {
+		kept = []ArchivedBlock{}
+	}
+	sm.PrunedBlocks = kept
+	sm.Segments = append(sm.Segments, seg.Ref())
+	return seg, nil
+}
+
+// RestoreBlock returns an archived block, reading it back from a sealed
+// segment in the sink if it was compacted. Header-only blocks get their
+// data back from the body archive when there is one.
+func (sm *StateManager) RestoreBlock(hash string) (ArchivedBlock, error) {
+	block, err := sm.findArchived(hash)
+	if err != nil {
+		return ArchivedBlock{}, err
+	}
+	if block.Data != "" || block.DataHash == "" || sm.Bodies == nil {
+		return block, nil
+	}
+	body, err := sm.Bodies.GetBody(hash)
+	if err != nil {
+		return ArchivedBlock{}, err
+	}
+	if HashBlockData(body.Data) != block.DataHash {
+		return ArchivedBlock{}, fmt.Errorf("%w: data of block %s", ErrBodyMismatch, hash)
+	}
+	block.Data = body.Data
+	return block, nil
+}
+
+// findArchived looks a block up in the live archive, then in sealed
+// segments from newest to oldest
+func (sm *StateManager) findArchived(hash string) (ArchivedBlock, error) {
+	for _, b := range sm.PrunedBlocks {
+		if b.Hash == hash {
+			return b, nil
+		}
+	}
+	if sm.Sink == nil || len(sm.Segments) == 0 {
+		return ArchivedBlock{}, fmt.Errorf("%w: %s not archived", ErrBlockNotFound, hash)
+	}
+	pub := sm.SegmentPublicKey()
+	for i := len(sm.Segments) - 1; i >= 0; i-- {
+		ref := sm.Segments[i]
+		seg, err := sm.Sink.GetSegment(ref.Root)
+		if err != nil {
+			return ArchivedBlock{}, err
+		}
+		if err := VerifyArchiveSegment(pub, seg); err != nil {
+			return ArchivedBlock{}, err
+		}
+		if seg.Ref() != ref {
+			return ArchivedBlock{}, fmt.Errorf("%w: %s does not match its reference", ErrInvalidSegment, ref.Root)
+		}
+		for _, b := range seg.Blocks {
+			if b.Hash == hash {
+				return b, nil
+			}
+		}
+	}
+	return ArchivedBlock{}, fmt.Errorf("%w: %s not archived", ErrBlockNotFound, hash)
+}
+
+// compacted reports whether a block index falls in a sealed segment
+func (sm *StateManager) compacted(index int) bool {
+	for _, ref := range sm.Segments {
+		if ref.contains(index) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// compactingState returns a manager keeping 4 active blocks with the rest
+// of blocks archived, sealing segments into a memory sink
+func compactingState(t *testing.T, blocks []Block) (*StateManager, *MemoryArchiveSink) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewMemoryArchiveSink()
+	sm := NewStateManager(4, WithArchiveSink(sink), WithSegmentKey(key))
+	for _, b := range blocks {
+		sm.AddBlock(b)
+	}
+	return sm, sink
+}
+
+func TestArchiveRootStableAcrossCompactions(t *testing.T) {
+	blocks := stateChain(t, 30)
+	a, _ := compactingState(t, blocks)
+	b, _ := compactingState(t, blocks)
+	if len(a.PrunedBlocks) != 26 {
+		t.Fatalf("%d blocks archived, want 26", len(a.PrunedBlocks))
+	}
+
+	// The same compactions of the same data give the same root, whoever
+	// signs the segments
+	for _, olderThan := range []int{6, 12, 20} {
+		segA, err := a.CompactArchive(olderThan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segB, err := b.CompactArchive(olderThan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if segA.Root != segB.Root || a.GetArchiveRoot() != b.GetArchiveRoot() {
+			t.Fatalf("roots differ after compacting below #%d", olderThan)
+		}
+		if segA.Signature == segB.Signature {
+			t.Fatal("segments signed by different keys share a signature")
+		}
+		for _, blk := range segA.Blocks {
+			if blk.Index >= olderThan {
+				t.Fatalf("block #%d sealed below #%d", blk.Index, olderThan)
+			}
+		}
+	}
+	if len(a.Segments) != 3 || len(a.PrunedBlocks) != 26-19 {
+		t.Fatalf("%d segments and %d live archived blocks", len(a.Segments), len(a.PrunedBlocks))
+	}
+
+	// The root commits to the segments and the live archive
+	roots := []string{a.Segments[0].Root, a.Segments[1].Root, a.Segments[2].Root, a.ArchiveTrie.GetMerkleRoot()}
+	if a.GetArchiveRoot() != NewMerkleTree(roots).Root {
+		t.Fatal("archive root is not the Merkle root over segments and the live trie")
+	}
+	if _, err := a.CompactArchive(20); !errors.Is(err, ErrNothingToCompact) {
+		t.Fatalf("compacting again: %v", err)
+	}
+}
+
+func TestRestoreBlockFromSealedSegment(t *testing.T) {
+	blocks := stateChain(t, 20)
+	sm, sink := compactingState(t, blocks)
+	seg, err := sm.CompactArchive(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyArchiveSegment(sm.SegmentPublicKey(), seg); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks[:7] {
+		restored, err := sm.RestoreBlock(b.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Hash != b.Hash || restored.Data != b.Data {
+			t.Fatalf("restored %+v for block #%d", restored, b.Index)
+		}
+	}
+	if _, err := sm.RestoreBlock(blocks[10].Hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.RestoreBlock("missing"); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("restoring a block never archived: %v", err)
+	}
+
+	// A segment altered in the sink is refused
+	tampered := seg
+	tampered.Blocks = append([]ArchivedBlock(nil), seg.Blocks...)
+	tampered.Blocks[0].Data = "tampered"
+	if err := sink.PutSegment(tampered); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.RestoreBlock(blocks[0].Hash); !errors.Is(err, ErrInvalidSegment) {
+		t.Fatalf("restoring from a tampered segment: %v", err)
+	}
+}
+
+func TestVerifyArchiveSegment(t *testing.T) {
+	sm, _ := compactingState(t, stateChain(t, 12))
+	seg, err := sm.CompactArchive(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := sm.SegmentPublicKey()
+	other, _ := compactingState(t, nil)
+
+	for name, tamper := range map[string]func(*ArchiveSegment){
+		"range":     func(s *ArchiveSegment) { s.LastIndex = s.FirstIndex },
+		"root":      func(s *ArchiveSegment) { s.Root = NewSuccinctTrie().GetMerkleRoot() },
+		"signature": func(s *ArchiveSegment) { s.Signature = "00" },
+		"empty":     func(s *ArchiveSegment) { s.Blocks = nil },
+	} {
+		tampered := seg
+		tamper(&tampered)
+		if err := VerifyArchiveSegment(pub, tampered); !errors.Is(err, ErrInvalidSegment) {
+			t.Errorf("segment with tampered %s: %v", name, err)
+		}
+	}
+	if err := VerifyArchiveSegment(other.SegmentPublicKey(), seg); !errors.Is(err, ErrInvalidSegment) {
+		t.Fatalf("segment under another key: %v", err)
+	}
+
+	// A trie that cannot delete cannot be compacted
+	lazy, err := NewLazyTrie(NewMemoryNodeStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk := NewStateManager(2, WithArchiveTrie(lazy))
+	for _, b := range stateChain(t, 6) {
+		disk.AddBlock(b)
+	}
+	if _, err := disk.CompactArchive(4); !errors.Is(err, ErrArchiveNotCompactable) {
+		t.Fatalf("compacting a lazy archive: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrAuditWrongShard is returned when a shard is asked to answer an audit
+// challenge issued for another shard
+var ErrAuditWrongShard = errors.New("audit challenge is for another shard")
+
+// DefaultAuditTimeout is how long a custodian has to answer a challenge
+const DefaultAuditTimeout = 5 * time.Second
+
+// AuditChallenge asks a shard's custodian to prove it still holds the
+// blocks at randomly chosen leaf indices of its tree. The nonce ties the
+// response to the challenge, so responses cannot be replayed.
+type AuditChallenge struct {
+	ShardID   int       `json:"shard_id"`
+	Indices   []int     `json:"indices"`
+	LeafCount int       `json:"leaf_count"` // Blocks the shard held when last observed
+	Nonce     string    `json:"nonce"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+// AuditItem is a challenged block's header, committing to its data hash,
+// with the Merkle path linking it to the shard root
+type AuditItem struct {
+	Index  int         `json:"index"`
+	Header BlockHeader `json:"header"`
+	Proof  MerkleProof `json:"proof"`
+}
+
+// AuditResponse answers an AuditChallenge with one item per index, in the
+// challenge's order
+type AuditResponse struct {
+	ShardID int         `json:"shard_id"`
+	Nonce   string      `json:"nonce"`
+	Items   []AuditItem `json:"items"`
+}
+
+// RespondToAudit proves the challenged blocks against the shard's current
+// tree. A custodian that lost blocks fails with ErrIndexOutOfRange, or
+// answers with proofs that do not verify against the audited root.
+func (s *Shard) RespondToAudit(ch AuditChallenge) (AuditResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ch.ShardID != s.ID {
+		return AuditResponse{}, fmt.Errorf("%w: #%d asked for #%d", ErrAuditWrongShard, s.ID, ch.ShardID)
+	}
+	if s.Tree == nil || len(s.Tree.Leaves) != len(s.Blocks) {
+		s.Tree = newBlockTree(s.Blocks)
+	}
+
+	resp := AuditResponse{ShardID: s.ID, Nonce: ch.Nonce, Items: make([]AuditItem, 0, len(ch.Indices))}
+	for _, i := range ch.Indices {
+		if i < 0 || i >= len(s.Blocks) {
+			return AuditResponse{}, fmt.Errorf("%w: %d for shard #%d (block count: %d)", ErrIndexOutOfRange, i, s.ID, len(s.Blocks))
+		}
+		proof, err := s.Tree.GetProof(i)
+		if err != nil {
+			return AuditResponse{}, err
+		}
+		resp.Items = append(resp.Items, AuditItem{Index: i, Header: s.Blocks[i].Header(), Proof: proof})
+	}
+	return resp, nil
+}
+
+// Auditor challenges shard custodians to prove they still hold their
+// blocks. Custodians that answer wrongly or too late lose reputation
+// through BFTManager.RecordTimeout.
+type Auditor struct {
+	timeout     time.Duration
+	random      RandomSource
+	now         func() time.Time
+	bft         *BFTManager               // Optional; penalizes failed custodians
+	custodians  map[int]int               // BFT node holding each shard
+	sizes       map[int]int               // Block count of each shard when last observed
+	roots       map[int]string            // Root of each shard when last observed
+	outstanding map[string]AuditChallenge // Unanswered challenges by nonce
+	mutex       sync.Mutex
+}
+
+// AuditorOption configures an Auditor
+type AuditorOption func(*Auditor)
+
+// WithAuditTimeout sets how long custodians have to answer a challenge
+func WithAuditTimeout(timeout time.Duration) AuditorOption {
+	return func(a *Auditor) {
+		a.timeout = timeout
+	}
+}
+
+// WithAuditRandom draws challenged indices and nonces from src
+func WithAuditRandom(src RandomSource) AuditorOption {
+	return func(a *Auditor) {
+		a.random = src
+	}
+}
+
+// WithAuditClock replaces time.Now for challenge deadlines
+func WithAuditClock(now func() time.Time) AuditorOption {
+	return func(a *Auditor) {
+		a.now = now
+	}
+}
+
+// WithAuditPenalties records failed audits against custodians' reputation
+// in bft; see SetCustodian
+func WithAuditPenalties(bft *BFTManager) AuditorOption {
+	return func(a *Auditor) {
+		a.bft = bft
+	}
+}
+
+// NewAuditor creates an auditor giving custodians DefaultAuditTimeout to
+// answer
+func NewAuditor(opts ...AuditorOption) *Auditor {
+	a := &Auditor{
+		timeout:     DefaultAuditTimeout,
+		random:      defaultSource{},
+		now:         time.Now,
+		custodians:  make(map[int]int),
+		sizes:       make(map[int]int),
+		roots:       make(map[int]string),
+		outstanding: make(map[string]AuditChallenge),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// SetCustodian names the BFT node penalized when a shard fails an audit
+func (a *Auditor) SetCustodian(shardID, nodeID int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.custodians[shardID] = nodeID
+}
+
+// Observe records a shard's root and block count, e.g. from an attested
+// header, as the state later challenges are checked against
+func (a *Auditor) Observe(view ShardView) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.sizes[view.ID] = view.BlockCount
+	a.roots[view.ID] = view.Root
+}
+
+// LastRoot returns the root of a shard when it was last observed
+func (a *Auditor) LastRoot(shardID int) (string, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	root, exists := a.roots[shardID]
+	return root, exists
+}
+
+// Challenge picks k distinct leaf indices of a shard at random, or every
+// index if it held fewer blocks when last observed, and a fresh nonce. A
+// challenge of a shard never observed, or observed empty, has no indices
+// and is not tracked.
+func (a *Auditor) Challenge(shardID int, k int) AuditChallenge {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	count := a.sizes[shardID]
+	if k > count {
+		k = count
+	}
+	nonce := make([]byte, 16)
+	a.random.Read(nonce)
+	ch := AuditChallenge{
+		ShardID:   shardID,
+		LeafCount: count,
+		Nonce:     hex.EncodeToString(nonce),
+		Deadline:  a.now().Add(a.timeout),
+	}
+	if k <= 0 {
+		return ch
+	}
+
+	// Partial Fisher-Yates shuffle of the leaf indices
+	indices := make([]int, count)
+	for i := range indices {
+		indices[i] = i
+	}
+	for i := 0; i < k; i++ {
+		j := i + a.random.Intn(count-i)
+		indices[i], indices[j] = indices[j], indices[i]
+	}
+	ch.Indices = indices[:k:k]
+	a.outstanding[ch.Nonce] = ch
+	return ch
+}
+
+// VerifyResponse checks a response against root, the shard root the
+// auditor last knew, and the challenge as issued: it must arrive by the
+// deadline, carry the nonce and prove every challenged block. A response
+// to a challenge this auditor did not issue, or already settled, is
+// rejected without penalty; any other failure penalizes the custodian.
+func (a *Auditor) VerifyResponse(root string, ch AuditChallenge, resp AuditResponse) bool {
+	a.mutex.Lock()
+	issued, exists := a.outstanding[ch.Nonce]
+	delete(a.outstanding, ch.Nonce)
+	late := a.now().After(issued.Deadline)
+	a.mutex.Unlock()
+	if !exists {
+		return false
+	}
+
+	if late || !auditResponseValid(root, issued, resp) {
+		a.penalize(issued.ShardID)
+		return false
+	}
+	return true
+}
+
+// ExpireChallenges settles challenges whose deadline has passed without a
+// response, penalizing their custodians, and returns them
+func (a *Auditor) ExpireChallenges() []AuditChallenge {
+	a.mutex.Lock()
+	var expired []AuditChallenge
+	now := a.now()
+	for nonce, ch := range a.outstanding {
+		if now.After(ch.Deadline) {
+			expired = append(expired, ch)
+			delete(a.outstanding, nonce)
+		}
+	}
+	a.mutex.Unlock()
+
+	for _, ch := range expired {
+		a.penalize(ch.ShardID)
+	}
+	return expired
+}
+
+// auditResponseValid checks every item of a response against root
+func auditResponseValid(root string, ch AuditChallenge, resp AuditResponse) bool {
+	if resp.ShardID != ch.ShardID || resp.Nonce != ch.Nonce || len(resp.Items) != len(ch.Indices) {
+		return false
+	}
+	for i, item := range resp.Items {
+		if item.Index != ch.Indices[i] || item.Proof.Index != item.Index || item.Proof.LeafCount != ch.LeafCount {
+			return false
+		}
+		if !VerifyMerkleProof(root, item.Header.leafData(), item.Proof) {
+			return false
+		}
+	}
+	return true
+}
+
+// penalize lowers the reputation of a shard's custodian, if known
+func (a *Auditor) penalize(shardID int) {
+	a.mutex.Lock()
+	nodeID, exists := a.custodians[shardID]
+	a.mutex.Unlock()
+	if exists && a.bft != nil {
+		a.bft.RecordTimeout(nodeID)
+	}
+}
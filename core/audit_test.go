@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// auditSetup returns an auditor that has observed a shard of n blocks held
+// by node 1 of a BFT manager, with the clock its deadlines run on
+func auditSetup(t *testing.T, n int) (*Auditor, *Shard, *BFTManager, *fakeClock) {
+	t.Helper()
+	shard := NewShard(7)
+	for _, b := range fixedBlocks(n) {
+		shard.AddBlock(b)
+	}
+	bft := behaviorBFT(4)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	a := NewAuditor(WithAuditPenalties(bft), WithAuditClock(clock.Now), WithAuditTimeout(time.Second))
+	a.SetCustodian(shard.ID, 1)
+	a.Observe(shard.View())
+	return a, shard, bft, clock
+}
+
+// audit challenges the shard for k blocks and checks its answer
+func audit(t *testing.T, a *Auditor, shard *Shard, k int) bool {
+	t.Helper()
+	root, _ := a.LastRoot(shard.ID)
+	ch := a.Challenge(shard.ID, k)
+	resp, err := shard.RespondToAudit(ch)
+	if err != nil {
+		// A custodian unable to answer is found out when its time is up
+		return false
+	}
+	return a.VerifyResponse(root, ch, resp)
+}
+
+func TestHonestCustodianPassesAudits(t *testing.T) {
+	a, shard, bft, _ := auditSetup(t, 20)
+	for i := 0; i < 50; i++ {
+		if !audit(t, a, shard, 5) {
+			t.Fatalf("honest custodian failed audit %d", i)
+		}
+	}
+	if bft.Nodes[1].Reputation != 0.5 {
+		t.Fatalf("honest custodian's reputation is %v", bft.Nodes[1].Reputation)
+	}
+
+	// Every leaf is challenged when k exceeds the shard
+	ch := a.Challenge(shard.ID, 50)
+	seen := make(map[int]bool)
+	for _, i := range ch.Indices {
+		seen[i] = true
+	}
+	if len(ch.Indices) != 20 || len(seen) != 20 || ch.LeafCount != 20 {
+		t.Fatalf("challenge of %d indices, %d distinct, over %d leaves", len(ch.Indices), len(seen), ch.LeafCount)
+	}
+	if unobserved := a.Challenge(99, 3); len(unobserved.Indices) != 0 {
+		t.Fatalf("challenge of a shard never observed has indices %v", unobserved.Indices)
+	}
+}
+
+func TestCustodianDroppingOneBlockFailsAudits(t *testing.T) {
+	a, shard, bft, clock := auditSetup(t, 20)
+
+	// The custodian silently loses a block
+	shard.mutex.Lock()
+	shard.Blocks = append(shard.Blocks[:11:11], shard.Blocks[12:]...)
+	shard.mutex.Unlock()
+
+	for i := 0; i < 5; i++ {
+		root, _ := a.LastRoot(shard.ID)
+		ch := a.Challenge(shard.ID, 3)
+		resp, err := shard.RespondToAudit(ch)
+		switch {
+		case errors.Is(err, ErrIndexOutOfRange):
+			// No answer at all: the deadline passes
+			clock.Advance(2 * time.Second)
+			if expired := a.ExpireChallenges(); len(expired) != 1 || expired[0].Nonce != ch.Nonce {
+				t.Fatalf("expired %v", expired)
+			}
+		case err != nil:
+			t.Fatal(err)
+		case a.VerifyResponse(root, ch, resp):
+			t.Fatalf("custodian missing a block passed audit %d of %v", i, ch.Indices)
+		}
+	}
+	if got, want := bft.Nodes[1].Reputation, 0.5-5*ReputationPenalty; got < want-1e-9 || got > want+1e-9 {
+		t.Fatalf("reputation %v after five failed audits, want %v", got, want)
+	}
+	if bft.Nodes[0].Reputation != 0.5 {
+		t.Fatal("another node penalized")
+	}
+}
+
+func TestAuditResponseRules(t *testing.T) {
+	a, shard, bft, clock := auditSetup(t, 10)
+	root, _ := a.LastRoot(shard.ID)
+	penalties := 0
+	check := func(name string, ok, wantOK, penalized bool) {
+		t.Helper()
+		if penalized {
+			penalties++
+		}
+		if ok != wantOK {
+			t.Fatalf("%s: verified %v", name, ok)
+		}
+		if want := 0.5 - float64(penalties)*ReputationPenalty; bft.Nodes[1].Reputation < want-1e-9 || bft.Nodes[1].Reputation > want+1e-9 {
+			t.Fatalf("%s: reputation %v, want %v", name, bft.Nodes[1].Reputation, want)
+		}
+	}
+
+	ch := a.Challenge(shard.ID, 3)
+	resp, err := shard.RespondToAudit(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("honest", a.VerifyResponse(root, ch, resp), true, false)
+	// A settled challenge cannot be answered again, nor penalize anyone
+	check("replayed", a.VerifyResponse(root, ch, resp), false, false)
+	// An old response does not answer a new challenge
+	next := a.Challenge(shard.ID, 3)
+	check("stale nonce", a.VerifyResponse(root, next, resp), false, true)
+
+	for name, tamper := range map[string]func(*AuditResponse){
+		"header":   func(r *AuditResponse) { r.Items[0].Header.DataHash = HashBlockData("forged") },
+		"order":    func(r *AuditResponse) { r.Items[0], r.Items[1] = r.Items[1], r.Items[0] },
+		"missing":  func(r *AuditResponse) { r.Items = r.Items[1:] },
+		"shard id": func(r *AuditResponse) { r.ShardID = 8 },
+	} {
+		ch := a.Challenge(shard.ID, 3)
+		resp, err := shard.RespondToAudit(ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(&resp)
+		check(name, a.VerifyResponse(root, ch, resp), false, true)
+	}
+
+	// Answers after the deadline fail, as do answers against another root
+	ch = a.Challenge(shard.ID, 3)
+	resp, _ = shard.RespondToAudit(ch)
+	clock.Advance(2 * time.Second)
+	check("late", a.VerifyResponse(root, ch, resp), false, true)
+	ch = a.Challenge(shard.ID, 3)
+	resp, _ = shard.RespondToAudit(ch)
+	check("other root", a.VerifyResponse(NewMerkleTree([]string{"x"}).Root, ch, resp), false, true)
+
+	if _, err := NewShard(8).RespondToAudit(a.Challenge(shard.ID, 1)); !errors.Is(err, ErrAuditWrongShard) {
+		t.Fatalf("challenge for another shard: %v", err)
+	}
+	if err := bft.RecordTimeout(99); !errors.Is(err, ErrUnknownNode) {
+		t.Fatalf("timeout of an unknown node: %v", err)
+	}
+}
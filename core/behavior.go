@@ -0,0 +1,260 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Errors returned when handling node behavior
+var (
+	ErrNotEquivocation = errors.New("votes are not an equivocation")
+	ErrUnknownNode     = errors.New("unknown node")
+)
+
+// Reputation changes applied after BFT rounds
+const (
+	ReputationReward  = 0.01 // For voting in a decided round
+	ReputationPenalty = 0.05 // For missing a decided round
+)
+
+// DefaultRoundTimeout is how long a round waits for a node's votes
+const DefaultRoundTimeout = time.Second
+
+// Action is what a node does in response to a step of a BFT round
+type Action struct {
+	Votes []Vote        // Signed votes to send, possibly none
+	Delay time.Duration // How long after the step they are sent
+}
+
+// Behavior decides how a node acts at each step of a BFT round, so faulty
+// nodes can be simulated beyond staying silent
+type Behavior interface {
+	// OnPropose is called when the leader proposes blockHash and returns
+	// the node's prepare votes
+	OnPropose(n *Node, view, height int, blockHash string) Action
+	// OnVote is called when the protocol has the node cast v, once it has
+	// seen a prepare quorum, and returns the votes actually sent
+	OnVote(n *Node, v Vote) Action
+	// OnTimeout is called when a round ends without a decision
+	OnTimeout(n *Node, view int)
+}
+
+// HonestBehavior follows the protocol
+type HonestBehavior struct{}
+
+// OnPropose implements Behavior
+func (HonestBehavior) OnPropose(n *Node, view, height int, blockHash string) Action {
+	return Action{Votes: []Vote{n.SignVote(PrepareVote, view, height, blockHash)}}
+}
+
+// OnVote implements Behavior
+func (HonestBehavior) OnVote(_ *Node, v Vote) Action {
+	return Action{Votes: []Vote{v}}
+}
+
+// OnTimeout implements Behavior
+func (HonestBehavior) OnTimeout(*Node, int) {}
+
+// SilentBehavior never votes
+type SilentBehavior struct{}
+
+// OnPropose implements Behavior
+func (SilentBehavior) OnPropose(*Node, int, int, string) Action { return Action{} }
+
+// OnVote implements Behavior
+func (SilentBehavior) OnVote(*Node, Vote) Action { return Action{} }
+
+// OnTimeout implements Behavior
+func (SilentBehavior) OnTimeout(*Node, int) {}
+
+// EquivocatingBehavior votes for the proposed block and for a conflicting
+// one in every phase
+type EquivocatingBehavior struct{}
+
+// OnPropose implements Behavior
+func (EquivocatingBehavior) OnPropose(n *Node, view, height int, blockHash string) Action {
+	return Action{Votes: []Vote{
+		n.SignVote(PrepareVote, view, height, blockHash),
+		n.SignVote(PrepareVote, view, height, conflictingHash(blockHash)),
+	}}
+}
+
+// OnVote implements Behavior
+func (EquivocatingBehavior) OnVote(n *Node, v Vote) Action {
+	return Action{Votes: []Vote{v, n.SignVote(v.Type, v.View, v.Height, conflictingHash(v.BlockHash))}}
+}
+
+// OnTimeout implements Behavior
+func (EquivocatingBehavior) OnTimeout(*Node, int) {}
+
+// conflictingHash derives the block an equivocating node also votes for
+func conflictingHash(blockHash string) string {
+	hash := sha256.Sum256([]byte("equivocation:" + blockHash))
+	return hex.EncodeToString(hash[:])
+}
+
+// slowBehavior votes honestly after a delay
+type slowBehavior struct {
+	delay time.Duration
+}
+
+// SlowBehavior follows the protocol but sends every vote delay late
+func SlowBehavior(delay time.Duration) Behavior {
+	return slowBehavior{delay: delay}
+}
+
+// OnPropose implements Behavior
+func (s slowBehavior) OnPropose(n *Node, view, height int, blockHash string) Action {
+	action := HonestBehavior{}.OnPropose(n, view, height, blockHash)
+	action.Delay = s.delay
+	return action
+}
+
+// OnVote implements Behavior
+func (s slowBehavior) OnVote(n *Node, v Vote) Action {
+	return Action{Votes: []Vote{v}, Delay: s.delay}
+}
+
+// OnTimeout implements Behavior
+func (slowBehavior) OnTimeout(*Node, int) {}
+
+// EffectiveBehavior returns the node's behavior, defaulting to silent for
+// nodes flagged Byzantine and honest otherwise
+func (n *Node) EffectiveBehavior() Behavior {
+	if n.Behavior != nil {
+		return n.Behavior
+	}
+	if n.Byzantine {
+		return SilentBehavior{}
+	}
+	return HonestBehavior{}
+}
+
+// EquivocationEvidence is a pair of validly signed votes from one node for
+// different blocks in the same phase of the same round
+type EquivocationEvidence struct {
+	First  Vote `json:"first"`
+	Second Vote `json:"second"`
+}
+
+// NodeID returns the equivocating node
+func (e EquivocationEvidence) NodeID() int {
+	return e.First.NodeID
+}
+
+// Verify checks that the votes conflict and are both signed by pub
+func (e EquivocationEvidence) Verify(pub ed25519.PublicKey) error {
+	a, b := e.First, e.Second
+	if a.NodeID != b.NodeID || a.Type != b.Type || a.View != b.View || a.Height != b.Height || a.BlockHash == b.BlockHash {
+		return fmt.Errorf("%w: votes do not conflict", ErrNotEquivocation)
+	}
+	if !VerifyVote(pub, a) || !VerifyVote(pub, b) {
+		return fmt.Errorf("%w: node #%d", ErrInvalidVoteSignature, a.NodeID)
+	}
+	return nil
+}
+
+// voteSlot identifies where a node may cast at most one vote
+type voteSlot struct {
+	NodeID int
+	Type   VoteType
+	View   int
+	Height int
+}
+
+// DetectEquivocation returns evidence for every node that signed votes for
+// two different blocks in the same phase of a round. Votes with invalid
+// signatures are ignored.
+func DetectEquivocation(votes []Vote, pubkeys map[int]ed25519.PublicKey) []EquivocationEvidence {
+	first := make(map[voteSlot]Vote)
+	reported := make(map[int]bool)
+	var evidence []EquivocationEvidence
+	for _, v := range votes {
+		if pub, known := pubkeys[v.NodeID]; !known || !VerifyVote(pub, v) {
+			continue
+		}
+		slot := voteSlot{NodeID: v.NodeID, Type: v.Type, View: v.View, Height: v.Height}
+		prev, seen := first[slot]
+		if !seen {
+			first[slot] = v
+			continue
+		}
+		if prev.BlockHash != v.BlockHash && !reported[v.NodeID] {
+			reported[v.NodeID] = true
+			evidence = append(evidence, EquivocationEvidence{First: prev, Second: v})
+		}
+	}
+	return evidence
+}
+
+// RecordEquivocation verifies evidence against the node's key and drops
+// its reputation to zero, excluding it from participant selection. With a
+// stake registry, EquivocationSlashPercent of its stake is also slashed.
+func (bft *BFTManager) RecordEquivocation(e EquivocationEvidence) error {
+	node := bft.nodeByID(e.NodeID())
+	if node == nil {
+		return fmt.Errorf("%w: #%d", ErrUnknownNode, e.NodeID())
+	}
+	if err := e.Verify(node.PublicKey); err != nil {
+		return err
+	}
+	node.Reputation = 0
+	if bft.stakes != nil {
+		bft.stakes.Slash(node.ID, EquivocationSlashPercent)
+	}
+	return nil
+}
+
+// RecordRound rewards the nodes that voted in a decided round, refreshing
+// them through RecordVote, and penalizes those that did not respond in time
+func (bft *BFTManager) RecordRound(voters map[int]bool) {
+	for _, node := range bft.Nodes {
+		if voters[node.ID] {
+			bft.RecordVote(node.ID)
+			node.Reputation = math.Min(node.Reputation+ReputationReward, 1)
+		} else {
+			node.Reputation = math.Max(node.Reputation-ReputationPenalty, 0)
+		}
+	}
+}
+
+// RecordTimeout penalizes a node that failed to answer in time, e.g. a
+// failed storage audit, by ReputationPenalty
+func (bft *BFTManager) RecordTimeout(nodeID int) error {
+	node := bft.nodeByID(nodeID)
+	if node == nil {
+		return fmt.Errorf("%w: #%d", ErrUnknownNode, nodeID)
+	}
+	node.Reputation = math.Max(node.Reputation-ReputationPenalty, 0)
+	return nil
+}
+
+// TimeoutRound calls every node's OnTimeout hook after a failed round
+func (bft *BFTManager) TimeoutRound(view int) {
+	for _, node := range bft.Nodes {
+		node.EffectiveBehavior().OnTimeout(node, view)
+	}
+}
+
+// nodeByID returns the node with an ID, or nil, looking through earlier
+// validator sets for nodes that have since left
+func (bft *BFTManager) nodeByID(id int) *Node {
+	for _, node := range bft.Nodes {
+		if node.ID == id {
+			return node
+		}
+	}
+	for i := len(bft.history) - 1; i >= 0; i-- {
+		for _, node := range bft.history[i].nodes {
+			if node.ID == id {
+				return node
+			}
+		}
+	}
+	return nil
+}
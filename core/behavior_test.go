@@ -0,0 +1,148 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// timeoutCounter is an honest behavior counting its OnTimeout calls
+type timeoutCounter struct {
+	HonestBehavior
+	timeouts *int
+}
+
+func (c timeoutCounter) OnTimeout(*Node, int) { *c.timeouts++ }
+
+// behaviorBFT returns n honest nodes at reputation 0.5
+func behaviorBFT(n int, opts ...BFTOption) *BFTManager {
+	bft := NewBFTManager(n, opts...)
+	for _, node := range bft.Nodes {
+		node.Byzantine = false
+		node.Reputation = 0.5
+	}
+	return bft
+}
+
+func TestEquivocationDetectedAndPunished(t *testing.T) {
+	bft := behaviorBFT(5)
+	bft.Nodes[0].Behavior = EquivocatingBehavior{}
+	keys := bft.PublicKeys()
+
+	cert, err := bft.Decide(0, 1, "block-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range cert.Votes {
+		if v.NodeID == 0 {
+			t.Fatal("equivocating node's vote in the certificate")
+		}
+	}
+	if err := VerifyCommitCertificate(cert, keys); err != nil {
+		t.Fatal(err)
+	}
+	if got := bft.Nodes[0].Reputation; got != 0 {
+		t.Fatalf("equivocating node kept reputation %v", got)
+	}
+	for _, node := range bft.Nodes[1:] {
+		if node.Reputation != 0.5+ReputationReward {
+			t.Fatalf("honest node #%d has reputation %v", node.ID, node.Reputation)
+		}
+	}
+	for _, node := range bft.SelectConsensusParticipants() {
+		if node.ID == 0 {
+			t.Fatal("equivocating node still selected as a participant")
+		}
+	}
+}
+
+func TestDetectEquivocationNeedsConflictingSignedVotes(t *testing.T) {
+	bft := behaviorBFT(4)
+	keys := bft.PublicKeys()
+	node := bft.Nodes[1]
+	action := EquivocatingBehavior{}.OnPropose(node, 3, 7, "block")
+	evidence := DetectEquivocation(action.Votes, keys)
+	if len(evidence) != 1 || evidence[0].NodeID() != node.ID {
+		t.Fatalf("evidence %+v", evidence)
+	}
+	if err := evidence[0].Verify(node.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := evidence[0].Verify(bft.Nodes[2].PublicKey); !errors.Is(err, ErrInvalidVoteSignature) {
+		t.Fatalf("evidence under another node's key: %v", err)
+	}
+
+	// Repeated, cross-phase and cross-view votes are not equivocation
+	honest := []Vote{
+		node.SignVote(PrepareVote, 3, 7, "block"),
+		node.SignVote(PrepareVote, 3, 7, "block"),
+		node.SignVote(CommitVote, 3, 7, "block"),
+		node.SignVote(PrepareVote, 4, 7, "other"),
+	}
+	if evidence := DetectEquivocation(honest, keys); len(evidence) != 0 {
+		t.Fatalf("honest votes reported: %+v", evidence)
+	}
+	same := EquivocationEvidence{First: honest[0], Second: honest[1]}
+	if err := same.Verify(node.PublicKey); !errors.Is(err, ErrNotEquivocation) {
+		t.Fatalf("identical votes as evidence: %v", err)
+	}
+
+	// A conflicting vote with a forged signature proves nothing
+	forged := action.Votes[1]
+	forged.Signature = honest[0].Signature
+	if evidence := DetectEquivocation([]Vote{action.Votes[0], forged}, keys); len(evidence) != 0 {
+		t.Fatal("forged vote accepted as evidence")
+	}
+	if err := bft.RecordEquivocation(EquivocationEvidence{First: action.Votes[0], Second: forged}); err == nil {
+		t.Fatal("forged evidence recorded")
+	}
+	if node.Reputation != 0.5 {
+		t.Fatalf("forged evidence changed reputation to %v", node.Reputation)
+	}
+}
+
+func TestSilentAndSlowNodesMissRounds(t *testing.T) {
+	bft := behaviorBFT(5, WithRoundTimeout(time.Second))
+	bft.Nodes[0].Behavior = SilentBehavior{}
+	bft.Nodes[1].Behavior = SlowBehavior(2 * time.Second)
+
+	// Four of five are needed, so two missing nodes stall the round
+	if _, err := bft.Decide(0, 1, "block-1"); !errors.Is(err, ErrInsufficientSignatures) {
+		t.Fatalf("round with two missing voters: %v", err)
+	}
+	bft.Nodes[1].Behavior = SlowBehavior(500 * time.Millisecond)
+	cert, err := bft.Decide(1, 1, "block-1")
+	if err != nil {
+		t.Fatalf("round with a node slow within the timeout: %v", err)
+	}
+	if len(cert.Votes) != 4 {
+		t.Fatalf("certificate carries %d votes", len(cert.Votes))
+	}
+	if got := bft.Nodes[0].Reputation; got != 0.5-ReputationPenalty {
+		t.Fatalf("silent node has reputation %v", got)
+	}
+	if got := bft.Nodes[1].Reputation; got != 0.5+ReputationReward {
+		t.Fatalf("slow node has reputation %v", got)
+	}
+}
+
+func TestTimeoutHookCalledOnFailedRound(t *testing.T) {
+	bft := behaviorBFT(4)
+	timeouts := 0
+	bft.Nodes[0].Behavior = timeoutCounter{timeouts: &timeouts}
+	bft.Nodes[1].Behavior = SilentBehavior{}
+	bft.Nodes[2].Byzantine = true
+	if _, err := bft.Decide(0, 1, "block-1"); err == nil {
+		t.Fatal("round decided with two of four voters")
+	}
+	if timeouts != 1 {
+		t.Fatalf("OnTimeout called %d times", timeouts)
+	}
+	bft.Nodes[1].Behavior, bft.Nodes[2].Byzantine = nil, false
+	if _, err := bft.Decide(1, 1, "block-1"); err != nil {
+		t.Fatal(err)
+	}
+	if timeouts != 1 {
+		t.Fatalf("OnTimeout called after a decided round")
+	}
+}
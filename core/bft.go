@@ -1,8 +1,9 @@
 package core
 
 import (
+	"crypto/ed25519"
 	"fmt"
-	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -11,48 +12,136 @@ type Node struct {
 	Reputation   float64
 	Byzantine    bool
 	LastResponse time.Time
+	PublicKey    ed25519.PublicKey // Verifies the node's votes
+	Behavior     Behavior          // Optional; see EffectiveBehavior
+	Misbehavior  int               // Invalid or replayed consensus messages sent, see RecordMisbehavior
+	signer       ed25519.PrivateKey
+	sequence     uint64 // Last consensus message sequence number signed
 }
 
 type BFTManager struct {
-	Nodes []*Node
+	Nodes        []*Node
+	rand         RandomSource
+	quorum       QuorumSource   // Optional; nil requires two thirds of the nodes
+	roundTimeout time.Duration  // Votes sent later than this miss the round
+	stakes       *StakeRegistry // Optional; slashed on equivocation
+	pending      []ValidatorChange
+	joining      map[int]*Node    // Nodes given to AddNode, by ID
+	history      []validatorEpoch // Replaced validator sets, oldest first
+	halfLife     time.Duration    // Reputation half-life while idle; 0 disables decay
+	decayFloor   float64          // Reputation decay never goes below this
+	now          func() time.Time // Nil uses time.Now
+	round        sync.Mutex       // Held through each Decide, so one committee runs one round at a time
+}
+
+// BFTOption configures a BFTManager
+type BFTOption func(*BFTManager)
+
+// WithBFTRandom draws node reputations and faults from src
+func WithBFTRandom(src RandomSource) BFTOption {
+	return func(bft *BFTManager) {
+		bft.rand = src
+	}
+}
+
+// WithBFTQuorum takes the votes needed to accept a round from the write
+// quorum of q, e.g. a ConsistencyOrchestrator, instead of two thirds
+func WithBFTQuorum(q QuorumSource) BFTOption {
+	return func(bft *BFTManager) {
+		bft.quorum = q
+	}
+}
+
+// WithRoundTimeout sets how long Decide waits for each node's votes
+func WithRoundTimeout(timeout time.Duration) BFTOption {
+	return func(bft *BFTManager) {
+		bft.roundTimeout = timeout
+	}
+}
+
+// WithStakeRegistry slashes nodes' stake in stakes when they are caught
+// equivocating
+func WithStakeRegistry(stakes *StakeRegistry) BFTOption {
+	return func(bft *BFTManager) {
+		bft.stakes = stakes
+	}
 }
 
 // NewBFTManager initializes N nodes
-func NewBFTManager(total int) *BFTManager {
-	var nodes []*Node
+func NewBFTManager(total int, opts ...BFTOption) *BFTManager {
+	bft := &BFTManager{rand: defaultSource{}, roundTimeout: DefaultRoundTimeout}
+	for _, opt := range opts {
+		opt(bft)
+	}
 	for i := 0; i < total; i++ {
-		nodes = append(nodes, &Node{
-			ID:         i,
-			Reputation: rand.Float64(),    // simulate history
-			Byzantine:  rand.Intn(10) < 2, // ~20% faulty
+		bft.Nodes = append(bft.Nodes, &Node{
+			ID:           i,
+			Reputation:   bft.rand.Float64(),    // simulate history
+			Byzantine:    bft.rand.Intn(10) < 2, // ~20% faulty
+			LastResponse: bft.clock(),
 		})
 	}
-	return &BFTManager{Nodes: nodes}
+	for _, node := range bft.Nodes {
+		seed := make([]byte, ed25519.SeedSize)
+		bft.rand.Read(seed)
+		node.signer = ed25519.NewKeyFromSeed(seed)
+		node.PublicKey = node.signer.Public().(ed25519.PublicKey)
+	}
+	return bft
 }
 
-// SelectConsensusParticipants picks top honest nodes
+// SelectConsensusParticipants picks top honest nodes by decayed reputation
 func (bft *BFTManager) SelectConsensusParticipants() []*Node {
-	var selected []*Node
-	for _, node := range bft.Nodes {
-		if !node.Byzantine && node.Reputation > 0.5 {
-			selected = append(selected, node)
-		}
-	}
-	return selected
+	return ReputationSelector{Reputation: bft.Reputation}.SelectParticipants(bft.Nodes, "")
+}
+
+// ReachConsensus simulates a voting round, returning ErrQuorumNotReached if
+// too few honest participants vote
+func (bft *BFTManager) ReachConsensus() error {
+	return bft.ReachConsensusWith(bft.SelectConsensusParticipants())
+}
+
+// RunConsensus simulates a voting round and reports whether it reached quorum
+//
+// Deprecated: use ReachConsensus, which reports why a round fails.
+func (bft *BFTManager) RunConsensus() bool {
+	return bft.ReachConsensus() == nil
 }
 
-// RunConsensus simulates a voting round
-func (bft *BFTManager) RunConsensus() {
+// ReachConsensusWith simulates a voting round among the given participants,
+// of which only the honest ones vote
+func (bft *BFTManager) ReachConsensusWith(participants []*Node) error {
 	fmt.Println("\nRunning BFT Consensus...")
-	participants := bft.SelectConsensusParticipants()
+	honest := 0
+	for _, node := range participants {
+		if !node.Byzantine {
+			honest++
+		}
+	}
 
-	if len(participants) >= (len(bft.Nodes)*2)/3 {
-		fmt.Printf("Consensus Reached with %d honest nodes\n", len(participants))
+	required := bft.RequiredVotes()
+	reached := honest >= required
+	if reached {
+		fmt.Printf("Consensus Reached with %d honest nodes\n", honest)
 	} else {
-		fmt.Printf("Consensus Failed (only %d honest nodes)\n", len(participants))
+		fmt.Printf("Consensus Failed (only %d honest nodes)\n", honest)
 	}
 
 	for _, node := range participants {
-		fmt.Printf("Node #%d | Reputation: %.2f\n", node.ID, node.Reputation)
+		fmt.Printf("Node #%d | Reputation: %.2f\n", node.ID, bft.Reputation(node))
+	}
+	if !reached {
+		return fmt.Errorf("%w: %d honest votes, need %d", ErrQuorumNotReached, honest, required)
+	}
+	return nil
+}
+
+// RequiredVotes returns the number of honest participants needed to reach
+// consensus, consulting the quorum source at the time of the round
+func (bft *BFTManager) RequiredVotes() int {
+	if bft.quorum != nil {
+		_, write := bft.quorum.CurrentQuorum(len(bft.Nodes))
+		return write
 	}
+	return (len(bft.Nodes) * 2) / 3
 }
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors returned when checking consensus messages
+var (
+	ErrInvalidMessage  = errors.New("invalid consensus message signature")
+	ErrMessageMismatch = errors.New("consensus message does not match its vote")
+	ErrReplayedMessage = errors.New("consensus message replayed")
+)
+
+// DefaultReplayWindow is how many recent sequence numbers a MessageGuard
+// remembers per sender
+const DefaultReplayWindow = 64
+
+// ConsensusMessageType is the BFT step a message belongs to
+type ConsensusMessageType string
+
+const (
+	ProposeMessage ConsensusMessageType = "propose"
+	PrepareMessage ConsensusMessageType = "prepare"
+	CommitMessage  ConsensusMessageType = "commit"
+)
+
+// ConsensusMessage is a signed envelope for a proposal or vote exchanged
+// between BFT nodes. Sequence increases with every message a sender signs,
+// so receivers can drop replays.
+type ConsensusMessage struct {
+	Type      ConsensusMessageType `json:"type"`
+	Sender    int                  `json:"sender"`
+	View      int                  `json:"view"`
+	Height    int                  `json:"height"`
+	Sequence  uint64               `json:"sequence"`
+	BlockHash string               `json:"block_hash"`
+	Vote      *Vote                `json:"vote,omitempty"` // For prepare and commit messages
+	Signature string               `json:"signature"`      // Hex ed25519 signature over the fields above
+}
+
+// consensusMessageBytes is the data a message signature covers; a vote is
+// covered through its own signature
+func consensusMessageBytes(m ConsensusMessage) []byte {
+	vote := ""
+	if m.Vote != nil {
+		vote = m.Vote.Signature
+	}
+	return []byte(fmt.Sprintf("%s:%d:%d:%d:%d:%s:%s", m.Type, m.Sender, m.View, m.Height, m.Sequence, m.BlockHash, vote))
+}
+
+// Digest identifies a message by a hash of its signed fields and signature
+func (m ConsensusMessage) Digest() string {
+	hash := sha256.Sum256(append(consensusMessageBytes(m), m.Signature...))
+	return hex.EncodeToString(hash[:])
+}
+
+// SignProposal wraps a proposal in a message signed with the node's next
+// sequence number
+func (n *Node) SignProposal(view, height int, blockHash string) ConsensusMessage {
+	return n.signMessage(ConsensusMessage{Type: ProposeMessage, View: view, Height: height, BlockHash: blockHash})
+}
+
+// SignVoteMessage wraps a vote in a message signed with the node's next
+// sequence number
+func (n *Node) SignVoteMessage(v Vote) ConsensusMessage {
+	t := PrepareMessage
+	if v.Type == CommitVote {
+		t = CommitMessage
+	}
+	return n.signMessage(ConsensusMessage{Type: t, View: v.View, Height: v.Height, BlockHash: v.BlockHash, Vote: &v})
+}
+
+// signMessage stamps and signs a message as the node
+func (n *Node) signMessage(m ConsensusMessage) ConsensusMessage {
+	m.Sender = n.ID
+	m.Sequence = atomic.AddUint64(&n.sequence, 1)
+	m.Signature = hex.EncodeToString(ed25519.Sign(n.signer, consensusMessageBytes(m)))
+	return m
+}
+
+// VerifyConsensusMessage checks a message's signature against its sender's
+// key and that a carried vote is the sender's vote for the same step
+func VerifyConsensusMessage(pub ed25519.PublicKey, m ConsensusMessage) error {
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: no key for node #%d", ErrInvalidMessage, m.Sender)
+	}
+	signature, err := hex.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(pub, consensusMessageBytes(m), signature) {
+		return fmt.Errorf("%w: node #%d sequence %d", ErrInvalidMessage, m.Sender, m.Sequence)
+	}
+	if m.Type == ProposeMessage {
+		if m.Vote != nil {
+			return fmt.Errorf("%w: proposal from node #%d carries a vote", ErrMessageMismatch, m.Sender)
+		}
+		return nil
+	}
+	v := m.Vote
+	if v == nil || v.NodeID != m.Sender || v.View != m.View || v.Height != m.Height || v.BlockHash != m.BlockHash ||
+		(v.Type == CommitVote) != (m.Type == CommitMessage) || !VerifyVote(pub, *v) {
+		return fmt.Errorf("%w: node #%d sequence %d", ErrMessageMismatch, m.Sender, m.Sequence)
+	}
+	return nil
+}
+
+// replayWindow is the sequence numbers seen from one sender: the highest,
+// and a bitmap of the window below and including it
+type replayWindow struct {
+	highest uint64
+	seen    []uint64 // Bit i of the bitmap is sequence highest-i
+}
+
+// MessageGuard checks consensus messages arriving at one node: each must be
+// signed by a member of the validator set of its height, and carry a
+// sequence number not seen before from its sender. Sequence numbers older
+// than the window are rejected as replays too.
+type MessageGuard struct {
+	keys    func(height int) map[int]ed25519.PublicKey
+	window  int
+	senders map[int]*replayWindow
+	mutex   sync.Mutex
+}
+
+// NewMessageGuard creates a guard verifying messages against bft's
+// validator sets and remembering window sequence numbers per sender; window
+// of zero or less uses DefaultReplayWindow
+func NewMessageGuard(bft *BFTManager, window int) *MessageGuard {
+	if window < 1 {
+		window = DefaultReplayWindow
+	}
+	return &MessageGuard{keys: bft.ValidatorKeysAt, window: window, senders: make(map[int]*replayWindow)}
+}
+
+// Check verifies a message and records its sequence number, returning an
+// error if it is invalid or a replay
+func (g *MessageGuard) Check(m ConsensusMessage) error {
+	pub, known := g.keys(m.Height)[m.Sender]
+	if !known {
+		return fmt.Errorf("%w: node #%d is not a validator at height %d", ErrInvalidMessage, m.Sender, m.Height)
+	}
+	if err := VerifyConsensusMessage(pub, m); err != nil {
+		return err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	w, exists := g.senders[m.Sender]
+	if !exists {
+		w = &replayWindow{seen: make([]uint64, (g.window+63)/64)}
+		g.senders[m.Sender] = w
+	}
+	if !w.record(m.Sequence, g.window) {
+		return fmt.Errorf("%w: node #%d sequence %d", ErrReplayedMessage, m.Sender, m.Sequence)
+	}
+	return nil
+}
+
+// record marks seq as seen, returning false if it was seen already or is
+// older than the window
+func (w *replayWindow) record(seq uint64, window int) bool {
+	if seq > w.highest {
+		w.shift(seq-w.highest, window)
+		w.highest = seq
+		w.seen[0] |= 1
+		return true
+	}
+	offset := w.highest - seq
+	if offset >= uint64(window) {
+		return false
+	}
+	word, bit := offset/64, offset%64
+	if w.seen[word]&(1<<bit) != 0 {
+		return false
+	}
+	w.seen[word] |= 1 << bit
+	return true
+}
+
+// shift moves the bitmap up by n sequence numbers
+func (w *replayWindow) shift(n uint64, window int) {
+	if n >= uint64(window) {
+		for i := range w.seen {
+			w.seen[i] = 0
+		}
+		return
+	}
+	words, bits := int(n/64), n%64
+	for i := len(w.seen) - 1; i >= 0; i-- {
+		var v uint64
+		if j := i - words; j >= 0 {
+			v = w.seen[j] << bits
+			if bits > 0 && j > 0 {
+				v |= w.seen[j-1] >> (64 - bits)
+			}
+		}
+		w.seen[i] = v
+	}
+}
+
+// RecordMisbehavior counts an invalid or replayed consensus message against
+// a node and lowers its reputation by ReputationPenalty
+func (bft *BFTManager) RecordMisbehavior(nodeID int) error {
+	node := bft.nodeByID(nodeID)
+	if node == nil {
+		return fmt.Errorf("%w: #%d", ErrUnknownNode, nodeID)
+	}
+	node.Misbehavior++
+	node.Reputation = math.Max(node.Reputation-ReputationPenalty, 0)
+	return nil
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConsensusMessagesAreAuthenticated(t *testing.T) {
+	bft := behaviorBFT(4)
+	keys := bft.PublicKeys()
+	node := bft.Nodes[1]
+
+	proposal := node.SignProposal(2, 5, "block-5")
+	vote := node.SignVoteMessage(node.SignVote(CommitVote, 2, 5, "block-5"))
+	if proposal.Sequence != 1 || vote.Sequence != 2 || vote.Type != CommitMessage {
+		t.Fatalf("sequences %d and %d, vote message %s", proposal.Sequence, vote.Sequence, vote.Type)
+	}
+	for _, m := range []ConsensusMessage{proposal, vote} {
+		if err := VerifyConsensusMessage(keys[1], m); err != nil {
+			t.Fatalf("%s message: %v", m.Type, err)
+		}
+	}
+
+	other := bft.Nodes[2].SignVote(CommitVote, 2, 5, "block-5")
+	for name, tc := range map[string]struct {
+		m    ConsensusMessage
+		edit func(m *ConsensusMessage)
+		want error
+	}{
+		"sender":     {vote, func(m *ConsensusMessage) { m.Sender = 2 }, ErrInvalidMessage},
+		"view":       {vote, func(m *ConsensusMessage) { m.View = 3 }, ErrInvalidMessage},
+		"sequence":   {vote, func(m *ConsensusMessage) { m.Sequence = 9 }, ErrInvalidMessage},
+		"signature":  {vote, func(m *ConsensusMessage) { m.Signature = proposal.Signature }, ErrInvalidMessage},
+		"garbled":    {vote, func(m *ConsensusMessage) { m.Signature = "not hex" }, ErrInvalidMessage},
+		"other vote": {vote, func(m *ConsensusMessage) { *m = node.SignVoteMessage(other) }, ErrMessageMismatch},
+		"vote step": {vote, func(m *ConsensusMessage) {
+			*m = node.signMessage(ConsensusMessage{Type: PrepareMessage, View: 2, Height: 5, BlockHash: "block-5", Vote: vote.Vote})
+		}, ErrMessageMismatch},
+		"no vote": {vote, func(m *ConsensusMessage) {
+			*m = node.signMessage(ConsensusMessage{Type: CommitMessage, View: 2, Height: 5, BlockHash: "block-5"})
+		}, ErrMessageMismatch},
+		"proposal": {proposal, func(m *ConsensusMessage) {
+			*m = node.signMessage(ConsensusMessage{Type: ProposeMessage, Vote: vote.Vote})
+		}, ErrMessageMismatch},
+	} {
+		m := tc.m
+		tc.edit(&m)
+		if err := VerifyConsensusMessage(keys[1], m); !errors.Is(err, tc.want) {
+			t.Errorf("%s changed: %v", name, err)
+		}
+	}
+	if err := VerifyConsensusMessage(nil, vote); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("without a key: %v", err)
+	}
+	if vote.Digest() == proposal.Digest() {
+		t.Fatal("distinct messages share a digest")
+	}
+}
+
+func TestMessageGuardDropsReplays(t *testing.T) {
+	bft := behaviorBFT(4)
+	guard := NewMessageGuard(bft, 130)
+	node := bft.Nodes[0]
+	var messages []ConsensusMessage
+	for i := 0; i < 200; i++ {
+		messages = append(messages, node.SignProposal(i, 1, "block-1"))
+	}
+
+	// Out of order within the window is fine; seen or too old is a replay
+	for _, i := range []int{5, 3, 4, 0, 140} {
+		if err := guard.Check(messages[i]); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	// Message 9 was never seen, but is 131 sequence numbers behind 140
+	for _, i := range []int{5, 3, 140, 9} {
+		if err := guard.Check(messages[i]); !errors.Is(err, ErrReplayedMessage) {
+			t.Fatalf("message %d replayed: %v", i, err)
+		}
+	}
+	// Sequence numbers remembered across a shift spanning bitmap words
+	if err := guard.Check(messages[80]); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Check(messages[150]); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Check(messages[80]); !errors.Is(err, ErrReplayedMessage) {
+		t.Fatalf("message 80 after the window moved: %v", err)
+	}
+	if err := guard.Check(messages[79]); err != nil {
+		t.Fatalf("message 79 never seen: %v", err)
+	}
+	if err := guard.Check(messages[140]); !errors.Is(err, ErrReplayedMessage) {
+		t.Fatalf("message 140 after the window moved: %v", err)
+	}
+
+	// Each sender has a window of its own, and strangers are refused
+	if err := guard.Check(bft.Nodes[1].SignProposal(0, 1, "block-1")); err != nil {
+		t.Fatal(err)
+	}
+	stranger := behaviorBFT(5).Nodes[4]
+	if err := guard.Check(stranger.SignProposal(0, 1, "block-1")); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("message from a node outside the validator set: %v", err)
+	}
+}
+
+func TestRecordMisbehavior(t *testing.T) {
+	bft := behaviorBFT(4)
+	for i := 0; i < 3; i++ {
+		if err := bft.RecordMisbehavior(2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := bft.Nodes[2]
+	if want := 0.5 - 3*ReputationPenalty; node.Misbehavior != 3 || node.Reputation < want-1e-9 || node.Reputation > want+1e-9 {
+		t.Fatalf("misbehavior %d, reputation %v", node.Misbehavior, node.Reputation)
+	}
+	node.Reputation = 0.01
+	bft.RecordMisbehavior(2)
+	if node.Reputation != 0 {
+		t.Fatalf("reputation %v below zero", node.Reputation)
+	}
+	if err := bft.RecordMisbehavior(42); !errors.Is(err, ErrUnknownNode) {
+		t.Fatalf("unknown node: %v", err)
+	}
+}
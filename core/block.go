@@ -1,44 +1,200 @@
 package core
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// MedianTimeSpan is the number of recent blocks used for median-time-past checks
+const MedianTimeSpan = 11
+
 type Block struct {
-	Index     int
-	Timestamp string
-	Data      string
-	PrevHash  string
-	Hash      string
+	Index        int
+	Timestamp    time.Time
+	Data         string
+	PrevHash     string
+	Hash         string
+	TxRoot       string        // Merkle root of Transactions, empty for data-only blocks
+	TxBloom      string        `json:",omitempty"` // Filter over transaction hashes and addresses, see TransactionBloom
+	StateRoot    string        // Ledger state root after applying Transactions and Mutations
+	Difficulty   uint64        `json:",omitempty"` // Proof-of-work difficulty, 0 when unscheduled
+	HashFunction string        `json:",omitempty"` // Hasher name of the chain, empty for SHA-256
+	Transactions []Transaction `json:",omitempty"`
+	DataHash     string        `json:",omitempty"` // Set only on header-only blocks, see BlockHeader
+
+	ValidatorChanges []ValidatorChange `json:",omitempty"` // Membership changes effective from the next height
+	Causality        *VectorClock      `json:",omitempty"` // Producer's vector clock, recording what the block causally follows
+	Affinity         string            `json:",omitempty"` // Key of blocks to co-locate in one shard, see AffinityPolicy
+	Priority         Priority          `json:",omitempty"` // Class the block's transfers are served in
+	MutationRoot     string            `json:",omitempty"` // Merkle root of Mutations, see MutationRoot
+	Mutations        []StateMutation   `json:",omitempty"` // Application state writes applied after Transactions
+}
+
+// encodeTimestamp gives the deterministic form of a timestamp used for hashing
+func encodeTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
 }
 
+// calculateHash hashes the header fields with the block's hash function,
+// returning "" if the function is unknown so that no hash matches
 func calculateHash(block Block) string {
-	record := string(block.Index) + block.Timestamp + block.Data + block.PrevHash
-	h := sha256.New()
-	h.Write([]byte(record))
-	return hex.EncodeToString(h.Sum(nil))
+	record := strconv.Itoa(block.Index) + encodeTimestamp(block.Timestamp) + block.Data + block.PrevHash + block.TxRoot + block.StateRoot
+	if block.TxBloom != "" {
+		record += "txbloom:" + block.TxBloom
+	}
+	if block.MutationRoot != "" {
+		record += "mutations:" + block.MutationRoot
+	}
+	if block.Difficulty > 0 {
+		// Only scheduled blocks commit to a difficulty, keeping older hashes
+		record += "difficulty:" + strconv.FormatUint(block.Difficulty, 10)
+	}
+	if len(block.ValidatorChanges) > 0 {
+		record += "validators:" + encodeValidatorChanges(block.ValidatorChanges)
+	}
+	if block.Causality != nil {
+		record += "causality:" + block.Causality.encode()
+	}
+	if block.Affinity != "" {
+		// Affinity is free text, so its length marks where it ends
+		record += "affinity:" + strconv.Itoa(len(block.Affinity)) + ":" + block.Affinity
+	}
+	if priority := block.Priority.hashed(); priority != "" {
+		record += "priority:" + priority
+	}
+	if block.HashFunction != "" {
+		// Blocks of SHA-256 chains leave it out, keeping their hashes
+		record += "hasher:" + block.HashFunction
+	}
+	h, err := LookupHasher(block.HashFunction)
+	if err != nil {
+		return ""
+	}
+	return hexSum(h, []byte(record))
+}
+
+// ComputeHash recomputes the block hash from its header fields
+func (b Block) ComputeHash() string {
+	return calculateHash(b)
+}
+
+// Age returns how long ago the block was created
+func (b Block) Age() time.Duration {
+	return time.Since(b.Timestamp)
+}
+
+// GenerateBlock creates the successor of prevBlock stamped with the current time
+func GenerateBlock(prevBlock Block, data string) (Block, error) {
+	return GenerateBlockAt(prevBlock, data, time.Now())
 }
 
-func GenerateBlock(prevBlock Block, data string) Block {
+// GenerateBlockAt creates the successor of prevBlock with an explicit timestamp,
+// rejecting timestamps earlier than the parent's and data over MaxBlockDataBytes
+func GenerateBlockAt(prevBlock Block, data string, timestamp time.Time) (Block, error) {
+	if err := (BlockLimits{MaxDataBytes: MaxBlockDataBytes}).checkData(prevBlock.Index+1, data); err != nil {
+		return Block{}, err
+	}
+	if timestamp.Before(prevBlock.Timestamp) {
+		return Block{}, fmt.Errorf("timestamp %s is earlier than parent #%d (%s)",
+			encodeTimestamp(timestamp), prevBlock.Index, encodeTimestamp(prevBlock.Timestamp))
+	}
 	newBlock := Block{
 		Index:     prevBlock.Index + 1,
-		Timestamp: time.Now().String(),
+		Timestamp: timestamp.Round(0), // Strip the monotonic clock reading
 		Data:      data,
 		PrevHash:  prevBlock.Hash,
+
+		HashFunction: prevBlock.HashFunction,
 	}
 	newBlock.Hash = calculateHash(newBlock)
-	return newBlock
+	return newBlock, nil
+}
+
+// GenerateTransactionBlock creates the successor of prevBlock carrying txs,
+// committing to them through the block's TxRoot and TxBloom and to the
+// resulting ledger state through its StateRoot. The block takes the highest
+// priority of txs.
+func GenerateTransactionBlock(prevBlock Block, txs []Transaction, stateRoot string) (Block, error) {
+	block, err := GenerateBlock(prevBlock, "")
+	if err != nil {
+		return Block{}, err
+	}
+	block.Transactions = append([]Transaction{}, txs...)
+	block.TxRoot = TransactionRoot(txs)
+	block.TxBloom = TransactionBloom(txs)
+	block.StateRoot = stateRoot
+	block.Priority = highestPriority(txs)
+	block.Data = "txs:" + block.TxRoot
+	block.Hash = calculateHash(block)
+	return block, nil
 }
 
+// GenesisBlock returns the genesis block of DefaultGenesisConfig
 func GenesisBlock() Block {
-	genesis := Block{
-		Index:     0,
-		Timestamp: time.Now().String(),
-		Data:      "Genesis Block",
-		PrevHash:  "",
-	}
-	genesis.Hash = calculateHash(genesis)
-	return genesis
+	return DefaultGenesisConfig().Block()
+}
+
+// MedianTimePast returns the median timestamp of the last MedianTimeSpan blocks
+func MedianTimePast(blocks []Block) time.Time {
+	if len(blocks) == 0 {
+		return time.Time{}
+	}
+	start := len(blocks) - MedianTimeSpan
+	if start < 0 {
+		start = 0
+	}
+	times := make([]time.Time, 0, len(blocks)-start)
+	for _, b := range blocks[start:] {
+		times = append(times, b.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[len(times)/2]
+}
+
+// LegacyBlock is the pre-migration block layout with a time.Time.String() timestamp
+type LegacyBlock struct {
+	Index     int
+	Timestamp string
+	Data      string
+	PrevHash  string
+	Hash      string
+}
+
+// ParseLegacyTimestamp parses the time.Time.String() format used by old chains,
+// including the optional monotonic clock suffix
+func ParseLegacyTimestamp(s string) (time.Time, error) {
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	return time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", s)
+}
+
+// MigrateLegacyChain converts previously serialized blocks to the current format.
+// Hashes are recomputed and PrevHash links rewritten since the preimage changed.
+func MigrateLegacyChain(legacy []LegacyBlock) ([]Block, error) {
+	blocks := make([]Block, 0, len(legacy))
+	for i, lb := range legacy {
+		timestamp, err := ParseLegacyTimestamp(lb.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("block #%d: %v", lb.Index, err)
+		}
+		b := Block{
+			Index:     lb.Index,
+			Timestamp: timestamp,
+			Data:      lb.Data,
+			PrevHash:  lb.PrevHash,
+		}
+		if i > 0 {
+			if lb.PrevHash != legacy[i-1].Hash {
+				return nil, fmt.Errorf("block #%d does not link to block #%d", lb.Index, legacy[i-1].Index)
+			}
+			b.PrevHash = blocks[i-1].Hash
+		}
+		b.Hash = calculateHash(b)
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
 }
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors returned when reattaching block bodies
+var (
+	ErrBodyMismatch = errors.New("block body does not match its header")
+	ErrBodyNotFound = errors.New("block body not found")
+)
+
+// BlockHeader is the fixed-size part of a block. DataHash commits to the
+// block's Data, and shard tree leaves hash the header (see Leaf), so shard
+// trees can be rebuilt from headers alone; the block hash itself still
+// covers the full Data and needs the body to check.
+type BlockHeader struct {
+	Index      int
+	Timestamp  time.Time
+	PrevHash   string
+	DataHash   string // Commitment to Data, see HashBlockData
+	TxRoot     string
+	TxBloom    string `json:",omitempty"`
+	StateRoot  string
+	Difficulty uint64 `json:",omitempty"`
+	Hash       string
+
+	HashFunction     string            `json:",omitempty"`
+	ValidatorChanges []ValidatorChange `json:",omitempty"`
+	Causality        *VectorClock      `json:",omitempty"`
+	Affinity         string            `json:",omitempty"`
+	Priority         Priority          `json:",omitempty"`
+	MutationRoot     string            `json:",omitempty"`
+}
+
+// BlockBody is the payload a BlockHeader leaves out
+type BlockBody struct {
+	Data         string
+	Transactions []Transaction   `json:",omitempty"`
+	Mutations    []StateMutation `json:",omitempty"`
+}
+
+// HashBlockData returns the commitment to a block's Data stored in
+// BlockHeader.DataHash
+func HashBlockData(data string) string {
+	return hashLeaf(defaultHasher, CurrentTreeVersion, data)
+}
+
+// Header returns the block's header
+func (b Block) Header() BlockHeader {
+	dataHash := b.DataHash
+	if !b.HeaderOnly() {
+		dataHash = HashBlockData(b.Data)
+	}
+	return BlockHeader{
+		Index:      b.Index,
+		Timestamp:  b.Timestamp,
+		PrevHash:   b.PrevHash,
+		DataHash:   dataHash,
+		TxRoot:     b.TxRoot,
+		TxBloom:    b.TxBloom,
+		StateRoot:  b.StateRoot,
+		Difficulty: b.Difficulty,
+		Hash:       b.Hash,
+
+		HashFunction:     b.HashFunction,
+		ValidatorChanges: append([]ValidatorChange(nil), b.ValidatorChanges...),
+		Causality:        b.Causality,
+		Affinity:         b.Affinity,
+		Priority:         b.Priority,
+		MutationRoot:     b.MutationRoot,
+	}
+}
+
+// Body returns the block's payload
+func (b Block) Body() BlockBody {
+	return BlockBody{
+		Data:         b.Data,
+		Transactions: append([]Transaction{}, b.Transactions...),
+		Mutations:    append([]StateMutation(nil), b.Mutations...),
+	}
+}
+
+// HeaderOnly reports whether the block's body has been stripped, leaving
+// DataHash in place of its Data
+func (b Block) HeaderOnly() bool {
+	return b.DataHash != "" && b.Data == "" && len(b.Transactions) == 0 && len(b.Mutations) == 0
+}
+
+// Block returns a header-only block, which shards store in place of the
+// full block when their bodies live in a BodyStore
+func (h BlockHeader) Block() Block {
+	return Block{
+		Index:      h.Index,
+		Timestamp:  h.Timestamp,
+		PrevHash:   h.PrevHash,
+		Hash:       h.Hash,
+		TxRoot:     h.TxRoot,
+		TxBloom:    h.TxBloom,
+		StateRoot:  h.StateRoot,
+		Difficulty: h.Difficulty,
+		DataHash:   h.DataHash,
+
+		HashFunction:     h.HashFunction,
+		ValidatorChanges: append([]ValidatorChange(nil), h.ValidatorChanges...),
+		Causality:        h.Causality,
+		Affinity:         h.Affinity,
+		Priority:         h.Priority,
+		MutationRoot:     h.MutationRoot,
+	}
+}
+
+// WithBody reassembles the full block, checking the body against DataHash,
+// TxRoot, MutationRoot and the block hash
+func (h BlockHeader) WithBody(body BlockBody) (Block, error) {
+	if HashBlockData(body.Data) != h.DataHash {
+		return Block{}, fmt.Errorf("%w: data of block %s", ErrBodyMismatch, h.Hash)
+	}
+	if (h.TxRoot != "" || len(body.Transactions) > 0) && TransactionRoot(body.Transactions) != h.TxRoot {
+		return Block{}, fmt.Errorf("%w: transactions of block %s", ErrBodyMismatch, h.Hash)
+	}
+	if (h.MutationRoot != "" || len(body.Mutations) > 0) && MutationRoot(body.Mutations) != h.MutationRoot {
+		return Block{}, fmt.Errorf("%w: mutations of block %s", ErrBodyMismatch, h.Hash)
+	}
+	block := h.Block()
+	block.DataHash = ""
+	block.Data = body.Data
+	block.Transactions = append([]Transaction{}, body.Transactions...)
+	block.Mutations = append([]StateMutation(nil), body.Mutations...)
+	if block.ComputeHash() != h.Hash {
+		return Block{}, fmt.Errorf("%w: hash of block %s", ErrBodyMismatch, h.Hash)
+	}
+	return block, nil
+}
+
+// Leaf returns the Merkle leaf the block contributes to a current-version
+// shard tree. It hashes the index, block hash, parent hash and DataHash, so
+// a shard root commits to each block's position and identity and not just
+// its Data.
+func (h BlockHeader) Leaf() string {
+	return hashLeaf(defaultHasher, CurrentTreeVersion, h.leafData())
+}
+
+// leafData is the preimage of a shard tree leaf
+func (h BlockHeader) leafData() string {
+	return fmt.Sprintf("block|%d|%s|%s|%s", h.Index, h.Hash, h.PrevHash, h.DataHash)
+}
+
+// blockLeaf returns the current-version shard tree leaf of a block, full or
+// header-only
+func blockLeaf(b Block) string {
+	return b.Header().Leaf()
+}
+
+// newBlockTree builds a current-version shard tree over blocks, full or
+// header-only; both give the same root.
+func newBlockTree(blocks []Block) *MerkleTree {
+	leaves := make([]string, len(blocks))
+	for i, b := range blocks {
+		leaves[i] = blockLeaf(b)
+	}
+	return NewMerkleTreeFromLeaves(leaves)
+}
+
+// BodyStore holds block bodies by block hash for shards that keep only
+// headers, e.g. on disk or on an archive node
+type BodyStore interface {
+	PutBody(hash string, body BlockBody) error
+	GetBody(hash string) (BlockBody, error)
+}
+
+// MemoryBodyStore is an in-memory BodyStore
+type MemoryBodyStore struct {
+	bodies map[string]BlockBody
+	mutex  sync.RWMutex
+}
+
+// NewMemoryBodyStore creates an empty store
+func NewMemoryBodyStore() *MemoryBodyStore {
+	return &MemoryBodyStore{bodies: make(map[string]BlockBody)}
+}
+
+// PutBody implements BodyStore
+func (s *MemoryBodyStore) PutBody(hash string, body BlockBody) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bodies[hash] = body
+	return nil
+}
+
+// GetBody implements BodyStore
+func (s *MemoryBodyStore) GetBody(hash string) (BlockBody, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	body, exists := s.bodies[hash]
+	if !exists {
+		return BlockBody{}, fmt.Errorf("%w: %s", ErrBodyNotFound, hash)
+	}
+	return body, nil
+}
+
+// Len returns the number of stored bodies
+func (s *MemoryBodyStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.bodies)
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// headerOnlyShard returns shard id holding blocks with their bodies in store
+func headerOnlyShard(t *testing.T, id int, blocks []Block, store BodyStore) *Shard {
+	t.Helper()
+	shard := NewShard(id)
+	for _, b := range blocks {
+		shard.AddBlock(b)
+	}
+	if err := shard.StoreHeadersOnly(store); err != nil {
+		t.Fatal(err)
+	}
+	return shard
+}
+
+func TestHeaderRootsMatchFullBlocks(t *testing.T) {
+	blocks := fixedBlocks(12)
+	full := NewShard(0)
+	for _, b := range blocks {
+		full.AddBlock(b)
+	}
+	store := NewMemoryBodyStore()
+	headers := headerOnlyShard(t, 0, blocks, store)
+
+	if headers.GetRoot() != full.GetRoot() {
+		t.Fatalf("header-only root %s, full root %s", headers.GetRoot(), full.GetRoot())
+	}
+	if store.Len() != len(blocks) {
+		t.Fatalf("%d bodies stored", store.Len())
+	}
+	for i, h := range headers.Headers() {
+		if h.DataHash != HashBlockData(blocks[i].Data) || !headers.View().Blocks[i].HeaderOnly() {
+			t.Fatalf("block %d kept as %+v", i, headers.View().Blocks[i])
+		}
+		if h.Leaf() != blockLeaf(blocks[i]) {
+			t.Fatalf("block %d leaf differs between header and full block", i)
+		}
+		b, err := headers.FullBlock(i)
+		if err != nil || b.Data != blocks[i].Data || b.ComputeHash() != blocks[i].Hash {
+			t.Fatalf("full block %d: %+v, %v", i, b, err)
+		}
+	}
+
+	// A header whose DataHash does not commit to the body changes the root
+	forged := make([]Block, len(blocks))
+	for i, b := range blocks {
+		forged[i] = b.Header().Block()
+	}
+	forged[5].DataHash = HashBlockData("other data")
+	if newBlockTree(forged).GetRootHash() == full.GetRoot() {
+		t.Fatal("root unchanged by a DataHash not matching the body")
+	}
+
+	// Managers storing headers only agree with full managers on every root
+	fullManager, headerManager := NewShardManager(), NewShardManager(WithHeaderOnlyShards(NewMemoryBodyStore()))
+	for _, b := range fixedBlocks(25) {
+		fullManager.DistributeBlock(b)
+		headerManager.DistributeBlock(b)
+	}
+	if fullManager.ForestRoot() != headerManager.ForestRoot() {
+		t.Fatal("forest roots differ between full and header-only shards")
+	}
+}
+
+func TestHeaderWithBodyChecksCommitments(t *testing.T) {
+	block := fixedBlocks(1)[0]
+	header := block.Header()
+	rebuilt, err := header.WithBody(block.Body())
+	if err != nil || rebuilt.Hash != block.Hash || rebuilt.HeaderOnly() {
+		t.Fatalf("reassembled %+v: %v", rebuilt, err)
+	}
+	if _, err := header.WithBody(BlockBody{Data: "swapped"}); !errors.Is(err, ErrBodyMismatch) {
+		t.Fatalf("swapped body: %v", err)
+	}
+	tx := []Transaction{{From: "a", To: "b", Amount: 1}}
+	if _, err := header.WithBody(BlockBody{Data: block.Data, Transactions: tx}); !errors.Is(err, ErrBodyMismatch) {
+		t.Fatalf("body with extra transactions: %v", err)
+	}
+
+	// A header whose hash was altered cannot take the honest body
+	header.Hash = calculateHash(Block{Data: "other"})
+	if _, err := header.WithBody(block.Body()); !errors.Is(err, ErrBodyMismatch) {
+		t.Fatalf("body under another hash: %v", err)
+	}
+}
+
+func TestHeaderOnlyShardMissingBody(t *testing.T) {
+	blocks := fixedBlocks(3)
+	store := NewMemoryBodyStore()
+	shard := headerOnlyShard(t, 0, blocks, store)
+
+	detached := NewShard(1)
+	detached.AddBlock(blocks[0].Header().Block())
+	if _, err := detached.FullBlock(0); !errors.Is(err, ErrBodyNotFound) {
+		t.Fatalf("header without a store: %v", err)
+	}
+	if _, err := shard.FullBlock(3); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("block past the end: %v", err)
+	}
+
+	empty := headerOnlyShard(t, 2, nil, NewMemoryBodyStore())
+	empty.AddBlock(blocks[0].Header().Block())
+	if _, err := empty.FullBlock(0); !errors.Is(err, ErrBodyNotFound) {
+		t.Fatalf("body missing from the store: %v", err)
+	}
+}
+
+func TestSyncBlockKeepsHeaderRoots(t *testing.T) {
+	blocks := fixedBlocks(8)
+	fullSource, fullDest := NewShard(0), NewShard(1)
+	for _, b := range blocks[:6] {
+		fullSource.AddBlock(b)
+	}
+	for _, b := range blocks[6:] {
+		fullDest.AddBlock(b)
+	}
+	store := NewMemoryBodyStore()
+	source, dest := headerOnlyShard(t, 0, blocks[:6], store), headerOnlyShard(t, 1, blocks[6:], store)
+
+	syncer := NewSyncManager()
+	if err := syncer.SyncBlock(fullSource, fullDest, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.SyncBlock(source, dest, 2); err != nil {
+		t.Fatal(err)
+	}
+	if source.GetRoot() != fullSource.GetRoot() || dest.GetRoot() != fullDest.GetRoot() {
+		t.Fatal("roots differ between full and header-only shards after a sync")
+	}
+	b, err := dest.FullBlock(len(dest.View().Blocks) - 1)
+	if err != nil || b.Hash != blocks[2].Hash || b.Data != blocks[2].Data {
+		t.Fatalf("moved block %+v: %v", b, err)
+	}
+}
+
+func TestArchivingMovesBodiesToStore(t *testing.T) {
+	store := NewMemoryBodyStore()
+	sm := NewStateManager(4, WithBodyArchive(store))
+	blocks := fixedBlocks(10)
+	for _, b := range blocks {
+		sm.AddBlock(b)
+	}
+
+	if len(sm.ActiveBlocks) != 4 || len(sm.PrunedBlocks) != 6 || store.Len() != 6 {
+		t.Fatalf("%d active, %d archived, %d bodies", len(sm.ActiveBlocks), len(sm.PrunedBlocks), store.Len())
+	}
+	for _, b := range sm.ActiveBlocks {
+		if b.HeaderOnly() {
+			t.Fatalf("active block #%d lost its body", b.Index)
+		}
+	}
+	for i, archived := range sm.PrunedBlocks {
+		if archived.Data != "" || archived.DataHash != HashBlockData(blocks[i].Data) {
+			t.Fatalf("archived block %+v", archived)
+		}
+		body, err := store.GetBody(archived.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if full, err := blocks[i].Header().WithBody(body); err != nil || full.Hash != blocks[i].Hash {
+			t.Fatalf("archived body of block %d: %v", i, err)
+		}
+	}
+}
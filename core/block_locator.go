@@ -0,0 +1,165 @@
+package core
+
+import "sync"
+
+// Default shape of the Bloom filters behind MightContain and LocateBlock.
+// Every shard filter has the same shape, so the forest filter can be their
+// union; 2^16 bits with 4 hash functions stay near a 1% false positive rate
+// up to about 6,800 blocks in the forest.
+const (
+	DefaultLocatorBits   = 1 << 16
+	DefaultLocatorHashes = 4
+)
+
+// WithLocatorFilter sizes the per-shard and forest-level Bloom filters used
+// to locate blocks; size them for the number of blocks in the whole forest
+func WithLocatorFilter(bits, hashFuncs uint) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.locatorBits = bits
+		sm.locatorFuncs = hashFuncs
+	}
+}
+
+// locatorCache holds the forest-level filter, the union of every shard's
+// locator filter, and the shard states it was built from. It is checked
+// against the shard index's change counter, so an unchanged forest costs
+// nothing to query. Shards that only gained blocks since are OR-ed in; it
+// is rebuilt from scratch when a shard is removed or replaced (rebalance,
+// merge) or lost blocks.
+type locatorCache struct {
+	filter  *BloomFilter
+	entries []forestEntry
+	filters []*BloomFilter // Shard filters, aligned with entries
+	index   *ShardIndex    // Index the cache was built from
+	changes uint64         // Index change count the cache reflects
+	mutex   sync.Mutex
+}
+
+// MightContain reports whether any shard may hold the block with the given
+// hash. It checks a single forest-level filter, so a false answer is
+// definite; a true one is wrong at about the filter's false positive rate.
+func (sm *ShardManager) MightContain(hash string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	forest, _, _ := sm.forestFilter()
+	return forest.Test(hash)
+}
+
+// LocateBlock returns the shards holding the block with the given hash,
+// searching only the shards whose filters pass
+func (sm *ShardManager) LocateBlock(hash string) []*Shard {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	forest, entries, filters := sm.forestFilter()
+	locs := forest.locations(hash)
+	if !forest.hasLocations(locs) {
+		return nil
+	}
+
+	var found []*Shard
+	for i, entry := range entries {
+		if !filters[i].hasLocations(locs) {
+			continue
+		}
+		shard := entry.shard
+		shard.mutex.Lock()
+		if shard.containsBlock(hash) {
+			found = append(found, shard)
+		}
+		shard.mutex.Unlock()
+	}
+	return found
+}
+
+// forestFilter returns the forest-level filter and the shard filters it is
+// the union of, bringing them up to date with the shards; the caller must
+// hold the manager lock
+func (sm *ShardManager) forestFilter() (*BloomFilter, []forestEntry, []*BloomFilter) {
+	sm.locator.mutex.Lock()
+	defer sm.locator.mutex.Unlock()
+
+	// Read the counter first: a change racing the snapshot moves it past
+	// the recorded value, so the next query takes a new snapshot
+	changes := sm.Shards.changeCount()
+	if sm.locator.filter != nil && sm.locator.index == sm.Shards && sm.locator.changes == changes {
+		return sm.locator.filter, sm.locator.entries, sm.locator.filters
+	}
+
+	shards := sm.Shards.GetAllShards()
+	entries := make([]forestEntry, len(shards))
+	filters := make([]*BloomFilter, len(shards))
+	built := make([]uint64, len(shards))
+	for i, shard := range shards {
+		shard.mutex.Lock()
+		filters[i] = shard.locatorFilter(sm.locatorBits, sm.locatorFuncs)
+		entries[i] = forestEntry{shard: shard, version: shard.version}
+		built[i] = shard.filterBuilt
+		shard.mutex.Unlock()
+	}
+
+	// Shards that gained blocks, or are new, can be OR-ed into the cached
+	// filter; anything that cleared bits needs a full rebuild
+	previous := make(map[*Shard]uint64, len(sm.locator.entries))
+	for _, entry := range sm.locator.entries {
+		previous[entry.shard] = entry.version
+	}
+	current := make(map[*Shard]bool, len(entries))
+	for _, entry := range entries {
+		current[entry.shard] = true
+	}
+	incremental := sm.locator.filter != nil
+	for shard := range previous {
+		if !current[shard] {
+			incremental = false
+		}
+	}
+	var grown []*BloomFilter
+	for i, entry := range entries {
+		version, seen := previous[entry.shard]
+		switch {
+		case !seen:
+			grown = append(grown, filters[i])
+		case version == entry.version:
+		case built[i] <= version:
+			grown = append(grown, filters[i])
+		default:
+			incremental = false
+		}
+	}
+
+	if !incremental {
+		sm.locator.filter = NewBloomFilter(sm.locatorBits, sm.locatorFuncs)
+		grown = filters
+	}
+	for _, filter := range grown {
+		sm.locator.filter.Union(filter)
+	}
+	sm.locator.entries, sm.locator.filters = entries, filters
+	sm.locator.index, sm.locator.changes = sm.Shards, changes
+	return sm.locator.filter, entries, filters
+}
+
+// locatorFilter returns the shard's filter over its block hashes, rebuilding
+// it if blocks were removed or replaced since; the caller must hold the
+// shard mutex. addBlock keeps an up-to-date filter current.
+func (s *Shard) locatorFilter(bits, hashFuncs uint) *BloomFilter {
+	if s.filter == nil || s.filterAt != s.version || s.filter.size != bits || s.filter.hashFuncs != hashFuncs {
+		s.filter = NewBloomFilter(bits, hashFuncs)
+		for _, b := range s.Blocks {
+			s.filter.Add(b.Hash)
+		}
+		s.filterAt, s.filterBuilt = s.version, s.version
+	}
+	return s.filter
+}
+
+// containsBlock reports whether the shard has a block with the given hash;
+// the caller must hold the shard mutex
+func (s *Shard) containsBlock(hash string) bool {
+	for _, b := range s.Blocks {
+		if b.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+// checkLocated fails unless every block is located in exactly one shard of
+// the manager's current layout, and a hash never distributed is not
+func checkLocated(t *testing.T, sm *ShardManager, blocks []Block) {
+	t.Helper()
+	current := make(map[*Shard]bool)
+	for _, shard := range sm.Shards.GetAllShards() {
+		current[shard] = true
+	}
+	for _, b := range blocks {
+		if !sm.MightContain(b.Hash) {
+			t.Fatalf("forest filter misses block #%d", b.Index)
+		}
+		found := sm.LocateBlock(b.Hash)
+		if len(found) != 1 || !current[found[0]] {
+			t.Fatalf("block #%d located in %d shards", b.Index, len(found))
+		}
+		found[0].mutex.Lock()
+		held := found[0].containsBlock(b.Hash)
+		found[0].mutex.Unlock()
+		if !held {
+			t.Fatalf("block #%d located in shard #%d, which does not hold it", b.Index, found[0].ID)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if found := sm.LocateBlock(fmt.Sprintf("missing-%d", i)); found != nil {
+			t.Fatalf("hash never distributed located in %d shards", len(found))
+		}
+	}
+}
+
+func TestLocateBlockAcrossRebalanceAndMerge(t *testing.T) {
+	for _, strategy := range []SplitStrategy{MidpointSplit, HashRangeSplit} {
+		t.Run(string(strategy), func(t *testing.T) {
+			blocks := fixedBlocks(120)
+			sm := NewShardManager(WithSplitStrategy(strategy))
+
+			// Query between blocks so the forest filter is grown in place
+			// as well as rebuilt after splits
+			for i, b := range blocks {
+				sm.DistributeBlock(b)
+				if !sm.MightContain(b.Hash) {
+					t.Fatalf("block #%d missed right after distribution", b.Index)
+				}
+				if i%10 == 9 {
+					checkLocated(t, sm, blocks[:i+1])
+				}
+			}
+			shards := sm.Shards.Size()
+
+			// Splitting every shard replaces them all
+			if err := sm.SetThresholds(1, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := sm.RebalanceShards(); err != nil {
+				t.Fatal(err)
+			}
+			if sm.Shards.Size() <= shards {
+				t.Fatalf("%d shards after rebalancing %d", sm.Shards.Size(), shards)
+			}
+			checkLocated(t, sm, blocks)
+
+			// Merging drops shards from the layout
+			shards = sm.Shards.Size()
+			if err := sm.MergeShards(len(blocks)); err != nil {
+				t.Fatal(err)
+			}
+			if sm.Shards.Size() >= shards {
+				t.Fatalf("%d shards after merging %d", sm.Shards.Size(), shards)
+			}
+			checkLocated(t, sm, blocks)
+		})
+	}
+}
+
+func TestRemoveBlockDropsItFromItsShard(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	blocks := fixedBlocks(10)
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+	root := sm.ForestRoot()
+	if !sm.RemoveBlock(blocks[6].Hash) || sm.RemoveBlock(blocks[6].Hash) {
+		t.Fatal("removal reported the wrong block state")
+	}
+	if _, held := sm.FindBlockShard(blocks[6].Hash); held || len(sm.LocateBlock(blocks[6].Hash)) != 0 {
+		t.Fatal("removed block still found")
+	}
+	if sm.ForestRoot() == root {
+		t.Fatal("forest root unchanged by the removal")
+	}
+	for i, b := range blocks {
+		if _, held := sm.FindBlockShard(b.Hash); i != 6 && !held {
+			t.Fatalf("block %d lost", i)
+		}
+	}
+	if errs := sm.VerifyAll(1); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestLocateBlockFollowsTransfer(t *testing.T) {
+	sm, source, destination := transferSetup(t)
+	view := source.View()
+	moved := view.Blocks[0]
+	if found := sm.LocateBlock(moved.Hash); len(found) != 1 || found[0] != source {
+		t.Fatalf("block located in %d shards before the transfer", len(found))
+	}
+
+	if err := sm.TransferBlock(NewEnhancedSyncManager("key"), source.ID, destination.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The source lost a block, so its filter is rebuilt and no longer
+	// passes the moved block
+	found := sm.LocateBlock(moved.Hash)
+	if len(found) != 1 || found[0].ID != destination.ID {
+		t.Fatalf("moved block located in %d shards", len(found))
+	}
+	source.mutex.Lock()
+	filter := source.locatorFilter(DefaultLocatorBits, DefaultLocatorHashes)
+	source.mutex.Unlock()
+	if filter.Test(moved.Hash) {
+		t.Fatal("source filter still passes the moved block")
+	}
+
+	var blocks []Block
+	for _, shard := range sm.Shards.GetAllShards() {
+		blocks = append(blocks, shard.View().Blocks...)
+	}
+	checkLocated(t, sm, blocks)
+}
+
+// locatorBenchManager returns a manager of at least 1,000 shards and the
+// blocks it holds
+func locatorBenchManager(b *testing.B) (*ShardManager, []Block) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 2); err != nil {
+		b.Fatal(err)
+	}
+	blocks := fixedBlocks(1200)
+	for _, block := range blocks {
+		sm.DistributeBlock(block)
+	}
+	if sm.Shards.Size() < 1000 {
+		b.Fatalf("%d shards, want at least 1,000", sm.Shards.Size())
+	}
+	return sm, blocks
+}
+
+func BenchmarkLocateBlock(b *testing.B) {
+	sm, blocks := locatorBenchManager(b)
+	sm.LocateBlock(blocks[0].Hash) // Build the forest filter
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(sm.LocateBlock(blocks[i%len(blocks)].Hash)) != 1 {
+			b.Fatal("block not located")
+		}
+	}
+}
+
+func BenchmarkLocateBlockScan(b *testing.B) {
+	sm, blocks := locatorBenchManager(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hash := blocks[i%len(blocks)].Hash
+		var found []*Shard
+		for _, shard := range sm.Shards.GetAllShards() {
+			shard.mutex.Lock()
+			if shard.containsBlock(hash) {
+				found = append(found, shard)
+			}
+			shard.mutex.Unlock()
+		}
+		if len(found) != 1 {
+			b.Fatal("block not located")
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Errors returned when a block breaks the chain's data limits
+var (
+	ErrBlockTooLarge    = errors.New("block data too large")
+	ErrInvalidBlockData = errors.New("block data is not valid UTF-8")
+)
+
+// MaxBlockDataBytes is the most data GenerateBlock accepts for any chain,
+// so oversized input is refused before it is hashed
+const MaxBlockDataBytes = 32 << 20
+
+// DefaultMaxBlockDataBytes is the per-block data limit of new chains
+const DefaultMaxBlockDataBytes = 1 << 20
+
+// BlockValidator is a rule a chain applies to every block it accepts,
+// whether produced locally or received from a peer
+type BlockValidator interface {
+	ValidateBlock(b Block) error
+}
+
+// BlockValidatorFunc adapts a function to a BlockValidator
+type BlockValidatorFunc func(b Block) error
+
+// ValidateBlock calls f(b)
+func (f BlockValidatorFunc) ValidateBlock(b Block) error {
+	return f(b)
+}
+
+// BlockLimits bounds the data a chain accepts in a block
+type BlockLimits struct {
+	MaxDataBytes int  `json:"max_data_bytes"` // Zero for no limit below MaxBlockDataBytes
+	RequireUTF8  bool `json:"require_utf8"`
+}
+
+// DefaultBlockLimits are the limits of new chains
+func DefaultBlockLimits() BlockLimits {
+	return BlockLimits{MaxDataBytes: DefaultMaxBlockDataBytes}
+}
+
+// ValidateBlock checks the block's data against the limits
+func (l BlockLimits) ValidateBlock(b Block) error {
+	return l.checkData(b.Index, b.Data)
+}
+
+// checkData checks data destined for the block at index
+func (l BlockLimits) checkData(index int, data string) error {
+	if l.MaxDataBytes > 0 && len(data) > l.MaxDataBytes {
+		return fmt.Errorf("%w: %w: block #%d has %d bytes, limit %d", ErrInvalidBlock, ErrBlockTooLarge, index, len(data), l.MaxDataBytes)
+	}
+	if l.RequireUTF8 && !utf8.ValidString(data) {
+		return fmt.Errorf("%w: %w: block #%d", ErrInvalidBlock, ErrInvalidBlockData, index)
+	}
+	return nil
+}
+
+// SetBlockLimits replaces the data limits checked on new blocks; blocks
+// already in the chain are not rechecked
+func (bc *Blockchain) SetBlockLimits(limits BlockLimits) {
+	bc.limits = limits
+}
+
+// BlockLimits returns the data limits checked on new blocks
+func (bc *Blockchain) BlockLimits() BlockLimits {
+	return bc.limits
+}
+
+// AddBlockValidator adds a rule checked, after the data limits, on every
+// block AddBlock, AddBlockWithTransactions and AddBlockCandidate accept
+func (bc *Blockchain) AddBlockValidator(v BlockValidator) {
+	bc.validators = append(bc.validators, v)
+}
+
+// validateBlock runs the data limits and custom validators on a new block
+func (bc *Blockchain) validateBlock(b Block) error {
+	if err := bc.limits.ValidateBlock(b); err != nil {
+		return err
+	}
+	for _, v := range bc.validators {
+		if err := v.ValidateBlock(b); err != nil {
+			return fmt.Errorf("%w: block #%d: %w", ErrInvalidBlock, b.Index, err)
+		}
+	}
+	return nil
+}
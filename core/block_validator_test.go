@@ -0,0 +1,108 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBlockDataLimitBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		limit int
+		size  int
+		want  error
+	}{
+		{16, 16, nil},
+		{16, 17, ErrBlockTooLarge},
+		{DefaultMaxBlockDataBytes, DefaultMaxBlockDataBytes, nil},
+		{DefaultMaxBlockDataBytes, DefaultMaxBlockDataBytes + 1, ErrBlockTooLarge},
+		{0, DefaultMaxBlockDataBytes + 1, nil},
+	} {
+		bc := NewBlockchain()
+		if tc.limit != DefaultMaxBlockDataBytes {
+			bc.SetBlockLimits(BlockLimits{MaxDataBytes: tc.limit})
+		}
+		err := bc.AddBlock(strings.Repeat("x", tc.size))
+		if !errors.Is(err, tc.want) || (tc.want != nil && !errors.Is(err, ErrInvalidBlock)) {
+			t.Fatalf("%d bytes under limit %d: %v, want %v", tc.size, tc.limit, err, tc.want)
+		}
+		if added := len(bc.Blocks) == 2; added != (tc.want == nil) {
+			t.Fatalf("%d bytes under limit %d: chain of %d blocks", tc.size, tc.limit, len(bc.Blocks))
+		}
+	}
+
+	// GenerateBlock has a hard limit whatever the chain's
+	genesis := GenesisBlock()
+	if _, err := GenerateBlock(genesis, strings.Repeat("x", MaxBlockDataBytes)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateBlock(genesis, strings.Repeat("x", MaxBlockDataBytes+1)); !errors.Is(err, ErrBlockTooLarge) {
+		t.Fatalf("block over the hard limit: %v", err)
+	}
+}
+
+func TestBlockDataRequiresUTF8(t *testing.T) {
+	bc := NewBlockchain()
+	if err := bc.AddBlock("caf\xe9"); err != nil {
+		t.Fatalf("invalid UTF-8 rejected without RequireUTF8: %v", err)
+	}
+	bc.SetBlockLimits(BlockLimits{MaxDataBytes: 64, RequireUTF8: true})
+	if bc.BlockLimits().MaxDataBytes != 64 {
+		t.Fatalf("limits %+v", bc.BlockLimits())
+	}
+	if err := bc.AddBlock("caf\xe9"); !errors.Is(err, ErrInvalidBlockData) {
+		t.Fatalf("invalid UTF-8 under RequireUTF8: %v", err)
+	}
+	if err := bc.AddBlock("café"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultGenesisConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Data = "caf\xe9"
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidBlockData) {
+		t.Fatalf("genesis data that is not UTF-8: %v", err)
+	}
+}
+
+// jsonPayload accepts only blocks whose data is a JSON object
+var jsonPayload = BlockValidatorFunc(func(b Block) error {
+	var payload map[string]any
+	return json.Unmarshal([]byte(b.Data), &payload)
+})
+
+func TestCustomBlockValidator(t *testing.T) {
+	bc := NewBlockchain()
+	nonEmpty := BlockValidatorFunc(func(b Block) error {
+		if b.Data == "" {
+			return errors.New("empty block")
+		}
+		return nil
+	})
+	bc.AddBlockValidator(nonEmpty)
+	bc.AddBlockValidator(jsonPayload)
+
+	for _, data := range []string{"", "not json", "[1]"} {
+		if err := bc.AddBlock(data); !errors.Is(err, ErrInvalidBlock) {
+			t.Fatalf("block %q: %v", data, err)
+		}
+	}
+	if err := bc.AddBlock(`{"kind":"ok"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks from elsewhere go through the same rules
+	candidate := mustGenerateBlock(t, bc.Blocks[1], "not json")
+	if err := bc.AddBlockCandidate(candidate); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("candidate breaking a validator: %v", err)
+	}
+	if len(bc.Blocks) != 2 {
+		t.Fatalf("chain of %d blocks, want 2", len(bc.Blocks))
+	}
+	if err := bc.AddBlockCandidate(mustGenerateBlock(t, bc.Blocks[1], `{}`)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,18 +1,680 @@
 package core
 
+import (
+	"crypto/ed25519"
+	"fmt"
+	"math/big"
+)
+
+// MaxOrphanBlocks bounds the pool of blocks whose parent is not yet known
+const MaxOrphanBlocks = 64
+
 type Blockchain struct {
-	Blocks []Block
+	Blocks       []Block
+	sideBlocks   map[string][]Block           // Competing blocks keyed by PrevHash
+	orphans      []Block                      // Blocks waiting for their parent to arrive
+	genesisState *LedgerState                 // Account state before the first block
+	state        *LedgerState                 // Account state at the tip of the main chain
+	heights      *RBTree[int, string]         // Main-chain block hash by height
+	checkpoints  *CheckpointManager           // Optional; checkpoints the main chain
+	certificates map[string]CommitCertificate // Commit certificates keyed by block hash
+	chainID      string                       // From the genesis config
+	hashFunction string                       // From the genesis config, empty for SHA-256
+	difficulty   *DifficultySchedule          // Optional proof-of-work retargeting
+	finality     *FinalityTracker             // Optional; see NewFinalityTracker
+	limits       BlockLimits                  // Data limits checked on new blocks
+	validators   []BlockValidator             // Custom rules checked on new blocks
 }
 
 func NewBlockchain() *Blockchain {
-	genesis := GenesisBlock()
+	return NewBlockchainFromGenesis(DefaultGenesisConfig())
+}
+
+// NewBlockchainFromGenesis starts a chain from a genesis config; nodes using
+// the same config share the same genesis block and can sync with each other
+func NewBlockchainFromGenesis(cfg GenesisConfig) *Blockchain {
+	state := cfg.State()
+	genesis := cfg.Block()
+	state.recordRoot(genesis.Index)
 	return &Blockchain{
-		Blocks: []Block{genesis},
+		Blocks:       []Block{genesis},
+		sideBlocks:   make(map[string][]Block),
+		orphans:      []Block{},
+		genesisState: state,
+		state:        state.Clone(),
+		chainID:      cfg.ChainID,
+		hashFunction: cfg.HashFunction,
+		limits:       DefaultBlockLimits(),
+	}
+}
+
+// SetGenesisState sets the account allocations the chain starts from and
+// replays the main chain on top of them
+func (bc *Blockchain) SetGenesisState(state *LedgerState) error {
+	if genesis := bc.Blocks[0]; genesis.Index == 0 && genesis.StateRoot != "" && genesis.StateRoot != state.StateRoot() {
+		return fmt.Errorf("genesis block: %w", ErrStateRootMismatch)
+	}
+	genesisState := state.Clone()
+	genesisState.history = NewRootHistory()
+	genesisState.recordRoot(bc.Blocks[0].Index)
+	current := genesisState.Clone()
+	for _, b := range bc.Blocks[1:] {
+		next, err := applyBlockState(current, b)
+		if err != nil {
+			return err
+		}
+		current = next
+		current.recordRoot(b.Index)
+	}
+	bc.genesisState = genesisState
+	bc.state = current
+	return nil
+}
+
+// State returns a copy of the account state at the tip of the main chain
+func (bc *Blockchain) State() *LedgerState {
+	return bc.ledger().Clone()
+}
+
+// ledger returns the tip state, creating it for zero-value chains
+func (bc *Blockchain) ledger() *LedgerState {
+	if bc.state == nil {
+		bc.genesisState = NewLedgerState()
+		bc.state = bc.genesisState.Clone()
+	}
+	return bc.state
+}
+
+// applyBlockState replays a block's transactions and then its mutations on a
+// copy of state and checks the result against the block's StateRoot.
+// Data-only blocks leave state as is.
+func applyBlockState(state *LedgerState, b Block) (*LedgerState, error) {
+	if len(b.Transactions) == 0 && len(b.Mutations) == 0 && b.StateRoot == "" {
+		return state, nil
+	}
+	next := state.Clone()
+	if err := next.ApplyTransactions(b.Transactions); err != nil {
+		return nil, fmt.Errorf("block #%d: %w", b.Index, err)
+	}
+	if err := next.ApplyMutations(b.Mutations); err != nil {
+		return nil, fmt.Errorf("block #%d: %w", b.Index, err)
 	}
+	if b.StateRoot != "" && next.StateRoot() != b.StateRoot {
+		return nil, fmt.Errorf("block #%d: %w", b.Index, ErrStateRootMismatch)
+	}
+	return next, nil
 }
 
-func (bc *Blockchain) AddBlock(data string) {
+// stateAt rebuilds the account state after the main-chain block at index i
+func (bc *Blockchain) stateAt(i int) (*LedgerState, error) {
+	bc.ledger()
+	state := bc.genesisState
+	for _, b := range bc.Blocks[1 : i+1] {
+		next, err := applyBlockState(state, b)
+		if err != nil {
+			return nil, err
+		}
+		state = next
+	}
+	return state, nil
+}
+
+func (bc *Blockchain) AddBlock(data string) error {
+	return bc.addDataBlock(data, "")
+}
+
+// AddBlockWithAffinity appends a data block recording affinityKey, which
+// an AffinityPolicy shard manager uses to keep the key's blocks together
+func (bc *Blockchain) AddBlockWithAffinity(data, affinityKey string) error {
+	return bc.addDataBlock(data, affinityKey)
+}
+
+// addDataBlock implements AddBlock and AddBlockWithAffinity
+func (bc *Blockchain) addDataBlock(data, affinityKey string) error {
+	prevBlock := bc.Blocks[len(bc.Blocks)-1]
+	if err := bc.limits.checkData(prevBlock.Index+1, data); err != nil {
+		return err
+	}
+	newBlock, err := GenerateBlock(prevBlock, data)
+	if err != nil {
+		return err
+	}
+	if affinityKey != "" {
+		newBlock.Affinity = affinityKey
+		newBlock.Hash = calculateHash(newBlock)
+	}
+	if err := bc.validateBlock(newBlock); err != nil {
+		return err
+	}
+	bc.stampDifficulty(&newBlock)
+	if mtp := MedianTimePast(bc.Blocks); newBlock.Timestamp.Before(mtp) {
+		return fmt.Errorf("%w: block #%d timestamp is before median time past", ErrInvalidBlock, newBlock.Index)
+	}
+	bc.appendMain(newBlock, bc.ledger())
+	return nil
+}
+
+// appendMain extends the main chain and its height index; state is the
+// account state after the block
+func (bc *Blockchain) appendMain(b Block, state *LedgerState) {
+	bc.heightIndex().Insert(b.Index, b.Hash)
+	bc.Blocks = append(bc.Blocks, b)
+	bc.state = state
+	state.recordRoot(b.Index)
+	if bc.checkpoints != nil {
+		bc.checkpoints.observe(b, state.StateRoot())
+	}
+}
+
+// SetCheckpointManager checkpoints the main chain from now on. The chain is
+// replayed from genesis to seed the cumulative chain hash, so the manager
+// must be attached before any pruning.
+func (bc *Blockchain) SetCheckpointManager(cm *CheckpointManager) error {
+	if len(bc.Blocks) == 0 || bc.Blocks[0].Index != 0 {
+		return fmt.Errorf("checkpoints need the chain from genesis")
+	}
+	bc.ledger()
+	state := bc.genesisState
+	for _, b := range bc.Blocks {
+		next, err := applyBlockState(state, b)
+		if err != nil {
+			return err
+		}
+		state = next
+		cm.observe(b, state.StateRoot())
+	}
+	bc.checkpoints = cm
+	return nil
+}
+
+// Checkpoints returns the attached checkpoint manager, or nil
+func (bc *Blockchain) Checkpoints() *CheckpointManager {
+	return bc.checkpoints
+}
+
+// AttachCertificate stores the commit certificate for a block held by the
+// chain, on the main chain or a side branch
+func (bc *Blockchain) AttachCertificate(cert CommitCertificate) error {
+	b, exists := bc.findBlock(cert.BlockHash)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrBlockNotFound, cert.BlockHash)
+	}
+	if b.Index != cert.Height {
+		return fmt.Errorf("%w: certificate height %d for block #%d", ErrCertificateMismatch, cert.Height, b.Index)
+	}
+	if bc.certificates == nil {
+		bc.certificates = make(map[string]CommitCertificate)
+	}
+	bc.certificates[cert.BlockHash] = cert
+	return nil
+}
+
+// Certificate returns the commit certificate stored for a block
+func (bc *Blockchain) Certificate(hash string) (CommitCertificate, bool) {
+	cert, exists := bc.certificates[hash]
+	return cert, exists
+}
+
+// ValidateOption configures Blockchain.Validate
+type ValidateOption func(*validateConfig)
+
+type validateConfig struct {
+	certificateKeys map[int]ed25519.PublicKey
+}
+
+// WithCertificateKeys additionally requires every block after genesis to
+// carry a commit certificate that verifies against the validator set:
+// pubkeys at first, updated by the ValidatorChanges of each block for the
+// blocks after it
+func WithCertificateKeys(pubkeys map[int]ed25519.PublicKey) ValidateOption {
+	return func(c *validateConfig) {
+		c.certificateKeys = pubkeys
+	}
+}
+
+// Validate checks the main chain from genesis: every block must carry a
+// valid hash and transaction root, link to its parent, follow the
+// difficulty schedule if one is set and apply cleanly to the account state
+// before it
+func (bc *Blockchain) Validate(opts ...ValidateOption) error {
+	var cfg validateConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	bc.ledger()
+	state := bc.genesisState
+	validators := cfg.certificateKeys
+	it := bc.Iterator(0)
+	var prev Block
+	for i := 0; ; i++ {
+		b, ok := it.Next()
+		if !ok {
+			return it.Err()
+		}
+		if b.HashFunction != bc.hashFunction {
+			return fmt.Errorf("block #%d: %w", b.Index, ErrHasherMismatch)
+		}
+		if b.Hash != b.ComputeHash() {
+			return fmt.Errorf("%w: block #%d has invalid hash %s", ErrInvalidBlock, b.Index, b.Hash)
+		}
+		if b.TxRoot != "" && b.TxRoot != TransactionRoot(b.Transactions) {
+			return fmt.Errorf("%w: block #%d transactions do not match its TxRoot", ErrInvalidBlock, b.Index)
+		}
+		if err := checkTxBloom(b); err != nil {
+			return err
+		}
+		if err := checkMutationRoot(b); err != nil {
+			return err
+		}
+		if i == 0 {
+			prev = b
+			continue
+		}
+		if b.Index != prev.Index+1 || b.PrevHash != prev.Hash {
+			return fmt.Errorf("%w: block #%d does not follow #%d", ErrInvalidBlock, b.Index, prev.Index)
+		}
+		prev = b
+		if err := bc.checkDifficulty(bc.Blocks, i); err != nil {
+			return err
+		}
+		next, err := applyBlockState(state, b)
+		if err != nil {
+			return fmt.Errorf("block #%d: %w", b.Index, err)
+		}
+		state = next
+
+		if cfg.certificateKeys == nil {
+			continue
+		}
+		cert, exists := bc.certificates[b.Hash]
+		if !exists {
+			return fmt.Errorf("%w: #%d", ErrMissingCertificate, b.Index)
+		}
+		if cert.Height != b.Index {
+			return fmt.Errorf("%w: certificate height %d for block #%d", ErrCertificateMismatch, cert.Height, b.Index)
+		}
+		if err := VerifyCommitCertificate(cert, validators); err != nil {
+			return fmt.Errorf("block #%d: %w", b.Index, err)
+		}
+		if len(b.ValidatorChanges) > 0 {
+			if validators, err = applyValidatorChanges(validators, b.ValidatorChanges); err != nil {
+				return fmt.Errorf("block #%d: %w", b.Index, err)
+			}
+		}
+	}
+}
+
+// heightIndex returns the height index, rebuilding it if Blocks was changed
+// directly (e.g. by pruning)
+func (bc *Blockchain) heightIndex() *RBTree[int, string] {
+	if bc.heights == nil || bc.heights.Size() != len(bc.Blocks) || !bc.tipIndexed() {
+		bc.heights = NewRBTree[int, string]()
+		for _, b := range bc.Blocks {
+			bc.heights.Insert(b.Index, b.Hash)
+		}
+	}
+	return bc.heights
+}
+
+// tipIndexed reports whether the index agrees with the current tip
+func (bc *Blockchain) tipIndexed() bool {
+	if len(bc.Blocks) == 0 {
+		return true
+	}
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	hash, exists := bc.heights.Get(tip.Index)
+	return exists && hash == tip.Hash
+}
+
+// HashAtHeight returns the hash of the main-chain block at a height
+func (bc *Blockchain) HashAtHeight(height int) (string, bool) {
+	return bc.heightIndex().Get(height)
+}
+
+// HashesInRange returns main-chain hashes for heights lo through hi
+func (bc *Blockchain) HashesInRange(lo, hi int) []string {
+	var hashes []string
+	for _, entry := range bc.heightIndex().Range(lo, hi) {
+		hashes = append(hashes, entry.Value)
+	}
+	return hashes
+}
+
+// AddBlockWithTransactions applies a batch of transactions to the account
+// state and appends a block embedding the resulting state root. Nothing
+// changes if any transaction fails.
+func (bc *Blockchain) AddBlockWithTransactions(txs []Transaction) (Block, error) {
+	return bc.addTransactionBlock(txs, nil, nil)
+}
+
+// addTransactionBlock implements AddBlockWithTransactions and
+// AddBlockWithMutations, stamping the block with causality if it is not nil
+func (bc *Blockchain) addTransactionBlock(txs []Transaction, mutations []StateMutation, causality *VectorClock) (Block, error) {
+	next := bc.ledger().Clone()
+	if err := next.ApplyTransactions(txs); err != nil {
+		return Block{}, err
+	}
+	if err := next.ApplyMutations(mutations); err != nil {
+		return Block{}, err
+	}
+
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := GenerateBlock(prevBlock, data)
-	bc.Blocks = append(bc.Blocks, newBlock)
+	newBlock, err := GenerateStateBlock(prevBlock, txs, mutations, next.StateRoot())
+	if err != nil {
+		return Block{}, err
+	}
+	if causality != nil {
+		newBlock.Causality = causality
+		newBlock.Hash = calculateHash(newBlock)
+	}
+	if err := bc.validateBlock(newBlock); err != nil {
+		return Block{}, err
+	}
+	bc.stampDifficulty(&newBlock)
+	if mtp := MedianTimePast(bc.Blocks); newBlock.Timestamp.Before(mtp) {
+		return Block{}, fmt.Errorf("%w: block #%d timestamp is before median time past", ErrInvalidBlock, newBlock.Index)
+	}
+	bc.appendMain(newBlock, next)
+	return newBlock, nil
+}
+
+// AddBlockCandidate accepts a block that may extend the tip, a side branch or
+// an unknown parent. Side blocks are kept until ResolveForks picks a chain.
+func (bc *Blockchain) AddBlockCandidate(b Block) error {
+	if bc.sideBlocks == nil {
+		bc.sideBlocks = make(map[string][]Block)
+	}
+	if b.Hash != calculateHash(b) {
+		return fmt.Errorf("%w: block #%d has invalid hash %s", ErrInvalidBlock, b.Index, b.Hash)
+	}
+	if b.TxRoot != "" && b.TxRoot != TransactionRoot(b.Transactions) {
+		return fmt.Errorf("%w: block #%d transactions do not match its TxRoot", ErrInvalidBlock, b.Index)
+	}
+	if err := checkTxBloom(b); err != nil {
+		return err
+	}
+	if err := checkMutationRoot(b); err != nil {
+		return err
+	}
+	if err := bc.checkChainBlock(b); err != nil {
+		return err
+	}
+	if err := bc.validateBlock(b); err != nil {
+		return err
+	}
+	if _, exists := bc.findBlock(b.Hash); exists {
+		return nil // Already known
+	}
+	for _, o := range bc.orphans {
+		if o.Hash == b.Hash {
+			return nil
+		}
+	}
+
+	parent, exists := bc.findBlock(b.PrevHash)
+	if !exists {
+		// Hold as orphan, evicting the oldest when the pool is full
+		if len(bc.orphans) >= MaxOrphanBlocks {
+			bc.orphans = bc.orphans[1:]
+		}
+		bc.orphans = append(bc.orphans, b)
+		return nil
+	}
+	if b.Index != parent.Index+1 {
+		return fmt.Errorf("%w: block #%d does not follow parent #%d", ErrInvalidBlock, b.Index, parent.Index)
+	}
+	if b.Timestamp.Before(parent.Timestamp) {
+		return fmt.Errorf("%w: block #%d timestamp is earlier than its parent", ErrInvalidBlock, b.Index)
+	}
+
+	if err := bc.attach(b); err != nil {
+		return err
+	}
+	bc.connectOrphans(b.Hash)
+	return nil
+}
+
+// attach links a block whose parent is known into the chain or a side branch.
+// Blocks extending the tip are replayed against the account state; side
+// blocks are replayed when a reorganization applies them.
+func (bc *Blockchain) attach(b Block) error {
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if b.PrevHash == tip.Hash {
+		if err := bc.checkNextDifficulty(bc.Blocks, b); err != nil {
+			return err
+		}
+		next, err := applyBlockState(bc.ledger(), b)
+		if err != nil {
+			return err
+		}
+		bc.appendMain(b, next)
+		return nil
+	}
+	bc.sideBlocks[b.PrevHash] = append(bc.sideBlocks[b.PrevHash], b)
+	return nil
+}
+
+// connectOrphans attaches any orphans descending from the given parent hash
+func (bc *Blockchain) connectOrphans(parentHash string) {
+	queue := []string{parentHash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		parent, _ := bc.findBlock(hash)
+		var remaining []Block
+		for _, o := range bc.orphans {
+			if o.PrevHash == hash && o.Index == parent.Index+1 {
+				// Orphans whose transactions do not replay are dropped
+				if bc.attach(o) == nil {
+					queue = append(queue, o.Hash)
+				}
+			} else if o.PrevHash != hash {
+				remaining = append(remaining, o)
+			}
+		}
+		bc.orphans = remaining
+	}
+}
+
+// findBlock looks up a block by hash in the main chain and side branches
+func (bc *Blockchain) findBlock(hash string) (Block, bool) {
+	if i := bc.mainIndex(hash); i >= 0 {
+		return bc.Blocks[i], true
+	}
+	for _, blocks := range bc.sideBlocks {
+		for _, b := range blocks {
+			if b.Hash == hash {
+				return b, true
+			}
+		}
+	}
+	return Block{}, false
+}
+
+// HasSideBlock reports whether a block is held on a side branch
+func (bc *Blockchain) HasSideBlock(hash string) bool {
+	if bc.mainIndex(hash) >= 0 {
+		return false
+	}
+	_, exists := bc.findBlock(hash)
+	return exists
+}
+
+// mainIndex returns the position of a hash on the main chain, or -1
+func (bc *Blockchain) mainIndex(hash string) int {
+	for i := len(bc.Blocks) - 1; i >= 0; i-- {
+		if bc.Blocks[i].Hash == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// sideTips returns side blocks that no other known block builds on
+func (bc *Blockchain) sideTips() []Block {
+	var tips []Block
+	for _, blocks := range bc.sideBlocks {
+		for _, b := range blocks {
+			if len(bc.sideBlocks[b.Hash]) == 0 {
+				tips = append(tips, b)
+			}
+		}
+	}
+	return tips
+}
+
+// ResolveForks switches to the best known branch if it strictly beats the
+// main chain: with a difficulty schedule set, the one with the most
+// accumulated difficulty, otherwise, or if no branch carries difficulty,
+// the longest. It returns the rolled back and applied blocks, both empty
+// when nothing changes.
+func (bc *Blockchain) ResolveForks() ([]Block, []Block, error) {
+	best := bc.Blocks[len(bc.Blocks)-1]
+	bestWork := bc.chainWork(best)
+	for _, tip := range bc.sideTips() {
+		if work := bc.chainWork(tip); beats(tip, work, best, bestWork) {
+			best, bestWork = tip, work
+		}
+	}
+	if bc.mainIndex(best.Hash) >= 0 {
+		return nil, nil, nil
+	}
+	return bc.Reorganize(best)
+}
+
+// chainWork returns the summed difficulty of the blocks from the oldest
+// held one up to tip, or nil without a difficulty schedule or if tip does
+// not connect to the main chain
+func (bc *Blockchain) chainWork(tip Block) *big.Int {
+	if bc.difficulty == nil {
+		return nil
+	}
+	work := new(big.Int)
+	current := tip
+	for {
+		if i := bc.mainIndex(current.Hash); i >= 0 {
+			for _, b := range bc.Blocks[:i+1] {
+				work.Add(work, new(big.Int).SetUint64(b.Difficulty))
+			}
+			return work
+		}
+		work.Add(work, new(big.Int).SetUint64(current.Difficulty))
+		parent, exists := bc.findBlock(current.PrevHash)
+		if !exists {
+			return nil
+		}
+		current = parent
+	}
+}
+
+// beats reports whether the branch ending at tip, with chain work work,
+// beats the one ending at best. Work decides when both branches have it
+// and either is nonzero; length decides otherwise.
+func beats(tip Block, work *big.Int, best Block, bestWork *big.Int) bool {
+	if work != nil && bestWork != nil && (work.Sign() > 0 || bestWork.Sign() > 0) {
+		return work.Cmp(bestWork) > 0
+	}
+	return tip.Index > best.Index
+}
+
+// Reorganize makes newTip the head of the main chain. It returns the blocks
+// rolled back from the old chain and the blocks applied from the new branch.
+// Branches forking below a checkpoint or the last final block are refused.
+func (bc *Blockchain) Reorganize(newTip Block) ([]Block, []Block, error) {
+	// Walk back from the new tip until we meet the main chain
+	var branch []Block
+	current := newTip
+	fork := bc.mainIndex(current.Hash)
+	for fork < 0 {
+		branch = append([]Block{current}, branch...)
+		parent, exists := bc.findBlock(current.PrevHash)
+		if !exists {
+			return nil, nil, fmt.Errorf("block %s does not connect to the chain", current.Hash)
+		}
+		current = parent
+		fork = bc.mainIndex(current.Hash)
+	}
+	if len(branch) == 0 {
+		return nil, nil, fmt.Errorf("block %s is already on the main chain", newTip.Hash)
+	}
+	if bc.checkpoints != nil {
+		if latest, exists := bc.checkpoints.LatestCheckpoint(); exists && bc.Blocks[fork].Index < latest.Height {
+			return nil, nil, fmt.Errorf("%w at height %d", ErrReorgBeyondCheckpoint, latest.Height)
+		}
+	}
+	if bc.finality != nil {
+		if final := bc.finality.LastFinalHeight(); bc.Blocks[fork].Index < final {
+			return nil, nil, fmt.Errorf("%w: fork at #%d, final up to #%d", ErrFinalityViolation, bc.Blocks[fork].Index, final)
+		}
+	}
+
+	candidate := append(bc.Blocks[:fork+1:fork+1], branch...)
+	for i := fork + 1; i < len(candidate); i++ {
+		if err := bc.checkDifficulty(candidate, i); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Replay the branch on the state at the fork before switching
+	state, err := bc.stateAt(fork)
+	if err != nil {
+		return nil, nil, err
+	}
+	states := make([]*LedgerState, len(branch))
+	for i, b := range branch {
+		if state, err = applyBlockState(state, b); err != nil {
+			return nil, nil, err
+		}
+		states[i] = state
+	}
+
+	rolledBack := make([]Block, len(bc.Blocks)-fork-1)
+	copy(rolledBack, bc.Blocks[fork+1:])
+
+	// Applied blocks leave the side pool, rolled back blocks join it
+	for _, b := range branch {
+		bc.removeSideBlock(b)
+	}
+	index := bc.heightIndex()
+	for _, b := range rolledBack {
+		index.Delete(b.Index)
+	}
+	bc.Blocks = append(bc.Blocks[:fork+1:fork+1], branch...)
+	for _, b := range branch {
+		index.Insert(b.Index, b.Hash)
+	}
+	for _, b := range rolledBack {
+		bc.sideBlocks[b.PrevHash] = append(bc.sideBlocks[b.PrevHash], b)
+	}
+	bc.state = state
+	state.history.rewind(bc.Blocks[fork].Index)
+	for i, b := range branch {
+		states[i].recordRoot(b.Index)
+	}
+	if bc.checkpoints != nil {
+		bc.checkpoints.rewind(bc.Blocks, fork)
+		for i, b := range branch {
+			bc.checkpoints.observe(b, states[i].StateRoot())
+		}
+	}
+
+	return rolledBack, branch, nil
+}
+
+// removeSideBlock drops a block from the side pool
+func (bc *Blockchain) removeSideBlock(b Block) {
+	siblings := bc.sideBlocks[b.PrevHash]
+	for i, s := range siblings {
+		if s.Hash == b.Hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(bc.sideBlocks, b.PrevHash)
+	} else {
+		bc.sideBlocks[b.PrevHash] = siblings
+	}
 }
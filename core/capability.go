@@ -0,0 +1,156 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned when issuing and checking capability tokens
+var (
+	ErrCapabilitiesDisabled = errors.New("capabilities not enabled")
+	ErrMissingCapability    = errors.New("missing capability token")
+	ErrCapabilityExpired    = errors.New("capability token expired")
+	ErrCapabilityWrongShard = errors.New("capability token for another shard")
+	ErrCapabilityRights     = errors.New("capability token lacks rights")
+	ErrInvalidCapability    = errors.New("invalid capability token signature")
+)
+
+// Rights is a set of operations a capability token allows on a shard
+type Rights uint
+
+const (
+	// RightRead allows reading a shard's blocks and state
+	RightRead Rights = 1 << iota
+	// RightTransfer allows moving blocks or value into or out of a shard
+	RightTransfer
+)
+
+// Has reports whether every right in want is in r
+func (r Rights) Has(want Rights) bool {
+	return r&want == want
+}
+
+func (r Rights) String() string {
+	var names []string
+	if r.Has(RightRead) {
+		names = append(names, "read")
+	}
+	if r.Has(RightTransfer) {
+		names = append(names, "transfer")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Token is a capability granting rights on one shard until it expires,
+// signed with the issuing manager's capability key
+type Token struct {
+	ShardID   int       `json:"shard_id"`
+	Rights    Rights    `json:"rights"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MAC       string    `json:"mac"` // "kid:hexmac" over signedData
+}
+
+// signedData is the part of a token its MAC covers
+func (t Token) signedData() string {
+	return fmt.Sprintf("capability|%d|%d|%d", t.ShardID, t.Rights, t.ExpiresAt.UnixNano())
+}
+
+// CapabilityVerifier checks that a token grants rights on a shard;
+// ShardManager is the default implementation
+type CapabilityVerifier interface {
+	VerifyCapability(token Token, shardID int, rights Rights) error
+}
+
+// WithCapabilityKey lets the manager issue and verify capability tokens,
+// signed with auth's active key. Rotating auth keeps older tokens valid
+// until they expire.
+func WithCapabilityKey(auth *HomomorphicAuthenticator) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.capabilities = auth
+	}
+}
+
+// IssueCapability returns a token granting rights on an existing shard for ttl
+func (sm *ShardManager) IssueCapability(shardID int, rights Rights, ttl time.Duration) (Token, error) {
+	if sm.capabilities == nil {
+		return Token{}, ErrCapabilitiesDisabled
+	}
+	if ttl <= 0 {
+		return Token{}, fmt.Errorf("invalid capability lifetime %v", ttl)
+	}
+	if _, err := sm.FindShard(shardID); err != nil {
+		return Token{}, err
+	}
+	token := Token{ShardID: shardID, Rights: rights, ExpiresAt: time.Now().Add(ttl)}
+	token.MAC = sm.capabilities.AuthenticateData(token.signedData())
+	return token, nil
+}
+
+// VerifyCapability checks that token was issued by the manager, has not
+// expired, and grants rights on shardID
+func (sm *ShardManager) VerifyCapability(token Token, shardID int, rights Rights) error {
+	if sm.capabilities == nil {
+		return ErrCapabilitiesDisabled
+	}
+	if !time.Now().Before(token.ExpiresAt) {
+		return fmt.Errorf("%w: shard #%d at %s", ErrCapabilityExpired, token.ShardID, token.ExpiresAt.Format(time.RFC3339))
+	}
+	if token.ShardID != shardID {
+		return fmt.Errorf("%w: token for #%d used on #%d", ErrCapabilityWrongShard, token.ShardID, shardID)
+	}
+	if !token.Rights.Has(rights) {
+		return fmt.Errorf("%w: has %s, needs %s on shard #%d", ErrCapabilityRights, token.Rights, rights, shardID)
+	}
+	if !sm.capabilities.VerifyAuthentication(token.signedData(), token.MAC) {
+		return fmt.Errorf("%w: shard #%d", ErrInvalidCapability, shardID)
+	}
+	return nil
+}
+
+// RequireCapabilities makes block and value transfers present tokens with
+// RightTransfer on both shards, checked by verifier before any shard is
+// locked. A nil verifier lifts the requirement.
+func (esm *EnhancedSyncManager) RequireCapabilities(verifier CapabilityVerifier) {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	esm.capabilities = verifier
+}
+
+// checkTransferCapabilities finds tokens for the source and destination
+// among tokens and verifies both, if capabilities are required
+func (esm *EnhancedSyncManager) checkTransferCapabilities(source, destination *Shard, tokens []Token) error {
+	esm.mutex.Lock()
+	verifier := esm.capabilities
+	esm.mutex.Unlock()
+	if verifier == nil {
+		return nil
+	}
+	for _, shard := range []*Shard{source, destination} {
+		if err := verifyAny(verifier, shard.ID, tokens); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyAny accepts the first token valid for shardID. Otherwise it returns
+// why a token naming the shard failed, or failing that why the first token
+// did, e.g. it was issued for another shard.
+func verifyAny(verifier CapabilityVerifier, shardID int, tokens []Token) error {
+	err := fmt.Errorf("%w: shard #%d", ErrMissingCapability, shardID)
+	for _, token := range tokens {
+		tokenErr := verifier.VerifyCapability(token, shardID, RightTransfer)
+		if tokenErr == nil {
+			return nil
+		}
+		if token.ShardID == shardID || errors.Is(err, ErrMissingCapability) {
+			err = tokenErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// capabilitySetup returns a manager issuing tokens, a sync manager requiring
+// them and two shards holding blocks
+func capabilitySetup(t *testing.T) (*ShardManager, *EnhancedSyncManager, *Shard, *Shard) {
+	t.Helper()
+	sm := NewShardManager(WithCapabilityKey(NewHomomorphicAuthenticator("capability-key")))
+	bc := NewBlockchain()
+	for i := 0; i < 6; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks {
+		sm.DistributeBlock(b)
+	}
+	shards := sm.Shards.GetAllShards()
+	if len(shards) < 3 {
+		t.Fatalf("chain distributed over %d shards, want at least 3", len(shards))
+	}
+	esm := NewEnhancedSyncManager("key")
+	esm.RequireCapabilities(sm)
+	return sm, esm, shards[0], shards[1]
+}
+
+// issue returns a token or fails the test
+func issue(t *testing.T, sm *ShardManager, shardID int, rights Rights, ttl time.Duration) Token {
+	t.Helper()
+	token, err := sm.IssueCapability(shardID, rights, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTransferWithValidCapabilities(t *testing.T) {
+	sm, esm, source, destination := capabilitySetup(t)
+	tokens := []Token{
+		issue(t, sm, source.ID, RightTransfer, time.Minute),
+		issue(t, sm, destination.ID, RightRead|RightTransfer, time.Minute),
+	}
+	before := len(source.Blocks)
+
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); !errors.Is(err, ErrMissingCapability) {
+		t.Fatalf("transfer without tokens: %v", err)
+	}
+	// The source's token does not cover the destination
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1, tokens[0]); !errors.Is(err, ErrCapabilityWrongShard) {
+		t.Fatalf("transfer without a destination token: %v", err)
+	}
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1, tokens...); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(source.Blocks) != before-1 {
+		t.Fatalf("source holds %d blocks after the transfer, had %d", len(source.Blocks), before)
+	}
+
+	// Lifting the requirement lets transfers through without tokens
+	esm.RequireCapabilities(nil)
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferCapabilityRejections(t *testing.T) {
+	sm, esm, source, destination := capabilitySetup(t)
+	third := sm.Shards.GetAllShards()[2]
+	destToken := issue(t, sm, destination.ID, RightTransfer, time.Minute)
+
+	expired := issue(t, sm, source.ID, RightTransfer, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	tampered := issue(t, sm, source.ID, RightRead, time.Minute)
+	tampered.Rights = RightRead | RightTransfer
+
+	extended := issue(t, sm, source.ID, RightTransfer, time.Minute)
+	extended.ExpiresAt = extended.ExpiresAt.Add(time.Hour)
+
+	forged := issue(t, sm, third.ID, RightTransfer, time.Minute)
+	forged.ShardID = source.ID
+
+	other := NewShardManager(WithCapabilityKey(NewHomomorphicAuthenticator("other-key")))
+	for _, b := range fixedBlocks(4) {
+		other.DistributeBlock(b)
+	}
+	foreign, err := other.IssueCapability(source.ID, RightTransfer, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token Token
+		want  error
+	}{
+		{"expired", expired, ErrCapabilityExpired},
+		{"wrong shard", issue(t, sm, third.ID, RightTransfer, time.Minute), ErrCapabilityWrongShard},
+		{"read only", issue(t, sm, source.ID, RightRead, time.Minute), ErrCapabilityRights},
+		{"tampered rights", tampered, ErrInvalidCapability},
+		{"tampered expiry", extended, ErrInvalidCapability},
+		{"tampered shard", forged, ErrInvalidCapability},
+		{"another key", foreign, ErrInvalidCapability},
+	} {
+		// Holding the source shard's lock shows the token is rejected
+		// before any shard is locked
+		source.mutex.Lock()
+		result := make(chan error, 1)
+		go func() {
+			result <- esm.CreateAuthenticatedTransfer(source, destination, 1, tc.token, destToken)
+		}()
+		select {
+		case err := <-result:
+			source.mutex.Unlock()
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("%s token: %v, want %v", tc.name, err, tc.want)
+			}
+		case <-time.After(5 * time.Second):
+			source.mutex.Unlock()
+			t.Fatalf("%s token: transfer waited for the shard lock", tc.name)
+		}
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); !errors.Is(err, ErrTransferNotPrepared) {
+		t.Fatalf("transfer prepared with invalid tokens: %v", err)
+	}
+}
+
+func TestTransferValueRequiresCapabilities(t *testing.T) {
+	sm := NewShardManager(WithCapabilityKey(NewHomomorphicAuthenticator("capability-key")))
+	for _, data := range testLeaves(12) {
+		sm.DistributeBlock(mustGenerateBlock(t, GenesisBlock(), data))
+	}
+	sm.PartitionAddressSpace()
+	from, fromOK := sm.ShardForAddress("\x01a")
+	to, toOK := sm.ShardForAddress("\xf0b")
+	if !fromOK || !toOK || from == to {
+		t.Fatal("sender and recipient not on two shards")
+	}
+	if err := from.Credit("\x01a", 100); err != nil {
+		t.Fatal(err)
+	}
+	esm := NewEnhancedSyncManager("key")
+	esm.RequireCapabilities(sm)
+	tx := Transaction{From: "\x01a", To: "\xf0b", Amount: 10}
+
+	wrong := issue(t, sm, to.ID, RightTransfer, time.Minute)
+	if err := sm.TransferValue(esm, tx, wrong, wrong); !errors.Is(err, ErrCapabilityWrongShard) {
+		t.Fatalf("value transfer with the recipient's token only: %v", err)
+	}
+	if from.BalanceOf("\x01a") != 100 {
+		t.Fatal("rejected transfer debited the sender")
+	}
+	if err := sm.TransferValue(esm, tx, issue(t, sm, from.ID, RightTransfer, time.Minute), wrong); err != nil {
+		t.Fatal(err)
+	}
+	if from.BalanceOf("\x01a") != 90 || to.BalanceOf("\xf0b") != 10 {
+		t.Fatalf("balances %d and %d after the transfer", from.BalanceOf("\x01a"), to.BalanceOf("\xf0b"))
+	}
+}
+
+func TestIssueCapability(t *testing.T) {
+	if _, err := NewShardManager().IssueCapability(0, RightRead, time.Minute); !errors.Is(err, ErrCapabilitiesDisabled) {
+		t.Fatalf("issuing without a key: %v", err)
+	}
+	sm, _, _, _ := capabilitySetup(t)
+	if _, err := sm.IssueCapability(0, RightRead, 0); err == nil {
+		t.Fatal("token issued without a lifetime")
+	}
+	if _, err := sm.IssueCapability(9999, RightRead, time.Minute); !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("token for a missing shard: %v", err)
+	}
+	if got := (RightRead | RightTransfer).String(); got != "read|transfer" {
+		t.Fatalf("rights print as %q", got)
+	}
+}
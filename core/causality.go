@@ -0,0 +1,167 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrCausalityViolation is returned when blocks are ordered against the
+// causal order their vector clocks record
+var ErrCausalityViolation = errors.New("block violates causal order")
+
+// ClockOrder is how two vector clocks are causally related
+type ClockOrder string
+
+const (
+	ClockEqual      ClockOrder = "equal"
+	ClockBefore     ClockOrder = "before" // Happened before the other clock
+	ClockAfter      ClockOrder = "after"  // Happened after the other clock
+	ClockConcurrent ClockOrder = "concurrent"
+)
+
+// Compare reports how vc is causally related to other
+func (vc *VectorClock) Compare(other *VectorClock) ClockOrder {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+
+	less, greater := false, false
+	for nodeID, t := range vc.clock {
+		if o := other.clock[nodeID]; t < o {
+			less = true
+		} else if t > o {
+			greater = true
+		}
+	}
+	for nodeID, o := range other.clock {
+		if vc.clock[nodeID] < o {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return ClockConcurrent
+	case less:
+		return ClockBefore
+	case greater:
+		return ClockAfter
+	default:
+		return ClockEqual
+	}
+}
+
+// encode is the deterministic form of the clock used for block hashing:
+// quoted node IDs in sorted order with their counters, zeros left out
+func (vc *VectorClock) encode() string {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+
+	nodeIDs := make([]string, 0, len(vc.clock))
+	for nodeID, t := range vc.clock {
+		if t > 0 {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+	sort.Strings(nodeIDs)
+	parts := make([]string, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		parts[i] = strconv.Quote(nodeID) + "=" + strconv.FormatUint(vc.clock[nodeID], 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// MarshalJSON encodes the clock as an object of counters by node ID
+func (vc *VectorClock) MarshalJSON() ([]byte, error) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+	return json.Marshal(vc.clock)
+}
+
+// UnmarshalJSON decodes a clock written by MarshalJSON
+func (vc *VectorClock) UnmarshalJSON(data []byte) error {
+	clock := make(map[string]uint64)
+	if err := json.Unmarshal(data, &clock); err != nil {
+		return err
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.clock = clock
+	return nil
+}
+
+// Tick records a local event of nodeID, e.g. producing a block, and returns
+// a copy of the resulting clock
+func (acm *AdaptiveCapacityManager) Tick(nodeID string) *VectorClock {
+	acm.mu.Lock()
+	defer acm.mu.Unlock()
+	acm.vectorClock.Update(nodeID)
+	return acm.vectorClock.Clone()
+}
+
+// ValidateCausalOrder checks that every main-chain block stamped with a
+// vector clock happened after the stamped block before it. Unstamped
+// blocks are skipped.
+func (bc *Blockchain) ValidateCausalOrder() error {
+	var prev *Block
+	for i := range bc.Blocks {
+		b := &bc.Blocks[i]
+		if b.Causality == nil {
+			continue
+		}
+		if prev != nil && b.Causality.Compare(prev.Causality) != ClockAfter {
+			return fmt.Errorf("%w: block #%d is not causally after #%d", ErrCausalityViolation, b.Index, prev.Index)
+		}
+		prev = b
+	}
+	return nil
+}
+
+// latestClock returns the clock of the shard's last stamped block, or nil;
+// the caller must hold the shard mutex
+func (s *Shard) latestClock() *VectorClock {
+	for i := len(s.Blocks) - 1; i >= 0; i-- {
+		if s.Blocks[i].Causality != nil {
+			return s.Blocks[i].Causality
+		}
+	}
+	return nil
+}
+
+// CheckCausalOrder reports whether appending a block stamped with clock
+// after a shard's latest stamped block respects the consistency level.
+// Under Causal a block must not have happened before the latest one, since
+// the latest may depend on it; Strong also rejects concurrent blocks, whose
+// order is undetermined. Eventual accepts any order, as do unstamped blocks.
+func CheckCausalOrder(level ConsistencyLevel, clock, latest *VectorClock) error {
+	if clock == nil || latest == nil || level == Eventual {
+		return nil
+	}
+	order := clock.Compare(latest)
+	if order == ClockBefore || (level == Strong && order == ClockConcurrent) {
+		return fmt.Errorf("%w: block is %s the destination's latest block under %s consistency", ErrCausalityViolation, order, level)
+	}
+	return nil
+}
+
+// EnforceCausalOrder makes transfers check, under co's current level, that
+// the moved block may follow the destination's latest block; see
+// CheckCausalOrder. A nil orchestrator disables the check.
+func (esm *EnhancedSyncManager) EnforceCausalOrder(co *ConsistencyOrchestrator) {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	esm.consistency = co
+}
+
+// checkCausality applies EnforceCausalOrder to a transfer; the caller must
+// hold the manager mutex and both shard mutexes
+func (esm *EnhancedSyncManager) checkCausality(block Block, destination *Shard) error {
+	if esm.consistency == nil {
+		return nil
+	}
+	return CheckCausalOrder(esm.consistency.Level(), block.Causality, destination.latestClock())
+}
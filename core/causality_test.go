@@ -0,0 +1,137 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// clockOf builds a vector clock from counters by node ID
+func clockOf(counters map[string]uint64) *VectorClock {
+	vc := NewVectorClock()
+	for nodeID, t := range counters {
+		vc.clock[nodeID] = t
+	}
+	return vc
+}
+
+// stampedBlock returns a block after prev stamped with a clock
+func stampedBlock(t *testing.T, prev Block, data string, counters map[string]uint64) Block {
+	t.Helper()
+	b := mustGenerateBlock(t, prev, data)
+	b.Causality = clockOf(counters)
+	b.Hash = calculateHash(b)
+	return b
+}
+
+func TestProducerStampsCausality(t *testing.T) {
+	bc := fundedChain(t, map[string]uint64{"alice": 1000})
+	mp := NewMempool(100)
+	bp := NewBlockProducer(bc, mp, 1)
+	acm := NewAdaptiveCapacityManager("node-a")
+	bp.SetCausalityClock(acm, "node-a")
+	for i := 0; i < 3; i++ {
+		if err := mp.Submit(Transaction{From: "alice", To: "bob", Amount: 1, Nonce: uint64(i), Fee: 1}); err != nil {
+			t.Fatal(err)
+		}
+		block, err := bp.ProduceBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Causality == nil || block.Causality.Get("node-a") != uint64(i+1) {
+			t.Fatalf("block #%d stamped with %v", block.Index, block.Causality)
+		}
+	}
+	if err := bc.ValidateCausalOrder(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The clock is covered by the hash and survives serialization
+	last := bc.Blocks[len(bc.Blocks)-1]
+	data, err := json.Marshal(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Block
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if calculateHash(decoded) != last.Hash || decoded.Causality.Compare(last.Causality) != ClockEqual {
+		t.Fatal("stamped block changed by a JSON round trip")
+	}
+	decoded.Causality = clockOf(map[string]uint64{"node-a": 9})
+	if calculateHash(decoded) == last.Hash {
+		t.Fatal("block hash does not cover its clock")
+	}
+
+	// A chain whose clocks run backwards is rejected
+	bc.Blocks[2].Causality, bc.Blocks[3].Causality = bc.Blocks[3].Causality, bc.Blocks[2].Causality
+	if err := bc.ValidateCausalOrder(); !errors.Is(err, ErrCausalityViolation) {
+		t.Fatalf("clocks out of order: %v", err)
+	}
+}
+
+func TestClockCompare(t *testing.T) {
+	a := clockOf(map[string]uint64{"x": 1, "y": 2})
+	for _, tc := range []struct {
+		other map[string]uint64
+		want  ClockOrder
+	}{
+		{map[string]uint64{"x": 1, "y": 2}, ClockEqual},
+		{map[string]uint64{"x": 1, "y": 2, "z": 0}, ClockEqual},
+		{map[string]uint64{"x": 2, "y": 2}, ClockBefore},
+		{map[string]uint64{"y": 2, "z": 1, "x": 1}, ClockBefore},
+		{map[string]uint64{"x": 1}, ClockAfter},
+		{map[string]uint64{"x": 2, "y": 1}, ClockConcurrent},
+	} {
+		if got := a.Compare(clockOf(tc.other)); got != tc.want {
+			t.Errorf("compared with %v: %s, want %s", tc.other, got, tc.want)
+		}
+	}
+}
+
+func TestTransferCausalityByLevel(t *testing.T) {
+	genesis := GenesisBlock()
+	latest := map[string]uint64{"node-b": 2}
+	for _, tc := range []struct {
+		name     string
+		clock    map[string]uint64
+		rejected map[ConsistencyLevel]bool
+	}{
+		{"happened before", map[string]uint64{"node-b": 1}, map[ConsistencyLevel]bool{Strong: true, Causal: true}},
+		{"concurrent", map[string]uint64{"node-a": 1}, map[ConsistencyLevel]bool{Strong: true}},
+		{"happened after", map[string]uint64{"node-b": 3}, nil},
+	} {
+		for _, level := range []ConsistencyLevel{Strong, Causal, Eventual} {
+			source, destination := NewShard(0), NewShard(1)
+			source.AddBlock(stampedBlock(t, genesis, "moved", tc.clock))
+			destination.AddBlock(stampedBlock(t, genesis, "latest", latest))
+			co := NewOrchestrator()
+			co.CurrentLevel = level
+			esm := NewEnhancedSyncManager("key")
+			esm.EnforceCausalOrder(co)
+
+			err := esm.CreateAuthenticatedTransfer(source, destination, 0)
+			if tc.rejected[level] {
+				if !errors.Is(err, ErrCausalityViolation) {
+					t.Fatalf("%s block under %s: %v", tc.name, level, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s block under %s: %v", tc.name, level, err)
+			}
+			if err := esm.VerifyAndApplyTransfer(source, destination, 0); err != nil {
+				t.Fatalf("%s block under %s: %v", tc.name, level, err)
+			}
+		}
+	}
+
+	// Without an orchestrator nothing is checked
+	source, destination := NewShard(0), NewShard(1)
+	source.AddBlock(stampedBlock(t, genesis, "moved", map[string]uint64{"node-b": 1}))
+	destination.AddBlock(stampedBlock(t, genesis, "latest", latest))
+	if err := NewEnhancedSyncManager("key").CreateAuthenticatedTransfer(source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Errors returned when assembling or verifying commit certificates
+var (
+	ErrInsufficientSignatures = errors.New("commit certificate lacks a 2f+1 quorum")
+	ErrInvalidVoteSignature   = errors.New("invalid vote signature")
+	ErrCertificateMismatch    = errors.New("certificate does not match block")
+	ErrMissingCertificate     = errors.New("block has no commit certificate")
+)
+
+// VoteType is the BFT phase a vote belongs to
+type VoteType string
+
+const (
+	PrepareVote VoteType = "prepare"
+	CommitVote  VoteType = "commit"
+)
+
+// Vote is a node's signed statement about a block in a view
+type Vote struct {
+	Type      VoteType `json:"type"`
+	NodeID    int      `json:"node_id"`
+	View      int      `json:"view"`
+	Height    int      `json:"height"`
+	BlockHash string   `json:"block_hash"`
+	Signature string   `json:"signature"` // Hex ed25519 signature over (type, view, height, hash)
+}
+
+// voteMessage is the data a vote signature covers
+func voteMessage(t VoteType, view, height int, blockHash string) []byte {
+	return []byte(fmt.Sprintf("%s:%d:%d:%s", t, view, height, blockHash))
+}
+
+// SignVote signs a vote for blockHash at (view, height)
+func (n *Node) SignVote(t VoteType, view, height int, blockHash string) Vote {
+	return Vote{
+		Type:      t,
+		NodeID:    n.ID,
+		View:      view,
+		Height:    height,
+		BlockHash: blockHash,
+		Signature: hex.EncodeToString(ed25519.Sign(n.signer, voteMessage(t, view, height, blockHash))),
+	}
+}
+
+// VerifyVote checks a vote's signature against the voter's public key
+func VerifyVote(pub ed25519.PublicKey, v Vote) bool {
+	if len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	signature, err := hex.DecodeString(v.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, voteMessage(v.Type, v.View, v.Height, v.BlockHash), signature)
+}
+
+// CommitCertificate proves that at least 2f+1 of n nodes committed to a block
+type CommitCertificate struct {
+	View      int    `json:"view"`
+	Height    int    `json:"height"`
+	BlockHash string `json:"block_hash"`
+	Votes     []Vote `json:"votes"` // Commit votes, one per node
+
+	ValidatorSet string `json:"validator_set,omitempty"` // ValidatorSetHash of the deciding set
+}
+
+// CertificateQuorum returns n-f, the signatures needed among n nodes
+// tolerating f = (n-1)/3 faults. That is 2f+1 when n = 3f+1; for other n it
+// is larger, so any two quorums still share an honest node.
+func CertificateQuorum(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n - (n-1)/3
+}
+
+// PublicKeys returns every node's vote verification key by node ID
+func (bft *BFTManager) PublicKeys() map[int]ed25519.PublicKey {
+	return nodeKeys(bft.Nodes)
+}
+
+// Decide runs a prepare and a commit phase for blockHash through each
+// node's behavior and returns the commit certificate once 2f+1 nodes have
+// signed both. The nodes are the validator set of the height, see
+// ValidatorsAt. Votes later than the round timeout are missed, equivocating
+// nodes are reported through RecordEquivocation and their votes discarded,
+// and a decided round updates reputations through RecordRound.
+func (bft *BFTManager) Decide(view, height int, blockHash string) (CommitCertificate, error) {
+	bft.round.Lock()
+	defer bft.round.Unlock()
+	nodes := bft.ValidatorsAt(height)
+	quorum := CertificateQuorum(len(nodes))
+	keys := nodeKeys(nodes)
+
+	var prepares []Vote
+	for _, node := range nodes {
+		prepares = append(prepares, bft.timely(node.EffectiveBehavior().OnPropose(node, view, height, blockHash))...)
+	}
+	faulty := bft.punishEquivocation(prepares, keys, nil)
+	prepared := validVoters(prepares, blockHash, keys, faulty)
+	if len(prepared) < quorum {
+		bft.TimeoutRound(view)
+		return CommitCertificate{}, fmt.Errorf("%w: %d prepare votes, need %d", ErrInsufficientSignatures, len(prepared), quorum)
+	}
+
+	var commits []Vote
+	for _, node := range nodes {
+		if prepared[node.ID] {
+			vote := node.SignVote(CommitVote, view, height, blockHash)
+			commits = append(commits, bft.timely(node.EffectiveBehavior().OnVote(node, vote))...)
+		}
+	}
+	faulty = bft.punishEquivocation(commits, keys, faulty)
+	committed := validVoters(commits, blockHash, keys, faulty)
+	if len(committed) < quorum {
+		bft.TimeoutRound(view)
+		return CommitCertificate{}, fmt.Errorf("%w: %d commit votes, need %d", ErrInsufficientSignatures, len(committed), quorum)
+	}
+
+	cert := CommitCertificate{View: view, Height: height, BlockHash: blockHash, ValidatorSet: ValidatorSetHash(keys)}
+	certified := make(map[int]bool)
+	for _, v := range commits {
+		if committed[v.NodeID] && !certified[v.NodeID] && v.BlockHash == blockHash && VerifyVote(keys[v.NodeID], v) {
+			cert.Votes = append(cert.Votes, v)
+			certified[v.NodeID] = true
+		}
+	}
+	bft.RecordRound(certified)
+	return cert, nil
+}
+
+// timely returns an action's votes if they arrive within the round timeout
+func (bft *BFTManager) timely(action Action) []Vote {
+	if action.Delay > bft.roundTimeout {
+		return nil
+	}
+	return action.Votes
+}
+
+// punishEquivocation records every equivocation among votes and returns the
+// set of faulty nodes, extending faulty if it is not nil
+func (bft *BFTManager) punishEquivocation(votes []Vote, keys map[int]ed25519.PublicKey, faulty map[int]bool) map[int]bool {
+	if faulty == nil {
+		faulty = make(map[int]bool)
+	}
+	for _, evidence := range DetectEquivocation(votes, keys) {
+		if bft.RecordEquivocation(evidence) == nil {
+			faulty[evidence.NodeID()] = true
+		}
+	}
+	return faulty
+}
+
+// validVoters returns the nodes outside faulty with a valid vote for blockHash
+func validVoters(votes []Vote, blockHash string, keys map[int]ed25519.PublicKey, faulty map[int]bool) map[int]bool {
+	voters := make(map[int]bool)
+	for _, v := range votes {
+		if v.BlockHash == blockHash && !faulty[v.NodeID] && VerifyVote(keys[v.NodeID], v) {
+			voters[v.NodeID] = true
+		}
+	}
+	return voters
+}
+
+// VerifyCommitCertificate checks that every vote in cert is a commit vote for
+// the certified block with a valid signature from a distinct known node, and
+// that there are at least 2f+1 of them for the len(pubkeys) nodes. A
+// certificate naming its validator set must name the set of pubkeys.
+func VerifyCommitCertificate(cert CommitCertificate, pubkeys map[int]ed25519.PublicKey) error {
+	if cert.ValidatorSet != "" && cert.ValidatorSet != ValidatorSetHash(pubkeys) {
+		return fmt.Errorf("%w: certified by a different validator set", ErrCertificateMismatch)
+	}
+	seen := make(map[int]bool, len(cert.Votes))
+	for _, v := range cert.Votes {
+		if v.Type != CommitVote || v.View != cert.View || v.Height != cert.Height || v.BlockHash != cert.BlockHash {
+			return fmt.Errorf("%w: vote from node #%d is for a different decision", ErrCertificateMismatch, v.NodeID)
+		}
+		if seen[v.NodeID] {
+			return fmt.Errorf("%w: duplicate vote from node #%d", ErrInvalidVoteSignature, v.NodeID)
+		}
+		pub, known := pubkeys[v.NodeID]
+		if !known || !VerifyVote(pub, v) {
+			return fmt.Errorf("%w: node #%d", ErrInvalidVoteSignature, v.NodeID)
+		}
+		seen[v.NodeID] = true
+	}
+	if quorum := CertificateQuorum(len(pubkeys)); len(seen) < quorum {
+		return fmt.Errorf("%w: %d signatures, need %d", ErrInsufficientSignatures, len(seen), quorum)
+	}
+	return nil
+}
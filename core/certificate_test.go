@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// honestBFT returns a BFT manager of n nodes that all vote honestly
+func honestBFT(n int) *BFTManager {
+	bft := NewBFTManager(n)
+	for _, node := range bft.Nodes {
+		node.Byzantine = false
+	}
+	return bft
+}
+
+func TestCertificateQuorumSizes(t *testing.T) {
+	for n, want := range map[int]int{0: 1, 1: 1, 2: 2, 3: 3, 4: 3, 5: 4, 6: 5, 7: 5, 10: 7, 100: 67} {
+		if got := CertificateQuorum(n); got != want {
+			t.Errorf("quorum of %d nodes is %d, want %d", n, got, want)
+		}
+	}
+
+	// Any two quorums overlap in more than f nodes, so in an honest one
+	for n := 1; n <= 100; n++ {
+		q, f := CertificateQuorum(n), (n-1)/3
+		if 2*q-n <= f || q > n {
+			t.Fatalf("%d nodes: quorums of %d tolerate %d faults", n, q, f)
+		}
+	}
+}
+
+func TestCommitCertificateVerification(t *testing.T) {
+	bft := honestBFT(4)
+	keys := bft.PublicKeys()
+	block := mustGenerateBlock(t, GenesisBlock(), "decided")
+	cert, err := bft.Decide(0, block.Index, block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Votes) != 4 {
+		t.Fatalf("certificate carries %d votes", len(cert.Votes))
+	}
+	if err := VerifyCommitCertificate(cert, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exactly the quorum verifies; one signature fewer does not
+	quorum := cert
+	quorum.Votes = cert.Votes[:CertificateQuorum(4)]
+	if err := VerifyCommitCertificate(quorum, keys); err != nil {
+		t.Fatalf("certificate with exactly the quorum: %v", err)
+	}
+	missing := quorum
+	missing.Votes = quorum.Votes[1:]
+	if err := VerifyCommitCertificate(missing, keys); !errors.Is(err, ErrInsufficientSignatures) {
+		t.Fatalf("certificate missing one signature: %v", err)
+	}
+
+	// A signature over another hash, carried under the certified hash
+	other := mustGenerateBlock(t, GenesisBlock(), "other")
+	node := bft.Nodes[cert.Votes[0].NodeID]
+	wrongHash := cert
+	wrongHash.Votes = append([]Vote{}, cert.Votes...)
+	wrongHash.Votes[0].Signature = node.SignVote(CommitVote, 0, block.Index, other.Hash).Signature
+	if err := VerifyCommitCertificate(wrongHash, keys); !errors.Is(err, ErrInvalidVoteSignature) {
+		t.Fatalf("certificate with a signature over the wrong hash: %v", err)
+	}
+
+	// A valid vote for another hash
+	wrongHash.Votes[0] = node.SignVote(CommitVote, 0, block.Index, other.Hash)
+	if err := VerifyCommitCertificate(wrongHash, keys); !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("certificate with a vote for the wrong hash: %v", err)
+	}
+
+	duplicate := quorum
+	duplicate.Votes = append(append([]Vote{}, quorum.Votes[:2]...), quorum.Votes[0])
+	if err := VerifyCommitCertificate(duplicate, keys); !errors.Is(err, ErrInvalidVoteSignature) {
+		t.Fatalf("certificate with a duplicate vote: %v", err)
+	}
+	if err := VerifyCommitCertificate(cert, honestBFT(4).PublicKeys()); err == nil {
+		t.Fatal("certificate verified against another validator set")
+	}
+}
+
+func TestCertificateQuorumWhenNIsNotThreeFPlusOne(t *testing.T) {
+	// Five nodes tolerate one fault and need four signatures, not three
+	bft := honestBFT(5)
+	keys := bft.PublicKeys()
+	block := mustGenerateBlock(t, GenesisBlock(), "decided")
+	cert, err := bft.Decide(0, block.Index, block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Votes = cert.Votes[:3]
+	if err := VerifyCommitCertificate(cert, keys); !errors.Is(err, ErrInsufficientSignatures) {
+		t.Fatalf("three of five signatures: %v", err)
+	}
+
+	// Two silent nodes leave three voters, short of the quorum
+	bft.Nodes[0].Byzantine, bft.Nodes[1].Byzantine = true, true
+	if _, err := bft.Decide(1, block.Index, block.Hash); !errors.Is(err, ErrInsufficientSignatures) {
+		t.Fatalf("round with three of five voters: %v", err)
+	}
+}
+
+func TestValidateVerifiesStoredCertificates(t *testing.T) {
+	bft := honestBFT(4)
+	keys := bft.PublicKeys()
+	bc := NewBlockchain()
+	for _, data := range testLeaves(3) {
+		if err := bc.AddBlock(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks[1:] {
+		cert, err := bft.Decide(0, b.Index, b.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AttachCertificate(cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.Validate(WithCertificateKeys(keys)); err != nil {
+		t.Fatal(err)
+	}
+
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	valid, _ := bc.Certificate(tip.Hash)
+	cert := valid
+	cert.Votes = valid.Votes[:2]
+	bc.certificates[tip.Hash] = cert
+	if err := bc.Validate(WithCertificateKeys(keys)); !errors.Is(err, ErrInsufficientSignatures) {
+		t.Fatalf("chain with an undersigned certificate: %v", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatalf("validation without certificate keys: %v", err)
+	}
+	bc.certificates[tip.Hash] = valid
+	if err := bc.AddBlock("uncertified"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Validate(WithCertificateKeys(keys)); !errors.Is(err, ErrMissingCertificate) {
+		t.Fatalf("chain with an uncertified block: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// extendChainCommitment computes C_i = H(C_{i-1} || blockHash_i)
+func extendChainCommitment(prev, blockHash string) string {
+	hash := sha256.Sum256([]byte(prev + blockHash))
+	return hex.EncodeToString(hash[:])
+}
+
+// chainCommitment folds block hashes in order, starting from the hash of
+// the empty string for a shard without blocks
+func chainCommitment(blocks []Block) string {
+	empty := sha256.Sum256(nil)
+	commitment := hex.EncodeToString(empty[:])
+	for _, b := range blocks {
+		commitment = extendChainCommitment(commitment, b.Hash)
+	}
+	return commitment
+}
+
+// ChainCommitment returns the rolling commitment over the shard's block
+// hashes in order. Unlike the Merkle root it changes whenever any block is
+// reordered, replaced or removed, and each append extends it in O(1).
+func (s *Shard) ChainCommitment() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.chain
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// shardWith returns shard 0 holding blocks appended one by one
+func shardWith(blocks []Block) *Shard {
+	shard := NewShard(0)
+	for _, b := range blocks {
+		shard.AddBlock(b)
+	}
+	return shard
+}
+
+// staleChainSyncer moves a block but leaves the destination's chain
+// commitment where it was, as a shard skipping the extension would
+type staleChainSyncer struct {
+	syncer *SyncManager
+}
+
+func (s staleChainSyncer) SyncBlock(source, destination *Shard, blockIndex int) error {
+	before := destination.ChainCommitment()
+	if err := s.syncer.SyncBlock(source, destination, blockIndex); err != nil {
+		return err
+	}
+	destination.mutex.Lock()
+	destination.chain = before
+	destination.mutex.Unlock()
+	return nil
+}
+
+func TestChainCommitmentExtendsPerBlock(t *testing.T) {
+	blocks := fixedBlocks(5)
+	shard := NewShard(0)
+	commitment := shard.ChainCommitment()
+	if commitment != chainCommitment(nil) {
+		t.Fatalf("empty shard commitment %s", commitment)
+	}
+	for _, b := range blocks {
+		shard.AddBlock(b)
+		commitment = extendChainCommitment(commitment, b.Hash)
+		if shard.ChainCommitment() != commitment {
+			t.Fatalf("commitment after block #%d is not the previous one extended", b.Index)
+		}
+	}
+	if commitment != chainCommitment(blocks) {
+		t.Fatal("incremental commitment differs from a rebuilt one")
+	}
+}
+
+func TestReorderOrSubstitutionChangesCommitments(t *testing.T) {
+	blocks := fixedBlocks(6)
+	honest := shardWith(blocks)
+
+	reordered := append([]Block{}, blocks...)
+	reordered[1], reordered[4] = reordered[4], reordered[1]
+
+	substituted := append([]Block{}, blocks...)
+	substituted[3].Data = "substituted"
+	substituted[3].Hash = calculateHash(substituted[3])
+
+	// Swapping Data between blocks while keeping their hashes
+	swapped := append([]Block{}, blocks...)
+	swapped[0].Data, swapped[2].Data = swapped[2].Data, swapped[0].Data
+
+	for name, tampered := range map[string][]Block{"reordered": reordered, "substituted": substituted, "swapped data": swapped} {
+		shard := shardWith(tampered)
+		if shard.GetRoot() == honest.GetRoot() {
+			t.Errorf("%s blocks keep the root", name)
+		}
+		if name != "swapped data" && shard.ChainCommitment() == honest.ChainCommitment() {
+			t.Errorf("%s blocks keep the chain commitment", name)
+		}
+	}
+}
+
+func TestReconstructStateChecksBothCommitments(t *testing.T) {
+	sm := NewShardManager()
+	for _, b := range fixedBlocks(25) {
+		sm.DistributeBlock(b)
+	}
+	shard := sm.Shards.GetAllShards()[0]
+	if _, ok := sm.ReconstructState(shard.ID); !ok {
+		t.Fatal("honest shard fails reconstruction")
+	}
+
+	shard.mutex.Lock()
+	shard.chain = chainCommitment(shard.Blocks[1:])
+	shard.mutex.Unlock()
+	if _, ok := sm.ReconstructState(shard.ID); ok {
+		t.Fatal("reconstruction accepted a stale chain commitment")
+	}
+
+	shard.mutex.Lock()
+	shard.chain = chainCommitment(shard.Blocks)
+	shard.Blocks[0].Data, shard.Blocks[1].Data = shard.Blocks[1].Data, shard.Blocks[0].Data
+	shard.mutex.Unlock()
+	if _, ok := sm.ReconstructState(shard.ID); ok {
+		t.Fatal("reconstruction accepted swapped block data")
+	}
+}
+
+func TestTransferChecksChainCommitment(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	destRoot, destChain := destination.GetRoot(), destination.ChainCommitment()
+
+	esm := NewEnhancedSyncManager("key")
+	esm.SetSyncManager(staleChainSyncer{syncer: NewSyncManager()})
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	err := esm.VerifyAndApplyTransfer(source, destination, 1)
+	var mismatch *RootMismatchError
+	if !errors.As(err, &mismatch) || !mismatch.Chain || mismatch.ShardID != destination.ID {
+		t.Fatalf("stale chain commitment: %v", err)
+	}
+	if destination.GetRoot() != destRoot || destination.ChainCommitment() != destChain {
+		t.Fatal("destination not rolled back")
+	}
+}
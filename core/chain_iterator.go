@@ -0,0 +1,172 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChainReader gives positional access to the blocks of a main chain, so
+// iterators walk chains held in memory and in storage alike
+type ChainReader interface {
+	Len() int
+	BlockAt(position int) (Block, error)
+}
+
+// ChainIterator walks a chain's blocks in order without copying the chain
+type ChainIterator struct {
+	chain ChainReader
+	next  int // Position of the next block
+	err   error
+}
+
+// NewChainIterator starts at the block with index fromIndex, or at the
+// first block held if the chain was pruned past it
+func NewChainIterator(chain ChainReader, fromIndex int) *ChainIterator {
+	it := &ChainIterator{chain: chain}
+	if chain.Len() == 0 {
+		return it
+	}
+	first, err := chain.BlockAt(0)
+	if err != nil {
+		it.err = err
+		return it
+	}
+	if fromIndex > first.Index {
+		it.next = fromIndex - first.Index
+	}
+	return it
+}
+
+// Next returns the next block; ok is false at the end of the chain or
+// after a read failed, see Err
+func (it *ChainIterator) Next() (Block, bool) {
+	if it.err != nil || it.next >= it.chain.Len() {
+		return Block{}, false
+	}
+	b, err := it.chain.BlockAt(it.next)
+	if err != nil {
+		it.err = err
+		return Block{}, false
+	}
+	it.next++
+	return b, true
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *ChainIterator) Err() error {
+	return it.err
+}
+
+// memoryChain reads the main chain of a Blockchain in place
+type memoryChain struct {
+	bc *Blockchain
+}
+
+// Len implements ChainReader
+func (mc memoryChain) Len() int {
+	return len(mc.bc.Blocks)
+}
+
+// BlockAt implements ChainReader
+func (mc memoryChain) BlockAt(position int) (Block, error) {
+	if position < 0 || position >= len(mc.bc.Blocks) {
+		return Block{}, fmt.Errorf("%w: position %d (block count: %d)", ErrIndexOutOfRange, position, len(mc.bc.Blocks))
+	}
+	return mc.bc.Blocks[position], nil
+}
+
+// Iterator walks the main chain from the block with index fromIndex.
+// Blocks appended meanwhile are included; the chain must not be pruned or
+// reorganized while it is walked.
+func (bc *Blockchain) Iterator(fromIndex int) *ChainIterator {
+	return NewChainIterator(memoryChain{bc}, fromIndex)
+}
+
+// StreamExport writes the main chain from the block with index fromIndex
+// as newline-delimited JSON, one block at a time
+func (bc *Blockchain) StreamExport(w io.Writer, fromIndex int) error {
+	return ExportChain(w, bc.Iterator(fromIndex))
+}
+
+// ExportChain writes the remaining blocks of an iterator as
+// newline-delimited JSON, one block at a time
+func ExportChain(w io.Writer, it *ChainIterator) error {
+	enc := json.NewEncoder(w)
+	for {
+		b, ok := it.Next()
+		if !ok {
+			return it.Err()
+		}
+		if err := enc.Encode(b); err != nil {
+			return fmt.Errorf("exporting block #%d: %w", b.Index, err)
+		}
+	}
+}
+
+// StreamImport reads newline-delimited JSON blocks, as written by
+// StreamExport, and appends them to the main chain one at a time, checking
+// each like Validate does. Blocks the main chain already holds are
+// skipped. It returns the number of blocks appended.
+func (bc *Blockchain) StreamImport(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	appended := 0
+	for {
+		var b Block
+		if err := dec.Decode(&b); err != nil {
+			if errors.Is(err, io.EOF) {
+				return appended, nil
+			}
+			return appended, fmt.Errorf("importing block after %d: %w", appended, err)
+		}
+		if hash, exists := bc.HashAtHeight(b.Index); exists && hash == b.Hash {
+			continue
+		}
+		if err := bc.appendImported(b); err != nil {
+			return appended, err
+		}
+		appended++
+	}
+}
+
+// appendImported checks that b extends the tip and appends it
+func (bc *Blockchain) appendImported(b Block) error {
+	if len(bc.Blocks) == 0 {
+		return fmt.Errorf("%w: block #%d has no parent", ErrInvalidBlock, b.Index)
+	}
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if b.Index != tip.Index+1 || b.PrevHash != tip.Hash {
+		return fmt.Errorf("%w: block #%d does not follow #%d", ErrInvalidBlock, b.Index, tip.Index)
+	}
+	if b.Hash != b.ComputeHash() {
+		return fmt.Errorf("%w: block #%d has invalid hash %s", ErrInvalidBlock, b.Index, b.Hash)
+	}
+	if b.TxRoot != "" && b.TxRoot != TransactionRoot(b.Transactions) {
+		return fmt.Errorf("%w: block #%d transactions do not match its TxRoot", ErrInvalidBlock, b.Index)
+	}
+	if err := checkTxBloom(b); err != nil {
+		return err
+	}
+	if err := checkMutationRoot(b); err != nil {
+		return err
+	}
+	if b.Timestamp.Before(tip.Timestamp) {
+		return fmt.Errorf("%w: block #%d timestamp is earlier than its parent", ErrInvalidBlock, b.Index)
+	}
+	if err := bc.checkChainBlock(b); err != nil {
+		return err
+	}
+	if err := bc.validateBlock(b); err != nil {
+		return err
+	}
+	if err := bc.checkNextDifficulty(bc.Blocks, b); err != nil {
+		return err
+	}
+	next, err := applyBlockState(bc.ledger(), b)
+	if err != nil {
+		return err
+	}
+	bc.appendMain(b, next)
+	return nil
+}
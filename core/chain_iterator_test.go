@@ -0,0 +1,267 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// longChain returns a chain of n blocks after genesis
+func longChain(t testing.TB, n int) *Blockchain {
+	t.Helper()
+	bc := NewBlockchain()
+	for i := 0; i < n; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+var (
+	longChainOnce sync.Once
+	longChainBC   *Blockchain
+)
+
+// sharedLongChain returns a 50,000-block chain built once per test binary
+func sharedLongChain(t testing.TB) *Blockchain {
+	longChainOnce.Do(func() { longChainBC = longChain(t, 50000) })
+	return longChainBC
+}
+
+// storedChain is a ChainReader over NDJSON lines, decoding each block only
+// when it is read, as a storage-backed chain would
+type storedChain struct {
+	lines [][]byte
+	fail  int // Position whose read fails, -1 for none
+}
+
+// Len implements ChainReader
+func (sc storedChain) Len() int {
+	return len(sc.lines)
+}
+
+// BlockAt implements ChainReader
+func (sc storedChain) BlockAt(position int) (Block, error) {
+	if position == sc.fail {
+		return Block{}, ErrBodyNotFound
+	}
+	var b Block
+	err := json.Unmarshal(sc.lines[position], &b)
+	return b, err
+}
+
+// collect returns the hashes an iterator yields
+func collect(t *testing.T, it *ChainIterator) []string {
+	t.Helper()
+	var hashes []string
+	for {
+		b, ok := it.Next()
+		if !ok {
+			break
+		}
+		hashes = append(hashes, b.Hash)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+func TestIteratorMatchesStoredChain(t *testing.T) {
+	bc := longChain(t, 20)
+	var out bytes.Buffer
+	if err := bc.StreamExport(&out, 0); err != nil {
+		t.Fatal(err)
+	}
+	stored := storedChain{lines: bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n")), fail: -1}
+	if stored.Len() != len(bc.Blocks) {
+		t.Fatalf("%d lines exported for %d blocks", stored.Len(), len(bc.Blocks))
+	}
+
+	for _, from := range []int{-5, 0, 1, 13, 20, 21, 100} {
+		memory := collect(t, bc.Iterator(from))
+		disk := collect(t, NewChainIterator(stored, from))
+		want := 21 - from
+		if from < 0 {
+			want = 21
+		} else if from > 20 {
+			want = 0
+		}
+		if len(memory) != want || fmt.Sprint(memory) != fmt.Sprint(disk) {
+			t.Fatalf("from #%d: %d blocks in memory, %d stored, want %d", from, len(memory), len(disk), want)
+		}
+		if want > 0 && memory[0] != bc.Blocks[21-want].Hash {
+			t.Fatalf("from #%d starts at the wrong block", from)
+		}
+	}
+
+	// Blocks appended during a walk are reached
+	it := bc.Iterator(20)
+	it.Next()
+	if err := bc.AddBlock("late"); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := it.Next(); !ok || b.Data != "late" {
+		t.Fatalf("block appended during the walk: %v", ok)
+	}
+
+	// A failed read stops the walk and is reported
+	stored.fail = 4
+	broken := NewChainIterator(stored, 0)
+	count := 0
+	for _, ok := broken.Next(); ok; _, ok = broken.Next() {
+		count++
+	}
+	if count != 4 || !errors.Is(broken.Err(), ErrBodyNotFound) {
+		t.Fatalf("stopped after %d blocks with %v", count, broken.Err())
+	}
+	if err := ExportChain(io.Discard, NewChainIterator(stored, 0)); !errors.Is(err, ErrBodyNotFound) {
+		t.Fatalf("export over a failed read: %v", err)
+	}
+}
+
+func TestStreamImportRoundTrip(t *testing.T) {
+	bc := longChain(t, 30)
+	var out bytes.Buffer
+	if err := bc.StreamExport(&out, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := NewBlockchain()
+	appended, err := imported.StreamImport(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appended != 30 || len(imported.Blocks) != 31 || imported.Blocks[30].Hash != bc.Blocks[30].Hash {
+		t.Fatalf("appended %d blocks to a chain of %d", appended, len(imported.Blocks))
+	}
+	if err := imported.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An export from a later index extends a chain holding the prefix
+	partial := NewBlockchain()
+	if _, err := partial.StreamImport(strings.NewReader(strings.Join(strings.SplitAfter(out.String(), "\n")[:11], ""))); err != nil {
+		t.Fatal(err)
+	}
+	var tail bytes.Buffer
+	if err := bc.StreamExport(&tail, 11); err != nil {
+		t.Fatal(err)
+	}
+	if appended, err := partial.StreamImport(&tail); err != nil || appended != 20 {
+		t.Fatalf("appended %d blocks from #11: %v", appended, err)
+	}
+
+	// A tampered block stops the import after the blocks before it
+	lines := strings.SplitAfter(out.String(), "\n")
+	lines[16] = strings.Replace(lines[16], `"block-15"`, `"forged"`, 1)
+	tampered := NewBlockchain()
+	appended, err = tampered.StreamImport(strings.NewReader(strings.Join(lines, "")))
+	if !errors.Is(err, ErrInvalidBlock) || appended != 15 {
+		t.Fatalf("tampered import appended %d blocks: %v", appended, err)
+	}
+	if _, err := NewBlockchain().StreamImport(strings.NewReader("{not json")); err == nil {
+		t.Fatal("malformed import accepted")
+	}
+}
+
+// countingWriter counts lines written and calls every for each 5,000th
+type countingWriter struct {
+	lines int
+	every func()
+}
+
+// Write implements io.Writer
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.lines += bytes.Count(p, []byte("\n"))
+	if w.lines%5000 == 0 && w.every != nil {
+		w.every()
+	}
+	return len(p), nil
+}
+
+// liveHeap returns the heap still in use after a collection
+func liveHeap() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func TestStreamLongChainInConstantMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a 50,000-block chain")
+	}
+	bc := sharedLongChain(t)
+
+	// Exporting keeps nothing of what it has written
+	base := liveHeap()
+	var peak uint64
+	w := &countingWriter{every: func() {
+		if heap := liveHeap(); heap > peak {
+			peak = heap
+		}
+	}}
+	if err := bc.StreamExport(w, 0); err != nil {
+		t.Fatal(err)
+	}
+	if w.lines != 50001 {
+		t.Fatalf("exported %d blocks", w.lines)
+	}
+	if peak > base+1<<20 {
+		t.Fatalf("live heap grew by %d bytes exporting 50,000 blocks", peak-base)
+	}
+
+	// Importing through a pipe holds one block in flight, not the export
+	r, pw := io.Pipe()
+	go func() {
+		buffered := bufio.NewWriter(pw)
+		err := bc.StreamExport(buffered, 0)
+		if err == nil {
+			err = buffered.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	imported := NewBlockchain()
+	appended, err := imported.StreamImport(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appended != 50000 || imported.Blocks[50000].Hash != bc.Blocks[50000].Hash {
+		t.Fatalf("imported %d blocks", appended)
+	}
+}
+
+func BenchmarkStreamExport(b *testing.B) {
+	bc := sharedLongChain(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := bc.StreamExport(io.Discard, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(bc.Blocks)), "ns/block")
+}
+
+func BenchmarkStreamImport(b *testing.B) {
+	var out bytes.Buffer
+	if err := sharedLongChain(b).StreamExport(&out, 0); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewBlockchain().StreamImport(bytes.NewReader(out.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
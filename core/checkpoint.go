@@ -0,0 +1,239 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Errors returned by checkpointing
+var (
+	ErrBadCheckpointSignature = errors.New("checkpoint signature does not verify")
+	ErrCheckpointMismatch     = errors.New("blocks do not extend checkpoint")
+	ErrReorgBeyondCheckpoint  = errors.New("reorganization would undo a checkpoint")
+)
+
+// Checkpoint commits to the main chain at a height so that nodes can sync
+// from it without the blocks before it
+type Checkpoint struct {
+	Height         int    `json:"height"`
+	BlockHash      string `json:"block_hash"`  // Hash of the block at Height
+	ChainHash      string `json:"chain_hash"`  // Cumulative hash of every block up to Height
+	ForestRoot     string `json:"forest_root"` // Shard forest root when created, empty if untracked
+	StateRoot      string `json:"state_root"`  // Ledger state root after the block at Height
+	Signature      string `json:"signature"`   // Hex ed25519 signature over the fields above
+	KeyFingerprint string `json:"key_fingerprint"`
+}
+
+// message is the data a checkpoint signature covers
+func (cp Checkpoint) message() []byte {
+	return []byte(fmt.Sprintf("%d:%s:%s:%s:%s", cp.Height, cp.BlockHash, cp.ChainHash, cp.ForestRoot, cp.StateRoot))
+}
+
+// extendChainHash folds a block into the cumulative chain hash
+func extendChainHash(chainHash string, b Block) string {
+	hash := sha256.Sum256([]byte(chainHash + b.Hash))
+	return hex.EncodeToString(hash[:])
+}
+
+// CheckpointManager creates a signed checkpoint every interval blocks
+// appended to a Blockchain it is attached to
+type CheckpointManager struct {
+	interval    int
+	signer      ed25519.PrivateKey
+	forest      *ShardManager // Optional source of ForestRoot
+	checkpoints []Checkpoint  // Ascending by height
+	chainHash   string        // Cumulative hash up to the main-chain tip
+	exported    int           // Height of the latest exported checkpoint, -1 if none
+	mutex       sync.RWMutex
+}
+
+// CheckpointOption configures a CheckpointManager
+type CheckpointOption func(*CheckpointManager)
+
+// WithCheckpointKey signs checkpoints with an existing ed25519 key
+func WithCheckpointKey(key ed25519.PrivateKey) CheckpointOption {
+	return func(cm *CheckpointManager) {
+		cm.signer = key
+	}
+}
+
+// WithCheckpointForest records the shard manager's ForestRoot in checkpoints
+func WithCheckpointForest(sm *ShardManager) CheckpointOption {
+	return func(cm *CheckpointManager) {
+		cm.forest = sm
+	}
+}
+
+// NewCheckpointManager creates a manager checkpointing every interval blocks
+func NewCheckpointManager(interval int, opts ...CheckpointOption) *CheckpointManager {
+	if interval < 1 {
+		interval = 1
+	}
+	cm := &CheckpointManager{interval: interval, exported: -1}
+	for _, opt := range opts {
+		opt(cm)
+	}
+	if cm.signer == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Sprintf("generating checkpoint key: %v", err))
+		}
+		cm.signer = key
+	}
+	return cm
+}
+
+// PublicKey returns the key that verifies this manager's checkpoints
+func (cm *CheckpointManager) PublicKey() ed25519.PublicKey {
+	return cm.signer.Public().(ed25519.PublicKey)
+}
+
+// observe folds a main-chain block into the chain hash and checkpoints it if
+// it falls on the interval
+func (cm *CheckpointManager) observe(b Block, stateRoot string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.chainHash = extendChainHash(cm.chainHash, b)
+	if b.Index == 0 || b.Index%cm.interval != 0 {
+		return
+	}
+
+	cp := Checkpoint{
+		Height:         b.Index,
+		BlockHash:      b.Hash,
+		ChainHash:      cm.chainHash,
+		StateRoot:      stateRoot,
+		KeyFingerprint: KeyFingerprint(cm.PublicKey()),
+	}
+	if cm.forest != nil {
+		cp.ForestRoot = cm.forest.ForestRoot()
+	}
+	cp.Signature = hex.EncodeToString(ed25519.Sign(cm.signer, cp.message()))
+	cm.checkpoints = append(cm.checkpoints, cp)
+}
+
+// rewind resets the chain hash to the main-chain block at position pos
+// after a reorganization; checkpoints at or below it are unaffected
+func (cm *CheckpointManager) rewind(blocks []Block, pos int) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	chainHash, start := "", 0
+	if n := len(cm.checkpoints); n > 0 {
+		latest := cm.checkpoints[n-1]
+		chainHash, start = latest.ChainHash, latest.Height+1-blocks[0].Index
+	}
+	for _, b := range blocks[start : pos+1] {
+		chainHash = extendChainHash(chainHash, b)
+	}
+	cm.chainHash = chainHash
+}
+
+// LatestCheckpoint returns the most recent checkpoint
+func (cm *CheckpointManager) LatestCheckpoint() (Checkpoint, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	if len(cm.checkpoints) == 0 {
+		return Checkpoint{}, false
+	}
+	return cm.checkpoints[len(cm.checkpoints)-1], true
+}
+
+// CheckpointAt returns the checkpoint taken at a height
+func (cm *CheckpointManager) CheckpointAt(height int) (Checkpoint, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	i := sort.Search(len(cm.checkpoints), func(i int) bool {
+		return cm.checkpoints[i].Height >= height
+	})
+	if i < len(cm.checkpoints) && cm.checkpoints[i].Height == height {
+		return cm.checkpoints[i], true
+	}
+	return Checkpoint{}, false
+}
+
+// ExportLatest hands out the most recent checkpoint for other nodes to sync
+// from. Blocks up to an exported checkpoint may then be pruned.
+func (cm *CheckpointManager) ExportLatest() (Checkpoint, bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if len(cm.checkpoints) == 0 {
+		return Checkpoint{}, false
+	}
+	latest := cm.checkpoints[len(cm.checkpoints)-1]
+	cm.exported = latest.Height
+	return latest, true
+}
+
+// ExportedHeight returns the height of the latest exported checkpoint, or -1
+func (cm *CheckpointManager) ExportedHeight() int {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.exported
+}
+
+// VerifyChainFromCheckpoint checks that blocks extend a checkpoint signed by
+// this manager; see VerifyCheckpointChain
+func (cm *CheckpointManager) VerifyChainFromCheckpoint(cp Checkpoint, blocks []Block) error {
+	return VerifyCheckpointChain(cm.PublicKey(), cp, blocks)
+}
+
+// VerifyCheckpoint checks a checkpoint's signature against a public key
+func VerifyCheckpoint(pub ed25519.PublicKey, cp Checkpoint) bool {
+	if len(pub) != ed25519.PublicKeySize || cp.KeyFingerprint != KeyFingerprint(pub) {
+		return false
+	}
+	signature, err := hex.DecodeString(cp.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, cp.message(), signature)
+}
+
+// VerifyCheckpointChain validates blocks following a signed checkpoint
+// without the history before it: the first block must build on the
+// checkpoint block, and every block must carry a valid hash, link to its
+// parent and not go back in time. Blocks may start with the checkpoint block
+// itself. Account state is not replayed, since only its root is checkpointed.
+func VerifyCheckpointChain(pub ed25519.PublicKey, cp Checkpoint, blocks []Block) error {
+	if !VerifyCheckpoint(pub, cp) {
+		return fmt.Errorf("%w: height %d", ErrBadCheckpointSignature, cp.Height)
+	}
+	if len(blocks) > 0 && blocks[0].Index == cp.Height {
+		if blocks[0].Hash != cp.BlockHash || blocks[0].ComputeHash() != cp.BlockHash {
+			return fmt.Errorf("%w: block #%d is not the checkpoint block", ErrCheckpointMismatch, cp.Height)
+		}
+		blocks = blocks[1:]
+	}
+
+	prevHash, prevIndex := cp.BlockHash, cp.Height
+	for i, b := range blocks {
+		if b.Index != prevIndex+1 || b.PrevHash != prevHash {
+			return fmt.Errorf("%w: block #%d does not follow #%d", ErrCheckpointMismatch, b.Index, prevIndex)
+		}
+		if b.Hash != b.ComputeHash() {
+			return fmt.Errorf("%w: block #%d has invalid hash %s", ErrInvalidBlock, b.Index, b.Hash)
+		}
+		if b.TxRoot != "" && b.TxRoot != TransactionRoot(b.Transactions) {
+			return fmt.Errorf("%w: block #%d transactions do not match its TxRoot", ErrInvalidBlock, b.Index)
+		}
+		if err := checkTxBloom(b); err != nil {
+			return err
+		}
+		if err := checkMutationRoot(b); err != nil {
+			return err
+		}
+		if i > 0 && b.Timestamp.Before(blocks[i-1].Timestamp) {
+			return fmt.Errorf("%w: block #%d timestamp is earlier than its parent", ErrInvalidBlock, b.Index)
+		}
+		prevHash, prevIndex = b.Hash, b.Index
+	}
+	return nil
+}
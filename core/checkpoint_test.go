@@ -0,0 +1,145 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// checkpointedChain builds a chain of n blocks after genesis checkpointed
+// every interval blocks
+func checkpointedChain(t *testing.T, n, interval int) (*Blockchain, *CheckpointManager) {
+	t.Helper()
+	bc := NewBlockchain()
+	cm := NewCheckpointManager(interval)
+	if err := bc.SetCheckpointManager(cm); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range testLeaves(n) {
+		if err := bc.AddBlock(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc, cm
+}
+
+func TestCheckpointsEveryInterval(t *testing.T) {
+	bc, cm := checkpointedChain(t, 12, 5)
+	chainHash := ""
+	for _, b := range bc.Blocks {
+		chainHash = extendChainHash(chainHash, b)
+		cp, exists := cm.CheckpointAt(b.Index)
+		if exists != (b.Index == 5 || b.Index == 10) {
+			t.Fatalf("checkpoint at height %d: %v", b.Index, exists)
+		}
+		if !exists {
+			continue
+		}
+		if cp.BlockHash != b.Hash || cp.ChainHash != chainHash {
+			t.Fatalf("checkpoint %d commits to block %s, chain %s", cp.Height, cp.BlockHash, cp.ChainHash)
+		}
+		if !VerifyCheckpoint(cm.PublicKey(), cp) {
+			t.Fatalf("checkpoint %d signature rejected", cp.Height)
+		}
+	}
+	latest, exists := cm.LatestCheckpoint()
+	if !exists || latest.Height != 10 || latest.StateRoot == "" {
+		t.Fatalf("latest checkpoint %+v", latest)
+	}
+
+	// A manager attached late replays the chain to the same checkpoints
+	late := NewCheckpointManager(5)
+	if err := bc.SetCheckpointManager(late); err != nil {
+		t.Fatal(err)
+	}
+	replayed, _ := late.LatestCheckpoint()
+	if replayed.ChainHash != latest.ChainHash || replayed.StateRoot != latest.StateRoot {
+		t.Fatalf("replayed checkpoint %+v, want %+v", replayed, latest)
+	}
+}
+
+func TestPruningStopsAtExportedCheckpoint(t *testing.T) {
+	bc, cm := checkpointedChain(t, 12, 5)
+	pruner := NewStatePruner(1, 2, false)
+	if pruned := pruner.PruneBlockchain(bc); pruned != 0 {
+		t.Fatalf("pruned %d blocks before any checkpoint was exported", pruned)
+	}
+
+	cp, _ := cm.ExportLatest()
+	if cm.ExportedHeight() != 10 {
+		t.Fatalf("exported height %d", cm.ExportedHeight())
+	}
+	if pruned := pruner.PruneBlockchain(bc); pruned != 11 {
+		t.Fatalf("pruned %d blocks, want the 11 up to the exported checkpoint", pruned)
+	}
+	if bc.Blocks[0].Index != cp.Height+1 {
+		t.Fatalf("chain starts at #%d after pruning", bc.Blocks[0].Index)
+	}
+
+	// New blocks still checkpoint past the pruned prefix, and stay until exported
+	for _, data := range testLeaves(5) {
+		if err := bc.AddBlock("after-" + data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, exists := cm.CheckpointAt(15); !exists {
+		t.Fatal("no checkpoint at height 15 after pruning")
+	}
+	if pruned := pruner.PruneBlockchain(bc); pruned != 0 {
+		t.Fatalf("pruned %d blocks past the exported checkpoint", pruned)
+	}
+}
+
+func TestSyncFromCheckpointWithoutPrunedPrefix(t *testing.T) {
+	bc, cm := checkpointedChain(t, 12, 5)
+	cp, _ := cm.ExportLatest()
+	NewStatePruner(1, 2, false).PruneBlockchain(bc)
+	for _, data := range testLeaves(3) {
+		if err := bc.AddBlock("new-" + data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A syncing node holds the checkpoint, the public key and the unpruned blocks
+	pub := cm.PublicKey()
+	blocks := append([]Block{}, bc.Blocks...)
+	if err := VerifyCheckpointChain(pub, cp, blocks); err != nil {
+		t.Fatalf("blocks after the checkpoint rejected: %v", err)
+	}
+	if err := cm.VerifyChainFromCheckpoint(cp, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyCheckpointChain(NewCheckpointManager(5).PublicKey(), cp, blocks); !errors.Is(err, ErrBadCheckpointSignature) {
+		t.Fatalf("checkpoint under another key: %v", err)
+	}
+	forged := cp
+	forged.StateRoot = "forged"
+	if err := VerifyCheckpointChain(pub, forged, blocks); !errors.Is(err, ErrBadCheckpointSignature) {
+		t.Fatalf("checkpoint with a forged state root: %v", err)
+	}
+	if err := VerifyCheckpointChain(pub, cp, blocks[1:]); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Fatalf("blocks with a gap after the checkpoint: %v", err)
+	}
+	tampered := append([]Block{}, blocks...)
+	tampered[2].Data = "tampered"
+	if err := VerifyCheckpointChain(pub, cp, tampered); err == nil {
+		t.Fatal("tampered block accepted")
+	}
+}
+
+func TestReorganizationBelowCheckpointRefused(t *testing.T) {
+	bc, _ := checkpointedChain(t, 7, 5)
+	prev := bc.Blocks[2]
+	for i := 0; i < 8; i++ {
+		prev = mustGenerateBlock(t, prev, "branch")
+		if err := bc.AddBlockCandidate(prev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := bc.ResolveForks(); !errors.Is(err, ErrReorgBeyondCheckpoint) {
+		t.Fatalf("reorganization below the checkpoint: %v", err)
+	}
+	if bc.Blocks[len(bc.Blocks)-1].Index != 7 {
+		t.Fatal("main chain changed")
+	}
+}
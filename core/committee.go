@@ -0,0 +1,84 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// AssignCommittee makes committee the BFT committee of a shard. Transfers
+// into the shard then commit only once the committee has decided them with
+// a 2f+1 quorum; the commit certificate is attached to the journal record
+// and, for value transfers, the receipt. A nil committee removes it.
+func (esm *EnhancedSyncManager) AssignCommittee(shardID int, committee *BFTManager) {
+	esm.committeeMutex.Lock()
+	defer esm.committeeMutex.Unlock()
+	if committee == nil {
+		delete(esm.committees, shardID)
+		return
+	}
+	esm.committees[shardID] = committee
+}
+
+// Committee returns the BFT committee assigned to a shard, if any
+func (esm *EnhancedSyncManager) Committee(shardID int) (*BFTManager, bool) {
+	esm.committeeMutex.Lock()
+	defer esm.committeeMutex.Unlock()
+	committee, exists := esm.committees[shardID]
+	return committee, exists
+}
+
+// RunConsensusOn decides blockHash at height with the committee of a shard.
+// Unlike transfer and root decisions it does not hold the committee list
+// lock during the round, so shards with different committees decide in
+// parallel.
+func (esm *EnhancedSyncManager) RunConsensusOn(shardID, height int, blockHash string) (CommitCertificate, error) {
+	committee, exists := esm.Committee(shardID)
+	if !exists {
+		return CommitCertificate{}, fmt.Errorf("%w: #%d", ErrNoCommittee, shardID)
+	}
+	cert, err := committee.Decide(0, height, blockHash)
+	if err != nil {
+		return CommitCertificate{}, fmt.Errorf("committee of shard #%d: %w", shardID, err)
+	}
+	return cert, nil
+}
+
+// TransferDecision is the value a destination committee votes on to commit
+// a transfer, binding its ID to its commitment
+func TransferDecision(transferID, commitment string) string {
+	hash := sha256.Sum256([]byte("transfer:" + transferID + ":" + commitment))
+	return hex.EncodeToString(hash[:])
+}
+
+// VerifyTransferCertificate checks that a journaled transfer carries a
+// commit certificate deciding it, signed by a quorum of pubkeys
+func VerifyTransferCertificate(record TransferRecord, pubkeys map[int]ed25519.PublicKey) error {
+	if record.Certificate == nil {
+		return fmt.Errorf("%w: transfer %s", ErrMissingCertificate, record.ID)
+	}
+	if record.Certificate.BlockHash != TransferDecision(record.ID, record.Commitment) {
+		return fmt.Errorf("%w: certificate does not decide transfer %s", ErrCertificateMismatch, record.ID)
+	}
+	return VerifyCommitCertificate(*record.Certificate, pubkeys)
+}
+
+// decideTransfer runs a consensus round over a transfer among the
+// destination's committee at its current height. It returns a nil
+// certificate when the destination has no committee. Byzantine members
+// that withhold or forge votes only block the transfer if they leave the
+// committee short of quorum.
+func (esm *EnhancedSyncManager) decideTransfer(destination *Shard, transferID, commitment string) (*CommitCertificate, error) {
+	esm.committeeMutex.Lock()
+	defer esm.committeeMutex.Unlock()
+	committee, exists := esm.committees[destination.ID]
+	if !exists {
+		return nil, nil
+	}
+	cert, err := committee.Decide(0, len(destination.Blocks), TransferDecision(transferID, commitment))
+	if err != nil {
+		return nil, fmt.Errorf("committee of shard #%d: %w", destination.ID, err)
+	}
+	return &cert, nil
+}
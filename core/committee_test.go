@@ -0,0 +1,137 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCommitteeQuorumCommitsTransfer(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	committee := behaviorBFT(4)
+	committee.Nodes[3].Behavior = SilentBehavior{} // f = 1 tolerated
+	esm.AssignCommittee(destination.ID, committee)
+	if got, ok := esm.Committee(destination.ID); !ok || got != committee {
+		t.Fatal("committee not assigned")
+	}
+	moved := source.Blocks[1]
+
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !destination.containsBlock(moved.Hash) {
+		t.Fatal("decided transfer not applied")
+	}
+	record := esm.Journal()[0]
+	if record.Outcome != OutcomeCommitted || record.Certificate == nil {
+		t.Fatalf("journaled %s with certificate %v", record.Outcome, record.Certificate)
+	}
+	for _, v := range record.Certificate.Votes {
+		if v.NodeID == 3 {
+			t.Fatal("silent member's vote in the certificate")
+		}
+	}
+	if err := VerifyTransferCertificate(record, committee.PublicKeys()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitteeWithoutQuorumRollsBack(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	committee := behaviorBFT(4)
+	committee.Nodes[2].Behavior = SilentBehavior{}
+	committee.Nodes[3].Behavior = SilentBehavior{}
+	esm.AssignCommittee(destination.ID, committee)
+	roots := [2]string{source.GetRoot(), destination.GetRoot()}
+
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); !errors.Is(err, ErrInsufficientSignatures) {
+		t.Fatalf("transfer with two of four members silent: %v", err)
+	}
+	if source.GetRoot() != roots[0] || destination.GetRoot() != roots[1] {
+		t.Fatal("rejected transfer changed the shards")
+	}
+	record := esm.Journal()[0]
+	if record.Outcome != OutcomeRolledBack || record.Reason != ReasonCommitteeRejected || record.Certificate != nil {
+		t.Fatalf("journaled %s (%s) with certificate %v", record.Outcome, record.Reason, record.Certificate)
+	}
+
+	// Removing the committee lets the shard commit on its own again
+	esm.AssignCommittee(destination.ID, nil)
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if esm.Journal()[1].Certificate != nil {
+		t.Fatal("certificate attached without a committee")
+	}
+}
+
+func TestVerifyTransferCertificate(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	committee := behaviorBFT(4)
+	esm.AssignCommittee(destination.ID, committee)
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	record := esm.Journal()[0]
+	keys := committee.PublicKeys()
+
+	missing := record
+	missing.Certificate = nil
+	if err := VerifyTransferCertificate(missing, keys); !errors.Is(err, ErrMissingCertificate) {
+		t.Fatalf("record without a certificate: %v", err)
+	}
+	// The certificate decides this transfer's commitment only
+	rebound := record
+	rebound.Commitment = "other"
+	if err := VerifyTransferCertificate(rebound, keys); !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("certificate for another commitment: %v", err)
+	}
+	if err := VerifyTransferCertificate(record, behaviorBFT(4).PublicKeys()); err == nil {
+		t.Fatal("certificate verified under another committee's keys")
+	}
+	if err := VerifyTransferCertificate(record, keys); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitteeDecidesValueTransfer(t *testing.T) {
+	esm := NewEnhancedSyncManager("key")
+	committee := behaviorBFT(4)
+	committee.Nodes[0].Behavior = EquivocatingBehavior{}
+	sm := partitionedManager(t)
+	from, _ := sm.ShardForAddress("\x01alice")
+	to, _ := sm.ShardForAddress("~bob")
+	esm.AssignCommittee(to.ID, committee)
+	if err := from.Credit("\x01alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := esm.TransferValueWithReceipt(from, to, Transaction{From: "\x01alice", To: "~bob", Amount: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The equivocating member is left out and the rest reach quorum
+	if receipt.Certificate == nil {
+		t.Fatal("receipt carries no committee certificate")
+	}
+	if receipt.Certificate.BlockHash != TransferDecision(receipt.TransferID, esm.Journal()[0].Commitment) {
+		t.Fatal("receipt certificate does not decide the transfer")
+	}
+	if err := VerifyCommitCertificate(*receipt.Certificate, committee.PublicKeys()); err != nil {
+		t.Fatal(err)
+	}
+}
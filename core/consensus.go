@@ -1,15 +1,86 @@
 package core
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 )
 
+// ElectionMode selects how VRF leader election treats node reputation
+type ElectionMode int
+
+const (
+	// Uniform gives every honest node the same chance to lead
+	Uniform ElectionMode = iota
+	// ReputationWeighted makes a node's chance proportional to its reputation
+	ReputationWeighted
+)
+
+func (m ElectionMode) String() string {
+	if m == ReputationWeighted {
+		return "ReputationWeighted"
+	}
+	return "Uniform"
+}
+
+// DefaultMinElectionWeight is the smallest weight a node gets under
+// ReputationWeighted, so low-reputation nodes can still recover by leading
+const DefaultMinElectionWeight = 0.05
+
 type ConsensusManager struct {
-	BFT *BFTManager
+	BFT       *BFTManager
+	rand      RandomSource // Falls back to the BFT manager's source when nil
+	mode      ElectionMode
+	minWeight float64
+	selector  ParticipantSelector // Nil selects by reputation under mode
+	mutex     sync.RWMutex
+}
+
+// ConsensusOption configures a ConsensusManager
+type ConsensusOption func(*ConsensusManager)
+
+// WithConsensusRandom draws proof-of-work randomness from src
+func WithConsensusRandom(src RandomSource) ConsensusOption {
+	return func(cm *ConsensusManager) {
+		cm.rand = src
+	}
+}
+
+// WithMinElectionWeight sets the weight floor used under ReputationWeighted
+func WithMinElectionWeight(weight float64) ConsensusOption {
+	return func(cm *ConsensusManager) {
+		cm.minWeight = weight
+	}
+}
+
+// WithParticipantSelector replaces reputation-based selection, e.g. with a
+// StakeWeightedSelector
+func WithParticipantSelector(selector ParticipantSelector) ConsensusOption {
+	return func(cm *ConsensusManager) {
+		cm.selector = selector
+	}
+}
+
+// NewConsensusManager runs hybrid consensus over a BFT node set
+func NewConsensusManager(bft *BFTManager, opts ...ConsensusOption) *ConsensusManager {
+	cm := &ConsensusManager{BFT: bft, mode: Uniform, minWeight: DefaultMinElectionWeight}
+	for _, opt := range opts {
+		opt(cm)
+	}
+	return cm
+}
+
+// source returns the randomness used for proof of work
+func (cm *ConsensusManager) source() RandomSource {
+	if cm.rand != nil {
+		return cm.rand
+	}
+	if cm.BFT != nil && cm.BFT.rand != nil {
+		return cm.BFT.rand
+	}
+	return defaultSource{}
 }
 
 // simulateProofOfWork injects random "mining" delay
@@ -18,7 +89,7 @@ func (cm *ConsensusManager) simulateProofOfWork() string {
 
 	// Simulate randomness
 	buf := make([]byte, 32)
-	rand.Read(buf)
+	cm.source().Read(buf)
 	hash := sha256.Sum256(buf)
 
 	nonce := hex.EncodeToString(hash[:])
@@ -27,28 +98,52 @@ func (cm *ConsensusManager) simulateProofOfWork() string {
 	return nonce
 }
 
-// simulateVRFLeaderElection picks one node using deterministic hash
-func (cm *ConsensusManager) simulateVRFLeaderElection(nonce string) *Node {
-	fmt.Println(" Performing VRF-based Leader Election...")
-
-	var leader *Node
-	highestScore := ""
+// SetElectionMode switches between uniform and reputation-weighted election
+func (cm *ConsensusManager) SetElectionMode(mode ElectionMode) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.mode = mode
+}
 
-	for _, node := range cm.BFT.Nodes {
-		if node.Byzantine {
-			continue
-		}
+// ElectionMode returns the current election mode
+func (cm *ConsensusManager) ElectionMode() ElectionMode {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.mode
+}
 
-		input := fmt.Sprintf("%s-%d", nonce, node.ID)
-		hash := sha256.Sum256([]byte(input))
-		score := hex.EncodeToString(hash[:])
+// SetParticipantSelector swaps the selection strategy; nil restores
+// reputation-based selection
+func (cm *ConsensusManager) SetParticipantSelector(selector ParticipantSelector) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.selector = selector
+}
 
-		if score > highestScore {
-			highestScore = score
-			leader = node
-		}
+// participantSelector returns the selection strategy in use
+func (cm *ConsensusManager) participantSelector() ParticipantSelector {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	if cm.selector != nil {
+		return cm.selector
 	}
+	selector := ReputationSelector{Mode: cm.mode, MinWeight: cm.minWeight}
+	if cm.BFT != nil {
+		selector.Reputation = cm.BFT.Reputation
+	}
+	return selector
+}
 
+// electLeader picks the leader for nonce with the current selector
+func (cm *ConsensusManager) electLeader(nonce string) *Node {
+	return cm.participantSelector().SelectLeader(cm.BFT.Nodes, nonce)
+}
+
+// simulateVRFLeaderElection picks one node using deterministic hash
+func (cm *ConsensusManager) simulateVRFLeaderElection(nonce string) *Node {
+	fmt.Printf(" Performing VRF-based Leader Election (%v)...\n", cm.participantSelector())
+
+	leader := cm.electLeader(nonce)
 	if leader != nil {
 		fmt.Printf(" Leader Elected: Node #%d\n", leader.ID)
 	} else {
@@ -58,8 +153,9 @@ func (cm *ConsensusManager) simulateVRFLeaderElection(nonce string) *Node {
 	return leader
 }
 
-// RunHybridConsensus executes PoW + BFT + VRF
-func (cm *ConsensusManager) RunHybridConsensus() {
+// ReachHybridConsensus executes PoW + BFT + VRF, returning ErrNoLeader if
+// no leader is elected or ErrQuorumNotReached if the BFT round fails
+func (cm *ConsensusManager) ReachHybridConsensus() error {
 	fmt.Println("\n Running Hybrid Consensus Protocol")
 
 	nonce := cm.simulateProofOfWork()
@@ -68,8 +164,15 @@ func (cm *ConsensusManager) RunHybridConsensus() {
 	leader := cm.simulateVRFLeaderElection(nonce)
 	if leader == nil {
 		fmt.Println(" Consensus aborted: No leader.")
-		return
+		return fmt.Errorf("%w: nonce %s", ErrNoLeader, nonce)
 	}
 
-	cm.BFT.RunConsensus()
+	return cm.BFT.ReachConsensusWith(cm.participantSelector().SelectParticipants(cm.BFT.Nodes, nonce))
+}
+
+// RunHybridConsensus executes PoW + BFT + VRF and reports whether it succeeded
+//
+// Deprecated: use ReachHybridConsensus, which reports why consensus fails.
+func (cm *ConsensusManager) RunHybridConsensus() bool {
+	return cm.ReachHybridConsensus() == nil
 }
@@ -0,0 +1,261 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors returned when scheduling per-shard consensus rounds
+var (
+	ErrNoCommittee       = errors.New("shard has no committee")
+	ErrDuplicateProposal = errors.New("shard proposed more than one block at a height")
+	ErrDependencyFailed  = errors.New("a shard the round depends on failed")
+)
+
+// DefaultConsensusConcurrency is how many shard rounds a ConsensusScheduler
+// runs at once
+const DefaultConsensusConcurrency = 4
+
+// ShardProposal is a block proposed to a shard's committee
+type ShardProposal struct {
+	ShardID   int
+	Block     Block
+	DependsOn []int // Shards whose rounds must finish first, besides those found from Block's transactions
+}
+
+// RoundResult is the outcome of one shard's round at a height
+type RoundResult struct {
+	ShardID     int                `json:"shard_id"`
+	BlockHash   string             `json:"block_hash"`
+	DependsOn   []int              `json:"depends_on,omitempty"` // Shards proposed at the height the block depends on
+	After       []int              `json:"after,omitempty"`      // Shards whose rounds finished before this one started
+	Certificate *CommitCertificate `json:"certificate,omitempty"`
+	Err         error              `json:"-"`
+	Duration    time.Duration      `json:"duration"`
+}
+
+// HeightReport aggregates the rounds of every shard proposed at a height
+type HeightReport struct {
+	Height    int           `json:"height"`
+	Results   []RoundResult `json:"results"` // Ascending by shard ID
+	Committed int           `json:"committed"`
+	Failed    int           `json:"failed"`
+	TieBreaks int           `json:"tie_breaks"` // Dependency cycles broken by shard ID
+	Duration  time.Duration `json:"duration"`
+}
+
+// ConsensusScheduler runs the consensus rounds of independent shards in
+// parallel, each with the shard's committee through RunConsensusOn. A block
+// with a transaction touching an account of another shard proposed at the
+// same height depends on that shard, and the two rounds run one after the
+// other instead. Circular dependencies are broken by running the lowest
+// shard ID of the cycle first, so a height always completes.
+type ConsensusScheduler struct {
+	esm         *EnhancedSyncManager
+	sm          *ShardManager // Optional; nil finds no dependencies from transactions
+	concurrency int
+}
+
+// SchedulerOption configures a ConsensusScheduler
+type SchedulerOption func(*ConsensusScheduler)
+
+// WithConsensusConcurrency bounds how many shard rounds run at once
+func WithConsensusConcurrency(n int) SchedulerOption {
+	return func(cs *ConsensusScheduler) {
+		cs.concurrency = n
+	}
+}
+
+// NewConsensusScheduler creates a scheduler deciding blocks with the
+// committees assigned in esm, resolving transaction addresses to the shards
+// of sm
+func NewConsensusScheduler(esm *EnhancedSyncManager, sm *ShardManager, opts ...SchedulerOption) *ConsensusScheduler {
+	cs := &ConsensusScheduler{esm: esm, sm: sm, concurrency: DefaultConsensusConcurrency}
+	for _, opt := range opts {
+		opt(cs)
+	}
+	if cs.concurrency < 1 {
+		cs.concurrency = 1
+	}
+	return cs
+}
+
+// RunHeight runs one round per proposal at height and returns once all
+// have finished. A round whose dependency failed is not run and fails with
+// ErrDependencyFailed.
+func (cs *ConsensusScheduler) RunHeight(height int, proposals []ShardProposal) (HeightReport, error) {
+	start := time.Now()
+	proposals = append([]ShardProposal{}, proposals...)
+	sort.SliceStable(proposals, func(i, j int) bool { return proposals[i].ShardID < proposals[j].ShardID })
+	position := make(map[int]int, len(proposals))
+	for i, p := range proposals {
+		if _, exists := position[p.ShardID]; exists {
+			return HeightReport{}, fmt.Errorf("%w: shard #%d at height %d", ErrDuplicateProposal, p.ShardID, height)
+		}
+		position[p.ShardID] = i
+	}
+
+	deps := make(map[int][]int, len(proposals))
+	for _, p := range proposals {
+		deps[p.ShardID] = cs.dependencies(p, position)
+	}
+	rank, tieBreaks := orderRounds(proposals, deps)
+
+	// Every dependency, in whichever direction, becomes a wait of the later
+	// round on the earlier one; ranks are a total order, so nothing waits
+	// in a circle
+	waits := make(map[int]map[int]bool, len(proposals))
+	for id, ds := range deps {
+		for _, d := range ds {
+			first, second := d, id
+			if rank[id] < rank[d] {
+				first, second = id, d
+			}
+			if waits[second] == nil {
+				waits[second] = make(map[int]bool)
+			}
+			waits[second][first] = true
+		}
+	}
+
+	results := make([]RoundResult, len(proposals))
+	done := make([]chan struct{}, len(proposals))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	slots := make(chan struct{}, cs.concurrency)
+	var wg sync.WaitGroup
+	for i, p := range proposals {
+		wg.Add(1)
+		go func(i int, p ShardProposal) {
+			defer wg.Done()
+			defer close(done[i])
+			result := RoundResult{ShardID: p.ShardID, BlockHash: p.Block.Hash, DependsOn: deps[p.ShardID]}
+			for _, id := range sortedKeys(waits[p.ShardID]) {
+				<-done[position[id]]
+				result.After = append(result.After, id)
+			}
+			for _, id := range result.DependsOn {
+				if previous := results[position[id]]; rank[id] < rank[p.ShardID] && previous.Err != nil {
+					result.Err = fmt.Errorf("%w: shard #%d: %w", ErrDependencyFailed, id, previous.Err)
+					results[i] = result
+					return
+				}
+			}
+
+			slots <- struct{}{}
+			began := time.Now()
+			cert, err := cs.esm.RunConsensusOn(p.ShardID, height, p.Block.Hash)
+			result.Duration = time.Since(began)
+			<-slots
+			if err != nil {
+				result.Err = err
+			} else {
+				result.Certificate = &cert
+			}
+			results[i] = result
+		}(i, p)
+	}
+	wg.Wait()
+
+	report := HeightReport{Height: height, Results: results, TieBreaks: tieBreaks, Duration: time.Since(start)}
+	for _, r := range results {
+		if r.Err != nil {
+			report.Failed++
+		} else {
+			report.Committed++
+		}
+	}
+	return report, nil
+}
+
+// dependencies returns the other proposed shards a proposal depends on:
+// those it names, and those owning an account its transactions touch
+func (cs *ConsensusScheduler) dependencies(p ShardProposal, proposed map[int]int) []int {
+	found := make(map[int]bool)
+	for _, id := range p.DependsOn {
+		found[id] = true
+	}
+	if cs.sm != nil {
+		for _, tx := range p.Block.Transactions {
+			for _, addr := range []string{tx.From, tx.To} {
+				if addr == "" {
+					continue
+				}
+				if shard, exists := cs.sm.ShardForAddress(addr); exists {
+					found[shard.ID] = true
+				}
+			}
+		}
+	}
+	var deps []int
+	for _, id := range sortedKeys(found) {
+		if _, exists := proposed[id]; exists && id != p.ShardID {
+			deps = append(deps, id)
+		}
+	}
+	return deps
+}
+
+// orderRounds ranks proposals so each comes after the rounds it depends
+// on, taking every ready round in shard ID order. When none is ready the
+// rest is held up by a cycle, and the lowest shard ID on a cycle is ranked
+// next regardless of its dependencies. It returns the ranks and how many
+// cycles were broken.
+func orderRounds(proposals []ShardProposal, deps map[int][]int) (map[int]int, int) {
+	rank := make(map[int]int, len(proposals))
+	tieBreaks := 0
+	for len(rank) < len(proposals) {
+		var ready []int
+		for _, p := range proposals {
+			if _, ranked := rank[p.ShardID]; ranked {
+				continue
+			}
+			waiting := false
+			for _, d := range deps[p.ShardID] {
+				if _, ranked := rank[d]; !ranked {
+					waiting = true
+				}
+			}
+			if !waiting {
+				ready = append(ready, p.ShardID)
+			}
+		}
+		if len(ready) == 0 {
+			for _, p := range proposals {
+				if _, ranked := rank[p.ShardID]; !ranked && onCycle(p.ShardID, deps, rank) {
+					ready = []int{p.ShardID}
+					tieBreaks++
+					break
+				}
+			}
+		}
+		for _, id := range ready {
+			rank[id] = len(rank)
+		}
+	}
+	return rank, tieBreaks
+}
+
+// onCycle reports whether id reaches itself through dependencies on
+// unranked shards
+func onCycle(id int, deps map[int][]int, rank map[int]int) bool {
+	seen := make(map[int]bool)
+	stack := append([]int{}, deps[id]...)
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ranked := rank[next]; ranked || seen[next] {
+			continue
+		}
+		if next == id {
+			return true
+		}
+		seen[next] = true
+		stack = append(stack, deps[next]...)
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pacedBehavior votes honestly, sleeping in each proposal step to stand in
+// for a round's network time, and tracks how many rounds sleep at once
+type pacedBehavior struct {
+	sleep  time.Duration
+	mu     *sync.Mutex
+	active *int
+	peak   *int
+}
+
+// OnPropose implements Behavior
+func (p pacedBehavior) OnPropose(n *Node, view, height int, blockHash string) Action {
+	p.mu.Lock()
+	*p.active++
+	if *p.active > *p.peak {
+		*p.peak = *p.active
+	}
+	p.mu.Unlock()
+	time.Sleep(p.sleep)
+	p.mu.Lock()
+	*p.active--
+	p.mu.Unlock()
+	return HonestBehavior{}.OnPropose(n, view, height, blockHash)
+}
+
+// OnVote implements Behavior
+func (pacedBehavior) OnVote(n *Node, v Vote) Action { return HonestBehavior{}.OnVote(n, v) }
+
+// OnTimeout implements Behavior
+func (pacedBehavior) OnTimeout(*Node, int) {}
+
+// scheduledShards returns a manager of eight shards partitioning the
+// address space, with a committee for each whose first node is paced by
+// sleep, and the shard IDs in order. peak reports the most rounds seen
+// running at once.
+func scheduledShards(t *testing.T, sleep time.Duration) (*ShardManager, *EnhancedSyncManager, []int, func() int) {
+	t.Helper()
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range fixedBlocks(16) {
+		sm.DistributeBlock(b)
+	}
+	sm.PartitionAddressSpace()
+	esm := NewEnhancedSyncManager("key")
+	var mu sync.Mutex
+	var active, peak int
+	var ids []int
+	for _, view := range sm.GetAllShardViews() {
+		committee := behaviorBFT(4)
+		committee.Nodes[0].Behavior = pacedBehavior{sleep: sleep, mu: &mu, active: &active, peak: &peak}
+		esm.AssignCommittee(view.ID, committee)
+		ids = append(ids, view.ID)
+	}
+	if len(ids) < 8 {
+		t.Fatalf("%d shards", len(ids))
+	}
+	return sm, esm, ids[:8], func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return peak
+	}
+}
+
+// accountOf returns an address owned by a shard
+func accountOf(t *testing.T, sm *ShardManager, id int) string {
+	t.Helper()
+	shard, err := sm.FindShard(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string([]byte{byte(shard.AddrLow)}) + fmt.Sprintf("acct-%d", id)
+}
+
+// proposals returns one transaction-free proposal per shard
+func proposals(ids []int) []ShardProposal {
+	var ps []ShardProposal
+	for _, id := range ids {
+		ps = append(ps, ShardProposal{ShardID: id, Block: Block{Index: 1, Hash: HashBlockData(fmt.Sprint("shard-", id))}})
+	}
+	return ps
+}
+
+func TestSchedulerRunsIndependentShardsInParallel(t *testing.T) {
+	const sleep = 100 * time.Millisecond
+	sm, esm, ids, peak := scheduledShards(t, sleep)
+
+	// Eight rounds run serially, then four and eight at a time; the bounds
+	// leave room for the rounds' signing, which a single CPU serializes
+	var elapsed []time.Duration
+	for _, concurrency := range []int{1, 4, 8} {
+		cs := NewConsensusScheduler(esm, sm, WithConsensusConcurrency(concurrency))
+		report, err := cs.RunHeight(concurrency, proposals(ids))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Committed != 8 || report.Failed != 0 || report.TieBreaks != 0 {
+			t.Fatalf("concurrency %d: report %+v", concurrency, report)
+		}
+		for i, r := range report.Results {
+			if r.ShardID != ids[i] || r.Certificate == nil || len(r.After) != 0 || r.Certificate.BlockHash != r.BlockHash {
+				t.Fatalf("result %+v", r)
+			}
+		}
+		if got := peak(); got > concurrency {
+			t.Fatalf("%d rounds at once under a limit of %d", got, concurrency)
+		}
+		elapsed = append(elapsed, report.Duration)
+	}
+	t.Logf("eight shards at concurrency 1, 4 and 8: %v", elapsed)
+	if elapsed[0] < 8*sleep || elapsed[1] > elapsed[0]*3/5 || elapsed[2] > elapsed[0]/2 {
+		t.Fatalf("throughput does not scale with concurrency: %v", elapsed)
+	}
+	if peak() != 8 {
+		t.Fatalf("at most %d of eight rounds ran at once", peak())
+	}
+}
+
+func TestSchedulerOrdersCrossShardRounds(t *testing.T) {
+	sm, esm, ids, _ := scheduledShards(t, 5*time.Millisecond)
+	cs := NewConsensusScheduler(esm, sm, WithConsensusConcurrency(8))
+
+	// Shard 0's block pays an account of shard 3, and shard 5 names shard 6
+	ps := proposals(ids)
+	ps[0].Block.Transactions = []Transaction{{From: accountOf(t, sm, ids[0]), To: accountOf(t, sm, ids[3]), Amount: 1}}
+	ps[5].DependsOn = []int{ids[6], 999}
+	report, err := cs.RunHeight(1, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Committed != 8 || report.TieBreaks != 0 {
+		t.Fatalf("report %+v", report)
+	}
+	byShard := make(map[int]RoundResult)
+	for _, r := range report.Results {
+		byShard[r.ShardID] = r
+	}
+	// Each pair runs one after the other, the dependency first
+	for dependent, dependency := range map[int]int{ids[0]: ids[3], ids[5]: ids[6]} {
+		r, d := byShard[dependent], byShard[dependency]
+		if fmt.Sprint(r.DependsOn) != fmt.Sprint([]int{dependency}) || fmt.Sprint(r.After) != fmt.Sprint([]int{dependency}) || len(d.After) != 0 {
+			t.Fatalf("shard #%d ran after %v, shard #%d after %v", dependent, r.After, dependency, d.After)
+		}
+	}
+	if len(byShard[ids[1]].After) != 0 {
+		t.Fatal("an independent round waited")
+	}
+
+	// A failed round fails those depending on it without running them
+	esm.AssignCommittee(ids[3], nil)
+	report, err = cs.RunHeight(2, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range report.Results {
+		switch r.ShardID {
+		case ids[3]:
+			if !errors.Is(r.Err, ErrNoCommittee) {
+				t.Fatalf("shard without a committee: %v", r.Err)
+			}
+		case ids[0]:
+			if !errors.Is(r.Err, ErrDependencyFailed) || r.Certificate != nil || r.Duration != 0 {
+				t.Fatalf("round depending on a failed shard: %+v", r)
+			}
+		}
+	}
+	if report.Failed != 2 || report.Committed != 6 {
+		t.Fatalf("report %+v", report)
+	}
+
+	if _, err := cs.RunHeight(3, append(ps, ps[2])); !errors.Is(err, ErrDuplicateProposal) {
+		t.Fatalf("two proposals for a shard: %v", err)
+	}
+}
+
+func TestSchedulerBreaksDependencyCycles(t *testing.T) {
+	sm, esm, ids, _ := scheduledShards(t, time.Millisecond)
+	cs := NewConsensusScheduler(esm, sm, WithConsensusConcurrency(8))
+
+	// Shards 4 → 2 → 6 → 4 form a cycle through transfers; 7 depends on 2
+	// and 1 and 0 on each other
+	ps := proposals(ids)
+	transfer := func(from, to int) []Transaction {
+		return []Transaction{{From: accountOf(t, sm, ids[from]), To: accountOf(t, sm, ids[to]), Amount: 1}}
+	}
+	ps[4].Block.Transactions = transfer(4, 2)
+	ps[2].Block.Transactions = transfer(2, 6)
+	ps[6].Block.Transactions = transfer(6, 4)
+	ps[7].DependsOn = []int{ids[2]}
+	ps[1].DependsOn = []int{ids[0]}
+	ps[0].DependsOn = []int{ids[1]}
+
+	done := make(chan HeightReport)
+	go func() {
+		report, err := cs.RunHeight(1, ps)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- report
+	}()
+	var report HeightReport
+	select {
+	case report = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("height deadlocked on a dependency cycle")
+	}
+	if report.Committed != 8 || report.TieBreaks != 2 {
+		t.Fatalf("report %+v", report)
+	}
+
+	// The lowest shard ID of each cycle goes first, and the rest follow
+	// their dependencies
+	after := make(map[int]string)
+	for _, r := range report.Results {
+		after[r.ShardID] = fmt.Sprint(r.After)
+	}
+	for id, want := range map[int][]int{
+		ids[0]: nil,
+		ids[1]: {ids[0]},
+		ids[2]: nil,
+		ids[4]: {ids[2]},
+		ids[6]: {ids[2], ids[4]},
+		ids[7]: {ids[2]},
+	} {
+		if after[id] != fmt.Sprint(want) {
+			t.Fatalf("shard #%d ran after %s, want %v", id, after[id], want)
+		}
+	}
+
+	// The same proposals in another order are scheduled the same way
+	reversed := make([]ShardProposal, len(ps))
+	for i, p := range ps {
+		reversed[len(ps)-1-i] = p
+	}
+	again, err := cs.RunHeight(2, reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range again.Results {
+		if fmt.Sprint(r.After) != after[r.ShardID] {
+			t.Fatalf("shard #%d ran after %v, before after %s", r.ShardID, r.After, after[r.ShardID])
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// electionFrequencies runs n elections over distinct nonces and returns the
+// fraction each node led
+func electionFrequencies(cm *ConsensusManager, n int) map[int]float64 {
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		if leader := cm.electLeader(fmt.Sprintf("nonce-%d", i)); leader != nil {
+			counts[leader.ID]++
+		}
+	}
+	freq := make(map[int]float64)
+	for id, c := range counts {
+		freq[id] = float64(c) / float64(n)
+	}
+	return freq
+}
+
+func electionNodes() *BFTManager {
+	return &BFTManager{Nodes: []*Node{
+		{ID: 0, Reputation: 1},
+		{ID: 1, Reputation: 0.5},
+		{ID: 2, Reputation: 0.25},
+		{ID: 3, Reputation: 0.01}, // Raised to the weight floor
+		{ID: 4, Reputation: 1, Byzantine: true},
+	}}
+}
+
+func TestReputationWeightedElectionFrequencies(t *testing.T) {
+	const elections, tolerance = 10000, 0.02
+	cm := NewConsensusManager(electionNodes())
+	cm.SetElectionMode(ReputationWeighted)
+
+	weights := []float64{1, 0.5, 0.25, DefaultMinElectionWeight}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	freq := electionFrequencies(cm, elections)
+	for id, w := range weights {
+		if want := w / total; math.Abs(freq[id]-want) > tolerance {
+			t.Errorf("node %d led %.3f of elections, want %.3f", id, freq[id], want)
+		}
+	}
+	if freq[4] != 0 {
+		t.Errorf("byzantine node led %.3f of elections", freq[4])
+	}
+	if freq[3] == 0 {
+		t.Error("the weight floor did not let the low-reputation node lead")
+	}
+}
+
+func TestUniformElectionIgnoresReputation(t *testing.T) {
+	const elections, tolerance = 10000, 0.02
+	cm := NewConsensusManager(electionNodes())
+	if cm.ElectionMode() != Uniform {
+		t.Fatalf("default election mode %s", cm.ElectionMode())
+	}
+	freq := electionFrequencies(cm, elections)
+	for id := 0; id < 4; id++ {
+		if math.Abs(freq[id]-0.25) > tolerance {
+			t.Errorf("node %d led %.3f of uniform elections, want 0.25", id, freq[id])
+		}
+	}
+}
+
+func TestMinElectionWeightOption(t *testing.T) {
+	cm := NewConsensusManager(electionNodes(), WithMinElectionWeight(0.5))
+	cm.SetElectionMode(ReputationWeighted)
+	freq := electionFrequencies(cm, 10000)
+
+	// Weights 1, 0.5, 0.5, 0.5 once the floor is raised
+	for id, want := range []float64{0.4, 0.2, 0.2, 0.2} {
+		if math.Abs(freq[id]-want) > 0.02 {
+			t.Errorf("node %d led %.3f of elections, want %.2f", id, freq[id], want)
+		}
+	}
+}
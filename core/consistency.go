@@ -1,10 +1,16 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 )
 
+// ErrQuorumNotMet is returned when an operation gathers fewer acknowledgements
+// than the current write quorum
+var ErrQuorumNotMet = errors.New("write quorum not met")
+
 type ConsistencyLevel string
 
 const (
@@ -13,20 +19,101 @@ const (
 	Eventual ConsistencyLevel = "Eventual"
 )
 
+// QuorumPolicy maps a consistency level to read and write quorum sizes for
+// a replica set of n
+type QuorumPolicy interface {
+	Quorum(level ConsistencyLevel, n int) (read, write int)
+}
+
+// DefaultQuorumPolicy uses majority reads and writes under Strong (or
+// read-one/write-all with WriteAll), majority writes under Causal and single
+// replica writes under Eventual
+type DefaultQuorumPolicy struct {
+	WriteAll bool // Strong writes go to every replica so reads need only one
+}
+
+// Quorum implements QuorumPolicy
+func (p DefaultQuorumPolicy) Quorum(level ConsistencyLevel, n int) (int, int) {
+	if n <= 0 {
+		return 0, 0
+	}
+	majority := n/2 + 1
+	switch level {
+	case Strong:
+		if p.WriteAll {
+			return 1, n
+		}
+		return majority, majority
+	case Causal:
+		return 1, majority
+	default:
+		return 1, 1
+	}
+}
+
+// QuorumSource reports the quorum currently required for a replica set of n;
+// ConsistencyOrchestrator is the usual implementation
+type QuorumSource interface {
+	CurrentQuorum(n int) (read, write int)
+}
+
+// CheckWriteQuorum reports whether acks acknowledgements out of n replicas
+// satisfy the current write quorum
+func CheckWriteQuorum(q QuorumSource, n, acks int) error {
+	_, write := q.CurrentQuorum(n)
+	if acks < write {
+		return fmt.Errorf("%w: %d of %d acknowledgements, need %d", ErrQuorumNotMet, acks, n, write)
+	}
+	return nil
+}
+
 type ConsistencyOrchestrator struct {
-	CurrentLevel ConsistencyLevel
+	CurrentLevel ConsistencyLevel // Read through Level when evaluated concurrently
 	LastLatency  time.Duration
 	ErrorRate    float64
+	policy       QuorumPolicy
+	mutex        sync.RWMutex
 }
 
 func NewOrchestrator() *ConsistencyOrchestrator {
 	return &ConsistencyOrchestrator{
 		CurrentLevel: Strong, // default
+		policy:       DefaultQuorumPolicy{},
 	}
 }
 
+// SetQuorumPolicy replaces the mapping from consistency level to quorum sizes
+func (co *ConsistencyOrchestrator) SetQuorumPolicy(policy QuorumPolicy) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+	co.policy = policy
+}
+
+// Level returns the current consistency level
+func (co *ConsistencyOrchestrator) Level() ConsistencyLevel {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	return co.CurrentLevel
+}
+
+// CurrentQuorum returns the read and write quorum for a replica set of n.
+// The level and policy are read under one lock, so the quorum always
+// matches a level the orchestrator was actually in.
+func (co *ConsistencyOrchestrator) CurrentQuorum(n int) (int, int) {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	policy := co.policy
+	if policy == nil {
+		policy = DefaultQuorumPolicy{}
+	}
+	return policy.Quorum(co.CurrentLevel, n)
+}
+
 // Simulate monitoring: adjusts based on latency/error rate
 func (co *ConsistencyOrchestrator) EvaluateNetwork(latency time.Duration, errorRate float64) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
 	co.LastLatency = latency
 	co.ErrorRate = errorRate
 
@@ -40,6 +127,9 @@ func (co *ConsistencyOrchestrator) EvaluateNetwork(latency time.Duration, errorR
 }
 
 func (co *ConsistencyOrchestrator) PrintStatus() {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+
 	fmt.Println("=== Consistency Orchestrator ===")
 	fmt.Printf("Current Level: %s\n", co.CurrentLevel)
 	fmt.Printf("Last Latency: %s\n", co.LastLatency)
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockWritePath accepts a write once its acknowledgements meet the quorum
+// in force at the time, recording the quorum it used
+type mockWritePath struct {
+	quorum QuorumSource
+	n      int
+	used   []int
+}
+
+func (w *mockWritePath) Write(acks int) error {
+	_, write := w.quorum.CurrentQuorum(w.n)
+	w.used = append(w.used, write)
+	return CheckWriteQuorum(w.quorum, w.n, acks)
+}
+
+// levelConditions drives EvaluateNetwork into each consistency level
+var levelConditions = []struct {
+	level     ConsistencyLevel
+	latency   time.Duration
+	errorRate float64
+}{
+	{Strong, 50 * time.Millisecond, 0},
+	{Causal, 150 * time.Millisecond, 0},
+	{Eventual, 300 * time.Millisecond, 0},
+	{Causal, 50 * time.Millisecond, 0.05},
+	{Strong, 10 * time.Millisecond, 0.01},
+}
+
+func TestQuorumFollowsConsistencyLevel(t *testing.T) {
+	const n = 5
+	for _, policy := range []DefaultQuorumPolicy{{}, {WriteAll: true}} {
+		co := NewOrchestrator()
+		co.SetQuorumPolicy(policy)
+		want := map[ConsistencyLevel][2]int{Strong: {3, 3}, Causal: {1, 3}, Eventual: {1, 1}}
+		if policy.WriteAll {
+			want[Strong] = [2]int{1, 5}
+		}
+
+		path := &mockWritePath{quorum: co, n: n}
+		for _, c := range levelConditions {
+			co.EvaluateNetwork(c.latency, c.errorRate)
+			if co.Level() != c.level {
+				t.Fatalf("latency %v, error rate %v: level %s, want %s", c.latency, c.errorRate, co.Level(), c.level)
+			}
+			read, write := co.CurrentQuorum(n)
+			if [2]int{read, write} != want[c.level] {
+				t.Fatalf("write-all %v, %s: quorum %d/%d, want %v", policy.WriteAll, c.level, read, write, want[c.level])
+			}
+
+			// One acknowledgement short of the quorum is refused, the quorum itself accepted
+			if err := path.Write(write - 1); !errors.Is(err, ErrQuorumNotMet) {
+				t.Fatalf("%s: write with %d of %d acknowledgements: %v", c.level, write-1, write, err)
+			}
+			if err := path.Write(write); err != nil {
+				t.Fatalf("%s: write meeting the quorum refused: %v", c.level, err)
+			}
+		}
+		if len(path.used) != 2*len(levelConditions) {
+			t.Fatalf("write path consulted the quorum %d times", len(path.used))
+		}
+	}
+}
+
+func TestQuorumSizesForSmallSets(t *testing.T) {
+	policy := DefaultQuorumPolicy{}
+	cases := []struct {
+		level       ConsistencyLevel
+		n           int
+		read, write int
+	}{
+		{Strong, 0, 0, 0},
+		{Strong, 1, 1, 1},
+		{Strong, 2, 2, 2},
+		{Strong, 4, 3, 3},
+		{Causal, 2, 1, 2},
+		{Causal, 7, 1, 4},
+		{Eventual, 7, 1, 1},
+	}
+	for _, c := range cases {
+		if read, write := policy.Quorum(c.level, c.n); read != c.read || write != c.write {
+			t.Errorf("%s over %d replicas: %d/%d, want %d/%d", c.level, c.n, read, write, c.read, c.write)
+		}
+	}
+}
+
+func TestReplicaWritesConsultCurrentQuorum(t *testing.T) {
+	co := NewOrchestrator()
+	rs := NewShardReplicaSet(0, 5, co)
+	for i := 0; i < 3; i++ {
+		if err := rs.SetAvailable(i, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block := mustGenerateBlock(t, GenesisBlock(), "replicated")
+
+	// Two of five replicas fall short of the majority Strong and Causal need
+	for _, c := range levelConditions[:2] {
+		co.EvaluateNetwork(c.latency, c.errorRate)
+		if _, err := rs.AddBlock(block); !errors.Is(err, ErrQuorumNotMet) {
+			t.Fatalf("%s: write to 2 of 5 replicas: %v", c.level, err)
+		}
+	}
+	co.EvaluateNetwork(levelConditions[2].latency, levelConditions[2].errorRate)
+	acks, err := rs.AddBlock(block)
+	if err != nil || acks != 2 {
+		t.Fatalf("eventual write: %d acknowledgements, %v", acks, err)
+	}
+}
+
+func TestBFTRequiredVotesFollowsLevel(t *testing.T) {
+	co := NewOrchestrator()
+	bft := NewBFTManager(9, WithBFTQuorum(co))
+	want := map[ConsistencyLevel]int{Strong: 5, Causal: 5, Eventual: 1}
+	for _, c := range levelConditions {
+		co.EvaluateNetwork(c.latency, c.errorRate)
+		if got := bft.RequiredVotes(); got != want[c.level] {
+			t.Fatalf("%s: %d votes required, want %d", c.level, got, want[c.level])
+		}
+	}
+	if got := NewBFTManager(9).RequiredVotes(); got != 6 {
+		t.Fatalf("%d votes required without a quorum source, want 6", got)
+	}
+}
+
+func TestQuorumChangesAtomicallyWithLevel(t *testing.T) {
+	const n = 5
+	co := NewOrchestrator()
+	co.SetQuorumPolicy(DefaultQuorumPolicy{WriteAll: true})
+	valid := map[[2]int]bool{{1, 5}: true, {1, 3}: true, {1, 1}: true}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c := levelConditions[i%len(levelConditions)]
+			co.EvaluateNetwork(c.latency, c.errorRate)
+		}
+	}()
+	for i := 0; i < 10000; i++ {
+		read, write := co.CurrentQuorum(n)
+		if !valid[[2]int{read, write}] {
+			close(stop)
+			wg.Wait()
+			t.Fatalf("quorum %d/%d matches no consistency level", read, write)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
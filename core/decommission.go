@@ -0,0 +1,153 @@
+package core
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultTombstoneRetention is how long a decommissioned node's tombstone
+// is kept, and gossiped, before it expires
+const DefaultTombstoneRetention = 24 * time.Hour
+
+// Tombstone records that a node was decommissioned, so that metrics about
+// it gossiped by peers that still remember it do not bring it back
+type Tombstone struct {
+	NodeID    string    `json:"node_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Clock     uint64    `json:"clock"` // The node's vector clock entry when decommissioned
+}
+
+// supersedes reports whether t replaces other as a node's tombstone
+func (t Tombstone) supersedes(other Tombstone) bool {
+	if t.Clock != other.Clock {
+		return t.Clock > other.Clock
+	}
+	return t.Timestamp.After(other.Timestamp)
+}
+
+// suppresses reports whether metrics about the tombstoned node predate it.
+// Metrics stamped with a vector clock are judged by the node's entry in it,
+// others by their timestamp.
+func (t Tombstone) suppresses(metrics NetworkMetrics) bool {
+	if metrics.VectorClock != nil {
+		return metrics.VectorClock.Get(t.NodeID) <= t.Clock
+	}
+	return !metrics.Timestamp.After(t.Timestamp)
+}
+
+// WithTombstoneRetention sets how long tombstones are kept, zero or less
+// keeping them forever. Metrics older than an expired tombstone are
+// accepted again, so retention should comfortably exceed the time gossip
+// takes to reach every peer.
+func WithTombstoneRetention(retention time.Duration) AdaptiveCapacityOption {
+	return func(acm *AdaptiveCapacityManager) {
+		acm.retention = retention
+	}
+}
+
+// DecommissionNode forgets a node's capacity and metric history and records
+// a tombstone for it, ticking its vector clock entry. Until the tombstone
+// expires, metrics about the node that are not newer than it are ignored,
+// whether recorded locally or received from peers.
+func (acm *AdaptiveCapacityManager) DecommissionNode(nodeID string) Tombstone {
+	acm.mu.Lock()
+	defer acm.mu.Unlock()
+
+	acm.vectorClock.Update(nodeID)
+	tombstone := Tombstone{
+		NodeID:    nodeID,
+		Timestamp: acm.now(),
+		Clock:     acm.vectorClock.Get(nodeID),
+	}
+	acm.bury(tombstone)
+	acm.expireTombstones()
+	return tombstone
+}
+
+// MergeTombstones applies tombstones gossiped by a peer, forgetting the
+// nodes they name unless a local tombstone is already newer. Expired
+// tombstones are dropped rather than merged.
+func (acm *AdaptiveCapacityManager) MergeTombstones(tombstones []Tombstone) {
+	acm.mu.Lock()
+	defer acm.mu.Unlock()
+
+	for _, tombstone := range tombstones {
+		if acm.expired(tombstone) {
+			continue
+		}
+		if existing, exists := acm.tombstones[tombstone.NodeID]; exists && !tombstone.supersedes(existing) {
+			continue
+		}
+		entry := NewVectorClock()
+		entry.clock[tombstone.NodeID] = tombstone.Clock
+		acm.vectorClock.Merge(entry)
+		acm.bury(tombstone)
+	}
+	acm.expireTombstones()
+}
+
+// Tombstones returns the unexpired tombstones, ordered by node ID, for
+// gossiping alongside metrics
+func (acm *AdaptiveCapacityManager) Tombstones() []Tombstone {
+	acm.mu.Lock()
+	defer acm.mu.Unlock()
+	acm.expireTombstones()
+
+	tombstones := make([]Tombstone, 0, len(acm.tombstones))
+	for _, tombstone := range acm.tombstones {
+		tombstones = append(tombstones, tombstone)
+	}
+	sort.Slice(tombstones, func(i, j int) bool { return tombstones[i].NodeID < tombstones[j].NodeID })
+	return tombstones
+}
+
+// IsDecommissioned reports whether the node has an unexpired tombstone
+func (acm *AdaptiveCapacityManager) IsDecommissioned(nodeID string) bool {
+	acm.mu.RLock()
+	defer acm.mu.RUnlock()
+	tombstone, exists := acm.tombstones[nodeID]
+	return exists && !acm.expired(tombstone)
+}
+
+// bury records a tombstone and drops the node's state, keeping only
+// metrics newer than the tombstone; the caller must hold the lock
+func (acm *AdaptiveCapacityManager) bury(tombstone Tombstone) {
+	acm.tombstones[tombstone.NodeID] = tombstone
+
+	var kept []NetworkMetrics
+	for _, metrics := range acm.metricHistory[tombstone.NodeID] {
+		if !tombstone.suppresses(metrics) {
+			kept = append(kept, metrics)
+		}
+	}
+	if len(kept) > 0 {
+		acm.metricHistory[tombstone.NodeID] = kept
+		acm.nodeCapacities[tombstone.NodeID] = acm.policy.AdjustCapacity(kept[len(kept)-1])
+		return
+	}
+	delete(acm.metricHistory, tombstone.NodeID)
+	delete(acm.nodeCapacities, tombstone.NodeID)
+	delete(acm.nodeLastUpdate, tombstone.NodeID)
+}
+
+// buried reports whether metrics about nodeID are suppressed by an
+// unexpired tombstone; the caller must hold the lock
+func (acm *AdaptiveCapacityManager) buried(nodeID string, metrics NetworkMetrics) bool {
+	tombstone, exists := acm.tombstones[nodeID]
+	return exists && !acm.expired(tombstone) && tombstone.suppresses(metrics)
+}
+
+// expired reports whether a tombstone has outlived the retention
+func (acm *AdaptiveCapacityManager) expired(tombstone Tombstone) bool {
+	return acm.retention > 0 && acm.now().Sub(tombstone.Timestamp) > acm.retention
+}
+
+// expireTombstones drops tombstones past the retention; the caller must
+// hold the lock
+func (acm *AdaptiveCapacityManager) expireTombstones() {
+	for nodeID, tombstone := range acm.tombstones {
+		if acm.expired(tombstone) {
+			delete(acm.tombstones, nodeID)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// gossipOnce sends from's tombstones and latest metrics to to, as a
+// capacity gossip round does
+func gossipOnce(from, to *AdaptiveCapacityManager) {
+	to.MergeTombstones(from.Tombstones())
+	metrics := make(map[string]NetworkMetrics)
+	for nodeID := range from.GetGlobalView() {
+		if latest, ok := from.GetLatestMetrics(nodeID); ok {
+			metrics[nodeID] = latest
+		}
+	}
+	to.SyncWithPeer(metrics, from.GetVectorClock())
+}
+
+// checkGone fails unless every manager has forgotten nodeID
+func checkGone(t *testing.T, nodeID string, managers ...*AdaptiveCapacityManager) {
+	t.Helper()
+	for i, acm := range managers {
+		if _, ok := acm.GetLatestMetrics(nodeID); ok {
+			t.Fatalf("manager %d has metrics for %s", i, nodeID)
+		}
+		if _, ok := acm.GetGlobalView()[nodeID]; ok {
+			t.Fatalf("manager %d has a capacity for %s", i, nodeID)
+		}
+		if !acm.IsDecommissioned(nodeID) {
+			t.Fatalf("manager %d has no tombstone for %s", i, nodeID)
+		}
+	}
+}
+
+func TestDecommissionedNodeStaysGone(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	a := NewAdaptiveCapacityManager("node-a", WithCapacityClock(clock.Now))
+	b := NewAdaptiveCapacityManager("node-b", WithCapacityClock(clock.Now))
+	d := NewAdaptiveCapacityManager("node-d", WithCapacityClock(clock.Now))
+	for _, acm := range []*AdaptiveCapacityManager{a, b, d} {
+		acm.RecordMetrics(NetworkMetrics{NodeID: "node-c", Latency: time.Millisecond})
+	}
+
+	// Peers keep hearing from node-c right up to its decommissioning
+	clock.Advance(time.Minute)
+	b.RecordMetrics(NetworkMetrics{NodeID: "node-c", Latency: 2 * time.Millisecond})
+	clock.Advance(time.Minute)
+	tombstone := a.DecommissionNode("node-c")
+	if tombstone.NodeID != "node-c" || !tombstone.Timestamp.Equal(clock.now) || tombstone.Clock != 2 {
+		t.Fatalf("tombstone %+v", tombstone)
+	}
+	checkGone(t, "node-c", a)
+
+	// b gossips its fresh metrics first, then learns of the tombstone
+	clock.Advance(time.Second)
+	gossipOnce(b, a)
+	checkGone(t, "node-c", a)
+	gossipOnce(a, b)
+	checkGone(t, "node-c", a, b)
+
+	// d only ever hears from b, and the metrics it holds do not come back
+	gossipOnce(d, b)
+	gossipOnce(b, d)
+	gossipOnce(d, a)
+	checkGone(t, "node-c", a, b, d)
+
+	// Local reports predating the tombstone are ignored too
+	d.RecordMetrics(NetworkMetrics{NodeID: "node-c", Timestamp: tombstone.Timestamp.Add(-time.Second)})
+	checkGone(t, "node-c", d)
+
+	// A report newer than the tombstone is a node coming back
+	clock.Advance(time.Minute)
+	b.RecordMetrics(NetworkMetrics{NodeID: "node-c"})
+	if _, ok := b.GetLatestMetrics("node-c"); !ok {
+		t.Fatal("metrics newer than the tombstone ignored")
+	}
+}
+
+func TestTombstoneSuppressesByVectorClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	a := NewAdaptiveCapacityManager("node-a", WithCapacityClock(clock.Now))
+	tombstone := a.DecommissionNode("node-c")
+
+	// A report stamped later, but concurrent with the decommissioning by
+	// node-c's clock entry, is still suppressed
+	clock.Advance(time.Minute)
+	concurrent := NewVectorClock()
+	concurrent.Update("node-c")
+	a.SyncWithPeer(map[string]NetworkMetrics{"node-c": {NodeID: "node-c", Timestamp: clock.now, VectorClock: concurrent}}, nil)
+	checkGone(t, "node-c", a)
+
+	after := concurrent.Clone()
+	for after.Get("node-c") <= tombstone.Clock {
+		after.Update("node-c")
+	}
+	a.SyncWithPeer(map[string]NetworkMetrics{"node-c": {NodeID: "node-c", Timestamp: clock.now, VectorClock: after}}, nil)
+	if _, ok := a.GetLatestMetrics("node-c"); !ok {
+		t.Fatal("metrics after the tombstone by vector clock ignored")
+	}
+
+	// A newer tombstone replaces an older one; an older one changes nothing
+	b := NewAdaptiveCapacityManager("node-b", WithCapacityClock(clock.Now))
+	newer := b.DecommissionNode("node-c")
+	newer.Clock = after.Get("node-c") + 1
+	a.MergeTombstones([]Tombstone{tombstone, newer})
+	checkGone(t, "node-c", a)
+	if got := a.Tombstones(); len(got) != 1 || got[0].Clock != newer.Clock {
+		t.Fatalf("tombstones %+v", got)
+	}
+}
+
+func TestTombstonesExpire(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	a := NewAdaptiveCapacityManager("node-a", WithCapacityClock(clock.Now), WithTombstoneRetention(time.Hour))
+	b := NewAdaptiveCapacityManager("node-b", WithCapacityClock(clock.Now), WithTombstoneRetention(time.Hour))
+	b.RecordMetrics(NetworkMetrics{NodeID: "node-c"})
+	a.DecommissionNode("node-c")
+	a.DecommissionNode("node-e")
+	stale := a.Tombstones()
+	if len(stale) != 2 || stale[0].NodeID != "node-c" || stale[1].NodeID != "node-e" {
+		t.Fatalf("tombstones %+v", stale)
+	}
+
+	clock.Advance(time.Hour + time.Second)
+	if a.IsDecommissioned("node-c") || len(a.Tombstones()) != 0 {
+		t.Fatal("tombstone kept past the retention")
+	}
+	// Expired tombstones are not merged, so old metrics are accepted again
+	b.MergeTombstones(stale)
+	if b.IsDecommissioned("node-c") {
+		t.Fatal("expired tombstone merged")
+	}
+	gossipOnce(b, a)
+	if _, ok := a.GetLatestMetrics("node-c"); !ok {
+		t.Fatal("metrics ignored after the tombstone expired")
+	}
+
+	// Without retention tombstones are kept forever
+	forever := NewAdaptiveCapacityManager("node-f", WithCapacityClock(clock.Now), WithTombstoneRetention(0))
+	forever.DecommissionNode("node-c")
+	clock.Advance(365 * 24 * time.Hour)
+	if !forever.IsDecommissioned("node-c") {
+		t.Fatal("tombstone expired without a retention")
+	}
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+)
+
+// ErrDifficultyMismatch is returned for a block whose difficulty does not
+// follow the retargeting schedule
+var ErrDifficultyMismatch = errors.New("difficulty does not follow schedule")
+
+// MaxRetargetFactor bounds how much difficulty may change per interval
+const MaxRetargetFactor = 4
+
+// DifficultySchedule retargets proof-of-work difficulty every
+// RetargetInterval blocks so blocks arrive TargetBlockTime apart
+type DifficultySchedule struct {
+	InitialDifficulty uint64        `json:"initial_difficulty"`
+	RetargetInterval  int           `json:"retarget_interval"`
+	TargetBlockTime   time.Duration `json:"target_block_time"`
+}
+
+// DefaultDifficultySchedule retargets every 10 blocks towards 10s spacing
+func DefaultDifficultySchedule() DifficultySchedule {
+	return DifficultySchedule{
+		InitialDifficulty: 1 << 16,
+		RetargetInterval:  10,
+		TargetBlockTime:   10 * time.Second,
+	}
+}
+
+// Next returns the difficulty of the block following chain, whose blocks
+// must be contiguous with the parent last. Blocks without a difficulty,
+// like genesis, count as InitialDifficulty. At every multiple of
+// RetargetInterval the parent's difficulty is scaled by the ratio of the
+// target to the actual time the last interval took.
+func (s DifficultySchedule) Next(chain []Block) uint64 {
+	if len(chain) == 0 {
+		return s.initial()
+	}
+	parent := chain[len(chain)-1]
+	difficulty := parent.Difficulty
+	if difficulty == 0 {
+		difficulty = s.initial()
+	}
+	if s.RetargetInterval <= 0 || (parent.Index+1)%s.RetargetInterval != 0 {
+		return difficulty
+	}
+
+	first := len(chain) - 1 - s.RetargetInterval
+	if first < 0 {
+		first = 0
+	}
+	spacings := len(chain) - 1 - first
+	if spacings == 0 {
+		return difficulty
+	}
+	actual := parent.Timestamp.Sub(chain[first].Timestamp)
+	return Retarget(difficulty, actual, s.TargetBlockTime*time.Duration(spacings))
+}
+
+// initial returns the starting difficulty, at least 1
+func (s DifficultySchedule) initial() uint64 {
+	if s.InitialDifficulty == 0 {
+		return 1
+	}
+	return s.InitialDifficulty
+}
+
+// Retarget scales difficulty by expected/actual, clamped to a change of
+// MaxRetargetFactor either way and to at least 1
+func Retarget(difficulty uint64, actual, expected time.Duration) uint64 {
+	if actual < 1 {
+		actual = 1
+	}
+	scaled := new(big.Int).SetUint64(difficulty)
+	scaled.Mul(scaled, big.NewInt(int64(expected)))
+	scaled.Quo(scaled, big.NewInt(int64(actual)))
+
+	low := difficulty / MaxRetargetFactor
+	high := new(big.Int).Mul(new(big.Int).SetUint64(difficulty), big.NewInt(MaxRetargetFactor))
+	switch {
+	case scaled.Cmp(high) > 0:
+		scaled = high
+	case scaled.Cmp(new(big.Int).SetUint64(low)) < 0:
+		scaled.SetUint64(low)
+	}
+	if !scaled.IsUint64() {
+		return math.MaxUint64
+	}
+	if scaled.Sign() == 0 {
+		return 1
+	}
+	return scaled.Uint64()
+}
+
+// SetDifficultySchedule stamps new blocks with the schedule's difficulty
+// and makes Validate and AddBlockCandidate enforce it
+func (bc *Blockchain) SetDifficultySchedule(s DifficultySchedule) {
+	bc.difficulty = &s
+}
+
+// NextDifficulty returns the difficulty of the next main-chain block, or 0
+// without a schedule
+func (bc *Blockchain) NextDifficulty() uint64 {
+	if bc.difficulty == nil {
+		return 0
+	}
+	return bc.difficulty.Next(bc.Blocks)
+}
+
+// stampDifficulty sets the scheduled difficulty on a new tip block
+func (bc *Blockchain) stampDifficulty(b *Block) {
+	if bc.difficulty == nil {
+		return
+	}
+	b.Difficulty = bc.difficulty.Next(bc.Blocks)
+	b.Hash = calculateHash(*b)
+}
+
+// checkDifficulty verifies the difficulty of blocks[i] against the blocks
+// before it. Blocks whose retarget window was pruned are not checked.
+func (bc *Blockchain) checkDifficulty(blocks []Block, i int) error {
+	return bc.checkNextDifficulty(blocks[:i], blocks[i])
+}
+
+// checkNextDifficulty verifies the difficulty of b as the block after chain
+func (bc *Blockchain) checkNextDifficulty(chain []Block, b Block) error {
+	s := bc.difficulty
+	if s == nil || len(chain) == 0 {
+		return nil
+	}
+	if chain[0].Index != 0 && len(chain) <= s.RetargetInterval {
+		return nil
+	}
+	if want := s.Next(chain); b.Difficulty != want {
+		return fmt.Errorf("%w: block #%d has %d, want %d", ErrDifficultyMismatch, b.Index, b.Difficulty, want)
+	}
+	return nil
+}
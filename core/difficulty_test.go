@@ -0,0 +1,183 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testSchedule retargets every 10 blocks towards 10s spacing from 1000
+var testSchedule = DifficultySchedule{InitialDifficulty: 1000, RetargetInterval: 10, TargetBlockTime: 10 * time.Second}
+
+// mineAt appends a block with a synthetic timestamp and the scheduled
+// difficulty through AddBlockCandidate
+func mineAt(t *testing.T, bc *Blockchain, data string, at time.Time) {
+	t.Helper()
+	b, err := GenerateBlockAt(bc.Blocks[len(bc.Blocks)-1], data, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.stampDifficulty(&b)
+	if err := bc.AddBlockCandidate(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// minedChain simulates a miner hashing rate difficulty units per second for
+// n blocks, so each block takes difficulty/rate seconds to find
+func minedChain(t *testing.T, rate float64, n int) *Blockchain {
+	t.Helper()
+	bc := NewBlockchain()
+	bc.SetDifficultySchedule(testSchedule)
+	at := bc.Blocks[0].Timestamp
+	for i := 0; i < n; i++ {
+		at = at.Add(time.Duration(float64(bc.NextDifficulty()) / rate * float64(time.Second)))
+		mineAt(t, bc, "mined", at)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// windowSpacing returns the mean block spacing of the interval ending at
+// block end
+func windowSpacing(bc *Blockchain, end int) time.Duration {
+	start := end - testSchedule.RetargetInterval
+	return bc.Blocks[end].Timestamp.Sub(bc.Blocks[start].Timestamp) / time.Duration(testSchedule.RetargetInterval)
+}
+
+func TestDifficultyConvergesToTargetSpacing(t *testing.T) {
+	for _, miner := range []struct {
+		name string
+		rate float64
+	}{
+		{"slow", 1},   // 1000s blocks at the initial difficulty
+		{"fast", 1e4}, // 0.1s blocks at the initial difficulty
+		{"matched", 100},
+	} {
+		t.Run(miner.name, func(t *testing.T) {
+			bc := minedChain(t, miner.rate, 200)
+			target := testSchedule.TargetBlockTime
+
+			distance := func(d time.Duration) time.Duration {
+				if d < target {
+					return target - d
+				}
+				return d - target
+			}
+			prev := distance(windowSpacing(bc, testSchedule.RetargetInterval))
+			for end := 2 * testSchedule.RetargetInterval; end < len(bc.Blocks); end += testSchedule.RetargetInterval {
+				cur := distance(windowSpacing(bc, end))
+				if cur > prev+target/100 {
+					t.Fatalf("spacing moved away from target at #%d: off by %v after %v", end, cur, prev)
+				}
+				prev = cur
+			}
+			if spacing := windowSpacing(bc, len(bc.Blocks)-1); spacing < 9*time.Second || spacing > 11*time.Second {
+				t.Fatalf("final spacing %v", spacing)
+			}
+			want := uint64(miner.rate * target.Seconds())
+			if d := bc.NextDifficulty(); d < want*9/10 || d > want*11/10 {
+				t.Fatalf("difficulty %d, want about %d", d, want)
+			}
+		})
+	}
+}
+
+func TestRetargetClampsPerInterval(t *testing.T) {
+	if d := Retarget(100, time.Second, time.Hour); d != 100*MaxRetargetFactor {
+		t.Fatalf("fast interval retargeted to %d", d)
+	}
+	if d := Retarget(100, time.Hour, time.Second); d != 100/MaxRetargetFactor {
+		t.Fatalf("slow interval retargeted to %d", d)
+	}
+	if d := Retarget(2, time.Hour, time.Second); d != 1 {
+		t.Fatalf("difficulty dropped to %d", d)
+	}
+
+	// The first retarget of a very fast miner is capped at 4x
+	bc := minedChain(t, 1e6, testSchedule.RetargetInterval)
+	if d := bc.NextDifficulty(); d != testSchedule.InitialDifficulty*MaxRetargetFactor {
+		t.Fatalf("first retarget to %d", d)
+	}
+}
+
+// scheduledBranch extends chain by n blocks spacing
+// apart with the difficulty testSchedule gives each
+func scheduledBranch(t *testing.T, chain []Block, n int, spacing time.Duration) []Block {
+	t.Helper()
+	chain = append([]Block{}, chain...)
+	var branch []Block
+	for i := 0; i < n; i++ {
+		parent := chain[len(chain)-1]
+		b, err := GenerateBlockAt(parent, "branch", parent.Timestamp.Add(spacing))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Difficulty = testSchedule.Next(chain)
+		b.Hash = b.ComputeHash()
+		chain = append(chain, b)
+		branch = append(branch, b)
+	}
+	return branch
+}
+
+func TestResolveForksPrefersHeavierBranch(t *testing.T) {
+	bc := NewBlockchain()
+	bc.SetDifficultySchedule(testSchedule)
+	genesis := bc.Blocks[0]
+
+	// Twelve slow blocks retarget down to 250; ten fast ones end at 4000
+	light := scheduledBranch(t, bc.Blocks, 12, 100*time.Second)
+	heavy := scheduledBranch(t, bc.Blocks, 10, time.Second)
+	if light[11].Difficulty != 250 || heavy[9].Difficulty != 4000 {
+		t.Fatalf("branch tips at difficulty %d and %d", light[11].Difficulty, heavy[9].Difficulty)
+	}
+	for _, b := range append(light, heavy...) {
+		if err := bc.AddBlockCandidate(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bc.Blocks[len(bc.Blocks)-1].Hash != light[11].Hash {
+		t.Fatal("light branch not the main chain")
+	}
+
+	// 9000+4000 beats 9000+3*250 although the branch is two blocks shorter
+	rolledBack, applied, err := bc.ResolveForks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolledBack) != 12 || len(applied) != 10 || bc.Blocks[len(bc.Blocks)-1].Hash != heavy[9].Hash {
+		t.Fatalf("rolled back %d and applied %d blocks", len(rolledBack), len(applied))
+	}
+	if bc.Blocks[0].Hash != genesis.Hash {
+		t.Fatal("genesis replaced")
+	}
+
+	// The longer, lighter branch does not win it back
+	if rolledBack, applied, err := bc.ResolveForks(); err != nil || len(rolledBack) != 0 || len(applied) != 0 {
+		t.Fatalf("reorganized to the lighter branch: %d, %d, %v", len(rolledBack), len(applied), err)
+	}
+}
+
+func TestValidateRecomputesDifficulty(t *testing.T) {
+	bc := minedChain(t, 1e4, 50)
+	bc.Blocks[25].Difficulty++
+	bc.Blocks[25].Hash = bc.Blocks[25].ComputeHash()
+	if err := bc.Validate(); !errors.Is(err, ErrDifficultyMismatch) {
+		t.Fatalf("tampered difficulty: %v", err)
+	}
+
+	bc = minedChain(t, 1e4, 50)
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	b, err := GenerateBlockAt(tip, "cheap", tip.Timestamp.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Difficulty = 1
+	b.Hash = b.ComputeHash()
+	if err := bc.AddBlockCandidate(b); !errors.Is(err, ErrDifficultyMismatch) {
+		t.Fatalf("understated difficulty: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// fixedBlocks returns a chain of n blocks with fixed timestamps, so their
+// hashes and every root over them are the same on each run
+func fixedBlocks(n int) []Block {
+	blocks := make([]Block, n)
+	prevHash := ""
+	for i := range blocks {
+		b := Block{
+			Index:     i + 1,
+			Timestamp: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+			Data:      fmt.Sprintf("block-%d", i+1),
+			PrevHash:  prevHash,
+		}
+		b.Hash = calculateHash(b)
+		blocks[i], prevHash = b, b.Hash
+	}
+	return blocks
+}
+
+// checkGolden compares v encoded as indented JSON with testdata/name,
+// rewriting the file instead when run with -update
+func checkGolden(t *testing.T, name string, v any) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s changed:\n%s", path, got)
+	}
+}
+
+func TestTrieDumpGolden(t *testing.T) {
+	st := NewSuccinctTrie()
+	for _, key := range []string{"shard", "shards", "sharp", "state", "stake", "s"} {
+		st.Insert(key, "value-"+key)
+	}
+	dump := st.Dump()
+	if dump.Keys != 6 || dump.RootHash != st.GetMerkleRoot() {
+		t.Fatalf("dump of %d keys with root %s", dump.Keys, dump.RootHash)
+	}
+	checkGolden(t, "trie_dump.json", dump)
+
+	var out strings.Builder
+	st.PrintTrie(&out)
+	if !strings.Contains(out.String(), "Leaf: Value=value-shards") || !strings.Contains(out.String(), "Edge [har]:") {
+		t.Fatalf("printed trie:\n%s", out.String())
+	}
+}
+
+func TestShardIndexDumpGolden(t *testing.T) {
+	si := NewShardIndex()
+	blocks := fixedBlocks(7)
+	for id := 0; id < 7; id++ {
+		shard := NewShard(id)
+		for _, b := range blocks[:id%3+1] {
+			shard.AddBlock(b)
+		}
+		si.Insert(shard)
+	}
+	entries := si.Dump()
+	if len(entries) != 7 || entries[0].Depth != 0 || entries[0].Color != "Black" {
+		t.Fatalf("index dump %+v", entries)
+	}
+	checkGolden(t, "shard_index_dump.json", entries)
+
+	var out strings.Builder
+	si.PrintTree(&out)
+	if lines := strings.Count(out.String(), "Shard #"); lines != 7 {
+		t.Fatalf("printed %d shards:\n%s", lines, out.String())
+	}
+}
+
+func TestForestStateGolden(t *testing.T) {
+	sm := NewShardManager()
+	for _, b := range fixedBlocks(25) {
+		sm.DistributeBlock(b)
+	}
+	state := sm.State()
+	if state.ForestRoot != sm.ForestRoot() || len(state.Shards) != state.Stats.Size || len(state.Index) != state.Stats.Size {
+		t.Fatalf("forest state %+v", state)
+	}
+	checkGolden(t, "forest_state.json", state)
+
+	var out strings.Builder
+	sm.PrintShardState(&out)
+	if !strings.Contains(out.String(), "Forest root: "+state.ForestRoot) {
+		t.Fatalf("printed forest:\n%s", out.String())
+	}
+}
+
+func TestStateReportGolden(t *testing.T) {
+	sm := NewStateManager(4)
+	for _, b := range fixedBlocks(6) {
+		sm.AddBlock(b)
+	}
+	report := sm.State()
+	if report.ActiveCount != 4 || report.ArchivedCount != 2 || report.ActiveTrie.Keys != 4 {
+		t.Fatalf("state report counts %d/%d", report.ActiveCount, report.ArchivedCount)
+	}
+	checkGolden(t, "state_report.json", report)
+
+	var out strings.Builder
+	sm.PrintState(&out)
+	if !strings.Contains(out.String(), "Active Blocks: 4") || !strings.Contains(out.String(), "Archive Trie Merkle Root: "+report.ArchiveRoot) {
+		t.Fatalf("printed state:\n%s", out.String())
+	}
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ErrNoValidators is returned when an epoch starts without nodes to assign
+var ErrNoValidators = errors.New("no nodes to assign shards to")
+
+// DefaultEpochLength is the number of blocks between shard reshuffles
+const DefaultEpochLength = 32
+
+// EpochAssignment is the node to shard assignment published for an epoch
+type EpochAssignment struct {
+	Epoch       int              `json:"epoch"`
+	StartHeight int              `json:"start_height"`
+	Nonce       string           `json:"nonce"` // EpochNonce of the epoch's first block
+	Nodes       []string         `json:"nodes"` // Nodes the shards were dealt to, sorted
+	Assignment  map[string][]int `json:"assignment"`
+}
+
+// ShardAssignmentManager reassigns shards to nodes at the start of every
+// epoch of EpochLength blocks. The assignment is a shuffle seeded by the
+// nonce of the epoch's first block, so an adversary cannot choose which
+// shard its nodes land on, and anyone holding the block can recompute it.
+// Shards whose node changed are moved through the ShardManager's
+// ExecutePlan.
+type ShardAssignmentManager struct {
+	shards      *ShardManager
+	nodes       []string
+	epochLength int
+	handoff     ShardHandoff // Optional; see ShardManager.ExecutePlan
+	onPublish   func(EpochAssignment)
+	epochs      map[int]EpochAssignment
+	current     int
+	mutex       sync.Mutex
+}
+
+// ShardAssignmentOption configures a ShardAssignmentManager
+type ShardAssignmentOption func(*ShardAssignmentManager)
+
+// WithEpochLength sets the number of blocks per epoch
+func WithEpochLength(blocks int) ShardAssignmentOption {
+	return func(m *ShardAssignmentManager) {
+		m.epochLength = blocks
+	}
+}
+
+// WithAssignmentHandoff ships moved shards to their new nodes through handoff
+func WithAssignmentHandoff(handoff ShardHandoff) ShardAssignmentOption {
+	return func(m *ShardAssignmentManager) {
+		m.handoff = handoff
+	}
+}
+
+// WithAssignmentPublisher reports every epoch's assignment to fn, e.g. to
+// broadcast it to peers
+func WithAssignmentPublisher(fn func(EpochAssignment)) ShardAssignmentOption {
+	return func(m *ShardAssignmentManager) {
+		m.onPublish = fn
+	}
+}
+
+// NewShardAssignmentManager creates a manager dealing sm's shards to nodes
+func NewShardAssignmentManager(sm *ShardManager, nodes []string, opts ...ShardAssignmentOption) *ShardAssignmentManager {
+	m := &ShardAssignmentManager{
+		shards:      sm,
+		epochLength: DefaultEpochLength,
+		epochs:      make(map[int]EpochAssignment),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.epochLength < 1 {
+		m.epochLength = 1
+	}
+	m.nodes = sortedNodes(nodes)
+	return m
+}
+
+// SetNodes replaces the nodes shards are dealt to from the next epoch on
+func (m *ShardAssignmentManager) SetNodes(nodes []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nodes = sortedNodes(nodes)
+}
+
+// EpochOf returns the epoch a block height belongs to
+func (m *ShardAssignmentManager) EpochOf(height int) int {
+	return height / m.epochLength
+}
+
+// CurrentEpoch returns the epoch of the latest block observed
+func (m *ShardAssignmentManager) CurrentEpoch() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.current
+}
+
+// ObserveBlock advances the epoch with a committed block. The first block
+// of an epoch triggers a reshuffle: the new assignment is derived from its
+// nonce, published and applied by moving every shard whose node changed.
+// It reports whether the block started an epoch.
+func (m *ShardAssignmentManager) ObserveBlock(b Block) (bool, error) {
+	m.mutex.Lock()
+	epoch := m.EpochOf(b.Index)
+	if epoch > m.current {
+		m.current = epoch
+	}
+	if b.Index%m.epochLength != 0 {
+		m.mutex.Unlock()
+		return false, nil
+	}
+	if _, done := m.epochs[epoch]; done {
+		m.mutex.Unlock()
+		return true, nil
+	}
+	if len(m.nodes) == 0 {
+		m.mutex.Unlock()
+		return true, fmt.Errorf("%w: epoch %d", ErrNoValidators, epoch)
+	}
+
+	var shardIDs []int
+	for id := range m.shards.ShardAssignments() {
+		shardIDs = append(shardIDs, id)
+	}
+	nonce := EpochNonce(b)
+	published := EpochAssignment{
+		Epoch:       epoch,
+		StartHeight: b.Index,
+		Nonce:       nonce,
+		Nodes:       m.nodes,
+		Assignment:  ShuffleAssignment(nonce, m.nodes, shardIDs),
+	}
+	m.epochs[epoch] = published
+	m.mutex.Unlock()
+
+	if m.onPublish != nil {
+		m.onPublish(published)
+	}
+	if _, err := m.shards.ExecutePlan(m.migrationPlan(published.Assignment), m.handoff); err != nil {
+		return true, fmt.Errorf("epoch %d: %w", epoch, err)
+	}
+	return true, nil
+}
+
+// migrationPlan moves every shard whose node differs from assignment
+func (m *ShardAssignmentManager) migrationPlan(assignment map[string][]int) MigrationPlan {
+	current := m.shards.ShardAssignments()
+	var plan MigrationPlan
+	for _, node := range sortedNodes(keysOf(assignment)) {
+		for _, id := range assignment[node] {
+			if from, exists := current[id]; exists && from != node {
+				plan.Moves = append(plan.Moves, ShardMove{ShardID: id, FromNode: from, ToNode: node})
+			}
+		}
+	}
+	return plan
+}
+
+// AssignmentFor returns the shards each node was given for an epoch, or nil
+// if the epoch has not started
+func (m *ShardAssignmentManager) AssignmentFor(epoch int) map[string][]int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	published, exists := m.epochs[epoch]
+	if !exists {
+		return nil
+	}
+	assignment := make(map[string][]int, len(published.Assignment))
+	for node, ids := range published.Assignment {
+		assignment[node] = append([]int{}, ids...)
+	}
+	return assignment
+}
+
+// VerifyAssignment checks that assignment is the shuffle nonce yields for
+// the shards it covers and the nodes of the epoch, or the manager's current
+// nodes for an epoch it has not seen. For a seen epoch the nonce must also
+// be the one its first block gave.
+func (m *ShardAssignmentManager) VerifyAssignment(epoch int, nonce string, assignment map[string][]int) bool {
+	m.mutex.Lock()
+	nodes := m.nodes
+	if published, exists := m.epochs[epoch]; exists {
+		if published.Nonce != nonce {
+			m.mutex.Unlock()
+			return false
+		}
+		nodes = published.Nodes
+	}
+	m.mutex.Unlock()
+
+	var shardIDs []int
+	for _, ids := range assignment {
+		shardIDs = append(shardIDs, ids...)
+	}
+	return sameAssignment(ShuffleAssignment(nonce, nodes, shardIDs), assignment)
+}
+
+// EpochNonce derives an epoch's shuffle seed from its first block. Block
+// hashes cannot be chosen freely without redoing consensus on the block.
+func EpochNonce(b Block) string {
+	hash := sha256.Sum256([]byte("epoch|" + strconv.Itoa(b.Index) + "|" + b.Hash))
+	return hex.EncodeToString(hash[:])
+}
+
+// ShuffleAssignment deals shardIDs to nodes: the shards are sorted,
+// shuffled by Fisher-Yates with randomness drawn from nonce, and dealt in
+// turn to the sorted nodes, so every node gets an equal share to within one
+func ShuffleAssignment(nonce string, nodes []string, shardIDs []int) map[string][]int {
+	nodes = sortedNodes(nodes)
+	shuffled := append([]int{}, shardIDs...)
+	sort.Ints(shuffled)
+	stream := shuffleStream{nonce: nonce}
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j := int(stream.next() % uint64(i+1))
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+
+	assignment := make(map[string][]int, len(nodes))
+	if len(nodes) == 0 {
+		return assignment
+	}
+	for i, id := range shuffled {
+		node := nodes[i%len(nodes)]
+		assignment[node] = append(assignment[node], id)
+	}
+	for _, ids := range assignment {
+		sort.Ints(ids)
+	}
+	return assignment
+}
+
+// shuffleStream expands a nonce into a sequence of 64-bit values by hashing
+// it with a counter
+type shuffleStream struct {
+	nonce   string
+	counter uint64
+}
+
+func (s *shuffleStream) next() uint64 {
+	hash := sha256.Sum256([]byte(s.nonce + "|" + strconv.FormatUint(s.counter, 10)))
+	s.counter++
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+// sameAssignment compares assignments ignoring nodes without shards
+func sameAssignment(a, b map[string][]int) bool {
+	count := func(m map[string][]int) int {
+		n := 0
+		for _, ids := range m {
+			if len(ids) > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if count(a) != count(b) {
+		return false
+	}
+	for node, ids := range a {
+		other := append([]int{}, b[node]...)
+		sort.Ints(other)
+		if len(ids) != len(other) {
+			return false
+		}
+		for i := range ids {
+			if ids[i] != other[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sortedNodes(nodes []string) []string {
+	sorted := append([]string{}, nodes...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func keysOf(assignment map[string][]int) []string {
+	keys := make([]string, 0, len(assignment))
+	for node := range assignment {
+		keys = append(keys, node)
+	}
+	return keys
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// epochSetup returns a manager with the shards of 25 blocks all on node a
+func epochSetup(t *testing.T, nodes []string, opts ...ShardAssignmentOption) (*ShardManager, *ShardAssignmentManager) {
+	t.Helper()
+	sm := NewShardManager()
+	for _, b := range fixedBlocks(25) {
+		sm.DistributeBlock(b)
+	}
+	for id := range sm.ShardAssignments() {
+		if err := sm.AssignShardToNode(id, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sm, NewShardAssignmentManager(sm, nodes, opts...)
+}
+
+func TestShuffleAssignmentDeterministicFromNonce(t *testing.T) {
+	nodes := []string{"c", "a", "d", "b"}
+	shardIDs := make([]int, 40)
+	for i := range shardIDs {
+		shardIDs[i] = i
+	}
+
+	first := ShuffleAssignment("nonce-1", nodes, shardIDs)
+	// Input order does not matter, only the nonce does
+	reversed := make([]int, len(shardIDs))
+	for i, id := range shardIDs {
+		reversed[len(shardIDs)-1-i] = id
+	}
+	again := ShuffleAssignment("nonce-1", []string{"d", "c", "b", "a"}, reversed)
+	if !reflect.DeepEqual(first, again) {
+		t.Fatalf("same nonce gave %v and %v", first, again)
+	}
+	if other := ShuffleAssignment("nonce-2", nodes, shardIDs); reflect.DeepEqual(first, other) {
+		t.Fatal("different nonces gave the same assignment")
+	}
+
+	dealt := 0
+	for node, ids := range first {
+		if len(ids) != 10 {
+			t.Fatalf("%s got %d of 40 shards over 4 nodes", node, len(ids))
+		}
+		dealt += len(ids)
+	}
+	if dealt != len(shardIDs) {
+		t.Fatalf("dealt %d of %d shards", dealt, len(shardIDs))
+	}
+}
+
+func TestConsecutiveEpochsReshuffle(t *testing.T) {
+	var published []EpochAssignment
+	handed := make(map[int]string)
+	sm, m := epochSetup(t, []string{"a", "b", "c"},
+		WithEpochLength(4),
+		WithAssignmentPublisher(func(a EpochAssignment) { published = append(published, a) }),
+		WithAssignmentHandoff(func(move ShardMove, view ShardView) error {
+			handed[move.ShardID] = move.ToNode
+			return nil
+		}))
+
+	for _, b := range fixedBlocks(12) {
+		started, err := m.ObserveBlock(b)
+		if err != nil {
+			t.Fatalf("block %d: %v", b.Index, err)
+		}
+		if started != (b.Index%4 == 0) {
+			t.Fatalf("block %d started an epoch: %v", b.Index, started)
+		}
+		if epoch := m.CurrentEpoch(); epoch != b.Index/4 {
+			t.Fatalf("epoch %d after block %d", epoch, b.Index)
+		}
+	}
+
+	if len(published) != 3 {
+		t.Fatalf("%d assignments published for epochs 1 to 3", len(published))
+	}
+	for i, a := range published {
+		if a.Epoch != i+1 || a.StartHeight != 4*(i+1) {
+			t.Fatalf("published %+v as assignment %d", a, i)
+		}
+		if got := m.AssignmentFor(a.Epoch); !reflect.DeepEqual(got, a.Assignment) {
+			t.Fatalf("epoch %d: AssignmentFor %v, published %v", a.Epoch, got, a.Assignment)
+		}
+		if !m.VerifyAssignment(a.Epoch, a.Nonce, a.Assignment) {
+			t.Fatalf("epoch %d: published assignment rejected", a.Epoch)
+		}
+	}
+	for i := 1; i < len(published); i++ {
+		if published[i].Nonce == published[i-1].Nonce || reflect.DeepEqual(published[i].Assignment, published[i-1].Assignment) {
+			t.Fatalf("epochs %d and %d have the same assignment", i, i+1)
+		}
+	}
+	if m.AssignmentFor(4) != nil {
+		t.Fatal("assignment for an epoch that has not started")
+	}
+
+	// The shards sit where the last epoch put them, and every move went
+	// through the handoff
+	last := published[len(published)-1].Assignment
+	assignments := sm.ShardAssignments()
+	for node, ids := range last {
+		for _, id := range ids {
+			if assignments[id] != node {
+				t.Fatalf("shard #%d on %q, epoch 3 gave it to %q", id, assignments[id], node)
+			}
+		}
+	}
+	if len(handed) == 0 {
+		t.Fatal("no shard was handed off")
+	}
+
+	// Observing an epoch's first block again does not reshuffle
+	if started, err := m.ObserveBlock(fixedBlocks(12)[11]); !started || err != nil || len(published) != 3 {
+		t.Fatalf("replayed epoch start: %v, %v, %d published", started, err, len(published))
+	}
+}
+
+func TestVerifyAssignmentRejectsTampering(t *testing.T) {
+	_, m := epochSetup(t, []string{"a", "b", "c"}, WithEpochLength(5))
+	start := fixedBlocks(5)[4]
+	if _, err := m.ObserveBlock(start); err != nil {
+		t.Fatal(err)
+	}
+	nonce := EpochNonce(start)
+	assignment := m.AssignmentFor(1)
+
+	if m.VerifyAssignment(1, EpochNonce(fixedBlocks(6)[5]), assignment) {
+		t.Fatal("assignment accepted with another block's nonce")
+	}
+
+	// Swap one shard between two nodes
+	tampered := m.AssignmentFor(1)
+	tampered["a"][0], tampered["b"][0] = tampered["b"][0], tampered["a"][0]
+	if m.VerifyAssignment(1, nonce, tampered) {
+		t.Fatal("swapped shards accepted")
+	}
+
+	// Hand everything to one node
+	var all []int
+	for _, ids := range assignment {
+		all = append(all, ids...)
+	}
+	if m.VerifyAssignment(1, nonce, map[string][]int{"a": all}) {
+		t.Fatal("assignment to a single node accepted")
+	}
+
+	// An epoch not yet seen is checked against the current nodes
+	next := fixedBlocks(10)[9]
+	if !m.VerifyAssignment(2, EpochNonce(next), ShuffleAssignment(EpochNonce(next), []string{"a", "b", "c"}, all)) {
+		t.Fatal("future epoch's assignment rejected")
+	}
+}
+
+func TestObserveBlockWithoutNodes(t *testing.T) {
+	_, m := epochSetup(t, nil, WithEpochLength(3))
+	for _, b := range fixedBlocks(3) {
+		started, err := m.ObserveBlock(b)
+		if b.Index < 3 {
+			if started || err != nil {
+				t.Fatalf("block %d: %v, %v", b.Index, started, err)
+			}
+			continue
+		}
+		if !started || !errors.Is(err, ErrNoValidators) {
+			t.Fatalf("epoch start with no nodes: %v, %v", started, err)
+		}
+	}
+
+	m.SetNodes([]string{"x", "y"})
+	if _, err := m.ObserveBlock(fixedBlocks(6)[5]); err != nil {
+		t.Fatal(err)
+	}
+	for node := range m.AssignmentFor(2) {
+		if node != "x" && node != "y" {
+			t.Fatalf("shards dealt to %q", node)
+		}
+	}
+}
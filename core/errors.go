@@ -0,0 +1,18 @@
+package core
+
+import "errors"
+
+// Errors shared across core. Failures are reported by wrapping one of these
+// with context, so callers can test for them with errors.Is; errors that
+// belong to a single feature are declared next to it.
+var (
+	ErrShardNotFound       = errors.New("shard not found")
+	ErrBlockNotFound       = errors.New("block not found in shard")
+	ErrInvalidBlock        = errors.New("invalid block")
+	ErrInvalidProof        = errors.New("invalid proof")
+	ErrKeyNotFound         = errors.New("key not in trie")
+	ErrInvalidCommitment   = errors.New("invalid transfer commitment")
+	ErrTransferNotPrepared = errors.New("transfer not found or not prepared")
+	ErrQuorumNotReached    = errors.New("consensus quorum not reached")
+	ErrNoLeader            = errors.New("no eligible leader")
+)
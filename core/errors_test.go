@@ -0,0 +1,67 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// TestSharedErrorsReachable drives each shared sentinel error out of an API
+// that reports it
+func TestSharedErrorsReachable(t *testing.T) {
+	sm, source, destination := transferSetup(t)
+
+	lazy, err := NewLazyTrie(NewMemoryNodeStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := finalityChain(t, 2)
+	invalid := mustGenerateBlock(t, chain.Blocks[2], "invalid")
+	invalid.Hash = "bad"
+
+	allByzantine := behaviorBFT(4)
+	for _, node := range allByzantine.Nodes {
+		node.Byzantine = true
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  func() error
+		want error
+	}{
+		{"FindShard", func() error { _, err := sm.FindShard(9999); return err }, ErrShardNotFound},
+		{"VerifyBlockInShard missing shard", func() error { return sm.VerifyBlockInShard(9999, "h", big.NewInt(1)) }, ErrShardNotFound},
+		{"ProveBlockInclusion", func() error { _, err := sm.ProveBlockInclusion("missing"); return err }, ErrBlockNotFound},
+		{"Shard.ProveInclusion", func() error { _, _, err := source.ProveInclusion("missing"); return err }, ErrBlockNotFound},
+		{"AddBlockCandidate", func() error { return chain.AddBlockCandidate(invalid) }, ErrInvalidBlock},
+		{"CheckMembership", func() error { return NewRSAAccumulator().CheckMembership("x", nil) }, ErrInvalidProof},
+		{"VerifyBlockInShard without accumulator", func() error {
+			return sm.VerifyBlockInShard(source.ID, source.Blocks[0].Hash, big.NewInt(1))
+		}, ErrInvalidProof},
+		{"SuccinctTrie.Prove", func() error { _, err := NewSuccinctTrie().Prove("missing"); return err }, ErrKeyNotFound},
+		{"LazyTrie.Prove", func() error { _, err := lazy.Prove("missing"); return err }, ErrKeyNotFound},
+		{"VerifyAndApplyTransfer unprepared", func() error {
+			return NewEnhancedSyncManager("key").VerifyAndApplyTransfer(source, destination, 0)
+		}, ErrTransferNotPrepared},
+		{"VerifyAndApplyTransfer under a rotated key", func() error {
+			keyring := NewHomomorphicAuthenticator("key")
+			esm := NewEnhancedSyncManagerWithKeyring(keyring)
+			if err := esm.CreateAuthenticatedTransfer(source, destination, 0); err != nil {
+				return err
+			}
+			keyring.RotateKey(DefaultKeyID, []byte("wrong-key"))
+			return esm.VerifyAndApplyTransfer(source, destination, 0)
+		}, ErrInvalidCommitment},
+		{"ReachConsensus", allByzantine.ReachConsensus, ErrQuorumNotReached},
+		{"ReachHybridConsensus", NewConsensusManager(allByzantine).ReachHybridConsensus, ErrNoLeader},
+	} {
+		if err := tc.err(); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// The deprecated bool forms agree
+	if allByzantine.RunConsensus() || NewRSAAccumulator().VerifyMembership("x", nil) {
+		t.Fatal("deprecated wrapper reported success")
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is returned by a ScriptedInjector failing a hook
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultPoint names a place where production code calls an installed
+// FaultInjector
+type FaultPoint string
+
+const (
+	FaultBeforePrepare  FaultPoint = "before-prepare"   // Block transfer logged as prepared, not yet validated
+	FaultAfterPrepare   FaultPoint = "after-prepare"    // Block transfer validated, before CreateKeyedTransfer returns
+	FaultBeforeCommit   FaultPoint = "before-commit"    // Commit phase started, before the committee decides and the block moves
+	FaultMidRebalance   FaultPoint = "mid-rebalance"    // Split shards built, before the new layout is swapped in
+	FaultBeforeWALFlush FaultPoint = "before-wal-flush" // Write-ahead log record encoded, before it is written
+)
+
+// FaultInjector is called at each FaultPoint when installed, see
+// EnhancedSyncManager.SetFaultInjector and WithFaultInjector. It may return
+// an error, which fails the operation at that point as a real failure
+// would; panic, to simulate a crash; or sleep, to widen a race. Production
+// code installs none.
+type FaultInjector interface {
+	Inject(point FaultPoint) error
+}
+
+// injectFault calls fi at point, doing nothing if fi is nil
+func injectFault(fi FaultInjector, point FaultPoint) error {
+	if fi == nil {
+		return nil
+	}
+	return fi.Inject(point)
+}
+
+// FaultAction is what a ScriptedFault does when it fires
+type FaultAction string
+
+const (
+	FaultActionError FaultAction = "error"
+	FaultActionPanic FaultAction = "panic"
+	FaultActionDelay FaultAction = "delay"
+)
+
+// ScriptedFault fires on one invocation of a hook
+type ScriptedFault struct {
+	Point  FaultPoint
+	Call   int // 1-based invocation of Point the fault fires on
+	Action FaultAction
+	Err    error         // Returned by FaultActionError; a wrapped ErrInjectedFault if nil
+	Delay  time.Duration // Slept by FaultActionDelay
+}
+
+// InjectedCrash is the value a ScriptedInjector panics with, so a
+// recover-based harness can tell a simulated crash from a real one
+type InjectedCrash struct {
+	Point FaultPoint
+	Call  int
+}
+
+// String describes the crash
+func (c InjectedCrash) String() string {
+	return fmt.Sprintf("injected crash at %s call %d", c.Point, c.Call)
+}
+
+// ScriptedInjector is a FaultInjector that fires scripted faults on chosen
+// invocations of named hooks, so a test fails exactly the step it means to.
+// It is safe for concurrent use.
+type ScriptedInjector struct {
+	faults []ScriptedFault
+	calls  map[FaultPoint]int
+	fired  []ScriptedFault
+	mutex  sync.Mutex
+}
+
+// NewScriptedInjector creates an injector firing faults
+func NewScriptedInjector(faults ...ScriptedFault) *ScriptedInjector {
+	return &ScriptedInjector{faults: append([]ScriptedFault{}, faults...), calls: make(map[FaultPoint]int)}
+}
+
+// FailNth returns an injector failing the nth invocation of point with
+// ErrInjectedFault
+func FailNth(point FaultPoint, n int) *ScriptedInjector {
+	return NewScriptedInjector(ScriptedFault{Point: point, Call: n, Action: FaultActionError})
+}
+
+// Inject implements FaultInjector. Faults scripted for the same invocation
+// fire in order, the first error or panic ending it.
+func (si *ScriptedInjector) Inject(point FaultPoint) error {
+	si.mutex.Lock()
+	si.calls[point]++
+	call := si.calls[point]
+	var due []ScriptedFault
+	for _, f := range si.faults {
+		if f.Point == point && f.Call == call {
+			due = append(due, f)
+		}
+	}
+	si.fired = append(si.fired, due...)
+	si.mutex.Unlock()
+
+	for _, f := range due {
+		switch f.Action {
+		case FaultActionDelay:
+			time.Sleep(f.Delay)
+		case FaultActionPanic:
+			panic(InjectedCrash{Point: point, Call: call})
+		default:
+			if f.Err != nil {
+				return f.Err
+			}
+			return fmt.Errorf("%w: %s call %d", ErrInjectedFault, point, call)
+		}
+	}
+	return nil
+}
+
+// Calls returns how many times point has been invoked
+func (si *ScriptedInjector) Calls(point FaultPoint) int {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+	return si.calls[point]
+}
+
+// Fired returns the faults that have fired, in order
+func (si *ScriptedInjector) Fired() []ScriptedFault {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+	return append([]ScriptedFault{}, si.fired...)
+}
+
+// SetFaultInjector installs hooks called at the prepare, commit and
+// write-ahead log points of block transfers (nil removes them)
+func (esm *EnhancedSyncManager) SetFaultInjector(fi FaultInjector) {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	esm.faults = fi
+}
+
+// WithFaultInjector installs hooks called at the mid-rebalance point of
+// shard splits
+func WithFaultInjector(fi FaultInjector) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.faults = fi
+	}
+}
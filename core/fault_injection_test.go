@@ -0,0 +1,300 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// forestSnapshot is what a failed operation must leave as it was
+type forestSnapshot struct {
+	root   string
+	counts string
+	blocks int
+}
+
+func snapshotForest(sm *ShardManager) forestSnapshot {
+	blocks := 0
+	for _, shard := range sm.Shards.GetAllShards() {
+		blocks += len(shard.Blocks)
+	}
+	return forestSnapshot{root: sm.ForestRoot(), counts: fmt.Sprint(shardCounts(sm)), blocks: blocks}
+}
+
+// checkInvariants fails unless every block is held exactly once, each
+// shard's root matches its blocks and no transfer is left pending
+func checkInvariants(t *testing.T, sm *ShardManager, esm *EnhancedSyncManager, blocks int) {
+	t.Helper()
+	seen := make(map[string]int)
+	shards := sm.Shards.GetAllShards()
+	if held := snapshotForest(sm).blocks; held != blocks {
+		t.Fatalf("forest holds %d blocks, want %d", held, blocks)
+	}
+	for _, shard := range shards {
+		for _, b := range shard.Blocks {
+			if other, dup := seen[b.Hash]; dup {
+				t.Fatalf("block #%d held by shards #%d and #%d", b.Index, other, shard.ID)
+			}
+			seen[b.Hash] = shard.ID
+		}
+		if root := newBlockTree(shard.Blocks).GetRootHash(); root != shard.GetRoot() {
+			t.Fatalf("shard #%d has root %s over blocks hashing to %s", shard.ID, shard.GetRoot(), root)
+		}
+	}
+	if esm != nil && len(esm.pendingTransfers) != 0 {
+		t.Fatalf("%d transfers left pending", len(esm.pendingTransfers))
+	}
+}
+
+func TestTransferRollsBackAtEveryFaultPoint(t *testing.T) {
+	for name, tc := range map[string]struct {
+		point   FaultPoint
+		call    int
+		outcome TransferOutcome
+		reason  RollbackReason
+	}{
+		"before prepare":         {FaultBeforePrepare, 1, OutcomeAborted, ReasonPrepareFailed},
+		"after prepare":          {FaultAfterPrepare, 1, OutcomeAborted, ReasonPrepareFailed},
+		"before commit":          {FaultBeforeCommit, 1, OutcomeRolledBack, ReasonInjectedFault},
+		"flushing the prepare":   {FaultBeforeWALFlush, 1, "", ""},
+		"flushing the commit":    {FaultBeforeWALFlush, 2, OutcomeRolledBack, ReasonWALFailure},
+		"flushing the abort":     {FaultBeforeWALFlush, 3, OutcomeRolledBack, ReasonInjectedFault},
+		"never reached (commit)": {FaultBeforeCommit, 2, OutcomeCommitted, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sm, source, destination := transferSetup(t)
+			before := snapshotForest(sm)
+			sourceRoot, destRoot := source.GetRoot(), destination.GetRoot()
+			sourceCount := len(source.Blocks)
+			var log bytes.Buffer
+			esm := NewEnhancedSyncManager("key")
+			esm.SetWAL(&log)
+			fi := FailNth(tc.point, tc.call)
+			if tc.call == 3 {
+				// The abort record is only written after a failed commit
+				fi = NewScriptedInjector(
+					ScriptedFault{Point: FaultBeforeCommit, Call: 1, Action: FaultActionError},
+					ScriptedFault{Point: FaultBeforeWALFlush, Call: 3, Action: FaultActionError},
+				)
+			}
+			esm.SetFaultInjector(fi)
+
+			err := esm.CreateAuthenticatedTransfer(source, destination, 1)
+			if err == nil {
+				err = esm.VerifyAndApplyTransfer(source, destination, 1)
+			}
+			if tc.outcome == OutcomeCommitted {
+				if err != nil || fi.Calls(tc.point) != 1 || len(fi.Fired()) != 0 {
+					t.Fatalf("transfer with a fault never reached: %v", err)
+				}
+				checkInvariants(t, sm, esm, before.blocks)
+				return
+			}
+			if !errors.Is(err, ErrInjectedFault) || len(fi.Fired()) == 0 {
+				t.Fatalf("transfer failing at %s call %d: %v", tc.point, tc.call, err)
+			}
+
+			// Nothing moved, nothing is pending and the journal says why
+			if source.GetRoot() != sourceRoot || destination.GetRoot() != destRoot || snapshotForest(sm) != before {
+				t.Fatal("failed transfer changed the shards")
+			}
+			checkInvariants(t, sm, esm, before.blocks)
+			journal := esm.Journal()
+			if tc.outcome == "" {
+				if len(journal) != 0 {
+					t.Fatalf("journaled a transfer never logged: %+v", journal)
+				}
+			} else if len(journal) != 1 || journal[0].Outcome != tc.outcome || journal[0].Reason != tc.reason {
+				t.Fatalf("journal %+v, want %s for %s", journal, tc.outcome, tc.reason)
+			}
+
+			// Recovering from the log leaves the rolled-back shards alone,
+			// even where the ABORT record was lost
+			if _, err := RecoverPendingTransfers(bytes.NewReader(log.Bytes()), sm); err != nil {
+				t.Fatal(err)
+			}
+			if snapshotForest(sm) != before {
+				t.Fatal("recovery changed the shards")
+			}
+
+			// Without the fault the same transfer goes through
+			esm.SetFaultInjector(nil)
+			if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+				t.Fatal(err)
+			}
+			if len(source.Blocks) != sourceCount-1 || source.GetRoot() == sourceRoot {
+				t.Fatal("retried transfer did not move the block")
+			}
+			checkInvariants(t, sm, esm, before.blocks)
+		})
+	}
+}
+
+// crash runs fn, returning the injected crash it panicked with
+func crash(t *testing.T, fn func() error) InjectedCrash {
+	t.Helper()
+	var crashed InjectedCrash
+	func() {
+		defer func() {
+			r := recover()
+			c, ok := r.(InjectedCrash)
+			if !ok {
+				t.Fatalf("recovered %v, want an injected crash", r)
+			}
+			crashed = c
+		}()
+		err := fn()
+		t.Fatalf("returned %v instead of crashing", err)
+	}()
+	return crashed
+}
+
+func TestRecoveryAfterCrashAtFaultPoint(t *testing.T) {
+	for _, tc := range []struct {
+		point FaultPoint
+		call  int
+	}{
+		{FaultBeforePrepare, 1},
+		{FaultAfterPrepare, 1},
+		{FaultBeforeCommit, 1},
+		// The block has moved but its COMMIT never reached the log
+		{FaultBeforeWALFlush, 2},
+	} {
+		t.Run(fmt.Sprintf("%s call %d", tc.point, tc.call), func(t *testing.T) {
+			sm, source, destination := transferSetup(t)
+			before := snapshotForest(sm)
+			var log bytes.Buffer
+			esm := NewEnhancedSyncManager("key")
+			esm.SetWAL(&log)
+			esm.SetFaultInjector(NewScriptedInjector(ScriptedFault{Point: tc.point, Call: tc.call, Action: FaultActionPanic}))
+
+			c := crash(t, func() error {
+				if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+					return err
+				}
+				return esm.VerifyAndApplyTransfer(source, destination, 1)
+			})
+			if c.Point != tc.point || c.Call != tc.call {
+				t.Fatalf("crashed at %s", c)
+			}
+			// The crash released the manager's lock on its way out
+			if !esm.mutex.TryLock() {
+				t.Fatal("crash left the sync manager locked")
+			}
+			esm.mutex.Unlock()
+
+			// A restarted node recovers from the log alone
+			ids, err := RecoverPendingTransfers(bytes.NewReader(log.Bytes()), sm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != 1 || ids[0] != TransferID("", source.ID, destination.ID, 1) {
+				t.Fatalf("recovered %v, want the crashed transfer", ids)
+			}
+			if snapshotForest(sm) != before {
+				t.Fatal("recovery did not restore the shards")
+			}
+			checkInvariants(t, sm, NewEnhancedSyncManager("key"), before.blocks)
+		})
+	}
+}
+
+func TestRebalanceFailureKeepsLayout(t *testing.T) {
+	for _, strategy := range []SplitStrategy{MidpointSplit, HashRangeSplit} {
+		t.Run(string(strategy), func(t *testing.T) {
+			var events []ShardEvent
+			fi := FailNth(FaultMidRebalance, 1)
+			sm := NewShardManager(WithSplitStrategy(strategy), WithFaultInjector(fi), WithShardEvents(func(e ShardEvent) {
+				events = append(events, e)
+			}))
+			if err := sm.SetThresholds(1, 2); err != nil {
+				t.Fatal(err)
+			}
+			blocks := fixedBlocks(4)
+			for _, b := range blocks[:3] {
+				sm.DistributeBlock(b)
+			}
+
+			// The third block is placed, but the split it triggers fails
+			err := sm.LastRebalanceError()
+			if !errors.Is(err, ErrInjectedFault) || fi.Calls(FaultMidRebalance) != 1 {
+				t.Fatalf("rebalance error %v after %d calls", err, fi.Calls(FaultMidRebalance))
+			}
+			if sm.Shards.Size() != 1 || len(sm.Shards.GetAllShards()[0].Blocks) != 3 {
+				t.Fatalf("failed split left shards %v", shardCounts(sm))
+			}
+			if len(events) != 1 || events[0].Type != ShardRebalanceFailed || events[0].Error != err.Error() {
+				t.Fatalf("events %+v", events)
+			}
+			if root, ok := sm.ForestRootAt(blocks[2].Index); !ok || root != sm.ForestRoot() {
+				t.Fatal("forest root of the unsplit layout not recorded")
+			}
+			checkInvariants(t, sm, nil, 3)
+
+			// The next block retries the split, clearing the error
+			sm.DistributeBlock(blocks[3])
+			if err := sm.LastRebalanceError(); err != nil || sm.Shards.Size() < 2 {
+				t.Fatalf("retried split: %v, shards %v", err, shardCounts(sm))
+			}
+			if len(events) != 1 {
+				t.Fatalf("successful split reported %+v", events[1:])
+			}
+			checkInvariants(t, sm, nil, 4)
+		})
+	}
+}
+
+func TestExplicitRebalanceFailureKeepsLayout(t *testing.T) {
+	for _, strategy := range []SplitStrategy{MidpointSplit, HashRangeSplit} {
+		t.Run(string(strategy), func(t *testing.T) {
+			fi := NewScriptedInjector(
+				ScriptedFault{Point: FaultMidRebalance, Call: 1, Action: FaultActionError},
+				ScriptedFault{Point: FaultMidRebalance, Call: 2, Action: FaultActionError},
+				ScriptedFault{Point: FaultMidRebalance, Call: 3, Action: FaultActionDelay, Delay: 20 * time.Millisecond},
+			)
+			sm := NewShardManager(WithSplitStrategy(strategy), WithFaultInjector(fi))
+			if err := sm.SetThresholds(1, 8); err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range fixedBlocks(8) {
+				sm.DistributeBlock(b)
+			}
+			if err := sm.SetThresholds(1, 2); err != nil {
+				t.Fatal(err)
+			}
+			before := snapshotForest(sm)
+
+			if err := sm.RebalanceShards(); !errors.Is(err, ErrInjectedFault) {
+				t.Fatalf("rebalance failing mid-way: %v", err)
+			}
+			if snapshotForest(sm) != before {
+				t.Fatal("failed rebalance changed the forest")
+			}
+			plan := sm.PlanRebalance()
+			if err := sm.ApplyPlan(plan); !errors.Is(err, ErrInjectedFault) {
+				t.Fatalf("plan failing mid-way: %v", err)
+			}
+			if snapshotForest(sm) != before {
+				t.Fatal("failed plan changed the forest")
+			}
+			checkInvariants(t, sm, nil, 8)
+
+			// The plan still matches the forest, so it can be applied again;
+			// a delayed hook only slows it down
+			start := time.Now()
+			if err := sm.ApplyPlan(plan); err != nil {
+				t.Fatal(err)
+			}
+			if time.Since(start) < 20*time.Millisecond || sm.ForestRoot() == before.root {
+				t.Fatal("delayed rebalance did not run")
+			}
+			checkPlanned(t, sm, plan.Summary())
+			checkInvariants(t, sm, nil, 8)
+		})
+	}
+}
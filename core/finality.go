@@ -0,0 +1,162 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Errors returned when finalizing blocks
+var (
+	ErrFinalityViolation       = errors.New("operation would undo a finalized block")
+	ErrInvalidFinalityEvidence = errors.New("invalid finality evidence")
+)
+
+// DefaultFinalityDepth is the fewest confirmations depth evidence may claim
+const DefaultFinalityDepth = 6
+
+// FinalityRule is how a block was shown to be final
+type FinalityRule string
+
+const (
+	// FinalityByCertificate finalizes a block with a BFT commit certificate
+	FinalityByCertificate FinalityRule = "certificate"
+	// FinalityByDepth finalizes a block once enough blocks are built on it
+	FinalityByDepth FinalityRule = "depth"
+)
+
+// FinalityEvidence shows that a block can no longer be rolled back
+type FinalityEvidence struct {
+	Rule          FinalityRule       `json:"rule"`
+	Certificate   *CommitCertificate `json:"certificate,omitempty"`   // For FinalityByCertificate
+	Confirmations int                `json:"confirmations,omitempty"` // For FinalityByDepth
+}
+
+// CertificateEvidence finalizes the block a commit certificate decided
+func CertificateEvidence(cert CommitCertificate) FinalityEvidence {
+	return FinalityEvidence{Rule: FinalityByCertificate, Certificate: &cert}
+}
+
+// DepthEvidence finalizes a block with at least confirmations blocks on top
+func DepthEvidence(confirmations int) FinalityEvidence {
+	return FinalityEvidence{Rule: FinalityByDepth, Confirmations: confirmations}
+}
+
+// FinalityTracker records the height up to which the main chain is final.
+// Inclusion alone is not final: PoW blocks can be reorganized away until
+// they are deep enough or a BFT quorum has committed to them. Finality only
+// moves forward; Reorganize refuses to roll back finalized blocks and a
+// StatePruner only prunes final blocks.
+type FinalityTracker struct {
+	chain    *Blockchain
+	keys     map[int]ed25519.PublicKey // Validators certificates must verify against
+	minDepth int
+	final    int    // Last final height; the genesis block is always final
+	hash     string // Hash of the block at final
+	mutex    sync.RWMutex
+}
+
+// FinalityOption configures a FinalityTracker
+type FinalityOption func(*FinalityTracker)
+
+// WithFinalityKeys accepts commit certificates that verify against pubkeys;
+// without keys, certificate evidence is rejected
+func WithFinalityKeys(pubkeys map[int]ed25519.PublicKey) FinalityOption {
+	return func(ft *FinalityTracker) {
+		ft.keys = pubkeys
+	}
+}
+
+// WithFinalityDepth sets the fewest confirmations depth evidence may claim
+func WithFinalityDepth(confirmations int) FinalityOption {
+	return func(ft *FinalityTracker) {
+		ft.minDepth = confirmations
+	}
+}
+
+// NewFinalityTracker creates a tracker for bc's main chain and attaches it
+// to bc, so bc.Reorganize respects it
+func NewFinalityTracker(bc *Blockchain, opts ...FinalityOption) *FinalityTracker {
+	ft := &FinalityTracker{chain: bc, minDepth: DefaultFinalityDepth}
+	for _, opt := range opts {
+		opt(ft)
+	}
+	if ft.minDepth < 1 {
+		ft.minDepth = 1
+	}
+	ft.final = bc.Blocks[0].Index
+	ft.hash = bc.Blocks[0].Hash
+	bc.finality = ft
+	return ft
+}
+
+// MarkFinal finalizes the main-chain block at height, and with it every
+// block below, if evidence holds. Heights already final are accepted as is.
+func (ft *FinalityTracker) MarkFinal(height int, evidence FinalityEvidence) error {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	if height <= ft.final {
+		return nil
+	}
+
+	bc := ft.chain
+	hash, exists := bc.HashAtHeight(height)
+	if !exists {
+		return fmt.Errorf("%w: no main-chain block at height %d", ErrBlockNotFound, height)
+	}
+	switch evidence.Rule {
+	case FinalityByCertificate:
+		cert := evidence.Certificate
+		if cert == nil || ft.keys == nil {
+			return fmt.Errorf("%w: no certificate or validator keys", ErrInvalidFinalityEvidence)
+		}
+		if cert.Height != height || cert.BlockHash != hash {
+			return fmt.Errorf("%w: certificate for #%d does not decide main-chain block #%d", ErrCertificateMismatch, cert.Height, height)
+		}
+		if err := VerifyCommitCertificate(*cert, ft.keys); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidFinalityEvidence, err)
+		}
+		if err := bc.AttachCertificate(*cert); err != nil {
+			return err
+		}
+	case FinalityByDepth:
+		if evidence.Confirmations < ft.minDepth {
+			return fmt.Errorf("%w: %d confirmations, need %d", ErrInvalidFinalityEvidence, evidence.Confirmations, ft.minDepth)
+		}
+		if depth := bc.Blocks[len(bc.Blocks)-1].Index - height; depth < evidence.Confirmations {
+			return fmt.Errorf("%w: block #%d has %d confirmations, not %d", ErrInvalidFinalityEvidence, height, depth, evidence.Confirmations)
+		}
+	default:
+		return fmt.Errorf("%w: unknown rule %q", ErrInvalidFinalityEvidence, evidence.Rule)
+	}
+
+	ft.final, ft.hash = height, hash
+	return nil
+}
+
+// IsFinal reports whether the main-chain block at height is final
+func (ft *FinalityTracker) IsFinal(height int) bool {
+	ft.mutex.RLock()
+	defer ft.mutex.RUnlock()
+	return height <= ft.final
+}
+
+// LastFinalHeight returns the height of the highest final block
+func (ft *FinalityTracker) LastFinalHeight() int {
+	ft.mutex.RLock()
+	defer ft.mutex.RUnlock()
+	return ft.final
+}
+
+// LastFinalHash returns the hash of the highest final block
+func (ft *FinalityTracker) LastFinalHash() string {
+	ft.mutex.RLock()
+	defer ft.mutex.RUnlock()
+	return ft.hash
+}
+
+// Finality returns the attached finality tracker, or nil
+func (bc *Blockchain) Finality() *FinalityTracker {
+	return bc.finality
+}
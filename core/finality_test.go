@@ -0,0 +1,161 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// finalityChain returns a chain of n blocks after genesis
+func finalityChain(t *testing.T, n int) *Blockchain {
+	t.Helper()
+	bc := NewBlockchain()
+	for i := 1; i <= n; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("main-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+// branchFrom adds a side branch of n blocks built on prev and returns its tip
+func branchFrom(t *testing.T, bc *Blockchain, prev Block, n int) Block {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		prev = mustGenerateBlock(t, prev, fmt.Sprintf("side-%d-%d", prev.Index, i))
+		if err := bc.AddBlockCandidate(prev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return prev
+}
+
+func TestReorgAcrossFinalBlockRejected(t *testing.T) {
+	bc := finalityChain(t, 8)
+	ft := NewFinalityTracker(bc, WithFinalityDepth(3))
+	if bc.Finality() != ft || ft.LastFinalHeight() != 0 {
+		t.Fatalf("new tracker final at %d", ft.LastFinalHeight())
+	}
+	if err := ft.MarkFinal(4, DepthEvidence(4)); err != nil {
+		t.Fatal(err)
+	}
+	if !ft.IsFinal(4) || !ft.IsFinal(1) || ft.IsFinal(5) || ft.LastFinalHash() != bc.Blocks[4].Hash {
+		t.Fatalf("final up to %d at %s", ft.LastFinalHeight(), ft.LastFinalHash())
+	}
+
+	// A longer branch forking below the final block is refused
+	mainHashes := blockHashes(bc.Blocks)
+	tip := branchFrom(t, bc, bc.Blocks[2], 10)
+	if _, _, err := bc.Reorganize(tip); !errors.Is(err, ErrFinalityViolation) {
+		t.Fatalf("reorg across final block #4: %v", err)
+	}
+	if _, _, err := bc.ResolveForks(); !errors.Is(err, ErrFinalityViolation) {
+		t.Fatalf("fork resolution across final block #4: %v", err)
+	}
+	if !equalHashes(blockHashes(bc.Blocks), mainHashes) {
+		t.Fatal("refused reorg changed the main chain")
+	}
+
+	// One forking at the final block only undoes blocks above it
+	tip = branchFrom(t, bc, bc.Blocks[4], 6)
+	rolledBack, applied, err := bc.Reorganize(tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolledBack) != 4 || len(applied) != 6 {
+		t.Fatalf("rolled back %d and applied %d blocks", len(rolledBack), len(applied))
+	}
+	if bc.Blocks[4].Hash != ft.LastFinalHash() {
+		t.Fatal("final block replaced")
+	}
+}
+
+func TestMarkFinalEvidence(t *testing.T) {
+	bc := finalityChain(t, 10)
+	ft := NewFinalityTracker(bc, WithFinalityDepth(3))
+
+	for _, tc := range []struct {
+		name     string
+		height   int
+		evidence FinalityEvidence
+		want     error
+	}{
+		{"below the minimum depth", 5, DepthEvidence(2), ErrInvalidFinalityEvidence},
+		{"more confirmations than built", 8, DepthEvidence(3), ErrInvalidFinalityEvidence},
+		{"unknown rule", 5, FinalityEvidence{Rule: "vibes"}, ErrInvalidFinalityEvidence},
+		{"certificate without keys", 5, CertificateEvidence(CommitCertificate{Height: 5}), ErrInvalidFinalityEvidence},
+		{"height past the tip", 11, DepthEvidence(3), ErrBlockNotFound},
+	} {
+		if err := ft.MarkFinal(tc.height, tc.evidence); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	if ft.LastFinalHeight() != 0 {
+		t.Fatalf("rejected evidence finalized up to %d", ft.LastFinalHeight())
+	}
+
+	if err := ft.MarkFinal(7, DepthEvidence(3)); err != nil {
+		t.Fatal(err)
+	}
+	// Finality never moves back
+	if err := ft.MarkFinal(3, DepthEvidence(3)); err != nil || ft.LastFinalHeight() != 7 {
+		t.Fatalf("marking a lower height: %v, final at %d", err, ft.LastFinalHeight())
+	}
+}
+
+func TestMarkFinalByCertificate(t *testing.T) {
+	bc := finalityChain(t, 4)
+	bft := behaviorBFT(4)
+	ft := NewFinalityTracker(bc, WithFinalityKeys(bft.PublicKeys()))
+
+	block := bc.Blocks[3]
+	cert, err := bft.Decide(0, block.Index, block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The certificate decides #3 only
+	if err := ft.MarkFinal(4, CertificateEvidence(cert)); !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("certificate for #3 used for #4: %v", err)
+	}
+	other := behaviorBFT(4)
+	forged, err := other.Decide(0, block.Index, block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ft.MarkFinal(3, CertificateEvidence(forged)); !errors.Is(err, ErrInvalidFinalityEvidence) {
+		t.Fatalf("certificate from other validators: %v", err)
+	}
+	// and needs no confirmations on top
+	if err := ft.MarkFinal(3, CertificateEvidence(cert)); err != nil {
+		t.Fatal(err)
+	}
+	if ft.LastFinalHeight() != 3 {
+		t.Fatalf("final at %d", ft.LastFinalHeight())
+	}
+	if attached, ok := bc.Certificate(block.Hash); !ok || attached.BlockHash != block.Hash {
+		t.Fatal("finalizing certificate not attached to the block")
+	}
+}
+
+func TestPruningRespectsFinality(t *testing.T) {
+	bc := finalityChain(t, 12)
+	ft := NewFinalityTracker(bc, WithFinalityDepth(2))
+	pruner := NewStatePruner(1, 2, false)
+
+	// Only genesis is final
+	pruner.PruneBlockchain(bc)
+	if bc.Blocks[0].Index > 1 {
+		t.Fatalf("pruned up to #%d with only genesis final", bc.Blocks[0].Index-1)
+	}
+
+	if err := ft.MarkFinal(6, DepthEvidence(2)); err != nil {
+		t.Fatal(err)
+	}
+	pruner.PruneBlockchain(bc)
+	if bc.Blocks[0].Index != 7 {
+		t.Fatalf("chain starts at #%d, want the block after final #6", bc.Blocks[0].Index)
+	}
+	if pruned := pruner.PruneBlockchain(bc); pruned != 0 {
+		t.Fatalf("pruned %d blocks that are not final", pruned)
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+)
+
+// forestEntry identifies the state of one shard when the forest was built
+type forestEntry struct {
+	shard   *Shard
+	version uint64
+}
+
+// forestCache holds the Merkle tree over shard roots and the shard states it
+// was built from. It is rebuilt when the set of shards changes (rebalance,
+// merge) or any shard's version moves (AddBlock, transfers, rollbacks).
+type forestCache struct {
+	tree    *MerkleTree
+	entries []forestEntry
+	mutex   sync.Mutex
+}
+
+// ForestRoot returns a Merkle root over every shard root in ascending
+// shard-ID order, committing to the whole sharded ledger
+func (sm *ShardManager) ForestRoot() string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	tree, _ := sm.forestTree()
+	return tree.GetRootHash()
+}
+
+// ProveShardInForest proves a shard's current root against ForestRoot
+func (sm *ShardManager) ProveShardInForest(shardID int) (MerkleProof, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	tree, entries := sm.forestTree()
+	for i, entry := range entries {
+		if entry.shard.ID == shardID {
+			return tree.GetProof(i)
+		}
+	}
+	return MerkleProof{}, fmt.Errorf("%w: #%d", ErrShardNotFound, shardID)
+}
+
+// forestTree returns the cached forest, rebuilding it if any shard changed
+// since it was built; the caller must hold the manager lock
+func (sm *ShardManager) forestTree() (*MerkleTree, []forestEntry) {
+	sm.forest.mutex.Lock()
+	defer sm.forest.mutex.Unlock()
+
+	shards := sm.Shards.GetAllShards()
+	entries := make([]forestEntry, len(shards))
+	roots := make([]string, len(shards))
+	for i, shard := range shards {
+		shard.mutex.Lock()
+		entries[i] = forestEntry{shard: shard, version: shard.version}
+		roots[i] = shard.GetRoot()
+		shard.mutex.Unlock()
+	}
+
+	if sm.forest.tree == nil || !sameForest(sm.forest.entries, entries) {
+		sm.forest.tree = NewMerkleTree(roots)
+		sm.forest.entries = entries
+	}
+	return sm.forest.tree, sm.forest.entries
+}
+
+// sameForest reports whether two snapshots saw the same shards at the same versions
+func sameForest(a, b []forestEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// InclusionProof proves a block is in the sharded ledger: the block's path
+// to its shard root, and the shard root's path to the forest root
+type InclusionProof struct {
+	ShardID    int         `json:"shard_id"`
+	Block      Block       `json:"block"`
+	BlockProof MerkleProof `json:"block_proof"` // Block leaf to ShardRoot
+	ShardRoot  string      `json:"shard_root"`
+	ShardProof MerkleProof `json:"shard_proof"` // ShardRoot to ForestRoot
+	ForestRoot string      `json:"forest_root"`
+}
+
+// ProveBlockInclusion proves the block with the given hash against the
+// current ForestRoot, returning ErrBlockNotFound if no shard holds it. Both
+// paths are taken from the same shard state, so the proof verifies against
+// the ForestRoot it carries even if the shard changes meanwhile.
+func (sm *ShardManager) ProveBlockInclusion(blockHash string) (InclusionProof, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	for {
+		tree, entries := sm.forestTree()
+		proof, stale, err := proveInForest(tree, entries, blockHash)
+		if !stale {
+			return proof, err
+		}
+	}
+}
+
+// proveInForest finds the block among the forest's shards and proves it.
+// stale reports that a shard changed since the forest was built, so the
+// forest must be rebuilt first.
+func proveInForest(tree *MerkleTree, entries []forestEntry, blockHash string) (proof InclusionProof, stale bool, err error) {
+	for i, entry := range entries {
+		shard := entry.shard
+		shard.mutex.Lock()
+		if shard.version != entry.version {
+			shard.mutex.Unlock()
+			return InclusionProof{}, true, nil
+		}
+		block, blockProof, err := shard.proveInclusion(blockHash)
+		if err != nil {
+			shard.mutex.Unlock()
+			continue
+		}
+		root := shard.GetRoot()
+		shard.mutex.Unlock()
+
+		shardProof, err := tree.GetProof(i)
+		if err != nil {
+			return InclusionProof{}, false, err
+		}
+		return InclusionProof{
+			ShardID:    shard.ID,
+			Block:      block,
+			BlockProof: blockProof,
+			ShardRoot:  root,
+			ShardProof: shardProof,
+			ForestRoot: tree.GetRootHash(),
+		}, false, nil
+	}
+	return InclusionProof{}, false, fmt.Errorf("%w: %s in any shard", ErrBlockNotFound, blockHash)
+}
+
+// VerifyInclusionProof checks an inclusion proof against a trusted forest root
+func VerifyInclusionProof(forestRoot string, p InclusionProof) bool {
+	return p.ForestRoot == forestRoot &&
+		VerifyBlockInForest(forestRoot, p.Block, p.BlockProof, p.ShardRoot, p.ShardProof)
+}
+
+// VerifyShardInForest checks that shardRoot is committed to by forestRoot
+func VerifyShardInForest(forestRoot, shardRoot string, proof MerkleProof) bool {
+	return VerifyMerkleProof(forestRoot, shardRoot, proof)
+}
+
+// VerifyBlockInForest chains a block proof from Shard.ProveInclusion with a
+// shard proof from ProveShardInForest to verify a block against ForestRoot
+func VerifyBlockInForest(forestRoot string, block Block, blockProof MerkleProof, shardRoot string, shardProof MerkleProof) bool {
+	return VerifyBlockProof(shardRoot, block, blockProof) &&
+		VerifyShardInForest(forestRoot, shardRoot, shardProof)
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// forestFromScratch rebuilds the forest root from every shard's blocks,
+// bypassing the cache and the shards' own trees
+func forestFromScratch(sm *ShardManager) string {
+	var roots []string
+	for _, shard := range sm.Shards.GetAllShards() {
+		roots = append(roots, newBlockTree(shard.Blocks).GetRootHash())
+	}
+	return NewMerkleTree(roots).GetRootHash()
+}
+
+func TestForestRootCacheInvalidation(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	check := func(step string) {
+		t.Helper()
+		if got, want := sm.ForestRoot(), forestFromScratch(sm); got != want {
+			t.Fatalf("%s: forest root %s, rebuilt %s", step, got, want)
+		}
+	}
+	prev := GenesisBlock()
+	next := func(data string) Block {
+		prev = mustGenerateBlock(t, prev, data)
+		return prev
+	}
+	for i := 0; i < 6; i++ {
+		sm.DistributeBlock(next(fmt.Sprint("distribute-", i)))
+		check("distribute")
+	}
+
+	before := sm.ForestRoot()
+	if sm.ForestRoot() != before {
+		t.Fatal("forest root changed without a mutation")
+	}
+	shard, err := sm.FindShard(sm.Shards.GetAllShards()[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard.AddBlock(next("direct"))
+	check("direct shard append")
+	if sm.ForestRoot() == before {
+		t.Fatal("appending to a shard left the forest root unchanged")
+	}
+
+	// Rebalancing replaces shards with split halves
+	if err := sm.SetThresholds(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	for shards := 0; shards != len(sm.Shards.GetAllShards()); {
+		shards = len(sm.Shards.GetAllShards())
+		if err := sm.RebalanceShards(); err != nil {
+			t.Fatal(err)
+		}
+		check("rebalance")
+	}
+	if len(sm.Shards.GetAllShards()) < 3 {
+		t.Fatalf("%d shards after rebalancing", len(sm.Shards.GetAllShards()))
+	}
+	for i := 0; i < 5; i++ {
+		sm.DistributeBlock(next(fmt.Sprint("after-split-", i)))
+		check("distribute after rebalancing")
+	}
+
+	shards := sm.Shards.GetAllShards()
+	if err := sm.TransferBlock(NewEnhancedSyncManager("key"), shards[0].ID, shards[1].ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	check("transfer")
+	if err := sm.MergeShards(100); err != nil {
+		t.Fatal(err)
+	}
+	check("merge")
+}
+
+func TestBlockInclusionAgainstForestRoot(t *testing.T) {
+	sm := NewShardManager()
+	prev := GenesisBlock()
+	for _, data := range testLeaves(14) {
+		prev = mustGenerateBlock(t, prev, data)
+		sm.DistributeBlock(prev)
+	}
+	root := sm.ForestRoot()
+	for _, shard := range sm.Shards.GetAllShards() {
+		shardProof, err := sm.ProveShardInForest(shard.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyShardInForest(root, shard.GetRoot(), shardProof) {
+			t.Fatalf("shard #%d root rejected", shard.ID)
+		}
+		if VerifyShardInForest(root, NewMerkleTree([]string{"bogus"}).Root, shardProof) {
+			t.Fatalf("bogus root accepted for shard #%d", shard.ID)
+		}
+		for _, b := range shard.Blocks {
+			block, blockProof, err := shard.ProveInclusion(b.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyBlockInForest(root, block, blockProof, shard.GetRoot(), shardProof) {
+				t.Fatalf("block %s rejected against the forest root", b.Hash)
+			}
+			block.Data = "forged"
+			if VerifyBlockInForest(root, block, blockProof, shard.GetRoot(), shardProof) {
+				t.Fatal("forged block accepted against the forest root")
+			}
+		}
+	}
+	if _, err := sm.ProveShardInForest(sm.Shards.NextID()); err == nil {
+		t.Fatal("proof for a missing shard")
+	}
+}
+
+func TestProveBlockInclusion(t *testing.T) {
+	sm := NewShardManager()
+	blocks := fixedBlocks(20)
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+	shards := sm.Shards.GetAllShards()
+	if len(shards) < 3 {
+		t.Fatalf("20 blocks over %d shards, want at least 3", len(shards))
+	}
+
+	// Blocks in the first and last shards prove against the forest root
+	root := sm.ForestRoot()
+	for _, shard := range []*Shard{shards[0], shards[len(shards)-1]} {
+		for _, b := range shard.Blocks {
+			proof, err := sm.ProveBlockInclusion(b.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof.ShardID != shard.ID || proof.Block.Hash != b.Hash || proof.ForestRoot != root {
+				t.Fatalf("block %s proved in shard #%d under %s", b.Hash, proof.ShardID, proof.ForestRoot)
+			}
+			if !VerifyInclusionProof(root, proof) {
+				t.Fatalf("block %s in shard #%d rejected", b.Hash, shard.ID)
+			}
+		}
+	}
+
+	if _, err := sm.ProveBlockInclusion("missing"); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("proof for a block no shard holds: %v", err)
+	}
+
+	proof, err := sm.ProveBlockInclusion(blocks[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tamper := range map[string]func(*InclusionProof){
+		"block data":  func(p *InclusionProof) { p.Block.Data = "forged" },
+		"shard root":  func(p *InclusionProof) { p.ShardRoot = NewMerkleTree([]string{"bogus"}).Root },
+		"forest root": func(p *InclusionProof) { p.ForestRoot = NewMerkleTree([]string{"bogus"}).Root },
+	} {
+		tampered := proof
+		tamper(&tampered)
+		if VerifyInclusionProof(root, tampered) {
+			t.Errorf("proof with tampered %s verified", name)
+		}
+	}
+
+	// After a rebalance every block proves against the new root only
+	if err := sm.SetThresholds(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.RebalanceShards(); err != nil {
+		t.Fatal(err)
+	}
+	if sm.Shards.Size() <= len(shards) {
+		t.Fatalf("rebalance left %d shards, had %d", sm.Shards.Size(), len(shards))
+	}
+	rebalanced := sm.ForestRoot()
+	if rebalanced == root {
+		t.Fatal("forest root unchanged by the rebalance")
+	}
+	for _, b := range blocks {
+		proof, err := sm.ProveBlockInclusion(b.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyInclusionProof(rebalanced, proof) {
+			t.Fatalf("block %s rejected after the rebalance", b.Hash)
+		}
+		if VerifyInclusionProof(root, proof) {
+			t.Fatalf("block %s verified against the root before the rebalance", b.Hash)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"testing"
+)
+
+// mustGenerateBlock creates the successor of prev or fails the test
+func mustGenerateBlock(t testing.TB, prev Block, data string) Block {
+	t.Helper()
+	b, err := GenerateBlock(prev, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// blockHashes lists the hashes of blocks in order
+func blockHashes(blocks []Block) []string {
+	hashes := make([]string, len(blocks))
+	for i, b := range blocks {
+		hashes[i] = b.Hash
+	}
+	return hashes
+}
+
+func equalHashes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResolveForksReorganizesToLongerBranch(t *testing.T) {
+	bc := NewBlockchain()
+	if err := bc.AddBlock("a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock("a2"); err != nil {
+		t.Fatal(err)
+	}
+	oldMain := append([]Block{}, bc.Blocks[1:]...)
+
+	genesis := bc.Blocks[0]
+	b1 := mustGenerateBlock(t, genesis, "b1")
+	b2 := mustGenerateBlock(t, b1, "b2")
+	b3 := mustGenerateBlock(t, b2, "b3")
+	for _, b := range []Block{b1, b2} {
+		if err := bc.AddBlockCandidate(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A branch as long as the main chain does not win
+	rolledBack, applied, err := bc.ResolveForks()
+	if err != nil || len(rolledBack) != 0 || len(applied) != 0 {
+		t.Fatalf("equal-length fork reorganized: %v %v %v", rolledBack, applied, err)
+	}
+	if !bc.HasSideBlock(b2.Hash) {
+		t.Fatal("b2 not held as a side block")
+	}
+
+	if err := bc.AddBlockCandidate(b3); err != nil {
+		t.Fatal(err)
+	}
+	rolledBack, applied, err = bc.ResolveForks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalHashes(blockHashes(rolledBack), blockHashes(oldMain)) {
+		t.Errorf("rolled back %v, want %v", blockHashes(rolledBack), blockHashes(oldMain))
+	}
+	if want := []string{b1.Hash, b2.Hash, b3.Hash}; !equalHashes(blockHashes(applied), want) {
+		t.Errorf("applied %v, want %v", blockHashes(applied), want)
+	}
+	if want := []string{genesis.Hash, b1.Hash, b2.Hash, b3.Hash}; !equalHashes(blockHashes(bc.Blocks), want) {
+		t.Errorf("main chain %v, want %v", blockHashes(bc.Blocks), want)
+	}
+	for _, b := range oldMain {
+		if !bc.HasSideBlock(b.Hash) {
+			t.Errorf("rolled back block #%d not kept as a side block", b.Index)
+		}
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrphansConnectWhenParentArrives(t *testing.T) {
+	bc := NewBlockchain()
+	b1 := mustGenerateBlock(t, bc.Blocks[0], "1")
+	b2 := mustGenerateBlock(t, b1, "2")
+	b3 := mustGenerateBlock(t, b2, "3")
+
+	// Children first, parent last
+	for _, b := range []Block{b3, b2} {
+		if err := bc.AddBlockCandidate(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(bc.Blocks) != 1 {
+		t.Fatalf("orphans extended the chain to %d blocks", len(bc.Blocks))
+	}
+	if err := bc.AddBlockCandidate(b1); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{bc.Blocks[0].Hash, b1.Hash, b2.Hash, b3.Hash}; !equalHashes(blockHashes(bc.Blocks), want) {
+		t.Fatalf("main chain %v, want %v", blockHashes(bc.Blocks), want)
+	}
+	if len(bc.orphans) != 0 {
+		t.Fatalf("%d orphans left", len(bc.orphans))
+	}
+}
+
+func TestOrphanPoolIsBounded(t *testing.T) {
+	bc := NewBlockchain()
+	prev := mustGenerateBlock(t, bc.Blocks[0], "missing parent")
+	for i := 0; i < MaxOrphanBlocks+10; i++ {
+		b := mustGenerateBlock(t, prev, "orphan")
+		b.Data = string(rune('a'+i%26)) + b.Data
+		b.Index = prev.Index + 1 + i
+		b.Hash = calculateHash(b)
+		if err := bc.AddBlockCandidate(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(bc.orphans) != MaxOrphanBlocks {
+		t.Fatalf("orphan pool holds %d blocks, want %d", len(bc.orphans), MaxOrphanBlocks)
+	}
+}
+
+func TestReorganizeRejectsBlockOnMainChain(t *testing.T) {
+	bc := NewBlockchain()
+	if err := bc.AddBlock("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bc.Reorganize(bc.Blocks[1]); err == nil {
+		t.Fatal("reorganized onto the current tip")
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrChainMismatch is returned for blocks or peers from a different chain
+var ErrChainMismatch = errors.New("chain mismatch")
+
+// DefaultChainID identifies chains started from DefaultGenesisConfig
+const DefaultChainID = "ledger-main"
+
+// GenesisConfig fixes everything the genesis block is derived from, so
+// nodes started from the same config agree on the genesis hash
+type GenesisConfig struct {
+	ChainID         string            `json:"chain_id"`
+	Timestamp       time.Time         `json:"timestamp"`
+	Data            string            `json:"data"`
+	InitialBalances map[string]uint64 `json:"initial_balances,omitempty"`
+	HashFunction    string            `json:"hash_function,omitempty"` // Hasher of every block hash, empty for SHA-256
+}
+
+// DefaultGenesisConfig is the config NewBlockchain starts from
+func DefaultGenesisConfig() GenesisConfig {
+	return GenesisConfig{
+		ChainID:   DefaultChainID,
+		Timestamp: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Data:      "Genesis Block",
+	}
+}
+
+// Validate checks the genesis data against the default block limits, as
+// text every node must be able to load from the same config
+func (cfg GenesisConfig) Validate() error {
+	return BlockLimits{MaxDataBytes: DefaultMaxBlockDataBytes, RequireUTF8: true}.checkData(0, cfg.Data)
+}
+
+// State returns the account state holding the initial balances
+func (cfg GenesisConfig) State() *LedgerState {
+	state := NewLedgerState()
+	for addr, amount := range cfg.InitialBalances {
+		// Each address is credited once from zero, which cannot overflow
+		state.Credit(addr, amount)
+	}
+	return state
+}
+
+// Block derives the genesis block. Having no parent, its PrevHash commits
+// to the chain ID, and its StateRoot to the initial balances if any.
+func (cfg GenesisConfig) Block() Block {
+	genesis := Block{
+		Index:     0,
+		Timestamp: cfg.Timestamp.Round(0),
+		Data:      cfg.Data,
+		PrevHash:  chainTag(cfg.ChainID),
+
+		HashFunction: cfg.HashFunction,
+	}
+	if len(cfg.InitialBalances) > 0 {
+		genesis.StateRoot = cfg.State().StateRoot()
+	}
+	genesis.Hash = calculateHash(genesis)
+	return genesis
+}
+
+// chainTag is the PrevHash of a chain's genesis block
+func chainTag(chainID string) string {
+	hash := sha256.Sum256([]byte("chain:" + chainID))
+	return hex.EncodeToString(hash[:])
+}
+
+// ChainID returns the ID of the chain the blockchain was started for
+func (bc *Blockchain) ChainID() string {
+	return bc.chainID
+}
+
+// HashFunction returns the name of the hash function the chain's blocks are
+// hashed with, empty for SHA-256
+func (bc *Blockchain) HashFunction() string {
+	return bc.hashFunction
+}
+
+// GenesisHash returns the hash of the chain's genesis block, or "" once it
+// has been pruned
+func (bc *Blockchain) GenesisHash() string {
+	if len(bc.Blocks) == 0 || bc.Blocks[0].Index != 0 {
+		return ""
+	}
+	return bc.Blocks[0].Hash
+}
+
+// CheckChain verifies that a peer announcing chainID and genesisHash follows
+// the same chain. A pruned local chain only compares chain IDs.
+func (bc *Blockchain) CheckChain(chainID, genesisHash string) error {
+	if chainID != bc.chainID {
+		return fmt.Errorf("%w: chain ID %q, want %q", ErrChainMismatch, chainID, bc.chainID)
+	}
+	if local := bc.GenesisHash(); local != "" && genesisHash != local {
+		return fmt.Errorf("%w: genesis %s, want %s", ErrChainMismatch, genesisHash, local)
+	}
+	return nil
+}
+
+// checkChainBlock rejects a block that cannot belong to this chain: one
+// hashed with another function, another genesis block, or a first block
+// built on another genesis
+func (bc *Blockchain) checkChainBlock(b Block) error {
+	if b.HashFunction != bc.hashFunction {
+		return fmt.Errorf("%w: block #%d uses %q, want %q", ErrHasherMismatch, b.Index, b.HashFunction, bc.hashFunction)
+	}
+	genesis := bc.GenesisHash()
+	if genesis == "" {
+		return nil
+	}
+	if b.Index == 0 && b.Hash != genesis {
+		return fmt.Errorf("%w: genesis %s, want %s", ErrChainMismatch, b.Hash, genesis)
+	}
+	if b.Index == 1 && b.PrevHash != genesis {
+		return fmt.Errorf("%w: block #1 builds on %s, want %s", ErrChainMismatch, b.PrevHash, genesis)
+	}
+	return nil
+}
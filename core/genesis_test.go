@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSameGenesisConfigSameHash(t *testing.T) {
+	cfg := GenesisConfig{
+		ChainID:         "testnet",
+		Timestamp:       time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Data:            "testnet genesis",
+		InitialBalances: map[string]uint64{"alice": 100, "bob": 50, "carol": 7},
+	}
+
+	// A second node loads the same config into its own map
+	copied := cfg
+	copied.InitialBalances = map[string]uint64{"carol": 7, "bob": 50, "alice": 100}
+
+	a, b := NewBlockchainFromGenesis(cfg), NewBlockchainFromGenesis(copied)
+	if a.GenesisHash() != b.GenesisHash() || a.GenesisHash() != cfg.Block().Hash {
+		t.Fatalf("genesis hashes %s and %s differ", a.GenesisHash(), b.GenesisHash())
+	}
+	if a.ChainID() != "testnet" || a.State().BalanceOf("alice") != 100 {
+		t.Fatalf("chain %q with alice at %d", a.ChainID(), a.State().BalanceOf("alice"))
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if NewBlockchain().GenesisHash() != GenesisBlock().Hash {
+		t.Fatal("default chain does not start from GenesisBlock")
+	}
+
+	for name, change := range map[string]func(*GenesisConfig){
+		"chain ID":  func(c *GenesisConfig) { c.ChainID = "other" },
+		"timestamp": func(c *GenesisConfig) { c.Timestamp = c.Timestamp.Add(time.Nanosecond) },
+		"data":      func(c *GenesisConfig) { c.Data = "other" },
+		"balances":  func(c *GenesisConfig) { c.InitialBalances = map[string]uint64{"alice": 101, "bob": 50, "carol": 7} },
+	} {
+		changed := cfg
+		change(&changed)
+		if changed.Block().Hash == cfg.Block().Hash {
+			t.Errorf("changing the %s kept the genesis hash", name)
+		}
+	}
+}
+
+func TestBlocksFromAnotherChainRejected(t *testing.T) {
+	cfg := DefaultGenesisConfig()
+	other := cfg
+	other.ChainID = "other"
+	bc, foreign := NewBlockchainFromGenesis(cfg), NewBlockchainFromGenesis(other)
+
+	if err := bc.CheckChain(cfg.ChainID, bc.GenesisHash()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.CheckChain(other.ChainID, foreign.GenesisHash()); !errors.Is(err, ErrChainMismatch) {
+		t.Fatalf("peer on another chain ID: %v", err)
+	}
+	if err := bc.CheckChain(cfg.ChainID, foreign.GenesisHash()); !errors.Is(err, ErrChainMismatch) {
+		t.Fatalf("peer with the same ID and another genesis: %v", err)
+	}
+
+	if err := foreign.AddBlock("foreign"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlockCandidate(foreign.Blocks[1]); !errors.Is(err, ErrChainMismatch) {
+		t.Fatalf("block built on another genesis: %v", err)
+	}
+	if err := bc.AddBlockCandidate(foreign.Blocks[0]); !errors.Is(err, ErrChainMismatch) {
+		t.Fatalf("another chain's genesis block: %v", err)
+	}
+	if len(bc.Blocks) != 1 {
+		t.Fatalf("chain grew to %d blocks", len(bc.Blocks))
+	}
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// ErrUnknownHistoricalRoot is returned for forest roots never accumulated
+var ErrUnknownHistoricalRoot = errors.New("forest root was never accumulated")
+
+// GlobalCommitmentManager accumulates successive forest roots of a
+// ShardManager into an RSA accumulator. Its constant-size state commits to
+// every ledger state it has seen, so a past forest root stays provable after
+// the blocks under it are pruned.
+type GlobalCommitmentManager struct {
+	shards     *ShardManager
+	acc        *RSAAccumulator
+	seen       map[string]bool
+	lastRoot   string
+	lastBlocks int // Ledger block count when lastRoot was accumulated
+	interval   int // Blocks between accumulations; 0 accumulates every change
+	mutex      sync.Mutex
+}
+
+// GlobalCommitmentOption configures a GlobalCommitmentManager
+type GlobalCommitmentOption func(*GlobalCommitmentManager)
+
+// WithCommitmentInterval accumulates the forest root only once the ledger
+// has grown by at least blocks since the last accumulated root
+func WithCommitmentInterval(blocks int) GlobalCommitmentOption {
+	return func(g *GlobalCommitmentManager) {
+		g.interval = blocks
+	}
+}
+
+// NewGlobalCommitmentManager creates a manager over sm's forest and
+// accumulates its current root
+func NewGlobalCommitmentManager(sm *ShardManager, opts ...GlobalCommitmentOption) *GlobalCommitmentManager {
+	g := &GlobalCommitmentManager{shards: sm, acc: NewRSAAccumulator(), seen: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(g)
+	}
+	root, blocks := sm.forestSnapshot()
+	g.accumulate(root, blocks)
+	return g
+}
+
+// Update accumulates the current forest root if it changed, subject to the
+// commitment interval, and reports whether it did. Call it after changing
+// the ledger, e.g. after each DistributeBlock.
+func (g *GlobalCommitmentManager) Update() bool {
+	root, blocks := g.shards.forestSnapshot()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if root == g.lastRoot {
+		return false
+	}
+	if blocks < g.lastBlocks {
+		// Blocks were moved out of the ledger; count growth from here
+		g.lastBlocks = blocks
+	}
+	if g.interval > 0 && blocks-g.lastBlocks < g.interval {
+		return false
+	}
+	return g.accumulate(root, blocks)
+}
+
+// accumulate adds a root unless it was accumulated before; the caller must
+// hold the mutex or own g exclusively
+func (g *GlobalCommitmentManager) accumulate(root string, blocks int) bool {
+	g.lastRoot, g.lastBlocks = root, blocks
+	if g.seen[root] {
+		return false
+	}
+	g.seen[root] = true
+	g.acc.AddElement(root)
+	return true
+}
+
+// ProveHistoricalRoot returns a witness that root was once the forest root
+func (g *GlobalCommitmentManager) ProveHistoricalRoot(root string) (*big.Int, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	witness, exists := g.acc.Proofs[root]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHistoricalRoot, root)
+	}
+	return new(big.Int).Set(witness), nil
+}
+
+// VerifyHistoricalRoot checks a witness from ProveHistoricalRoot against
+// the current accumulator state
+func (g *GlobalCommitmentManager) VerifyHistoricalRoot(root string, witness *big.Int) bool {
+	if witness == nil {
+		return false
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.acc.CheckMembership(root, witness) == nil
+}
+
+// State returns the accumulator state in hex
+func (g *GlobalCommitmentManager) State() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.acc.State.Text(16)
+}
+
+// RootCount returns the number of forest roots accumulated
+func (g *GlobalCommitmentManager) RootCount() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return len(g.acc.Elements)
+}
+
+// forestSnapshot returns the forest root and total block count under one
+// lock
+func (sm *ShardManager) forestSnapshot() (string, int) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	tree, entries := sm.forestTree()
+	blocks := 0
+	for _, entry := range entries {
+		entry.shard.mutex.Lock()
+		blocks += len(entry.shard.Blocks)
+		entry.shard.mutex.Unlock()
+	}
+	return tree.GetRootHash(), blocks
+}
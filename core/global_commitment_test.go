@@ -0,0 +1,97 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// committedForest distributes blocks over a manager, accumulating the
+// forest root after each one, and returns every root seen
+func committedForest(t *testing.T, blocks []Block, opts ...GlobalCommitmentOption) (*ShardManager, *GlobalCommitmentManager, []string) {
+	t.Helper()
+	sm := NewShardManager()
+	g := NewGlobalCommitmentManager(sm, opts...)
+	roots := []string{sm.ForestRoot()}
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+		g.Update()
+		roots = append(roots, sm.ForestRoot())
+	}
+	return sm, g, roots
+}
+
+func TestHistoricalRootProvableAfterPruning(t *testing.T) {
+	sm, g, roots := committedForest(t, fixedBlocks(30))
+	sp := NewStatePruner(0, 0, false, WithGlobalCommitment(g))
+
+	if pruned := sm.PruneAllShards(sp, 0); len(pruned) == 0 {
+		t.Fatal("nothing pruned")
+	}
+	for _, view := range sm.GetAllShardViews() {
+		if view.BlockCount != 0 {
+			t.Fatalf("shard #%d kept %d blocks", view.ID, view.BlockCount)
+		}
+	}
+	g.Update()
+
+	for i, root := range roots {
+		witness, err := g.ProveHistoricalRoot(root)
+		if err != nil {
+			t.Fatalf("root after %d blocks: %v", i, err)
+		}
+		if !g.VerifyHistoricalRoot(root, witness) {
+			t.Fatalf("root after %d blocks fails to verify", i)
+		}
+	}
+
+	// Every shard proof records a state that already covered the ledger
+	// as it was before pruning
+	if len(sp.integrityProofs) == 0 {
+		t.Fatal("no integrity proofs")
+	}
+	for _, proof := range sp.integrityProofs {
+		if proof.AccumulatorState == "" || !sp.VerifyIntegrity(proof) {
+			t.Fatalf("proof for shard #%d records %q", proof.ShardID, proof.AccumulatorState)
+		}
+		forged := proof
+		forged.AccumulatorState = new(big.Int).SetInt64(7).Text(16)
+		if sp.VerifyIntegrity(forged) {
+			t.Fatal("proof with a replaced accumulator state verifies")
+		}
+	}
+}
+
+func TestHistoricalRootRejectsUnknownAndForged(t *testing.T) {
+	_, g, roots := committedForest(t, fixedBlocks(5))
+	if _, err := g.ProveHistoricalRoot("never-a-root"); !errors.Is(err, ErrUnknownHistoricalRoot) {
+		t.Fatalf("unknown root: %v", err)
+	}
+	witness, err := g.ProveHistoricalRoot(roots[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.VerifyHistoricalRoot("never-a-root", witness) || g.VerifyHistoricalRoot(roots[2], nil) {
+		t.Fatal("forged membership verifies")
+	}
+	if g.Update() {
+		t.Fatal("unchanged forest accumulated again")
+	}
+	if g.RootCount() != len(roots) {
+		t.Fatalf("%d roots accumulated for %d states", g.RootCount(), len(roots))
+	}
+}
+
+func TestCommitmentInterval(t *testing.T) {
+	_, g, roots := committedForest(t, fixedBlocks(30), WithCommitmentInterval(10))
+	// The initial root, then one root per ten blocks
+	if g.RootCount() != 4 {
+		t.Fatalf("%d roots accumulated", g.RootCount())
+	}
+	for i, root := range roots {
+		_, err := g.ProveHistoricalRoot(root)
+		if accumulated := i%10 == 0; (err == nil) != accumulated {
+			t.Fatalf("root after %d blocks provable: %v", i, err)
+		}
+	}
+}
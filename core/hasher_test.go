@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Roots and hashes computed before hash functions were configurable
+const (
+	goldenBlockHash   = "335a9277a42458510915443794df50b00f2014aafdb5b0e78f6a58657fb73bf9"
+	goldenGenesisHash = "349f2606a9c1a39a8bbccb52ea8d7d89976a03ccb613763e59444a03e70235c2"
+	goldenMerkleRoot  = "6c8e6bf029e09644a2a640a0bcd827a4889a5416ca965efb8ec7f499ad91fce3"
+	goldenTrieRoot    = "ea5b3ad890310fa13884b337d4d42fd9be2978a69cce3526169916417a9f122d"
+	goldenPrime       = "1977029959108764749873646140646220029183319051998169396493495541193295098889"
+)
+
+// hashedTrie inserts testLeaves(n) with their index as value
+func hashedTrie(n int, opts ...TrieOption) *SuccinctTrie {
+	st := NewSuccinctTrie(opts...)
+	for i, key := range testLeaves(n) {
+		st.Insert(key, fmt.Sprint(i))
+	}
+	return st
+}
+
+func TestDefaultHasherMatchesSHA256Roots(t *testing.T) {
+	for _, h := range []Hasher{nil, SHA256Hasher{}} {
+		var treeOpts []TreeOption
+		var trieOpts []TrieOption
+		var accOpts []AccumulatorOption
+		if h != nil {
+			treeOpts = append(treeOpts, WithTreeHasher(h))
+			trieOpts = append(trieOpts, WithTrieHasher(h))
+			accOpts = append(accOpts, WithAccumulatorHasher(h))
+		}
+
+		if got := fixedBlocks(5)[4].Hash; got != goldenBlockHash {
+			t.Fatalf("block hash %s", got)
+		}
+		if got := GenesisBlock().Hash; got != goldenGenesisHash {
+			t.Fatalf("genesis hash %s", got)
+		}
+		tree := NewMerkleTree(testLeaves(10), treeOpts...)
+		if tree.Root != goldenMerkleRoot || tree.HashFunction() != HashSHA256 {
+			t.Fatalf("hasher %v: Merkle root %s under %q", h, tree.Root, tree.HashFunction())
+		}
+		trie := hashedTrie(10, trieOpts...)
+		if got := trie.GetMerkleRoot(); got != goldenTrieRoot || trie.HashFunction() != HashSHA256 {
+			t.Fatalf("hasher %v: trie root %s under %q", h, got, trie.HashFunction())
+		}
+		if got := NewRSAAccumulator(accOpts...).hashToPrime("tx-1").String(); got != goldenPrime {
+			t.Fatalf("hasher %v: prime %s", h, got)
+		}
+
+		// The trie encoding is unchanged too
+		var buf bytes.Buffer
+		if err := trie.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(buf.Bytes(), []byte(trieMagic)) {
+			t.Fatalf("hasher %v: trie header %q", h, buf.Bytes()[:len(trieMagic)])
+		}
+	}
+}
+
+func TestOtherHasherChangesRoots(t *testing.T) {
+	h := SHA512_256Hasher{}
+	tree := NewMerkleTree(testLeaves(10), WithTreeHasher(h))
+	if tree.Root == goldenMerkleRoot || tree.HashFunction() != HashSHA512256 {
+		t.Fatalf("Merkle root %s under %q", tree.Root, tree.HashFunction())
+	}
+	proof, err := tree.GetProof(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyMerkleProof(tree.Root, testLeaves(10)[3], proof) {
+		t.Fatal("proof under SHA-512/256 rejected")
+	}
+	// The proof names its hash function, so relabelling it breaks it
+	relabelled := proof
+	relabelled.Hasher = ""
+	if VerifyMerkleProof(tree.Root, testLeaves(10)[3], relabelled) {
+		t.Fatal("proof accepted as SHA-256")
+	}
+
+	trie := hashedTrie(10, WithTrieHasher(h))
+	if trie.GetMerkleRoot() == goldenTrieRoot {
+		t.Fatal("trie root unchanged under SHA-512/256")
+	}
+	if NewRSAAccumulator(WithAccumulatorHasher(h)).hashToPrime("tx-1").String() == goldenPrime {
+		t.Fatal("prime unchanged under SHA-512/256")
+	}
+}
+
+func TestMixedHashChainsRejected(t *testing.T) {
+	cfg := DefaultGenesisConfig()
+	cfg.HashFunction = HashSHA512256
+	bc := NewBlockchainFromGenesis(cfg)
+	if bc.HashFunction() != HashSHA512256 || bc.GenesisHash() == goldenGenesisHash {
+		t.Fatalf("chain under %q with genesis %s", bc.HashFunction(), bc.GenesisHash())
+	}
+	if err := bc.AddBlock("next"); err != nil {
+		t.Fatal(err)
+	}
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if tip.HashFunction != HashSHA512256 {
+		t.Fatalf("block built under %q", tip.HashFunction)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A SHA-256 block on the same parent is refused
+	sha256Block := Block{Index: tip.Index + 1, Timestamp: tip.Timestamp, Data: "mixed", PrevHash: tip.Hash}
+	sha256Block.Hash = calculateHash(sha256Block)
+	if err := bc.AddBlockCandidate(sha256Block); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("SHA-256 block on a SHA-512/256 chain: %v", err)
+	}
+	// and so is a block relabelled after the fact
+	relabelled := tip
+	relabelled.HashFunction = ""
+	bc.Blocks[len(bc.Blocks)-1] = relabelled
+	if err := bc.Validate(); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("relabelled block validated: %v", err)
+	}
+	bc.Blocks[len(bc.Blocks)-1] = tip
+
+	// Blocks naming an unknown function match no hash
+	unknown := tip
+	unknown.HashFunction = "md5"
+	if calculateHash(unknown) != "" {
+		t.Fatal("block hashed under an unknown function")
+	}
+	if _, err := LookupHasher("md5"); !errors.Is(err, ErrUnknownHasher) {
+		t.Fatalf("unknown hasher: %v", err)
+	}
+}
+
+func TestTrieImportRejectsOtherHasher(t *testing.T) {
+	var sha256Trie, otherTrie bytes.Buffer
+	if err := hashedTrie(10).Serialize(&sha256Trie); err != nil {
+		t.Fatal(err)
+	}
+	if err := hashedTrie(10, WithTrieHasher(SHA512_256Hasher{})).Serialize(&otherTrie); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DeserializeTrie(bytes.NewReader(otherTrie.Bytes())); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("SHA-512/256 trie opened as SHA-256: %v", err)
+	}
+	if _, err := DeserializeTrie(bytes.NewReader(sha256Trie.Bytes()), WithTrieHasher(SHA512_256Hasher{})); !errors.Is(err, ErrHasherMismatch) {
+		t.Fatalf("SHA-256 trie opened as SHA-512/256: %v", err)
+	}
+	st, err := DeserializeTrie(bytes.NewReader(otherTrie.Bytes()), WithTrieHasher(SHA512_256Hasher{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.GetMerkleRoot() != hashedTrie(10, WithTrieHasher(SHA512_256Hasher{})).GetMerkleRoot() {
+		t.Fatal("SHA-512/256 trie changed root on import")
+	}
+}
+
+var benchHashers = []Hasher{SHA256Hasher{}, SHA512_256Hasher{}}
+
+func BenchmarkTreeConstruction(b *testing.B) {
+	data := testLeaves(1024)
+	for _, h := range benchHashers {
+		b.Run(h.Name(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewMerkleTree(data, WithTreeHasher(h))
+			}
+		})
+	}
+}
+
+func BenchmarkBlockHashing(b *testing.B) {
+	for _, h := range benchHashers {
+		block := fixedBlocks(1)[0]
+		block.HashFunction = hasherName(h)
+		b.Run(h.Name(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				calculateHash(block)
+			}
+		})
+	}
+}
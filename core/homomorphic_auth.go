@@ -1,11 +1,18 @@
 package core
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // HomomorphicCommitment represents a commitment to some data
@@ -14,29 +21,84 @@ type HomomorphicCommitment struct {
 	Commitment string
 }
 
-// HomomorphicAuthenticator handles homomorphic authentication
+// DefaultKeyID names the key of authenticators built from a single raw key.
+// Commitments without a key ID are verified against it.
+const DefaultKeyID = "default"
+
+// HomomorphicAuthenticator handles homomorphic authentication over a keyring.
+// Commitments are "kid:hexmac" so they stay verifiable after key rotation.
 type HomomorphicAuthenticator struct {
-	key []byte
+	keys     map[string][]byte
+	activeID string
+	mutex    sync.RWMutex
 }
 
 // NewHomomorphicAuthenticator creates a new authenticator
 func NewHomomorphicAuthenticator(key string) *HomomorphicAuthenticator {
 	return &HomomorphicAuthenticator{
-		key: []byte(key),
+		keys:     map[string][]byte{DefaultKeyID: []byte(key)},
+		activeID: DefaultKeyID,
+	}
+}
+
+// NewHomomorphicAuthenticatorWithKeyring creates an authenticator that signs
+// with keys[activeID] and verifies with any key in the ring
+func NewHomomorphicAuthenticatorWithKeyring(keys map[string][]byte, activeID string) (*HomomorphicAuthenticator, error) {
+	ring := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		ring[id] = append([]byte{}, key...)
 	}
+	if _, exists := ring[activeID]; !exists {
+		return nil, fmt.Errorf("active key %q not in keyring", activeID)
+	}
+	return &HomomorphicAuthenticator{keys: ring, activeID: activeID}, nil
+}
+
+// RotateKey adds a key and makes it active; commitments under older keys
+// remain verifiable. Reusing an existing ID replaces that key, which
+// invalidates its commitments.
+func (ha *HomomorphicAuthenticator) RotateKey(newID string, key []byte) {
+	ha.mutex.Lock()
+	defer ha.mutex.Unlock()
+	ha.keys[newID] = append([]byte{}, key...)
+	ha.activeID = newID
 }
 
-// AuthenticateData creates a commitment for some data
+// ActiveKeyID returns the ID of the key used for new commitments
+func (ha *HomomorphicAuthenticator) ActiveKeyID() string {
+	ha.mutex.RLock()
+	defer ha.mutex.RUnlock()
+	return ha.activeID
+}
+
+// hmacHex computes the raw HMAC of data under a key
+func hmacHex(key []byte, data string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// AuthenticateData creates a commitment for some data under the active key
 func (ha *HomomorphicAuthenticator) AuthenticateData(data string) string {
-	mac := hmac.New(sha256.New, ha.key)
-	mac.Write([]byte(data))
-	return hex.EncodeToString(mac.Sum(nil))
+	ha.mutex.RLock()
+	defer ha.mutex.RUnlock()
+	return ha.activeID + ":" + hmacHex(ha.keys[ha.activeID], data)
 }
 
-// VerifyAuthentication checks if a commitment is valid
+// VerifyAuthentication checks a commitment with the key named by its key ID
 func (ha *HomomorphicAuthenticator) VerifyAuthentication(data, commitment string) bool {
-	expected := ha.AuthenticateData(data)
-	return hmac.Equal([]byte(commitment), []byte(expected))
+	keyID, digest := DefaultKeyID, commitment
+	if i := strings.LastIndex(commitment, ":"); i >= 0 {
+		keyID, digest = commitment[:i], commitment[i+1:]
+	}
+
+	ha.mutex.RLock()
+	key, exists := ha.keys[keyID]
+	ha.mutex.RUnlock()
+	if !exists {
+		return false
+	}
+	return hmac.Equal([]byte(digest), []byte(hmacHex(key, data)))
 }
 
 // CombineCommitments homomorphically combines multiple commitments
@@ -57,12 +119,55 @@ func (ha *HomomorphicAuthenticator) CombineCommitments(commitments []Homomorphic
 	}
 }
 
+// Errors returned by authenticated transfers
+var (
+	ErrRootMismatch   = errors.New("shard root mismatch after commit")
+	ErrTransferFailed = errors.New("transfer already finished without committing")
+)
+
+// RootMismatchError reports a shard whose Merkle root or chain commitment
+// after commit differs from the one predicted before the move
+type RootMismatchError struct {
+	ShardID  int
+	Expected string
+	Actual   string
+	Chain    bool // The chain commitment differed rather than the Merkle root
+}
+
+func (e *RootMismatchError) Error() string {
+	if e.Chain {
+		return fmt.Sprintf("%v: shard #%d chain commitment expected %s, got %s", ErrRootMismatch, e.ShardID, e.Expected, e.Actual)
+	}
+	return fmt.Sprintf("%v: shard #%d expected %s, got %s", ErrRootMismatch, e.ShardID, e.Expected, e.Actual)
+}
+
+// Is lets errors.Is match RootMismatchError against ErrRootMismatch
+func (e *RootMismatchError) Is(target error) bool {
+	return target == ErrRootMismatch
+}
+
+// BlockSyncer moves blocks between shards; SyncManager is the default implementation
+type BlockSyncer interface {
+	SyncBlock(source, destination *Shard, blockIndex int) error
+}
+
 // EnhancedSyncManager extends SyncManager with homomorphic authentication and atomic transfers
 type EnhancedSyncManager struct {
-	syncManager      *SyncManager
-	authenticator    *HomomorphicAuthenticator
-	pendingTransfers map[string]*TransferState // Track pending transfers for 2PC
-	mutex            sync.Mutex
+	syncManager        BlockSyncer
+	authenticator      *HomomorphicAuthenticator
+	pendingTransfers   map[string]*TransferState // Track pending transfers for 2PC
+	committedTransfers map[string]*TransferState // Committed transfers with consistency proofs
+	wal                io.Writer                 // Optional write-ahead log for crash recovery
+	journal            *TransferJournal          // Bounded history of transfer attempts
+	completed          *completedTransfers       // Outcomes of finished keyed transfers, for replays
+	capabilities       CapabilityVerifier        // Checks transfer tokens when set, see RequireCapabilities
+	committees         map[int]*BFTManager       // Destination committees by shard ID, see AssignCommittee
+	committeeMutex     sync.Mutex                // Serializes committee rounds
+	consistency        *ConsistencyOrchestrator  // Level causal order is checked at, see EnforceCausalOrder
+	sequence           uint64                    // Last transfer sequence number, updated atomically
+	faults             FaultInjector             // Hooks for fault-injection tests, see SetFaultInjector
+	receiptKey         ed25519.PrivateKey        // Signs transfer receipts, see SetReceiptKey
+	mutex              sync.Mutex
 }
 
 // TransferState represents the state of a pending transfer
@@ -70,129 +175,401 @@ type TransferState struct {
 	SourceShard    *Shard
 	DestShard      *Shard
 	BlockIndex     int
+	BlockHash      string // Hash of the block moved, by which rollback finds it
+	Key            string // Caller-supplied idempotency key, empty for unkeyed transfers
+	Nonce          string // Random 128-bit nonce bound into Commitment, in hex
+	Sequence       uint64 // Manager sequence number bound into Commitment
 	Commitment     string
 	Prepared       bool
-	SourceSnapshot []Block // Snapshot for rollback
-	DestSnapshot   []Block // Snapshot for rollback
+	DestOldRoot    string             // Destination root before commit
+	DestOldSize    int                // Destination block count before commit
+	DestProof      []string           // Consistency proof for the destination after commit
+	ExpectedSource string             // Source root predicted at commit
+	ExpectedDest   string             // Destination root predicted at commit
+	SourceChain    string             // Source chain commitment predicted at commit
+	DestChain      string             // Destination chain commitment predicted at commit
+	Certificate    *CommitCertificate // Destination committee's decision, if it has one
+	record         *TransferRecord
 }
 
 // NewEnhancedSyncManager creates a new EnhancedSyncManager
 func NewEnhancedSyncManager(key string) *EnhancedSyncManager {
+	return NewEnhancedSyncManagerWithKeyring(NewHomomorphicAuthenticator(key))
+}
+
+// NewEnhancedSyncManagerWithKeyring creates an EnhancedSyncManager whose
+// transfer commitments use a keyring that can be rotated. Receipts are
+// signed with a fresh ed25519 key until SetReceiptKey installs another.
+func NewEnhancedSyncManagerWithKeyring(auth *HomomorphicAuthenticator) *EnhancedSyncManager {
+	// In production, load the key from secure key management
+	_, receiptKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("generating receipt key: %v", err))
+	}
 	return &EnhancedSyncManager{
-		syncManager:      NewSyncManager(),
-		authenticator:    NewHomomorphicAuthenticator(key),
-		pendingTransfers: make(map[string]*TransferState),
+		syncManager:        NewSyncManager(),
+		authenticator:      auth,
+		pendingTransfers:   make(map[string]*TransferState),
+		committedTransfers: make(map[string]*TransferState),
+		journal:            NewTransferJournal(DefaultJournalCapacity),
+		completed:          newCompletedTransfers(DefaultCompletedCapacity),
+		committees:         make(map[int]*BFTManager),
+		receiptKey:         receiptKey,
+	}
+}
+
+// SetSyncManager replaces the component that moves blocks during commit
+func (esm *EnhancedSyncManager) SetSyncManager(syncer BlockSyncer) {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	esm.syncManager = syncer
+}
+
+// GetCommittedTransfer returns a committed transfer and its consistency proof
+func (esm *EnhancedSyncManager) GetCommittedTransfer(transferID string) (*TransferState, bool) {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	state, exists := esm.committedTransfers[transferID]
+	return state, exists
+}
+
+// transferMessage is what a block transfer commitment covers. Binding both
+// shard IDs, a random nonce and the manager's sequence number means a
+// commitment only verifies for the one transfer it was issued for.
+func transferMessage(sourceID, destID int, block Block, nonce string, sequence uint64) string {
+	return fmt.Sprintf("transfer|%d|%d|%s:%s|%s|%d", sourceID, destID, block.Hash, block.Data, nonce, sequence)
+}
+
+// nextTransferNonce draws a fresh nonce and sequence number for a transfer
+func (esm *EnhancedSyncManager) nextTransferNonce() (string, uint64) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("generating transfer nonce: %v", err))
 	}
+	return hex.EncodeToString(nonce), atomic.AddUint64(&esm.sequence, 1)
 }
 
-// CreateAuthenticatedTransfer initiates a two-phase commit transfer
-func (esm *EnhancedSyncManager) CreateAuthenticatedTransfer(source, destination *Shard, blockIndex int) bool {
+// VerifyTransferCommitment checks that a journaled block transfer's
+// commitment covers its shard pair, nonce and sequence number for block
+func (esm *EnhancedSyncManager) VerifyTransferCommitment(record TransferRecord, block Block) bool {
+	if record.Kind != "block" || block.Hash != record.BlockHash {
+		return false
+	}
+	message := transferMessage(record.SourceID, record.DestID, block, record.Nonce, record.Sequence)
+	return esm.authenticator.VerifyAuthentication(message, record.Commitment)
+}
+
+// CreateAuthenticatedTransfer initiates an unkeyed two-phase commit
+// transfer. When capabilities are required, tokens must grant RightTransfer
+// on both shards.
+func (esm *EnhancedSyncManager) CreateAuthenticatedTransfer(source, destination *Shard, blockIndex int, tokens ...Token) error {
+	return esm.CreateKeyedTransfer("", source, destination, blockIndex, tokens...)
+}
+
+// CreateKeyedTransfer initiates a two-phase commit transfer identified by
+// an idempotency key. Retrying with the same key while the transfer is
+// prepared does nothing, and once it has finished returns its recorded
+// outcome instead of moving the block again. When capabilities are
+// required, tokens must grant RightTransfer on both shards.
+func (esm *EnhancedSyncManager) CreateKeyedTransfer(key string, source, destination *Shard, blockIndex int, tokens ...Token) error {
+	if err := esm.checkTransferCapabilities(source, destination, tokens); err != nil {
+		return err
+	}
 	esm.mutex.Lock()
 	defer esm.mutex.Unlock()
 
+	if source == destination || source.ID == destination.ID {
+		return ErrSameShard
+	}
+	transferID := TransferID(key, source.ID, destination.ID, blockIndex)
+	if key != "" {
+		if err, done := esm.completed.replay(transferID); done {
+			return err
+		}
+		if _, pending := esm.pendingTransfers[transferID]; pending {
+			return nil
+		}
+	}
+
 	// Validate block index
 	if blockIndex < 0 || blockIndex >= len(source.Blocks) {
-		fmt.Printf("Invalid block index %d for Shard #%d (block count: %d)\n", blockIndex, source.ID, len(source.Blocks))
-		return false
+		return fmt.Errorf("%w: %d for shard #%d (block count: %d)", ErrIndexOutOfRange, blockIndex, source.ID, len(source.Blocks))
 	}
 
-	// Create partial state (block hash and data)
+	// Commit to the block for this shard pair only
 	block := source.Blocks[blockIndex]
-	partialState := fmt.Sprintf("%s:%s", block.Hash, block.Data)
-	commitment := esm.authenticator.AuthenticateData(partialState)
+	nonce, sequence := esm.nextTransferNonce()
+	commitment := esm.authenticator.AuthenticateData(transferMessage(source.ID, destination.ID, block, nonce, sequence))
 
 	// Store pending transfer state
-	transferID := fmt.Sprintf("%d-%d-%d", source.ID, destination.ID, blockIndex)
+	record := &TransferRecord{
+		ID:               transferID,
+		Kind:             "block",
+		StartedAt:        time.Now(),
+		SourceID:         source.ID,
+		DestID:           destination.ID,
+		BlockIndex:       blockIndex,
+		BlockHash:        block.Hash,
+		Nonce:            nonce,
+		Sequence:         sequence,
+		Commitment:       commitment,
+		Phases:           []TransferPhase{PhasePrepare},
+		SourceRootBefore: source.GetRoot(),
+		DestRootBefore:   destination.GetRoot(),
+	}
 	transferState := &TransferState{
-		SourceShard:    source,
-		DestShard:      destination,
-		BlockIndex:     blockIndex,
-		Commitment:     commitment,
-		Prepared:       false,
-		SourceSnapshot: make([]Block, len(source.Blocks)),
-		DestSnapshot:   make([]Block, len(destination.Blocks)),
-	}
-	copy(transferState.SourceSnapshot, source.Blocks)
-	copy(transferState.DestSnapshot, destination.Blocks)
+		SourceShard: source,
+		DestShard:   destination,
+		BlockIndex:  blockIndex,
+		BlockHash:   block.Hash,
+		Key:         key,
+		Nonce:       nonce,
+		Sequence:    sequence,
+		Commitment:  commitment,
+		Prepared:    false,
+		record:      record,
+	}
+
+	// Log the intent before anything can be mutated
+	if err := esm.writeWAL(WALRecord{
+		Type:       WALPrepare,
+		TransferID: transferID,
+		SourceID:   source.ID,
+		DestID:     destination.ID,
+		BlockIndex: blockIndex,
+		BlockHash:  block.Hash,
+		Nonce:      nonce,
+		Sequence:   sequence,
+		Commitment: commitment,
+	}); err != nil {
+		return err
+	}
 	esm.pendingTransfers[transferID] = transferState
 
 	// Phase 1: Prepare (lock resources and validate)
-	if !esm.prepareTransfer(transferState) {
+	err := injectFault(esm.faults, FaultBeforePrepare)
+	if err == nil {
+		err = esm.prepareTransfer(transferState)
+	}
+	if err == nil {
+		err = injectFault(esm.faults, FaultAfterPrepare)
+	}
+	if err != nil {
 		delete(esm.pendingTransfers, transferID)
-		return false
+		esm.writeWAL(WALRecord{Type: WALAbort, TransferID: transferID})
+		esm.finishBlockRecord(transferState, OutcomeAborted, ReasonPrepareFailed, err)
+		return err
 	}
+	record.Phases = append(record.Phases, PhasePrepared)
 
-	return true
+	return nil
 }
 
 // prepareTransfer locks resources and validates the transfer
-func (esm *EnhancedSyncManager) prepareTransfer(state *TransferState) bool {
+func (esm *EnhancedSyncManager) prepareTransfer(state *TransferState) error {
+	// Simulate resource locking (e.g., shard mutexes)
+	unlock := lockShards(state.SourceShard, state.DestShard)
+	defer unlock()
+
 	// Validate commitment
-	partialState := fmt.Sprintf("%s:%s", state.SourceShard.Blocks[state.BlockIndex].Hash, state.SourceShard.Blocks[state.BlockIndex].Data)
-	if !esm.authenticator.VerifyAuthentication(partialState, state.Commitment) {
-		fmt.Printf("Prepare failed: Invalid commitment for transfer from Shard #%d to #%d\n", state.SourceShard.ID, state.DestShard.ID)
-		return false
+	block := state.SourceShard.Blocks[state.BlockIndex]
+	if !esm.verifyTransferState(state, block) {
+		return fmt.Errorf("%w: prepare of transfer from shard #%d to #%d", ErrInvalidCommitment, state.SourceShard.ID, state.DestShard.ID)
+	}
+	if err := esm.checkCausality(block, state.DestShard); err != nil {
+		return err
 	}
-
-	// Simulate resource locking (e.g., shard mutexes)
-	state.SourceShard.mutex.Lock()
-	defer state.SourceShard.mutex.Unlock()
-	state.DestShard.mutex.Lock()
-	defer state.DestShard.mutex.Unlock()
 
 	// Mark as prepared
 	state.Prepared = true
-	return true
+	return nil
+}
+
+// stageCommit checks the block is still where the transfer found it and
+// predicts both roots after the move from the shards as they are now, so
+// blocks added since prepare are accounted for. It reports whether the
+// commitment still matches.
+func (esm *EnhancedSyncManager) stageCommit(state *TransferState) (bool, error) {
+	source, destination := state.SourceShard, state.DestShard
+	unlock := lockShards(source, destination)
+	defer unlock()
+
+	if state.BlockIndex < 0 || state.BlockIndex >= len(source.Blocks) {
+		return false, fmt.Errorf("%w: %d for shard #%d (block count: %d) during commit", ErrIndexOutOfRange, state.BlockIndex, source.ID, len(source.Blocks))
+	}
+	block := source.Blocks[state.BlockIndex]
+	if !esm.verifyTransferState(state, block) {
+		return false, nil
+	}
+
+	// Predict both roots by simulating the block lists after the move
+	var sourceBlocks []Block
+	for i, b := range source.Blocks {
+		if i != state.BlockIndex {
+			sourceBlocks = append(sourceBlocks, b)
+		}
+	}
+	destBlocks := append(append([]Block{}, destination.Blocks...), block)
+	state.ExpectedSource = newBlockTree(sourceBlocks).GetRootHash()
+	state.ExpectedDest = newBlockTree(destBlocks).GetRootHash()
+	state.SourceChain = chainCommitment(sourceBlocks)
+	state.DestChain = chainCommitment(destBlocks)
+	state.DestOldRoot = destination.GetRoot()
+	state.DestOldSize = len(destination.Blocks)
+	return true, nil
+}
+
+// verifyTransferState recomputes a pending transfer's commitment over its
+// shard pair, nonce and sequence number
+func (esm *EnhancedSyncManager) verifyTransferState(state *TransferState, block Block) bool {
+	message := transferMessage(state.SourceShard.ID, state.DestShard.ID, block, state.Nonce, state.Sequence)
+	return esm.authenticator.VerifyAuthentication(message, state.Commitment)
+}
+
+// VerifyAndApplyTransfer completes or rolls back an unkeyed transfer
+func (esm *EnhancedSyncManager) VerifyAndApplyTransfer(source, destination *Shard, blockIndex int) error {
+	return esm.VerifyAndApplyKeyedTransfer("", source, destination, blockIndex)
 }
 
-// VerifyAndApplyTransfer completes or rolls back the transfer
-func (esm *EnhancedSyncManager) VerifyAndApplyTransfer(source, destination *Shard, blockIndex int) bool {
+// VerifyAndApplyKeyedTransfer completes or rolls back the transfer created
+// with key. If the destination has a committee, it must decide the transfer
+// before the block moves. Replaying a finished keyed transfer returns its
+// recorded outcome.
+func (esm *EnhancedSyncManager) VerifyAndApplyKeyedTransfer(key string, source, destination *Shard, blockIndex int) error {
 	esm.mutex.Lock()
 	defer esm.mutex.Unlock()
 
-	transferID := fmt.Sprintf("%d-%d-%d", source.ID, destination.ID, blockIndex)
+	transferID := TransferID(key, source.ID, destination.ID, blockIndex)
+	if key != "" {
+		if err, done := esm.completed.replay(transferID); done {
+			return err
+		}
+	}
 	transferState, exists := esm.pendingTransfers[transferID]
 	if !exists || !transferState.Prepared {
-		fmt.Printf("Transfer %s not found or not prepared\n", transferID)
-		return false
+		return fmt.Errorf("%w: %s", ErrTransferNotPrepared, transferID)
 	}
 
-	// Validate block index
-	if blockIndex < 0 || blockIndex >= len(source.Blocks) {
-		fmt.Printf("Invalid block index %d for Shard #%d (block count: %d) during commit\n", blockIndex, source.ID, len(source.Blocks))
-		return false
+	// Validate block index and commitment
+	valid, err := esm.stageCommit(transferState)
+	if err != nil {
+		return err
 	}
 
 	// Phase 2: Commit or Rollback
-	if esm.authenticator.VerifyAuthentication(
-		fmt.Sprintf("%s:%s", source.Blocks[blockIndex].Hash, source.Blocks[blockIndex].Data),
-		transferState.Commitment) {
-		// Commit: Apply transfer
-		if esm.syncManager.SyncBlock(source, destination, blockIndex) {
+	transferState.record.Phases = append(transferState.record.Phases, PhaseCommit)
+	var commitErr error
+	var reason RollbackReason
+	if valid {
+		// Commit: Apply transfer once the destination committee agrees
+		commitErr = injectFault(esm.faults, FaultBeforeCommit)
+		reason = ReasonInjectedFault
+		if commitErr == nil {
+			transferState.Certificate, commitErr = esm.decideTransfer(destination, transferID, transferState.Commitment)
+			transferState.record.Certificate = transferState.Certificate
+			reason = ReasonCommitteeRejected
+		}
+		if commitErr == nil {
+			commitErr = esm.syncManager.SyncBlock(source, destination, blockIndex)
+			reason = ReasonSyncFailure
+		}
+		if commitErr == nil {
+			commitErr = verifyCommittedRoots(transferState)
+			reason = ReasonRootMismatch
+		}
+		if commitErr == nil {
+			// A commit missing from the log is rolled back on recovery, so
+			// it only counts once logged
+			commitErr = esm.writeWAL(WALRecord{Type: WALCommit, TransferID: transferID})
+			reason = ReasonWALFailure
+		}
+		if commitErr == nil {
 			fmt.Printf("Committed transfer from Shard #%d to #%d\n", source.ID, destination.ID)
+			// The destination only gained a block, so prove its root is an extension
+			if proof, err := destination.Tree.ConsistencyProof(transferState.DestOldSize); err == nil {
+				transferState.DestProof = proof
+			}
+			esm.committedTransfers[transferID] = transferState
 			delete(esm.pendingTransfers, transferID)
-			return true
+			esm.finishBlockRecord(transferState, OutcomeCommitted, "", nil)
+			return nil
+		}
+	} else {
+		commitErr = ErrInvalidCommitment
+		reason = ReasonCommitmentMismatch
+	}
+
+	// Rollback: Undo the block move, if it happened
+	if err := esm.rollbackTransfer(transferState); err != nil {
+		commitErr = fmt.Errorf("%w; rollback: %v", commitErr, err)
+	}
+	fmt.Printf("Rolled back transfer from Shard #%d to #%d\n", source.ID, destination.ID)
+	delete(esm.pendingTransfers, transferID)
+	esm.finishBlockRecord(transferState, OutcomeRolledBack, reason, commitErr)
+	if err := esm.writeWAL(WALRecord{Type: WALAbort, TransferID: transferID}); err != nil {
+		return err
+	}
+	return fmt.Errorf("transfer %s rolled back: %w", transferID, commitErr)
+}
+
+// AbortStaleTransfers rolls back prepared transfers that have waited longer
+// than maxAge for their commit phase, returning how many were aborted
+func (esm *EnhancedSyncManager) AbortStaleTransfers(maxAge time.Duration) int {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+
+	aborted := 0
+	for transferID, state := range esm.pendingTransfers {
+		if time.Since(state.record.StartedAt) <= maxAge {
+			continue
 		}
+		esm.rollbackTransfer(state)
+		delete(esm.pendingTransfers, transferID)
+		esm.writeWAL(WALRecord{Type: WALAbort, TransferID: transferID})
+		state.record.Phases = append(state.record.Phases, PhaseRollback)
+		esm.finishBlockRecord(state, OutcomeAborted, ReasonTimeout, nil)
+		aborted++
 	}
+	return aborted
+}
 
-	// Rollback: Restore snapshots
-	source.Blocks = make([]Block, len(transferState.SourceSnapshot))
-	destination.Blocks = make([]Block, len(transferState.DestSnapshot))
-	copy(source.Blocks, transferState.SourceSnapshot)
-	copy(destination.Blocks, transferState.DestSnapshot)
+// finishBlockRecord journals a block transfer with the shard roots it left behind
+func (esm *EnhancedSyncManager) finishBlockRecord(state *TransferState, outcome TransferOutcome, reason RollbackReason, err error) {
+	record := state.record
+	if outcome == OutcomeRolledBack {
+		record.Phases = append(record.Phases, PhaseRollback)
+	}
+	record.SourceRootAfter = state.SourceShard.GetRoot()
+	record.DestRootAfter = state.DestShard.GetRoot()
+	esm.finishRecord(record, outcome, reason, err)
+	if state.Key != "" {
+		esm.completed.add(record.ID, outcome, err)
+	}
+}
 
-	// Rebuild Merkle trees
-	var sourceData, destData []string
-	for _, b := range source.Blocks {
-		sourceData = append(sourceData, b.Data)
+// verifyCommittedRoots compares both shard roots and chain commitments
+// against the prepared predictions
+func verifyCommittedRoots(state *TransferState) error {
+	if actual := state.SourceShard.GetRoot(); actual != state.ExpectedSource {
+		return &RootMismatchError{ShardID: state.SourceShard.ID, Expected: state.ExpectedSource, Actual: actual}
+	}
+	if actual := state.DestShard.GetRoot(); actual != state.ExpectedDest {
+		return &RootMismatchError{ShardID: state.DestShard.ID, Expected: state.ExpectedDest, Actual: actual}
+	}
+	if actual := state.SourceShard.ChainCommitment(); actual != state.SourceChain {
+		return &RootMismatchError{ShardID: state.SourceShard.ID, Expected: state.SourceChain, Actual: actual, Chain: true}
 	}
-	for _, b := range destination.Blocks {
-		destData = append(destData, b.Data)
+	if actual := state.DestShard.ChainCommitment(); actual != state.DestChain {
+		return &RootMismatchError{ShardID: state.DestShard.ID, Expected: state.DestChain, Actual: actual, Chain: true}
 	}
-	source.Tree = NewMerkleTree(sourceData)
-	destination.Tree = NewMerkleTree(destData)
+	return nil
+}
 
-	fmt.Printf("Rolled back transfer from Shard #%d to #%d\n", source.ID, destination.ID)
-	delete(esm.pendingTransfers, transferID)
-	return false
+// rollbackTransfer moves the transferred block back to its place in the
+// source. Other blocks either shard gained meanwhile are kept.
+func (esm *EnhancedSyncManager) rollbackTransfer(state *TransferState) error {
+	source, destination := state.SourceShard, state.DestShard
+	unlock := lockShards(source, destination)
+	defer unlock()
+	return undoBlockMove(source, destination, state.BlockHash, state.BlockIndex)
 }
@@ -0,0 +1,306 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// duplicatingSyncer moves a block and then appends it to the destination a
+// second time, corrupting the destination's tree
+type duplicatingSyncer struct {
+	syncer *SyncManager
+}
+
+func (d duplicatingSyncer) SyncBlock(source, destination *Shard, blockIndex int) error {
+	block := source.Blocks[blockIndex]
+	if err := d.syncer.SyncBlock(source, destination, blockIndex); err != nil {
+		return err
+	}
+	destination.AddBlock(block)
+	return nil
+}
+
+func TestTransferRollsBackOnRootMismatch(t *testing.T) {
+	sm, source, destination := transferSetup(t)
+	sourceRoot, destRoot := source.GetRoot(), destination.GetRoot()
+	sourceCount, destCount := len(source.Blocks), len(destination.Blocks)
+
+	esm := NewEnhancedSyncManager("key")
+	esm.SetSyncManager(duplicatingSyncer{syncer: NewSyncManager()})
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	err := esm.VerifyAndApplyTransfer(source, destination, 1)
+	var mismatch *RootMismatchError
+	if !errors.Is(err, ErrRootMismatch) || !errors.As(err, &mismatch) {
+		t.Fatalf("duplicating syncer: %v, want a root mismatch", err)
+	}
+	if mismatch.ShardID != destination.ID || mismatch.Expected == mismatch.Actual || mismatch.Actual == "" {
+		t.Fatalf("mismatch reported on shard #%d, expected %s, actual %s", mismatch.ShardID, mismatch.Expected, mismatch.Actual)
+	}
+
+	if source.GetRoot() != sourceRoot || destination.GetRoot() != destRoot {
+		t.Fatal("shards not rolled back")
+	}
+	if len(source.Blocks) != sourceCount || len(destination.Blocks) != destCount {
+		t.Fatalf("shards hold %d and %d blocks after rollback, want %d and %d", len(source.Blocks), len(destination.Blocks), sourceCount, destCount)
+	}
+	if errs := sm.VerifyAll(1); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if _, ok := esm.GetCommittedTransfer(TransferID("", source.ID, destination.ID, 1)); ok {
+		t.Fatal("rolled back transfer recorded as committed")
+	}
+	journal := esm.Journal()
+	if len(journal) != 1 || journal[0].Outcome == OutcomeCommitted || journal[0].Reason != ReasonRootMismatch {
+		t.Fatalf("journal %+v, want one rollback for a root mismatch", journal)
+	}
+}
+
+func TestTransferPredictsPostCommitRoots(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	state, ok := esm.GetCommittedTransfer(TransferID("", source.ID, destination.ID, 1))
+	if !ok {
+		t.Fatal("transfer not committed")
+	}
+	if state.ExpectedSource != source.GetRoot() || state.ExpectedDest != destination.GetRoot() {
+		t.Fatal("predicted roots differ from the committed ones")
+	}
+}
+
+func TestTransferKeepsBlocksAddedBetweenPhases(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	moved := source.Blocks[1]
+	extra := fixedBlocks(3)
+	esm := NewEnhancedSyncManager("key")
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	destination.AddBlock(extra[0])
+	source.AddBlock(extra[1])
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatalf("transfer after both shards grew: %v", err)
+	}
+	if !destination.containsBlock(extra[0].Hash) || !destination.containsBlock(moved.Hash) || !source.containsBlock(extra[1].Hash) {
+		t.Fatal("blocks added between prepare and commit were lost")
+	}
+}
+
+// appendingSyncer adds a block to the destination just before moving one,
+// as a writer racing the commit would
+type appendingSyncer struct {
+	syncer *SyncManager
+	block  Block
+}
+
+func (a appendingSyncer) SyncBlock(source, destination *Shard, blockIndex int) error {
+	destination.AddBlock(a.block)
+	return a.syncer.SyncBlock(source, destination, blockIndex)
+}
+
+func TestRollbackKeepsBlocksAddedDuringCommit(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	sourceRoot := source.GetRoot()
+	sourceCount, destCount := len(source.Blocks), len(destination.Blocks)
+	extra := fixedBlocks(1)[0]
+
+	esm := NewEnhancedSyncManager("key")
+	esm.SetSyncManager(appendingSyncer{syncer: NewSyncManager(), block: extra})
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("block added during commit: %v, want a root mismatch", err)
+	}
+
+	// Only the move is undone; the racing block stays where it was added
+	if source.GetRoot() != sourceRoot || len(source.Blocks) != sourceCount {
+		t.Fatal("source not restored")
+	}
+	if len(destination.Blocks) != destCount+1 || destination.Blocks[destCount].Hash != extra.Hash {
+		t.Fatalf("destination holds %d blocks after rollback, want %d ending with the racing one", len(destination.Blocks), destCount+1)
+	}
+	if root := newBlockTree(destination.Blocks).GetRootHash(); root != destination.GetRoot() {
+		t.Fatal("destination root does not match its blocks")
+	}
+}
+
+func TestAuthenticatorRotationKeepsOldCommitments(t *testing.T) {
+	auth, err := NewHomomorphicAuthenticatorWithKeyring(map[string][]byte{"2023": []byte("old")}, "2023")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var era1, era2 []string
+	for _, data := range testLeaves(10) {
+		era1 = append(era1, auth.AuthenticateData(data))
+	}
+	auth.RotateKey("2024", []byte("new"))
+	if auth.ActiveKeyID() != "2024" {
+		t.Fatalf("active key %q after rotation", auth.ActiveKeyID())
+	}
+	for _, data := range testLeaves(10) {
+		era2 = append(era2, auth.AuthenticateData(data))
+	}
+
+	for i, data := range testLeaves(10) {
+		if !strings.HasPrefix(era1[i], "2023:") || !strings.HasPrefix(era2[i], "2024:") {
+			t.Fatalf("commitments %q and %q do not name their keys", era1[i], era2[i])
+		}
+		if !auth.VerifyAuthentication(data, era1[i]) || !auth.VerifyAuthentication(data, era2[i]) {
+			t.Fatalf("commitment to %q from one era rejected", data)
+		}
+		if auth.VerifyAuthentication(data+"x", era1[i]) {
+			t.Fatal("commitment verified for other data")
+		}
+
+		// Naming the other era's key does not verify the digest
+		relabelled := "2024:" + strings.TrimPrefix(era1[i], "2023:")
+		if auth.VerifyAuthentication(data, relabelled) || auth.VerifyAuthentication(data, "2022:"+strings.TrimPrefix(era1[i], "2023:")) {
+			t.Fatal("commitment verified under another key ID")
+		}
+	}
+
+	legacy := NewHomomorphicAuthenticator("key")
+	bare := strings.TrimPrefix(legacy.AuthenticateData("data"), DefaultKeyID+":")
+	if !legacy.VerifyAuthentication("data", bare) {
+		t.Fatal("commitment without a key ID rejected by the default key")
+	}
+	if _, err := NewHomomorphicAuthenticatorWithKeyring(map[string][]byte{"a": []byte("k")}, "b"); err == nil {
+		t.Fatal("keyring without its active key accepted")
+	}
+}
+
+func TestSyncManagerRotationMidStream(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	auth, err := NewHomomorphicAuthenticatorWithKeyring(map[string][]byte{"k1": []byte("first")}, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	esm := NewEnhancedSyncManagerWithKeyring(auth)
+	moved := make(map[string]Block)
+	transfer := func() {
+		block := source.Blocks[0]
+		if err := esm.CreateAuthenticatedTransfer(source, destination, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := esm.VerifyAndApplyTransfer(source, destination, 0); err != nil {
+			t.Fatal(err)
+		}
+		moved[block.Hash] = block
+	}
+	transfer()
+	auth.RotateKey("k2", []byte("second"))
+	transfer()
+
+	records := esm.Journal()
+	if len(records) != 2 {
+		t.Fatalf("%d journal records, want 2", len(records))
+	}
+	stranger := NewEnhancedSyncManagerWithKeyring(NewHomomorphicAuthenticator("second"))
+	for i, record := range records {
+		if want := []string{"k1:", "k2:"}[i]; !strings.HasPrefix(record.Commitment, want) {
+			t.Fatalf("transfer %d committed under %q", i, record.Commitment)
+		}
+		if !esm.VerifyTransferCommitment(record, moved[record.BlockHash]) {
+			t.Fatalf("transfer %d's commitment rejected after rotation", i)
+		}
+		if stranger.VerifyTransferCommitment(record, moved[record.BlockHash]) {
+			t.Fatalf("transfer %d verified without its key ID in the ring", i)
+		}
+	}
+}
+
+func TestStatePrunerRotationMidStream(t *testing.T) {
+	auth := NewHomomorphicAuthenticator("first")
+	pruner := NewStatePrunerWithKeyring(1, 2, false, auth)
+	bc := NewBlockchain()
+	var proofs []IntegrityProof
+	for era := 0; era < 2; era++ {
+		for _, data := range testLeaves(4) {
+			if err := bc.AddBlock(fmt.Sprintf("%d-%s", era, data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if pruner.PruneBlockchain(bc) == 0 {
+			t.Fatalf("era %d pruned nothing", era)
+		}
+		proofs = append(proofs, *pruner.GetLatestProof())
+		auth.RotateKey("rotated", []byte("second"))
+	}
+	if !strings.HasPrefix(proofs[0].Signature, DefaultKeyID+":") || !strings.HasPrefix(proofs[1].Signature, "rotated:") {
+		t.Fatalf("proofs signed under %q and %q", proofs[0].Signature, proofs[1].Signature)
+	}
+	for i, proof := range proofs {
+		if !pruner.VerifyIntegrity(proof) {
+			t.Fatalf("proof %d rejected after rotation", i)
+		}
+	}
+	if !VerifyIntegrityProofHMAC(proofs[0], "first") || VerifyIntegrityProofHMAC(proofs[1], "first") {
+		t.Fatal("proofs verified against the wrong single key")
+	}
+}
+
+func TestCapturedCommitmentRejectedForOtherShards(t *testing.T) {
+	sm, source, destination := transferSetup(t)
+	third := sm.Shards.GetAllShards()[2]
+	esm := NewEnhancedSyncManager("key")
+	block := source.Blocks[1]
+
+	// Capture the commitment of a transfer to the destination
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	captured := esm.Journal()[0]
+	if len(captured.Nonce) != 32 || captured.Sequence != 1 || !esm.VerifyTransferCommitment(captured, block) {
+		t.Fatalf("journaled nonce %q, sequence %d", captured.Nonce, captured.Sequence)
+	}
+
+	// It verifies for no other shard pair, nonce or sequence number
+	for name, alter := range map[string]func(*TransferRecord){
+		"destination": func(r *TransferRecord) { r.DestID = third.ID },
+		"source":      func(r *TransferRecord) { r.SourceID = third.ID },
+		"reversed":    func(r *TransferRecord) { r.SourceID, r.DestID = r.DestID, r.SourceID },
+		"nonce":       func(r *TransferRecord) { r.Nonce = strings.Repeat("0", 32) },
+		"sequence":    func(r *TransferRecord) { r.Sequence++ },
+	} {
+		replayed := captured
+		alter(&replayed)
+		if esm.VerifyTransferCommitment(replayed, block) {
+			t.Errorf("commitment replayed with another %s verified", name)
+		}
+	}
+
+	// Replaying it into a pending transfer to the third shard rolls back
+	moved := source.Blocks[0]
+	if err := esm.CreateAuthenticatedTransfer(source, third, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range esm.pendingTransfers {
+		state.Commitment = captured.Commitment
+	}
+	roots := [2]string{source.GetRoot(), third.GetRoot()}
+	if err := esm.VerifyAndApplyTransfer(source, third, 0); !errors.Is(err, ErrInvalidCommitment) {
+		t.Fatalf("replayed commitment: %v", err)
+	}
+	if source.GetRoot() != roots[0] || third.GetRoot() != roots[1] || third.containsBlock(moved.Hash) {
+		t.Fatal("replayed commitment moved a block")
+	}
+
+	// Each transfer draws a fresh nonce and the next sequence number
+	records := esm.Journal()
+	if records[1].Sequence != 2 || records[1].Nonce == captured.Nonce {
+		t.Fatalf("second transfer has sequence %d and nonce %s", records[1].Sequence, records[1].Nonce)
+	}
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// DefaultCompletedCapacity is the number of finished keyed transfers whose
+// outcomes are remembered for replays
+const DefaultCompletedCapacity = 1024
+
+// TransferID names a transfer. Keyed IDs are remembered once finished so a
+// retried Create or Verify with the same key returns the recorded outcome;
+// unkeyed IDs are reused by any later transfer of the same index.
+func TransferID(key string, sourceID, destID, blockIndex int) string {
+	id := fmt.Sprintf("%d-%d-%d", sourceID, destID, blockIndex)
+	if key == "" {
+		return id
+	}
+	return key + "/" + id
+}
+
+// completedTransfer is the recorded outcome of a finished transfer
+type completedTransfer struct {
+	id      string
+	outcome TransferOutcome
+	err     error
+}
+
+// completedTransfers is an LRU of finished transfer outcomes
+type completedTransfers struct {
+	capacity int
+	order    *list.List // Most recently used first
+	entries  map[string]*list.Element
+	mutex    sync.Mutex
+}
+
+func newCompletedTransfers(capacity int) *completedTransfers {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &completedTransfers{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// add records an outcome, evicting the least recently used when full
+func (c *completedTransfers) add(id string, outcome TransferOutcome, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exists := c.entries[id]; exists {
+		elem.Value = completedTransfer{id: id, outcome: outcome, err: err}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[id] = c.order.PushFront(completedTransfer{id: id, outcome: outcome, err: err})
+	c.evict()
+}
+
+// lookup returns a recorded outcome and marks it recently used
+func (c *completedTransfers) lookup(id string) (completedTransfer, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exists := c.entries[id]
+	if !exists {
+		return completedTransfer{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(completedTransfer), true
+}
+
+// resize changes the capacity, evicting the least recently used
+func (c *completedTransfers) resize(capacity int) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.capacity = capacity
+	c.evict()
+}
+
+// evict drops entries beyond capacity; the caller must hold the mutex
+func (c *completedTransfers) evict() {
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(completedTransfer).id)
+	}
+}
+
+// replay returns the recorded result of a finished transfer: nil if it
+// committed, otherwise the error it failed with
+func (c *completedTransfers) replay(id string) (error, bool) {
+	done, exists := c.lookup(id)
+	if !exists {
+		return nil, false
+	}
+	if done.outcome == OutcomeCommitted {
+		return nil, true
+	}
+	if done.err != nil {
+		return fmt.Errorf("transfer %s already %s: %w", id, done.outcome, done.err), true
+	}
+	return fmt.Errorf("%w: %s %s", ErrTransferFailed, id, done.outcome), true
+}
+
+// CompletedTransfer returns the recorded outcome of a finished keyed transfer
+func (esm *EnhancedSyncManager) CompletedTransfer(transferID string) (TransferOutcome, bool) {
+	done, exists := esm.completed.lookup(transferID)
+	return done.outcome, exists
+}
+
+// SetCompletedCapacity bounds how many finished keyed transfers are
+// remembered, evicting the least recently used
+func (esm *EnhancedSyncManager) SetCompletedCapacity(capacity int) {
+	esm.completed.resize(capacity)
+}
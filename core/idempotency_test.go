@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// keyedTransfer creates and commits a keyed transfer of block 1
+func keyedTransfer(t *testing.T, esm *EnhancedSyncManager, key string, source, destination *Shard) {
+	t.Helper()
+	if err := esm.CreateKeyedTransfer(key, source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyKeyedTransfer(key, source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkUnchanged fails if either shard's root or size moved from before
+func checkUnchanged(t *testing.T, before [2]string, counts [2]int, source, destination *Shard) {
+	t.Helper()
+	if source.GetRoot() != before[0] || destination.GetRoot() != before[1] {
+		t.Fatal("replay changed shard roots")
+	}
+	if len(source.Blocks) != counts[0] || len(destination.Blocks) != counts[1] {
+		t.Fatalf("replay left %d and %d blocks, want %d and %d", len(source.Blocks), len(destination.Blocks), counts[0], counts[1])
+	}
+}
+
+func TestKeyedTransferDoubleCommit(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	keyedTransfer(t, esm, "retry-1", source, destination)
+	roots, counts := [2]string{source.GetRoot(), destination.GetRoot()}, [2]int{len(source.Blocks), len(destination.Blocks)}
+
+	if err := esm.VerifyAndApplyKeyedTransfer("retry-1", source, destination, 1); err != nil {
+		t.Fatalf("replayed commit: %v", err)
+	}
+	checkUnchanged(t, roots, counts, source, destination)
+	if outcome, ok := esm.CompletedTransfer(TransferID("retry-1", source.ID, destination.ID, 1)); !ok || outcome != OutcomeCommitted {
+		t.Fatalf("recorded outcome %v, %v", outcome, ok)
+	}
+
+	// Unkeyed transfers are not remembered
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); !errors.Is(err, ErrTransferNotPrepared) {
+		t.Fatalf("unkeyed commit without a transfer: %v", err)
+	}
+}
+
+func TestKeyedTransferDoubleCreate(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+
+	// A retry while the transfer is prepared does not prepare it twice
+	if err := esm.CreateKeyedTransfer("retry-2", source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.CreateKeyedTransfer("retry-2", source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyKeyedTransfer("retry-2", source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	roots, counts := [2]string{source.GetRoot(), destination.GetRoot()}, [2]int{len(source.Blocks), len(destination.Blocks)}
+
+	// Create and commit retried after completion move nothing
+	if err := esm.CreateKeyedTransfer("retry-2", source, destination, 1); err != nil {
+		t.Fatalf("replayed create: %v", err)
+	}
+	if err := esm.VerifyAndApplyKeyedTransfer("retry-2", source, destination, 1); err != nil {
+		t.Fatalf("replayed commit: %v", err)
+	}
+	checkUnchanged(t, roots, counts, source, destination)
+
+	// A new key is a new transfer
+	if err := esm.CreateKeyedTransfer("retry-3", source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyKeyedTransfer("retry-3", source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(destination.Blocks) != counts[1]+1 {
+		t.Fatalf("new key moved %d blocks", len(destination.Blocks)-counts[1])
+	}
+}
+
+func TestKeyedTransferReplaysFailure(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	esm.SetFaultInjector(FailNth(FaultBeforeCommit, 1))
+	if err := esm.CreateKeyedTransfer("failing", source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyKeyedTransfer("failing", source, destination, 1); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("injected failure: %v", err)
+	}
+	roots, counts := [2]string{source.GetRoot(), destination.GetRoot()}, [2]int{len(source.Blocks), len(destination.Blocks)}
+
+	// The fault has passed, but the retry still reports the recorded failure
+	for _, retry := range []func() error{
+		func() error { return esm.CreateKeyedTransfer("failing", source, destination, 1) },
+		func() error { return esm.VerifyAndApplyKeyedTransfer("failing", source, destination, 1) },
+	} {
+		if err := retry(); !errors.Is(err, ErrInjectedFault) {
+			t.Fatalf("replayed failure: %v", err)
+		}
+	}
+	checkUnchanged(t, roots, counts, source, destination)
+}
+
+func TestKeyedTransferReplayAfterRestart(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	var log bytes.Buffer
+	esm := NewEnhancedSyncManager("key")
+	esm.SetWAL(&log)
+	keyedTransfer(t, esm, "before-crash", source, destination)
+	if err := esm.CreateKeyedTransfer("in-flight", source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+	roots, counts := [2]string{source.GetRoot(), destination.GetRoot()}, [2]int{len(source.Blocks), len(destination.Blocks)}
+
+	restarted := NewEnhancedSyncManager("key")
+	loaded, err := restarted.LoadCompletedTransfers(bytes.NewReader(log.Bytes()))
+	if err != nil || loaded != 2 {
+		t.Fatalf("loaded %d transfers: %v", loaded, err)
+	}
+	if err := restarted.CreateKeyedTransfer("before-crash", source, destination, 1); err != nil {
+		t.Fatalf("create replayed after restart: %v", err)
+	}
+	if err := restarted.VerifyAndApplyKeyedTransfer("before-crash", source, destination, 1); err != nil {
+		t.Fatalf("commit replayed after restart: %v", err)
+	}
+	// Recovery rolls back transfers left pending, so they replay as aborted
+	if err := restarted.VerifyAndApplyKeyedTransfer("in-flight", source, destination, 0); !errors.Is(err, ErrTransferFailed) {
+		t.Fatalf("pending transfer replayed after restart: %v", err)
+	}
+	checkUnchanged(t, roots, counts, source, destination)
+}
+
+func TestCompletedTransfersEvictLeastRecentlyUsed(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	esm.SetCompletedCapacity(2)
+	for _, key := range []string{"a", "b", "c"} {
+		esm.completed.add(TransferID(key, source.ID, destination.ID, 1), OutcomeCommitted, nil)
+		if key == "b" {
+			esm.CompletedTransfer(TransferID("a", source.ID, destination.ID, 1))
+		}
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := esm.CompletedTransfer(TransferID(key, source.ID, destination.ID, 1)); ok != want {
+			t.Fatalf("transfer %q remembered: %v", key, ok)
+		}
+	}
+}
+
+func TestSyncBlockRefusesDuplicate(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	destination.AddBlock(source.Blocks[0])
+	root, count := destination.GetRoot(), len(destination.Blocks)
+	sourceCount := len(source.Blocks)
+
+	if err := NewSyncManager().SyncBlock(source, destination, 0); !errors.Is(err, ErrDuplicateBlock) {
+		t.Fatalf("syncing a block the destination holds: %v", err)
+	}
+	if destination.GetRoot() != root || len(destination.Blocks) != count || len(source.Blocks) != sourceCount {
+		t.Fatal("refused sync changed the shards")
+	}
+}
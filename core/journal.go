@@ -0,0 +1,165 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultJournalCapacity is the number of transfer records kept by default
+const DefaultJournalCapacity = 256
+
+// TransferPhase is a step a transfer reached
+type TransferPhase string
+
+const (
+	PhasePrepare  TransferPhase = "prepare"
+	PhasePrepared TransferPhase = "prepared"
+	PhaseCommit   TransferPhase = "commit"
+	PhaseRollback TransferPhase = "rollback"
+)
+
+// TransferOutcome is how a transfer ended
+type TransferOutcome string
+
+const (
+	OutcomeCommitted  TransferOutcome = "committed"
+	OutcomeRolledBack TransferOutcome = "rolled-back"
+	OutcomeAborted    TransferOutcome = "aborted"
+)
+
+// RollbackReason explains why a transfer did not commit
+type RollbackReason string
+
+const (
+	ReasonCommitmentMismatch RollbackReason = "commitment mismatch"
+	ReasonSyncFailure        RollbackReason = "sync failure"
+	ReasonRootMismatch       RollbackReason = "root mismatch"
+	ReasonPrepareFailed      RollbackReason = "prepare failed"
+	ReasonCreditFailed       RollbackReason = "credit failed"
+	ReasonTimeout            RollbackReason = "timeout"
+	ReasonRangeProofFailed   RollbackReason = "range proof failed"
+	ReasonCommitteeRejected  RollbackReason = "committee rejected"
+	ReasonWALFailure         RollbackReason = "wal failure"
+	ReasonInjectedFault      RollbackReason = "injected fault"
+)
+
+// TransferRecord is the audit trail of one transfer attempt. Roots are shard
+// Merkle roots for block transfers and ledger state roots for value transfers.
+type TransferRecord struct {
+	ID               string             `json:"id"`
+	Kind             string             `json:"kind"` // "block" or "value"
+	StartedAt        time.Time          `json:"started_at"`
+	FinishedAt       time.Time          `json:"finished_at"`
+	SourceID         int                `json:"source_id"`
+	DestID           int                `json:"dest_id"`
+	BlockIndex       int                `json:"block_index,omitempty"`
+	BlockHash        string             `json:"block_hash,omitempty"`
+	TxHash           string             `json:"tx_hash,omitempty"`
+	Nonce            string             `json:"nonce,omitempty"`    // Random nonce bound into Commitment
+	Sequence         uint64             `json:"sequence,omitempty"` // Manager sequence number bound into Commitment
+	Commitment       string             `json:"commitment,omitempty"`
+	AmountCommitment string             `json:"amount_commitment,omitempty"` // Pedersen commitment to a value transfer's amount
+	Phases           []TransferPhase    `json:"phases"`
+	Certificate      *CommitCertificate `json:"certificate,omitempty"` // Destination committee's commit decision
+	SourceRootBefore string             `json:"source_root_before"`
+	SourceRootAfter  string             `json:"source_root_after"`
+	DestRootBefore   string             `json:"dest_root_before"`
+	DestRootAfter    string             `json:"dest_root_after"`
+	Outcome          TransferOutcome    `json:"outcome"`
+	Reason           RollbackReason     `json:"reason,omitempty"`
+	Error            string             `json:"error,omitempty"`
+}
+
+// TransferJournal keeps the most recent transfer records in a ring buffer
+type TransferJournal struct {
+	records []TransferRecord
+	start   int // Index of the oldest record
+	count   int
+	mutex   sync.RWMutex
+}
+
+// NewTransferJournal creates a journal holding at most capacity records
+func NewTransferJournal(capacity int) *TransferJournal {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TransferJournal{records: make([]TransferRecord, capacity)}
+}
+
+// Append adds a record, overwriting the oldest when full
+func (j *TransferJournal) Append(record TransferRecord) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	record.Phases = append([]TransferPhase{}, record.Phases...)
+	if j.count < len(j.records) {
+		j.records[(j.start+j.count)%len(j.records)] = record
+		j.count++
+		return
+	}
+	j.records[j.start] = record
+	j.start = (j.start + 1) % len(j.records)
+}
+
+// Resize changes the capacity, keeping the most recent records
+func (j *TransferJournal) Resize(capacity int) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	keep := j.count
+	if keep > capacity {
+		keep = capacity
+	}
+	records := make([]TransferRecord, capacity)
+	for i := 0; i < keep; i++ {
+		records[i] = j.records[(j.start+j.count-keep+i)%len(j.records)]
+	}
+	j.records, j.start, j.count = records, 0, keep
+}
+
+// Records returns a copy of the journal, oldest first
+func (j *TransferJournal) Records() []TransferRecord {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	records := make([]TransferRecord, j.count)
+	for i := range records {
+		records[i] = j.records[(j.start+i)%len(j.records)]
+	}
+	return records
+}
+
+// ExportJSON writes the journal as a JSON array, oldest first
+func (j *TransferJournal) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(j.Records())
+}
+
+// finishRecord stamps the outcome of a transfer and journals it
+func (esm *EnhancedSyncManager) finishRecord(record *TransferRecord, outcome TransferOutcome, reason RollbackReason, err error) {
+	record.FinishedAt = time.Now()
+	record.Outcome = outcome
+	record.Reason = reason
+	if err != nil {
+		record.Error = err.Error()
+	}
+	esm.journal.Append(*record)
+}
+
+// Journal returns the recorded transfer attempts, oldest first
+func (esm *EnhancedSyncManager) Journal() []TransferRecord {
+	return esm.journal.Records()
+}
+
+// ExportJournal writes the transfer journal as JSON
+func (esm *EnhancedSyncManager) ExportJournal(w io.Writer) error {
+	return esm.journal.ExportJSON(w)
+}
+
+// SetJournalCapacity resizes the journal, keeping the most recent records
+func (esm *EnhancedSyncManager) SetJournalCapacity(capacity int) {
+	esm.journal.Resize(capacity)
+}
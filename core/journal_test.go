@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournalRecordsWrongKeyRollback(t *testing.T) {
+	// The "wrong-key" scenario of cmd/main.go: the key changes between
+	// prepare and commit, so the commitment no longer verifies
+	_, source, destination := transferSetup(t)
+	sourceRoot, destRoot := source.GetRoot(), destination.GetRoot()
+	keyring := NewHomomorphicAuthenticator("secret-key-123")
+	esm := NewEnhancedSyncManagerWithKeyring(keyring)
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+	keyring.RotateKey(DefaultKeyID, []byte("wrong-key"))
+	if err := esm.VerifyAndApplyTransfer(source, destination, 0); err == nil {
+		t.Fatal("transfer committed under the wrong key")
+	}
+
+	records := esm.Journal()
+	if len(records) != 1 {
+		t.Fatalf("%d journal records, want 1", len(records))
+	}
+	record := records[0]
+	if record.Outcome != OutcomeRolledBack || record.Reason != ReasonCommitmentMismatch || record.Error == "" {
+		t.Fatalf("journaled %s (%s): %q", record.Outcome, record.Reason, record.Error)
+	}
+	if len(record.Phases) == 0 || record.Phases[0] != PhasePrepare || record.Phases[len(record.Phases)-1] != PhaseRollback {
+		t.Fatalf("phases %v", record.Phases)
+	}
+	if record.SourceRootBefore != sourceRoot || record.SourceRootAfter != sourceRoot ||
+		record.DestRootBefore != destRoot || record.DestRootAfter != destRoot {
+		t.Fatal("roots before and after the rollback differ")
+	}
+	if source.GetRoot() != sourceRoot || destination.GetRoot() != destRoot {
+		t.Fatal("shards changed by a rolled-back transfer")
+	}
+	if record.Commitment == "" || record.StartedAt.IsZero() || record.FinishedAt.Before(record.StartedAt) {
+		t.Fatalf("record missing its commitment or timestamps: %+v", record)
+	}
+
+	var buf bytes.Buffer
+	if err := esm.ExportJournal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var exported []TransferRecord
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || exported[0].ID != record.ID || exported[0].Reason != ReasonCommitmentMismatch {
+		t.Fatalf("exported %+v", exported)
+	}
+}
+
+func TestJournalRecordsTimeoutAbort(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if aborted := esm.AbortStaleTransfers(0); aborted != 1 {
+		t.Fatalf("aborted %d transfers", aborted)
+	}
+	records := esm.Journal()
+	if len(records) != 1 || records[0].Outcome != OutcomeAborted || records[0].Reason != ReasonTimeout {
+		t.Fatalf("journaled %+v", records)
+	}
+}
+
+func TestJournalIsBounded(t *testing.T) {
+	j := NewTransferJournal(3)
+	for i := 0; i < 5; i++ {
+		j.Append(TransferRecord{ID: fmt.Sprint(i)})
+	}
+	ids := func() string {
+		var s string
+		for _, r := range j.Records() {
+			s += r.ID
+		}
+		return s
+	}
+	if got := ids(); got != "234" {
+		t.Fatalf("journal holds %q, want the newest three", got)
+	}
+	j.Resize(2)
+	if got := ids(); got != "34" {
+		t.Fatalf("shrunk journal holds %q", got)
+	}
+	j.Resize(4)
+	j.Append(TransferRecord{ID: "5"})
+	if got := ids(); got != "345" {
+		t.Fatalf("grown journal holds %q", got)
+	}
+	if got := len(NewTransferJournal(0).records); got != 1 {
+		t.Fatalf("zero capacity gave %d slots", got)
+	}
+}
+
+func TestJournalReadableDuringTransfers(t *testing.T) {
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	esm.SetJournalCapacity(2)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, record := range esm.Journal() {
+				if record.Outcome == "" {
+					t.Error("journal exposed an unfinished record")
+				}
+			}
+			esm.ExportJournal(new(bytes.Buffer))
+		}
+	}()
+	for len(source.Blocks) > 0 {
+		if err := esm.CreateAuthenticatedTransfer(source, destination, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := esm.VerifyAndApplyTransfer(source, destination, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	if got := len(esm.Journal()); got != 2 {
+		t.Fatalf("journal holds %d records, capacity 2", got)
+	}
+}
@@ -0,0 +1,526 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Trie is the key-value interface shared by SuccinctTrie and LazyTrie
+type Trie interface {
+	Insert(key, value string)
+	Get(key string) (string, bool)
+	GetMerkleRoot() string
+	Iterate(fn func(key, value string) bool)
+	Keys() []string
+	Prove(key string) (TrieProof, error)
+	Dump() TrieDump
+	PrintTrie(w io.Writer)
+}
+
+// NodeStore holds encoded trie nodes keyed by their hash
+type NodeStore interface {
+	GetNode(hash string) ([]byte, error)
+	PutNode(hash string, data []byte) error
+	Retain(live map[string]bool) (int, error) // Drops nodes not in live, returning how many
+}
+
+// MemoryNodeStore keeps encoded nodes in a map
+type MemoryNodeStore struct {
+	nodes map[string][]byte
+	mutex sync.RWMutex
+}
+
+// NewMemoryNodeStore creates an empty in-memory node store
+func NewMemoryNodeStore() *MemoryNodeStore {
+	return &MemoryNodeStore{nodes: make(map[string][]byte)}
+}
+
+// GetNode returns the encoded node with the given hash
+func (ms *MemoryNodeStore) GetNode(hash string) ([]byte, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	data, exists := ms.nodes[hash]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, hash)
+	}
+	return data, nil
+}
+
+// PutNode stores an encoded node
+func (ms *MemoryNodeStore) PutNode(hash string, data []byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.nodes[hash] = append([]byte{}, data...)
+	return nil
+}
+
+// Retain drops every node whose hash is not in live
+func (ms *MemoryNodeStore) Retain(live map[string]bool) (int, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	dropped := 0
+	for hash := range ms.nodes {
+		if !live[hash] {
+			delete(ms.nodes, hash)
+			dropped++
+		}
+	}
+	return dropped, nil
+}
+
+// FileNodeStore appends encoded nodes to a single file and keeps only the
+// offset of each node in memory
+type FileNodeStore struct {
+	path    string
+	file    *os.File
+	hashFn  Hasher           // Hash indexing the records, matching the trie's
+	offsets map[string]int64 // Offset of each record's payload
+	sizes   map[string]int
+	end     int64
+	mutex   sync.Mutex
+}
+
+// OpenFileNodeStore opens or creates a node file, indexing existing records
+// by their hash under the function opts select, SHA-256 by default. A torn
+// record at the end of the file is ignored and overwritten.
+func OpenFileNodeStore(path string, opts ...TrieOption) (*FileNodeStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fs := &FileNodeStore{
+		path:    path,
+		file:    file,
+		hashFn:  optionsHasher(opts),
+		offsets: make(map[string]int64),
+		sizes:   make(map[string]int),
+	}
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			break
+		}
+		header := int64(len(binary.AppendUvarint(nil, size)))
+		if size > uint64(info.Size()-offset-header) {
+			break
+		}
+		record, err := readRecord(reader, size)
+		if err != nil {
+			break
+		}
+		hash := hexSum(fs.hashFn, record)
+		fs.offsets[hash] = offset + header
+		fs.sizes[hash] = int(size)
+		offset += header + int64(size)
+	}
+	fs.end = offset
+	return fs, nil
+}
+
+// GetNode reads the encoded node with the given hash from disk
+func (fs *FileNodeStore) GetNode(hash string) ([]byte, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	offset, exists := fs.offsets[hash]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, hash)
+	}
+	data := make([]byte, fs.sizes[hash])
+	if _, err := fs.file.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// PutNode appends an encoded node unless it is already stored
+func (fs *FileNodeStore) PutNode(hash string, data []byte) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if _, exists := fs.offsets[hash]; exists {
+		return nil
+	}
+	record := append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+	if _, err := fs.file.WriteAt(record, fs.end); err != nil {
+		return err
+	}
+	fs.offsets[hash] = fs.end + int64(len(record)-len(data))
+	fs.sizes[hash] = len(data)
+	fs.end += int64(len(record))
+	return nil
+}
+
+// Retain drops every node whose hash is not in live, rewriting the file
+// with the remaining records
+func (fs *FileNodeStore) Retain(live map[string]bool) (int, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	hashes := make([]string, 0, len(fs.offsets))
+	for hash := range fs.offsets {
+		if live[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	dropped := len(fs.offsets) - len(hashes)
+	if dropped == 0 {
+		return 0, nil
+	}
+	sort.Strings(hashes)
+
+	tmpPath := fs.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	offsets := make(map[string]int64, len(hashes))
+	sizes := make(map[string]int, len(hashes))
+	var end int64
+	err = func() error {
+		w := bufio.NewWriter(tmp)
+		for _, hash := range hashes {
+			data := make([]byte, fs.sizes[hash])
+			if _, err := fs.file.ReadAt(data, fs.offsets[hash]); err != nil {
+				return err
+			}
+			header := binary.AppendUvarint(nil, uint64(len(data)))
+			if _, err := w.Write(append(header, data...)); err != nil {
+				return err
+			}
+			offsets[hash] = end + int64(len(header))
+			sizes[hash] = len(data)
+			end += int64(len(header) + len(data))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := tmp.Sync(); err != nil {
+			return err
+		}
+		return os.Rename(tmpPath, fs.path)
+	}()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	fs.file.Close()
+	fs.file, fs.offsets, fs.sizes, fs.end = tmp, offsets, sizes, end
+	return dropped, nil
+}
+
+// Close releases the underlying file
+func (fs *FileNodeStore) Close() error {
+	return fs.file.Close()
+}
+
+// LazyTrie is a SuccinctTrie whose nodes live in a NodeStore. Only the root
+// hash is kept in memory; nodes are loaded on demand and updates write new
+// nodes copy-on-write, leaving the replaced ones for Reclaim. Store failures
+// make Get report a missing key and are remembered for Err.
+type LazyTrie struct {
+	store  NodeStore
+	root   string
+	hashFn Hasher
+	err    error
+	mutex  sync.Mutex
+}
+
+// lazyNode is a decoded node; children are sorted by first label byte
+type lazyNode struct {
+	value    string
+	children []TrieProofChild
+}
+
+// optionsHasher returns the hash function opts select, SHA-256 by default
+func optionsHasher(opts []TrieOption) Hasher {
+	st := &SuccinctTrie{}
+	for _, opt := range opts {
+		opt(st)
+	}
+	return st.hasher()
+}
+
+// NewLazyTrie creates an empty trie backed by store, hashing nodes with the
+// function opts select
+func NewLazyTrie(store NodeStore, opts ...TrieOption) (*LazyTrie, error) {
+	lt := &LazyTrie{store: store, hashFn: optionsHasher(opts)}
+	root, err := lt.save(lazyNode{})
+	if err != nil {
+		return nil, err
+	}
+	lt.root = root
+	return lt, nil
+}
+
+// OpenLazyTrie opens a trie previously written to store with the given
+// root, hashed with the function opts select
+func OpenLazyTrie(store NodeStore, root string, opts ...TrieOption) (*LazyTrie, error) {
+	lt := &LazyTrie{store: store, root: root, hashFn: optionsHasher(opts)}
+	if _, err := lt.load(root); err != nil {
+		return nil, err
+	}
+	return lt, nil
+}
+
+// load fetches and verifies a node from the store
+func (lt *LazyTrie) load(hash string) (lazyNode, error) {
+	data, err := lt.store.GetNode(hash)
+	if err != nil {
+		return lazyNode{}, err
+	}
+	if hexSum(lt.hashFn, data) != hash {
+		return lazyNode{}, fmt.Errorf("%w: %s does not match its hash", ErrCorruptNode, hash)
+	}
+	value, children, err := decodeTrieNode(data, hashSize(lt.hashFn))
+	return lazyNode{value: value, children: children}, err
+}
+
+// save writes a node and returns its hash
+func (lt *LazyTrie) save(node lazyNode) (string, error) {
+	data, err := encodeTrieNode(node.value, node.children, hashSize(lt.hashFn))
+	if err != nil {
+		return "", err
+	}
+	hash := hexSum(lt.hashFn, data)
+	return hash, lt.store.PutNode(hash, data)
+}
+
+// childIndex finds the child whose label starts with b, or where it would go
+func (n lazyNode) childIndex(b byte) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].Label[0] >= b })
+	return i, i < len(n.children) && n.children[i].Label[0] == b
+}
+
+// Err returns the first store error hit by Insert
+func (lt *LazyTrie) Err() error {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	return lt.err
+}
+
+// Reclaim drops from the store every node not reachable from the current
+// root or the roots of shared, such as those earlier inserts replaced,
+// returning how many it dropped. Every other trie using the store must be
+// passed in shared, e.g. the archives of shards split from one another;
+// tries opened at an older root can no longer load the nodes they alone used.
+// The shared tries must not be updated until Reclaim returns.
+func (lt *LazyTrie) Reclaim(shared ...*LazyTrie) (int, error) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	live := make(map[string]bool)
+	if err := lt.mark(lt.root, live); err != nil {
+		return 0, err
+	}
+	for _, trie := range shared {
+		if trie == lt {
+			continue
+		}
+		if err := trie.mark(trie.GetMerkleRoot(), live); err != nil {
+			return 0, err
+		}
+	}
+	return lt.store.Retain(live)
+}
+
+// mark adds a subtree's node hashes to live
+func (lt *LazyTrie) mark(hash string, live map[string]bool) error {
+	if live[hash] {
+		return nil
+	}
+	live[hash] = true
+	node, err := lt.load(hash)
+	if err != nil {
+		return err
+	}
+	for _, child := range node.children {
+		if err := lt.mark(child.Hash, live); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMerkleRoot returns the Merkle root of the trie
+func (lt *LazyTrie) GetMerkleRoot() string {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	return lt.root
+}
+
+// Insert adds a key-value pair, writing the changed path to the store
+func (lt *LazyTrie) Insert(key, value string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	root, err := lt.insert(lt.root, key, value)
+	if err != nil {
+		if lt.err == nil {
+			lt.err = err
+		}
+		return
+	}
+	lt.root = root
+}
+
+func (lt *LazyTrie) insert(hash, key, value string) (string, error) {
+	node, err := lt.load(hash)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		node.value = value
+		return lt.save(node)
+	}
+
+	i, exists := node.childIndex(key[0])
+	if !exists {
+		leaf, err := lt.save(lazyNode{value: value})
+		if err != nil {
+			return "", err
+		}
+		node.children = append(node.children, TrieProofChild{})
+		copy(node.children[i+1:], node.children[i:])
+		node.children[i] = TrieProofChild{Label: key, Hash: leaf}
+		return lt.save(node)
+	}
+
+	child := node.children[i]
+	common := commonPrefixLen([]byte(child.Label), []byte(key))
+	if common < len(child.Label) {
+		// Split the edge where the new key diverges
+		mid, err := lt.save(lazyNode{children: []TrieProofChild{{Label: child.Label[common:], Hash: child.Hash}}})
+		if err != nil {
+			return "", err
+		}
+		child = TrieProofChild{Label: child.Label[:common], Hash: mid}
+	}
+	updated, err := lt.insert(child.Hash, key[common:], value)
+	if err != nil {
+		return "", err
+	}
+	node.children[i] = TrieProofChild{Label: child.Label, Hash: updated}
+	return lt.save(node)
+}
+
+// Get retrieves the value associated with a key
+func (lt *LazyTrie) Get(key string) (string, bool) {
+	hash := lt.GetMerkleRoot()
+	for {
+		node, err := lt.load(hash)
+		if err != nil {
+			return "", false
+		}
+		if key == "" {
+			return node.value, node.value != ""
+		}
+		i, exists := node.childIndex(key[0])
+		if !exists || !strings.HasPrefix(key, node.children[i].Label) {
+			return "", false
+		}
+		key = key[len(node.children[i].Label):]
+		hash = node.children[i].Hash
+	}
+}
+
+// Prove returns an inclusion proof for a stored key, loading only its path
+func (lt *LazyTrie) Prove(key string) (TrieProof, error) {
+	proof := TrieProof{Hasher: hasherName(lt.hashFn)}
+	hash := lt.GetMerkleRoot()
+	rest := key
+	for {
+		node, err := lt.load(hash)
+		if err != nil {
+			return TrieProof{}, err
+		}
+		step := TrieProofStep{Value: node.value, Children: append([]TrieProofChild{}, node.children...)}
+		if rest == "" {
+			proof.Steps = append(proof.Steps, step)
+			if node.value == "" {
+				return TrieProof{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+			}
+			return proof, nil
+		}
+		i, exists := node.childIndex(rest[0])
+		if !exists || !strings.HasPrefix(rest, node.children[i].Label) {
+			return TrieProof{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+		}
+		hash = node.children[i].Hash
+		step.Children[i].Hash = ""
+		proof.Steps = append(proof.Steps, step)
+		rest = rest[len(node.children[i].Label):]
+	}
+}
+
+// Iterate visits every stored key-value pair in lexicographic key order
+// until fn returns false
+func (lt *LazyTrie) Iterate(fn func(key, value string) bool) {
+	lt.iterate(lt.GetMerkleRoot(), "", fn)
+}
+
+func (lt *LazyTrie) iterate(hash, path string, fn func(key, value string) bool) bool {
+	node, err := lt.load(hash)
+	if err != nil {
+		return false
+	}
+	if node.value != "" && !fn(path, node.value) {
+		return false
+	}
+	for _, child := range node.children {
+		if !lt.iterate(child.Hash, path+child.Label, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns every stored key in lexicographic order
+func (lt *LazyTrie) Keys() []string {
+	var keys []string
+	lt.Iterate(func(key, _ string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Dump loads every node and returns the trie structure as plain data.
+// Nodes that fail to load appear without children.
+func (lt *LazyTrie) Dump() TrieDump {
+	dump := TrieDump{RootHash: lt.GetMerkleRoot()}
+	dump.Root = lt.dumpNode("", dump.RootHash, &dump.Keys)
+	return dump
+}
+
+func (lt *LazyTrie) dumpNode(label, hash string, keys *int) TrieNodeDump {
+	dump := TrieNodeDump{Label: label, Hash: hash}
+	node, err := lt.load(hash)
+	if err != nil {
+		return dump
+	}
+	dump.Value = node.value
+	if node.value != "" {
+		*keys++
+	}
+	for _, child := range node.children {
+		dump.Children = append(dump.Children, lt.dumpNode(child.Label, child.Hash, keys))
+	}
+	return dump
+}
+
+// PrintTrie writes the trie structure to w (for debugging)
+func (lt *LazyTrie) PrintTrie(w io.Writer) {
+	writeTrieDump(w, "Lazy Trie", lt.Dump())
+}
@@ -0,0 +1,359 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// truncatedHasher is SHA-256 cut to 20 bytes, a digest size other than the
+// default for checking the encoding does not assume 32-byte child hashes
+type truncatedHasher struct{}
+
+func (truncatedHasher) Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:20]
+}
+
+func (truncatedHasher) Name() string { return "sha256/160" }
+
+func init() {
+	RegisterHasher(truncatedHasher{})
+}
+
+// trieHashers covers the default, another 32-byte and a 20-byte hasher
+var trieHashers = map[string][]TrieOption{
+	"sha256":     nil,
+	"sha512/256": {WithTrieHasher(SHA512_256Hasher{})},
+	"sha256/160": {WithTrieHasher(truncatedHasher{})},
+}
+
+// filledTrie returns a trie holding random block hash keys
+func filledTrie(n int, opts ...TrieOption) *SuccinctTrie {
+	st := NewSuccinctTrie(opts...)
+	for i, key := range randomBlockHashes(n) {
+		st.Insert(key, testLeaves(n)[i])
+	}
+	return st
+}
+
+// checkTrieContents fails unless trie holds exactly want's pairs, each
+// with a proof verifying against the trie's root
+func checkTrieContents(t *testing.T, trie Trie, want *SuccinctTrie) {
+	t.Helper()
+	if trie.GetMerkleRoot() != want.GetMerkleRoot() {
+		t.Fatalf("root %s, want %s", trie.GetMerkleRoot(), want.GetMerkleRoot())
+	}
+	want.Iterate(func(key, value string) bool {
+		if got, ok := trie.Get(key); !ok || got != value {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, got, ok, value)
+		}
+		proof, err := trie.Prove(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyTrieProof(trie.GetMerkleRoot(), key, value, proof) {
+			t.Fatalf("proof of %q rejected", key)
+		}
+		return true
+	})
+	if _, ok := trie.Get("missing"); ok {
+		t.Fatal("missing key found")
+	}
+	if len(trie.Keys()) != len(want.Keys()) {
+		t.Fatalf("%d keys, want %d", len(trie.Keys()), len(want.Keys()))
+	}
+}
+
+func TestSerializeRoundTripPreservesRoot(t *testing.T) {
+	for name, opts := range trieHashers {
+		t.Run(name, func(t *testing.T) {
+			st := filledTrie(300, opts...)
+			var buf bytes.Buffer
+			if err := st.Serialize(&buf); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DeserializeTrie(bytes.NewReader(buf.Bytes()), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTrieContents(t, decoded, st)
+
+			if name != "sha256" {
+				if _, err := DeserializeTrie(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrHasherMismatch) {
+					t.Fatalf("read under the wrong hasher: %v", err)
+				}
+			}
+			corrupt := append([]byte{}, buf.Bytes()...)
+			corrupt[len(corrupt)-1] ^= 1
+			if _, err := DeserializeTrie(bytes.NewReader(corrupt), opts...); !errors.Is(err, ErrCorruptNode) {
+				t.Fatalf("flipped byte: %v", err)
+			}
+		})
+	}
+}
+
+func TestLazyTrieOverPersistedNodes(t *testing.T) {
+	for name, opts := range trieHashers {
+		t.Run(name, func(t *testing.T) {
+			st := filledTrie(300, opts...)
+			path := filepath.Join(t.TempDir(), "nodes")
+			store, err := OpenFileNodeStore(path, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Persist(store); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			// Reopening indexes the records already in the file
+			store, err = OpenFileNodeStore(path, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			lazy, err := OpenLazyTrie(store, st.GetMerkleRoot(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTrieContents(t, lazy, st)
+
+			// Inserts through either trie reach the same root
+			for i, key := range randomBlockHashes(20) {
+				st.Insert(key+"/new", testLeaves(20)[i])
+				lazy.Insert(key+"/new", testLeaves(20)[i])
+			}
+			if err := lazy.Err(); err != nil {
+				t.Fatal(err)
+			}
+			checkTrieContents(t, lazy, st)
+		})
+	}
+}
+
+func TestLazyTrieMatchesSuccinctTrie(t *testing.T) {
+	lazy, err := NewLazyTrie(NewMemoryNodeStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewSuccinctTrie()
+	if lazy.GetMerkleRoot() != st.GetMerkleRoot() {
+		t.Fatal("empty roots differ")
+	}
+	for i, key := range randomBlockHashes(200) {
+		lazy.Insert(key[:8], testLeaves(200)[i]) // Short keys share prefixes
+		st.Insert(key[:8], testLeaves(200)[i])
+	}
+	checkTrieContents(t, lazy, st)
+}
+
+// countTrieNodes returns the number of nodes in a subtree
+func countTrieNodes(node *TrieNode) int {
+	count := 1
+	for _, child := range node.Children {
+		count += countTrieNodes(child)
+	}
+	return count
+}
+
+func TestLazyTrieReclaimDropsReplacedNodes(t *testing.T) {
+	stores := map[string]func(t *testing.T) NodeStore{
+		"memory": func(*testing.T) NodeStore { return NewMemoryNodeStore() },
+		"file": func(t *testing.T) NodeStore {
+			store, err := OpenFileNodeStore(filepath.Join(t.TempDir(), "nodes"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			lazy, err := NewLazyTrie(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := NewSuccinctTrie()
+			for i, key := range randomBlockHashes(100) {
+				lazy.Insert(key, testLeaves(100)[i])
+				st.Insert(key, testLeaves(100)[i])
+			}
+			live := countTrieNodes(st.Root)
+
+			dropped, err := lazy.Reclaim()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dropped == 0 {
+				t.Fatal("copy-on-write inserts left no nodes to reclaim")
+			}
+			if again, _ := lazy.Reclaim(); again != 0 {
+				t.Fatalf("second reclaim dropped %d nodes", again)
+			}
+			if stored, _ := store.Retain(map[string]bool{}); stored != live {
+				t.Fatalf("store kept %d nodes, trie has %d", stored, live)
+			}
+		})
+	}
+}
+
+func TestLazyTrieWorksAfterReclaim(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes")
+	store, err := OpenFileNodeStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy, err := NewLazyTrie(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewSuccinctTrie()
+	keys := randomBlockHashes(60)
+	for i, key := range keys[:30] {
+		lazy.Insert(key, testLeaves(60)[i])
+		st.Insert(key, testLeaves(60)[i])
+	}
+	before, _ := os.Stat(path)
+	if _, err := lazy.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("node file grew from %d to %d bytes", before.Size(), after.Size())
+	}
+	for i, key := range keys[30:] {
+		lazy.Insert(key, testLeaves(60)[30+i])
+		st.Insert(key, testLeaves(60)[30+i])
+	}
+	checkTrieContents(t, lazy, st)
+	store.Close()
+
+	reopened, err := OpenFileNodeStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	lazy, err = OpenLazyTrie(reopened, st.GetMerkleRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTrieContents(t, lazy, st)
+}
+
+// allocatedDuring returns the bytes fn allocates
+func allocatedDuring(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestCorruptRecordLengthsAreBounded(t *testing.T) {
+	huge := binary.AppendUvarint(nil, math.MaxInt32)
+	stream := append([]byte(trieMagic), huge...)
+	stream = append(stream, "short"...)
+	allocated := allocatedDuring(func() {
+		if _, err := DeserializeTrie(bytes.NewReader(stream)); !errors.Is(err, ErrCorruptNode) {
+			t.Errorf("oversized record: %v", err)
+		}
+		if _, err := DeserializeTrie(bytes.NewReader(append([]byte(trieMagic), binary.AppendUvarint(nil, math.MaxUint64)...))); !errors.Is(err, ErrCorruptNode) {
+			t.Errorf("record length past int64: %v", err)
+		}
+	})
+	if allocated > 1<<20 {
+		t.Fatalf("corrupt lengths allocated %d bytes", allocated)
+	}
+
+	path := filepath.Join(t.TempDir(), "nodes")
+	record := []byte("node")
+	valid := append(binary.AppendUvarint(nil, uint64(len(record))), record...)
+	if err := os.WriteFile(path, append(append(valid, huge...), "torn"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var store *FileNodeStore
+	allocated = allocatedDuring(func() {
+		var err error
+		if store, err = OpenFileNodeStore(path); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer store.Close()
+	if allocated > 1<<20 {
+		t.Fatalf("opening a file with a corrupt length allocated %d bytes", allocated)
+	}
+	if got, err := store.GetNode(hexSum(nil, record)); err != nil || !bytes.Equal(got, record) {
+		t.Fatalf("record before the corrupt one lost: %q, %v", got, err)
+	}
+
+	// The next node overwrites the corrupt record and survives a reopen
+	if err := store.PutNode(hexSum(nil, []byte("next")), []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenFileNodeStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for _, data := range []string{"node", "next"} {
+		if got, err := reopened.GetNode(hexSum(nil, []byte(data))); err != nil || string(got) != data {
+			t.Fatalf("record %q after reopening: %q, %v", data, got, err)
+		}
+	}
+}
+
+func TestDecodeTrieNodeUsesHashSize(t *testing.T) {
+	children := []TrieProofChild{
+		{Label: "a", Hash: hexSum(truncatedHasher{}, []byte("a"))},
+		{Label: "bc", Hash: hexSum(truncatedHasher{}, []byte("bc"))},
+	}
+	data, err := encodeTrieNode("value", children, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, decoded, err := decodeTrieNode(data, 20)
+	if err != nil || value != "value" || len(decoded) != 2 || decoded[1] != children[1] {
+		t.Fatalf("decoded %q %v: %v", value, decoded, err)
+	}
+	if _, _, err := decodeTrieNode(data, sha256.Size); !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("20-byte hashes decoded at 32 bytes: %v", err)
+	}
+	if _, err := encodeTrieNode("value", children, sha256.Size); !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("20-byte hashes encoded at 32 bytes: %v", err)
+	}
+}
+
+func TestStateManagerWithLazyArchive(t *testing.T) {
+	lazy, err := NewLazyTrie(NewMemoryNodeStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazySM, plainSM := NewStateManager(3, WithArchiveTrie(lazy)), NewStateManager(3)
+	for _, b := range stateChain(t, 12) {
+		lazySM.AddBlock(b)
+		plainSM.AddBlock(b)
+	}
+	plainSM.UpdateArchiveRoot()
+	if lazySM.GetArchiveRoot() != plainSM.GetArchiveRoot() {
+		t.Fatal("lazy archive root differs from the in-memory one")
+	}
+	for _, b := range lazySM.PrunedBlocks {
+		proof, err := lazy.Prove(b.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, _ := lazy.Get(b.Hash)
+		if !VerifyTrieProof(lazySM.GetArchiveRoot(), b.Hash, value, proof) {
+			t.Fatalf("archived block %s has no valid proof", b.Hash)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Errors returned when applying transactions to the ledger
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrBadNonce            = errors.New("unexpected transaction nonce")
+	ErrBalanceOverflow     = errors.New("balance overflow")
+	ErrStateRootMismatch   = errors.New("state root mismatch")
+	ErrReceiptRedeemed     = errors.New("transfer receipt already redeemed")
+)
+
+// Trie key prefixes for account fields and redeemed transfer receipts
+const (
+	balanceKeyPrefix = "balance/"
+	nonceKeyPrefix   = "nonce/"
+	receiptKeyPrefix = "receipt/"
+)
+
+// LedgerState holds account balances and nonces in a SuccinctTrie. Values are
+// stored as decimal strings so the trie root commits to the whole state.
+// Transaction fees only order the mempool and are not charged.
+type LedgerState struct {
+	trie    *SuccinctTrie
+	history *RootHistory // State roots by block height, shared with clones
+}
+
+// NewLedgerState creates an empty account state
+func NewLedgerState() *LedgerState {
+	return &LedgerState{trie: NewSuccinctTrie(), history: NewRootHistory()}
+}
+
+// Clone returns an independent copy of the state. The copy shares the root
+// history, so the successive states of a chain answer StateRootAt alike.
+func (ls *LedgerState) Clone() *LedgerState {
+	return &LedgerState{trie: ls.trie.Clone(), history: ls.history}
+}
+
+// BalanceOf returns the balance of an address, zero if unknown
+func (ls *LedgerState) BalanceOf(addr string) uint64 {
+	return ls.readUint(balanceKeyPrefix + addr)
+}
+
+// NonceOf returns the nonce expected on the next transaction from addr
+func (ls *LedgerState) NonceOf(addr string) uint64 {
+	return ls.readUint(nonceKeyPrefix + addr)
+}
+
+// StateRoot returns the trie root committing to every balance and nonce
+func (ls *LedgerState) StateRoot() string {
+	return ls.trie.GetMerkleRoot()
+}
+
+// StateRootAt returns the state root after the main-chain block at height,
+// as recorded by the Blockchain the state belongs to. It reports false for
+// heights before the recorded or retained history.
+func (ls *LedgerState) StateRootAt(height int) (string, bool) {
+	return ls.history.At(height)
+}
+
+// recordRoot records the current root as the state at height
+func (ls *LedgerState) recordRoot(height int) {
+	ls.history.Record(height, ls.StateRoot())
+}
+
+// Credit adds funds to an address outside of any transaction (e.g. genesis allocations)
+func (ls *LedgerState) Credit(addr string, amount uint64) error {
+	balance := ls.BalanceOf(addr)
+	if balance > math.MaxUint64-amount {
+		return fmt.Errorf("%w: crediting %d to %s", ErrBalanceOverflow, amount, addr)
+	}
+	ls.writeUint(balanceKeyPrefix+addr, balance+amount)
+	return nil
+}
+
+// Debit removes funds from an address outside of any transaction
+func (ls *LedgerState) Debit(addr string, amount uint64) error {
+	balance := ls.BalanceOf(addr)
+	if balance < amount {
+		return fmt.Errorf("%w: %s has %d, needs %d", ErrInsufficientBalance, addr, balance, amount)
+	}
+	ls.writeUint(balanceKeyPrefix+addr, balance-amount)
+	return nil
+}
+
+// RedeemReceipt credits a cross-shard transfer to addr and records its
+// receipt, so the state root commits to it and it cannot be credited again
+func (ls *LedgerState) RedeemReceipt(transferID, addr string, amount uint64) error {
+	if ls.HasRedeemed(transferID) {
+		return fmt.Errorf("%w: %s", ErrReceiptRedeemed, transferID)
+	}
+	if err := ls.Credit(addr, amount); err != nil {
+		return err
+	}
+	ls.trie.Insert(receiptKeyPrefix+transferID, addr)
+	return nil
+}
+
+// HasRedeemed reports whether the receipt of a transfer has been credited
+func (ls *LedgerState) HasRedeemed(transferID string) bool {
+	_, ok := ls.trie.Get(receiptKeyPrefix + transferID)
+	return ok
+}
+
+// RedeemedReceipts returns the IDs of every credited transfer receipt
+func (ls *LedgerState) RedeemedReceipts() []string {
+	var ids []string
+	for key := range ls.trie.GetByPrefix(receiptKeyPrefix) {
+		ids = append(ids, strings.TrimPrefix(key, receiptKeyPrefix))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ApplyTransaction checks the sender's nonce and balance, then moves the
+// amount and advances the nonce. The state is unchanged on error.
+func (ls *LedgerState) ApplyTransaction(tx Transaction) error {
+	if nonce := ls.NonceOf(tx.From); tx.Nonce != nonce {
+		return fmt.Errorf("%w: %s sent nonce %d, expected %d", ErrBadNonce, tx.From, tx.Nonce, nonce)
+	}
+	if balance := ls.BalanceOf(tx.From); balance < tx.Amount {
+		return fmt.Errorf("%w: %s has %d, needs %d", ErrInsufficientBalance, tx.From, balance, tx.Amount)
+	}
+	if tx.From != tx.To && ls.BalanceOf(tx.To) > math.MaxUint64-tx.Amount {
+		return fmt.Errorf("%w: crediting %d to %s", ErrBalanceOverflow, tx.Amount, tx.To)
+	}
+
+	// Self-transfers only advance the nonce
+	if tx.From != tx.To {
+		ls.writeUint(balanceKeyPrefix+tx.From, ls.BalanceOf(tx.From)-tx.Amount)
+		ls.writeUint(balanceKeyPrefix+tx.To, ls.BalanceOf(tx.To)+tx.Amount)
+	}
+	ls.writeUint(nonceKeyPrefix+tx.From, tx.Nonce+1)
+	return nil
+}
+
+// debitSender performs the source half of a cross-shard transfer: it checks
+// the nonce and balance, debits the amount and advances the sender's nonce
+func (ls *LedgerState) debitSender(tx Transaction) error {
+	if nonce := ls.NonceOf(tx.From); tx.Nonce != nonce {
+		return fmt.Errorf("%w: %s sent nonce %d, expected %d", ErrBadNonce, tx.From, tx.Nonce, nonce)
+	}
+	if err := ls.Debit(tx.From, tx.Amount); err != nil {
+		return err
+	}
+	ls.writeUint(nonceKeyPrefix+tx.From, tx.Nonce+1)
+	return nil
+}
+
+// Merge folds another state into this one, summing balances, keeping the
+// higher nonce for accounts present in both, every redeemed receipt and
+// every application key, other's value winning where both set one
+func (ls *LedgerState) Merge(other *LedgerState) error {
+	var err error
+	other.trie.Iterate(func(key, value string) bool {
+		if strings.HasPrefix(key, receiptKeyPrefix) || strings.HasPrefix(key, appKeyPrefix) {
+			ls.trie.Insert(key, value)
+			return true
+		}
+		n, parseErr := strconv.ParseUint(value, 10, 64)
+		if parseErr != nil {
+			return true
+		}
+		switch {
+		case strings.HasPrefix(key, balanceKeyPrefix):
+			err = ls.Credit(strings.TrimPrefix(key, balanceKeyPrefix), n)
+		case strings.HasPrefix(key, nonceKeyPrefix):
+			if n > ls.readUint(key) {
+				ls.writeUint(key, n)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// TotalSupply returns the sum of all balances
+func (ls *LedgerState) TotalSupply() uint64 {
+	var total uint64
+	for _, value := range ls.trie.GetByPrefix(balanceKeyPrefix) {
+		n, _ := strconv.ParseUint(value, 10, 64)
+		total += n
+	}
+	return total
+}
+
+// ApplyTransactions applies a batch in order, stopping at the first failure
+func (ls *LedgerState) ApplyTransactions(txs []Transaction) error {
+	for i, tx := range txs {
+		if err := ls.ApplyTransaction(tx); err != nil {
+			return fmt.Errorf("transaction %d (%s): %w", i, tx.Hash(), err)
+		}
+	}
+	return nil
+}
+
+func (ls *LedgerState) readUint(key string) uint64 {
+	value, ok := ls.trie.Get(key)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (ls *LedgerState) writeUint(key string, n uint64) {
+	ls.trie.Insert(key, strconv.FormatUint(n, 10))
+}
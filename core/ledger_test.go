@@ -0,0 +1,100 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyTransactionRejectsOverdraft(t *testing.T) {
+	ls := NewLedgerState()
+	if err := ls.Credit("alice", 50); err != nil {
+		t.Fatal(err)
+	}
+	root := ls.StateRoot()
+	err := ls.ApplyTransaction(Transaction{From: "alice", To: "bob", Amount: 51})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("overdraft: %v", err)
+	}
+	if ls.StateRoot() != root || ls.NonceOf("alice") != 0 {
+		t.Fatal("failed transaction changed the state")
+	}
+	if err := ls.ApplyTransaction(Transaction{From: "alice", To: "bob", Amount: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if ls.BalanceOf("alice") != 0 || ls.BalanceOf("bob") != 50 || ls.TotalSupply() != 50 {
+		t.Fatalf("balances %d and %d after spending everything", ls.BalanceOf("alice"), ls.BalanceOf("bob"))
+	}
+}
+
+func TestSelfTransferOnlyAdvancesNonce(t *testing.T) {
+	ls := NewLedgerState()
+	if err := ls.Credit("alice", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.ApplyTransaction(Transaction{From: "alice", To: "alice", Amount: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if ls.BalanceOf("alice") != 10 || ls.NonceOf("alice") != 1 {
+		t.Fatalf("balance %d, nonce %d after a self-transfer", ls.BalanceOf("alice"), ls.NonceOf("alice"))
+	}
+	if err := ls.ApplyTransaction(Transaction{From: "alice", To: "alice", Amount: 11, Nonce: 1}); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("self-transfer beyond the balance: %v", err)
+	}
+}
+
+func TestReplayedTransactionRejected(t *testing.T) {
+	ls := NewLedgerState()
+	if err := ls.Credit("alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	tx := Transaction{From: "alice", To: "bob", Amount: 10}
+	if err := ls.ApplyTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.ApplyTransaction(tx); !errors.Is(err, ErrBadNonce) {
+		t.Fatalf("replayed transaction: %v", err)
+	}
+	skipped := Transaction{From: "alice", To: "bob", Amount: 10, Nonce: 5}
+	if err := ls.ApplyTransaction(skipped); !errors.Is(err, ErrBadNonce) {
+		t.Fatalf("transaction skipping nonces: %v", err)
+	}
+	if ls.BalanceOf("bob") != 10 {
+		t.Fatalf("bob holds %d, want 10", ls.BalanceOf("bob"))
+	}
+}
+
+func TestBlockchainReplaysTransactionsOnValidation(t *testing.T) {
+	bc := fundedChain(t, map[string]uint64{"alice": 100})
+	txs := []Transaction{{From: "alice", To: "bob", Amount: 30}, {From: "bob", To: "carol", Amount: 5}}
+	block, err := bc.AddBlockWithTransactions(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.StateRoot != bc.State().StateRoot() {
+		t.Fatal("block does not embed the resulting state root")
+	}
+	if _, err := bc.AddBlockWithTransactions(txs[:1]); !errors.Is(err, ErrBadNonce) {
+		t.Fatalf("block replaying a transaction: %v", err)
+	}
+
+	// A block claiming a state root its transactions do not produce
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	next := []Transaction{{From: "alice", To: "bob", Amount: 1, Nonce: 1}}
+	forged, err := GenerateTransactionBlock(tip, next, NewLedgerState().StateRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlockCandidate(forged); err == nil {
+		t.Fatal("block with a wrong state root accepted")
+	}
+	overdraft := []Transaction{{From: "carol", To: "bob", Amount: 6}}
+	if _, err := bc.AddBlockWithTransactions(overdraft); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("block with an overdraft: %v", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if bc.State().BalanceOf("alice") != 70 || bc.State().BalanceOf("carol") != 5 {
+		t.Fatal("rejected blocks changed the tip state")
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Errors returned by the mempool
+var (
+	ErrDuplicateTransaction = errors.New("transaction already pending")
+	ErrTransactionIncluded  = errors.New("transaction already included in a block")
+	ErrMempoolFull          = errors.New("mempool full and transaction priority too low")
+)
+
+// pendingTx is a transaction waiting in the mempool with its arrival order
+type pendingTx struct {
+	tx      Transaction
+	hash    string
+	arrival uint64
+	block   uint64 // Blocks included before it arrived
+}
+
+// Mempool stages transactions until a block producer picks them up
+type Mempool struct {
+	pending  map[string]*pendingTx
+	included map[string]bool
+	maxSize  int
+	arrivals uint64
+	blocks   uint64 // Calls to MarkIncluded, the clock priorities age by
+	aging    int    // Blocks a transaction waits per class it climbs
+	mu       sync.Mutex
+}
+
+// NewMempool creates a mempool holding at most maxSize transactions
+func NewMempool(maxSize int) *Mempool {
+	return &Mempool{
+		pending:  make(map[string]*pendingTx),
+		included: make(map[string]bool),
+		maxSize:  maxSize,
+		aging:    DefaultPriorityAging,
+	}
+}
+
+// SetPriorityAging makes a pending transaction climb one priority class
+// for every blocks blocks included while it waits; zero or less disables
+// aging, letting a steady flow of higher classes starve Low transactions
+func (mp *Mempool) SetPriorityAging(blocks int) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.aging = blocks
+}
+
+// before reports whether a is served ahead of b: by aged priority, then
+// highest fee, then oldest arrival; the caller must hold the mutex
+func (mp *Mempool) before(a, b *pendingTx) bool {
+	rankA := agedRank(a.tx.Priority, mp.blocks-a.block, mp.aging)
+	rankB := agedRank(b.tx.Priority, mp.blocks-b.block, mp.aging)
+	if rankA != rankB {
+		return rankA < rankB
+	}
+	if a.tx.Fee != b.tx.Fee {
+		return a.tx.Fee > b.tx.Fee
+	}
+	return a.arrival < b.arrival
+}
+
+// Submit adds a transaction, evicting the last transaction in serving
+// order when full
+func (mp *Mempool) Submit(tx Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	hash := tx.Hash()
+	if mp.included[hash] {
+		return fmt.Errorf("%w: %s", ErrTransactionIncluded, hash)
+	}
+	if _, exists := mp.pending[hash]; exists {
+		return fmt.Errorf("%w: %s", ErrDuplicateTransaction, hash)
+	}
+
+	entry := &pendingTx{tx: tx, hash: hash, arrival: mp.arrivals + 1, block: mp.blocks}
+	if len(mp.pending) >= mp.maxSize {
+		lowest := mp.lowestPriority()
+		if lowest == nil || !mp.before(entry, lowest) {
+			return fmt.Errorf("%w: fee %d", ErrMempoolFull, tx.Fee)
+		}
+		delete(mp.pending, lowest.hash)
+	}
+
+	mp.arrivals++
+	mp.pending[hash] = entry
+	return nil
+}
+
+// lowestPriority returns the eviction candidate, the last transaction in
+// serving order
+func (mp *Mempool) lowestPriority() *pendingTx {
+	var lowest *pendingTx
+	for _, p := range mp.pending {
+		if lowest == nil || mp.before(lowest, p) {
+			lowest = p
+		}
+	}
+	return lowest
+}
+
+// Pending returns up to max transactions in serving order: High priority
+// first and Low last, counting classes climbed by aging, then highest fee,
+// then oldest arrival. A max of zero or less returns everything.
+func (mp *Mempool) Pending(max int) []Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	ordered := make([]*pendingTx, 0, len(mp.pending))
+	for _, p := range mp.pending {
+		ordered = append(ordered, p)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return mp.before(ordered[i], ordered[j])
+	})
+	if max > 0 && len(ordered) > max {
+		ordered = ordered[:max]
+	}
+
+	txs := make([]Transaction, len(ordered))
+	for i, p := range ordered {
+		txs[i] = p.tx
+	}
+	return txs
+}
+
+// Remove drops transactions from the mempool
+func (mp *Mempool) Remove(hashes []string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, hash := range hashes {
+		delete(mp.pending, hash)
+	}
+}
+
+// MarkIncluded removes transactions that made it into a block and rejects
+// any later re-submission of them. Each call counts as one block for aging.
+func (mp *Mempool) MarkIncluded(hashes []string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.blocks++
+	for _, hash := range hashes {
+		delete(mp.pending, hash)
+		mp.included[hash] = true
+	}
+}
+
+// Size returns the number of pending transactions
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.pending)
+}
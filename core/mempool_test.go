@@ -0,0 +1,189 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fundedChain returns a chain whose genesis state credits each address
+func fundedChain(t testing.TB, balances map[string]uint64) *Blockchain {
+	t.Helper()
+	genesis := NewLedgerState()
+	for addr, amount := range balances {
+		if err := genesis.Credit(addr, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc := NewBlockchain()
+	if err := bc.SetGenesisState(genesis); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func TestMempoolEvictsLowestFee(t *testing.T) {
+	mp := NewMempool(3)
+	for i, fee := range []uint64{5, 1, 3} {
+		if err := mp.Submit(Transaction{From: "alice", To: "bob", Nonce: uint64(i), Fee: fee}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A transaction no better than the lowest is turned away
+	if err := mp.Submit(Transaction{From: "alice", To: "bob", Nonce: 9, Fee: 1}); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("equal-fee transaction into a full pool: %v", err)
+	}
+	// A better one evicts the fee-1 transaction
+	if err := mp.Submit(Transaction{From: "alice", To: "bob", Nonce: 3, Fee: 4}); err != nil {
+		t.Fatal(err)
+	}
+	pending := mp.Pending(0)
+	if len(pending) != 3 {
+		t.Fatalf("%d pending, want 3", len(pending))
+	}
+	for i, fee := range []uint64{5, 4, 3} {
+		if pending[i].Fee != fee {
+			t.Fatalf("pending fees %d, %d, %d, want 5, 4, 3", pending[0].Fee, pending[1].Fee, pending[2].Fee)
+		}
+	}
+	if got := mp.Pending(2); len(got) != 2 || got[1].Fee != 4 {
+		t.Fatal("Pending(2) is not the two highest fees")
+	}
+}
+
+func TestMempoolEqualFeesServeOldestFirst(t *testing.T) {
+	mp := NewMempool(2)
+	first := Transaction{From: "alice", To: "bob", Nonce: 0, Fee: 2}
+	second := Transaction{From: "alice", To: "bob", Nonce: 1, Fee: 2}
+	for _, tx := range []Transaction{first, second} {
+		if err := mp.Submit(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pending := mp.Pending(0); pending[0].Hash() != first.Hash() {
+		t.Fatal("later arrival served first")
+	}
+	if err := mp.Submit(Transaction{From: "alice", To: "bob", Nonce: 2, Fee: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range mp.Pending(0) {
+		if tx.Hash() == second.Hash() {
+			t.Fatal("the older of two equal-fee transactions was evicted")
+		}
+	}
+}
+
+func TestMempoolDeduplicates(t *testing.T) {
+	mp := NewMempool(10)
+	tx := Transaction{From: "alice", To: "bob", Amount: 1}
+	if err := mp.Submit(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Submit(tx); !errors.Is(err, ErrDuplicateTransaction) {
+		t.Fatalf("duplicate: %v", err)
+	}
+	mp.Remove([]string{tx.Hash()})
+	if mp.Size() != 0 {
+		t.Fatal("removed transaction still pending")
+	}
+	if err := mp.Submit(tx); err != nil {
+		t.Fatalf("removed transaction not resubmittable: %v", err)
+	}
+}
+
+func TestProducerDrainsMempool(t *testing.T) {
+	bc := fundedChain(t, map[string]uint64{"alice": 1000})
+	mp := NewMempool(100)
+	var submitted []Transaction
+	for i := 0; i < 7; i++ {
+		tx := Transaction{From: "alice", To: "bob", Amount: 10, Nonce: uint64(i), Fee: 1}
+		submitted = append(submitted, tx)
+		if err := mp.Submit(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bp := NewBlockProducer(bc, mp, 3)
+	var included []Transaction
+	for _, want := range []int{3, 3, 1} {
+		block, err := bp.ProduceBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(block.Transactions) != want {
+			t.Fatalf("block holds %d transactions, want %d", len(block.Transactions), want)
+		}
+		if block.TxRoot != TransactionRoot(block.Transactions) {
+			t.Fatal("block's TxRoot does not cover its transactions")
+		}
+		included = append(included, block.Transactions...)
+	}
+	if _, err := bp.ProduceBlock(); !errors.Is(err, ErrNoTransactions) {
+		t.Fatalf("empty mempool: %v", err)
+	}
+	if mp.Size() != 0 || len(bc.Blocks) != 4 || bc.State().BalanceOf("bob") != 70 {
+		t.Fatalf("mempool %d, chain %d blocks, bob %d", mp.Size(), len(bc.Blocks), bc.State().BalanceOf("bob"))
+	}
+	for i, tx := range included {
+		if tx.Hash() != submitted[i].Hash() {
+			t.Fatalf("transaction %d out of nonce order", i)
+		}
+		if err := mp.Submit(tx); !errors.Is(err, ErrTransactionIncluded) {
+			t.Fatalf("resubmitting an included transaction: %v", err)
+		}
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProducerDropsSpentNonces(t *testing.T) {
+	bc := fundedChain(t, map[string]uint64{"alice": 100})
+	mp := NewMempool(10)
+	spent := Transaction{From: "alice", To: "bob", Amount: 1, Nonce: 0, Fee: 1}
+	if err := mp.Submit(spent); err != nil {
+		t.Fatal(err)
+	}
+	bp := NewBlockProducer(bc, mp, 10)
+	if _, err := bp.ProduceBlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same nonce, different transaction
+	replay := spent
+	replay.Fee = 2
+	if err := mp.Submit(replay); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bp.ProduceBlock(); !errors.Is(err, ErrNoTransactions) {
+		t.Fatalf("block from a spent nonce: %v", err)
+	}
+	if mp.Size() != 0 {
+		t.Fatal("transaction with a spent nonce left pending")
+	}
+}
+
+func TestProducerStartProducesPeriodically(t *testing.T) {
+	bc := fundedChain(t, map[string]uint64{"alice": 100})
+	mp := NewMempool(10)
+	bp := NewBlockProducer(bc, mp, 1)
+	for i := 0; i < 3; i++ {
+		if err := mp.Submit(Transaction{From: "alice", To: "bob", Amount: 1, Nonce: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bp.Start(5 * time.Millisecond)
+	defer bp.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for mp.Size() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d transactions still pending", mp.Size())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	bp.Stop()
+	if got := bc.State().BalanceOf("bob"); got != 3 {
+		t.Fatalf("bob holds %d, want 3", got)
+	}
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// DefaultMergeJournalCapacity is how many merge proofs a ShardManager keeps
+const DefaultMergeJournalCapacity = 64
+
+// MergeProof shows that a merged shard holds every block of the two shards
+// merged into it and nothing else. The leaves of each tree are included, so
+// a verifier recomputes all three roots and compares the sorted leaf sets;
+// SetCommitment hashes the sorted set for compact logging.
+type MergeProof struct {
+	LeftID        int      `json:"left_id"`
+	RightID       int      `json:"right_id"`
+	MergedID      int      `json:"merged_id"`
+	LeftRoot      string   `json:"left_root"`
+	RightRoot     string   `json:"right_root"`
+	MergedRoot    string   `json:"merged_root"`
+	LeftLeaves    []string `json:"left_leaves"`
+	RightLeaves   []string `json:"right_leaves"`
+	MergedLeaves  []string `json:"merged_leaves"`
+	SetCommitment string   `json:"set_commitment"` // SHA-256 of the sorted merged leaves
+}
+
+// NewMergeProof builds the proof that merged is the merge of left and right
+// from the shards' current trees
+func NewMergeProof(left, right, merged *Shard) MergeProof {
+	proof := MergeProof{LeftID: left.ID, RightID: right.ID, MergedID: merged.ID}
+	proof.LeftRoot, proof.LeftLeaves = left.rootAndLeaves()
+	proof.RightRoot, proof.RightLeaves = right.rootAndLeaves()
+	proof.MergedRoot, proof.MergedLeaves = merged.rootAndLeaves()
+	proof.SetCommitment = leafSetCommitment(proof.MergedLeaves)
+	return proof
+}
+
+// VerifyMergeProof checks that each root is the tree over its leaves and
+// that the merged leaves are exactly the left and right leaves together
+func VerifyMergeProof(p MergeProof) bool {
+	if !leavesMatchRoot(p.LeftLeaves, p.LeftRoot) ||
+		!leavesMatchRoot(p.RightLeaves, p.RightRoot) ||
+		!leavesMatchRoot(p.MergedLeaves, p.MergedRoot) {
+		return false
+	}
+	inputs := make([]string, 0, len(p.LeftLeaves)+len(p.RightLeaves))
+	inputs = append(append(inputs, p.LeftLeaves...), p.RightLeaves...)
+	commitment := leafSetCommitment(inputs)
+	return commitment == p.SetCommitment && commitment == leafSetCommitment(p.MergedLeaves)
+}
+
+// MergeProofs returns the proofs of the most recent merges, oldest first
+func (sm *ShardManager) MergeProofs() []MergeProof {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return append([]MergeProof{}, sm.merges...)
+}
+
+// recordMerges appends proofs to the merge journal, dropping the oldest
+// beyond DefaultMergeJournalCapacity; the caller must hold the manager lock
+func (sm *ShardManager) recordMerges(proofs []MergeProof) {
+	sm.merges = append(sm.merges, proofs...)
+	if extra := len(sm.merges) - DefaultMergeJournalCapacity; extra > 0 {
+		sm.merges = append([]MergeProof{}, sm.merges[extra:]...)
+	}
+}
+
+// emitMerges reports a ShardMerged event per proof; the caller must not
+// hold the manager lock
+func (sm *ShardManager) emitMerges(proofs []MergeProof) {
+	if sm.onEvent == nil {
+		return
+	}
+	for i := range proofs {
+		proof := proofs[i]
+		sm.onEvent(ShardEvent{Type: ShardMerged, ShardID: proof.MergedID, Merge: &proof})
+	}
+}
+
+// rootAndLeaves returns the shard's root and a copy of its tree leaves
+func (s *Shard) rootAndLeaves() (string, []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Tree == nil {
+		return "", []string{}
+	}
+	return s.Tree.GetRootHash(), append([]string{}, s.Tree.Leaves...)
+}
+
+// leavesMatchRoot reports whether root is the shard tree over leaves; a
+// shard without a tree has an empty root
+func leavesMatchRoot(leaves []string, root string) bool {
+	if len(leaves) == 0 && root == "" {
+		return true
+	}
+	return NewMerkleTreeFromLeaves(leaves).Root == root
+}
+
+// leafSetCommitment hashes leaves in sorted order, so any two orderings of
+// the same multiset of leaves commit to the same value
+func leafSetCommitment(leaves []string) string {
+	sorted := append([]string{}, leaves...)
+	sort.Strings(sorted)
+	hash := sha256.Sum256([]byte(strings.Join(sorted, "|")))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"sync"
+	"testing"
+)
+
+// mergeInput returns a shard with the given ID holding blocks
+func mergeInput(id int, blocks []Block) *Shard {
+	shard := NewShard(id)
+	for _, b := range blocks {
+		shard.AddBlock(b)
+	}
+	return shard
+}
+
+func TestMergeProducesVerifiableProofs(t *testing.T) {
+	var mu sync.Mutex
+	var events []ShardEvent
+	sm := NewShardManager(WithShardEvents(func(e ShardEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range fixedBlocks(16) {
+		sm.DistributeBlock(b)
+	}
+	before := sm.Shards.Size()
+	sm.MergeShards(100)
+
+	proofs := sm.MergeProofs()
+	if len(proofs) == 0 || sm.Shards.Size() != before-len(proofs) {
+		t.Fatalf("%d proofs for %d shards merged into %d", len(proofs), before, sm.Shards.Size())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var merged []ShardEvent
+	for _, e := range events {
+		if e.Type == ShardMerged {
+			merged = append(merged, e)
+		}
+	}
+	if len(merged) != len(proofs) {
+		t.Fatalf("%d merge events for %d proofs", len(merged), len(proofs))
+	}
+	for i, proof := range proofs {
+		if !VerifyMergeProof(proof) {
+			t.Fatalf("proof of merge %d does not verify", i)
+		}
+		if merged[i].Merge == nil || merged[i].Merge.SetCommitment != proof.SetCommitment || merged[i].ShardID != proof.MergedID {
+			t.Fatalf("event %d does not carry its proof", i)
+		}
+		view, ok := sm.GetShardView(proof.MergedID)
+		if !ok || view.Root != proof.MergedRoot || view.BlockCount != len(proof.LeftLeaves)+len(proof.RightLeaves) {
+			t.Fatalf("proof of merge %d does not describe shard #%d", i, proof.MergedID)
+		}
+	}
+}
+
+func TestMergeProofCatchesBuggyMerges(t *testing.T) {
+	blocks := fixedBlocks(8)
+	left, right := mergeInput(1, blocks[:4]), mergeInput(2, blocks[4:])
+	if proof := NewMergeProof(left, right, mergeInput(1, blocks)); !VerifyMergeProof(proof) {
+		t.Fatal("correct merge does not verify")
+	}
+	// Order within the merged shard does not matter, only the set
+	reordered := append(append([]Block{}, blocks[4:]...), blocks[:4]...)
+	if proof := NewMergeProof(left, right, mergeInput(1, reordered)); !VerifyMergeProof(proof) {
+		t.Fatal("merge in another order does not verify")
+	}
+
+	for name, merged := range map[string][]Block{
+		"dropped":    append(append([]Block{}, blocks[:5]...), blocks[6:]...),
+		"duplicated": append(append([]Block{}, blocks...), blocks[2]),
+		"swapped":    append(append([]Block{}, blocks[:7]...), fixedBlocks(9)[8]),
+		"empty":      nil,
+	} {
+		if VerifyMergeProof(NewMergeProof(left, right, mergeInput(1, merged))) {
+			t.Errorf("%s merge verified", name)
+		}
+	}
+
+	// A proof edited to hide the loss does not verify either
+	honest := NewMergeProof(left, right, mergeInput(1, blocks))
+	for name, edit := range map[string]func(p *MergeProof){
+		"merged leaf dropped": func(p *MergeProof) { p.MergedLeaves = p.MergedLeaves[1:] },
+		"input leaf dropped":  func(p *MergeProof) { p.LeftLeaves = p.LeftLeaves[1:] },
+		"left root":           func(p *MergeProof) { p.LeftRoot = right.Tree.GetRootHash() },
+		"commitment":          func(p *MergeProof) { p.SetCommitment = leafSetCommitment(p.LeftLeaves) },
+	} {
+		proof := honest
+		proof.LeftLeaves = append([]string{}, honest.LeftLeaves...)
+		proof.MergedLeaves = append([]string{}, honest.MergedLeaves...)
+		edit(&proof)
+		if VerifyMergeProof(proof) {
+			t.Errorf("proof with %s edited verified", name)
+		}
+	}
+}
+
+func TestMergeJournalIsBounded(t *testing.T) {
+	sm := NewShardManager()
+	blocks := fixedBlocks(2)
+	proofs := make([]MergeProof, DefaultMergeJournalCapacity+5)
+	for i := range proofs {
+		proofs[i] = NewMergeProof(mergeInput(i, blocks[:1]), mergeInput(i+1, blocks[1:]), mergeInput(i, blocks))
+	}
+	sm.recordMerges(proofs[:10])
+	sm.recordMerges(proofs[10:])
+	journal := sm.MergeProofs()
+	if len(journal) != DefaultMergeJournalCapacity || journal[0].LeftID != 5 {
+		t.Fatalf("journal of %d proofs starting with merge %d", len(journal), journal[0].LeftID)
+	}
+	journal[0].LeftID = -1
+	if sm.MergeProofs()[0].LeftID != 5 {
+		t.Fatal("MergeProofs returned the live journal")
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import "fmt"
+
+// Height returns the number of levels above the leaves, 0 for trees with at
+// most one leaf. Node (level, index) covers leaves [index<<level,
+// (index+1)<<level).
+func (mt *MerkleTree) Height() int {
+	if levels := mt.levels(); len(levels) > 0 {
+		return len(levels) - 1
+	}
+	return 0
+}
+
+// NodeHashes returns the hashes of the nodes at the given indices of a
+// level, with "" for nodes the tree does not have
+func (mt *MerkleTree) NodeHashes(level int, indices []int) []string {
+	return nodeHashes(mt.levels(), level, indices)
+}
+
+// Diff returns the indices of leaves that differ between the trees, in
+// ascending order, including leaves only one tree has. It descends only
+// into subtrees whose hashes differ, so k differing leaves cost
+// O(k log n) comparisons.
+func (mt *MerkleTree) Diff(other *MerkleTree) []int {
+	local, remote := mt.levels(), other.levels()
+	indices, _, _ := walkDiff(local, func(level int, nodes []int) ([]string, error) {
+		return nodeHashes(remote, level, nodes), nil
+	}, len(remote))
+	return indices
+}
+
+// nodeHashes looks up nodes in precomputed levels
+func nodeHashes(levels [][]string, level int, indices []int) []string {
+	hashes := make([]string, len(indices))
+	if level < 0 || level >= len(levels) {
+		return hashes
+	}
+	for i, index := range indices {
+		if index >= 0 && index < len(levels[level]) {
+			hashes[i] = levels[level][index]
+		}
+	}
+	return hashes
+}
+
+// walkDiff compares local levels against a remote tree of remoteLevels
+// levels top-down, fetching one level of remote hashes per call. It returns
+// the differing leaf indices and the number of hash comparisons made.
+func walkDiff(local [][]string, fetch func(level int, indices []int) ([]string, error), remoteLevels int) ([]int, int, error) {
+	top := len(local)
+	if remoteLevels > top {
+		top = remoteLevels
+	}
+	if top == 0 {
+		return nil, 0, nil
+	}
+
+	comparisons := 0
+	frontier := []int{0}
+	for level := top - 1; level >= 0; level-- {
+		remote, err := fetch(level, frontier)
+		if err != nil {
+			return nil, comparisons, err
+		}
+		if len(remote) != len(frontier) {
+			return nil, comparisons, fmt.Errorf("%w: %d hashes for %d nodes", ErrSyncMismatch, len(remote), len(frontier))
+		}
+		localHashes := nodeHashes(local, level, frontier)
+		var differing []int
+		for i, index := range frontier {
+			comparisons++
+			if localHashes[i] != remote[i] {
+				differing = append(differing, index)
+			}
+		}
+		if level == 0 {
+			return differing, comparisons, nil
+		}
+		frontier = frontier[:0:0]
+		for _, index := range differing {
+			frontier = append(frontier, 2*index, 2*index+1)
+		}
+		if len(frontier) == 0 {
+			return nil, comparisons, nil
+		}
+	}
+	return nil, comparisons, nil
+}
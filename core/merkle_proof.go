@@ -0,0 +1,349 @@
+package core
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// MultiProofNode is a sibling hash at a given position in the tree
+type MultiProofNode struct {
+	Level int    `json:"level"`
+	Index int    `json:"index"`
+	Hash  string `json:"hash"`
+}
+
+// MultiProof proves several leaves at once, sharing sibling hashes between them.
+// Nodes are sorted by level and then index.
+type MultiProof struct {
+	Version   TreeVersion      `json:"version"`
+	Hasher    string           `json:"hasher,omitempty"` // Hash function name, empty for SHA-256
+	LeafCount int              `json:"leaf_count"`
+	Indices   []int            `json:"indices"`
+	Nodes     []MultiProofNode `json:"nodes"`
+}
+
+// GetMultiProof builds a de-duplicated proof for the leaves at the given indices
+func (mt *MerkleTree) GetMultiProof(indices []int) (MultiProof, error) {
+	if len(indices) == 0 {
+		return MultiProof{}, fmt.Errorf("no leaf indices given")
+	}
+
+	known := make(map[int]bool)
+	for _, i := range indices {
+		if i < 0 || i >= len(mt.Leaves) {
+			return MultiProof{}, fmt.Errorf("leaf index %d out of range (leaf count: %d)", i, len(mt.Leaves))
+		}
+		known[i] = true
+	}
+
+	proof := MultiProof{Version: mt.version(), Hasher: hasherName(mt.hasher()), LeafCount: len(mt.Leaves)}
+	proof.Indices = sortedKeys(known)
+
+	levels := mt.levels()
+	for level := 0; level < len(levels)-1; level++ {
+		nodes := levels[level]
+		parents := make(map[int]bool)
+		for _, i := range sortedKeys(known) {
+			sibling := i ^ 1
+			if sibling < len(nodes) && !known[sibling] {
+				proof.Nodes = append(proof.Nodes, MultiProofNode{Level: level, Index: sibling, Hash: nodes[sibling]})
+				known[sibling] = true
+			}
+			parents[i/2] = true
+		}
+		known = parents
+	}
+
+	return proof, nil
+}
+
+// VerifyMultiProof checks that the given leaf data, keyed by leaf index,
+// belongs to the tree with the given root. The proof's indices must be
+// strictly ascending and in range, and name exactly the leaves given.
+func VerifyMultiProof(root string, leaves map[int]string, proof MultiProof) bool {
+	if len(leaves) == 0 || len(leaves) != len(proof.Indices) || proof.LeafCount <= 0 {
+		return false
+	}
+
+	version := proof.Version
+	if version == 0 {
+		version = TreeV1
+	}
+	h, err := LookupHasher(proof.Hasher)
+	if err != nil {
+		return false
+	}
+
+	known := make(map[int]string)
+	for n, i := range proof.Indices {
+		if i < 0 || i >= proof.LeafCount || (n > 0 && i <= proof.Indices[n-1]) {
+			return false
+		}
+		data, exists := leaves[i]
+		if !exists {
+			return false
+		}
+		known[i] = hashLeaf(h, version, data)
+	}
+
+	siblings := make(map[[2]int]string)
+	for _, n := range proof.Nodes {
+		siblings[[2]int{n.Level, n.Index}] = n.Hash
+	}
+
+	used := 0
+	size := proof.LeafCount
+	for level := 0; size > 1; level++ {
+		parents := make(map[int]string)
+		for _, i := range sortedKeys(known) {
+			p := i / 2
+			if _, done := parents[p]; done {
+				continue
+			}
+			left, right := 2*p, 2*p+1
+
+			leftHash, ok := lookupNode(known, siblings, level, left, &used)
+			if !ok {
+				return false
+			}
+			if right >= size {
+				parents[p] = hashNode(h, version, leftHash, "", false)
+				continue
+			}
+			rightHash, ok := lookupNode(known, siblings, level, right, &used)
+			if !ok {
+				return false
+			}
+			parents[p] = hashNode(h, version, leftHash, rightHash, true)
+		}
+		known = parents
+		size = (size + 1) / 2
+	}
+
+	// Every supplied node must have been needed, otherwise the proof is malformed
+	return used == len(proof.Nodes) && known[0] == root
+}
+
+// lookupNode finds a node hash either among computed nodes or proof siblings
+func lookupNode(known map[int]string, siblings map[[2]int]string, level, index int, used *int) (string, bool) {
+	if hash, exists := known[index]; exists {
+		return hash, true
+	}
+	if hash, exists := siblings[[2]int{level, index}]; exists {
+		*used++
+		return hash, true
+	}
+	return "", false
+}
+
+// sortedKeys returns the keys of an index set in ascending order
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// nodePos identifies a node by level (0 = leaves) and index within the level
+type nodePos struct {
+	Level int
+	Index int
+}
+
+// peakPositions returns the perfect subtrees covering the first size leaves,
+// largest first. These nodes are unchanged by later appends.
+func peakPositions(size int) []nodePos {
+	var positions []nodePos
+	offset := 0
+	for level := bits.Len(uint(size)) - 1; level >= 0; level-- {
+		if size&(1<<level) != 0 {
+			positions = append(positions, nodePos{Level: level, Index: offset >> level})
+			offset += 1 << level
+		}
+	}
+	return positions
+}
+
+// appendedPositions returns aligned nodes of a size-leaf tree covering [from, size)
+func appendedPositions(from, size int) []nodePos {
+	var positions []nodePos
+	height := bits.Len(uint(size - 1))
+	for pos := from; pos < size; {
+		level := height
+		if pos > 0 && bits.TrailingZeros(uint(pos)) < level {
+			level = bits.TrailingZeros(uint(pos))
+		}
+		positions = append(positions, nodePos{Level: level, Index: pos >> level})
+		pos += 1 << level
+	}
+	return positions
+}
+
+// foldCover computes the root of a size-leaf tree from nodes covering all
+// leaves, applying the same pairing rules as buildMerkleTree
+func foldCover(h Hasher, version TreeVersion, size int, nodes map[nodePos]string) (string, bool) {
+	for level := 0; ; level++ {
+		count := (size + (1 << level) - 1) >> level
+		var indices []int
+		for pos := range nodes {
+			if pos.Level == level {
+				indices = append(indices, pos.Index)
+			}
+		}
+		sort.Ints(indices)
+		if count == 1 {
+			root, ok := nodes[nodePos{Level: level, Index: 0}]
+			return root, ok && len(indices) == 1
+		}
+		for _, i := range indices {
+			p := nodePos{Level: level + 1, Index: i / 2}
+			if _, done := nodes[p]; done {
+				continue
+			}
+			left, okLeft := nodes[nodePos{Level: level, Index: 2 * p.Index}]
+			if !okLeft {
+				return "", false
+			}
+			if 2*p.Index+1 >= count {
+				nodes[p] = hashNode(h, version, left, "", false)
+				continue
+			}
+			right, okRight := nodes[nodePos{Level: level, Index: 2*p.Index + 1}]
+			if !okRight {
+				return "", false
+			}
+			nodes[p] = hashNode(h, version, left, right, true)
+		}
+	}
+}
+
+// ConsistencyProof proves that the tree's first oldSize leaves form the earlier
+// tree, i.e. the current root is an append-only extension of the old root.
+// The proof lists the old tree's peaks followed by nodes covering the new leaves.
+func (mt *MerkleTree) ConsistencyProof(oldSize int) ([]string, error) {
+	if oldSize < 0 || oldSize > len(mt.Leaves) {
+		return nil, fmt.Errorf("old size %d out of range (leaf count: %d)", oldSize, len(mt.Leaves))
+	}
+	if oldSize == 0 {
+		return []string{}, nil
+	}
+
+	levels := mt.levels()
+	var proof []string
+	for _, pos := range peakPositions(oldSize) {
+		proof = append(proof, levels[pos.Level][pos.Index])
+	}
+	for _, pos := range appendedPositions(oldSize, len(mt.Leaves)) {
+		proof = append(proof, levels[pos.Level][pos.Index])
+	}
+	return proof, nil
+}
+
+// VerifyConsistency checks a consistency proof between two roots of a tree
+// built with the current tree version and SHA-256
+func VerifyConsistency(oldRoot string, oldSize int, newRoot string, newSize int, proof []string) bool {
+	return verifyConsistency(defaultHasher, CurrentTreeVersion, oldRoot, oldSize, newRoot, newSize, proof)
+}
+
+func verifyConsistency(h Hasher, version TreeVersion, oldRoot string, oldSize int, newRoot string, newSize int, proof []string) bool {
+	if oldSize < 0 || oldSize > newSize {
+		return false
+	}
+	if oldSize == 0 {
+		return len(proof) == 0 && oldRoot == buildMerkleTree(h, version, nil)
+	}
+
+	peaks := peakPositions(oldSize)
+	appended := appendedPositions(oldSize, newSize)
+	if len(proof) != len(peaks)+len(appended) {
+		return false
+	}
+
+	oldNodes := make(map[nodePos]string)
+	newNodes := make(map[nodePos]string)
+	for i, pos := range peaks {
+		oldNodes[pos] = proof[i]
+		newNodes[pos] = proof[i]
+	}
+	for i, pos := range appended {
+		newNodes[pos] = proof[len(peaks)+i]
+	}
+
+	computedOld, ok := foldCover(h, version, oldSize, oldNodes)
+	if !ok || computedOld != oldRoot {
+		return false
+	}
+	computedNew, ok := foldCover(h, version, newSize, newNodes)
+	return ok && computedNew == newRoot
+}
+
+// MerkleProof is the authentication path for a single leaf. Siblings are
+// listed bottom-up; levels where the node has no sibling contribute nothing.
+type MerkleProof struct {
+	Version   TreeVersion `json:"version"`
+	Hasher    string      `json:"hasher,omitempty"` // Hash function name, empty for SHA-256
+	LeafCount int         `json:"leaf_count"`
+	Index     int         `json:"index"`
+	Siblings  []string    `json:"siblings"`
+}
+
+// GetProof builds the authentication path for the leaf at index
+func (mt *MerkleTree) GetProof(index int) (MerkleProof, error) {
+	if index < 0 || index >= len(mt.Leaves) {
+		return MerkleProof{}, fmt.Errorf("leaf index %d out of range (leaf count: %d)", index, len(mt.Leaves))
+	}
+
+	proof := MerkleProof{Version: mt.version(), Hasher: hasherName(mt.hasher()), LeafCount: len(mt.Leaves), Index: index, Siblings: []string{}}
+	levels := mt.levels()
+	for level := 0; level < len(levels)-1; level++ {
+		if sibling := index ^ 1; sibling < len(levels[level]) {
+			proof.Siblings = append(proof.Siblings, levels[level][sibling])
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that data is the leaf at proof.Index under root
+func VerifyMerkleProof(root, data string, proof MerkleProof) bool {
+	version := proof.Version
+	if version == 0 {
+		version = TreeV1
+	}
+	if proof.Index < 0 || proof.Index >= proof.LeafCount {
+		return false
+	}
+	h, err := LookupHasher(proof.Hasher)
+	if err != nil {
+		return false
+	}
+
+	hash := hashLeaf(h, version, data)
+	index, size, next := proof.Index, proof.LeafCount, 0
+	for ; size > 1; size = (size + 1) / 2 {
+		if sibling := index ^ 1; sibling < size {
+			if next >= len(proof.Siblings) {
+				return false
+			}
+			if index%2 == 0 {
+				hash = hashNode(h, version, hash, proof.Siblings[next], true)
+			} else {
+				hash = hashNode(h, version, proof.Siblings[next], hash, true)
+			}
+			next++
+		} else {
+			hash = hashNode(h, version, hash, "", false)
+		}
+		index /= 2
+	}
+	return next == len(proof.Siblings) && hash == root
+}
+
+// VerifyBlockProof checks that a block, full or header-only, is the shard
+// tree leaf at proof.Index under root
+func VerifyBlockProof(root string, block Block, proof MerkleProof) bool {
+	return VerifyMerkleProof(root, block.Header().leafData(), proof)
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// testLeaves returns n distinct leaf data strings
+func testLeaves(n int) []string {
+	data := make([]string, n)
+	for i := range data {
+		data[i] = fmt.Sprintf("tx-%d", i)
+	}
+	return data
+}
+
+func TestMultiProofIndexSets(t *testing.T) {
+	const n = 1024
+	data := testLeaves(n)
+	mt := NewMerkleTree(data)
+	cases := map[string][]int{
+		"first":     {0},
+		"last":      {n - 1},
+		"boundary":  {0, n - 1},
+		"adjacent":  {510, 511, 512, 513},
+		"pair":      {6, 7},
+		"scattered": {3, 97, 250, 511, 700, 1001},
+		"all even":  evenIndices(n),
+	}
+	for name, indices := range cases {
+		t.Run(name, func(t *testing.T) {
+			proof, err := mt.GetMultiProof(indices)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaves := make(map[int]string, len(indices))
+			for _, i := range indices {
+				leaves[i] = data[i]
+			}
+
+			// The proof survives a JSON round trip
+			encoded, err := json.Marshal(proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded MultiProof
+			if err := json.Unmarshal(encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyMultiProof(mt.Root, leaves, decoded) {
+				t.Fatal("valid multiproof rejected")
+			}
+
+			leaves[indices[0]] = "forged"
+			if VerifyMultiProof(mt.Root, leaves, decoded) {
+				t.Fatal("multiproof accepted forged leaf data")
+			}
+		})
+	}
+}
+
+func evenIndices(n int) []int {
+	var indices []int
+	for i := 0; i < n; i += 2 {
+		indices = append(indices, i)
+	}
+	return indices
+}
+
+func TestMultiProofOddSizedTrees(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 7, 13, 100} {
+		data := testLeaves(n)
+		mt := NewMerkleTree(data)
+		indices := []int{0, n / 2, n - 1}
+		proof, err := mt.GetMultiProof(indices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves := map[int]string{}
+		for _, i := range indices {
+			leaves[i] = data[i]
+		}
+		if !VerifyMultiProof(mt.Root, leaves, proof) {
+			t.Errorf("%d leaves: valid multiproof rejected", n)
+		}
+	}
+}
+
+func TestMultiProofRejectsMalformedProofs(t *testing.T) {
+	data := testLeaves(16)
+	mt := NewMerkleTree(data)
+	proof, err := mt.GetMultiProof([]int{2, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := map[int]string{2: data[2], 9: data[9]}
+
+	extra := proof
+	extra.Nodes = append(append([]MultiProofNode{}, proof.Nodes...), MultiProofNode{Level: 0, Index: 15, Hash: mt.Leaves[15]})
+	if VerifyMultiProof(mt.Root, leaves, extra) {
+		t.Error("proof with an unneeded node accepted")
+	}
+	if VerifyMultiProof(mt.Root, map[int]string{2: data[2]}, proof) {
+		t.Error("proof accepted with a leaf missing")
+	}
+
+	// A repeated index would leave a supplied leaf unhashed
+	single, err := mt.GetMultiProof([]int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := single
+	forged.Indices = []int{0, 0}
+	if VerifyMultiProof(mt.Root, map[int]string{0: data[0], 1: "FORGED"}, forged) {
+		t.Error("duplicate index let an unproven leaf through")
+	}
+	for _, indices := range [][]int{{9, 2}, {2, 2}, {2, 16}, {-1, 9}} {
+		bad := proof
+		bad.Indices = indices
+		if VerifyMultiProof(mt.Root, leaves, bad) {
+			t.Errorf("indices %v accepted", indices)
+		}
+	}
+	if _, err := mt.GetMultiProof([]int{16}); err == nil {
+		t.Error("out-of-range index accepted")
+	}
+	if _, err := mt.GetMultiProof(nil); err == nil {
+		t.Error("empty index set accepted")
+	}
+}
+
+func TestMultiProofSmallerThanNaiveProofs(t *testing.T) {
+	const n = 1024
+	mt := NewMerkleTree(testLeaves(n))
+	sets := map[string][]int{
+		"adjacent":  {96, 97, 98, 99, 100, 101, 102, 103},
+		"scattered": {1, 130, 260, 390, 520, 650, 780, 910, 1023},
+		"50 leaves": func() []int {
+			var indices []int
+			for i := 0; i < 50; i++ {
+				indices = append(indices, i*20)
+			}
+			return indices
+		}(),
+	}
+	for name, indices := range sets {
+		multi, err := mt.GetMultiProof(indices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		naiveHashes, naiveBytes := 0, 0
+		for _, i := range indices {
+			single, err := mt.GetProof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, _ := json.Marshal(single)
+			naiveHashes += len(single.Siblings)
+			naiveBytes += len(encoded)
+		}
+		encoded, _ := json.Marshal(multi)
+		t.Logf("%s: %d hashes (%d bytes) vs %d naive hashes (%d bytes)", name, len(multi.Nodes), len(encoded), naiveHashes, naiveBytes)
+		if len(multi.Nodes) >= naiveHashes || len(encoded) >= naiveBytes {
+			t.Errorf("%s: multiproof of %d hashes is no smaller than %d naive hashes", name, len(multi.Nodes), naiveHashes)
+		}
+	}
+
+	// Adjacent leaves share everything above their common ancestor
+	multi, _ := mt.GetMultiProof([]int{96, 97, 98, 99, 100, 101, 102, 103})
+	if len(multi.Nodes) != 7 {
+		t.Errorf("eight aligned adjacent leaves need %d hashes, want 7", len(multi.Nodes))
+	}
+}
+
+func TestConsistencyProofAllSizes(t *testing.T) {
+	// Every size up to 70 covers powers of two and the sizes between them
+	data := testLeaves(70)
+	for n := 1; n <= len(data); n++ {
+		newTree := NewMerkleTree(data[:n])
+		for m := 0; m <= n; m++ {
+			oldRoot := NewMerkleTree(data[:m]).Root
+			proof, err := newTree.ConsistencyProof(m)
+			if err != nil {
+				t.Fatalf("%d -> %d: %v", m, n, err)
+			}
+			if !VerifyConsistency(oldRoot, m, newTree.Root, n, proof) {
+				t.Fatalf("%d -> %d: valid consistency proof rejected", m, n)
+			}
+			if m == 0 || m == n {
+				continue
+			}
+
+			// An old tree whose last leaf was rewritten is not a prefix
+			rewritten := append(append([]string{}, data[:m-1]...), "rewritten")
+			if VerifyConsistency(NewMerkleTree(rewritten).Root, m, newTree.Root, n, proof) {
+				t.Fatalf("%d -> %d: proof accepted a rewritten history", m, n)
+			}
+			if VerifyConsistency(oldRoot, m, newTree.Root, n, proof[:len(proof)-1]) {
+				t.Fatalf("%d -> %d: truncated proof accepted", m, n)
+			}
+		}
+	}
+}
+
+func TestConsistencyProofPowerOfTwoBoundaries(t *testing.T) {
+	data := testLeaves(1024)
+	for _, sizes := range [][2]int{{1, 2}, {4, 8}, {8, 1024}, {512, 1024}, {7, 8}, {8, 9}, {511, 513}, {300, 1000}} {
+		m, n := sizes[0], sizes[1]
+		newTree := NewMerkleTree(data[:n])
+		proof, err := newTree.ConsistencyProof(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyConsistency(NewMerkleTree(data[:m]).Root, m, newTree.Root, n, proof) {
+			t.Errorf("%d -> %d: valid consistency proof rejected", m, n)
+		}
+		if VerifyConsistency(NewMerkleTree(data[:m]).Root, m+1, newTree.Root, n, proof) {
+			t.Errorf("%d -> %d: proof accepted for the wrong old size", m, n)
+		}
+	}
+	if _, err := NewMerkleTree(data[:5]).ConsistencyProof(6); err == nil {
+		t.Error("proof from a larger old size accepted")
+	}
+}
+
+func TestShardConsistencyProofAfterAddBlock(t *testing.T) {
+	shard := NewShard(0)
+	prev := GenesisBlock()
+	var blocks []Block
+	for i, data := range testLeaves(20) {
+		prev = mustGenerateBlock(t, prev, data)
+		oldRoot := newBlockTree(blocks).GetRootHash()
+		blocks = append(blocks, prev)
+		shard.AddBlock(prev)
+		if shard.PrevSize != i || shard.PrevRoot != oldRoot {
+			t.Fatalf("block %d: previous state %d/%s, want %d/%s", i, shard.PrevSize, shard.PrevRoot, i, oldRoot)
+		}
+		proof, err := shard.GetConsistencyProof()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyConsistency(shard.PrevRoot, shard.PrevSize, shard.GetRoot(), len(shard.Blocks), proof) {
+			t.Fatalf("block %d: shard consistency proof rejected", i)
+		}
+	}
+}
+
+func TestCommittedTransferCarriesConsistencyProof(t *testing.T) {
+	source, destination := NewShard(0), NewShard(1)
+	prev := GenesisBlock()
+	for i, data := range testLeaves(6) {
+		prev = mustGenerateBlock(t, prev, data)
+		if i%2 == 0 {
+			source.AddBlock(prev)
+		} else {
+			destination.AddBlock(prev)
+		}
+	}
+
+	esm := NewEnhancedSyncManager("key")
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	state, ok := esm.GetCommittedTransfer(TransferID("", source.ID, destination.ID, 1))
+	if !ok {
+		t.Fatal("committed transfer not recorded")
+	}
+	if state.DestOldSize != 3 || len(destination.Blocks) != 4 {
+		t.Fatalf("destination grew from %d to %d blocks", state.DestOldSize, len(destination.Blocks))
+	}
+	if !VerifyConsistency(state.DestOldRoot, state.DestOldSize, destination.GetRoot(), len(destination.Blocks), state.DestProof) {
+		t.Fatal("committed transfer's consistency proof rejected")
+	}
+}
@@ -1,67 +1,269 @@
 package core
 
-import (
-	"crypto/sha256"
-	"encoding/hex"
+// TreeVersion selects the hashing scheme used to build a Merkle Tree
+type TreeVersion int
+
+const (
+	// TreeV1 hashes leaves and internal nodes identically (legacy roots)
+	TreeV1 TreeVersion = 1
+	// TreeV2 prefixes leaves with 0x00 and internal nodes with 0x01 (RFC 6962)
+	TreeV2 TreeVersion = 2
+)
+
+// CurrentTreeVersion is the version used for newly built trees
+const CurrentTreeVersion = TreeV2
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
 )
 
 // MerkleTree represents a Merkle Tree for efficient data verification
 type MerkleTree struct {
-	Root   string
-	Leaves []string
+	Root    string
+	Leaves  []string
+	Version TreeVersion // Zero value is treated as TreeV1
+	peaks   []string    // Perfect subtree roots indexed by height ("" if absent)
+	hashFn  Hasher      // nil is SHA-256
+}
+
+// TreeOption configures a MerkleTree
+type TreeOption func(*MerkleTree)
+
+// WithTreeHasher builds the tree with a hash function other than SHA-256.
+// Its proofs name the function so verifiers hash with the same one.
+func WithTreeHasher(h Hasher) TreeOption {
+	return func(mt *MerkleTree) {
+		mt.hashFn = h
+	}
 }
 
 // NewMerkleTree creates a new Merkle Tree from a slice of data
-func NewMerkleTree(data []string) *MerkleTree {
+func NewMerkleTree(data []string, opts ...TreeOption) *MerkleTree {
+	return NewMerkleTreeVersion(data, CurrentTreeVersion, opts...)
+}
+
+// NewMerkleTreeVersion creates a Merkle Tree using a specific hashing version
+func NewMerkleTreeVersion(data []string, version TreeVersion, opts ...TreeOption) *MerkleTree {
+	mt := &MerkleTree{Leaves: []string{}, Version: version}
+	for _, opt := range opts {
+		opt(mt)
+	}
 	if len(data) == 0 {
-		// Return a tree with a default root (hash of empty string)
-		hash := sha256.Sum256([]byte(""))
-		return &MerkleTree{
-			Root:   hex.EncodeToString(hash[:]),
-			Leaves: []string{},
-		}
+		// Default root (hash of empty string)
+		mt.Root = buildMerkleTree(mt.hasher(), version, nil)
+		return mt
 	}
 
 	// Create leaf nodes
-	leaves := make([]string, len(data))
+	mt.Leaves = make([]string, len(data))
 	for i, d := range data {
-		hash := sha256.Sum256([]byte(d))
-		leaves[i] = hex.EncodeToString(hash[:])
+		mt.Leaves[i] = hashLeaf(mt.hasher(), version, d)
 	}
 
 	// Build the tree
-	return &MerkleTree{
-		Root:   buildMerkleTree(leaves),
-		Leaves: leaves,
+	mt.Root = buildMerkleTree(mt.hasher(), version, mt.Leaves)
+	return mt
+}
+
+// NewMerkleTreeFromLeaves creates a current-version tree from leaf hashes
+// computed beforehand, such as the DataHash of block headers
+func NewMerkleTreeFromLeaves(leaves []string, opts ...TreeOption) *MerkleTree {
+	mt := &MerkleTree{Leaves: append([]string{}, leaves...), Version: CurrentTreeVersion}
+	for _, opt := range opts {
+		opt(mt)
+	}
+	mt.Root = buildMerkleTree(mt.hasher(), CurrentTreeVersion, mt.Leaves)
+	return mt
+}
+
+// ValidateRoot checks a persisted root against data under every known version,
+// returning the version that produced it
+func ValidateRoot(data []string, root string) (TreeVersion, bool) {
+	for _, version := range []TreeVersion{TreeV2, TreeV1} {
+		if NewMerkleTreeVersion(data, version).Root == root {
+			return version, true
+		}
 	}
+	return 0, false
 }
 
 // buildMerkleTree constructs the Merkle Tree and returns the root hash
-func buildMerkleTree(leaves []string) string {
+func buildMerkleTree(h Hasher, version TreeVersion, leaves []string) string {
 	if len(leaves) == 0 {
-		hash := sha256.Sum256([]byte(""))
-		return hex.EncodeToString(hash[:])
+		return hexSum(h, []byte(""))
 	}
 
 	if len(leaves) == 1 {
 		return leaves[0]
 	}
 
-	// Build parent nodes
+	// Recursively build the tree
+	return buildMerkleTree(h, version, parentLevel(h, version, leaves))
+}
+
+// hashLeaf hashes raw data into a leaf node
+func hashLeaf(h Hasher, version TreeVersion, data string) string {
+	var preimage []byte
+	if version >= TreeV2 {
+		preimage = append(preimage, leafPrefix)
+	}
+	preimage = append(preimage, []byte(data)...)
+	return hexSum(h, preimage)
+}
+
+// hashNode hashes a pair of children; a lone child (odd level) is hashed alone
+func hashNode(h Hasher, version TreeVersion, left, right string, hasRight bool) string {
+	var combined []byte
+	if version >= TreeV2 {
+		combined = append(combined, nodePrefix)
+	}
+	combined = append(combined, []byte(left)...)
+	if hasRight {
+		combined = append(combined, []byte(right)...)
+	}
+	return hexSum(h, combined)
+}
+
+// parentLevel computes the parent nodes of one tree level
+func parentLevel(h Hasher, version TreeVersion, nodes []string) []string {
 	var parents []string
-	for i := 0; i < len(leaves); i += 2 {
-		var combined []byte
-		if i+1 < len(leaves) {
-			combined = append([]byte(leaves[i]), []byte(leaves[i+1])...)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 < len(nodes) {
+			parents = append(parents, hashNode(h, version, nodes[i], nodes[i+1], true))
 		} else {
-			combined = []byte(leaves[i])
+			parents = append(parents, hashNode(h, version, nodes[i], "", false))
 		}
-		hash := sha256.Sum256(combined)
-		parents = append(parents, hex.EncodeToString(hash[:]))
 	}
+	return parents
+}
 
-	// Recursively build the tree
-	return buildMerkleTree(parents)
+// levels returns every level of the tree, leaves first and root last
+func (mt *MerkleTree) levels() [][]string {
+	if len(mt.Leaves) == 0 {
+		return nil
+	}
+	levels := [][]string{mt.Leaves}
+	for current := mt.Leaves; len(current) > 1; {
+		current = parentLevel(mt.hasher(), mt.version(), current)
+		levels = append(levels, current)
+	}
+	return levels
+}
+
+// version returns the tree's hashing version, defaulting to TreeV1
+func (mt *MerkleTree) version() TreeVersion {
+	if mt.Version == 0 {
+		return TreeV1
+	}
+	return mt.Version
+}
+
+// hasher returns the tree's hash function, defaulting to SHA-256
+func (mt *MerkleTree) hasher() Hasher {
+	if mt.hashFn == nil {
+		return defaultHasher
+	}
+	return mt.hashFn
+}
+
+// HashFunction returns the name of the hash function the tree is built with
+func (mt *MerkleTree) HashFunction() string {
+	return mt.hasher().Name()
+}
+
+// HashData hashes data into a leaf using this tree's version
+func (mt *MerkleTree) HashData(data string) string {
+	return hashLeaf(mt.hasher(), mt.version(), data)
+}
+
+// leafOf returns the shard tree leaf for a block under this tree's
+// version, see BlockHeader.Leaf
+func (mt *MerkleTree) leafOf(b Block) string {
+	return mt.HashData(b.Header().leafData())
+}
+
+// AppendLeaf adds data as a new leaf in O(log n) by maintaining the frontier
+// of perfect subtree peaks instead of rebuilding the whole tree
+func (mt *MerkleTree) AppendLeaf(data string) {
+	mt.AppendLeafHash(mt.HashData(data))
+}
+
+// AppendLeafHash adds an already hashed leaf in O(log n)
+func (mt *MerkleTree) AppendLeafHash(leaf string) {
+	if mt.peaks == nil {
+		mt.rebuildPeaks()
+	}
+	mt.Leaves = append(mt.Leaves, leaf)
+	mt.pushPeak(leaf)
+	mt.Root = mt.foldPeaks()
+}
+
+// rebuildPeaks recomputes the frontier from the current leaves
+func (mt *MerkleTree) rebuildPeaks() {
+	mt.peaks = []string{}
+	for _, leaf := range mt.Leaves {
+		mt.pushPeak(leaf)
+	}
+}
+
+// pushPeak merges a new leaf into the frontier like a binary counter increment
+func (mt *MerkleTree) pushPeak(leaf string) {
+	mt.peaks = pushFrontier(mt.hasher(), mt.version(), mt.peaks, leaf)
+}
+
+// foldPeaks combines the frontier into the root
+func (mt *MerkleTree) foldPeaks() string {
+	return foldFrontier(mt.hasher(), mt.version(), mt.peaks)
+}
+
+// frontier returns a copy of the perfect subtree peaks indexed by height
+func (mt *MerkleTree) frontier() []string {
+	if mt.peaks == nil {
+		mt.rebuildPeaks()
+	}
+	return append([]string{}, mt.peaks...)
+}
+
+// pushFrontier merges a leaf into peaks, reusing its storage
+func pushFrontier(h Hasher, version TreeVersion, peaks []string, leaf string) []string {
+	carry := leaf
+	height := 0
+	for height < len(peaks) && peaks[height] != "" {
+		carry = hashNode(h, version, peaks[height], carry, true)
+		peaks[height] = ""
+		height++
+	}
+	if height == len(peaks) {
+		peaks = append(peaks, "")
+	}
+	peaks[height] = carry
+	return peaks
+}
+
+// foldFrontier combines peaks into the root. A partial subtree on the right
+// is hashed alone at each level it has no sibling, matching buildMerkleTree.
+func foldFrontier(h Hasher, version TreeVersion, peaks []string) string {
+	acc := ""
+	accHeight := 0
+	for height, peak := range peaks {
+		if peak == "" {
+			continue
+		}
+		if acc == "" {
+			acc, accHeight = peak, height
+			continue
+		}
+		for ; accHeight < height; accHeight++ {
+			acc = hashNode(h, version, acc, "", false)
+		}
+		acc = hashNode(h, version, peak, acc, true)
+		accHeight++
+	}
+	if acc == "" {
+		return buildMerkleTree(h, version, nil)
+	}
+	return acc
 }
 
 // GetRootHash returns the Merkle Tree root
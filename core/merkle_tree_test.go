@@ -0,0 +1,115 @@
+package core
+
+import (
+	"testing"
+)
+
+// secondPreimageForgery presents the children of the root's left child as
+// one leaf of a two-leaf tree, proving data that was never inserted
+func secondPreimageForgery(mt *MerkleTree) (string, MerkleProof) {
+	levels := mt.levels()
+	internal := levels[1]
+	fake := mt.Leaves[0] + mt.Leaves[1]
+	proof := MerkleProof{Version: mt.Version, LeafCount: 2, Index: 0, Siblings: []string{internal[1]}}
+	return fake, proof
+}
+
+func TestTreeV1SecondPreimageForgery(t *testing.T) {
+	data := []string{"a", "b", "c", "d"}
+	legacy := NewMerkleTreeVersion(data, TreeV1)
+	fake, proof := secondPreimageForgery(legacy)
+	if !VerifyMerkleProof(legacy.Root, fake, proof) {
+		t.Fatal("expected the legacy construction to accept the forged leaf")
+	}
+}
+
+func TestTreeV2RejectsSecondPreimageForgery(t *testing.T) {
+	data := []string{"a", "b", "c", "d"}
+	current := NewMerkleTree(data)
+	fake, proof := secondPreimageForgery(current)
+	if VerifyMerkleProof(current.Root, fake, proof) {
+		t.Fatal("forged leaf verified under domain-separated hashing")
+	}
+	if forged := NewMerkleTree([]string{fake, current.Leaves[2] + current.Leaves[3]}); forged.Root == current.Root {
+		t.Fatal("tree over internal node preimages has the same root")
+	}
+}
+
+func TestValidateRootDetectsVersion(t *testing.T) {
+	data := []string{"a", "b", "c"}
+	for _, version := range []TreeVersion{TreeV1, TreeV2} {
+		root := NewMerkleTreeVersion(data, version).Root
+		got, ok := ValidateRoot(data, root)
+		if !ok || got != version {
+			t.Errorf("root of version %d validated as %d, %v", version, got, ok)
+		}
+	}
+	if _, ok := ValidateRoot(data, NewMerkleTree([]string{"x"}).Root); ok {
+		t.Error("unrelated root validated")
+	}
+	if NewMerkleTreeVersion(data, TreeV1).Root == NewMerkleTree(data).Root {
+		t.Error("versions produce the same root")
+	}
+}
+
+func TestAppendLeafMatchesFromScratch(t *testing.T) {
+	for _, version := range []TreeVersion{TreeV1, TreeV2} {
+		data := testLeaves(1000)
+		mt := NewMerkleTreeVersion(nil, version)
+		for n := 1; n <= len(data); n++ {
+			mt.AppendLeaf(data[n-1])
+			if want := NewMerkleTreeVersion(data[:n], version).Root; mt.GetRootHash() != want {
+				t.Fatalf("version %d, %d leaves: appended root %s, from scratch %s", version, n, mt.GetRootHash(), want)
+			}
+		}
+	}
+}
+
+func TestAppendLeafExtendsBuiltTree(t *testing.T) {
+	data := testLeaves(37)
+	mt := NewMerkleTree(data[:20])
+	for _, d := range data[20:] {
+		mt.AppendLeaf(d)
+	}
+	if want := NewMerkleTree(data).Root; mt.Root != want {
+		t.Fatalf("root after appending to a built tree %s, want %s", mt.Root, want)
+	}
+	proof, err := mt.GetProof(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyMerkleProof(mt.Root, data[30], proof) {
+		t.Fatal("proof of an appended leaf rejected")
+	}
+}
+
+func BenchmarkAppendLeaf(b *testing.B) {
+	data := testLeaves(b.N)
+	mt := NewMerkleTree(nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mt.AppendLeaf(data[i])
+	}
+}
+
+func BenchmarkRebuildPerAppend(b *testing.B) {
+	data := testLeaves(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewMerkleTree(data[:i%len(data)+1])
+	}
+}
+
+func TestShardAddBlockAppendsIncrementally(t *testing.T) {
+	shard := NewShard(0)
+	prev := GenesisBlock()
+	var blocks []Block
+	for i := 0; i < 40; i++ {
+		prev = mustGenerateBlock(t, prev, testLeaves(40)[i])
+		blocks = append(blocks, prev)
+		shard.AddBlock(prev)
+		if want := newBlockTree(blocks).GetRootHash(); shard.GetRoot() != want {
+			t.Fatalf("shard root after %d blocks %s, rebuilt %s", i+1, shard.GetRoot(), want)
+		}
+	}
+}
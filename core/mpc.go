@@ -2,8 +2,6 @@ package core
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"math/big"
 )
@@ -14,19 +12,30 @@ type MPCProtocol struct {
 	Threshold    int
 	SecretShares map[int]*big.Int // Node ID -> Secret share
 	Prime        *big.Int         // Prime modulus for Shamir's secret sharing
+	keyShares    map[int]*big.Int // Threshold signing key share held by each participant
+	publicShares map[int]PublicKey
+	groupKey     PublicKey
 }
 
-// NewMPCProtocol creates a new MPC protocol instance
-func NewMPCProtocol(participants []*Node, threshold int) *MPCProtocol {
+// NewMPCProtocol creates a new MPC protocol instance. The threshold must
+// be between 1 and the number of participants.
+func NewMPCProtocol(participants []*Node, threshold int) (*MPCProtocol, error) {
+	if err := checkThreshold(threshold, len(participants)); err != nil {
+		return nil, err
+	}
+
 	// Generate a large prime for the field
-	prime, _ := rand.Prime(rand.Reader, 256)
+	prime, err := rand.Prime(rand.Reader, 256)
+	if err != nil {
+		return nil, err
+	}
 	
 	return &MPCProtocol{
 		Participants: participants,
 		Threshold:    threshold,
 		SecretShares: make(map[int]*big.Int),
 		Prime:        prime,
-	}
+	}, nil
 }
 
 // generatePolynomial creates a random polynomial f(x) of degree threshold-1
@@ -140,48 +149,31 @@ func (mpc *MPCProtocol) ReconstructSecret(shares map[int]*big.Int) (*big.Int, er
 	return secret, nil
 }
 
-// SimulateMPCSignature demonstrates threshold signing
-func (mpc *MPCProtocol) SimulateMPCSignature(message string) string {
+// SimulateMPCSignature demonstrates threshold signing: the participants
+// generate a group key without any of them learning the secret, and honest
+// participants sign with their key shares
+func (mpc *MPCProtocol) SimulateMPCSignature(message string) (Signature, error) {
 	fmt.Println("\nSimulating Multi-Party Computation Threshold Signing...")
-	
-	// Generate a random secret key
-	secretKey, _ := rand.Int(rand.Reader, big.NewInt(1).Exp(big.NewInt(2), big.NewInt(128), nil))
-	fmt.Println("Original Secret Key:", secretKey.String())
-	
-	// Share the secret among participants
-	mpc.ShareSecret(secretKey)
-	fmt.Printf("Secret shared among %d participants with threshold %d\n", 
-		len(mpc.Participants), mpc.Threshold)
-	
-	// Simulate some participants coming together to sign
-	collectedShares := make(map[int]*big.Int)
-	participantCount := 0
-	
+
+	group, err := mpc.GenerateThresholdKey()
+	if err != nil {
+		return Signature{}, err
+	}
+	fmt.Printf("Group key generated among %d participants with threshold %d: %x\n",
+		len(mpc.Participants), mpc.Threshold, group.Bytes())
+
+	var signers []int
 	for _, node := range mpc.Participants {
-		if !node.Byzantine && participantCount < mpc.Threshold+1 {
-			share := mpc.SecretShares[node.ID]
-			collectedShares[node.ID] = share
-			participantCount++
+		if !node.Byzantine && len(signers) < mpc.Threshold {
+			signers = append(signers, node.ID)
 		}
 	}
-	
-	fmt.Printf("Collected %d shares from honest participants\n", len(collectedShares))
-	
-	// Reconstruct the secret
-	reconstructed, err := mpc.ReconstructSecret(collectedShares)
+	fmt.Printf("Signing with honest participants %v\n", signers)
+
+	signature, err := mpc.SignThreshold(message, signers)
 	if err != nil {
-		fmt.Println("Error reconstructing secret:", err)
-		return ""
+		return Signature{}, err
 	}
-	
-	fmt.Println("Reconstructed Secret Key:", reconstructed.String())
-	
-	// Use the reconstructed key to "sign" the message
-	h := sha256.New()
-	h.Write([]byte(message))
-	h.Write(reconstructed.Bytes())
-	signature := hex.EncodeToString(h.Sum(nil))
-	
-	fmt.Println("Threshold Signature:", signature)
-	return signature
-}
\ No newline at end of file
+	fmt.Printf("Threshold Signature: R=%x z=%x\n", signature.R.Bytes(), signature.Z)
+	return signature, nil
+}
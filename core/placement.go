@@ -0,0 +1,249 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Errors returned when placing shards on nodes
+var (
+	ErrStaleMove     = errors.New("shard is no longer on the planned source node")
+	ErrHandoffFailed = errors.New("shard handoff does not match its root")
+)
+
+// DefaultMaxMoveFraction is the share of shards a plan may move at once
+const DefaultMaxMoveFraction = 0.25
+
+// ShardMove reassigns one shard; FromNode is empty for unassigned shards
+type ShardMove struct {
+	ShardID  int    `json:"shard_id"`
+	FromNode string `json:"from_node"`
+	ToNode   string `json:"to_node"`
+}
+
+// MigrationPlan is the set of moves for one planner invocation. Deferred
+// counts moves left out by the move limit, to be planned on a later call.
+type MigrationPlan struct {
+	Moves    []ShardMove `json:"moves"`
+	Deferred int         `json:"deferred"`
+}
+
+// ShardHandoff ships a shard's contents to the node taking it over and
+// returns once that node holds them
+type ShardHandoff func(move ShardMove, view ShardView) error
+
+// PlacementPlanner balances shards over nodes in proportion to capacity
+// while moving as few shards as possible
+type PlacementPlanner struct {
+	capacity        *AdaptiveCapacityManager // Optional; nil weighs nodes equally
+	maxMoveFraction float64
+}
+
+// PlacementOption configures a PlacementPlanner
+type PlacementOption func(*PlacementPlanner)
+
+// WithMaxMoveFraction bounds the share of shards a single plan may move.
+// At least one shard may always move.
+func WithMaxMoveFraction(fraction float64) PlacementOption {
+	return func(p *PlacementPlanner) {
+		p.maxMoveFraction = fraction
+	}
+}
+
+// NewPlacementPlanner creates a planner weighing nodes by the capacities
+// acm reports
+func NewPlacementPlanner(acm *AdaptiveCapacityManager, opts ...PlacementOption) *PlacementPlanner {
+	p := &PlacementPlanner{capacity: acm, maxMoveFraction: DefaultMaxMoveFraction}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Plan computes moves that bring every node to its capacity-proportional
+// share of the shards in assignments. Nodes defaults to the nodes in the
+// capacity manager's global view. Shards on nodes that left, or without a
+// node, move first; then overloaded nodes hand their highest shard IDs to
+// the most underloaded nodes. Shards already within quota never move.
+func (p *PlacementPlanner) Plan(assignments map[int]string, nodes []string) MigrationPlan {
+	if len(nodes) == 0 && p.capacity != nil {
+		for node := range p.capacity.GetGlobalView() {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 || len(assignments) == 0 {
+		return MigrationPlan{}
+	}
+	nodes = append([]string{}, nodes...)
+	sort.Strings(nodes)
+
+	quotas := p.quotas(nodes, len(assignments))
+	hosted := make(map[string][]int, len(nodes))
+	var homeless []ShardMove
+	for _, id := range sortedShardIDs(assignments) {
+		node := assignments[id]
+		if _, member := quotas[node]; member {
+			hosted[node] = append(hosted[node], id)
+		} else {
+			homeless = append(homeless, ShardMove{ShardID: id, FromNode: node})
+		}
+	}
+
+	// Overloaded nodes give up their highest shard IDs
+	surplus := homeless
+	for _, node := range nodes {
+		for len(hosted[node]) > quotas[node] {
+			last := len(hosted[node]) - 1
+			surplus = append(surplus, ShardMove{ShardID: hosted[node][last], FromNode: node})
+			hosted[node] = hosted[node][:last]
+		}
+	}
+
+	limit := int(p.maxMoveFraction * float64(len(assignments)))
+	if limit < 1 {
+		limit = 1
+	}
+	var plan MigrationPlan
+	for _, move := range surplus {
+		if len(plan.Moves) == limit {
+			plan.Deferred++
+			continue
+		}
+		move.ToNode = mostUnderloaded(nodes, quotas, hosted)
+		hosted[move.ToNode] = append(hosted[move.ToNode], move.ShardID)
+		plan.Moves = append(plan.Moves, move)
+	}
+	return plan
+}
+
+// quotas splits total shards over nodes in proportion to capacity by the
+// largest remainder method
+func (p *PlacementPlanner) quotas(nodes []string, total int) map[string]int {
+	weights := make([]float64, len(nodes))
+	sum := 0.0
+	for i, node := range nodes {
+		weights[i] = 1
+		if p.capacity != nil {
+			weights[i] = math.Max(p.capacity.GetNodeCapacity(node), 0)
+		}
+		sum += weights[i]
+	}
+	if sum == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		sum = float64(len(weights))
+	}
+
+	quotas := make(map[string]int, len(nodes))
+	remainders := make([]float64, len(nodes))
+	assigned := 0
+	for i, node := range nodes {
+		exact := float64(total) * weights[i] / sum
+		quotas[node] = int(exact)
+		remainders[i] = exact - float64(quotas[node])
+		assigned += quotas[node]
+	}
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := 0; assigned < total; i++ {
+		quotas[nodes[order[i%len(order)]]]++
+		assigned++
+	}
+	return quotas
+}
+
+// mostUnderloaded returns the node furthest below its quota
+func mostUnderloaded(nodes []string, quotas map[string]int, hosted map[string][]int) string {
+	best, bestDeficit := nodes[0], math.MinInt
+	for _, node := range nodes {
+		if deficit := quotas[node] - len(hosted[node]); deficit > bestDeficit {
+			best, bestDeficit = node, deficit
+		}
+	}
+	return best
+}
+
+func sortedShardIDs(assignments map[int]string) []int {
+	ids := make([]int, 0, len(assignments))
+	for id := range assignments {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// AssignShardToNode records which node hosts a shard
+func (sm *ShardManager) AssignShardToNode(shardID int, nodeID string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if _, exists := sm.Shards.FindShard(shardID); !exists {
+		return fmt.Errorf("%w: #%d", ErrShardNotFound, shardID)
+	}
+	if sm.assignments == nil {
+		sm.assignments = make(map[int]string)
+	}
+	sm.assignments[shardID] = nodeID
+	return nil
+}
+
+// ShardAssignments returns the node hosting each shard, with every current
+// shard present and "" for shards not yet assigned. Assignments of shards
+// removed by merging are dropped.
+func (sm *ShardManager) ShardAssignments() map[int]string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	assignments := make(map[int]string, sm.Shards.Size())
+	for _, shard := range sm.Shards.GetAllShards() {
+		assignments[shard.ID] = sm.assignments[shard.ID]
+	}
+	return assignments
+}
+
+// ExecutePlan applies a plan's moves in order. Each shard is handed off to
+// its new node through handoff, or checked locally when handoff is nil; the
+// shipped blocks must rebuild the shard's root before the assignment
+// changes. It stops at the first failed move and returns how many moves
+// were applied.
+func (sm *ShardManager) ExecutePlan(plan MigrationPlan, handoff ShardHandoff) (int, error) {
+	for i, move := range plan.Moves {
+		if err := sm.executeMove(move, handoff); err != nil {
+			return i, err
+		}
+	}
+	return len(plan.Moves), nil
+}
+
+// executeMove hands one shard to its new node while holding the manager
+// lock, so the shard cannot change mid-transfer
+func (sm *ShardManager) executeMove(move ShardMove, handoff ShardHandoff) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	shard, exists := sm.Shards.FindShard(move.ShardID)
+	if !exists {
+		return fmt.Errorf("%w: #%d", ErrShardNotFound, move.ShardID)
+	}
+	if current := sm.assignments[move.ShardID]; current != move.FromNode {
+		return fmt.Errorf("%w: shard #%d is on %q, plan expects %q", ErrStaleMove, move.ShardID, current, move.FromNode)
+	}
+	view := shard.View()
+	if view.BlockCount > 0 && newBlockTree(view.Blocks).GetRootHash() != view.Root {
+		return fmt.Errorf("%w: shard #%d", ErrHandoffFailed, move.ShardID)
+	}
+	if handoff != nil {
+		if err := handoff(move, view); err != nil {
+			return fmt.Errorf("shard #%d to %s: %w", move.ShardID, move.ToNode, err)
+		}
+	}
+	if sm.assignments == nil {
+		sm.assignments = make(map[int]string)
+	}
+	sm.assignments[move.ShardID] = move.ToNode
+	return nil
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// evenPlacement spreads shards round-robin over nodes n0..n(count-1)
+func evenPlacement(nodes, shards int) ([]string, map[int]string) {
+	names := make([]string, nodes)
+	for i := range names {
+		names[i] = fmt.Sprintf("n%d", i)
+	}
+	assignments := make(map[int]string, shards)
+	for id := 0; id < shards; id++ {
+		assignments[id] = names[id%nodes]
+	}
+	return names, assignments
+}
+
+// applyPlan returns assignments after the plan's moves
+func applyPlan(assignments map[int]string, plan MigrationPlan) map[int]string {
+	next := make(map[int]string, len(assignments))
+	for id, node := range assignments {
+		next[id] = node
+	}
+	for _, move := range plan.Moves {
+		next[move.ShardID] = move.ToNode
+	}
+	return next
+}
+
+func TestAddingNodeToTenMovesMinimalShards(t *testing.T) {
+	planner := NewPlacementPlanner(nil, WithMaxMoveFraction(1))
+	for _, shards := range []int{110, 100, 37} {
+		nodes, assignments := evenPlacement(10, shards)
+		if plan := planner.Plan(assignments, nodes); len(plan.Moves) != 0 {
+			t.Fatalf("%d balanced shards: plan moves %v", shards, plan.Moves)
+		}
+
+		nodes = append(nodes, "joined")
+		plan := planner.Plan(assignments, nodes)
+		// Only the joining node's share moves, and no node gives up more
+		// than its own excess
+		if low, high := shards/11, (shards+10)/11; len(plan.Moves) < low || len(plan.Moves) > high || plan.Deferred != 0 {
+			t.Fatalf("%d shards: %d moves, %d deferred, want %d to %d moves", shards, len(plan.Moves), plan.Deferred, low, high)
+		}
+		donors := make(map[string]int)
+		for _, move := range plan.Moves {
+			if move.ToNode != "joined" || assignments[move.ShardID] != move.FromNode {
+				t.Fatalf("%d shards: move %+v", shards, move)
+			}
+			donors[move.FromNode]++
+		}
+		for node, gave := range donors {
+			if gave > 1 {
+				t.Fatalf("%d shards: %s gave up %d shards", shards, node, gave)
+			}
+		}
+
+		// The result is balanced, so planning again moves nothing
+		balanced := applyPlan(assignments, plan)
+		if again := planner.Plan(balanced, nodes); len(again.Moves) != 0 {
+			t.Fatalf("%d shards: second plan moves %v", shards, again.Moves)
+		}
+	}
+}
+
+func TestRemovedNodeShardsMoveFirst(t *testing.T) {
+	nodes, assignments := evenPlacement(10, 100)
+	assignments[100] = "" // Not yet assigned
+	plan := NewPlacementPlanner(nil, WithMaxMoveFraction(1)).Plan(assignments, nodes[1:])
+
+	if len(plan.Moves) != 11 {
+		t.Fatalf("%d moves after n0 left", len(plan.Moves))
+	}
+	for _, move := range plan.Moves {
+		if move.FromNode != "n0" && move.FromNode != "" {
+			t.Fatalf("move %+v from a remaining node", move)
+		}
+		if move.ToNode == "n0" || move.ToNode == "" {
+			t.Fatalf("move %+v to no live node", move)
+		}
+	}
+	counts := make(map[string]int)
+	for _, node := range applyPlan(assignments, plan) {
+		counts[node]++
+	}
+	for _, node := range nodes[1:] {
+		if counts[node] < 11 || counts[node] > 12 {
+			t.Fatalf("%s hosts %d of 101 shards", node, counts[node])
+		}
+	}
+}
+
+func TestPlanMoveLimitDefersExcess(t *testing.T) {
+	nodes, assignments := evenPlacement(10, 110)
+	nodes = append(nodes, "joined")
+	planner := NewPlacementPlanner(nil, WithMaxMoveFraction(0.05))
+
+	moved := 0
+	for round := 0; ; round++ {
+		plan := planner.Plan(assignments, nodes)
+		if len(plan.Moves) > 5 {
+			t.Fatalf("round %d moves %d of 110 shards", round, len(plan.Moves))
+		}
+		if len(plan.Moves) == 0 {
+			break
+		}
+		if want := 10 - moved - len(plan.Moves); plan.Deferred != want {
+			t.Fatalf("round %d deferred %d, want %d", round, plan.Deferred, want)
+		}
+		moved += len(plan.Moves)
+		assignments = applyPlan(assignments, plan)
+	}
+	if moved != 10 {
+		t.Fatalf("moved %d shards in total", moved)
+	}
+
+	// A tiny fraction still moves one shard at a time
+	_, assignments = evenPlacement(10, 110)
+	if plan := NewPlacementPlanner(nil, WithMaxMoveFraction(0)).Plan(assignments, nodes); len(plan.Moves) != 1 {
+		t.Fatalf("zero fraction moved %d shards", len(plan.Moves))
+	}
+}
+
+func TestExecutePlanHandsOffShards(t *testing.T) {
+	sm := NewShardManager()
+	for _, b := range fixedBlocks(25) {
+		sm.DistributeBlock(b)
+	}
+	if err := sm.AssignShardToNode(9999, "a"); !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("assigning a missing shard: %v", err)
+	}
+	for id := range sm.ShardAssignments() {
+		if err := sm.AssignShardToNode(id, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	acm := NewAdaptiveCapacityManager("a")
+	acm.RecordMetrics(NetworkMetrics{NodeID: "a"})
+	acm.RecordMetrics(NetworkMetrics{NodeID: "b"})
+	plan := NewPlacementPlanner(acm, WithMaxMoveFraction(1)).Plan(sm.ShardAssignments(), nil)
+	if len(plan.Moves) == 0 {
+		t.Fatal("no moves towards the new node")
+	}
+
+	handed := make(map[int]ShardView)
+	applied, err := sm.ExecutePlan(plan, func(move ShardMove, view ShardView) error {
+		handed[move.ShardID] = view
+		return nil
+	})
+	if err != nil || applied != len(plan.Moves) {
+		t.Fatalf("applied %d of %d moves: %v", applied, len(plan.Moves), err)
+	}
+	assignments := sm.ShardAssignments()
+	for _, move := range plan.Moves {
+		view, ok := handed[move.ShardID]
+		if !ok || view.ID != move.ShardID || newBlockTree(view.Blocks).GetRootHash() != view.Root {
+			t.Fatalf("shard #%d handed off as %+v", move.ShardID, view)
+		}
+		if assignments[move.ShardID] != "b" {
+			t.Fatalf("shard #%d on %q after the move", move.ShardID, assignments[move.ShardID])
+		}
+	}
+
+	// Replaying the plan finds the shards already moved
+	if n, err := sm.ExecutePlan(plan, nil); n != 0 || !errors.Is(err, ErrStaleMove) {
+		t.Fatalf("replayed plan applied %d moves: %v", n, err)
+	}
+
+	// A failed handoff stops the plan and leaves the shard in place
+	back := MigrationPlan{Moves: []ShardMove{{ShardID: plan.Moves[0].ShardID, FromNode: "b", ToNode: "a"}}}
+	failure := errors.New("peer unreachable")
+	if _, err := sm.ExecutePlan(back, func(ShardMove, ShardView) error { return failure }); !errors.Is(err, failure) {
+		t.Fatalf("failed handoff: %v", err)
+	}
+	if node := sm.ShardAssignments()[plan.Moves[0].ShardID]; node != "b" {
+		t.Fatalf("shard moved to %q despite the failed handoff", node)
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+// Priority is the class a block or transaction is served in: High items go
+// ahead of Normal ones, which go ahead of Low ones
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal" // Also the meaning of an empty Priority
+	PriorityLow    Priority = "low"
+)
+
+// DefaultPriorityAging is how many turns an item may be passed over before
+// it is served one class higher, so Low items cannot starve
+const DefaultPriorityAging = 8
+
+// rank orders priorities, lowest rank first
+func (p Priority) rank() int {
+	switch p {
+	case PriorityHigh:
+		return 0
+	case PriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// hashed returns the priority as committed to by block and transaction
+// hashes: empty for Normal, which keeps the hashes of unprioritized items
+func (p Priority) hashed() string {
+	if p.rank() == PriorityNormal.rank() {
+		return ""
+	}
+	return string(p)
+}
+
+// highestPriority returns the highest priority among txs, empty for Normal
+// so that blocks of unprioritized transactions are unchanged
+func highestPriority(txs []Transaction) Priority {
+	if len(txs) == 0 {
+		return ""
+	}
+	highest := txs[0].Priority
+	for _, tx := range txs[1:] {
+		if tx.Priority.rank() < highest.rank() {
+			highest = tx.Priority
+		}
+	}
+	return Priority(highest.hashed())
+}
+
+// agedRank is the rank an item is served at after waiting the given number
+// of turns, climbing one class every aging turns; aging of zero or less
+// disables it. Ranks keep climbing past High, so an aged item is not held
+// back forever by High items that win on fee.
+func agedRank(p Priority, waited uint64, aging int) int {
+	rank := p.rank()
+	if aging > 0 {
+		rank -= int(waited / uint64(aging))
+	}
+	return rank
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+// prioritized returns a transfer of 1 from a sender of its own, so that
+// any set of them can go into one block
+func prioritized(from string, priority Priority, fee uint64) Transaction {
+	return Transaction{From: from, To: "~bob", Amount: 1, Fee: fee, Priority: priority}
+}
+
+// submitAll submits transactions, failing the test on any error
+func submitAll(t *testing.T, mp *Mempool, txs ...Transaction) {
+	t.Helper()
+	for _, tx := range txs {
+		if err := mp.Submit(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// senders returns the senders of txs in order
+func senders(txs []Transaction) []string {
+	from := make([]string, len(txs))
+	for i, tx := range txs {
+		from[i] = tx.From
+	}
+	return from
+}
+
+func TestMempoolOrdersByPriority(t *testing.T) {
+	mp := NewMempool(10)
+	submitAll(t, mp,
+		prioritized("~low", PriorityLow, 10),
+		prioritized("~normal-1", PriorityNormal, 1),
+		prioritized("~high", PriorityHigh, 1),
+		prioritized("~normal-5", PriorityNormal, 5),
+		prioritized("~unset", "", 1),
+	)
+	want := []string{"~high", "~normal-5", "~normal-1", "~unset", "~low"}
+	if got := senders(mp.Pending(0)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("served %v, want %v", got, want)
+	}
+
+	// A full pool evicts the last in serving order, whatever its fee
+	full := NewMempool(2)
+	submitAll(t, full, prioritized("~low", PriorityLow, 100), prioritized("~normal", PriorityNormal, 1))
+	submitAll(t, full, prioritized("~high", PriorityHigh, 1))
+	if got := senders(full.Pending(0)); fmt.Sprint(got) != "[~high ~normal]" {
+		t.Fatalf("after eviction %v", got)
+	}
+}
+
+func TestMempoolAgesLowPriority(t *testing.T) {
+	mp := NewMempool(10)
+	mp.SetPriorityAging(2)
+	submitAll(t, mp, prioritized("~low", PriorityLow, 1))
+	submitAll(t, mp, prioritized("~high", PriorityHigh, 1), prioritized("~normal", PriorityNormal, 1))
+
+	for _, tc := range []struct {
+		blocks int
+		want   string
+	}{
+		{0, "[~high ~normal ~low]"},
+		{1, "[~high ~normal ~low]"},
+		{2, "[~high ~low ~normal]"}, // Low has climbed to Normal, and arrived first
+		{4, "[~low ~high ~normal]"},
+		{20, "[~low ~high ~normal]"},
+	} {
+		aged := NewMempool(10)
+		aged.SetPriorityAging(2)
+		submitAll(t, aged, prioritized("~low", PriorityLow, 1))
+		for i := 0; i < tc.blocks; i++ {
+			aged.MarkIncluded(nil)
+		}
+		submitAll(t, aged, prioritized("~high", PriorityHigh, 1), prioritized("~normal", PriorityNormal, 1))
+		if got := fmt.Sprint(senders(aged.Pending(0))); got != tc.want {
+			t.Errorf("Low waiting %d blocks: served %s, want %s", tc.blocks, got, tc.want)
+		}
+	}
+
+	mp.SetPriorityAging(0)
+	for i := 0; i < 100; i++ {
+		mp.MarkIncluded(nil)
+	}
+	if got := fmt.Sprint(senders(mp.Pending(0))); got != "[~high ~normal ~low]" {
+		t.Fatalf("served %s with aging disabled", got)
+	}
+}
+
+// priorityProducer returns a producer of blocks of 4 transactions over a
+// chain funding every sender used
+func priorityProducer(t *testing.T, reserve float64) (*BlockProducer, *Mempool) {
+	t.Helper()
+	balances := make(map[string]uint64)
+	for i := 0; i < 100; i++ {
+		balances[fmt.Sprintf("~sender-%d", i)] = 1000
+	}
+	mp := NewMempool(100)
+	bp := NewBlockProducer(fundedChain(t, balances), mp, 4)
+	bp.SetHighPriorityReserve(reserve)
+	return bp, mp
+}
+
+func TestProducerReservesHighPrioritySlots(t *testing.T) {
+	for _, tc := range []struct {
+		reserve float64
+		high    int
+	}{{0, 0}, {0.5, 2}, {0.25, 1}, {1, 2}} {
+		bp, mp := priorityProducer(t, tc.reserve)
+		mp.SetPriorityAging(1)
+
+		// Normal transactions aged up to High with higher fees are served
+		// ahead of two genuine High ones
+		for i := 0; i < 4; i++ {
+			submitAll(t, mp, prioritized(fmt.Sprintf("~sender-%d", i), PriorityNormal, 10))
+		}
+		mp.MarkIncluded(nil)
+		submitAll(t, mp, prioritized("~sender-10", PriorityHigh, 1), prioritized("~sender-11", PriorityHigh, 1))
+
+		block, err := bp.ProduceBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		high := 0
+		for _, tx := range block.Transactions {
+			if tx.Priority == PriorityHigh {
+				high++
+			}
+		}
+		if len(block.Transactions) != 4 || high != tc.high {
+			t.Errorf("reserve %v: %d transactions, %d High, want %d High", tc.reserve, len(block.Transactions), high, tc.high)
+		}
+	}
+}
+
+func TestLowPriorityEventuallyIncluded(t *testing.T) {
+	for _, aging := range []int{DefaultPriorityAging, 0} {
+		bp, mp := priorityProducer(t, 0.5)
+		mp.SetPriorityAging(aging)
+		submitAll(t, mp, prioritized("~sender-0", PriorityLow, 1))
+
+		// Every block could be filled with High transactions alone, paying
+		// more than the Low one, which must climb past them
+		included := -1
+		for round := 0; round < 30 && included < 0; round++ {
+			for i := 0; i < 4; i++ {
+				sender := fmt.Sprintf("~sender-%d", 1+(round*4+i)%99)
+				nonce := bp.chain.State().NonceOf(sender)
+				tx := prioritized(sender, PriorityHigh, 5)
+				tx.Nonce = nonce
+				submitAll(t, mp, tx)
+			}
+			block, err := bp.ProduceBlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tx := range block.Transactions {
+				if tx.From == "~sender-0" {
+					included = round
+				}
+			}
+		}
+
+		switch {
+		case aging == 0 && included >= 0:
+			t.Fatalf("Low transaction included in block %d without aging", included)
+		case aging > 0 && (included < 0 || included > 3*aging):
+			t.Fatalf("Low transaction included in block %d with aging %d", included, aging)
+		}
+	}
+}
+
+// queuedSource returns a shard holding one block of the given priority
+func queuedSource(t *testing.T, id int, priority Priority) *Shard {
+	t.Helper()
+	b := mustGenerateBlock(t, GenesisBlock(), fmt.Sprintf("queued-%d", id))
+	b.Priority = priority
+	b.Hash = calculateHash(b)
+	source := NewShard(id)
+	source.AddBlock(b)
+	return source
+}
+
+// runOrder queues transfers of the given priorities, each from a shard of
+// its own, and returns the order they run in by queue position
+func runOrder(t *testing.T, level ConsistencyLevel, aging int, priorities ...Priority) []int {
+	t.Helper()
+	co := NewOrchestrator()
+	co.CurrentLevel = level
+	te := NewTransferExecutor(NewEnhancedSyncManager("key"), WithTransferConsistency(co), WithTransferAging(aging))
+	destination := NewShard(100)
+	for i, priority := range priorities {
+		// The last request takes its priority from its block
+		req := TransferRequest{Key: fmt.Sprint(i), Source: queuedSource(t, i, priority), Destination: destination, Priority: priority}
+		if i == len(priorities)-1 {
+			req.Priority = ""
+		}
+		te.Enqueue(req)
+	}
+	if te.Queued() != len(priorities) {
+		t.Fatalf("%d transfers queued of %d", te.Queued(), len(priorities))
+	}
+
+	var order []int
+	for {
+		req, ok, err := te.RunNext(exactPolicy)
+		if !ok {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var i int
+		fmt.Sscan(req.Key, &i)
+		order = append(order, i)
+	}
+	if len(destination.Blocks) != len(priorities) {
+		t.Fatalf("%d blocks moved of %d", len(destination.Blocks), len(priorities))
+	}
+	return order
+}
+
+func TestTransferQueuePriority(t *testing.T) {
+	priorities := []Priority{PriorityLow, PriorityNormal, PriorityLow, PriorityNormal, PriorityHigh}
+	for _, tc := range []struct {
+		level ConsistencyLevel
+		aging int
+		want  string
+	}{
+		{Eventual, 0, "[4 1 3 0 2]"},
+		{Strong, 0, "[0 1 2 3 4]"},
+		{Causal, 0, "[0 1 2 3 4]"},
+		// Transfers queued together age together and keep their order
+		{Eventual, 1, "[4 1 3 0 2]"},
+	} {
+		if got := fmt.Sprint(runOrder(t, tc.level, tc.aging, priorities...)); got != tc.want {
+			t.Errorf("%s with aging %d: ran %s, want %s", tc.level, tc.aging, got, tc.want)
+		}
+	}
+}
+
+func TestQueuedLowTransferClimbsPastHigh(t *testing.T) {
+	for _, tc := range []struct {
+		aging int
+		turn  int // Turn the Low transfer runs in, -1 for none
+	}{{1, 2}, {2, 4}, {0, -1}} {
+		co := NewOrchestrator()
+		co.CurrentLevel = Eventual
+		te := NewTransferExecutor(NewEnhancedSyncManager("key"), WithTransferConsistency(co), WithTransferAging(tc.aging))
+		destination := NewShard(100)
+		te.Enqueue(TransferRequest{Key: "low", Source: queuedSource(t, 0, PriorityLow), Destination: destination})
+
+		// A new High transfer arrives every turn
+		ran := -1
+		for turn := 0; turn < 10 && ran < 0; turn++ {
+			te.Enqueue(TransferRequest{Key: fmt.Sprint(turn), Source: queuedSource(t, turn+1, PriorityHigh), Destination: destination})
+			req, _, err := te.RunNext(exactPolicy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Key == "low" {
+				ran = turn
+			}
+		}
+		if ran != tc.turn {
+			t.Errorf("aging %d: Low transfer ran in turn %d, want %d", tc.aging, ran, tc.turn)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package core
 
 import (
+	"bytes"
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
+	"math/bits"
+	"sync/atomic"
 )
 
 // ProofCompressingMerkleTree is a Merkle Tree with probabilistic verification
@@ -26,8 +31,7 @@ func (pcmt *ProofCompressingMerkleTree) GetRootHash() string {
 // VerifyDataProbabilistic verifies data membership probabilistically
 func (pcmt *ProofCompressingMerkleTree) VerifyDataProbabilistic(data string) bool {
 	// Hash the data to check against leaves
-	hash := sha256.Sum256([]byte(data))
-	hashStr := hex.EncodeToString(hash[:])
+	hashStr := pcmt.tree.HashData(data)
 
 	// Check if the hash is in the leaves
 	for _, leaf := range pcmt.tree.Leaves {
@@ -38,28 +42,273 @@ func (pcmt *ProofCompressingMerkleTree) VerifyDataProbabilistic(data string) boo
 	return false
 }
 
-// BloomFilter is a probabilistic data structure for membership testing
+// ErrProofBudget is returned when a byte budget cannot hold even a fully
+// compressed proof
+var ErrProofBudget = errors.New("byte budget too small for a compressed proof")
+
+// Bounds on the Bloom digest of a compressed proof
+const (
+	minProofDigestBytes = 8  // Smallest bitset worth sending
+	maxProofDigestFuncs = 16 // Hash functions beyond this barely lower the false acceptance rate
+)
+
+// CompressedProof is an inclusion proof cut down to a byte budget: the top
+// Omitted siblings of Proof are dropped and Digest, a Bloom filter over the
+// path nodes they would have authenticated, stands in for them. Verifying
+// it is probabilistic, see VerifyCompressedProof; with Omitted at 0 it is an
+// exact proof. The prover builds the digest, and could add a forged path to
+// it, so verification looks the path up in a digest from a trusted source,
+// such as the tree's owner, and gives a prover's own digest no confidence.
+type CompressedProof struct {
+	Proof   MerkleProof `json:"proof"`            // Leaf position and the kept bottom siblings
+	Omitted int         `json:"omitted"`          // Top siblings replaced by Digest
+	Digest  []byte      `json:"digest,omitempty"` // Bloom filter, see BloomFilter.MarshalBinary
+}
+
+// Exact reports whether no siblings were omitted
+func (cp CompressedProof) Exact() bool {
+	return cp.Omitted == 0
+}
+
+// Size returns the proof's bytes counted against a budget: 32 bytes per
+// kept sibling plus the digest
+func (cp CompressedProof) Size() int {
+	size := len(cp.Digest)
+	for _, sibling := range cp.Proof.Siblings {
+		size += len(sibling) / 2
+	}
+	return size
+}
+
+// proofDigestItem keys a path node for a digest, binding it to the root and
+// its level so nodes of other trees or levels do not match
+func proofDigestItem(root string, level int, node string) string {
+	return fmt.Sprintf("%s|%d|%s", root, level, node)
+}
+
+// CompressedProof returns the inclusion proof of the leaf at index within
+// budget bytes. Proofs that fit are exact; otherwise the fewest top
+// siblings are omitted that leave room for a digest, which takes the rest
+// of the budget.
+func (pcmt *ProofCompressingMerkleTree) CompressedProof(index, budget int) (CompressedProof, error) {
+	proof, err := pcmt.tree.GetProof(index)
+	if err != nil {
+		return CompressedProof{}, err
+	}
+	full := CompressedProof{Proof: proof}
+	if full.Size() <= budget {
+		return full, nil
+	}
+
+	// Find the level each sibling sits at, bottom up
+	levels := pcmt.tree.levels()
+	var siblingLevels []int
+	for level, i := 0, index; level < len(levels)-1; level, i = level+1, i/2 {
+		if i^1 < len(levels[level]) {
+			siblingLevels = append(siblingLevels, level)
+		}
+	}
+	root := pcmt.GetRootHash()
+	for kept := len(proof.Siblings) - 1; kept >= 0; kept-- {
+		compressed := CompressedProof{
+			Proof:   proof,
+			Omitted: len(proof.Siblings) - kept,
+		}
+		compressed.Proof.Siblings = proof.Siblings[:kept]
+		room := budget - compressed.Size()
+		header := len(binary.AppendUvarint(binary.AppendUvarint(nil, uint64(room*8)), maxProofDigestFuncs))
+		if room-header < minProofDigestBytes {
+			continue
+		}
+
+		// The path nodes from the first omitted sibling's level upwards
+		bits := uint((room - header) * 8)
+		items := len(levels) - 1 - siblingLevels[kept]
+		funcs := uint(math.Round(float64(bits) / float64(items) * math.Ln2))
+		funcs = uint(math.Max(1, math.Min(float64(funcs), maxProofDigestFuncs)))
+		digest := NewBloomFilter(bits, funcs)
+		for level := siblingLevels[kept]; level < len(levels)-1; level++ {
+			digest.Add(proofDigestItem(root, level, levels[level][index>>level]))
+		}
+		if compressed.Digest, err = digest.MarshalBinary(); err != nil {
+			return CompressedProof{}, err
+		}
+		return compressed, nil
+	}
+	return CompressedProof{}, fmt.Errorf("%w: %d bytes", ErrProofBudget, budget)
+}
+
+// VerifyWithConfidence checks a compressed proof of data against the tree's
+// root, trusting the digest the tree itself builds for the proof's leaf and
+// size, see VerifyCompressedProof
+func (pcmt *ProofCompressingMerkleTree) VerifyWithConfidence(data string, proof CompressedProof, maxFalseAcceptance float64) (ok bool, confidence float64) {
+	var trusted []byte
+	if !proof.Exact() {
+		honest, err := pcmt.CompressedProof(proof.Proof.Index, proof.Size())
+		if err != nil || honest.Exact() {
+			return false, 0
+		}
+		trusted = honest.Digest
+	}
+	return VerifyCompressedProof(pcmt.GetRootHash(), data, proof, trusted, maxFalseAcceptance)
+}
+
+// VerifyCompressedProof checks that data is the leaf at proof's index under
+// root. Exact proofs are verified like VerifyMerkleProof, with confidence 1.
+// Otherwise the path is recomputed up to the first omitted sibling and the
+// node reached is looked up in trustedDigest, the digest the tree's owner
+// published for the leaf; a proof carrying another digest is rejected.
+// Confidence is one minus the digest's false positive rate, and the proof
+// is rejected if that rate is above maxFalseAcceptance, so saturated
+// digests never pass. Without a trusted digest the prover's is used with
+// confidence 0: whoever built it can make any path match.
+func VerifyCompressedProof(root, data string, proof CompressedProof, trustedDigest []byte, maxFalseAcceptance float64) (ok bool, confidence float64) {
+	if proof.Exact() {
+		if VerifyMerkleProof(root, data, proof.Proof) {
+			return true, 1
+		}
+		return false, 1
+	}
+
+	digest := &BloomFilter{}
+	encoded := trustedDigest
+	if encoded == nil {
+		encoded = proof.Digest
+	}
+	if proof.Omitted < 0 || digest.UnmarshalBinary(encoded) != nil {
+		return false, 0
+	}
+	if trustedDigest != nil {
+		confidence = 1 - digest.FalsePositiveRate()
+		if proof.Digest != nil && !bytes.Equal(proof.Digest, trustedDigest) {
+			return false, confidence
+		}
+	}
+	if 1-confidence > maxFalseAcceptance {
+		return false, confidence
+	}
+	p := proof.Proof
+	version := p.Version
+	if version == 0 {
+		version = TreeV1
+	}
+	h, err := LookupHasher(p.Hasher)
+	if err != nil || p.Index < 0 || p.Index >= p.LeafCount {
+		return false, confidence
+	}
+
+	hash := hashLeaf(h, version, data)
+	index, size, next, level := p.Index, p.LeafCount, 0, 0
+	for ; size > 1; size, level = (size+1)/2, level+1 {
+		if sibling := index ^ 1; sibling < size {
+			if next == len(p.Siblings) {
+				// The first omitted sibling: the node reached must be in the digest
+				return digest.Test(proofDigestItem(root, level, hash)), confidence
+			}
+			if index%2 == 0 {
+				hash = hashNode(h, version, hash, p.Siblings[next], true)
+			} else {
+				hash = hashNode(h, version, p.Siblings[next], hash, true)
+			}
+			next++
+		} else {
+			hash = hashNode(h, version, hash, "", false)
+		}
+		index /= 2
+	}
+	// Fewer siblings on the path than the proof claims to omit
+	return false, confidence
+}
+
+// Errors returned when combining or decoding Bloom filters
+var (
+	ErrBloomMismatch      = errors.New("bloom filters differ in size or hash functions")
+	ErrCorruptBloomFilter = errors.New("corrupt bloom filter encoding")
+	ErrInvalidFPRate      = errors.New("false positive rate must be between 0 and 1")
+)
+
+// maxBloomHashFuncs bounds the hash functions of a decoded filter, since
+// Add and Test run each one; NewBloomFilterFor picks about 60 for a false
+// positive rate of 1e-18
+const maxBloomHashFuncs = 64
+
+// BloomFilter is a probabilistic data structure for membership testing.
+// Add and Test are lock-free and may be called concurrently: bits are set
+// with atomic compare-and-swap and read with atomic loads.
 type BloomFilter struct {
-	bits      []bool
+	set       uint64   // Number of bits set; first for 64-bit atomic alignment
+	bits      []uint64 // Bitset packed 64 bits per word
 	size      uint
 	hashFuncs uint
 }
 
 // NewBloomFilter creates a new Bloom Filter
 func NewBloomFilter(size, hashFuncs uint) *BloomFilter {
+	if size == 0 {
+		size = 1
+	}
 	return &BloomFilter{
-		bits:      make([]bool, size),
+		bits:      make([]uint64, (size+63)/64),
 		size:      size,
 		hashFuncs: hashFuncs,
 	}
 }
 
+// NewBloomFilterFor sizes a filter for n items at the target false positive
+// rate, which must lie strictly between 0 and 1
+func NewBloomFilterFor(n int, fpRate float64) (*BloomFilter, error) {
+	if !(fpRate > 0 && fpRate < 1) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFPRate, fpRate)
+	}
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	return NewBloomFilter(uint(m), uint(k)), nil
+}
+
+func (bf *BloomFilter) setBit(index uint64) {
+	bf.orWord(int(index/64), 1<<(index%64))
+}
+
+func (bf *BloomFilter) hasBit(index uint64) bool {
+	return atomic.LoadUint64(&bf.bits[index/64])&(1<<(index%64)) != 0
+}
+
+// orWord sets the bits of mask in a word, counting those newly set
+func (bf *BloomFilter) orWord(i int, mask uint64) {
+	for {
+		old := atomic.LoadUint64(&bf.bits[i])
+		if old|mask == old {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&bf.bits[i], old, old|mask) {
+			atomic.AddUint64(&bf.set, uint64(bits.OnesCount64(mask&^old)))
+			return
+		}
+	}
+}
+
+// andWord clears the bits of a word missing from mask, uncounting them
+func (bf *BloomFilter) andWord(i int, mask uint64) {
+	for {
+		old := atomic.LoadUint64(&bf.bits[i])
+		if old&mask == old {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&bf.bits[i], old, old&mask) {
+			atomic.AddUint64(&bf.set, ^uint64(bits.OnesCount64(old&^mask)-1))
+			return
+		}
+	}
+}
+
 // Add adds an item to the Bloom Filter
 func (bf *BloomFilter) Add(item string) {
 	for i := uint(0); i < bf.hashFuncs; i++ {
 		hash := simpleHash(item, i)
-		index := hash % uint64(bf.size)
-		bf.bits[index] = true
+		bf.setBit(hash % uint64(bf.size))
 	}
 }
 
@@ -67,27 +316,138 @@ func (bf *BloomFilter) Add(item string) {
 func (bf *BloomFilter) Test(item string) bool {
 	for i := uint(0); i < bf.hashFuncs; i++ {
 		hash := simpleHash(item, i)
-		index := hash % uint64(bf.size)
-		if !bf.bits[index] {
+		if !bf.hasBit(hash % uint64(bf.size)) {
 			return false
 		}
 	}
 	return true
 }
 
-// FalsePositiveRate estimates the false positive rate
+// locations returns the bits Add sets for an item, so one item can be
+// tested against many filters of the same shape with hasLocations
+func (bf *BloomFilter) locations(item string) []uint64 {
+	locs := make([]uint64, bf.hashFuncs)
+	for i := range locs {
+		locs[i] = simpleHash(item, uint(i)) % uint64(bf.size)
+	}
+	return locs
+}
+
+// hasLocations reports whether every bit in locs is set
+func (bf *BloomFilter) hasLocations(locs []uint64) bool {
+	for _, loc := range locs {
+		if !bf.hasBit(loc) {
+			return false
+		}
+	}
+	return true
+}
+
+// FalsePositiveRate estimates the chance that Test matches an item never
+// added: the probability that all k probed bits are set, (X/m)^k for X of
+// the m bits set
 func (bf *BloomFilter) FalsePositiveRate() float64 {
-	// Approximate false positive rate: (1 - e^(-k*n/m))^k
-	// where k = hashFuncs, n = number of items (approximated), m = size
-	n := float64(bf.size) / 10 // Assume ~10% of bits are set for estimation
-	k := float64(bf.hashFuncs)
+	return math.Pow(float64(bf.BitsSet())/float64(bf.size), float64(bf.hashFuncs))
+}
+
+// BitsSet returns how many bits of the filter are set
+func (bf *BloomFilter) BitsSet() int {
+	return int(atomic.LoadUint64(&bf.set))
+}
+
+// EstimateCardinality estimates how many distinct items were added from the
+// fill ratio: n = -(m/k) * ln(1 - X/m). A saturated filter returns +Inf.
+func (bf *BloomFilter) EstimateCardinality() float64 {
+	if bf.hashFuncs == 0 {
+		return 0
+	}
 	m := float64(bf.size)
-	return math.Pow(1.0-math.Exp(-k*n/m), k)
+	x := float64(bf.BitsSet())
+	if x >= m {
+		return math.Inf(1)
+	}
+	return -m / float64(bf.hashFuncs) * math.Log(1-x/m)
+}
+
+// compatible checks that two filters hash items to the same bits
+func (bf *BloomFilter) compatible(other *BloomFilter) error {
+	if bf.size != other.size || bf.hashFuncs != other.hashFuncs {
+		return fmt.Errorf("%w: %d/%d vs %d/%d", ErrBloomMismatch, bf.size, bf.hashFuncs, other.size, other.hashFuncs)
+	}
+	return nil
+}
+
+// Union adds every item of other, so the filter matches items in either set
+func (bf *BloomFilter) Union(other *BloomFilter) error {
+	if err := bf.compatible(other); err != nil {
+		return err
+	}
+	for i := range bf.bits {
+		bf.orWord(i, atomic.LoadUint64(&other.bits[i]))
+	}
+	return nil
+}
+
+// Intersect keeps only bits set in both filters. Items in both sets still
+// match, though the false positive rate can exceed that of a filter built
+// from the intersection directly.
+func (bf *BloomFilter) Intersect(other *BloomFilter) error {
+	if err := bf.compatible(other); err != nil {
+		return err
+	}
+	for i := range bf.bits {
+		bf.andWord(i, atomic.LoadUint64(&other.bits[i]))
+	}
+	return nil
+}
+
+// MarshalBinary encodes the filter as uvarint(size) || uvarint(hashFuncs)
+// followed by the bitset, least significant bit first, in ceil(size/8) bytes
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(bf.size))
+	buf = binary.AppendUvarint(buf, uint64(bf.hashFuncs))
+	for i := uint(0); i < (bf.size+7)/8; i++ {
+		buf = append(buf, byte(atomic.LoadUint64(&bf.bits[i/8])>>(8*(i%8))))
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a filter produced by MarshalBinary. Unlike Add and
+// Test it replaces the whole filter, so it must not run concurrently with
+// other methods.
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size == 0 {
+		return fmt.Errorf("%w: bad size", ErrCorruptBloomFilter)
+	}
+	data = data[n:]
+	hashFuncs, n := binary.Uvarint(data)
+	if n <= 0 || hashFuncs == 0 || hashFuncs > maxBloomHashFuncs {
+		return fmt.Errorf("%w: bad hash function count", ErrCorruptBloomFilter)
+	}
+	data = data[n:]
+	if want := (size-1)/8 + 1; uint64(len(data)) != want {
+		return fmt.Errorf("%w: expected %d bitset bytes, got %d", ErrCorruptBloomFilter, want, len(data))
+	}
+
+	decoded := make([]uint64, (size-1)/64+1)
+	for i, b := range data {
+		decoded[i/8] |= uint64(b) << (8 * (i % 8))
+	}
+	if extra := size % 64; extra != 0 && decoded[len(decoded)-1]>>extra != 0 {
+		return fmt.Errorf("%w: bits set beyond filter size", ErrCorruptBloomFilter)
+	}
+	set := 0
+	for _, word := range decoded {
+		set += bits.OnesCount64(word)
+	}
+	bf.bits, bf.size, bf.hashFuncs, bf.set = decoded, uint(size), uint(hashFuncs), uint64(set)
+	return nil
 }
 
 // simpleHash generates a hash for Bloom Filter
 func simpleHash(data string, seed uint) uint64 {
-	hash := sha256.Sum256([]byte(data + string(seed)))
+	hash := sha256.Sum256([]byte(data + string(rune(seed))))
 	var result uint64
 	for i := 0; i < 8; i++ {
 		result = (result << 8) | uint64(hash[i])
@@ -0,0 +1,377 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"testing"
+)
+
+func TestNewBloomFilterForRejectsInvalidRates(t *testing.T) {
+	for _, rate := range []float64{0, 1, -0.5, 1.5, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if filter, err := NewBloomFilterFor(100, rate); !errors.Is(err, ErrInvalidFPRate) || filter != nil {
+			t.Errorf("rate %v: %v", rate, err)
+		}
+	}
+	filter, err := NewBloomFilterFor(1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range testLeaves(1000) {
+		filter.Add(item)
+	}
+	if rate := filter.FalsePositiveRate(); rate > 0.02 {
+		t.Fatalf("filter sized for 1%% has an estimated rate of %v", rate)
+	}
+}
+
+func TestBloomFilterBinaryRoundTrip(t *testing.T) {
+	for _, size := range []uint{1, 7, 8, 63, 64, 65, 1000} {
+		filter := NewBloomFilter(size, 3)
+		for _, item := range testLeaves(int(size) / 4) {
+			filter.Add(item)
+		}
+		data, err := filter.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &BloomFilter{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if decoded.size != filter.size || decoded.hashFuncs != filter.hashFuncs || decoded.BitsSet() != filter.BitsSet() {
+			t.Fatalf("size %d: decoded %d/%d with %d bits", size, decoded.size, decoded.hashFuncs, decoded.BitsSet())
+		}
+		for _, item := range testLeaves(int(size) / 4) {
+			if !decoded.Test(item) {
+				t.Fatalf("size %d: %q lost in the round trip", size, item)
+			}
+		}
+		if len(data) > 4+int(size+7)/8 { // Varint header plus one bit per slot
+			t.Fatalf("size %d encoded in %d bytes", size, len(data))
+		}
+	}
+}
+
+func TestBloomFilterRejectsCorruptEncodings(t *testing.T) {
+	valid, _ := NewBloomFilter(70, 2).MarshalBinary()
+	header := func(size, k uint64) []byte {
+		return binary.AppendUvarint(binary.AppendUvarint(nil, size), k)
+	}
+	for name, data := range map[string][]byte{
+		"empty":         nil,
+		"zero size":     header(0, 2),
+		"short bitset":  valid[:len(valid)-1],
+		"long bitset":   append(append([]byte{}, valid...), 0),
+		"stray bits":    append(header(70, 2), 0, 0, 0, 0, 0, 0, 0, 0, 0x40),
+		"wrapping size": header(math.MaxUint64, 2),
+		"no hashes":     append(header(70, 0), make([]byte, 9)...),
+		"many hashes":   append(header(70, 1<<40), make([]byte, 9)...),
+	} {
+		if err := (&BloomFilter{}).UnmarshalBinary(data); !errors.Is(err, ErrCorruptBloomFilter) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestCompressedProofsFitTheBudget(t *testing.T) {
+	leaves := testLeaves(4096)
+	pcmt := NewProofCompressingMerkleTree(leaves)
+	root := pcmt.GetRootHash()
+
+	// Twelve siblings fit in 384 bytes and stay exact
+	exact, err := pcmt.CompressedProof(100, 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exact.Exact() || exact.Size() != 384 {
+		t.Fatalf("exact proof of %d bytes omits %d siblings", exact.Size(), exact.Omitted)
+	}
+	if ok, confidence := pcmt.VerifyWithConfidence(leaves[100], exact, 0); !ok || confidence != 1 {
+		t.Fatalf("exact proof: %v with confidence %v", ok, confidence)
+	}
+	if ok, _ := pcmt.VerifyWithConfidence(leaves[101], exact, 1); ok {
+		t.Fatal("exact proof accepted another leaf")
+	}
+
+	for _, budget := range []int{100, 200, 300, 383} {
+		proof, err := pcmt.CompressedProof(100, budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Exact() || proof.Size() != budget {
+			t.Fatalf("budget %d: %d bytes omitting %d siblings", budget, proof.Size(), proof.Omitted)
+		}
+		ok, confidence := pcmt.VerifyWithConfidence(leaves[100], proof, 0.01)
+		if !ok || confidence < 0.99 {
+			t.Fatalf("budget %d: %v with confidence %v", budget, ok, confidence)
+		}
+		// Verifiers away from the tree need the owner's digest, not the prover's
+		if ok, c := VerifyCompressedProof(root, leaves[100], proof, proof.Digest, 0.01); !ok || c != confidence {
+			t.Fatalf("budget %d with the trusted digest: %v, %v", budget, ok, c)
+		}
+		if ok, c := VerifyCompressedProof(root, leaves[100], proof, nil, 0.01); ok || c != 0 {
+			t.Fatalf("budget %d with the prover's digest: %v, %v", budget, ok, c)
+		}
+		if ok, _ := pcmt.VerifyWithConfidence(leaves[100], proof, (1-confidence)/2); ok && confidence < 1 {
+			t.Fatalf("budget %d accepted beyond the allowed false acceptance", budget)
+		}
+	}
+	if _, err := pcmt.CompressedProof(100, 9); !errors.Is(err, ErrProofBudget) {
+		t.Fatalf("9-byte budget: %v", err)
+	}
+}
+
+func TestCompressedProofForgeryRate(t *testing.T) {
+	leaves := testLeaves(4096)
+	pcmt := NewProofCompressingMerkleTree(leaves)
+	root := pcmt.GetRootHash()
+
+	// A 72-bit digest over five path nodes: a high enough false acceptance
+	// rate to measure
+	const budget, trials = 7*32 + 11, 10000
+	proofs := make([]CompressedProof, 64)
+	for i := range proofs {
+		var err error
+		if proofs[i], err = pcmt.CompressedProof(i*61, budget); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, confidence := pcmt.VerifyWithConfidence(leaves[0], proofs[0], 1)
+
+	accepted, selfVouched := 0, 0
+	for i := 0; i < trials; i++ {
+		proof := proofs[i%len(proofs)]
+		forged := fmt.Sprintf("forged-%d", i)
+		if ok, _ := VerifyCompressedProof(root, forged, proof, proof.Digest, 1); ok {
+			accepted++
+		}
+
+		// The forger adds its own path to the digest it sends
+		vouched := proof
+		digest := &BloomFilter{}
+		digest.UnmarshalBinary(proof.Digest)
+		p := proof.Proof
+		h, _ := LookupHasher(p.Hasher)
+		hash := hashLeaf(h, TreeV1, forged)
+		for level, index := 0, p.Index; level < len(p.Siblings); level, index = level+1, index/2 {
+			if index%2 == 0 {
+				hash = hashNode(h, TreeV1, hash, p.Siblings[level], true)
+			} else {
+				hash = hashNode(h, TreeV1, p.Siblings[level], hash, true)
+			}
+		}
+		digest.Add(proofDigestItem(root, len(p.Siblings), hash))
+		vouched.Digest, _ = digest.MarshalBinary()
+		if ok, _ := VerifyCompressedProof(root, forged, vouched, nil, 0.5); ok {
+			selfVouched++
+		}
+		if ok, _ := VerifyCompressedProof(root, forged, vouched, proof.Digest, 1); ok {
+			selfVouched++
+		}
+		// The tree rebuilds each trusted digest, so check only some
+		if i%100 == 0 {
+			if ok, _ := pcmt.VerifyWithConfidence(forged, vouched, 1); ok {
+				selfVouched++
+			}
+		}
+	}
+
+	rate := float64(accepted) / trials
+	t.Logf("%d of %d forged proofs accepted, rate %.4f against a reported %.4f", accepted, trials, rate, 1-confidence)
+	if rate > 2*(1-confidence)+0.002 {
+		t.Fatalf("forged proofs accepted at %.4f, confidence promised %.4f", rate, 1-confidence)
+	}
+	if selfVouched != 0 {
+		t.Fatalf("%d forged proofs accepted on their own digest", selfVouched)
+	}
+}
+
+func TestBloomFilterUnionAndIntersect(t *testing.T) {
+	items := testLeaves(200)
+	a, b := NewBloomFilter(4096, 4), NewBloomFilter(4096, 4)
+	for _, item := range items[:120] {
+		a.Add(item)
+	}
+	for _, item := range items[80:] {
+		b.Add(item)
+	}
+
+	union := NewBloomFilter(4096, 4)
+	if err := union.Union(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := union.Union(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if !union.Test(item) {
+			t.Fatalf("union lost %q", item)
+		}
+	}
+
+	intersection := NewBloomFilter(4096, 4)
+	intersection.Union(a)
+	if err := intersection.Intersect(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items[80:120] {
+		if !intersection.Test(item) {
+			t.Fatalf("intersection lost shared item %q", item)
+		}
+	}
+	missed := 0
+	for _, item := range append(items[:80:80], items[120:]...) {
+		if !intersection.Test(item) {
+			missed++
+		}
+	}
+	if missed < 150 {
+		t.Fatalf("intersection matches %d of 160 items in only one filter", 160-missed)
+	}
+	if intersection.BitsSet() > a.BitsSet() || intersection.BitsSet() > b.BitsSet() {
+		t.Fatal("intersection has more bits than its inputs")
+	}
+	if est := union.EstimateCardinality(); math.Abs(est-200) > 20 {
+		t.Fatalf("union estimates %v items, want about 200", est)
+	}
+
+	for _, other := range []*BloomFilter{NewBloomFilter(4095, 4), NewBloomFilter(4096, 3)} {
+		if err := a.Union(other); !errors.Is(err, ErrBloomMismatch) {
+			t.Errorf("union with a %d/%d filter: %v", other.size, other.hashFuncs, err)
+		}
+		if err := a.Intersect(other); !errors.Is(err, ErrBloomMismatch) {
+			t.Errorf("intersection with a %d/%d filter: %v", other.size, other.hashFuncs, err)
+		}
+	}
+}
+
+// boolBloomFilter is the filter as it was before bits were packed, one bool
+// per bit
+type boolBloomFilter struct {
+	bits      []bool
+	hashFuncs uint
+}
+
+func (bf *boolBloomFilter) add(item string) {
+	for i := uint(0); i < bf.hashFuncs; i++ {
+		bf.bits[simpleHash(item, i)%uint64(len(bf.bits))] = true
+	}
+}
+
+func (bf *boolBloomFilter) test(item string) bool {
+	for i := uint(0); i < bf.hashFuncs; i++ {
+		if !bf.bits[simpleHash(item, i)%uint64(len(bf.bits))] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBloomFilterMatchesBoolFilter(t *testing.T) {
+	for _, shape := range []struct{ size, funcs uint }{{1, 1}, {63, 2}, {64, 3}, {1000, 4}, {4099, 7}} {
+		packed := NewBloomFilter(shape.size, shape.funcs)
+		old := &boolBloomFilter{bits: make([]bool, shape.size), hashFuncs: shape.funcs}
+		for i := 0; i < 300; i++ {
+			item := fmt.Sprintf("added-%d", i)
+			packed.Add(item)
+			old.add(item)
+		}
+		for i := 0; i < 2000; i++ {
+			item := fmt.Sprintf("added-%d", i) // the first 300 were added
+			if packed.Test(item) != old.test(item) {
+				t.Fatalf("%d/%d filter: %s answers %v, bool filter %v", shape.size, shape.funcs, item, packed.Test(item), old.test(item))
+			}
+		}
+		set := 0
+		for _, bit := range old.bits {
+			if bit {
+				set++
+			}
+		}
+		if packed.BitsSet() != set {
+			t.Fatalf("%d/%d filter: %d bits set, bool filter %d", shape.size, shape.funcs, packed.BitsSet(), set)
+		}
+		if want := math.Pow(float64(set)/float64(shape.size), float64(shape.funcs)); packed.FalsePositiveRate() != want {
+			t.Fatalf("%d/%d filter: false positive rate %v, want %v", shape.size, shape.funcs, packed.FalsePositiveRate(), want)
+		}
+	}
+}
+
+func TestBloomFilterConcurrentAddAndTest(t *testing.T) {
+	filter := NewBloomFilter(1<<14, 4)
+	const workers, items = 32, 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < items; i++ {
+				item := fmt.Sprintf("w%d-%d", w, i)
+				filter.Add(item)
+				if !filter.Test(item) {
+					t.Errorf("%s missing right after it was added", item)
+					return
+				}
+				// Probe another worker's items while it writes them
+				filter.Test(fmt.Sprintf("w%d-%d", (w+1)%workers, i))
+				_ = filter.FalsePositiveRate()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		for i := 0; i < items; i++ {
+			if item := fmt.Sprintf("w%d-%d", w, i); !filter.Test(item) {
+				t.Fatalf("%s missing after concurrent adds", item)
+			}
+		}
+	}
+	// No newly set bit went uncounted or was counted twice
+	set := 0
+	for _, word := range filter.bits {
+		set += bits.OnesCount64(word)
+	}
+	if filter.BitsSet() != set {
+		t.Fatalf("%d bits counted, %d set", filter.BitsSet(), set)
+	}
+}
+
+func TestSyncBlocksSkipsBlocksTheDestinationHas(t *testing.T) {
+	source, destination := NewShard(0), NewShard(1)
+	prev := GenesisBlock()
+	var shared []Block
+	for i, data := range testLeaves(20) {
+		prev = mustGenerateBlock(t, prev, data)
+		source.AddBlock(prev)
+		if i%4 == 0 {
+			destination.AddBlock(prev)
+			shared = append(shared, prev)
+		}
+	}
+	filter, err := destination.BlockFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, skipped, err := NewSyncManager().SyncBlocks(source, destination, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved+skipped != 20 || skipped < len(shared) {
+		t.Fatalf("moved %d and skipped %d of 20 blocks", moved, skipped)
+	}
+	for _, b := range shared {
+		found := false
+		for _, held := range source.Blocks {
+			found = found || held.Hash == b.Hash
+		}
+		if !found {
+			t.Fatalf("block %s the destination had was moved", b.Hash)
+		}
+	}
+}
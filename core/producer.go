@@ -0,0 +1,184 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Errors returned by the block producer
+var (
+	ErrNoTransactions  = errors.New("no pending transactions")
+	ErrConsensusFailed = errors.New("consensus rejected the block")
+)
+
+// BlockProducer drains the mempool into blocks appended to a Blockchain
+type BlockProducer struct {
+	chain     *Blockchain
+	mempool   *Mempool
+	maxTxs    int
+	consensus *ConsensusManager        // Optional; nil appends without consensus
+	clock     *AdaptiveCapacityManager // Optional; stamps blocks with its vector clock
+	nodeID    string                   // Node the clock ticks for
+	reserve   float64                  // Share of maxTxs kept for High priority transactions
+	stop      chan struct{}
+	mu        sync.Mutex
+}
+
+// NewBlockProducer creates a producer packing up to maxTxs transactions per block
+func NewBlockProducer(chain *Blockchain, mempool *Mempool, maxTxs int) *BlockProducer {
+	return &BlockProducer{
+		chain:   chain,
+		mempool: mempool,
+		maxTxs:  maxTxs,
+	}
+}
+
+// SetConsensus runs every produced block through consensus before appending
+func (bp *BlockProducer) SetConsensus(cm *ConsensusManager) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.consensus = cm
+}
+
+// SetCausalityClock stamps every produced block with the vector clock of
+// acm after ticking it for nodeID, the producing node. A nil acm stops
+// stamping.
+func (bp *BlockProducer) SetCausalityClock(acm *AdaptiveCapacityManager, nodeID string) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.clock = acm
+	bp.nodeID = nodeID
+}
+
+// SetHighPriorityReserve keeps fraction of each block's maxTxs slots for
+// transactions submitted with High priority: while such transactions are
+// pending, others, including ones aged up to High, fill at most the rest.
+// Slots a block cannot fill with High transactions go to the others.
+func (bp *BlockProducer) SetHighPriorityReserve(fraction float64) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.reserve = fraction
+}
+
+// ProduceBlock drains pending transactions into a new block
+func (bp *BlockProducer) ProduceBlock() (Block, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	txs := bp.selectTransactions(bp.candidates())
+	if len(txs) == 0 {
+		return Block{}, ErrNoTransactions
+	}
+	if bp.consensus != nil {
+		if err := bp.consensus.ReachHybridConsensus(); err != nil {
+			return Block{}, fmt.Errorf("%w: %w", ErrConsensusFailed, err)
+		}
+	}
+
+	var causality *VectorClock
+	if bp.clock != nil {
+		causality = bp.clock.Tick(bp.nodeID)
+	}
+	block, err := bp.chain.addTransactionBlock(txs, nil, causality)
+	if err != nil {
+		return Block{}, err
+	}
+	bp.mempool.MarkIncluded(transactionHashes(txs))
+	return block, nil
+}
+
+// candidates takes up to maxTxs pending transactions in serving order,
+// holding back others so that High ones get the reserved slots
+func (bp *BlockProducer) candidates() []Transaction {
+	if bp.reserve <= 0 || bp.maxTxs <= 0 {
+		return bp.mempool.Pending(bp.maxTxs)
+	}
+	pending := bp.mempool.Pending(0)
+	high := 0
+	for _, tx := range pending {
+		if tx.Priority == PriorityHigh {
+			high++
+		}
+	}
+	reserved := int(math.Ceil(bp.reserve * float64(bp.maxTxs)))
+	if reserved > bp.maxTxs {
+		reserved = bp.maxTxs
+	}
+	if reserved > high {
+		reserved = high
+	}
+
+	var selected []Transaction
+	others := 0
+	for _, tx := range pending {
+		if len(selected) == bp.maxTxs {
+			break
+		}
+		if tx.Priority != PriorityHigh {
+			if others == bp.maxTxs-reserved {
+				continue
+			}
+			others++
+		}
+		selected = append(selected, tx)
+	}
+	return selected
+}
+
+// selectTransactions keeps the candidates that apply cleanly to the tip state.
+// Transactions whose nonce was already used can never apply and are dropped
+// from the mempool; the rest stay pending for a later block.
+func (bp *BlockProducer) selectTransactions(candidates []Transaction) []Transaction {
+	state := bp.chain.State()
+	var selected []Transaction
+	var stale []string
+	for _, tx := range candidates {
+		if tx.Nonce < state.NonceOf(tx.From) {
+			stale = append(stale, tx.Hash())
+			continue
+		}
+		if state.ApplyTransaction(tx) == nil {
+			selected = append(selected, tx)
+		}
+	}
+	bp.mempool.Remove(stale)
+	return selected
+}
+
+// Start produces a block every interval until Stop is called
+func (bp *BlockProducer) Start(interval time.Duration) {
+	bp.mu.Lock()
+	if bp.stop != nil {
+		bp.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	bp.stop = stop
+	bp.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bp.ProduceBlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts periodic block production
+func (bp *BlockProducer) Stop() {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.stop != nil {
+		close(bp.stop)
+		bp.stop = nil
+	}
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	cryptorand "crypto/rand"
+	"math/rand"
+)
+
+// RandomSource supplies the randomness used by simulations. A seeded
+// *rand.Rand satisfies it and makes runs reproducible; it is not safe for
+// concurrent use, so share one only between components used together.
+type RandomSource interface {
+	Float64() float64
+	Intn(n int) int
+	Read(p []byte) (int, error)
+}
+
+// defaultSource keeps the historical behavior: math/rand's global source for
+// numbers and crypto/rand for bytes
+type defaultSource struct{}
+
+func (defaultSource) Float64() float64           { return rand.Float64() }
+func (defaultSource) Intn(n int) int             { return rand.Intn(n) }
+func (defaultSource) Read(p []byte) (int, error) { return cryptorand.Read(p) }
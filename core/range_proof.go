@@ -0,0 +1,188 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrInvalidRangeProof is returned when a committed amount is not proven to
+// lie within range
+var ErrInvalidRangeProof = errors.New("invalid range proof")
+
+// RangeProofBits bounds transfer amounts to [0, 2^RangeProofBits)
+const RangeProofBits = 32
+
+// Commitment is a Pedersen commitment v*G + r*H on P-256
+type Commitment PublicKey
+
+// String returns the hex compressed encoding of the commitment
+func (c Commitment) String() string {
+	return hex.EncodeToString(PublicKey(c).Bytes())
+}
+
+// pedersenH is the second generator, derived by hashing to the curve so
+// that nobody knows its discrete logarithm with respect to G
+var pedersenH = hashToCurve("pedersen-generator-H")
+
+// hashToCurve finds a point by try-and-increment on SHA-256 of label
+func hashToCurve(label string) PublicKey {
+	params := thresholdCurve.Params()
+	three := big.NewInt(3)
+	for counter := 0; ; counter++ {
+		digest := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", label, counter)))
+		x := new(big.Int).Mod(new(big.Int).SetBytes(digest[:]), params.P)
+		// y^2 = x^3 - 3x + b
+		y2 := new(big.Int).Exp(x, three, params.P)
+		y2.Sub(y2, new(big.Int).Mul(x, three))
+		y2.Add(y2, params.B)
+		y2.Mod(y2, params.P)
+		if y := new(big.Int).ModSqrt(y2, params.P); y != nil {
+			return PublicKey{X: x, Y: y}
+		}
+	}
+}
+
+// Commit returns the Pedersen commitment to value with the given blinding
+func Commit(value, blinding *big.Int) Commitment {
+	return Commitment(addPoints(scalarBaseMult(value), scalarMult(pedersenH, blinding)))
+}
+
+// BitProof shows that a commitment opens to 0 or 1 without revealing which,
+// as a disjunction of two Schnorr proofs over H
+type BitProof struct {
+	Commitment Commitment
+	E0, E1     *big.Int // Branch challenges; E0 + E1 is the Fiat-Shamir challenge
+	Z0, Z1     *big.Int // Branch responses
+}
+
+// RangeProof shows that a commitment opens to a value in [0, 2^bits) by
+// committing to each bit, least significant first. The bit commitments
+// weighted by powers of two sum to the value's commitment.
+type RangeProof struct {
+	Bits []BitProof
+}
+
+// ProveRange proves that Commit(value, blinding) opens to a value of at
+// most bits bits. A value outside the range, including a negative value
+// reduced modulo the group order, yields a proof that does not verify.
+func ProveRange(value, blinding *big.Int, bits int) RangeProof {
+	n := thresholdCurve.Params().N
+	commitment := Commit(value, blinding)
+	v := new(big.Int).Mod(value, n)
+
+	// Blindings for all but the top bit are random; the top one is chosen so
+	// the weighted bit blindings sum to the value's blinding
+	blindings := make([]*big.Int, bits)
+	weighted := big.NewInt(0)
+	for i := 0; i < bits-1; i++ {
+		blindings[i] = randomScalar()
+		weighted.Add(weighted, new(big.Int).Lsh(blindings[i], uint(i)))
+	}
+	if bits > 0 {
+		top := new(big.Int).Sub(blinding, weighted)
+		inverse := new(big.Int).ModInverse(new(big.Int).Lsh(big.NewInt(1), uint(bits-1)), n)
+		blindings[bits-1] = top.Mul(top, inverse).Mod(top, n)
+	}
+
+	proof := RangeProof{Bits: make([]BitProof, bits)}
+	for i := 0; i < bits; i++ {
+		proof.Bits[i] = proveBit(commitment, i, v.Bit(i), blindings[i])
+	}
+	return proof
+}
+
+// proveBit commits to bit b with blinding r and proves it is 0 or 1
+func proveBit(commitment Commitment, index int, b uint, r *big.Int) BitProof {
+	n := thresholdCurve.Params().N
+	c := Commit(big.NewInt(int64(b)), r)
+	branches := bitBranches(c)
+
+	// Simulate the branch that is false, prove the true one honestly
+	simulated := 1 - b
+	eSim, zSim := randomScalar(), randomScalar()
+	k := randomScalar()
+	var nonces [2]PublicKey
+	nonces[b] = scalarMult(pedersenH, k)
+	nonces[simulated] = addPoints(scalarMult(pedersenH, zSim), negate(scalarMult(branches[simulated], eSim)))
+
+	e := bitChallenge(commitment, index, c, nonces)
+	eReal := new(big.Int).Sub(e, eSim)
+	eReal.Mod(eReal, n)
+	zReal := new(big.Int).Mul(eReal, r)
+	zReal.Add(zReal, k).Mod(zReal, n)
+
+	proof := BitProof{Commitment: c}
+	if b == 0 {
+		proof.E0, proof.Z0, proof.E1, proof.Z1 = eReal, zReal, eSim, zSim
+	} else {
+		proof.E0, proof.Z0, proof.E1, proof.Z1 = eSim, zSim, eReal, zReal
+	}
+	return proof
+}
+
+// VerifyRange checks that commitment opens to a value in [0, 2^bits)
+func VerifyRange(commitment Commitment, proof RangeProof, bits int) bool {
+	if bits < 1 || len(proof.Bits) != bits || !onCurve(PublicKey(commitment)) {
+		return false
+	}
+	n := thresholdCurve.Params().N
+	var sum PublicKey
+	for i, bp := range proof.Bits {
+		if !onCurve(PublicKey(bp.Commitment)) || !validScalar(bp.E0, n) || !validScalar(bp.E1, n) ||
+			!validScalar(bp.Z0, n) || !validScalar(bp.Z1, n) {
+			return false
+		}
+		branches := bitBranches(bp.Commitment)
+		var nonces [2]PublicKey
+		nonces[0] = addPoints(scalarMult(pedersenH, bp.Z0), negate(scalarMult(branches[0], bp.E0)))
+		nonces[1] = addPoints(scalarMult(pedersenH, bp.Z1), negate(scalarMult(branches[1], bp.E1)))
+		e := new(big.Int).Add(bp.E0, bp.E1)
+		if e.Mod(e, n).Cmp(bitChallenge(commitment, i, bp.Commitment, nonces)) != 0 {
+			return false
+		}
+		sum = addPoints(sum, scalarMult(PublicKey(bp.Commitment), new(big.Int).Lsh(big.NewInt(1), uint(i))))
+	}
+	return pointsEqual(sum, PublicKey(commitment))
+}
+
+// bitBranches returns the points that are multiples of H exactly when the
+// bit commitment c opens to 0 and to 1 respectively
+func bitBranches(c Commitment) [2]PublicKey {
+	return [2]PublicKey{PublicKey(c), addPoints(PublicKey(c), negate(scalarBaseMult(big.NewInt(1))))}
+}
+
+// bitChallenge is the Fiat-Shamir challenge for one bit, bound to the value
+// commitment and the bit's position
+func bitChallenge(commitment Commitment, index int, c Commitment, nonces [2]PublicKey) *big.Int {
+	h := sha256.New()
+	fmt.Fprintf(h, "range:%d:", index)
+	h.Write(PublicKey(commitment).Bytes())
+	h.Write(PublicKey(c).Bytes())
+	h.Write(nonces[0].Bytes())
+	h.Write(nonces[1].Bytes())
+	return hashToScalar(h.Sum(nil))
+}
+
+// negate returns -p
+func negate(p PublicKey) PublicKey {
+	if p.X == nil {
+		return p
+	}
+	return PublicKey{X: new(big.Int).Set(p.X), Y: new(big.Int).Sub(thresholdCurve.Params().P, p.Y)}
+}
+
+func randomScalar() *big.Int {
+	k, err := rand.Int(rand.Reader, thresholdCurve.Params().N)
+	if err != nil {
+		panic(fmt.Sprintf("generating scalar: %v", err))
+	}
+	return k
+}
+
+func validScalar(k, n *big.Int) bool {
+	return k != nil && k.Sign() >= 0 && k.Cmp(n) < 0
+}
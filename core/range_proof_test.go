@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestRangeProofAcceptsValuesInRange(t *testing.T) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), RangeProofBits), big.NewInt(1))
+	for _, value := range []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(1 << 31), max} {
+		blinding := randomScalar()
+		commitment := Commit(value, blinding)
+		proof := ProveRange(value, blinding, RangeProofBits)
+		if !VerifyRange(commitment, proof, RangeProofBits) {
+			t.Fatalf("proof for %v rejected", value)
+		}
+		if VerifyRange(Commit(value, randomScalar()), proof, RangeProofBits) {
+			t.Fatalf("proof for %v verified against another commitment", value)
+		}
+		if VerifyRange(commitment, proof, RangeProofBits-1) {
+			t.Fatalf("proof for %v verified for a different bit count", value)
+		}
+	}
+
+	// Small bit widths are exact
+	for v := int64(0); v < 8; v++ {
+		blinding := randomScalar()
+		if ok := VerifyRange(Commit(big.NewInt(v), blinding), ProveRange(big.NewInt(v), blinding, 2), 2); ok != (v < 4) {
+			t.Fatalf("2-bit proof of %d verified: %v", v, ok)
+		}
+	}
+}
+
+func TestRangeProofRejectsOutOfRangeValues(t *testing.T) {
+	n := thresholdCurve.Params().N
+	for name, value := range map[string]*big.Int{
+		"bit bound":     new(big.Int).Lsh(big.NewInt(1), RangeProofBits),
+		"far above":     new(big.Int).Lsh(big.NewInt(1), 200),
+		"negative":      big.NewInt(-1),
+		"wrapped -1":    new(big.Int).Sub(n, big.NewInt(1)),
+		"wrapped -2^31": new(big.Int).Sub(n, big.NewInt(1<<31)),
+	} {
+		blinding := randomScalar()
+		if VerifyRange(Commit(value, blinding), ProveRange(value, blinding, RangeProofBits), RangeProofBits) {
+			t.Errorf("%s: out-of-range value %v verified", name, value)
+		}
+	}
+}
+
+func TestRangeProofRejectsTampering(t *testing.T) {
+	value, blinding := big.NewInt(123456), randomScalar()
+	commitment := Commit(value, blinding)
+	proof := ProveRange(value, blinding, RangeProofBits)
+
+	tampered := map[string]func(*RangeProof){
+		"truncated":       func(p *RangeProof) { p.Bits = p.Bits[:len(p.Bits)-1] },
+		"swapped bits":    func(p *RangeProof) { p.Bits[0], p.Bits[1] = p.Bits[1], p.Bits[0] },
+		"challenge split": func(p *RangeProof) { p.Bits[3].E0, p.Bits[3].E1 = p.Bits[3].E1, p.Bits[3].E0 },
+		"response":        func(p *RangeProof) { p.Bits[5].Z0 = new(big.Int).Add(p.Bits[5].Z0, big.NewInt(1)) },
+		"nil scalar":      func(p *RangeProof) { p.Bits[2].Z1 = nil },
+		"bit commitment":  func(p *RangeProof) { p.Bits[4].Commitment = Commit(big.NewInt(1), randomScalar()) },
+	}
+	for name, tamper := range tampered {
+		forged := RangeProof{Bits: append([]BitProof{}, proof.Bits...)}
+		tamper(&forged)
+		if VerifyRange(commitment, forged, RangeProofBits) {
+			t.Errorf("proof with %s verified", name)
+		}
+	}
+	if !VerifyRange(commitment, proof, RangeProofBits) {
+		t.Fatal("tampering changed the original proof")
+	}
+}
+
+func TestTransferValueRequiresRangeProof(t *testing.T) {
+	source, destination := NewShard(1), NewShard(2)
+	source.SetAddressRange(0, 127)
+	destination.SetAddressRange(128, 255)
+	if err := source.Credit("alice", 1<<33); err != nil {
+		t.Fatal(err)
+	}
+	esm := NewEnhancedSyncManager("key")
+
+	err := esm.TransferValue(source, destination, Transaction{From: "alice", To: "\xffbob", Amount: 1 << RangeProofBits})
+	if !errors.Is(err, ErrInvalidRangeProof) {
+		t.Fatalf("transfer of 2^32: %v", err)
+	}
+	if source.BalanceOf("alice") != 1<<33 || destination.BalanceOf("\xffbob") != 0 {
+		t.Fatal("out-of-range transfer moved value")
+	}
+	journal := esm.Journal()
+	if len(journal) != 1 || journal[0].Reason != ReasonRangeProofFailed || journal[0].AmountCommitment == "" {
+		t.Fatalf("journal %+v", journal)
+	}
+
+	if err := esm.TransferValue(source, destination, Transaction{From: "alice", To: "\xffbob", Amount: 1<<RangeProofBits - 1}); err != nil {
+		t.Fatalf("transfer of 2^32-1: %v", err)
+	}
+	if destination.BalanceOf("\xffbob") != 1<<RangeProofBits-1 {
+		t.Fatal("in-range transfer not credited")
+	}
+}
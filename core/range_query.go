@@ -0,0 +1,202 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInvalidRange is returned by range queries whose start is after their end
+var ErrInvalidRange = errors.New("query range start is after its end")
+
+// DefaultQueryWorkers is how many shards a range query scans at once
+const DefaultQueryWorkers = 4
+
+// WithQueryWorkers bounds how many shards QueryBlockRange and
+// QueryByTimeRange scan in parallel
+func WithQueryWorkers(n int) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.queryWorkers = n
+	}
+}
+
+// blockSpan is the range of indices and timestamps of a shard's blocks
+type blockSpan struct {
+	count    int
+	minIndex int
+	maxIndex int
+	first    time.Time
+	last     time.Time
+}
+
+// extend widens the span to cover b
+func (sp *blockSpan) extend(b Block) {
+	if sp.count == 0 || b.Index < sp.minIndex {
+		sp.minIndex = b.Index
+	}
+	if sp.count == 0 || b.Index > sp.maxIndex {
+		sp.maxIndex = b.Index
+	}
+	if sp.count == 0 || b.Timestamp.Before(sp.first) {
+		sp.first = b.Timestamp
+	}
+	if sp.count == 0 || b.Timestamp.After(sp.last) {
+		sp.last = b.Timestamp
+	}
+	sp.count++
+}
+
+// blockSpan returns the span of the shard's blocks, recomputing it if
+// Blocks changed other than by AddBlock since; the caller must hold the
+// shard mutex
+func (s *Shard) blockSpan() blockSpan {
+	if s.span == nil || s.spanAt != s.version {
+		s.span = &blockSpan{}
+		for _, b := range s.Blocks {
+			s.span.extend(b)
+		}
+		s.spanAt = s.version
+	}
+	return *s.span
+}
+
+// IndexRange returns the lowest and highest block index in the shard; ok
+// is false if it holds no blocks
+func (s *Shard) IndexRange() (low, high int, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	span := s.blockSpan()
+	return span.minIndex, span.maxIndex, span.count > 0
+}
+
+// TimeRange returns the earliest and latest block timestamp in the shard;
+// ok is false if it holds no blocks
+func (s *Shard) TimeRange() (first, last time.Time, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	span := s.blockSpan()
+	return span.first, span.last, span.count > 0
+}
+
+// QueryBlockRange returns every block with an index from fromIndex to
+// toIndex inclusive, in index order. Only shards whose index range overlaps
+// the query are scanned.
+func (sm *ShardManager) QueryBlockRange(fromIndex, toIndex int) ([]Block, error) {
+	if fromIndex > toIndex {
+		return nil, fmt.Errorf("%w: %d > %d", ErrInvalidRange, fromIndex, toIndex)
+	}
+	blocks, err := sm.queryShards(
+		func(span blockSpan) bool { return span.maxIndex >= fromIndex && span.minIndex <= toIndex },
+		func(b Block) bool { return b.Index >= fromIndex && b.Index <= toIndex },
+	)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if blocks[i].Index != blocks[j].Index {
+			return blocks[i].Index < blocks[j].Index
+		}
+		return blocks[i].Hash < blocks[j].Hash
+	})
+	return blocks, nil
+}
+
+// QueryByTimeRange returns every block with a timestamp from from to to
+// inclusive, in timestamp order and index order among equals. Only shards
+// whose time range overlaps the query are scanned.
+func (sm *ShardManager) QueryByTimeRange(from, to time.Time) ([]Block, error) {
+	if from.After(to) {
+		return nil, fmt.Errorf("%w: %s > %s", ErrInvalidRange, from.Format(time.RFC3339Nano), to.Format(time.RFC3339Nano))
+	}
+	blocks, err := sm.queryShards(
+		func(span blockSpan) bool { return !span.last.Before(from) && !span.first.After(to) },
+		func(b Block) bool { return !b.Timestamp.Before(from) && !b.Timestamp.After(to) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if !blocks[i].Timestamp.Equal(blocks[j].Timestamp) {
+			return blocks[i].Timestamp.Before(blocks[j].Timestamp)
+		}
+		if blocks[i].Index != blocks[j].Index {
+			return blocks[i].Index < blocks[j].Index
+		}
+		return blocks[i].Hash < blocks[j].Hash
+	})
+	return blocks, nil
+}
+
+// queryShards scans the shards whose span overlaps the query with a pool
+// of workers and returns the matching blocks, bodies included, unordered
+func (sm *ShardManager) queryShards(overlaps func(blockSpan) bool, match func(Block) bool) ([]Block, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	var plan []*Shard
+	for _, shard := range sm.Shards.GetAllShards() {
+		shard.mutex.Lock()
+		span := shard.blockSpan()
+		shard.mutex.Unlock()
+		if span.count > 0 && overlaps(span) {
+			plan = append(plan, shard)
+		}
+	}
+
+	workers := sm.queryWorkers
+	if workers < 1 {
+		workers = DefaultQueryWorkers
+	}
+	if workers > len(plan) {
+		workers = len(plan)
+	}
+	jobs := make(chan *Shard)
+	var blocks []Block
+	var firstErr error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range jobs {
+				found, err := shard.scanBlocks(match)
+				mu.Lock()
+				blocks = append(blocks, found...)
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, shard := range plan {
+		jobs <- shard
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return blocks, nil
+}
+
+// scanBlocks returns the shard's blocks that match, with their bodies
+func (s *Shard) scanBlocks(match func(Block) bool) ([]Block, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var found []Block
+	for i, b := range s.Blocks {
+		if !match(b) {
+			continue
+		}
+		full, err := s.fullBlock(i)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, full)
+	}
+	return found, nil
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// rangeManager distributes n blocks over shards of at most 4 blocks,
+// scanned by the given number of workers
+func rangeManager(t *testing.T, n, workers int) (*ShardManager, []Block) {
+	t.Helper()
+	sm := NewShardManager(WithQueryWorkers(workers))
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	blocks := fixedBlocks(n)
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+	if sm.Shards.Size() < 5 {
+		t.Fatalf("%d blocks held in %d shards", n, sm.Shards.Size())
+	}
+	return sm, blocks
+}
+
+// indices returns the indices of blocks in order
+func indices(blocks []Block) string {
+	got := make([]int, len(blocks))
+	for i, b := range blocks {
+		got[i] = b.Index
+	}
+	return fmt.Sprint(got)
+}
+
+// indexSpan returns the indices from low to high
+func indexSpan(low, high int) string {
+	var want []int
+	for i := low; i <= high; i++ {
+		want = append(want, i)
+	}
+	return fmt.Sprint(want)
+}
+
+func TestQueryBlockRangeAcrossShards(t *testing.T) {
+	for _, workers := range []int{0, 1, 3} {
+		sm, blocks := rangeManager(t, 30, workers)
+		for _, tc := range []struct{ from, to int }{
+			{1, 30}, {3, 5}, {4, 13}, {7, 7}, {-10, 2}, {29, 100}, {31, 40},
+		} {
+			got, err := sm.QueryBlockRange(tc.from, tc.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			low, high := tc.from, tc.to
+			if low < 1 {
+				low = 1
+			}
+			if high > len(blocks) {
+				high = len(blocks)
+			}
+			if indices(got) != indexSpan(low, high) && !(low > high && len(got) == 0) {
+				t.Fatalf("%d workers: blocks %d to %d gave %s", workers, tc.from, tc.to, indices(got))
+			}
+			for _, b := range got {
+				if b.Hash != blocks[b.Index-1].Hash || b.Data != blocks[b.Index-1].Data {
+					t.Fatalf("block #%d returned altered", b.Index)
+				}
+			}
+		}
+	}
+
+	sm, _ := rangeManager(t, 30, 0)
+	if _, err := sm.QueryBlockRange(5, 4); !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("reversed range: %v", err)
+	}
+}
+
+func TestQueryByTimeRange(t *testing.T) {
+	sm, blocks := rangeManager(t, 30, 2)
+	from, to := blocks[5].Timestamp, blocks[17].Timestamp
+	got, err := sm.QueryByTimeRange(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indices(got) != indexSpan(6, 18) {
+		t.Fatalf("blocks from %s to %s: %s", from, to, indices(got))
+	}
+	// Bounds between timestamps and outside every shard
+	got, _ = sm.QueryByTimeRange(from.Add(time.Millisecond), to.Add(-time.Millisecond))
+	if indices(got) != indexSpan(7, 17) {
+		t.Fatalf("blocks strictly between: %s", indices(got))
+	}
+	if got, err := sm.QueryByTimeRange(to.Add(time.Hour), to.Add(2*time.Hour)); err != nil || len(got) != 0 {
+		t.Fatalf("blocks after the last: %v, %v", indices(got), err)
+	}
+	if _, err := sm.QueryByTimeRange(to, from); !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("reversed range: %v", err)
+	}
+}
+
+func TestRangeQueriesFollowMovedBlocks(t *testing.T) {
+	sm, _ := rangeManager(t, 30, 2)
+	shards := sm.Shards.GetAllShards()
+	source, destination := shards[0], shards[len(shards)-1]
+	moved := source.View().Blocks[0]
+	if err := sm.TransferBlock(NewEnhancedSyncManager("key"), source.ID, destination.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	low, high, _ := destination.IndexRange()
+	if low != moved.Index {
+		t.Fatalf("destination covers %d to %d after receiving #%d", low, high, moved.Index)
+	}
+	if first, _, _ := destination.TimeRange(); !first.Equal(moved.Timestamp) {
+		t.Fatalf("destination starts at %s after receiving a block from %s", first, moved.Timestamp)
+	}
+	if low, _, _ := source.IndexRange(); low == moved.Index {
+		t.Fatal("source still covers the moved block")
+	}
+	if got, err := sm.QueryBlockRange(1, 30); err != nil || indices(got) != indexSpan(1, 30) {
+		t.Fatalf("after the transfer: %s, %v", indices(got), err)
+	}
+
+	// And after merges rebuild the shards
+	sm.MergeShards(100)
+	if got, err := sm.QueryBlockRange(1, 30); err != nil || indices(got) != indexSpan(1, 30) {
+		t.Fatalf("after merging: %s, %v", indices(got), err)
+	}
+	if _, _, ok := NewShard(9).IndexRange(); ok {
+		t.Fatal("empty shard has an index range")
+	}
+}
+
+func TestRangeQueriesSkipShardsOutsideTheRange(t *testing.T) {
+	sm, _ := rangeManager(t, 30, 2)
+	// The first shard loses its bodies, so scanning it fails
+	shard := sm.Shards.GetAllShards()[0]
+	low, high, _ := shard.IndexRange()
+	shard.mutex.Lock()
+	for i, b := range shard.Blocks {
+		shard.Blocks[i] = b.Header().Block()
+	}
+	shard.mutex.Unlock()
+
+	if _, err := sm.QueryBlockRange(high+1, 30); err != nil {
+		t.Fatalf("query beyond shard #%d scanned it: %v", shard.ID, err)
+	}
+	if _, err := sm.QueryBlockRange(low, low); !errors.Is(err, ErrBodyNotFound) {
+		t.Fatalf("query within shard #%d: %v", shard.ID, err)
+	}
+}
@@ -1,7 +1,7 @@
 package core
 
 import (
-	"fmt"
+	"cmp"
 )
 
 // Color represents the color of a Red-Black Tree node
@@ -13,55 +13,66 @@ const (
 )
 
 // RBNode represents a node in the Red-Black Tree
-type RBNode struct {
-	Shard  *Shard  // Shard metadata
-	Color  Color   // Red or Black
-	Left   *RBNode // Left child (corrected from RBpfNode)
-	Right  *RBNode // Right child
-	Parent *RBNode // Parent node
+type RBNode[K cmp.Ordered, V any] struct {
+	Key    K
+	Value  V
+	Color  Color         // Red or Black
+	Left   *RBNode[K, V] // Left child
+	Right  *RBNode[K, V] // Right child
+	Parent *RBNode[K, V] // Parent node
 }
 
-// RBTree represents a Red-Black Tree for shard indexing
-type RBTree struct {
-	Nil  *RBNode // Sentinel node for nil leaves
-	Root *RBNode // Root of the tree
+// RBTree is a Red-Black Tree mapping ordered keys to values
+type RBTree[K cmp.Ordered, V any] struct {
+	Nil  *RBNode[K, V] // Sentinel node for nil leaves
+	Root *RBNode[K, V] // Root of the tree
+	size int           // Number of keys stored
+}
+
+// RBEntry is a key-value pair returned by range queries
+type RBEntry[K cmp.Ordered, V any] struct {
+	Key   K
+	Value V
 }
 
 // NewRBTree creates a new Red-Black Tree
-func NewRBTree() *RBTree {
-	nilNode := &RBNode{Color: Black}
-	return &RBTree{
+func NewRBTree[K cmp.Ordered, V any]() *RBTree[K, V] {
+	nilNode := &RBNode[K, V]{Color: Black}
+	return &RBTree[K, V]{
 		Nil:  nilNode,
 		Root: nilNode,
 	}
 }
 
-// Insert adds a shard to the Red-Black Tree
-func (t *RBTree) Insert(shard *Shard) {
-	node := &RBNode{
-		Shard:  shard,
-		Color:  Red,
-		Left:   t.Nil,
-		Right:  t.Nil,
-		Parent: t.Nil,
-	}
-
+// Insert stores a value under a key, replacing any existing value
+func (t *RBTree[K, V]) Insert(key K, value V) {
 	// Standard BST insertion
 	current := t.Root
 	parent := t.Nil
 	for current != t.Nil {
 		parent = current
-		if shard.ID < current.Shard.ID {
+		switch {
+		case key < current.Key:
 			current = current.Left
-		} else {
+		case key > current.Key:
 			current = current.Right
+		default:
+			current.Value = value
+			return
 		}
 	}
 
-	node.Parent = parent
+	node := &RBNode[K, V]{
+		Key:    key,
+		Value:  value,
+		Color:  Red,
+		Left:   t.Nil,
+		Right:  t.Nil,
+		Parent: parent,
+	}
 	if parent == t.Nil {
 		t.Root = node
-	} else if shard.ID < parent.Shard.ID {
+	} else if key < parent.Key {
 		parent.Left = node
 	} else {
 		parent.Right = node
@@ -69,10 +80,11 @@ func (t *RBTree) Insert(shard *Shard) {
 
 	// Fix Red-Black Tree properties
 	t.fixInsert(node)
+	t.size++
 }
 
 // fixInsert balances the tree after insertion
-func (t *RBTree) fixInsert(node *RBNode) {
+func (t *RBTree[K, V]) fixInsert(node *RBNode[K, V]) {
 	for node.Parent.Color == Red {
 		if node.Parent == node.Parent.Parent.Left {
 			uncle := node.Parent.Parent.Right
@@ -114,8 +126,156 @@ func (t *RBTree) fixInsert(node *RBNode) {
 	t.Root.Color = Black
 }
 
+// Get returns the value stored under a key in O(log n) time
+func (t *RBTree[K, V]) Get(key K) (V, bool) {
+	if node := t.find(key); node != t.Nil {
+		return node.Value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// find returns the node holding key, or the sentinel
+func (t *RBTree[K, V]) find(key K) *RBNode[K, V] {
+	current := t.Root
+	for current != t.Nil {
+		switch {
+		case key < current.Key:
+			current = current.Left
+		case key > current.Key:
+			current = current.Right
+		default:
+			return current
+		}
+	}
+	return t.Nil
+}
+
+// Delete removes a key and reports whether it was present
+func (t *RBTree[K, V]) Delete(key K) bool {
+	z := t.find(key)
+	if z == t.Nil {
+		return false
+	}
+
+	y := z
+	yColor := y.Color
+	var x *RBNode[K, V]
+	if z.Left == t.Nil {
+		x = z.Right
+		t.transplant(z, z.Right)
+	} else if z.Right == t.Nil {
+		x = z.Left
+		t.transplant(z, z.Left)
+	} else {
+		y = t.minimum(z.Right)
+		yColor = y.Color
+		x = y.Right
+		if y.Parent == z {
+			x.Parent = y
+		} else {
+			t.transplant(y, y.Right)
+			y.Right = z.Right
+			y.Right.Parent = y
+		}
+		t.transplant(z, y)
+		y.Left = z.Left
+		y.Left.Parent = y
+		y.Color = z.Color
+	}
+	if yColor == Black {
+		t.fixDelete(x)
+	}
+	t.size--
+	return true
+}
+
+// transplant replaces the subtree rooted at u with the one rooted at v
+func (t *RBTree[K, V]) transplant(u, v *RBNode[K, V]) {
+	if u.Parent == t.Nil {
+		t.Root = v
+	} else if u == u.Parent.Left {
+		u.Parent.Left = v
+	} else {
+		u.Parent.Right = v
+	}
+	v.Parent = u.Parent
+}
+
+// fixDelete restores Red-Black properties after removing a black node
+func (t *RBTree[K, V]) fixDelete(x *RBNode[K, V]) {
+	for x != t.Root && x.Color == Black {
+		if x == x.Parent.Left {
+			w := x.Parent.Right
+			if w.Color == Red {
+				w.Color = Black
+				x.Parent.Color = Red
+				t.leftRotate(x.Parent)
+				w = x.Parent.Right
+			}
+			if w.Left.Color == Black && w.Right.Color == Black {
+				w.Color = Red
+				x = x.Parent
+			} else {
+				if w.Right.Color == Black {
+					w.Left.Color = Black
+					w.Color = Red
+					t.rightRotate(w)
+					w = x.Parent.Right
+				}
+				w.Color = x.Parent.Color
+				x.Parent.Color = Black
+				w.Right.Color = Black
+				t.leftRotate(x.Parent)
+				x = t.Root
+			}
+		} else {
+			w := x.Parent.Left
+			if w.Color == Red {
+				w.Color = Black
+				x.Parent.Color = Red
+				t.rightRotate(x.Parent)
+				w = x.Parent.Left
+			}
+			if w.Right.Color == Black && w.Left.Color == Black {
+				w.Color = Red
+				x = x.Parent
+			} else {
+				if w.Left.Color == Black {
+					w.Right.Color = Black
+					w.Color = Red
+					t.leftRotate(w)
+					w = x.Parent.Left
+				}
+				w.Color = x.Parent.Color
+				x.Parent.Color = Black
+				w.Left.Color = Black
+				t.rightRotate(x.Parent)
+				x = t.Root
+			}
+		}
+	}
+	x.Color = Black
+}
+
+// minimum returns the leftmost node of a subtree
+func (t *RBTree[K, V]) minimum(node *RBNode[K, V]) *RBNode[K, V] {
+	for node.Left != t.Nil {
+		node = node.Left
+	}
+	return node
+}
+
+// maximum returns the rightmost node of a subtree
+func (t *RBTree[K, V]) maximum(node *RBNode[K, V]) *RBNode[K, V] {
+	for node.Right != t.Nil {
+		node = node.Right
+	}
+	return node
+}
+
 // leftRotate performs a left rotation on a node
-func (t *RBTree) leftRotate(x *RBNode) {
+func (t *RBTree[K, V]) leftRotate(x *RBNode[K, V]) {
 	y := x.Right
 	x.Right = y.Left
 	if y.Left != t.Nil {
@@ -134,7 +294,7 @@ func (t *RBTree) leftRotate(x *RBNode) {
 }
 
 // rightRotate performs a right rotation on a node
-func (t *RBTree) rightRotate(x *RBNode) {
+func (t *RBTree[K, V]) rightRotate(x *RBNode[K, V]) {
 	y := x.Left
 	x.Left = y.Right
 	if y.Right != t.Nil {
@@ -152,56 +312,97 @@ func (t *RBTree) rightRotate(x *RBNode) {
 	x.Parent = y
 }
 
-// FindShard retrieves a shard by ID in O(log n) time
-func (t *RBTree) FindShard(id int) (*Shard, bool) {
-	current := t.Root
-	for current != t.Nil {
-		if id == current.Shard.ID {
-			return current.Shard, true
-		} else if id < current.Shard.ID {
-			current = current.Left
-		} else {
-			current = current.Right
-		}
+// Range returns the entries with lo <= key <= hi in key order
+func (t *RBTree[K, V]) Range(lo, hi K) []RBEntry[K, V] {
+	var entries []RBEntry[K, V]
+	t.rangeNode(t.Root, lo, hi, &entries)
+	return entries
+}
+
+func (t *RBTree[K, V]) rangeNode(node *RBNode[K, V], lo, hi K, entries *[]RBEntry[K, V]) {
+	if node == t.Nil {
+		return
+	}
+	if lo < node.Key {
+		t.rangeNode(node.Left, lo, hi, entries)
+	}
+	if lo <= node.Key && node.Key <= hi {
+		*entries = append(*entries, RBEntry[K, V]{Key: node.Key, Value: node.Value})
+	}
+	if node.Key < hi {
+		t.rangeNode(node.Right, lo, hi, entries)
 	}
-	return nil, false
 }
 
-// GetAllShards collects all shards in the tree
-func (t *RBTree) GetAllShards() []*Shard {
-	var shards []*Shard
-	t.inOrderTraversal(t.Root, &shards)
-	return shards
+// Each calls fn for every entry in key order without collecting them
+func (t *RBTree[K, V]) Each(fn func(key K, value V)) {
+	t.visit(t.Root, fn)
 }
 
-// inOrderTraversal collects shards in-order
-func (t *RBTree) inOrderTraversal(node *RBNode, shards *[]*Shard) {
+func (t *RBTree[K, V]) visit(node *RBNode[K, V], fn func(key K, value V)) {
 	if node != t.Nil {
-		t.inOrderTraversal(node.Left, shards)
-		*shards = append(*shards, node.Shard)
-		t.inOrderTraversal(node.Right, shards)
+		t.visit(node.Left, fn)
+		fn(node.Key, node.Value)
+		t.visit(node.Right, fn)
 	}
 }
 
-// PrintTree displays the tree structure (for debugging)
-func (t *RBTree) PrintTree() {
-	fmt.Println("\n--- Red-Black Tree Shard Index ---")
-	t.printNode(t.Root, 0)
+// Values collects every value in key order
+func (t *RBTree[K, V]) Values() []V {
+	values := make([]V, 0, t.size)
+	t.Each(func(_ K, value V) {
+		values = append(values, value)
+	})
+	return values
+}
+
+// Size returns the number of keys in the tree
+func (t *RBTree[K, V]) Size() int {
+	return t.size
+}
+
+// Height returns the number of nodes on the longest root-to-leaf path
+func (t *RBTree[K, V]) Height() int {
+	return t.height(t.Root)
 }
 
-func (t *RBTree) printNode(node *RBNode, level int) {
+func (t *RBTree[K, V]) height(node *RBNode[K, V]) int {
 	if node == t.Nil {
-		return
+		return 0
+	}
+	left, right := t.height(node.Left), t.height(node.Right)
+	if left > right {
+		return left + 1
+	}
+	return right + 1
+}
+
+// BlackHeight returns the number of black nodes on a path from the root to a
+// leaf, excluding the sentinel; it is the same on every path
+func (t *RBTree[K, V]) BlackHeight() int {
+	count := 0
+	for node := t.Root; node != t.Nil; node = node.Left {
+		if node.Color == Black {
+			count++
+		}
 	}
-	prefix := ""
-	for i := 0; i < level; i++ {
-		prefix += "  "
+	return count
+}
+
+// MinKey returns the smallest key, false if the tree is empty
+func (t *RBTree[K, V]) MinKey() (K, bool) {
+	if t.Root == t.Nil {
+		var zero K
+		return zero, false
 	}
-	color := "Black"
-	if node.Color == Red {
-		color = "Red"
+	return t.minimum(t.Root).Key, true
+}
+
+// MaxKey returns the largest key, false if the tree is empty
+func (t *RBTree[K, V]) MaxKey() (K, bool) {
+	if t.Root == t.Nil {
+		var zero K
+		return zero, false
 	}
-	fmt.Printf("%sShard #%d (Color: %s, Blocks: %d, Merkle Root: %s)\n", prefix, node.Shard.ID, color, len(node.Shard.Blocks), node.Shard.GetRoot())
-	t.printNode(node.Left, level+1)
-	t.printNode(node.Right, level+1)
+	return t.maximum(t.Root).Key, true
 }
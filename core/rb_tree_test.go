@@ -0,0 +1,177 @@
+package core
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// checkRBInvariants fails the test unless the tree is a valid red-black
+// tree: ordered keys, correct parent links, a black root, no red node with
+// a red child and the same number of black nodes on every path
+func checkRBInvariants[K cmp.Ordered, V any](t *testing.T, tree *RBTree[K, V]) {
+	t.Helper()
+	if tree.Root.Color != Black || tree.Nil.Color != Black {
+		t.Fatal("root or sentinel is red")
+	}
+	count := 0
+	var walk func(node *RBNode[K, V], lo, hi *K) int
+	walk = func(node *RBNode[K, V], lo, hi *K) int {
+		if node == tree.Nil {
+			return 0
+		}
+		count++
+		if (lo != nil && node.Key <= *lo) || (hi != nil && node.Key >= *hi) {
+			t.Fatalf("key %v out of order", node.Key)
+		}
+		for _, child := range []*RBNode[K, V]{node.Left, node.Right} {
+			if child != tree.Nil && child.Parent != node {
+				t.Fatalf("child of %v has a wrong parent link", node.Key)
+			}
+			if node.Color == Red && child.Color == Red {
+				t.Fatalf("red node %v has a red child", node.Key)
+			}
+		}
+		left, right := walk(node.Left, lo, &node.Key), walk(node.Right, &node.Key, hi)
+		if left != right {
+			t.Fatalf("black heights %d and %d below %v", left, right, node.Key)
+		}
+		if node.Color == Black {
+			left++
+		}
+		return left
+	}
+	if black := walk(tree.Root, nil, nil); black != tree.BlackHeight() {
+		t.Fatalf("black height %d, BlackHeight reports %d", black, tree.BlackHeight())
+	}
+	if count != tree.Size() {
+		t.Fatalf("%d nodes, Size reports %d", count, tree.Size())
+	}
+}
+
+func TestRBTreeMatchesSortedMapModel(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewRBTree[int, string]()
+		model := make(map[int]string)
+		keySpace := 1 + r.Intn(300) // Small spaces exercise replacement and deletion
+
+		for op := 0; op < 2000; op++ {
+			key := r.Intn(keySpace)
+			switch r.Intn(10) {
+			case 0, 1, 2, 3:
+				value := string(rune('a' + r.Intn(26)))
+				tree.Insert(key, value)
+				model[key] = value
+			case 4, 5, 6:
+				_, want := model[key]
+				if got := tree.Delete(key); got != want {
+					t.Fatalf("seed %d op %d: Delete(%d) = %v, model has it: %v", seed, op, key, got, want)
+				}
+				delete(model, key)
+			case 7, 8:
+				got, ok := tree.Get(key)
+				want, wantOK := model[key]
+				if ok != wantOK || got != want {
+					t.Fatalf("seed %d op %d: Get(%d) = %q, %v, want %q, %v", seed, op, key, got, ok, want, wantOK)
+				}
+			default:
+				lo, hi := key, key+r.Intn(keySpace/4+1)
+				var want []int
+				for _, k := range sortedKeys(model) {
+					if lo <= k && k <= hi {
+						want = append(want, k)
+					}
+				}
+				got := tree.Range(lo, hi)
+				if len(got) != len(want) {
+					t.Fatalf("seed %d op %d: Range(%d, %d) has %d entries, want %d", seed, op, lo, hi, len(got), len(want))
+				}
+				for i, entry := range got {
+					if entry.Key != want[i] || entry.Value != model[entry.Key] {
+						t.Fatalf("seed %d op %d: Range entry %d is %v=%q", seed, op, i, entry.Key, entry.Value)
+					}
+				}
+			}
+			if op%50 == 0 {
+				checkRBInvariants(t, tree)
+			}
+		}
+
+		checkRBInvariants(t, tree)
+		keys := sortedKeys(model)
+		values := tree.Values()
+		if len(values) != len(keys) {
+			t.Fatalf("seed %d: %d values, model has %d", seed, len(values), len(keys))
+		}
+		for i, k := range keys {
+			if values[i] != model[k] {
+				t.Fatalf("seed %d: value %d out of key order", seed, i)
+			}
+		}
+		if len(keys) > 0 {
+			if min, _ := tree.MinKey(); min != keys[0] {
+				t.Fatalf("seed %d: MinKey %d, want %d", seed, min, keys[0])
+			}
+			if max, _ := tree.MaxKey(); max != keys[len(keys)-1] {
+				t.Fatalf("seed %d: MaxKey %d, want %d", seed, max, keys[len(keys)-1])
+			}
+		}
+	}
+}
+
+func TestRBTreeSequentialInsertsStayBalanced(t *testing.T) {
+	tree := NewRBTree[string, int]()
+	for i := 0; i < 4096; i++ {
+		tree.Insert(string(rune(0x100+i)), i)
+	}
+	checkRBInvariants(t, tree)
+	if tree.Height() > maxRBHeight(tree.Size()) {
+		t.Fatalf("height %d for %d ascending keys", tree.Height(), tree.Size())
+	}
+	for i := 0; i < 4096; i += 3 {
+		tree.Delete(string(rune(0x100 + i)))
+	}
+	checkRBInvariants(t, tree)
+}
+
+func TestSplitsAfterMergesKeepEveryShard(t *testing.T) {
+	for _, strategy := range []SplitStrategy{MidpointSplit, HashRangeSplit} {
+		sm := NewShardManager(WithSplitStrategy(strategy))
+		distributed := 0
+		for round := 0; round < 6; round++ {
+			for _, data := range testLeaves(12) {
+				sm.DistributeBlock(mustGenerateBlock(t, GenesisBlock(), fmt.Sprintf("%d-%s", round, data)))
+				distributed++
+			}
+			// Merges leave gaps in the IDs, which a split must not fill
+			// with the ID of a shard still in the index
+			if err := sm.MergeShards(100); err != nil {
+				t.Fatal(err)
+			}
+			if got := sm.Shards.Stats().TotalBlocks; got != distributed {
+				t.Fatalf("strategy %q round %d: index holds %d blocks, %d distributed", strategy, round, got, distributed)
+			}
+			checkRBInvariants(t, sm.Shards.tree)
+		}
+	}
+}
+
+func TestBlockchainHeightIndex(t *testing.T) {
+	bc := NewBlockchain()
+	for _, data := range testLeaves(50) {
+		if err := bc.AddBlock(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks {
+		if hash, ok := bc.HashAtHeight(b.Index); !ok || hash != b.Hash {
+			t.Fatalf("height %d maps to %s, want %s", b.Index, hash, b.Hash)
+		}
+	}
+	if _, ok := bc.HashAtHeight(51); ok {
+		t.Fatal("height past the tip indexed")
+	}
+	checkRBInvariants(t, bc.heightIndex())
+}
@@ -0,0 +1,139 @@
+package core
+
+import "fmt"
+
+// RebuildConfig is the sharding policy and state manager configuration a
+// chain is replayed through by RebuildFromChain. It should match the one
+// the live instances were built with.
+type RebuildConfig struct {
+	ShardOptions []ShardManagerOption // Options for the rebuilt ShardManager
+	MinBlocks    int                  // Merge threshold; zero with MaxBlocks keeps the defaults
+	MaxBlocks    int                  // Split threshold
+	MaxActive    int                  // Active blocks kept by the rebuilt StateManager
+	StateOptions []StateManagerOption // Options for the rebuilt StateManager
+	FromHeight   int                  // Blocks below this height are skipped, e.g. 1 if the genesis block was never distributed
+}
+
+// RebuildFromChain derives a ShardManager and StateManager from the main
+// chain alone, by checking each block's hash and link to its parent and
+// replaying it through cfg in chain order. The result is deterministic, so
+// it can be compared against live instances with VerifyDerivedState to
+// find divergence, or used in their place to recover from it.
+func RebuildFromChain(bc *Blockchain, cfg RebuildConfig) (*ShardManager, *StateManager, error) {
+	sm := NewShardManager(cfg.ShardOptions...)
+	if cfg.MinBlocks != 0 || cfg.MaxBlocks != 0 {
+		if err := sm.SetThresholds(cfg.MinBlocks, cfg.MaxBlocks); err != nil {
+			return nil, nil, err
+		}
+	}
+	state := NewStateManager(cfg.MaxActive, cfg.StateOptions...)
+
+	it := bc.Iterator(0)
+	var prev *Block
+	for {
+		b, ok := it.Next()
+		if !ok {
+			break
+		}
+		if b.Hash != b.ComputeHash() {
+			return nil, nil, fmt.Errorf("%w: block #%d has invalid hash %s", ErrInvalidBlock, b.Index, b.Hash)
+		}
+		if prev != nil && (b.Index != prev.Index+1 || b.PrevHash != prev.Hash) {
+			return nil, nil, fmt.Errorf("%w: block #%d does not follow #%d", ErrInvalidBlock, b.Index, prev.Index)
+		}
+		prev = &b
+		if b.Index < cfg.FromHeight {
+			continue
+		}
+		sm.DistributeBlock(b)
+		state.AddBlock(b)
+	}
+	if err := it.Err(); err != nil {
+		return nil, nil, err
+	}
+	return sm, state, nil
+}
+
+// DerivedState is the set of structures derived from a chain that
+// VerifyDerivedState compares; nil members are not compared
+type DerivedState struct {
+	Shards *ShardManager
+	State  *StateManager
+}
+
+// DiscrepancyKind names what differs between live and rebuilt state
+type DiscrepancyKind string
+
+const (
+	DiscrepancyForestRoot      DiscrepancyKind = "forest root"
+	DiscrepancyGlobalStateRoot DiscrepancyKind = "global state root"
+	DiscrepancyMissingShard    DiscrepancyKind = "missing shard"    // Rebuilt has a shard live lacks
+	DiscrepancyExtraShard      DiscrepancyKind = "extra shard"      // Live has a shard rebuilt lacks
+	DiscrepancyShardRoot       DiscrepancyKind = "shard root"       // Merkle root of a shard's blocks
+	DiscrepancyBlockCount      DiscrepancyKind = "block count"      // Number of blocks in a shard
+	DiscrepancyChainCommitment DiscrepancyKind = "chain commitment" // Order of a shard's blocks
+	DiscrepancyAccumulator     DiscrepancyKind = "accumulator"      // A shard's accumulator state
+	DiscrepancyActiveRoot      DiscrepancyKind = "active root"      // StateManager active trie
+	DiscrepancyArchiveRoot     DiscrepancyKind = "archive root"     // StateManager archive
+)
+
+// Discrepancy is one way live state differs from state rebuilt from the chain
+type Discrepancy struct {
+	Kind    DiscrepancyKind `json:"kind"`
+	ShardID int             `json:"shard_id"` // -1 when not about one shard
+	Live    string          `json:"live"`
+	Rebuilt string          `json:"rebuilt"`
+}
+
+// String describes the discrepancy
+func (d Discrepancy) String() string {
+	if d.ShardID < 0 {
+		return fmt.Sprintf("%s: live %q, rebuilt %q", d.Kind, d.Live, d.Rebuilt)
+	}
+	return fmt.Sprintf("shard #%d %s: live %q, rebuilt %q", d.ShardID, d.Kind, d.Live, d.Rebuilt)
+}
+
+// VerifyDerivedState compares live derived structures against ones rebuilt
+// with RebuildFromChain, returning every difference found; none means the
+// live state is what the chain implies
+func VerifyDerivedState(live, rebuilt DerivedState) []Discrepancy {
+	var found []Discrepancy
+	differ := func(kind DiscrepancyKind, shardID int, a, b string) {
+		if a != b {
+			found = append(found, Discrepancy{Kind: kind, ShardID: shardID, Live: a, Rebuilt: b})
+		}
+	}
+
+	if live.Shards != nil && rebuilt.Shards != nil {
+		differ(DiscrepancyForestRoot, -1, live.Shards.ForestRoot(), rebuilt.Shards.ForestRoot())
+		differ(DiscrepancyGlobalStateRoot, -1, live.Shards.GlobalStateRoot(), rebuilt.Shards.GlobalStateRoot())
+
+		liveViews := make(map[int]ShardView)
+		for _, view := range live.Shards.GetAllShardViews() {
+			liveViews[view.ID] = view
+		}
+		for _, want := range rebuilt.Shards.GetAllShardViews() {
+			got, exists := liveViews[want.ID]
+			if !exists {
+				found = append(found, Discrepancy{Kind: DiscrepancyMissingShard, ShardID: want.ID, Rebuilt: want.Root})
+				continue
+			}
+			delete(liveViews, want.ID)
+			differ(DiscrepancyShardRoot, want.ID, got.Root, want.Root)
+			differ(DiscrepancyBlockCount, want.ID, fmt.Sprint(got.BlockCount), fmt.Sprint(want.BlockCount))
+			differ(DiscrepancyChainCommitment, want.ID, got.ChainCommitment, want.ChainCommitment)
+			differ(DiscrepancyAccumulator, want.ID, got.AccumulatorState, want.AccumulatorState)
+		}
+		for _, view := range live.Shards.GetAllShardViews() {
+			if _, extra := liveViews[view.ID]; extra {
+				found = append(found, Discrepancy{Kind: DiscrepancyExtraShard, ShardID: view.ID, Live: view.Root})
+			}
+		}
+	}
+
+	if live.State != nil && rebuilt.State != nil {
+		differ(DiscrepancyActiveRoot, -1, live.State.GetActiveRoot(), rebuilt.State.GetActiveRoot())
+		differ(DiscrepancyArchiveRoot, -1, live.State.GetArchiveRoot(), rebuilt.State.GetArchiveRoot())
+	}
+	return found
+}
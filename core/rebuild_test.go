@@ -0,0 +1,162 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// rebuildConfig shards with accumulators and per-shard state, so every
+// derived structure VerifyDerivedState compares is present
+var rebuildConfig = RebuildConfig{
+	ShardOptions: []ShardManagerOption{WithShardAccumulators(), WithShardState(4)},
+	MaxActive:    4,
+}
+
+// liveState builds the structures RebuildFromChain derives the way a node
+// does, block by block as the chain grows
+func liveState(t *testing.T, n int) (*Blockchain, DerivedState) {
+	t.Helper()
+	bc := NewBlockchain()
+	live := DerivedState{Shards: NewShardManager(rebuildConfig.ShardOptions...), State: NewStateManager(rebuildConfig.MaxActive)}
+	live.Shards.DistributeBlock(bc.Blocks[0])
+	live.State.AddBlock(bc.Blocks[0])
+	for i := 0; i < n; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		b := bc.Blocks[len(bc.Blocks)-1]
+		live.Shards.DistributeBlock(b)
+		live.State.AddBlock(b)
+	}
+	return bc, live
+}
+
+// rebuilt replays bc through rebuildConfig
+func rebuilt(t *testing.T, bc *Blockchain) DerivedState {
+	t.Helper()
+	shards, state, err := RebuildFromChain(bc, rebuildConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DerivedState{Shards: shards, State: state}
+}
+
+func TestRebuildFromChainMatchesLive(t *testing.T) {
+	bc, live := liveState(t, 20)
+	if found := VerifyDerivedState(live, rebuilt(t, bc)); len(found) != 0 {
+		t.Fatalf("live state built from the same chain differs: %v", found)
+	}
+	// Rebuilding is deterministic
+	if found := VerifyDerivedState(rebuilt(t, bc), rebuilt(t, bc)); len(found) != 0 {
+		t.Fatalf("two rebuilds differ: %v", found)
+	}
+
+	// Skipping the genesis block changes the layout
+	cfg := rebuildConfig
+	cfg.FromHeight = 1
+	shards, state, err := RebuildFromChain(bc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(VerifyDerivedState(live, DerivedState{Shards: shards, State: state})) == 0 {
+		t.Fatal("rebuild without the genesis block matches live state holding it")
+	}
+
+	// A chain that does not verify is not replayed
+	bc.Blocks[5].Data = "tampered"
+	if _, _, err := RebuildFromChain(bc, rebuildConfig); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("rebuilding a tampered chain: %v", err)
+	}
+	bc.Blocks[5].Hash = bc.Blocks[5].ComputeHash()
+	if _, _, err := RebuildFromChain(bc, rebuildConfig); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("rebuilding a chain with a broken link: %v", err)
+	}
+}
+
+// shardMutations corrupt a live shard the way a bug or bad disk might; the
+// caller holds the shard mutex and rebuilds its derived structures after
+var shardMutations = map[string]func(r *rand.Rand, s *Shard){
+	"drop": func(r *rand.Rand, s *Shard) {
+		i := r.Intn(len(s.Blocks))
+		s.Blocks = append(s.Blocks[:i:i], s.Blocks[i+1:]...)
+	},
+	"alter": func(r *rand.Rand, s *Shard) {
+		b := &s.Blocks[r.Intn(len(s.Blocks))]
+		b.Data += "-altered"
+		b.Hash = b.ComputeHash()
+	},
+	"duplicate": func(r *rand.Rand, s *Shard) {
+		s.Blocks = append(s.Blocks, s.Blocks[r.Intn(len(s.Blocks))])
+	},
+	"reorder": func(r *rand.Rand, s *Shard) {
+		s.Blocks[0], s.Blocks[len(s.Blocks)-1] = s.Blocks[len(s.Blocks)-1], s.Blocks[0]
+	},
+}
+
+func TestVerifyDerivedStateReportsMutatedShard(t *testing.T) {
+	r := rand.New(rand.NewSource(1144))
+	names := []string{"alter", "drop", "duplicate", "reorder"}
+	for i := 0; i < 40; i++ {
+		bc, live := liveState(t, 10+r.Intn(20))
+		expected := rebuilt(t, bc)
+
+		shards := live.Shards.Shards.GetAllShards()
+		shard := shards[r.Intn(len(shards))]
+		name := names[r.Intn(len(names))]
+		shard.mutex.Lock()
+		if name == "reorder" && len(shard.Blocks) < 2 {
+			name = "alter"
+		}
+		shardMutations[name](r, shard)
+		shard.rebuild()
+		shard.mutex.Unlock()
+
+		found := VerifyDerivedState(live, expected)
+		reported := false
+		for _, d := range found {
+			if d.ShardID == shard.ID {
+				reported = true
+			}
+		}
+		if !reported {
+			t.Fatalf("%s of shard #%d reported as %v", name, shard.ID, found)
+		}
+	}
+}
+
+func TestVerifyDerivedStateReportsLayoutAndState(t *testing.T) {
+	bc, live := liveState(t, 12)
+	expected := rebuilt(t, bc)
+	shards := live.Shards.Shards.GetAllShards()
+	removed := shards[len(shards)-1]
+	live.Shards.Shards.Delete(removed.ID)
+	live.State.AddBlock(mustGenerateBlock(t, bc.Blocks[len(bc.Blocks)-1], "unchained"))
+
+	kinds := make(map[DiscrepancyKind]Discrepancy)
+	for _, d := range VerifyDerivedState(live, expected) {
+		kinds[d.Kind] = d
+	}
+	for _, kind := range []DiscrepancyKind{DiscrepancyForestRoot, DiscrepancyMissingShard, DiscrepancyActiveRoot} {
+		if _, ok := kinds[kind]; !ok {
+			t.Fatalf("%s not reported in %v", kind, kinds)
+		}
+	}
+	if kinds[DiscrepancyMissingShard].ShardID != removed.ID {
+		t.Fatalf("missing shard reported as #%d, removed #%d", kinds[DiscrepancyMissingShard].ShardID, removed.ID)
+	}
+
+	// The other way round the shard is extra, and nil members are skipped
+	found := VerifyDerivedState(DerivedState{Shards: expected.Shards}, DerivedState{Shards: live.Shards, State: live.State})
+	extra := false
+	for _, d := range found {
+		if d.Kind == DiscrepancyActiveRoot {
+			t.Fatal("state compared with a nil member")
+		}
+		extra = extra || (d.Kind == DiscrepancyExtraShard && d.ShardID == removed.ID)
+	}
+	if !extra {
+		t.Fatalf("extra shard not reported in %v", found)
+	}
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Errors returned by shard replica sets
+var (
+	ErrUnknownReplica = errors.New("unknown replica")
+	ErrRepairFailed   = errors.New("replica root still differs after repair")
+)
+
+// ShardReplicaSet keeps N copies of one logical shard. Writes go to every
+// available replica and need the write quorum; replicas that missed writes
+// or were corrupted are found by comparing roots and brought back in line
+// by Repair.
+type ShardReplicaSet struct {
+	ID          int
+	replicas    []*Shard
+	unavailable map[int]bool // Replicas that miss writes, e.g. while partitioned
+	quorum      QuorumSource // Nil requires a majority
+	mutex       sync.Mutex
+}
+
+// NewShardReplicaSet creates n empty replicas of shard id whose writes need
+// the write quorum reported by quorum, e.g. a ConsistencyOrchestrator
+func NewShardReplicaSet(id, n int, quorum QuorumSource) *ShardReplicaSet {
+	rs := &ShardReplicaSet{ID: id, unavailable: make(map[int]bool), quorum: quorum}
+	for i := 0; i < n; i++ {
+		rs.replicas = append(rs.replicas, NewShard(id))
+	}
+	return rs
+}
+
+// Replica returns one copy of the shard
+func (rs *ShardReplicaSet) Replica(i int) (*Shard, bool) {
+	if i < 0 || i >= len(rs.replicas) {
+		return nil, false
+	}
+	return rs.replicas[i], true
+}
+
+// Size returns the number of replicas
+func (rs *ShardReplicaSet) Size() int {
+	return len(rs.replicas)
+}
+
+// SetAvailable marks whether a replica receives writes
+func (rs *ShardReplicaSet) SetAvailable(i int, available bool) error {
+	if i < 0 || i >= len(rs.replicas) {
+		return fmt.Errorf("%w: #%d", ErrUnknownReplica, i)
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if available {
+		delete(rs.unavailable, i)
+	} else {
+		rs.unavailable[i] = true
+	}
+	return nil
+}
+
+// AddBlock writes a block to every available replica. The write is refused
+// without touching any replica when fewer than the write quorum are
+// available, and returns the number of replicas written otherwise.
+func (rs *ShardReplicaSet) AddBlock(block Block) (int, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	n := len(rs.replicas)
+	acks := n - len(rs.unavailable)
+	if rs.quorum != nil {
+		if err := CheckWriteQuorum(rs.quorum, n, acks); err != nil {
+			return 0, fmt.Errorf("shard #%d: %w", rs.ID, err)
+		}
+	} else if acks < n/2+1 {
+		return 0, fmt.Errorf("%w: shard #%d has %d of %d replicas, need %d", ErrQuorumNotMet, rs.ID, acks, n, n/2+1)
+	}
+	for i, replica := range rs.replicas {
+		if !rs.unavailable[i] {
+			replica.AddBlock(block)
+		}
+	}
+	return acks, nil
+}
+
+// DetectDivergence compares replica roots and returns the replicas that
+// differ from the most common root, preferring the lowest replica's root on
+// a tie
+func (rs *ShardReplicaSet) DetectDivergence() ([]int, bool) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	roots := make([]string, len(rs.replicas))
+	counts := make(map[string]int)
+	for i, replica := range rs.replicas {
+		roots[i] = replicaRoot(replica)
+		counts[roots[i]]++
+	}
+	majority := ""
+	for _, root := range roots {
+		if counts[root] > counts[majority] {
+			majority = root
+		}
+	}
+
+	var diverged []int
+	for i, root := range roots {
+		if root != majority {
+			diverged = append(diverged, i)
+		}
+	}
+	return diverged, len(diverged) > 0
+}
+
+// Repair makes every replica match the authoritative one. Missing blocks
+// are found with the replica's Bloom filter first, then by exact hash
+// comparison for blocks the filter claims are present; blocks the
+// authoritative replica lacks and blocks whose contents no longer match
+// their hash are dropped. Each repaired replica is rebuilt in the
+// authoritative block order so that its root matches. Writes wait until
+// the repair is done.
+func (rs *ShardReplicaSet) Repair(authoritative int) error {
+	source, exists := rs.Replica(authoritative)
+	if !exists {
+		return fmt.Errorf("%w: #%d", ErrUnknownReplica, authoritative)
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	source.mutex.Lock()
+	blocks := append([]Block{}, source.Blocks...)
+	source.mutex.Unlock()
+	root := newBlockTree(blocks).GetRootHash()
+
+	for i, replica := range rs.replicas {
+		if i == authoritative || replicaRoot(replica) == root {
+			continue
+		}
+		if err := repairReplica(replica, blocks, root); err != nil {
+			return fmt.Errorf("shard #%d replica #%d: %w", rs.ID, i, err)
+		}
+	}
+	return nil
+}
+
+// repairReplica rebuilds replica from blocks, keeping its own copies of
+// blocks it holds intact
+func repairReplica(replica *Shard, blocks []Block, root string) error {
+	filter, err := replica.BlockFilter()
+	if err != nil {
+		return err
+	}
+
+	replica.mutex.Lock()
+	defer replica.mutex.Unlock()
+	held := make(map[string]Block, len(replica.Blocks))
+	for _, b := range replica.Blocks {
+		if b.ComputeHash() == b.Hash {
+			held[b.Hash] = b
+		}
+	}
+	repaired := make([]Block, len(blocks))
+	for j, b := range blocks {
+		repaired[j] = b
+		if !filter.Test(b.Hash) {
+			continue // Definitely missing
+		}
+		if own, ok := held[b.Hash]; ok {
+			repaired[j] = own
+		}
+	}
+
+	replica.Blocks = repaired
+	replica.rebuild()
+	if replica.Tree.GetRootHash() != root {
+		return ErrRepairFailed
+	}
+	return nil
+}
+
+// replicaRoot returns a shard's root, treating a shard without a tree as
+// an empty one
+func replicaRoot(s *Shard) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Tree == nil {
+		return NewMerkleTree(nil).GetRootHash()
+	}
+	return s.Tree.GetRootHash()
+}
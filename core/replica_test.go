@@ -0,0 +1,166 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// replicatedSet returns n replicas of shard 3 holding the given blocks
+func replicatedSet(t *testing.T, n int, blocks []Block) *ShardReplicaSet {
+	t.Helper()
+	rs := NewShardReplicaSet(3, n, nil)
+	for _, b := range blocks {
+		if _, err := rs.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rs
+}
+
+// checkConverged fails unless every replica holds blocks in order
+func checkConverged(t *testing.T, rs *ShardReplicaSet, blocks []Block) {
+	t.Helper()
+	if diverged, ok := rs.DetectDivergence(); ok {
+		t.Fatalf("replicas %v still diverge", diverged)
+	}
+	want := newBlockTree(blocks).GetRootHash()
+	for i := 0; i < rs.Size(); i++ {
+		replica, _ := rs.Replica(i)
+		if root := replicaRoot(replica); root != want {
+			t.Fatalf("replica #%d root %s, want %s", i, root, want)
+		}
+		view := replica.View()
+		for j, b := range blocks {
+			if view.Blocks[j].Hash != b.Hash || view.Blocks[j].Data != b.Data {
+				t.Fatalf("replica #%d block %d is %+v", i, j, view.Blocks[j])
+			}
+		}
+	}
+}
+
+func TestCorruptedReplicaDetectedAndRepaired(t *testing.T) {
+	blocks := fixedBlocks(10)
+	rs := replicatedSet(t, 3, blocks)
+	if diverged, ok := rs.DetectDivergence(); ok {
+		t.Fatalf("fresh replicas diverge: %v", diverged)
+	}
+
+	corrupt, _ := rs.Replica(1)
+	corrupt.mutex.Lock()
+	corrupt.Blocks[4].Data = "corrupted"
+	corrupt.Blocks = append(corrupt.Blocks[:7], corrupt.Blocks[8:]...)
+	corrupt.rebuild()
+	corrupt.mutex.Unlock()
+
+	diverged, ok := rs.DetectDivergence()
+	if !ok || len(diverged) != 1 || diverged[0] != 1 {
+		t.Fatalf("divergence %v", diverged)
+	}
+	if err := rs.Repair(0); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, rs, blocks)
+
+	if err := rs.Repair(7); !errors.Is(err, ErrUnknownReplica) {
+		t.Fatalf("repair from a missing replica: %v", err)
+	}
+}
+
+func TestPartitionedReplicasCatchUpAfterRepair(t *testing.T) {
+	blocks := fixedBlocks(20)
+	rs := replicatedSet(t, 5, blocks[:10])
+
+	// Two replicas cut off miss writes while the majority keeps accepting
+	for _, i := range []int{3, 4} {
+		if err := rs.SetAvailable(i, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range blocks[10:] {
+		if acks, err := rs.AddBlock(b); err != nil || acks != 3 {
+			t.Fatalf("partitioned write: %d acknowledgements, %v", acks, err)
+		}
+	}
+	// A third lost replica leaves no majority; nothing is written
+	if err := rs.SetAvailable(2, false); err != nil {
+		t.Fatal(err)
+	}
+	extra := mustGenerateBlock(t, blocks[19], "minority write")
+	if _, err := rs.AddBlock(extra); !errors.Is(err, ErrQuorumNotMet) {
+		t.Fatalf("write to a minority: %v", err)
+	}
+
+	diverged, ok := rs.DetectDivergence()
+	if !ok || fmt.Sprint(diverged) != "[3 4]" {
+		t.Fatalf("divergence %v", diverged)
+	}
+	for i := 2; i < 5; i++ {
+		if err := rs.SetAvailable(i, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Repair(0); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, rs, blocks)
+
+	// Healed replicas take new writes again
+	if acks, err := rs.AddBlock(extra); err != nil || acks != 5 {
+		t.Fatalf("write after healing: %d acknowledgements, %v", acks, err)
+	}
+	checkConverged(t, rs, append(blocks, extra))
+}
+
+func TestRepairDropsBlocksOutsideAuthoritativeReplica(t *testing.T) {
+	blocks := fixedBlocks(6)
+	rs := replicatedSet(t, 3, blocks[:5])
+	stray, _ := rs.Replica(2)
+	stray.AddBlock(blocks[5])
+
+	// The lagging majority is authoritative here, so the stray block goes
+	if diverged, _ := rs.DetectDivergence(); fmt.Sprint(diverged) != "[2]" {
+		t.Fatalf("divergence %v", diverged)
+	}
+	if err := rs.Repair(1); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, rs, blocks[:5])
+}
+
+func TestRepairConcurrentWithWrites(t *testing.T) {
+	blocks := fixedBlocks(40)
+	rs := replicatedSet(t, 3, blocks[:20])
+	corrupt, _ := rs.Replica(2)
+	corrupt.mutex.Lock()
+	corrupt.Blocks[0].Data = "corrupted"
+	corrupt.rebuild()
+	corrupt.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for _, b := range blocks[20:] {
+			if _, err := rs.AddBlock(b); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			rs.DetectDivergence()
+			if err := rs.Repair(0); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if err := rs.Repair(0); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, rs, blocks)
+}
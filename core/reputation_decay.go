@@ -0,0 +1,63 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MinParticipantReputation is the reputation a node must exceed to be
+// selected for consensus by ReputationSelector
+const MinParticipantReputation = 0.5
+
+// WithReputationDecay halves the part of a node's reputation above floor
+// for every halfLife since its LastResponse, so idle nodes lose trust. The
+// decay is computed whenever reputation is read; a non-positive halfLife
+// disables it.
+func WithReputationDecay(halfLife time.Duration, floor float64) BFTOption {
+	return func(bft *BFTManager) {
+		bft.halfLife = halfLife
+		bft.decayFloor = floor
+	}
+}
+
+// WithBFTClock replaces time.Now for reputation decay and LastResponse
+func WithBFTClock(now func() time.Time) BFTOption {
+	return func(bft *BFTManager) {
+		bft.now = now
+	}
+}
+
+// Reputation returns a node's reputation after decay. Nodes that have never
+// responded, with a zero LastResponse, do not decay.
+func (bft *BFTManager) Reputation(node *Node) float64 {
+	if bft.halfLife <= 0 || node.LastResponse.IsZero() || node.Reputation <= bft.decayFloor {
+		return node.Reputation
+	}
+	idle := bft.clock().Sub(node.LastResponse)
+	if idle <= 0 {
+		return node.Reputation
+	}
+	halvings := float64(idle) / float64(bft.halfLife)
+	return bft.decayFloor + (node.Reputation-bft.decayFloor)*math.Exp2(-halvings)
+}
+
+// RecordVote notes that a node responded, settling its decayed reputation
+// and restarting decay from now
+func (bft *BFTManager) RecordVote(nodeID int) error {
+	node := bft.nodeByID(nodeID)
+	if node == nil {
+		return fmt.Errorf("%w: #%d", ErrUnknownNode, nodeID)
+	}
+	node.Reputation = bft.Reputation(node)
+	node.LastResponse = bft.clock()
+	return nil
+}
+
+// clock returns the current time from the configured clock
+func (bft *BFTManager) clock() time.Time {
+	if bft.now != nil {
+		return bft.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// decayBFT returns n honest nodes at reputation 0.9 whose reputation halves
+// above 0.1 every hour, and a function advancing their clock
+func decayBFT(n int) (*BFTManager, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bft := behaviorBFT(n, WithReputationDecay(time.Hour, 0.1), WithBFTClock(func() time.Time { return now }))
+	for _, node := range bft.Nodes {
+		node.Reputation = 0.9
+	}
+	return bft, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestReputationDecayCurve(t *testing.T) {
+	for _, step := range []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 0.9},
+		{30 * time.Minute, 0.1 + 0.8/math.Sqrt2},
+		{time.Hour, 0.5},
+		{2 * time.Hour, 0.3},
+		{3 * time.Hour, 0.2},
+		{40 * time.Hour, 0.1},
+	} {
+		bft, advance := decayBFT(1)
+		node := bft.Nodes[0]
+		advance(step.elapsed)
+		if got := bft.Reputation(node); math.Abs(got-step.want) > 1e-9 {
+			t.Errorf("after %v: reputation %v, want %v", step.elapsed, got, step.want)
+		}
+		if node.Reputation != 0.9 {
+			t.Fatal("reading reputation changed the stored value")
+		}
+	}
+
+	bft, _ := decayBFT(1)
+	node := bft.Nodes[0]
+
+	// Nodes at the floor or that never responded do not decay
+	low := &Node{ID: 9, Reputation: 0.05, LastResponse: node.LastResponse}
+	if got := bft.Reputation(low); got != 0.05 {
+		t.Fatalf("node below the floor decayed to %v", got)
+	}
+	if got := bft.Reputation(&Node{Reputation: 0.9}); got != 0.9 {
+		t.Fatalf("node that never responded decayed to %v", got)
+	}
+	if got := behaviorBFT(1).Reputation(node); got != 0.9 {
+		t.Fatalf("reputation decayed to %v without a half-life", got)
+	}
+}
+
+func TestRecordVoteRefreshesReputation(t *testing.T) {
+	bft, advance := decayBFT(2)
+	node := bft.Nodes[0]
+	advance(time.Hour)
+
+	if err := bft.RecordVote(node.ID); err != nil {
+		t.Fatal(err)
+	}
+	if node.Reputation != 0.5 || bft.Reputation(node) != 0.5 {
+		t.Fatalf("vote settled reputation at %v, reads %v", node.Reputation, bft.Reputation(node))
+	}
+	// Decay restarts from the vote
+	advance(time.Hour)
+	if got := bft.Reputation(node); math.Abs(got-0.3) > 1e-9 {
+		t.Fatalf("an hour after the vote reputation is %v, want 0.3", got)
+	}
+	if err := bft.RecordVote(99); !errors.Is(err, ErrUnknownNode) {
+		t.Fatalf("vote from an unknown node: %v", err)
+	}
+}
+
+func TestIdleNodeDropsOutOfConsensus(t *testing.T) {
+	bft, advance := decayBFT(4)
+	idle := bft.Nodes[3]
+	if len(bft.SelectConsensusParticipants()) != 4 {
+		t.Fatal("trusted nodes not all selected")
+	}
+
+	// Three nodes keep voting, earning back what decay took as rewards in
+	// decided rounds would, while the fourth is away for two weeks
+	for hour := 0; hour < 14*24; hour++ {
+		advance(time.Hour)
+		for _, node := range bft.Nodes[:3] {
+			if err := bft.RecordVote(node.ID); err != nil {
+				t.Fatal(err)
+			}
+			node.Reputation = 0.9
+		}
+	}
+	if idle.Reputation != 0.9 {
+		t.Fatal("idle node's stored reputation changed")
+	}
+	if got := bft.Reputation(idle); got >= MinParticipantReputation {
+		t.Fatalf("idle node kept reputation %v", got)
+	}
+	selected := bft.SelectConsensusParticipants()
+	if len(selected) != 3 {
+		t.Fatalf("%d participants, want the 3 active nodes", len(selected))
+	}
+	for _, node := range selected {
+		if node == idle {
+			t.Fatal("long-idle node selected for consensus")
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrBlockHashMismatch is returned when the block at a transfer's index is
+// not the one the caller asked to move
+var ErrBlockHashMismatch = errors.New("block at index does not have the expected hash")
+
+// RetryPolicy bounds how often and how fast a transfer is retried. The
+// delay before attempt n+1 is BaseDelay*2^(n-1), capped at MaxDelay, less a
+// random fraction of up to Jitter of it so that retrying callers spread out.
+type RetryPolicy struct {
+	MaxAttempts int           `json:"max_attempts"`
+	BaseDelay   time.Duration `json:"base_delay"`
+	MaxDelay    time.Duration `json:"max_delay"`
+	Jitter      float64       `json:"jitter"` // In [0, 1]; 0 gives exact delays
+}
+
+// DefaultRetryPolicy retries a transfer up to five times over about a second
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   50 * time.Millisecond,
+	MaxDelay:    time.Second,
+	Jitter:      0.2,
+}
+
+// Delay returns how long to wait after the given failed attempt, counting
+// from 1, before the next one; random draws the jitter
+func (p RetryPolicy) Delay(attempt int, random RandomSource) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		if delay > delay*2 {
+			break // Overflowed without a cap
+		}
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(float64(delay) * jitter * random.Float64())
+	}
+	return delay
+}
+
+// TransferRequest is a block transfer for TransferExecutor. Key makes the
+// transfer idempotent across retries and repeated calls; BlockHash, if set,
+// must be the hash of the block at BlockIndex.
+type TransferRequest struct {
+	Key         string
+	Source      *Shard
+	Destination *Shard
+	BlockIndex  int
+	BlockHash   string
+	Tokens      []Token  // Capability tokens, see RequireCapabilities
+	Priority    Priority // Queue class; empty takes the block's priority
+}
+
+// queuedTransfer is a request waiting in a TransferExecutor queue
+type queuedTransfer struct {
+	req      TransferRequest
+	priority Priority
+	arrival  uint64
+	waited   uint64 // Transfers run ahead of it while queued
+}
+
+// TransferExecutor runs block transfers through an EnhancedSyncManager,
+// retrying failures that may be transient, e.g. a dropped message or a
+// write rejected under Eventual consistency
+type TransferExecutor struct {
+	esm         *EnhancedSyncManager
+	sleep       func(time.Duration)
+	random      RandomSource
+	consistency *ConsistencyOrchestrator // Optional; queue order depends on its level
+	aging       int                      // Transfers run ahead of a queued one per class it climbs
+	queue       []*queuedTransfer
+	arrivals    uint64
+	mutex       sync.Mutex
+}
+
+// TransferExecutorOption configures a TransferExecutor
+type TransferExecutorOption func(*TransferExecutor)
+
+// WithRetrySleep replaces time.Sleep for the waits between attempts, e.g.
+// with a fake clock
+func WithRetrySleep(sleep func(time.Duration)) TransferExecutorOption {
+	return func(te *TransferExecutor) {
+		te.sleep = sleep
+	}
+}
+
+// WithRetryRandom sets the source of backoff jitter
+func WithRetryRandom(src RandomSource) TransferExecutorOption {
+	return func(te *TransferExecutor) {
+		te.random = src
+	}
+}
+
+// WithTransferConsistency lets queued transfers run by priority while co
+// is at Eventual consistency; at stronger levels, or without co, they run
+// in the order queued
+func WithTransferConsistency(co *ConsistencyOrchestrator) TransferExecutorOption {
+	return func(te *TransferExecutor) {
+		te.consistency = co
+	}
+}
+
+// WithTransferAging makes a queued transfer climb one priority class for
+// every turns transfers run ahead of it; zero or less disables aging
+func WithTransferAging(turns int) TransferExecutorOption {
+	return func(te *TransferExecutor) {
+		te.aging = turns
+	}
+}
+
+// NewTransferExecutor creates an executor running transfers through esm
+func NewTransferExecutor(esm *EnhancedSyncManager, opts ...TransferExecutorOption) *TransferExecutor {
+	te := &TransferExecutor{esm: esm, sleep: time.Sleep, random: defaultSource{}, aging: DefaultPriorityAging}
+	for _, opt := range opts {
+		opt(te)
+	}
+	return te
+}
+
+// ExecuteWithRetry creates and applies the transfer, retrying with backoff
+// until it commits, fails with an error IsRetryable rejects, or runs out of
+// attempts. Each attempt uses its own idempotency key derived from req.Key,
+// and before every attempt the outcomes of earlier ones are checked, so a
+// transfer that committed but reported an error is never applied twice.
+func (te *TransferExecutor) ExecuteWithRetry(req TransferRequest, policy RetryPolicy) error {
+	if req.Key == "" {
+		return errors.New("retried transfers need an idempotency key")
+	}
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if te.committed(req, attempts) {
+			return nil
+		}
+		lastErr = te.attempt(req, attemptKey(req.Key, attempt))
+		if lastErr == nil {
+			return nil
+		}
+		if !IsRetryable(lastErr) {
+			return lastErr
+		}
+		if attempt < attempts {
+			te.sleep(policy.Delay(attempt, te.random))
+		}
+	}
+	if te.committed(req, attempts) {
+		return nil
+	}
+	return fmt.Errorf("transfer %s failed after %d attempts: %w", req.Key, attempts, lastErr)
+}
+
+// Enqueue queues a transfer for RunNext or RunQueued. A request without a
+// Priority takes the priority of the block at its index.
+func (te *TransferExecutor) Enqueue(req TransferRequest) {
+	priority := req.Priority
+	if priority == "" {
+		req.Source.mutex.Lock()
+		if req.BlockIndex >= 0 && req.BlockIndex < len(req.Source.Blocks) {
+			priority = req.Source.Blocks[req.BlockIndex].Priority
+		}
+		req.Source.mutex.Unlock()
+	}
+
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+	te.arrivals++
+	te.queue = append(te.queue, &queuedTransfer{req: req, priority: priority, arrival: te.arrivals})
+}
+
+// Queued returns the number of transfers waiting in the queue
+func (te *TransferExecutor) Queued() int {
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+	return len(te.queue)
+}
+
+// RunNext takes the next transfer off the queue and executes it with
+// ExecuteWithRetry, returning the request and its error; ok is false if
+// the queue was empty. Under Eventual consistency the next transfer is the
+// one with the highest priority, counting classes climbed by aging, and
+// the oldest among equals; otherwise it is the oldest.
+func (te *TransferExecutor) RunNext(policy RetryPolicy) (req TransferRequest, ok bool, err error) {
+	next, ok := te.dequeue()
+	if !ok {
+		return TransferRequest{}, false, nil
+	}
+	return next.req, true, te.ExecuteWithRetry(next.req, policy)
+}
+
+// RunQueued runs queued transfers until the queue is empty, including ones
+// queued meanwhile, and returns the errors of those that failed by key
+func (te *TransferExecutor) RunQueued(policy RetryPolicy) map[string]error {
+	failed := make(map[string]error)
+	for {
+		req, ok, err := te.RunNext(policy)
+		if !ok {
+			return failed
+		}
+		if err != nil {
+			failed[req.Key] = err
+		}
+	}
+}
+
+// dequeue removes the next transfer to run and ages the rest
+func (te *TransferExecutor) dequeue() (*queuedTransfer, bool) {
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+	if len(te.queue) == 0 {
+		return nil, false
+	}
+
+	best := 0
+	if te.consistency != nil && te.consistency.Level() == Eventual {
+		bestRank := agedRank(te.queue[0].priority, te.queue[0].waited, te.aging)
+		for i, q := range te.queue[1:] {
+			// The queue is in arrival order, so ties keep the oldest
+			if rank := agedRank(q.priority, q.waited, te.aging); rank < bestRank {
+				best, bestRank = i+1, rank
+			}
+		}
+	}
+	next := te.queue[best]
+	te.queue = append(te.queue[:best], te.queue[best+1:]...)
+	for _, q := range te.queue {
+		q.waited++
+	}
+	return next, true
+}
+
+// attempt runs both phases of one try under its own key
+func (te *TransferExecutor) attempt(req TransferRequest, key string) error {
+	if err := checkBlockHash(req); err != nil {
+		return err
+	}
+	if err := te.esm.CreateKeyedTransfer(key, req.Source, req.Destination, req.BlockIndex, req.Tokens...); err != nil {
+		return err
+	}
+	return te.esm.VerifyAndApplyKeyedTransfer(key, req.Source, req.Destination, req.BlockIndex)
+}
+
+// committed reports whether any attempt of the request has committed
+func (te *TransferExecutor) committed(req TransferRequest, attempts int) bool {
+	for attempt := 1; attempt <= attempts; attempt++ {
+		id := TransferID(attemptKey(req.Key, attempt), req.Source.ID, req.Destination.ID, req.BlockIndex)
+		if outcome, done := te.esm.CompletedTransfer(id); done && outcome == OutcomeCommitted {
+			return true
+		}
+	}
+	return false
+}
+
+// attemptKey is the idempotency key of one attempt of a retried transfer
+func attemptKey(key string, attempt int) string {
+	return key + "#" + strconv.Itoa(attempt)
+}
+
+// checkBlockHash confirms the block at the request's index is the one named
+func checkBlockHash(req TransferRequest) error {
+	if req.BlockHash == "" {
+		return nil
+	}
+	req.Source.mutex.Lock()
+	defer req.Source.mutex.Unlock()
+	if req.BlockIndex < 0 || req.BlockIndex >= len(req.Source.Blocks) {
+		return fmt.Errorf("%w: %d for shard #%d (block count: %d)", ErrIndexOutOfRange, req.BlockIndex, req.Source.ID, len(req.Source.Blocks))
+	}
+	if actual := req.Source.Blocks[req.BlockIndex].Hash; actual != req.BlockHash {
+		return fmt.Errorf("%w: shard #%d index %d holds %s, not %s", ErrBlockHashMismatch, req.Source.ID, req.BlockIndex, actual, req.BlockHash)
+	}
+	return nil
+}
+
+// permanentErrors fail the same way however often a transfer is retried
+var permanentErrors = []error{
+	ErrBlockHashMismatch,
+	ErrIndexOutOfRange,
+	ErrSameShard,
+	ErrDuplicateBlock,
+	ErrCapabilitiesDisabled,
+	ErrMissingCapability,
+	ErrCapabilityExpired,
+	ErrCapabilityWrongShard,
+	ErrCapabilityRights,
+	ErrInvalidCapability,
+}
+
+// IsRetryable reports whether a failed transfer may succeed if tried again
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, permanent := range permanentErrors {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return true
+}
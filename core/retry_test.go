@@ -0,0 +1,159 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errDropped = errors.New("message dropped")
+
+// flakySyncer drops the first fails syncs, as a lossy network would, and
+// passes the rest to a SyncManager
+type flakySyncer struct {
+	fails int
+	calls int
+	inner *SyncManager
+}
+
+func (s *flakySyncer) SyncBlock(source, destination *Shard, blockIndex int) error {
+	s.calls++
+	if s.calls <= s.fails {
+		return errDropped
+	}
+	return s.inner.SyncBlock(source, destination, blockIndex)
+}
+
+// fixedRandom draws the same fraction every time
+type fixedRandom float64
+
+func (r fixedRandom) Float64() float64           { return float64(r) }
+func (r fixedRandom) Intn(n int) int             { return int(float64(r) * float64(n)) }
+func (r fixedRandom) Read(p []byte) (int, error) { return len(p), nil }
+
+// retrySetup returns an executor whose syncer drops fails syncs, and the
+// delays it slept for
+func retrySetup(t *testing.T, fails int, opts ...TransferExecutorOption) (*TransferExecutor, *flakySyncer, *[]time.Duration, *Shard, *Shard) {
+	t.Helper()
+	_, source, destination := transferSetup(t)
+	esm := NewEnhancedSyncManager("key")
+	syncer := &flakySyncer{fails: fails, inner: NewSyncManager()}
+	esm.SetSyncManager(syncer)
+	var slept []time.Duration
+	opts = append([]TransferExecutorOption{WithRetrySleep(func(d time.Duration) { slept = append(slept, d) })}, opts...)
+	return NewTransferExecutor(esm, opts...), syncer, &slept, source, destination
+}
+
+var exactPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 25 * time.Millisecond}
+
+func TestExecuteWithRetrySucceedsWithinBudget(t *testing.T) {
+	te, syncer, slept, source, destination := retrySetup(t, 3)
+	moved := source.Blocks[1]
+	sourceCount, destCount := len(source.Blocks), len(destination.Blocks)
+	req := TransferRequest{Key: "flaky", Source: source, Destination: destination, BlockIndex: 1, BlockHash: moved.Hash}
+
+	if err := te.ExecuteWithRetry(req, exactPolicy); err != nil {
+		t.Fatal(err)
+	}
+	if syncer.calls != 4 {
+		t.Fatalf("%d sync attempts for 3 drops", syncer.calls)
+	}
+	// Doubling from the base delay, capped
+	if want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}; !reflect.DeepEqual(*slept, want) {
+		t.Fatalf("slept %v, want %v", *slept, want)
+	}
+	if len(source.Blocks) != sourceCount-1 || len(destination.Blocks) != destCount+1 || !destination.containsBlock(moved.Hash) {
+		t.Fatalf("%d and %d blocks after the transfer", len(source.Blocks), len(destination.Blocks))
+	}
+
+	// Running the request again finds it committed and moves nothing
+	if err := te.ExecuteWithRetry(req, exactPolicy); err != nil {
+		t.Fatal(err)
+	}
+	if syncer.calls != 4 || len(source.Blocks) != sourceCount-1 || len(destination.Blocks) != destCount+1 {
+		t.Fatal("repeated request applied the transfer again")
+	}
+}
+
+func TestExecuteWithRetryExhaustsAttempts(t *testing.T) {
+	te, syncer, slept, source, destination := retrySetup(t, 10)
+	roots := [2]string{source.GetRoot(), destination.GetRoot()}
+	policy := exactPolicy
+	policy.MaxAttempts = 3
+
+	err := te.ExecuteWithRetry(TransferRequest{Key: "lost", Source: source, Destination: destination, BlockIndex: 1}, policy)
+	if !errors.Is(err, errDropped) {
+		t.Fatalf("exhausted retries: %v", err)
+	}
+	if syncer.calls != 3 || len(*slept) != 2 {
+		t.Fatalf("%d attempts with %d waits for a budget of 3", syncer.calls, len(*slept))
+	}
+	if source.GetRoot() != roots[0] || destination.GetRoot() != roots[1] {
+		t.Fatal("failed attempts changed the shards")
+	}
+}
+
+func TestExecuteWithRetryShortCircuitsPermanentErrors(t *testing.T) {
+	te, syncer, slept, source, destination := retrySetup(t, 0)
+
+	err := te.ExecuteWithRetry(TransferRequest{Key: "stale", Source: source, Destination: destination, BlockIndex: 1, BlockHash: "not-the-hash"}, exactPolicy)
+	if !errors.Is(err, ErrBlockHashMismatch) {
+		t.Fatalf("wrong block hash: %v", err)
+	}
+	if syncer.calls != 0 || len(*slept) != 0 {
+		t.Fatalf("wrong block hash retried: %d syncs, %d waits", syncer.calls, len(*slept))
+	}
+
+	te.esm.RequireCapabilities(NewShardManager(WithCapabilityKey(NewHomomorphicAuthenticator("capability-key"))))
+	err = te.ExecuteWithRetry(TransferRequest{Key: "denied", Source: source, Destination: destination, BlockIndex: 1}, exactPolicy)
+	if !errors.Is(err, ErrMissingCapability) {
+		t.Fatalf("missing capability: %v", err)
+	}
+	if syncer.calls != 0 || len(*slept) != 0 {
+		t.Fatalf("denied transfer retried: %d syncs, %d waits", syncer.calls, len(*slept))
+	}
+
+	if err := te.ExecuteWithRetry(TransferRequest{Source: source, Destination: destination}, exactPolicy); err == nil {
+		t.Fatal("retried transfer without an idempotency key")
+	}
+	if IsRetryable(nil) || !IsRetryable(errDropped) || IsRetryable(ErrSameShard) {
+		t.Fatal("IsRetryable misclassified an error")
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.2}
+	for _, tc := range []struct {
+		attempt int
+		random  fixedRandom
+		want    time.Duration
+	}{
+		{1, 0, 100 * time.Millisecond},
+		{1, 0.5, 90 * time.Millisecond},
+		{3, 1, 320 * time.Millisecond},
+		{5, 0, time.Second}, // 1.6s capped
+		{60, 0.25, 950 * time.Millisecond},
+	} {
+		if got := policy.Delay(tc.attempt, tc.random); got != tc.want {
+			t.Errorf("attempt %d at %v: delay %v, want %v", tc.attempt, tc.random, got, tc.want)
+		}
+	}
+
+	// Without a cap the delay stops doubling before it overflows
+	uncapped := RetryPolicy{BaseDelay: time.Second}
+	if got := uncapped.Delay(200, fixedRandom(0)); got <= 0 {
+		t.Fatalf("uncapped delay overflowed to %v", got)
+	}
+
+	// Jitter drawn by the executor's source spreads the waits
+	te, _, slept, source, destination := retrySetup(t, 2, WithRetryRandom(fixedRandom(0.5)))
+	jittered := exactPolicy
+	jittered.Jitter = 0.5
+	if err := te.ExecuteWithRetry(TransferRequest{Key: "jitter", Source: source, Destination: destination, BlockIndex: 1}, jittered); err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{7500 * time.Microsecond, 15 * time.Millisecond}; !reflect.DeepEqual(*slept, want) {
+		t.Fatalf("slept %v, want %v", *slept, want)
+	}
+}
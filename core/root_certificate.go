@@ -0,0 +1,154 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ShardHeader is a shard root co-signed by the shard's committee, so light
+// clients need not trust whoever relays it
+type ShardHeader struct {
+	ShardID     int                `json:"shard_id"`
+	Height      uint64             `json:"height"` // Increases with every new root certified for the shard
+	Root        string             `json:"root"`
+	BlockCount  int                `json:"block_count"`
+	Certificate *CommitCertificate `json:"certificate,omitempty"`
+}
+
+// RootDecision is the value a shard committee votes on to certify a root,
+// binding it to the shard, the height and the block count
+func RootDecision(shardID int, height uint64, root string, blockCount int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("root:%d:%d:%s:%d", shardID, height, root, blockCount)))
+	return hex.EncodeToString(hash[:])
+}
+
+// VerifyShardHeader checks that a header carries a commit certificate
+// deciding its root, signed by a quorum of pubkeys
+func VerifyShardHeader(header ShardHeader, pubkeys map[int]ed25519.PublicKey) error {
+	if header.Certificate == nil {
+		return fmt.Errorf("%w: shard #%d height %d", ErrMissingCertificate, header.ShardID, header.Height)
+	}
+	if header.Certificate.BlockHash != RootDecision(header.ShardID, header.Height, header.Root, header.BlockCount) {
+		return fmt.Errorf("%w: certificate does not decide shard #%d root at height %d", ErrCertificateMismatch, header.ShardID, header.Height)
+	}
+	return VerifyCommitCertificate(*header.Certificate, pubkeys)
+}
+
+// decideRoot runs a consensus round over a shard root among the shard's
+// committee, returning a nil certificate when the shard has no committee
+func (esm *EnhancedSyncManager) decideRoot(header ShardHeader) (*CommitCertificate, error) {
+	esm.committeeMutex.Lock()
+	defer esm.committeeMutex.Unlock()
+	committee, exists := esm.committees[header.ShardID]
+	if !exists {
+		return nil, nil
+	}
+	cert, err := committee.Decide(0, header.BlockCount, RootDecision(header.ShardID, header.Height, header.Root, header.BlockCount))
+	if err != nil {
+		return nil, fmt.Errorf("committee of shard #%d: %w", header.ShardID, err)
+	}
+	return &cert, nil
+}
+
+// RootCertifier has the committee of every shard, as assigned with
+// AssignCommittee, co-sign the shard's root whenever it changes. Headers
+// are reported as ShardRootCertified events through WithShardEvents.
+type RootCertifier struct {
+	sm      *ShardManager
+	esm     *EnhancedSyncManager
+	headers map[int]ShardHeader // Latest certified header by shard ID
+	stop    chan struct{}
+	mutex   sync.Mutex
+}
+
+// NewRootCertifier creates a certifier for the shards of sm, using the
+// committees assigned in esm
+func NewRootCertifier(sm *ShardManager, esm *EnhancedSyncManager) *RootCertifier {
+	return &RootCertifier{sm: sm, esm: esm, headers: make(map[int]ShardHeader)}
+}
+
+// Certify has each committee sign its shard's current root if it differs
+// from the last one certified, and returns the new headers and the errors
+// of shards whose committee failed to reach a quorum by shard ID. Shards
+// without a committee are skipped.
+func (rc *RootCertifier) Certify() ([]ShardHeader, map[int]error) {
+	views := rc.sm.GetAllShardViews()
+
+	rc.mutex.Lock()
+	var certified []ShardHeader
+	failed := make(map[int]error)
+	for _, view := range views {
+		last, seen := rc.headers[view.ID]
+		if seen && last.Root == view.Root && last.BlockCount == view.BlockCount {
+			continue
+		}
+		header := ShardHeader{ShardID: view.ID, Height: last.Height + 1, Root: view.Root, BlockCount: view.BlockCount}
+		cert, err := rc.esm.decideRoot(header)
+		if err != nil {
+			failed[view.ID] = err
+			continue
+		}
+		if cert == nil {
+			continue
+		}
+		header.Certificate = cert
+		rc.headers[view.ID] = header
+		certified = append(certified, header)
+	}
+	rc.mutex.Unlock()
+
+	if rc.sm.onEvent != nil {
+		for i := range certified {
+			header := certified[i]
+			rc.sm.onEvent(ShardEvent{Type: ShardRootCertified, ShardID: header.ShardID, Header: &header})
+		}
+	}
+	return certified, failed
+}
+
+// Header returns the latest certified header of a shard
+func (rc *RootCertifier) Header(shardID int) (ShardHeader, bool) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	header, exists := rc.headers[shardID]
+	return header, exists
+}
+
+// Start certifies changed roots every interval until Stop is called
+func (rc *RootCertifier) Start(interval time.Duration) {
+	rc.mutex.Lock()
+	if rc.stop != nil {
+		rc.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	rc.stop = stop
+	rc.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rc.Certify()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts periodic certification
+func (rc *RootCertifier) Stop() {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if rc.stop != nil {
+		close(rc.stop)
+		rc.stop = nil
+	}
+}
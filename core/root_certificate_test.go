@@ -0,0 +1,121 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// certifiedManager returns a manager of several shards, all but the last
+// with a committee of four, and a certifier over them
+func certifiedManager(t *testing.T) (*ShardManager, *RootCertifier, map[int]*BFTManager, *[]ShardEvent) {
+	t.Helper()
+	var mu sync.Mutex
+	events := new([]ShardEvent)
+	sm := NewShardManager(WithShardEvents(func(e ShardEvent) {
+		mu.Lock()
+		*events = append(*events, e)
+		mu.Unlock()
+	}))
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range fixedBlocks(12) {
+		sm.DistributeBlock(b)
+	}
+	esm := NewEnhancedSyncManager("key")
+	committees := make(map[int]*BFTManager)
+	views := sm.GetAllShardViews()
+	if len(views) < 3 {
+		t.Fatalf("%d shards", len(views))
+	}
+	for _, view := range views[:len(views)-1] {
+		committees[view.ID] = behaviorBFT(4)
+		esm.AssignCommittee(view.ID, committees[view.ID])
+	}
+	return sm, NewRootCertifier(sm, esm), committees, events
+}
+
+func TestRootCertifierSignsChangedRoots(t *testing.T) {
+	sm, rc, committees, events := certifiedManager(t)
+	headers, failed := rc.Certify()
+	if len(failed) != 0 || len(headers) != len(committees) {
+		t.Fatalf("%d headers certified for %d committees, failures %v", len(headers), len(committees), failed)
+	}
+	for _, header := range headers {
+		view, _ := sm.GetShardView(header.ShardID)
+		if header.Height != 1 || header.Root != view.Root || header.BlockCount != view.BlockCount {
+			t.Fatalf("header %+v for shard %+v", header, view)
+		}
+		if err := VerifyShardHeader(header, committees[header.ShardID].PublicKeys()); err != nil {
+			t.Fatal(err)
+		}
+		if latest, _ := rc.Header(header.ShardID); latest.Root != header.Root {
+			t.Fatal("latest header not kept")
+		}
+	}
+	if len(*events) != len(headers) || (*events)[0].Type != ShardRootCertified || (*events)[0].Header == nil {
+		t.Fatalf("events %v", *events)
+	}
+
+	// Unchanged roots are not signed again; a changed one moves up a height
+	if headers, _ := rc.Certify(); len(headers) != 0 {
+		t.Fatalf("%d unchanged roots certified again", len(headers))
+	}
+	shard := sm.Shards.GetAllShards()[0]
+	extra := fixedBlocks(13)[12]
+	extra.Index = 100
+	extra.Hash = calculateHash(extra)
+	shard.AddBlock(extra)
+	headers, _ = rc.Certify()
+	if len(headers) != 1 || headers[0].ShardID != shard.ID || headers[0].Height != 2 {
+		t.Fatalf("after a block was added: %+v", headers)
+	}
+
+	// A committee short of a quorum certifies nothing
+	committees[shard.ID].Nodes[2].Behavior = SilentBehavior{}
+	committees[shard.ID].Nodes[3].Behavior = SilentBehavior{}
+	extra.Index = 101
+	extra.Hash = calculateHash(extra)
+	shard.AddBlock(extra)
+	headers, failed = rc.Certify()
+	if len(headers) != 0 || !errors.Is(failed[shard.ID], ErrInsufficientSignatures) {
+		t.Fatalf("committee without a quorum: %d headers, %v", len(headers), failed)
+	}
+	if latest, _ := rc.Header(shard.ID); latest.Height != 2 {
+		t.Fatalf("header at height %d after a failed round", latest.Height)
+	}
+}
+
+func TestVerifyShardHeaderRejectsForgeries(t *testing.T) {
+	_, rc, committees, _ := certifiedManager(t)
+	headers, _ := rc.Certify()
+	header := headers[0]
+	keys := committees[header.ShardID].PublicKeys()
+
+	forgedSig := header
+	cert := *header.Certificate
+	cert.Votes = append([]Vote{}, cert.Votes...)
+	cert.Votes[0].Signature = cert.Votes[1].Signature
+	forgedSig.Certificate = &cert
+	for name, tc := range map[string]struct {
+		edit func(h *ShardHeader)
+		want error
+	}{
+		"root":      {func(h *ShardHeader) { h.Root = HashBlockData("forged") }, ErrCertificateMismatch},
+		"height":    {func(h *ShardHeader) { h.Height++ }, ErrCertificateMismatch},
+		"shard":     {func(h *ShardHeader) { h.ShardID = -1 }, ErrCertificateMismatch},
+		"count":     {func(h *ShardHeader) { h.BlockCount++ }, ErrCertificateMismatch},
+		"missing":   {func(h *ShardHeader) { h.Certificate = nil }, ErrMissingCertificate},
+		"signature": {func(h *ShardHeader) { *h = forgedSig }, ErrInvalidVoteSignature},
+	} {
+		forged := header
+		tc.edit(&forged)
+		if err := VerifyShardHeader(forged, keys); !errors.Is(err, tc.want) {
+			t.Errorf("%s forged: %v", name, err)
+		}
+	}
+	if err := VerifyShardHeader(header, behaviorBFT(4).PublicKeys()); err == nil {
+		t.Fatal("header verified against another committee")
+	}
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+// RootEntry is a root and the height at which it was recorded
+type RootEntry struct {
+	Height int    `json:"height"`
+	Root   string `json:"root"`
+}
+
+// RootHistory is an append-only log of the roots a structure went through,
+// one entry per change, so the root in effect at any height can be found by
+// binary search. Heights never decrease; when a root changes several times
+// at one height every change is kept and the last one answers for it.
+type RootHistory struct {
+	entries []RootEntry // Ascending by height
+	mutex   sync.RWMutex
+}
+
+// NewRootHistory creates an empty history
+func NewRootHistory() *RootHistory {
+	return &RootHistory{}
+}
+
+// Record appends root at height unless it is the latest root already. A
+// height below the latest entry's is raised to it, keeping heights ordered.
+// It reports whether an entry was added.
+func (rh *RootHistory) Record(height int, root string) bool {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	if n := len(rh.entries); n > 0 {
+		latest := rh.entries[n-1]
+		if latest.Root == root {
+			return false
+		}
+		if height < latest.Height {
+			height = latest.Height
+		}
+	}
+	rh.entries = append(rh.entries, RootEntry{Height: height, Root: root})
+	return true
+}
+
+// At returns the root in effect at height: the last one recorded at or
+// below it. It reports false for heights before the first entry held,
+// including those whose entries were pruned.
+func (rh *RootHistory) At(height int) (string, bool) {
+	rh.mutex.RLock()
+	defer rh.mutex.RUnlock()
+	i := sort.Search(len(rh.entries), func(i int) bool {
+		return rh.entries[i].Height > height
+	})
+	if i == 0 {
+		return "", false
+	}
+	return rh.entries[i-1].Root, true
+}
+
+// Entries returns a copy of the history, oldest first
+func (rh *RootHistory) Entries() []RootEntry {
+	rh.mutex.RLock()
+	defer rh.mutex.RUnlock()
+	return append([]RootEntry{}, rh.entries...)
+}
+
+// Prune drops the entries superseded at or before height, keeping the one in
+// effect at height, so lookups at height and above answer as before. It
+// returns the number of entries dropped.
+func (rh *RootHistory) Prune(height int) int {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	i := sort.Search(len(rh.entries), func(i int) bool {
+		return rh.entries[i].Height > height
+	})
+	if i <= 1 {
+		return 0
+	}
+	rh.entries = append([]RootEntry{}, rh.entries[i-1:]...)
+	return i - 1
+}
+
+// pruneToCheckpoint prunes up to the latest checkpoint exported by cm,
+// leaving the history whole if none was
+func (rh *RootHistory) pruneToCheckpoint(cm *CheckpointManager) int {
+	if cm == nil {
+		return 0
+	}
+	exported := cm.ExportedHeight()
+	if exported < 0 {
+		return 0
+	}
+	return rh.Prune(exported)
+}
+
+// rewind drops the entries recorded above height, for a reorganization
+// replacing the blocks after it
+func (rh *RootHistory) rewind(height int) {
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
+	i := sort.Search(len(rh.entries), func(i int) bool {
+		return rh.entries[i].Height > height
+	})
+	rh.entries = rh.entries[:i]
+}
+
+// ForestRootAt returns the forest root in effect once the block at height
+// was distributed, including any rebalances, merges, transfers and prunes
+// made before a higher block arrived. It reports false for heights whose
+// history was pruned.
+func (sm *ShardManager) ForestRootAt(height int) (string, bool) {
+	return sm.roots.At(height)
+}
+
+// ForestRoots returns the recorded forest roots, oldest first
+func (sm *ShardManager) ForestRoots() []RootEntry {
+	return sm.roots.Entries()
+}
+
+// PruneForestRoots drops forest roots superseded at or before the latest
+// checkpoint exported by cm, which peers syncing from it no longer need.
+// Nothing after that checkpoint is dropped, and nothing at all if no
+// checkpoint was exported. It returns the number of entries dropped.
+func (sm *ShardManager) PruneForestRoots(cm *CheckpointManager) int {
+	return sm.roots.pruneToCheckpoint(cm)
+}
+
+// recordForestRoot records the current forest root at the highest block
+// index distributed so far; the caller must hold the manager lock
+func (sm *ShardManager) recordForestRoot() {
+	tree, _ := sm.forestTree()
+	sm.roots.Record(sm.height, tree.GetRootHash())
+}
+
+// PruneStateRoots drops state roots superseded at or before the latest
+// checkpoint exported by the attached CheckpointManager, like
+// PruneForestRoots. Without an exported checkpoint nothing is dropped.
+func (bc *Blockchain) PruneStateRoots() int {
+	return bc.ledger().history.pruneToCheckpoint(bc.checkpoints)
+}
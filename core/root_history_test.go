@@ -0,0 +1,161 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRootHistoryLookup(t *testing.T) {
+	rh := NewRootHistory()
+	if _, ok := rh.At(0); ok {
+		t.Fatal("empty history answered")
+	}
+	for _, e := range []RootEntry{{0, "a"}, {3, "b"}, {3, "c"}, {7, "c"}, {5, "d"}, {9, "e"}} {
+		rh.Record(e.Height, e.Root)
+	}
+	// An unchanged root adds nothing, and a lower height is raised
+	if got := fmt.Sprint(rh.Entries()); got != "[{0 a} {3 b} {3 c} {5 d} {9 e}]" {
+		t.Fatalf("entries %s", got)
+	}
+	for height, want := range map[int]string{0: "a", 2: "a", 3: "c", 4: "c", 5: "d", 8: "d", 9: "e", 1000: "e"} {
+		if root, ok := rh.At(height); !ok || root != want {
+			t.Fatalf("root at %d: %q, want %q", height, root, want)
+		}
+	}
+	if _, ok := rh.At(-1); ok {
+		t.Fatal("root before the first entry")
+	}
+
+	// Pruning keeps the root in effect at the pruned height
+	if dropped := rh.Prune(8); dropped != 3 {
+		t.Fatalf("dropped %d entries", dropped)
+	}
+	if root, ok := rh.At(8); !ok || root != "d" {
+		t.Fatalf("root at the pruned height: %q", root)
+	}
+	if _, ok := rh.At(2); ok {
+		t.Fatal("root answered from pruned history")
+	}
+	if rh.Prune(8) != 0 || rh.Prune(-5) != 0 {
+		t.Fatal("pruned again")
+	}
+	rh.rewind(8)
+	if got := fmt.Sprint(rh.Entries()); got != "[{5 d}]" {
+		t.Fatalf("entries after rewinding to 8: %s", got)
+	}
+}
+
+func TestForestRootAtSpansRebalances(t *testing.T) {
+	bc := longChain(t, 30)
+	cm := NewCheckpointManager(10)
+	if err := bc.SetCheckpointManager(cm); err != nil {
+		t.Fatal(err)
+	}
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// The root once each block was distributed, splits included
+	roots := make(map[int]string)
+	for _, b := range bc.Blocks[:21] {
+		sm.DistributeBlock(b)
+		roots[b.Index] = sm.ForestRoot()
+	}
+	shards := sm.Shards.Size()
+	sm.MergeShards(4)
+	if sm.Shards.Size() == shards {
+		t.Fatal("nothing merged")
+	}
+	// A merge after block 20 is the root in effect at 20
+	roots[20] = sm.ForestRoot()
+	for _, b := range bc.Blocks[21:] {
+		sm.DistributeBlock(b)
+		roots[b.Index] = sm.ForestRoot()
+	}
+	if len(sm.ForestRoots()) < 25 {
+		t.Fatalf("%d roots recorded for 31 blocks and a merge", len(sm.ForestRoots()))
+	}
+	for height, want := range roots {
+		if root, ok := sm.ForestRootAt(height); !ok || root != want {
+			t.Fatalf("forest root at %d: %s, want %s", height, root, want)
+		}
+	}
+
+	// Nothing is pruned until a checkpoint is exported, then only before it
+	if sm.PruneForestRoots(cm) != 0 || sm.PruneForestRoots(nil) != 0 {
+		t.Fatal("pruned without an exported checkpoint")
+	}
+	if cp, _ := cm.ExportLatest(); cp.Height != 30 {
+		t.Fatalf("exported checkpoint at %d", cp.Height)
+	}
+	if sm.PruneForestRoots(cm) == 0 {
+		t.Fatal("nothing pruned before the exported checkpoint")
+	}
+	for height, want := range roots {
+		root, ok := sm.ForestRootAt(height)
+		if height >= 30 && (!ok || root != want) {
+			t.Fatalf("forest root at %d lost to pruning", height)
+		}
+		if height < 30 && ok {
+			t.Fatalf("forest root at %d kept after pruning to 30", height)
+		}
+	}
+}
+
+func TestStateRootAtSpansPrunes(t *testing.T) {
+	bc := fundedChain(t, map[string]uint64{"~alice": 100})
+	cm := NewCheckpointManager(4)
+	if err := bc.SetCheckpointManager(cm); err != nil {
+		t.Fatal(err)
+	}
+	mp := NewMempool(100)
+	bp := NewBlockProducer(bc, mp, 4)
+	roots := map[int]string{0: bc.State().StateRoot()}
+	for i := 1; i <= 12; i++ {
+		// Data blocks in between leave the state as it was
+		if i%3 == 0 {
+			if err := bc.AddBlock(fmt.Sprintf("data-%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			submitAll(t, mp, Transaction{From: "~alice", To: fmt.Sprintf("~bob-%d", i), Amount: 1, Nonce: bc.State().NonceOf("~alice")})
+			if _, err := bp.ProduceBlock(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		roots[i] = bc.State().StateRoot()
+	}
+	if roots[3] != roots[2] || roots[2] == roots[1] {
+		t.Fatal("state roots do not follow the blocks")
+	}
+	for height, want := range roots {
+		if root, ok := bc.State().StateRootAt(height); !ok || root != want {
+			t.Fatalf("state root at %d: %s, want %s", height, root, want)
+		}
+	}
+
+	if bc.PruneStateRoots() != 0 {
+		t.Fatal("pruned without an exported checkpoint")
+	}
+	cm.ExportLatest()
+	if dropped := bc.PruneStateRoots(); dropped != 8 {
+		t.Fatalf("dropped %d state roots before the checkpoint at 12", dropped)
+	}
+	for i := 0; i < 3; i++ {
+		submitAll(t, mp, Transaction{From: "~alice", To: "~carol", Amount: 1, Nonce: bc.State().NonceOf("~alice")})
+		if _, err := bp.ProduceBlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Block 12 holds data, so the root recorded at 11 is kept for it
+	if _, ok := bc.State().StateRootAt(10); ok {
+		t.Fatal("state root kept from before the checkpoint")
+	}
+	if root, ok := bc.State().StateRootAt(12); !ok || root != roots[12] {
+		t.Fatal("state root at the checkpoint lost")
+	}
+	if root, ok := bc.State().StateRootAt(15); !ok || root != bc.State().StateRoot() {
+		t.Fatal("state root after the checkpoint missing")
+	}
+}
@@ -1,44 +1,439 @@
 package core
 
 import (
+	"crypto/ed25519"
 	"fmt"
+	"io"
+	"math/big"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Default merge and split thresholds; see ShardManager.SetThresholds
 const MinBlocksPerShard = 2
 const MaxBlocksPerShard = 3 // Example threshold for demo/testing
 
+// BlockFilterFalsePositiveRate is the target false positive rate of Shard.BlockFilter
+const BlockFilterFalsePositiveRate = 0.01
+
 type Shard struct {
-	ID     int
-	Blocks []Block
-	Tree   *MerkleTree
-	mutex  sync.Mutex
+	ID           int
+	Blocks       []Block
+	Tree         *MerkleTree
+	Accumulator  *RSAAccumulator   // Optional accumulator over block hashes
+	PrevRoot     string            // Root before the most recent AddBlock
+	PrevSize     int               // Block count before the most recent AddBlock
+	State        *LedgerState      // Balances of the accounts this shard owns
+	Receipts     map[string]string // Debit receipt commitments keyed by transaction hash
+	StateManager *StateManager     // Optional active/archived block state for pruning
+	AddrLow      int               // First address byte owned (inclusive)
+	AddrHigh     int               // Last address byte owned (inclusive); empty if below AddrLow
+	RangeStart   string            // Lowest block hash covered under HashRangeSplit (inclusive)
+	RangeEnd     string            // Highest block hash covered under HashRangeSplit (inclusive)
+	version      uint64            // Bumped whenever Blocks changes
+	filter       *BloomFilter      // Locator filter over block hashes, see LocateBlock
+	filterAt     uint64            // Version filter reflects
+	filterBuilt  uint64            // Version filter was last rebuilt from scratch at
+	span         *blockSpan        // Index and time range of Blocks, see IndexRange
+	spanAt       uint64            // Version span reflects
+	transitions  []TransitionProof // Recent append proofs, nil when disabled
+	proveAppends bool
+	bodies       BodyStore              // Holds block bodies when Blocks keeps only headers, see StoreHeadersOnly
+	chain        string                 // Rolling commitment over block hashes, see ChainCommitment
+	watch        atomic.Pointer[uint64] // Change counter of the ShardIndex holding the shard
+	mutex        sync.Mutex
 }
 
+// MaxTransitionProofs is how many recent transition proofs a shard keeps
+const MaxTransitionProofs = 64
+
 type ShardManager struct {
-	Shards *RBTree // Use Red-Black Tree for shard storage
+	Shards       *ShardIndex    // Red-Black Tree of shards keyed by ID
+	accumulators bool           // Maintain an RSAAccumulator per shard
+	stateActive  int            // Active blocks per shard StateManager; 0 disables
+	minBlocks    int            // Shards below this are merged by MergeSmallShards
+	maxBlocks    int            // Shards above this are split on rebalance
+	forest       forestCache    // Cached Merkle tree over shard roots
+	locator      locatorCache   // Cached union of shard locator filters
+	locatorBits  uint           // Bits per locator filter, see WithLocatorFilter
+	locatorFuncs uint           // Hash functions per locator filter
+	assignments  map[int]string // Node hosting each shard, see AssignShardToNode
+	bodies       BodyStore      // Body store of header-only shards; nil keeps full blocks
+	collectEmpty bool           // Run CollectEmptyShards after merges and transfers
+	onEvent      func(ShardEvent)
+	split        SplitStrategy             // How oversized shards are split
+	byRange      []*Shard                  // Shards ordered by RangeStart under HashRangeSplit
+	affinity     map[string][]int          // Shards holding each affinity key under AffinityPolicy
+	ingest       *ingestPipeline           // Running StartIngest pipeline, nil if none
+	ingestBatch  int                       // Blocks per ingest batch, see WithIngestBatching
+	ingestFlush  time.Duration             // Longest wait for an ingest batch to fill
+	capabilities *HomomorphicAuthenticator // Signs capability tokens, see WithCapabilityKey
+	strict       bool                      // Verify all shards after merges and splits
+	lastVerify   map[int]error             // Failures of the latest strict verification
+	merges       []MergeProof              // Proofs of recent merges, oldest first
+	queryWorkers int                       // Shards a range query scans at once; 0 uses the default
+	archiveKey   ed25519.PrivateKey        // Signs shard archives, see WithShardArchiveKey
+	roots        *RootHistory              // Forest roots by height, see ForestRootAt
+	height       int                       // Highest block index distributed
+	faults       FaultInjector             // Hooks for fault-injection tests, see WithFaultInjector
+	rebalanceErr error                     // Failure of the latest automatic rebalance, see LastRebalanceError
+	mutex        sync.RWMutex
+}
+
+// ShardManagerOption configures a ShardManager
+type ShardManagerOption func(*ShardManager)
+
+// WithShardAccumulators maintains an RSAAccumulator of block hashes per shard
+func WithShardAccumulators() ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.accumulators = true
+	}
+}
+
+// WithShardState attaches a StateManager keeping maxActive active blocks to
+// every shard so each shard can prune its own history
+func WithShardState(maxActive int) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.stateActive = maxActive
+	}
 }
 
-// NewShard creates a new shard with a unique ID
+// WithHeaderOnlyShards makes every shard keep only block headers, with the
+// bodies in store
+func WithHeaderOnlyShards(store BodyStore) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.bodies = store
+	}
+}
+
+// ShardView is an immutable snapshot of a shard for queries. Its block slice
+// is a private copy, so holding a view never observes later mutations.
+type ShardView struct {
+	ID               int
+	Blocks           []Block
+	Root             string
+	BlockCount       int
+	AccumulatorState string // Hex accumulator state, empty if disabled
+	StateRoot        string // Root of the shard's account state
+	ChainCommitment  string // Rolling commitment over block hashes in order
+}
+
+// NewShard creates a new shard with a unique ID owning the whole address space
 func NewShard(id int) *Shard {
 	return &Shard{
-		ID:     id,
-		Blocks: []Block{},
+		ID:       id,
+		Blocks:   []Block{},
+		chain:    chainCommitment(nil),
+		State:    NewLedgerState(),
+		Receipts: make(map[string]string),
+		AddrLow:  0,
+		AddrHigh: 255,
 	}
 }
 
-// AddBlock adds a block to a shard and rebuilds its Merkle tree
+// SetAddressRange assigns the addresses whose first byte lies in [low, high]
+// to this shard; low > high leaves it owning none
+func (s *Shard) SetAddressRange(low, high int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.AddrLow, s.AddrHigh = low, high
+}
+
+// OwnsAddress reports whether an account belongs to this shard's partition
+func (s *Shard) OwnsAddress(addr string) bool {
+	first := 0
+	if addr != "" {
+		first = int(addr[0])
+	}
+	return first >= s.AddrLow && first <= s.AddrHigh
+}
+
+// Credit funds an account owned by this shard, e.g. for genesis allocations
+func (s *Shard) Credit(addr string, amount uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.OwnsAddress(addr) {
+		return fmt.Errorf("%w: %s in shard #%d", ErrAddressNotOwned, addr, s.ID)
+	}
+	return s.State.Credit(addr, amount)
+}
+
+// BalanceOf returns the balance of an account held by this shard
+func (s *Shard) BalanceOf(addr string) uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.State.BalanceOf(addr)
+}
+
+// AddBlock adds a block to a shard and appends it to its Merkle tree
 func (s *Shard) AddBlock(block Block) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	s.addBlock(block)
+}
+
+// addBlock appends a block; the caller must hold the shard mutex
+func (s *Shard) addBlock(block Block) {
+	full := block
+	block = s.stripBody(block)
+
+	// Remember the previous state for consistency proofs. An empty shard
+	// has no tree yet, but its previous root is still the empty tree's.
+	s.PrevSize = len(s.Blocks)
+	if s.Tree == nil {
+		s.Tree = newBlockTree(s.Blocks)
+	}
+	s.PrevRoot = s.Tree.GetRootHash()
 
 	s.Blocks = append(s.Blocks, block)
+	s.chain = extendChainCommitment(s.chain, block.Hash)
+	s.touch()
+	if s.filter != nil && s.filterAt == s.version-1 {
+		s.filter.Add(block.Hash)
+		s.filterAt = s.version
+	}
+	if s.span != nil && s.spanAt == s.version-1 {
+		s.span.extend(block)
+		s.spanAt = s.version
+	}
+	if s.Accumulator != nil {
+		s.Accumulator.AddElement(block.Hash)
+	}
+	if s.StateManager != nil {
+		s.StateManager.AddBlock(block)
+	}
 
-	var data []string
+	// Fall back to a full rebuild if the tree is missing or out of step
+	if s.Tree == nil || len(s.Tree.Leaves) != len(s.Blocks)-1 {
+		s.Tree = newBlockTree(s.Blocks)
+		return
+	}
+	if s.proveAppends {
+		s.transitions = append(s.transitions, proveAppend(s.Tree, full))
+		if len(s.transitions) > MaxTransitionProofs {
+			s.transitions = s.transitions[len(s.transitions)-MaxTransitionProofs:]
+		}
+	}
+	s.Tree.AppendLeafHash(s.Tree.leafOf(block))
+}
+
+// StoreHeadersOnly makes the shard keep only block headers, moving the
+// bodies of its current and future blocks into store. Roots are unchanged,
+// since the tree is built from each header's DataHash; full blocks are
+// fetched back with FullBlock.
+func (s *Shard) StoreHeadersOnly(store BodyStore) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	for _, b := range s.Blocks {
-		data = append(data, b.Data)
+		if b.HeaderOnly() {
+			continue
+		}
+		if err := store.PutBody(b.Hash, b.Body()); err != nil {
+			return fmt.Errorf("shard #%d: storing body of %s: %w", s.ID, b.Hash, err)
+		}
+	}
+	s.bodies = store
+	for i, b := range s.Blocks {
+		s.Blocks[i] = b.Header().Block()
+	}
+	s.touch()
+	s.rebuildState()
+	return nil
+}
+
+// stripBody stores a block's body and returns its header-only form when
+// the shard keeps only headers. Blocks whose body cannot be stored are kept
+// whole. The caller must hold the shard mutex.
+func (s *Shard) stripBody(block Block) Block {
+	if s.bodies == nil || block.HeaderOnly() {
+		return block
 	}
-	s.Tree = NewMerkleTree(data)
+	if err := s.bodies.PutBody(block.Hash, block.Body()); err != nil {
+		return block
+	}
+	return block.Header().Block()
+}
+
+// Headers returns the headers of the shard's blocks
+func (s *Shard) Headers() []BlockHeader {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	headers := make([]BlockHeader, len(s.Blocks))
+	for i, b := range s.Blocks {
+		headers[i] = b.Header()
+	}
+	return headers
+}
+
+// FullBlock returns the block at index with its body, loading the body from
+// the shard's BodyStore if only the header is kept
+func (s *Shard) FullBlock(index int) (Block, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fullBlock(index)
+}
+
+// fullBlock implements FullBlock; the caller must hold the shard mutex
+func (s *Shard) fullBlock(index int) (Block, error) {
+	if index < 0 || index >= len(s.Blocks) {
+		return Block{}, fmt.Errorf("%w: %d for shard #%d (block count: %d)", ErrIndexOutOfRange, index, s.ID, len(s.Blocks))
+	}
+	b := s.Blocks[index]
+	if !b.HeaderOnly() {
+		return b, nil
+	}
+	if s.bodies == nil {
+		return Block{}, fmt.Errorf("%w: %s in shard #%d", ErrBodyNotFound, b.Hash, s.ID)
+	}
+	body, err := s.bodies.GetBody(b.Hash)
+	if err != nil {
+		return Block{}, fmt.Errorf("shard #%d: %w", s.ID, err)
+	}
+	return b.Header().WithBody(body)
+}
+
+// EnableTransitionProofs makes AddBlock emit a proof that each new root is
+// the previous one with the block appended. Blocks that force a tree
+// rebuild, such as a shard's first block or one after a removal, get no
+// proof.
+func (s *Shard) EnableTransitionProofs(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.proveAppends = enabled
+	if !enabled {
+		s.transitions = nil
+	}
+}
+
+// TransitionProofs returns the shard's recent transition proofs, oldest first
+func (s *Shard) TransitionProofs() []TransitionProof {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]TransitionProof{}, s.transitions...)
+}
+
+// TransitionProof implements TransitionSource
+func (s *Shard) TransitionProof(oldRoot, newRoot, blockHash string) (TransitionProof, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := len(s.transitions) - 1; i >= 0; i-- {
+		p := s.transitions[i]
+		if p.OldRoot == oldRoot && p.NewRoot == newRoot && p.BlockHash == blockHash {
+			return p, true
+		}
+	}
+	return TransitionProof{}, false
+}
+
+// touch records a change to Blocks; the caller must hold the shard mutex
+func (s *Shard) touch() {
+	s.version++
+	if changes := s.watch.Load(); changes != nil {
+		atomic.AddUint64(changes, 1)
+	}
+}
+
+// removeBlockAt deletes a block and rebuilds the tree; the caller must hold the shard mutex
+func (s *Shard) removeBlockAt(index int) Block {
+	block := s.Blocks[index]
+	s.Blocks = append(s.Blocks[:index], s.Blocks[index+1:]...)
+	s.touch()
+	if s.Accumulator != nil {
+		s.Accumulator.RemoveElement(block.Hash)
+	}
+	s.rebuildState()
+
+	// Removals need a full rebuild of the tree and chain commitment
+	s.Tree = newBlockTree(s.Blocks)
+	s.chain = chainCommitment(s.Blocks)
+	return block
+}
+
+// rebuild recomputes the tree and accumulator after Blocks was replaced;
+// the caller must hold the shard mutex
+func (s *Shard) rebuild() {
+	s.touch()
+	s.Tree = newBlockTree(s.Blocks)
+	s.chain = chainCommitment(s.Blocks)
+	if s.Accumulator != nil {
+		s.Accumulator = NewRSAAccumulator()
+		for _, b := range s.Blocks {
+			s.Accumulator.AddElement(b.Hash)
+		}
+	}
+	s.rebuildState()
+}
+
+// withBlocks returns a copy of the shard holding blocks in place of its
+// own, with the tree, accumulator and state manager rebuilt over them. The
+// shard itself is left untouched, so readers holding it never see a
+// half-applied split. The caller must hold the shard mutex.
+func (s *Shard) withBlocks(blocks []Block) *Shard {
+	receipts := make(map[string]string, len(s.Receipts))
+	for hash, receipt := range s.Receipts {
+		receipts[hash] = receipt
+	}
+	copied := &Shard{
+		ID:           s.ID,
+		Blocks:       append([]Block{}, blocks...),
+		Accumulator:  s.Accumulator, // Replaced by rebuild
+		PrevRoot:     s.PrevRoot,
+		PrevSize:     s.PrevSize,
+		State:        s.State.Clone(),
+		Receipts:     receipts,
+		StateManager: s.StateManager, // Replaced by rebuild
+		AddrLow:      s.AddrLow,
+		AddrHigh:     s.AddrHigh,
+		RangeStart:   s.RangeStart,
+		RangeEnd:     s.RangeEnd,
+		version:      s.version,
+		transitions:  append([]TransitionProof(nil), s.transitions...),
+		proveAppends: s.proveAppends,
+		bodies:       s.bodies,
+	}
+	copied.rebuild()
+	return copied
+}
+
+// rebuildState re-derives the shard's StateManager from its current blocks,
+// keeping blocks that were archived in the archive; the caller must hold the
+// shard mutex
+func (s *Shard) rebuildState() {
+	if s.StateManager != nil {
+		s.StateManager = rebuildStateManager(s.StateManager.MaxActiveCount, s.Blocks, s.StateManager)
+	}
+}
+
+// ProveBlockMembership returns the accumulator witness for a block hash
+func (s *Shard) ProveBlockMembership(hash string) (*big.Int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Accumulator == nil {
+		return nil, fmt.Errorf("shard #%d has no accumulator", s.ID)
+	}
+	proof, exists := s.Accumulator.Proofs[hash]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s in shard #%d", ErrBlockNotFound, hash, s.ID)
+	}
+	return new(big.Int).Set(proof), nil
+}
+
+// BlockFilter returns a Bloom filter over the shard's block hashes that
+// another shard can test against before sending blocks
+func (s *Shard) BlockFilter() (*BloomFilter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	filter, err := NewBloomFilterFor(len(s.Blocks), BlockFilterFalsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range s.Blocks {
+		filter.Add(b.Hash)
+	}
+	return filter, nil
 }
 
 // GetRoot returns the Merkle root of this shard
@@ -49,50 +444,282 @@ func (s *Shard) GetRoot() string {
 	return ""
 }
 
+// GetConsistencyProof proves the current root extends the root recorded
+// before the most recent AddBlock
+func (s *Shard) GetConsistencyProof() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Tree == nil {
+		return nil, fmt.Errorf("shard #%d has no Merkle tree", s.ID)
+	}
+	return s.Tree.ConsistencyProof(s.PrevSize)
+}
+
+// View takes a consistent snapshot of the shard
+func (s *Shard) View() ShardView {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	blocks := make([]Block, len(s.Blocks))
+	copy(blocks, s.Blocks)
+	root := ""
+	if s.Tree != nil {
+		root = s.Tree.GetRootHash()
+	}
+	accState := ""
+	if s.Accumulator != nil {
+		accState = s.Accumulator.State.Text(16)
+	}
+	return ShardView{
+		ID:               s.ID,
+		Blocks:           blocks,
+		Root:             root,
+		BlockCount:       len(blocks),
+		AccumulatorState: accState,
+		StateRoot:        s.State.StateRoot(),
+		ChainCommitment:  s.chain,
+	}
+}
+
+// ProveInclusion returns a block and its Merkle path within the shard tree
+func (s *Shard) ProveInclusion(hash string) (Block, MerkleProof, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.proveInclusion(hash)
+}
+
+// proveInclusion implements ProveInclusion; the caller must hold the shard mutex
+func (s *Shard) proveInclusion(hash string) (Block, MerkleProof, error) {
+	for i, b := range s.Blocks {
+		if b.Hash == hash {
+			if s.Tree == nil || len(s.Tree.Leaves) != len(s.Blocks) {
+				s.Tree = newBlockTree(s.Blocks)
+			}
+			proof, err := s.Tree.GetProof(i)
+			if err == nil && s.StateManager != nil {
+				s.StateManager.recordAccess(hash)
+			}
+			return b, proof, err
+		}
+	}
+	return Block{}, MerkleProof{}, fmt.Errorf("%w: %s in shard #%d", ErrBlockNotFound, hash, s.ID)
+}
+
 // Initialize shard manager
-func NewShardManager() *ShardManager {
-	tree := NewRBTree()
-	shard := NewShard(0)
-	tree.Insert(shard) // Start with one shard
-	return &ShardManager{
-		Shards: tree,
+func NewShardManager(opts ...ShardManagerOption) *ShardManager {
+	sm := &ShardManager{
+		minBlocks:    MinBlocksPerShard,
+		maxBlocks:    MaxBlocksPerShard,
+		locatorBits:  DefaultLocatorBits,
+		locatorFuncs: DefaultLocatorHashes,
+		roots:        NewRootHistory(),
 	}
+	for _, opt := range opts {
+		opt(sm)
+	}
+	first := sm.newShard(0) // Start with one shard
+	if sm.SplitStrategy() == HashRangeSplit {
+		first.RangeStart, first.RangeEnd = minBlockHash, maxBlockHash
+	}
+	tree := NewShardIndex()
+	tree.Insert(first)
+	sm.Shards = tree
+	sm.indexRanges()
+	sm.recordForestRoot()
+	return sm
 }
 
-// DistributeBlock handles dynamic allocation
+// newShard creates a shard configured with the manager's options
+func (sm *ShardManager) newShard(id int) *Shard {
+	shard := NewShard(id)
+	if sm.accumulators {
+		shard.Accumulator = NewRSAAccumulator()
+	}
+	if sm.stateActive > 0 {
+		shard.StateManager = NewStateManager(sm.stateActive)
+	}
+	shard.bodies = sm.bodies
+	return shard
+}
+
+// VerifyBlockInShard checks an accumulator witness against the shard's
+// state, returning ErrShardNotFound or ErrInvalidProof if it does not hold
+func (sm *ShardManager) VerifyBlockInShard(shardID int, hash string, proof *big.Int) error {
+	shard, err := sm.FindShard(shardID)
+	if err != nil {
+		return err
+	}
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.Accumulator == nil {
+		return fmt.Errorf("%w: shard #%d has no accumulator", ErrInvalidProof, shardID)
+	}
+	return shard.Accumulator.CheckMembership(hash, proof)
+}
+
+// DistributeBlock handles dynamic allocation. The block is placed even if
+// the rebalance after it fails; see LastRebalanceError.
 func (sm *ShardManager) DistributeBlock(block Block) {
-	// Get the last shard (highest ID)
-	shards := sm.Shards.GetAllShards()
-	lastShard := shards[len(shards)-1]
-	lastShard.AddBlock(block)
+	split, err := sm.distributeBlock(block)
+	sm.reportRebalance(err)
+	if split {
+		sm.verifyIfStrict()
+	}
+}
+
+// distributeBlock implements DistributeBlock, reporting whether a shard
+// was split and the rebalance error
+func (sm *ShardManager) distributeBlock(block Block) (bool, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if block.Index > sm.height {
+		sm.height = block.Index
+	}
+
+	// The last shard (highest ID), the one covering the block's hash, or
+	// the one chosen by affinity
+	switch sm.SplitStrategy() {
+	case HashRangeSplit:
+		target := sm.homeShard(block.Hash)
+		target.mutex.Lock()
+		target.insertByHash([]Block{block})
+		target.mutex.Unlock()
+	case AffinityPolicy:
+		target := sm.affinityShard(block)
+		target.AddBlock(block)
+		sm.recordAffinity(block.Affinity, target.ID)
+	default:
+		sm.homeShard(block.Hash).AddBlock(block)
+	}
+
+	// Trigger rebalance if needed; a failed one leaves the layout as it was
+	// and is retried with the next block
+	shards := sm.Shards.Size()
+	err := sm.rebalanceShards()
+	sm.rebalanceErr = err
+	sm.recordForestRoot()
+	return sm.Shards.Size() != shards, err
+}
+
+// RemoveBlock drops the block with the given hash from every shard holding
+// it, e.g. one a chain reorganization rolled back, and reports whether any
+// did
+func (sm *ShardManager) RemoveBlock(hash string) bool {
+	sm.mutex.Lock()
+	removed := false
+	for _, shard := range sm.Shards.GetAllShards() {
+		shard.mutex.Lock()
+		for i := len(shard.Blocks) - 1; i >= 0; i-- {
+			if shard.Blocks[i].Hash == hash {
+				shard.removeBlockAt(i)
+				removed = true
+			}
+		}
+		shard.mutex.Unlock()
+	}
+	if removed {
+		sm.recordForestRoot()
+	}
+	sm.mutex.Unlock()
+	if removed {
+		sm.verifyIfStrict()
+	}
+	return removed
+}
+
+// LastRebalanceError returns why the rebalance after the latest block
+// distributed failed, or nil if it succeeded. A failed rebalance leaves
+// the shards as they were and is retried with the next block.
+func (sm *ShardManager) LastRebalanceError() error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.rebalanceErr
+}
 
-	// Trigger rebalance if needed
-	sm.RebalanceShards()
+// reportRebalance emits a ShardRebalanceFailed event for a failed
+// automatic rebalance; the caller must not hold the manager lock
+func (sm *ShardManager) reportRebalance(err error) {
+	if err != nil && sm.onEvent != nil {
+		sm.onEvent(ShardEvent{Type: ShardRebalanceFailed, ShardID: -1, Error: err.Error()})
+	}
 }
 
-// RebalanceShards splits or keeps shards based on block count
-func (sm *ShardManager) RebalanceShards() {
+// SetThresholds changes the block counts below which shards are merged and
+// above which they are split
+func (sm *ShardManager) SetThresholds(min, max int) error {
+	if min < 1 || max < min {
+		return fmt.Errorf("invalid shard thresholds: min %d, max %d", min, max)
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.minBlocks, sm.maxBlocks = min, max
+	return nil
+}
+
+// Thresholds returns the current merge and split thresholds
+func (sm *ShardManager) Thresholds() (int, int) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.minBlocks, sm.maxBlocks
+}
+
+// RebalanceShards splits or keeps shards based on block count. If it fails,
+// e.g. at an injected fault, the shards are left as they were.
+func (sm *ShardManager) RebalanceShards() error {
+	defer sm.verifyIfStrict() // Runs once the lock is released
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	err := sm.rebalanceShards()
+	sm.recordForestRoot()
+	return err
+}
+
+// rebalanceShards splits shards above the split threshold; the caller must
+// hold the manager lock
+func (sm *ShardManager) rebalanceShards() error {
+	return sm.splitShards(sm.maxBlocks)
+}
+
+// splitShards splits shards holding more than max blocks. Split shards are
+// replaced by new shard objects in a new tree that is swapped in whole, so a
+// shard obtained before the rebalance keeps its blocks and readers see
+// either the old or the new layout; if the mid-rebalance hook fails, the
+// old one stays. The caller must hold the manager lock.
+func (sm *ShardManager) splitShards(max int) error {
+	if sm.SplitStrategy() == HashRangeSplit {
+		return sm.rebalanceByHash(max)
+	}
 	currentShards := sm.Shards.GetAllShards()
-	newTree := NewRBTree()
-	shardIDCounter := len(currentShards)
+	newTree := NewShardIndex()
+	shardIDCounter := sm.Shards.NextID()
+	var moves []func() // Affinity updates, made once the new layout is in
 
 	for _, shard := range currentShards {
-		if len(shard.Blocks) > MaxBlocksPerShard {
-			// Split this shard
+		if len(shard.Blocks) > max {
+			// Split this shard, replacing it with a copy holding the left half
+			shard.mutex.Lock()
 			mid := len(shard.Blocks) / 2
-			leftBlocks := shard.Blocks[:mid]
-			rightBlocks := shard.Blocks[mid:]
-
-			// Update original shard with left half
-			shard.Blocks = leftBlocks
-			shard.Tree = NewMerkleTree(getDataStrings(leftBlocks))
-			newTree.Insert(shard)
+			leftBlocks, rightBlocks := shard.Blocks[:mid], shard.Blocks[mid:]
+			if sm.SplitStrategy() == AffinityPolicy {
+				leftBlocks, rightBlocks = splitByAffinity(shard.Blocks, max)
+			}
+			oldState := shard.StateManager
+			newTree.Insert(shard.withBlocks(leftBlocks))
+			shard.mutex.Unlock()
 
-			// Create new shard with right half
-			newShard := NewShard(shardIDCounter)
+			// Create new shard with right half; accounts stay with the original
+			// shard until the address space is partitioned again
+			newShard := sm.newShard(shardIDCounter)
+			newShard.AddrLow, newShard.AddrHigh = 1, 0
 			for _, b := range rightBlocks {
 				newShard.AddBlock(b)
 			}
+			if oldState != nil {
+				newShard.StateManager = rebuildStateManager(oldState.MaxActiveCount, rightBlocks, oldState)
+			}
+			fromID, toID := shard.ID, newShard.ID
+			moves = append(moves, func() { sm.moveAffinity(leftBlocks, rightBlocks, fromID, toID) })
 			newTree.Insert(newShard)
 			shardIDCounter++
 		} else {
@@ -100,32 +727,108 @@ func (sm *ShardManager) RebalanceShards() {
 		}
 	}
 
+	if len(moves) > 0 {
+		if err := injectFault(sm.faults, FaultMidRebalance); err != nil {
+			return err
+		}
+	}
 	sm.Shards = newTree
+	for _, move := range moves {
+		move()
+	}
+	return nil
 }
 
-func getDataStrings(blocks []Block) []string {
-	var data []string
-	for _, b := range blocks {
-		data = append(data, b.Data)
-	}
-	return data
+// ShardSummary is the serializable per-shard part of a ForestState
+type ShardSummary struct {
+	ID         int    `json:"id"`
+	Root       string `json:"root"`
+	BlockCount int    `json:"block_count"`
+	StateRoot  string `json:"state_root"`
 }
 
-// PrintShardState displays Merkle roots and shard info
-func (sm *ShardManager) PrintShardState() {
-	fmt.Println("\n==== Shard Merkle Forest ====")
+// ForestState is a serializable snapshot of the shard Merkle forest
+type ForestState struct {
+	ForestRoot string            `json:"forest_root"`
+	Shards     []ShardSummary    `json:"shards"` // Ascending by shard ID
+	Stats      RBTreeStats       `json:"stats"`
+	Index      []ShardIndexEntry `json:"index"` // Shard index in pre-order
+}
+
+// State returns the shard roots, forest root and index structure, all taken
+// under one lock so they describe the same moment
+func (sm *ShardManager) State() ForestState {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	tree, _ := sm.forestTree()
+	state := ForestState{
+		ForestRoot: tree.GetRootHash(),
+		Stats:      sm.Shards.Stats(),
+		Index:      sm.Shards.Dump(),
+	}
 	for _, shard := range sm.Shards.GetAllShards() {
-		fmt.Printf("Shard #%d → Root: %s | Blocks: %d\n", shard.ID, shard.GetRoot(), len(shard.Blocks))
+		view := shard.View()
+		state.Shards = append(state.Shards, ShardSummary{
+			ID:         view.ID,
+			Root:       view.Root,
+			BlockCount: view.BlockCount,
+			StateRoot:  view.StateRoot,
+		})
 	}
-	// Print Red-Black Tree structure
-	sm.Shards.PrintTree()
+	return state
 }
 
-// MergeShards merges underutilized shards
-func (sm *ShardManager) MergeShards(threshold int) {
-	currentShards := sm.Shards.GetAllShards()
-	newTree := NewRBTree()
+// PrintShardState writes Merkle roots and shard info to w
+func (sm *ShardManager) PrintShardState(w io.Writer) {
+	state := sm.State()
+	fmt.Fprintln(w, "\n==== Shard Merkle Forest ====")
+	for _, shard := range state.Shards {
+		fmt.Fprintf(w, "Shard #%d → Root: %s | Blocks: %d\n", shard.ID, shard.Root, shard.BlockCount)
+	}
+	fmt.Fprintf(w, "Forest root: %s\n", state.ForestRoot)
+	stats := state.Stats
+	fmt.Fprintf(w, "Shards: %d | Height: %d | Black height: %d | IDs: %d-%d | Total blocks: %d\n",
+		stats.Size, stats.Height, stats.BlackHeight, stats.MinShardID, stats.MaxShardID, stats.TotalBlocks)
+	writeShardIndex(w, state.Index)
+}
+
+// MergeSmallShards merges shards holding fewer blocks than the minimum threshold
+func (sm *ShardManager) MergeSmallShards() error {
+	sm.mutex.Lock()
+	threshold := sm.minBlocks
+	sm.mutex.Unlock()
+	return sm.MergeShards(threshold)
+}
+
+// MergeShards merges underutilized shards. If any pair's account states
+// cannot be combined, no shard is merged and the error is returned.
+func (sm *ShardManager) MergeShards(threshold int) error {
+	sm.mutex.Lock()
+	proofs, err := sm.mergeShards(threshold)
+	if err != nil {
+		sm.mutex.Unlock()
+		return err
+	}
+	sm.recordForestRoot()
+	sm.mutex.Unlock()
+	sm.emitMerges(proofs)
+	if sm.collectEmpty {
+		sm.CollectEmptyShards()
+	}
+	sm.verifyIfStrict()
+	return nil
+}
+
+// mergeShards merges adjacent shards below threshold, journals a proof of
+// each merge and returns the proofs; the caller must hold the manager lock.
+// Merged shards are built aside, so on error the layout is unchanged.
+func (sm *ShardManager) mergeShards(threshold int) ([]MergeProof, error) {
+	currentShards := sm.shardsInOrder()
+	newTree := NewShardIndex()
 	used := make(map[int]bool)
+	var proofs []MergeProof
+	var absorbed [][2]int // Affinity moves, made once every merge succeeded
 
 	for i := 0; i < len(currentShards); i++ {
 		if used[i] {
@@ -139,21 +842,34 @@ func (sm *ShardManager) MergeShards(threshold int) {
 			next := currentShards[i+1]
 
 			// Merge blocks
-			merged := NewShard(current.ID)
+			merged := sm.newShard(current.ID)
 			merged.Blocks = append(current.Blocks, next.Blocks...)
 
 			// Rebuild Merkle tree
-			var data []string
-			for _, b := range merged.Blocks {
-				data = append(data, b.Data)
+			merged.rebuild()
+
+			// Combine account state and ownership
+			merged.State = current.State.Clone()
+			if err := merged.State.Merge(next.State); err != nil {
+				return nil, fmt.Errorf("merge shard #%d into #%d: %w", next.ID, current.ID, err)
+			}
+			for hash, receipt := range current.Receipts {
+				merged.Receipts[hash] = receipt
+			}
+			for hash, receipt := range next.Receipts {
+				merged.Receipts[hash] = receipt
+			}
+			merged.AddrLow, merged.AddrHigh = mergeRanges(current, next)
+			merged.RangeStart, merged.RangeEnd = mergeHashRanges(current, next)
+			if merged.StateManager != nil {
+				merged.StateManager = rebuildStateManager(sm.stateActive, merged.Blocks, current.StateManager, next.StateManager)
 			}
-			merged.Tree = NewMerkleTree(data)
 
+			absorbed = append(absorbed, [2]int{next.ID, current.ID})
 			newTree.Insert(merged)
+			proofs = append(proofs, NewMergeProof(current, next, merged))
 			used[i] = true
 			used[i+1] = true
-
-			fmt.Printf("[MERGE] Shard #%d and Shard #%d merged into Shard #%d\n", current.ID, next.ID, current.ID)
 		} else {
 			// Keep the shard as-is
 			newTree.Insert(current)
@@ -161,19 +877,207 @@ func (sm *ShardManager) MergeShards(threshold int) {
 		}
 	}
 
+	for _, ids := range absorbed {
+		sm.mergeAffinity(ids[0], ids[1])
+		fmt.Printf("[MERGE] Shard #%d and Shard #%d merged into Shard #%d\n", ids[1], ids[0], ids[1])
+	}
 	sm.Shards = newTree
+	sm.indexRanges()
+	sm.recordMerges(proofs)
+	return proofs, nil
+}
+
+// mergeRanges returns the address range covering two shards' partitions
+func mergeRanges(a, b *Shard) (int, int) {
+	if a.AddrLow > a.AddrHigh {
+		return b.AddrLow, b.AddrHigh
+	}
+	if b.AddrLow > b.AddrHigh {
+		return a.AddrLow, a.AddrHigh
+	}
+	low, high := a.AddrLow, a.AddrHigh
+	if b.AddrLow < low {
+		low = b.AddrLow
+	}
+	if b.AddrHigh > high {
+		high = b.AddrHigh
+	}
+	return low, high
+}
+
+// PartitionAddressSpace splits the first-byte address space evenly across
+// the current shards in ID order. Balances are not moved, so partition
+// before funding accounts.
+func (sm *ShardManager) PartitionAddressSpace() {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	shards := sm.Shards.GetAllShards()
+	for i, shard := range shards {
+		shard.SetAddressRange(i*256/len(shards), (i+1)*256/len(shards)-1)
+	}
+}
+
+// ShardForAddress returns the shard owning an account
+func (sm *ShardManager) ShardForAddress(addr string) (*Shard, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.shardForAddress(addr)
+}
+
+// shardForAddress finds the lowest-ID owner; the caller must hold the manager lock
+func (sm *ShardManager) shardForAddress(addr string) (*Shard, bool) {
+	for _, shard := range sm.Shards.GetAllShards() {
+		shard.mutex.Lock()
+		owns := shard.OwnsAddress(addr)
+		shard.mutex.Unlock()
+		if owns {
+			return shard, true
+		}
+	}
+	return nil, false
+}
+
+// FindShard retrieves a shard by ID in O(log n) time, or returns
+// ErrShardNotFound
+func (sm *ShardManager) FindShard(id int) (*Shard, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	shard, exists := sm.Shards.FindShard(id)
+	if !exists {
+		return nil, fmt.Errorf("%w: #%d", ErrShardNotFound, id)
+	}
+	return shard, nil
+}
+
+// GetShardView returns a read-only snapshot of a shard
+func (sm *ShardManager) GetShardView(id int) (ShardView, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	shard, exists := sm.Shards.FindShard(id)
+	if !exists {
+		return ShardView{}, false
+	}
+	return shard.View(), true
+}
+
+// GetAllShardViews returns read-only snapshots of every shard in ID order.
+// The manager lock is held throughout, so views never straddle a rebalance.
+func (sm *ShardManager) GetAllShardViews() []ShardView {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	var views []ShardView
+	for _, shard := range sm.Shards.GetAllShards() {
+		views = append(views, shard.View())
+	}
+	return views
+}
+
+// GetShardState returns the StateManager attached to a shard
+func (sm *ShardManager) GetShardState(id int) (*StateManager, bool) {
+	shard, err := sm.FindShard(id)
+	if err != nil {
+		return nil, false
+	}
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return shard.StateManager, shard.StateManager != nil
+}
+
+// GlobalStateRoot Merkle-izes the active root of every shard's StateManager
+// in shard-ID order; shards without one are skipped
+func (sm *ShardManager) GlobalStateRoot() string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	var roots []string
+	for _, shard := range sm.Shards.GetAllShards() {
+		shard.mutex.Lock()
+		if shard.StateManager != nil {
+			roots = append(roots, shard.StateManager.GetActiveRoot())
+		}
+		shard.mutex.Unlock()
+	}
+	return NewMerkleTree(roots).GetRootHash()
+}
+
+// TransferBlock moves a block between shards through an authenticated
+// two-phase commit. Callers outside the manager should use this rather than
+// mutating shards directly. tokens are passed on to the EnhancedSyncManager.
+func (sm *ShardManager) TransferBlock(esm *EnhancedSyncManager, sourceID, destID, blockIndex int, tokens ...Token) error {
+	if err := sm.transferBlock(esm, sourceID, destID, blockIndex, tokens); err != nil {
+		return err
+	}
+	sm.mutex.RLock()
+	sm.recordForestRoot()
+	sm.mutex.RUnlock()
+	if sm.collectEmpty {
+		sm.CollectEmptyShards()
+	}
+	return nil
+}
+
+// transferBlock implements TransferBlock under the manager's read lock
+func (sm *ShardManager) transferBlock(esm *EnhancedSyncManager, sourceID, destID, blockIndex int, tokens []Token) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	source, exists := sm.Shards.FindShard(sourceID)
+	if !exists {
+		return fmt.Errorf("%w: source #%d", ErrShardNotFound, sourceID)
+	}
+	destination, exists := sm.Shards.FindShard(destID)
+	if !exists {
+		return fmt.Errorf("%w: destination #%d", ErrShardNotFound, destID)
+	}
+	if err := esm.CreateAuthenticatedTransfer(source, destination, blockIndex, tokens...); err != nil {
+		return err
+	}
+	return esm.VerifyAndApplyTransfer(source, destination, blockIndex)
 }
 
-// FindShard retrieves a shard by ID in O(log n) time
-func (sm *ShardManager) FindShard(id int) (*Shard, bool) {
-	return sm.Shards.FindShard(id)
+// TransferValue moves funds between the shards owning the sender and the
+// recipient, applying the transaction locally when both share a shard.
+// tokens are passed on to the EnhancedSyncManager.
+func (sm *ShardManager) TransferValue(esm *EnhancedSyncManager, tx Transaction, tokens ...Token) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	source, exists := sm.shardForAddress(tx.From)
+	if !exists {
+		return fmt.Errorf("%w: no shard owns sender %s", ErrAddressNotOwned, tx.From)
+	}
+	destination, exists := sm.shardForAddress(tx.To)
+	if !exists {
+		return fmt.Errorf("%w: no shard owns recipient %s", ErrAddressNotOwned, tx.To)
+	}
+	if source == destination {
+		source.mutex.Lock()
+		defer source.mutex.Unlock()
+		return source.State.ApplyTransaction(tx)
+	}
+	return esm.TransferValue(source, destination, tx, tokens...)
 }
 
-// ReconstructState returns the Merkle root of a shard for state verification
+// ReconstructState recomputes a shard's Merkle root and chain commitment
+// from its blocks and returns the root for state verification. It reports
+// false if the shard does not exist or its blocks no longer match either
+// commitment, e.g. after being reordered or substituted in place. The view
+// is taken under the manager lock, and rebalancing never changes shards in
+// place, so it is safe to call while shards are being rebalanced.
 func (sm *ShardManager) ReconstructState(shardID int) (string, bool) {
-	shard, exists := sm.FindShard(shardID)
+	view, exists := sm.GetShardView(shardID)
 	if !exists {
 		return "", false
 	}
-	return shard.GetRoot(), true
+	root := newBlockTree(view.Blocks).GetRootHash()
+	if view.BlockCount > 0 && root != view.Root {
+		return "", false
+	}
+	if chainCommitment(view.Blocks) != view.ChainCommitment {
+		return "", false
+	}
+	return view.Root, true
 }
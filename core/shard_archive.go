@@ -0,0 +1,266 @@
+package core
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned by shard archiving
+var (
+	// ErrInvalidShardArchive is returned when a shard archive is incomplete,
+	// badly signed or does not match its manifest
+	ErrInvalidShardArchive = errors.New("invalid shard archive")
+	// ErrNoArchiveKey is returned when exporting without WithShardArchiveKey
+	ErrNoArchiveKey = errors.New("no shard archive key configured")
+)
+
+// ShardArchiveFormat is the version of the layout ExportShardArchive writes
+const ShardArchiveFormat = 1
+
+// MaxShardArchiveMemberBytes bounds each member ImportShardArchive reads,
+// above the largest block data allowed plus its transactions and header
+const MaxShardArchiveMemberBytes = 64 << 20
+
+// Members of a shard archive. Blocks are stored one per member, named by
+// position in the shard.
+const (
+	archiveManifestName  = "manifest.json"
+	archiveSignatureName = "manifest.sig"
+	archiveBlockName     = "blocks/%08d.json"
+)
+
+// ShardArchiveManifest describes a shard archive and is what its signature
+// covers. It comes first in the archive, so a truncated archive is caught
+// by the blocks it lists but does not hold.
+type ShardArchiveManifest struct {
+	Format      int      `json:"format"`
+	ShardID     int      `json:"shard_id"`
+	BlockHashes []string `json:"block_hashes"` // In shard order
+	Root        string   `json:"root"`
+	Accumulator string   `json:"accumulator,omitempty"` // Hex accumulator state, empty when disabled
+	AddrLow     int      `json:"addr_low"`
+	AddrHigh    int      `json:"addr_high"`
+	RangeStart  string   `json:"range_start,omitempty"`
+	RangeEnd    string   `json:"range_end,omitempty"`
+}
+
+// WithShardArchiveKey signs shard archives with key. The key must outlive
+// the process for its archives to be restorable, so none is generated.
+func WithShardArchiveKey(key ed25519.PrivateKey) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.archiveKey = key
+	}
+}
+
+// ShardArchivePublicKey returns the key shard archives are signed with, or
+// nil when no archive key is configured
+func (sm *ShardManager) ShardArchivePublicKey() ed25519.PublicKey {
+	if len(sm.archiveKey) != ed25519.PrivateKeySize {
+		return nil
+	}
+	return sm.archiveKey.Public().(ed25519.PublicKey)
+}
+
+// ExportShardArchive writes a shard as a tar archive for backup: a manifest
+// listing its block hashes, root and accumulator state, an ed25519
+// signature over the manifest, and each full block as JSON. It fails with
+// ErrNoArchiveKey unless the manager has WithShardArchiveKey.
+func (sm *ShardManager) ExportShardArchive(shardID int, w io.Writer) error {
+	key := sm.archiveKey
+	if len(key) != ed25519.PrivateKeySize {
+		return ErrNoArchiveKey
+	}
+	shard, err := sm.FindShard(shardID)
+	if err != nil {
+		return err
+	}
+
+	shard.mutex.Lock()
+	manifest := ShardArchiveManifest{
+		Format:      ShardArchiveFormat,
+		ShardID:     shard.ID,
+		BlockHashes: make([]string, len(shard.Blocks)),
+		Root:        shard.GetRoot(),
+		AddrLow:     shard.AddrLow,
+		AddrHigh:    shard.AddrHigh,
+		RangeStart:  shard.RangeStart,
+		RangeEnd:    shard.RangeEnd,
+	}
+	if shard.Accumulator != nil {
+		manifest.Accumulator = shard.Accumulator.State.Text(16)
+	}
+	blocks := make([]Block, len(shard.Blocks))
+	for i, b := range shard.Blocks {
+		manifest.BlockHashes[i] = b.Hash
+		if blocks[i], err = shard.fullBlock(i); err != nil {
+			shard.mutex.Unlock()
+			return err
+		}
+	}
+	shard.mutex.Unlock()
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := writeArchiveMember(tw, archiveManifestName, data); err != nil {
+		return err
+	}
+	signature := hex.EncodeToString(ed25519.Sign(key, data))
+	if err := writeArchiveMember(tw, archiveSignatureName, []byte(signature)); err != nil {
+		return err
+	}
+	for i, b := range blocks {
+		data, err := json.Marshal(b)
+		if err != nil {
+			return fmt.Errorf("encoding block #%d: %w", b.Index, err)
+		}
+		if err := writeArchiveMember(tw, fmt.Sprintf(archiveBlockName, i), data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeArchiveMember adds one file to a tar archive
+func writeArchiveMember(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportShardArchive reads an archive written by ExportShardArchive and
+// returns the shard it holds, configured with the manager's options but not
+// added to the forest; account balances are not archived. The manifest
+// signature must verify against pub, the key trusted to have signed the
+// backup, every block must hash to the manifest's list in order, and the
+// rebuilt root and accumulator state must match the manifest; otherwise
+// nothing is returned. No member may exceed MaxShardArchiveMemberBytes.
+func (sm *ShardManager) ImportShardArchive(r io.Reader, pub ed25519.PublicKey) (*Shard, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: no trusted public key", ErrInvalidShardArchive)
+	}
+
+	var manifestData, signature []byte
+	blocks := make(map[int][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidShardArchive, err)
+		}
+		if hdr.Size > MaxShardArchiveMemberBytes {
+			return nil, fmt.Errorf("%w: %s is %d bytes", ErrInvalidShardArchive, hdr.Name, hdr.Size)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, MaxShardArchiveMemberBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading %s: %w", ErrInvalidShardArchive, hdr.Name, err)
+		}
+		if len(data) > MaxShardArchiveMemberBytes {
+			return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrInvalidShardArchive, hdr.Name, MaxShardArchiveMemberBytes)
+		}
+		var position int
+		switch {
+		case hdr.Name == archiveManifestName && manifestData == nil:
+			manifestData = data
+		case hdr.Name == archiveSignatureName && signature == nil:
+			signature = data
+		case parseArchiveBlockName(hdr.Name, &position) && blocks[position] == nil:
+			blocks[position] = data
+		default:
+			return nil, fmt.Errorf("%w: unexpected member %s", ErrInvalidShardArchive, hdr.Name)
+		}
+	}
+
+	if manifestData == nil || signature == nil {
+		return nil, fmt.Errorf("%w: missing manifest or signature", ErrInvalidShardArchive)
+	}
+	sig, err := hex.DecodeString(string(signature))
+	if err != nil || !ed25519.Verify(pub, manifestData, sig) {
+		return nil, fmt.Errorf("%w: bad manifest signature", ErrInvalidShardArchive)
+	}
+	var manifest ShardArchiveManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: manifest: %w", ErrInvalidShardArchive, err)
+	}
+	if manifest.Format != ShardArchiveFormat {
+		return nil, fmt.Errorf("%w: format %d, expected %d", ErrInvalidShardArchive, manifest.Format, ShardArchiveFormat)
+	}
+	if len(blocks) != len(manifest.BlockHashes) {
+		return nil, fmt.Errorf("%w: %d blocks, manifest lists %d", ErrInvalidShardArchive, len(blocks), len(manifest.BlockHashes))
+	}
+
+	decoded := make([]Block, len(manifest.BlockHashes))
+	for i, hash := range manifest.BlockHashes {
+		data, exists := blocks[i]
+		if !exists {
+			return nil, fmt.Errorf("%w: block %d of %d missing", ErrInvalidShardArchive, i, len(manifest.BlockHashes))
+		}
+		var b Block
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("%w: block %d: %w", ErrInvalidShardArchive, i, err)
+		}
+		if b.Hash != hash || b.ComputeHash() != hash {
+			return nil, fmt.Errorf("%w: block %d does not hash to %s", ErrInvalidShardArchive, i, hash)
+		}
+		if b.TxRoot != "" && b.TxRoot != TransactionRoot(b.Transactions) {
+			return nil, fmt.Errorf("%w: block #%d transactions do not match its TxRoot", ErrInvalidShardArchive, b.Index)
+		}
+		if err := checkTxBloom(b); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidShardArchive, err)
+		}
+		if err := checkMutationRoot(b); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidShardArchive, err)
+		}
+		decoded[i] = b
+	}
+
+	// Check the root and accumulator before building the shard, which may
+	// write bodies to the manager's body store
+	root := ""
+	if len(decoded) > 0 {
+		root = newBlockTree(decoded).GetRootHash()
+	}
+	if root != manifest.Root {
+		return nil, fmt.Errorf("%w: rebuilt root %s, manifest has %s", ErrInvalidShardArchive, root, manifest.Root)
+	}
+	if manifest.Accumulator != "" {
+		acc := NewRSAAccumulator()
+		for _, b := range decoded {
+			acc.AddElement(b.Hash)
+		}
+		if acc.State.Text(16) != manifest.Accumulator {
+			return nil, fmt.Errorf("%w: accumulator state does not match the manifest", ErrInvalidShardArchive)
+		}
+	}
+
+	shard := sm.newShard(manifest.ShardID)
+	shard.AddrLow, shard.AddrHigh = manifest.AddrLow, manifest.AddrHigh
+	shard.RangeStart, shard.RangeEnd = manifest.RangeStart, manifest.RangeEnd
+	for _, b := range decoded {
+		shard.AddBlock(b)
+	}
+	return shard, nil
+}
+
+// parseArchiveBlockName reads the position from a block member name,
+// accepting only names ExportShardArchive writes
+func parseArchiveBlockName(name string, position *int) bool {
+	if _, err := fmt.Sscanf(name, archiveBlockName, position); err != nil {
+		return false
+	}
+	return *position >= 0 && name == fmt.Sprintf(archiveBlockName, *position)
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// archivedShard returns a manager with accumulators and an archive key,
+// holding ten blocks, the first five with transactions, and its first shard
+func archivedShard(t *testing.T) (*ShardManager, *Shard) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := NewShardManager(WithShardAccumulators(), WithShardArchiveKey(key))
+	if err := sm.SetThresholds(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	_, blocks := bloomChain(t, 5)
+	for _, b := range append(blocks, fixedBlocks(5)...) {
+		sm.DistributeBlock(b)
+	}
+	return sm, sm.Shards.GetAllShards()[0]
+}
+
+// rewriteArchive copies a tar archive, passing each member through edit;
+// a nil result drops the member
+func rewriteArchive(t *testing.T, archive []byte, edit func(name string, data []byte) []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	tr, tw := tar.NewReader(bytes.NewReader(archive)), tar.NewWriter(&out)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if data = edit(hdr.Name, data); data == nil {
+			continue
+		}
+		if err := writeArchiveMember(tw, hdr.Name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestShardArchiveRoundTrip(t *testing.T) {
+	sm, shard := archivedShard(t)
+	var archive bytes.Buffer
+	if err := sm.ExportShardArchive(shard.ID, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// A manager elsewhere restores the shard given only the public key
+	restorer := NewShardManager(WithShardAccumulators())
+	restored, err := restorer.ImportShardArchive(bytes.NewReader(archive.Bytes()), sm.ShardArchivePublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != shard.ID || restored.GetRoot() != shard.GetRoot() || len(restored.Blocks) != len(shard.Blocks) {
+		t.Fatalf("restored shard #%d with root %s and %d blocks", restored.ID, restored.GetRoot(), len(restored.Blocks))
+	}
+	if restored.Accumulator.State.Cmp(shard.Accumulator.State) != 0 {
+		t.Fatal("accumulator state not restored")
+	}
+	if restored.AddrLow != shard.AddrLow || restored.AddrHigh != shard.AddrHigh {
+		t.Fatal("address range not restored")
+	}
+	for i, b := range restored.Blocks {
+		if b.Hash != shard.Blocks[i].Hash || b.TxBloom != shard.Blocks[i].TxBloom || len(b.Transactions) != len(shard.Blocks[i].Transactions) {
+			t.Fatalf("block %d restored as %+v", i, b)
+		}
+	}
+
+	// Archives need a key that survives the process, so none is made up
+	keyless := NewShardManager()
+	keyless.DistributeBlock(fixedBlocks(1)[0])
+	if err := keyless.ExportShardArchive(keyless.Shards.GetAllShards()[0].ID, io.Discard); !errors.Is(err, ErrNoArchiveKey) {
+		t.Fatalf("export without a key: %v", err)
+	}
+	if keyless.ShardArchivePublicKey() != nil {
+		t.Fatal("public key without an archive key")
+	}
+	if _, err := restorer.ImportShardArchive(bytes.NewReader(archive.Bytes()), nil); !errors.Is(err, ErrInvalidShardArchive) {
+		t.Fatalf("import without a trusted key: %v", err)
+	}
+}
+
+func TestShardArchiveRejectsTampering(t *testing.T) {
+	sm, shard := archivedShard(t)
+	var buf bytes.Buffer
+	if err := sm.ExportShardArchive(shard.ID, &buf); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+	other, _ := archivedShard(t)
+	block := fmt.Sprintf(archiveBlockName, 2)
+	var manifest []byte
+
+	for name, tampered := range map[string][]byte{
+		"block data": rewriteArchive(t, archive, func(name string, data []byte) []byte {
+			if name == block {
+				var b Block
+				json.Unmarshal(data, &b)
+				b.Transactions[0].Amount++
+				data, _ = json.Marshal(b)
+			}
+			return data
+		}),
+		"manifest": rewriteArchive(t, archive, func(name string, data []byte) []byte {
+			if name == archiveManifestName {
+				return bytes.Replace(data, []byte(`"shard_id":`), []byte(`"shard_id":9`), 1)
+			}
+			return data
+		}),
+		"dropped block": rewriteArchive(t, archive, func(name string, data []byte) []byte {
+			if name == block {
+				return nil
+			}
+			return data
+		}),
+		"truncated": archive[:len(archive)/2],
+		"empty":     nil,
+		"not a tar": []byte(strings.Repeat("x", 1024)),
+		"signature": rewriteArchive(t, archive, func(name string, data []byte) []byte {
+			// The manifest comes first, so it can be signed by another key
+			if name == archiveManifestName {
+				manifest = data
+			} else if name == archiveSignatureName {
+				return []byte(hex.EncodeToString(ed25519.Sign(other.archiveKey, manifest)))
+			}
+			return data
+		}),
+		"no members": rewriteArchive(t, archive, func(string, []byte) []byte { return nil }),
+	} {
+		if _, err := sm.ImportShardArchive(bytes.NewReader(tampered), sm.ShardArchivePublicKey()); !errors.Is(err, ErrInvalidShardArchive) {
+			t.Errorf("%s tampered: %v", name, err)
+		}
+	}
+
+	// An untouched archive is refused under any key but the signer's
+	if _, err := sm.ImportShardArchive(bytes.NewReader(archive), other.ShardArchivePublicKey()); !errors.Is(err, ErrInvalidShardArchive) {
+		t.Fatalf("archive verified against another key: %v", err)
+	}
+
+	// Members are refused by their declared size before being read
+	var huge bytes.Buffer
+	tw := tar.NewWriter(&huge)
+	tw.WriteHeader(&tar.Header{Name: archiveManifestName, Mode: 0o644, Size: MaxShardArchiveMemberBytes + 1})
+	_, err := sm.ImportShardArchive(io.MultiReader(bytes.NewReader(huge.Bytes()), zeros{}), sm.ShardArchivePublicKey())
+	if !errors.Is(err, ErrInvalidShardArchive) || !strings.Contains(err.Error(), "bytes") {
+		t.Fatalf("oversized member: %v", err)
+	}
+}
+
+// zeros is an endless reader of zero bytes
+type zeros struct{}
+
+// Read implements io.Reader
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
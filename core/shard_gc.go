@@ -0,0 +1,114 @@
+package core
+
+// ShardEventType names a change to the set of shards
+type ShardEventType string
+
+const (
+	ShardRemoved            ShardEventType = "shard_removed"
+	ShardVerificationFailed ShardEventType = "shard_verification_failed" // See WithStrictMode
+	ShardMerged             ShardEventType = "shard_merged"
+	ShardRootCertified      ShardEventType = "shard_root_certified"   // See RootCertifier
+	ShardRebalanceFailed    ShardEventType = "shard_rebalance_failed" // See LastRebalanceError
+)
+
+// ShardEvent reports a change to the set of shards
+type ShardEvent struct {
+	Type    ShardEventType `json:"type"`
+	ShardID int            `json:"shard_id"`
+	Error   string         `json:"error,omitempty"`  // What failed, for ShardVerificationFailed and ShardRebalanceFailed
+	Merge   *MergeProof    `json:"merge,omitempty"`  // Proof of the merge, for ShardMerged
+	Header  *ShardHeader   `json:"header,omitempty"` // Certified root, for ShardRootCertified
+}
+
+// WithShardEvents reports shard events to fn. It is called without the
+// manager lock held, so it may query the manager.
+func WithShardEvents(fn func(ShardEvent)) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.onEvent = fn
+	}
+}
+
+// WithEmptyShardCollection runs CollectEmptyShards after every merge and
+// every successful TransferBlock
+func WithEmptyShardCollection() ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.collectEmpty = true
+	}
+}
+
+// CollectEmptyShards removes shards holding no blocks, balances or receipts
+// from the index and returns their IDs. The last remaining shard is never
+// removed. A removed shard's address range, and hash range under
+// HashRangeSplit, passes to the nearest remaining shard below it, or above
+// it if there is none, so every address keeps an owner. Shards are ordered
+// by hash range under HashRangeSplit and by ID otherwise.
+func (sm *ShardManager) CollectEmptyShards() []int {
+	sm.mutex.Lock()
+	removed := sm.collectEmptyShards()
+	sm.recordForestRoot()
+	sm.mutex.Unlock()
+
+	if sm.onEvent != nil {
+		for _, id := range removed {
+			sm.onEvent(ShardEvent{Type: ShardRemoved, ShardID: id})
+		}
+	}
+	return removed
+}
+
+// collectEmptyShards implements CollectEmptyShards; the caller must hold
+// the manager lock
+func (sm *ShardManager) collectEmptyShards() []int {
+	shards := sm.shardsInOrder()
+	empty := make([]bool, len(shards))
+	remaining := len(shards)
+	for i, shard := range shards {
+		shard.mutex.Lock()
+		empty[i] = len(shard.Blocks) == 0 && shard.State.TotalSupply() == 0 && len(shard.Receipts) == 0 &&
+			len(shard.State.RedeemedReceipts()) == 0
+		shard.mutex.Unlock()
+		if empty[i] {
+			remaining--
+		}
+	}
+	if remaining == 0 {
+		// Keep the lowest shard so the manager always has one
+		empty[0] = false
+	}
+
+	var removed []int
+	for i, shard := range shards {
+		if !empty[i] {
+			continue
+		}
+		if heir := emptyShardHeir(shards, empty, i); heir != nil {
+			shard.mutex.Lock()
+			heir.mutex.Lock()
+			heir.AddrLow, heir.AddrHigh = mergeRanges(heir, shard)
+			heir.RangeStart, heir.RangeEnd = mergeHashRanges(heir, shard)
+			heir.mutex.Unlock()
+			shard.mutex.Unlock()
+		}
+		sm.Shards.Delete(shard.ID)
+		delete(sm.assignments, shard.ID)
+		removed = append(removed, shard.ID)
+	}
+	sm.indexRanges()
+	return removed
+}
+
+// emptyShardHeir picks the remaining shard nearest below shards[i], or
+// nearest above it if there is none
+func emptyShardHeir(shards []*Shard, empty []bool, i int) *Shard {
+	for j := i - 1; j >= 0; j-- {
+		if !empty[j] {
+			return shards[j]
+		}
+	}
+	for j := i + 1; j < len(shards); j++ {
+		if !empty[j] {
+			return shards[j]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// eventRecorder collects shard events
+type eventRecorder struct {
+	events []ShardEvent
+}
+
+func (r *eventRecorder) record(e ShardEvent) { r.events = append(r.events, e) }
+
+// removedShards lists the IDs of ShardRemoved events in order
+func (r *eventRecorder) removedShards() []int {
+	var ids []int
+	for _, e := range r.events {
+		if e.Type == ShardRemoved {
+			ids = append(ids, e.ShardID)
+		}
+	}
+	return ids
+}
+
+// gcSetup distributes a short chain over a manager with the given options,
+// recording its events
+func gcSetup(t *testing.T, opts ...ShardManagerOption) (*ShardManager, *eventRecorder) {
+	t.Helper()
+	events := &eventRecorder{}
+	sm := NewShardManager(append([]ShardManagerOption{WithShardEvents(events.record)}, opts...)...)
+	bc := NewBlockchain()
+	for i := 0; i < 6; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks {
+		sm.DistributeBlock(b)
+	}
+	if sm.Shards.Size() < 2 {
+		t.Fatalf("chain distributed over %d shards, want at least 2", sm.Shards.Size())
+	}
+	return sm, events
+}
+
+// drainShard transfers every block of source to dest
+func drainShard(t *testing.T, sm *ShardManager, source, dest *Shard) {
+	t.Helper()
+	esm := NewEnhancedSyncManager("key")
+	for len(source.View().Blocks) > 0 {
+		if err := sm.TransferBlock(esm, source.ID, dest.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDrainedShardCollected(t *testing.T) {
+	sm, events := gcSetup(t)
+	shards := sm.Shards.GetAllShards()
+	source, dest := shards[0], shards[1]
+	size := sm.Shards.Size()
+	drainShard(t, sm, source, dest)
+
+	// Without the option the empty shard stays until collected
+	if _, err := sm.FindShard(source.ID); err != nil {
+		t.Fatalf("drained shard removed without collection: %v", err)
+	}
+	before := sm.ForestRoot()
+	if removed := sm.CollectEmptyShards(); len(removed) != 1 || removed[0] != source.ID {
+		t.Fatalf("collected %v", removed)
+	}
+	if _, err := sm.FindShard(source.ID); !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("collected shard still found: %v", err)
+	}
+	if sm.Shards.Size() != size-1 || len(sm.ShardAssignments()) != size-1 {
+		t.Fatalf("%d shards after collection", sm.Shards.Size())
+	}
+	if sm.ForestRoot() == before {
+		t.Fatal("forest root unchanged by collection")
+	}
+	if ids := events.removedShards(); len(ids) != 1 || ids[0] != source.ID {
+		t.Fatalf("removal events for %v", ids)
+	}
+
+	// Every address still has an owner
+	for b := 0; b < 256; b++ {
+		if _, ok := sm.ShardForAddress(string(rune(b))); !ok {
+			t.Fatalf("address byte %d lost its shard", b)
+		}
+	}
+	if removed := sm.CollectEmptyShards(); len(removed) != 0 {
+		t.Fatalf("second collection removed %v", removed)
+	}
+}
+
+func TestTransfersCollectEmptyShardsAutomatically(t *testing.T) {
+	sm, events := gcSetup(t, WithEmptyShardCollection())
+	shards := sm.Shards.GetAllShards()
+	source, dest := shards[0], shards[1]
+	drainShard(t, sm, source, dest)
+
+	if _, err := sm.FindShard(source.ID); !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("drained shard still found: %v", err)
+	}
+	if ids := events.removedShards(); len(ids) != 1 || ids[0] != source.ID {
+		t.Fatalf("removal events for %v", ids)
+	}
+	if view, ok := sm.GetShardView(dest.ID); !ok || view.BlockCount == 0 {
+		t.Fatalf("destination shard %+v", view)
+	}
+}
+
+func TestCollectionKeepsLastShard(t *testing.T) {
+	sm, events := gcSetup(t)
+	shards := sm.shardsInOrder()
+	for _, shard := range shards {
+		shard.mutex.Lock()
+		shard.Blocks = nil
+		shard.rebuild()
+		shard.mutex.Unlock()
+	}
+
+	removed := sm.CollectEmptyShards()
+	if len(removed) != len(shards)-1 || sm.Shards.Size() != 1 {
+		t.Fatalf("removed %v, %d shards left", removed, sm.Shards.Size())
+	}
+	if _, err := sm.FindShard(shards[0].ID); err != nil {
+		t.Fatalf("lowest shard removed: %v", err)
+	}
+	if len(events.removedShards()) != len(removed) {
+		t.Fatalf("%d events for %d removals", len(events.removedShards()), len(removed))
+	}
+	if shards[0].AddrLow != 0 || shards[0].AddrHigh != 255 {
+		t.Fatalf("last shard owns %d..%d", shards[0].AddrLow, shards[0].AddrHigh)
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// ShardIndex is the Red-Black Tree of shards keyed by shard ID
+type ShardIndex struct {
+	tree    *RBTree[int, *Shard]
+	changes uint64 // Bumped atomically when shards are inserted or deleted or their blocks change
+}
+
+// RBTreeStats summarizes the shard index
+type RBTreeStats struct {
+	Size        int `json:"size"`
+	Height      int `json:"height"`
+	BlackHeight int `json:"black_height"`
+	MinShardID  int `json:"min_shard_id"` // Zero when the index is empty
+	MaxShardID  int `json:"max_shard_id"`
+	TotalBlocks int `json:"total_blocks"`
+}
+
+// ShardIndexEntry is one node of the shard index, listed in pre-order
+type ShardIndexEntry struct {
+	ShardID    int    `json:"shard_id"`
+	Color      string `json:"color"` // "Red" or "Black"
+	Depth      int    `json:"depth"` // Zero at the root
+	BlockCount int    `json:"block_count"`
+	Root       string `json:"root"`
+}
+
+// NewShardIndex creates an empty shard index
+func NewShardIndex() *ShardIndex {
+	return &ShardIndex{tree: NewRBTree[int, *Shard]()}
+}
+
+// Insert adds a shard, replacing any shard with the same ID
+func (si *ShardIndex) Insert(shard *Shard) {
+	shard.watch.Store(&si.changes)
+	si.tree.Insert(shard.ID, shard)
+	atomic.AddUint64(&si.changes, 1)
+}
+
+// Delete removes a shard by ID
+func (si *ShardIndex) Delete(id int) bool {
+	defer atomic.AddUint64(&si.changes, 1)
+	return si.tree.Delete(id)
+}
+
+// changeCount returns a counter that moves whenever the index or the blocks
+// of a shard in it change
+func (si *ShardIndex) changeCount() uint64 {
+	return atomic.LoadUint64(&si.changes)
+}
+
+// FindShard retrieves a shard by ID in O(log n) time
+func (si *ShardIndex) FindShard(id int) (*Shard, bool) {
+	return si.tree.Get(id)
+}
+
+// NextID returns an ID above every shard's. Merges leave gaps in the IDs,
+// so the shard count may belong to a shard still in the index.
+func (si *ShardIndex) NextID() int {
+	if si.tree.Size() == 0 {
+		return 0
+	}
+	max, _ := si.tree.MaxKey()
+	return max + 1
+}
+
+// GetAllShards collects all shards in ID order
+func (si *ShardIndex) GetAllShards() []*Shard {
+	return si.tree.Values()
+}
+
+// Size returns the number of shards in the index
+func (si *ShardIndex) Size() int {
+	return si.tree.Size()
+}
+
+// Height returns the height of the underlying tree
+func (si *ShardIndex) Height() int {
+	return si.tree.Height()
+}
+
+// BlackHeight returns the black height of the underlying tree
+func (si *ShardIndex) BlackHeight() int {
+	return si.tree.BlackHeight()
+}
+
+// Stats reports size, balance and block totals in a single traversal
+func (si *ShardIndex) Stats() RBTreeStats {
+	stats := RBTreeStats{
+		Size:        si.tree.Size(),
+		Height:      si.tree.Height(),
+		BlackHeight: si.tree.BlackHeight(),
+	}
+	stats.MinShardID, _ = si.tree.MinKey()
+	stats.MaxShardID, _ = si.tree.MaxKey()
+	si.tree.Each(func(_ int, shard *Shard) {
+		shard.mutex.Lock()
+		stats.TotalBlocks += len(shard.Blocks)
+		shard.mutex.Unlock()
+	})
+	return stats
+}
+
+// Dump lists the tree's nodes in pre-order with their depth and color
+func (si *ShardIndex) Dump() []ShardIndexEntry {
+	var entries []ShardIndexEntry
+	si.dumpNode(si.tree.Root, 0, &entries)
+	return entries
+}
+
+func (si *ShardIndex) dumpNode(node *RBNode[int, *Shard], depth int, entries *[]ShardIndexEntry) {
+	if node == si.tree.Nil {
+		return
+	}
+	color := "Black"
+	if node.Color == Red {
+		color = "Red"
+	}
+	node.Value.mutex.Lock()
+	*entries = append(*entries, ShardIndexEntry{
+		ShardID:    node.Key,
+		Color:      color,
+		Depth:      depth,
+		BlockCount: len(node.Value.Blocks),
+		Root:       node.Value.GetRoot(),
+	})
+	node.Value.mutex.Unlock()
+	si.dumpNode(node.Left, depth+1, entries)
+	si.dumpNode(node.Right, depth+1, entries)
+}
+
+// PrintTree writes the tree structure to w (for debugging)
+func (si *ShardIndex) PrintTree(w io.Writer) {
+	writeShardIndex(w, si.Dump())
+}
+
+// writeShardIndex formats pre-order index entries as an indented tree
+func writeShardIndex(w io.Writer, entries []ShardIndexEntry) {
+	fmt.Fprintln(w, "\n--- Red-Black Tree Shard Index ---")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%sShard #%d (Color: %s, Blocks: %d, Merkle Root: %s)\n",
+			strings.Repeat("  ", e.Depth), e.ShardID, e.Color, e.BlockCount, e.Root)
+	}
+}
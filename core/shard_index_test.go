@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// maxRBHeight is the height bound a red-black tree of n keys never exceeds
+func maxRBHeight(n int) int {
+	return int(2 * math.Log2(float64(n+1)))
+}
+
+func TestShardIndexStatsAfterBulkInsert(t *testing.T) {
+	si := NewShardIndex()
+	if stats := si.Stats(); stats != (RBTreeStats{}) {
+		t.Fatalf("empty index stats %+v", stats)
+	}
+	const n = 1000
+	for i := 0; i < n; i++ {
+		shard := NewShard(i * 3)
+		for j := 0; j < i%4; j++ {
+			shard.Blocks = append(shard.Blocks, Block{Index: j})
+		}
+		si.Insert(shard)
+	}
+	si.Insert(NewShard(0)) // Replacing a shard does not grow the index
+
+	stats := si.Stats()
+	if stats.Size != n || si.Size() != n {
+		t.Fatalf("size %d (stats %d), want %d", si.Size(), stats.Size, n)
+	}
+	if stats.Height != si.Height() || stats.Height > maxRBHeight(n) || stats.Height < int(math.Log2(n)) {
+		t.Fatalf("height %d outside [%d, %d]", stats.Height, int(math.Log2(n)), maxRBHeight(n))
+	}
+	if stats.BlackHeight != si.BlackHeight() || stats.BlackHeight*2 < stats.Height {
+		t.Fatalf("black height %d for height %d", stats.BlackHeight, stats.Height)
+	}
+	if stats.MinShardID != 0 || stats.MaxShardID != (n-1)*3 {
+		t.Fatalf("shard IDs %d-%d", stats.MinShardID, stats.MaxShardID)
+	}
+	// Shard 0 was replaced by an empty one, which held no blocks anyway
+	if want := (n / 4) * (0 + 1 + 2 + 3); stats.TotalBlocks != want {
+		t.Fatalf("total blocks %d, want %d", stats.TotalBlocks, want)
+	}
+
+	for i := 0; i < n; i += 2 {
+		if !si.Delete(i * 3) {
+			t.Fatalf("shard #%d not deleted", i*3)
+		}
+	}
+	if si.Size() != n/2 || si.Height() > maxRBHeight(n/2) {
+		t.Fatalf("size %d, height %d after deleting half", si.Size(), si.Height())
+	}
+}
+
+func TestShardIndexStatsAfterRebalance(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range testLeaves(64) {
+		sm.DistributeBlock(mustGenerateBlock(t, GenesisBlock(), data))
+	}
+	if err := sm.SetThresholds(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Each pass splits every oversized shard once
+	for before := 0; before != sm.Shards.Size(); {
+		before = sm.Shards.Size()
+		if err := sm.RebalanceShards(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := sm.Shards.Stats()
+	shards := sm.Shards.GetAllShards()
+	if stats.Size != len(shards) || stats.Size < 32 {
+		t.Fatalf("stats size %d, index holds %d shards", stats.Size, len(shards))
+	}
+	if stats.TotalBlocks != 64 {
+		t.Fatalf("total blocks %d after rebalance, want 64", stats.TotalBlocks)
+	}
+	if stats.Height > maxRBHeight(stats.Size) {
+		t.Fatalf("height %d for %d shards", stats.Height, stats.Size)
+	}
+	if stats.MinShardID != shards[0].ID || stats.MaxShardID != shards[len(shards)-1].ID {
+		t.Fatal("min and max shard IDs disagree with the index")
+	}
+
+	var out bytes.Buffer
+	sm.PrintShardState(&out)
+	want := fmt.Sprintf("Shards: %d | Height: %d | Black height: %d", stats.Size, stats.Height, stats.BlackHeight)
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("shard state output lacks %q:\n%s", want, out.String())
+	}
+}
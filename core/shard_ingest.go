@@ -0,0 +1,255 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults of WithIngestBatching
+const (
+	DefaultIngestBatchSize     = 256
+	DefaultIngestFlushInterval = 20 * time.Millisecond
+)
+
+// WithIngestBatching sets how many blocks the StartIngest pipeline applies
+// at once, and how long it waits for a batch to fill before applying it
+func WithIngestBatching(size int, flushInterval time.Duration) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.ingestBatch, sm.ingestFlush = size, flushInterval
+	}
+}
+
+// ingestPipeline is the state of a running StartIngest
+type ingestPipeline struct {
+	blocks chan Block
+	jobs   chan func()
+	done   chan struct{} // Closed once every block sent has been distributed
+}
+
+// StartIngest distributes the blocks sent on the returned channel in the
+// background, for ingest rates at which rebalancing after every block
+// would dominate. The channel buffers one batch, so senders block once the
+// pipeline falls a batch behind. Blocks are taken in the order sent and
+// grouped into batches; each batch is placed exactly as DistributeBlock
+// would place its blocks one by one, the work on each target shard is
+// spread over workers, and RebalanceShards runs once per batch. The shards
+// end up holding the same blocks as with DistributeBlock, though under
+// HashRangeSplit their IDs may be numbered in a different order. Under
+// AffinityPolicy blocks are placed one by one, as DistributeBlock would.
+//
+// Close stops ingesting. The channel belongs to the manager: callers must
+// not close it or send on it after Close. While a pipeline runs,
+// StartIngest returns its channel again.
+func (sm *ShardManager) StartIngest(workers int) chan<- Block {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if sm.ingest != nil {
+		return sm.ingest.blocks
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	batchSize, flush := sm.ingestBatch, sm.ingestFlush
+	if batchSize < 1 {
+		batchSize = DefaultIngestBatchSize
+	}
+	if flush <= 0 {
+		flush = DefaultIngestFlushInterval
+	}
+
+	p := &ingestPipeline{
+		blocks: make(chan Block, batchSize),
+		jobs:   make(chan func()),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	go sm.runIngest(p, batchSize, flush)
+	sm.ingest = p
+	return p.blocks
+}
+
+// Close stops the StartIngest pipeline and returns once every block sent
+// before it has been distributed. It does nothing if no pipeline runs.
+func (sm *ShardManager) Close() {
+	sm.mutex.Lock()
+	p := sm.ingest
+	sm.ingest = nil
+	sm.mutex.Unlock()
+	if p == nil {
+		return
+	}
+	close(p.blocks)
+	<-p.done
+}
+
+// runIngest collects blocks into batches until one is full, its flush
+// interval has passed or the channel is closed
+func (sm *ShardManager) runIngest(p *ingestPipeline, batchSize int, flush time.Duration) {
+	defer close(p.done)
+	defer close(p.jobs)
+
+	var batch []Block
+	var deadline <-chan time.Time
+	for {
+		select {
+		case block, open := <-p.blocks:
+			if !open {
+				sm.distributeBatch(batch, p.jobs)
+				return
+			}
+			if len(batch) == 0 {
+				deadline = time.After(flush)
+			}
+			batch = append(batch, block)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-deadline:
+		}
+		sm.distributeBatch(batch, p.jobs)
+		batch, deadline = nil, nil
+	}
+}
+
+// distributeBatch adds a batch of blocks where DistributeBlock would, with
+// the work on each target shard done by a worker, and rebalances once
+func (sm *ShardManager) distributeBatch(batch []Block, jobs chan<- func()) {
+	if len(batch) == 0 {
+		return
+	}
+	sm.reportRebalance(sm.placeBatch(batch, jobs))
+}
+
+// placeBatch implements distributeBatch under the manager lock, returning
+// the rebalance error
+func (sm *ShardManager) placeBatch(batch []Block, jobs chan<- func()) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	for _, b := range batch {
+		if b.Index > sm.height {
+			sm.height = b.Index
+		}
+	}
+
+	var wg sync.WaitGroup
+	run := func(job func()) {
+		wg.Add(1)
+		jobs <- func() {
+			defer wg.Done()
+			job()
+		}
+	}
+	switch sm.SplitStrategy() {
+	case HashRangeSplit:
+		sm.distributeByHash(batch, run)
+	case AffinityPolicy:
+		sm.distributeByAffinity(batch)
+	default:
+		sm.distributeByMidpoint(batch, run)
+	}
+	wg.Wait()
+	err := sm.rebalanceShards()
+	sm.rebalanceErr = err
+	sm.recordForestRoot()
+	return err
+}
+
+// distributeByHash adds each block to the shard covering its hash. Ranges
+// split the same way whatever order their blocks arrive in, so splitting
+// once after the batch gives the shards DistributeBlock would.
+func (sm *ShardManager) distributeByHash(batch []Block, run func(func())) {
+	targets := make(map[*Shard][]Block)
+	for _, b := range batch {
+		home := sm.homeShard(b.Hash)
+		targets[home] = append(targets[home], b)
+	}
+	for shard, blocks := range targets {
+		shard, blocks := shard, blocks
+		run(func() {
+			shard.mutex.Lock()
+			defer shard.mutex.Unlock()
+			shard.insertByHash(blocks)
+		})
+	}
+}
+
+// distributeByAffinity places blocks one at a time, rebalancing after each,
+// since where a block goes depends on the splits before it. A failed one is
+// retried by the rebalance ending the batch.
+func (sm *ShardManager) distributeByAffinity(batch []Block) {
+	for _, b := range batch {
+		target := sm.affinityShard(b)
+		target.AddBlock(b)
+		sm.recordAffinity(b.Affinity, target.ID)
+		sm.rebalanceShards()
+	}
+}
+
+// distributeByMidpoint works out where DistributeBlock would leave each
+// block: the last shard takes blocks until it exceeds the split threshold,
+// is split in half, and the right half, as a new shard, becomes the last.
+// Each resulting shard's blocks are then added by a worker.
+func (sm *ShardManager) distributeByMidpoint(batch []Block, run func(func())) {
+	shards := sm.Shards.GetAllShards()
+	last := shards[len(shards)-1]
+	last.mutex.Lock()
+	existing := len(last.Blocks)
+	all := append(append(make([]Block, 0, existing+len(batch)), last.Blocks...), batch...)
+	oldState := last.StateManager
+	last.mutex.Unlock()
+
+	// Shard boundaries as offsets into the last shard's blocks and the batch
+	bounds := []int{0}
+	start, size := 0, existing
+	for range batch {
+		size++
+		if size > sm.maxBlocks {
+			mid := size / 2
+			start += mid
+			size -= mid
+			bounds = append(bounds, start)
+		}
+	}
+	bounds = append(bounds, len(all))
+
+	// A last shard losing blocks is split, so like in rebalanceShards it is
+	// replaced by a copy rather than truncated where readers may hold it.
+	// New shards derive their state from the last shard's, so it is settled
+	// before their jobs start.
+	keep := bounds[1]
+	last.mutex.Lock()
+	if keep < existing {
+		sm.Shards.Insert(last.withBlocks(all[:keep]))
+	} else {
+		for _, b := range all[existing:keep] {
+			last.addBlock(b)
+		}
+	}
+	last.mutex.Unlock()
+
+	// New shards own no accounts until the address space is partitioned again
+	id := sm.Shards.NextID()
+	for i := 1; i < len(bounds)-1; i++ {
+		shard := sm.newShard(id)
+		shard.AddrLow, shard.AddrHigh = 1, 0
+		blocks := all[bounds[i]:bounds[i+1]]
+		run(func() {
+			shard.mutex.Lock()
+			defer shard.mutex.Unlock()
+			for _, b := range blocks {
+				shard.addBlock(b)
+			}
+			if oldState != nil {
+				shard.StateManager = rebuildStateManager(oldState.MaxActiveCount, shard.Blocks, oldState)
+			}
+		})
+		sm.Shards.Insert(shard)
+		id++
+	}
+}
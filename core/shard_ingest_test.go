@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shardContents lists each shard's view as ID, root, chain commitment and
+// block hashes in order. Under HashRangeSplit IDs may be numbered
+// differently, so withIDs false leaves them out and sorts the list.
+func shardContents(sm *ShardManager, withIDs bool) []string {
+	var contents []string
+	for _, shard := range sm.Shards.GetAllShards() {
+		view := shard.View()
+		hashes := make([]string, len(view.Blocks))
+		for i, b := range view.Blocks {
+			hashes[i] = b.Hash
+		}
+		entry := view.Root + " " + view.ChainCommitment + " " + strings.Join(hashes, ",")
+		if withIDs {
+			entry = fmt.Sprintf("#%d %s", view.ID, entry)
+		}
+		contents = append(contents, entry)
+	}
+	if !withIDs {
+		sort.Strings(contents)
+	}
+	return contents
+}
+
+// ingestAll sends blocks through a pipeline and closes it
+func ingestAll(sm *ShardManager, workers int, blocks []Block) {
+	ch := sm.StartIngest(workers)
+	for _, b := range blocks {
+		ch <- b
+	}
+	sm.Close()
+}
+
+func TestAsyncIngestMatchesDistributeBlock(t *testing.T) {
+	blocks := fixedBlocks(600)
+	for _, strategy := range []SplitStrategy{MidpointSplit, HashRangeSplit} {
+		for _, workers := range []int{1, 4} {
+			direct := NewShardManager(WithSplitStrategy(strategy))
+			for _, b := range blocks {
+				direct.DistributeBlock(b)
+			}
+			async := NewShardManager(WithSplitStrategy(strategy), WithIngestBatching(64, time.Hour))
+			ingestAll(async, workers, blocks)
+
+			withIDs := strategy != HashRangeSplit
+			want, got := shardContents(direct, withIDs), shardContents(async, withIDs)
+			if len(got) != len(want) {
+				t.Fatalf("%s, %d workers: %d shards, want %d", strategy, workers, len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("%s, %d workers: shard %d is\n%s\nwant\n%s", strategy, workers, i, got[i], want[i])
+				}
+			}
+			if direct.height != async.height {
+				t.Fatalf("%s: height %d, want %d", strategy, async.height, direct.height)
+			}
+		}
+	}
+}
+
+func TestIngestCloseDrainsEveryBlock(t *testing.T) {
+	// A batch larger than the blocks sent and a long flush interval leave
+	// everything to Close
+	sm := NewShardManager(WithIngestBatching(10000, time.Hour))
+	ch := sm.StartIngest(3)
+	if again := sm.StartIngest(3); again != ch {
+		t.Fatal("second StartIngest opened another pipeline")
+	}
+	blocks := fixedBlocks(500)
+	for _, b := range blocks {
+		ch <- b
+	}
+	sm.Close()
+	if got := sm.Shards.Stats().TotalBlocks; got != len(blocks) {
+		t.Fatalf("%d of %d blocks distributed after Close", got, len(blocks))
+	}
+	for _, b := range blocks {
+		if _, found := sm.FindBlockShard(b.Hash); !found {
+			t.Fatalf("block #%d lost", b.Index)
+		}
+	}
+
+	// Closing again does nothing, and a new pipeline can start
+	sm.Close()
+	ingestAll(sm, 2, fixedBlocks(600)[500:])
+	if got := sm.Shards.Stats().TotalBlocks; got != 600 {
+		t.Fatalf("%d blocks after a second pipeline", got)
+	}
+}
+
+func TestIngestFlushesOnInterval(t *testing.T) {
+	sm := NewShardManager(WithIngestBatching(1000, time.Millisecond))
+	ch := sm.StartIngest(1)
+	defer sm.Close()
+	blocks := fixedBlocks(5)
+	for _, b := range blocks {
+		ch <- b
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, b := range blocks {
+		for {
+			if _, found := sm.FindBlockShard(b.Hash); found {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("block #%d not distributed without a full batch", b.Index)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// benchIngestBlocks is the number of blocks both ingest benchmarks distribute
+const benchIngestBlocks = 100000
+
+// benchIngestManager has shards large enough that the run measures
+// ingestion rather than splitting
+func benchIngestManager(b *testing.B) *ShardManager {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(MinBlocksPerShard, 1024); err != nil {
+		b.Fatal(err)
+	}
+	return sm
+}
+
+func BenchmarkIngestSync(b *testing.B) {
+	blocks := fixedBlocks(benchIngestBlocks)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sm := benchIngestManager(b)
+		for _, block := range blocks {
+			sm.DistributeBlock(block)
+		}
+	}
+	b.ReportMetric(float64(b.N*benchIngestBlocks)/b.Elapsed().Seconds(), "blocks/s")
+}
+
+func BenchmarkIngestAsync(b *testing.B) {
+	blocks := fixedBlocks(benchIngestBlocks)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ingestAll(benchIngestManager(b), 4, blocks)
+	}
+	b.ReportMetric(float64(b.N*benchIngestBlocks)/b.Elapsed().Seconds(), "blocks/s")
+}
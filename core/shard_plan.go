@@ -0,0 +1,246 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrStalePlan is returned by ApplyPlan when the forest changed after the
+// plan was made
+var ErrStalePlan = errors.New("forest changed since the plan was made")
+
+// PlannedShard is one shard's block count before and after a plan. Shards
+// the plan creates have a Before of 0 and shards it merges away an After of 0.
+type PlannedShard struct {
+	ID     int `json:"id"`
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+// PlanSummary is the part of a plan common to splits and merges
+type PlanSummary struct {
+	ForestRoot      string         `json:"forest_root"` // Forest root the plan was made against
+	Shards          []PlannedShard `json:"shards"`      // Ascending by shard ID
+	NewShardIDs     []int          `json:"new_shard_ids"`
+	RemovedShardIDs []int          `json:"removed_shard_ids"`
+	BlocksMoved     int            `json:"blocks_moved"`
+	BytesMoved      int            `json:"bytes_moved"` // Estimated from the JSON size of the blocks moved
+}
+
+// Summary returns the plan's shard changes
+func (ps PlanSummary) Summary() PlanSummary {
+	return ps
+}
+
+// Plan is a forest reorganization computed without applying it; see
+// PlanRebalance, PlanMerge and ApplyPlan
+type Plan interface {
+	Summary() PlanSummary
+	apply(sm *ShardManager) ([]MergeProof, error)
+}
+
+// PlannedSplit moves part of a shard's blocks to a new shard
+type PlannedSplit struct {
+	ShardID    int `json:"shard_id"`
+	NewShardID int `json:"new_shard_id"`
+	Moved      int `json:"moved"` // Blocks moved to the new shard
+}
+
+// RebalancePlan is what RebalanceShards would do at the split threshold
+// MaxBlocks
+type RebalancePlan struct {
+	PlanSummary
+	MaxBlocks int            `json:"max_blocks"`
+	Splits    []PlannedSplit `json:"splits"`
+}
+
+// apply implements Plan
+func (p RebalancePlan) apply(sm *ShardManager) ([]MergeProof, error) {
+	return nil, sm.splitShards(p.MaxBlocks)
+}
+
+// PlannedMerge moves all blocks of the shard AbsorbedID into ShardID
+type PlannedMerge struct {
+	ShardID    int `json:"shard_id"`
+	AbsorbedID int `json:"absorbed_id"`
+}
+
+// MergePlan is what MergeShards would do at Threshold
+type MergePlan struct {
+	PlanSummary
+	Threshold int            `json:"threshold"`
+	Merges    []PlannedMerge `json:"merges"`
+}
+
+// apply implements Plan
+func (p MergePlan) apply(sm *ShardManager) ([]MergeProof, error) {
+	return sm.mergeShards(p.Threshold)
+}
+
+// plannedBlocks is a shard as a plan sees it: its blocks and hash range
+type plannedBlocks struct {
+	id         int
+	before     int
+	blocks     []Block
+	rangeStart string
+	rangeEnd   string
+}
+
+// snapshot copies the shards' block lists and hash ranges in merge order
+// with the forest root; the caller must hold the manager lock
+func (sm *ShardManager) snapshot() (string, []plannedBlocks) {
+	tree, _ := sm.forestTree()
+	shards := sm.shardsInOrder()
+	planned := make([]plannedBlocks, len(shards))
+	for i, shard := range shards {
+		shard.mutex.Lock()
+		planned[i] = plannedBlocks{
+			id:         shard.ID,
+			before:     len(shard.Blocks),
+			blocks:     append([]Block{}, shard.Blocks...),
+			rangeStart: shard.RangeStart,
+			rangeEnd:   shard.RangeEnd,
+		}
+		shard.mutex.Unlock()
+	}
+	return tree.GetRootHash(), planned
+}
+
+// PlanRebalance reports the splits RebalanceShards would make now, without
+// changing any shard
+func (sm *ShardManager) PlanRebalance() RebalancePlan {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	root, shards := sm.snapshot()
+	sort.Slice(shards, func(i, j int) bool { return shards[i].id < shards[j].id })
+	plan := RebalancePlan{PlanSummary: PlanSummary{ForestRoot: root}, MaxBlocks: sm.maxBlocks}
+	nextID := sm.Shards.NextID()
+	split := func(shard *plannedBlocks, left, right []Block) plannedBlocks {
+		created := plannedBlocks{id: nextID, blocks: right}
+		plan.Splits = append(plan.Splits, PlannedSplit{ShardID: shard.id, NewShardID: nextID, Moved: len(right)})
+		plan.NewShardIDs = append(plan.NewShardIDs, nextID)
+		plan.moved(right)
+		shard.blocks = left
+		nextID++
+		return created
+	}
+
+	// Mirrors splitShards and rebalanceByHash
+	if sm.SplitStrategy() == HashRangeSplit {
+		for i := 0; i < len(shards); i++ {
+			for len(shards[i].blocks) > plan.MaxBlocks {
+				mid, ok := splitHashRange(shards[i].rangeStart, shards[i].rangeEnd)
+				if !ok {
+					break
+				}
+				var left, right []Block
+				for _, b := range shards[i].blocks {
+					if b.Hash <= mid {
+						left = append(left, b)
+					} else {
+						right = append(right, b)
+					}
+				}
+				created := split(&shards[i], left, right)
+				created.rangeStart, created.rangeEnd = nextHash(mid), shards[i].rangeEnd
+				shards[i].rangeEnd = mid
+				shards = append(shards, created)
+			}
+		}
+	} else {
+		for i, count := 0, len(shards); i < count; i++ {
+			blocks := shards[i].blocks
+			if len(blocks) <= plan.MaxBlocks {
+				continue
+			}
+			left, right := blocks[:len(blocks)/2], blocks[len(blocks)/2:]
+			if sm.SplitStrategy() == AffinityPolicy {
+				left, right = splitByAffinity(blocks, plan.MaxBlocks)
+			}
+			shards = append(shards, split(&shards[i], left, right))
+		}
+	}
+
+	for _, shard := range shards {
+		plan.Shards = append(plan.Shards, PlannedShard{ID: shard.id, Before: shard.before, After: len(shard.blocks)})
+	}
+	sort.Slice(plan.Shards, func(i, j int) bool { return plan.Shards[i].ID < plan.Shards[j].ID })
+	return plan
+}
+
+// PlanMerge reports the merges MergeShards(threshold) would make now,
+// without changing any shard
+func (sm *ShardManager) PlanMerge(threshold int) MergePlan {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	root, shards := sm.snapshot()
+	plan := MergePlan{PlanSummary: PlanSummary{ForestRoot: root}, Threshold: threshold}
+
+	// Mirrors mergeShards: each shard below threshold absorbs the next one
+	for i := 0; i < len(shards); i++ {
+		current := shards[i]
+		if current.before >= threshold || i+1 >= len(shards) {
+			plan.Shards = append(plan.Shards, PlannedShard{ID: current.id, Before: current.before, After: current.before})
+			continue
+		}
+		next := shards[i+1]
+		plan.Shards = append(plan.Shards,
+			PlannedShard{ID: current.id, Before: current.before, After: current.before + next.before},
+			PlannedShard{ID: next.id, Before: next.before})
+		plan.Merges = append(plan.Merges, PlannedMerge{ShardID: current.id, AbsorbedID: next.id})
+		plan.RemovedShardIDs = append(plan.RemovedShardIDs, next.id)
+		plan.moved(next.blocks)
+		i++
+	}
+	sort.Slice(plan.Shards, func(i, j int) bool { return plan.Shards[i].ID < plan.Shards[j].ID })
+	return plan
+}
+
+// moved adds blocks to the data a plan moves
+func (ps *PlanSummary) moved(blocks []Block) {
+	ps.BlocksMoved += len(blocks)
+	for _, b := range blocks {
+		if data, err := json.Marshal(b); err == nil {
+			ps.BytesMoved += len(data)
+		}
+	}
+}
+
+// ApplyPlan executes a plan made by PlanRebalance or PlanMerge, at the
+// threshold it was made with. It returns ErrStalePlan without changing
+// anything if the forest root differs from the one the plan was made
+// against, since the plan may no longer describe what would happen. A
+// rebalance failing at its mid-rebalance hook changes nothing either.
+func (sm *ShardManager) ApplyPlan(p Plan) error {
+	proofs, err := sm.applyPlan(p)
+	if err != nil {
+		return err
+	}
+	sm.emitMerges(proofs)
+	if _, merge := p.(MergePlan); merge && sm.collectEmpty {
+		sm.CollectEmptyShards()
+	}
+	sm.verifyIfStrict()
+	return nil
+}
+
+// applyPlan applies p under the manager lock unless it is stale
+func (sm *ShardManager) applyPlan(p Plan) ([]MergeProof, error) {
+	planned := p.Summary().ForestRoot
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	tree, _ := sm.forestTree()
+	if root := tree.GetRootHash(); root != planned {
+		return nil, fmt.Errorf("%w: forest root is %s, plan was made at %s", ErrStalePlan, root, planned)
+	}
+	proofs, err := p.apply(sm)
+	if err != nil {
+		return nil, err
+	}
+	sm.recordForestRoot()
+	return proofs, nil
+}
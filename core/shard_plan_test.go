@@ -0,0 +1,177 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// shardCounts returns the block count of every shard by ID
+func shardCounts(sm *ShardManager) map[int]int {
+	counts := make(map[int]int)
+	for _, view := range sm.GetAllShardViews() {
+		counts[view.ID] = view.BlockCount
+	}
+	return counts
+}
+
+// checkPlanned fails unless the forest holds exactly the shards a plan
+// leaves, with the counts it planned
+func checkPlanned(t *testing.T, sm *ShardManager, summary PlanSummary) {
+	t.Helper()
+	counts := shardCounts(sm)
+	removed := make(map[int]bool)
+	for _, id := range summary.RemovedShardIDs {
+		removed[id] = true
+	}
+	for _, planned := range summary.Shards {
+		count, exists := counts[planned.ID]
+		if exists == removed[planned.ID] || count != planned.After {
+			t.Fatalf("shard #%d holds %d blocks (exists %v), planned %d", planned.ID, count, exists, planned.After)
+		}
+	}
+	if len(counts) != len(summary.Shards)-len(removed) {
+		t.Fatalf("%d shards, planned %d", len(counts), len(summary.Shards)-len(removed))
+	}
+}
+
+// oversizedManager returns a manager holding n blocks in shards of up to 8,
+// then lowered to a split threshold of 2
+func oversizedManager(t *testing.T, n int, strategy SplitStrategy) *ShardManager {
+	t.Helper()
+	sm := NewShardManager(WithSplitStrategy(strategy))
+	if err := sm.SetThresholds(1, 8); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range fixedBlocks(n) {
+		sm.DistributeBlock(b)
+	}
+	if err := sm.SetThresholds(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+func TestPlanRebalanceMatchesApplied(t *testing.T) {
+	for _, strategy := range []SplitStrategy{MidpointSplit, HashRangeSplit} {
+		t.Run(string(strategy), func(t *testing.T) {
+			sm := oversizedManager(t, 20, strategy)
+			root, before := sm.ForestRoot(), shardCounts(sm)
+
+			plan := sm.PlanRebalance()
+			if sm.ForestRoot() != root || fmt.Sprint(shardCounts(sm)) != fmt.Sprint(before) {
+				t.Fatal("planning changed the forest")
+			}
+			if plan.ForestRoot != root || plan.MaxBlocks != 2 || len(plan.Splits) == 0 {
+				t.Fatalf("plan %+v", plan)
+			}
+			if len(plan.NewShardIDs) != len(plan.Splits) || plan.BlocksMoved == 0 || plan.BytesMoved <= plan.BlocksMoved {
+				t.Fatalf("%d new shards for %d splits moving %d blocks in %d bytes",
+					len(plan.NewShardIDs), len(plan.Splits), plan.BlocksMoved, plan.BytesMoved)
+			}
+			for _, id := range plan.NewShardIDs {
+				if _, exists := before[id]; exists {
+					t.Fatalf("planned new shard #%d already exists", id)
+				}
+			}
+
+			if err := sm.ApplyPlan(plan); err != nil {
+				t.Fatal(err)
+			}
+			checkPlanned(t, sm, plan.Summary())
+			if sm.ForestRoot() == root {
+				t.Fatal("forest unchanged by the plan")
+			}
+		})
+	}
+}
+
+func TestPlanMergeMatchesApplied(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range fixedBlocks(14) {
+		sm.DistributeBlock(b)
+	}
+	before := shardCounts(sm)
+	plan := sm.PlanMerge(4)
+	if len(plan.Merges) == 0 || len(plan.RemovedShardIDs) != len(plan.Merges) || len(plan.NewShardIDs) != 0 {
+		t.Fatalf("plan %+v", plan)
+	}
+	moved := 0
+	for _, id := range plan.RemovedShardIDs {
+		moved += before[id]
+	}
+	if plan.BlocksMoved != moved {
+		t.Fatalf("plan moves %d blocks, the removed shards hold %d", plan.BlocksMoved, moved)
+	}
+
+	if err := sm.ApplyPlan(plan); err != nil {
+		t.Fatal(err)
+	}
+	checkPlanned(t, sm, plan.Summary())
+	proofs := sm.MergeProofs()
+	if len(proofs) != len(plan.Merges) {
+		t.Fatalf("%d merge proofs for %d planned merges", len(proofs), len(plan.Merges))
+	}
+	for i, merge := range plan.Merges {
+		if proofs[i].LeftID != merge.ShardID || proofs[i].RightID != merge.AbsorbedID {
+			t.Fatalf("merged #%d and #%d, planned %+v", proofs[i].LeftID, proofs[i].RightID, merge)
+		}
+	}
+}
+
+func TestApplyStalePlanFails(t *testing.T) {
+	sm := oversizedManager(t, 20, MidpointSplit)
+	rebalance := sm.PlanRebalance()
+	merge := sm.PlanMerge(100)
+
+	// A shard changes after planning
+	shard := sm.Shards.GetAllShards()[0]
+	extra := fixedBlocks(21)[20]
+	shard.AddBlock(extra)
+	root, counts := sm.ForestRoot(), shardCounts(sm)
+
+	for _, plan := range []Plan{rebalance, merge} {
+		if err := sm.ApplyPlan(plan); !errors.Is(err, ErrStalePlan) {
+			t.Fatalf("stale %T: %v", plan, err)
+		}
+		if sm.ForestRoot() != root || fmt.Sprint(shardCounts(sm)) != fmt.Sprint(counts) {
+			t.Fatalf("stale %T changed the forest", plan)
+		}
+	}
+
+	// Planning again against the changed forest applies
+	fresh := sm.PlanRebalance()
+	if err := sm.ApplyPlan(fresh); err != nil {
+		t.Fatal(err)
+	}
+	checkPlanned(t, sm, fresh.Summary())
+	if err := sm.ApplyPlan(fresh); !errors.Is(err, ErrStalePlan) {
+		t.Fatalf("plan applied twice: %v", err)
+	}
+}
+
+func TestPlanJSON(t *testing.T) {
+	plan := oversizedManager(t, 12, MidpointSplit).PlanRebalance()
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded RebalancePlan
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(decoded) != fmt.Sprint(plan) {
+		t.Fatalf("plan changed through JSON:\n%+v\n%+v", decoded, plan)
+	}
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	for _, field := range []string{"forest_root", "shards", "new_shard_ids", "blocks_moved", "bytes_moved", "max_blocks", "splits"} {
+		if _, ok := fields[field]; !ok {
+			t.Fatalf("JSON plan has no %s: %s", field, data)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WithPrunedBlocks hands every shard proof made by PruneShard, with the
+// blocks it removed in index order, to fn, e.g. to keep them on an archive
+// node that can later prove them with ProvePrunedBlock
+func WithPrunedBlocks(fn func(IntegrityProof, []Block)) StatePrunerOption {
+	return func(sp *StatePruner) {
+		sp.onShardPrune = fn
+	}
+}
+
+// PruneShard removes the oldest blocks of a shard by index, keeping the
+// newest retain, and rebuilds the shard's tree, accumulator and state over
+// the rest. Blocks younger than the policy's MinAge are kept as well. The
+// removed blocks are committed to by a signed integrity proof, recording
+// the shard ID, whose RootHash is a shard tree root over their headers in
+// index order, so their inclusion stays verifiable with VerifyPrunedBlock.
+func (sp *StatePruner) PruneShard(s *Shard, retain int) (int, error) {
+	sp.updateCommitments()
+	return sp.pruneShard(s, retain)
+}
+
+// pruneShard implements PruneShard without updating the global commitment,
+// which the caller must do before taking any lock it reads
+func (sp *StatePruner) pruneShard(s *Shard, retain int) (int, error) {
+	if retain < 0 {
+		return 0, fmt.Errorf("negative retention %d for shard #%d", retain, s.ID)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Positions of the shard's blocks, oldest first
+	order := make([]int, len(s.Blocks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return s.Blocks[order[i]].Index < s.Blocks[order[j]].Index
+	})
+
+	count := len(order) - retain
+	if sp.policy.MinAge > 0 {
+		for count > 0 && s.Blocks[order[count-1]].Age() < sp.policy.MinAge {
+			count--
+		}
+	}
+	if count <= 0 {
+		return 0, nil
+	}
+
+	pruned := make([]Block, count)
+	removed := make(map[int]bool, count)
+	for i, pos := range order[:count] {
+		pruned[i] = s.Blocks[pos]
+		removed[pos] = true
+	}
+	kept := make([]Block, 0, len(s.Blocks)-count)
+	for i, b := range s.Blocks {
+		if !removed[i] {
+			kept = append(kept, b)
+		}
+	}
+
+	proof := sp.signIntegrityProof(IntegrityProof{
+		RootHash:    newBlockTree(pruned).GetRootHash(),
+		PrunedCount: count,
+		Sharded:     true,
+		ShardID:     s.ID,
+	})
+	sp.integrityProofs = append(sp.integrityProofs, proof)
+
+	s.Blocks = kept
+	s.rebuild()
+	if sp.onShardPrune != nil {
+		sp.onShardPrune(proof, pruned)
+	}
+	return count, nil
+}
+
+// PruneAllShards prunes every shard holding more than retain blocks with
+// PruneShard and returns the number of blocks pruned per shard ID, omitting
+// shards left untouched
+func (sm *ShardManager) PruneAllShards(pruner *StatePruner, retain int) map[int]int {
+	pruner.updateCommitments()
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	pruned := make(map[int]int)
+	for _, shard := range sm.Shards.GetAllShards() {
+		if shard.blockCount() <= retain {
+			continue
+		}
+		if count, err := pruner.pruneShard(shard, retain); err == nil && count > 0 {
+			pruned[shard.ID] = count
+		}
+	}
+	sm.recordForestRoot()
+	return pruned
+}
+
+// ProvePrunedBlock proves that the block with the given hash is in pruned,
+// the blocks removed by one PruneShard call in index order as
+// handed to WithPrunedBlocks
+func ProvePrunedBlock(pruned []Block, hash string) (Block, MerkleProof, error) {
+	for i, b := range pruned {
+		if b.Hash == hash {
+			proof, err := newBlockTree(pruned).GetProof(i)
+			return b, proof, err
+		}
+	}
+	return Block{}, MerkleProof{}, fmt.Errorf("%w: %s among pruned blocks", ErrBlockNotFound, hash)
+}
+
+// VerifyPrunedBlock checks that a block, full or header-only, was among
+// the blocks a shard integrity proof commits to. A full block must also
+// match its hash; a header-only one cannot be rehashed, and is checked
+// through the leaf alone. The proof's signature is not checked; see
+// VerifyIntegrityProof.
+func VerifyPrunedBlock(proof IntegrityProof, block Block, path MerkleProof) bool {
+	if !proof.Sharded || path.LeafCount != proof.PrunedCount {
+		return false
+	}
+	if !block.HeaderOnly() && block.ComputeHash() != block.Hash {
+		return false
+	}
+	return VerifyBlockProof(proof.RootHash, block, path)
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneShardRemovesOldestByIndex(t *testing.T) {
+	blocks := fixedBlocks(10)
+	shard := NewShard(3)
+	shard.Accumulator = NewRSAAccumulator()
+	// Blocks arrive out of index order, as after transfers and merges
+	for _, i := range []int{4, 9, 0, 7, 2, 5, 1, 8, 3, 6} {
+		shard.AddBlock(blocks[i])
+	}
+
+	var archived []Block
+	var handed IntegrityProof
+	sp := NewStatePruner(1, 2, false, WithPrunedBlocks(func(p IntegrityProof, pruned []Block) {
+		handed, archived = p, pruned
+	}))
+	count, err := sp.PruneShard(shard, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 || len(archived) != 6 {
+		t.Fatalf("pruned %d blocks, archived %d, want 6", count, len(archived))
+	}
+	for i, b := range archived {
+		if b.Hash != blocks[i].Hash {
+			t.Fatalf("archived block %d is #%d, want the oldest in index order", i, b.Index)
+		}
+	}
+
+	// The rest keep their order, and the shard's structures cover only them
+	view := shard.View()
+	kept := []Block{blocks[9], blocks[7], blocks[8], blocks[6]}
+	if len(view.Blocks) != len(kept) {
+		t.Fatalf("shard keeps %d blocks", len(view.Blocks))
+	}
+	for i, b := range kept {
+		if view.Blocks[i].Hash != b.Hash {
+			t.Fatalf("kept block %d is #%d, want #%d", i, view.Blocks[i].Index, b.Index)
+		}
+	}
+	if view.Root != newBlockTree(kept).GetRootHash() {
+		t.Fatal("shard root not rebuilt over the kept blocks")
+	}
+	if len(shard.Accumulator.Elements) != len(kept) {
+		t.Fatalf("accumulator holds %d elements", len(shard.Accumulator.Elements))
+	}
+
+	proof := *sp.GetLatestProof()
+	if proof != handed || !proof.Sharded || proof.ShardID != 3 || proof.PrunedCount != 6 {
+		t.Fatalf("proof %+v", proof)
+	}
+	if proof.RootHash != newBlockTree(archived).GetRootHash() {
+		t.Fatal("proof root is not the tree root over the pruned blocks")
+	}
+	if !VerifyIntegrityProof(sp.PublicKey(), proof) {
+		t.Fatal("shard proof does not verify")
+	}
+	moved := proof
+	moved.ShardID = 4
+	if VerifyIntegrityProof(sp.PublicKey(), moved) {
+		t.Fatal("proof verified for another shard")
+	}
+
+	// Every pruned block, full or header-only, proves against the root
+	for _, b := range archived {
+		block, path, err := ProvePrunedBlock(archived, b.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyPrunedBlock(proof, block, path) || !VerifyPrunedBlock(proof, block.Header().Block(), path) {
+			t.Fatalf("pruned block #%d does not verify", b.Index)
+		}
+		tampered := block
+		tampered.Data = "tampered"
+		header := block.Header().Block()
+		header.DataHash = HashBlockData("tampered")
+		if VerifyPrunedBlock(proof, tampered, path) || VerifyPrunedBlock(proof, header, path) {
+			t.Fatalf("tampered block #%d verified", b.Index)
+		}
+	}
+	if _, _, err := ProvePrunedBlock(archived, blocks[9].Hash); err == nil {
+		t.Fatal("kept block proven as pruned")
+	}
+}
+
+func TestPruneShardRetention(t *testing.T) {
+	shard := NewShard(0)
+	for _, b := range fixedBlocks(5) {
+		shard.AddBlock(b)
+	}
+	sp := NewStatePruner(1, 2, false)
+	if _, err := sp.PruneShard(shard, -1); err == nil {
+		t.Fatal("negative retention accepted")
+	}
+	if count, err := sp.PruneShard(shard, 5); err != nil || count != 0 {
+		t.Fatalf("pruned %d blocks keeping all 5: %v", count, err)
+	}
+	if sp.GetLatestProof() != nil {
+		t.Fatal("proof made for pruning nothing")
+	}
+
+	// Blocks younger than MinAge are kept whatever the retention
+	young := NewShard(1)
+	for i := 0; i < 4; i++ {
+		b := Block{Index: i, Timestamp: time.Now().Add(time.Duration(i-2) * time.Hour), Data: "young"}
+		b.Hash = b.ComputeHash()
+		young.AddBlock(b)
+	}
+	sp.SetMinAge(90 * time.Minute)
+	if count, err := sp.PruneShard(young, 0); err != nil || count != 1 {
+		t.Fatalf("pruned %d blocks of which 1 is old enough: %v", count, err)
+	}
+}
+
+func TestPruneAllShardsSkipsSmallShards(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range fixedBlocks(11) {
+		sm.DistributeBlock(b)
+	}
+	counts := make(map[int]int)
+	for _, view := range sm.GetAllShardViews() {
+		counts[view.ID] = view.BlockCount
+	}
+
+	var proofs []IntegrityProof
+	sp := NewStatePruner(1, 2, false, WithPrunedBlocks(func(p IntegrityProof, _ []Block) {
+		proofs = append(proofs, p)
+	}))
+	pruned := sm.PruneAllShards(sp, 2)
+	for id, count := range counts {
+		want := count - 2
+		if want < 0 {
+			want = 0
+		}
+		if pruned[id] != want {
+			t.Fatalf("pruned %d of %d blocks from shard #%d", pruned[id], count, id)
+		}
+		if _, reported := pruned[id]; reported != (want > 0) {
+			t.Fatalf("shard #%d reported with nothing pruned", id)
+		}
+	}
+	if len(proofs) != len(pruned) {
+		t.Fatalf("%d proofs for %d pruned shards", len(proofs), len(pruned))
+	}
+	for _, p := range proofs {
+		if pruned[p.ShardID] != p.PrunedCount {
+			t.Fatalf("proof for shard #%d counts %d blocks", p.ShardID, p.PrunedCount)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// ErrUnprovenBlock is returned when a fetched block does not prove to be in
+// the authoritative shard
+var ErrUnprovenBlock = errors.New("fetched block does not verify against the authoritative shard")
+
+// ProvenBlock is a block served for repair with evidence that the
+// authoritative shard holds it
+type ProvenBlock struct {
+	Block   Block       `json:"block"`
+	Proof   MerkleProof `json:"proof"`             // Path to the authoritative shard root
+	Witness *big.Int    `json:"witness,omitempty"` // Accumulator membership witness, if the shard keeps one
+}
+
+// BlockSource serves blocks of the authoritative copy of a shard, in
+// process or from a peer
+type BlockSource interface {
+	FetchBlock(hash string) (ProvenBlock, error)
+}
+
+// ShardBlockSource serves an in-process shard as a BlockSource
+type ShardBlockSource struct {
+	shard *Shard
+}
+
+// NewShardBlockSource serves shard's blocks with proofs
+func NewShardBlockSource(shard *Shard) *ShardBlockSource {
+	return &ShardBlockSource{shard: shard}
+}
+
+// FetchBlock implements BlockSource
+func (src *ShardBlockSource) FetchBlock(hash string) (ProvenBlock, error) {
+	s := src.shard
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, b := range s.Blocks {
+		if b.Hash != hash {
+			continue
+		}
+		block, err := s.fullBlock(i)
+		if err != nil {
+			return ProvenBlock{}, err
+		}
+		proof, err := shardTree(s).GetProof(i)
+		if err != nil {
+			return ProvenBlock{}, err
+		}
+		proven := ProvenBlock{Block: block, Proof: proof}
+		if s.Accumulator != nil {
+			if witness, exists := s.Accumulator.Proofs[hash]; exists {
+				proven.Witness = new(big.Int).Set(witness)
+			}
+		}
+		return proven, nil
+	}
+	return ProvenBlock{}, fmt.Errorf("%w: %s in shard #%d", ErrBlockNotFound, hash, s.ID)
+}
+
+// ShardRepairer backfills the blocks a replica is missing, accepting only
+// blocks proven to be in the authoritative copy
+type ShardRepairer struct {
+	replica     *Shard
+	accumulator *RSAAccumulator // Trusted authoritative accumulator, optional
+}
+
+// ShardRepairerOption configures a ShardRepairer
+type ShardRepairerOption func(*ShardRepairer)
+
+// WithTrustedAccumulator also accepts blocks whose membership witness
+// verifies against acc, the authoritative shard's accumulator
+func WithTrustedAccumulator(acc *RSAAccumulator) ShardRepairerOption {
+	return func(r *ShardRepairer) {
+		r.accumulator = acc
+	}
+}
+
+// NewShardRepairer repairs replica
+func NewShardRepairer(replica *Shard, opts ...ShardRepairerOption) *ShardRepairer {
+	r := &ShardRepairer{replica: replica}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// FetchAndVerify fetches the missing blocks from source and inserts each
+// one whose hash matches its contents and that is proven to be in the
+// authoritative shard, by a Merkle proof against expectedRoot or a witness
+// against the trusted accumulator. Merkle-proven blocks are inserted at
+// their authoritative position. A block that fails is rejected and
+// returned as an error after the blocks verified so far are inserted, so
+// calling again with the same hashes resumes the repair; blocks the replica
+// already holds are skipped. Once every block is in, the replica's root
+// must equal expectedRoot.
+func (r *ShardRepairer) FetchAndVerify(missing []string, source BlockSource, expectedRoot string) error {
+	replica := r.replica
+	replica.mutex.Lock()
+	held := make(map[string]bool, len(replica.Blocks))
+	for _, b := range replica.Blocks {
+		held[b.Hash] = true
+	}
+	replica.mutex.Unlock()
+
+	var verified []ProvenBlock
+	var fetchErr error
+	for _, hash := range missing {
+		if held[hash] {
+			continue
+		}
+		proven, err := source.FetchBlock(hash)
+		if err == nil {
+			err = r.verify(hash, proven, expectedRoot)
+		}
+		if err != nil {
+			fetchErr = err
+			break
+		}
+		held[hash] = true
+		verified = append(verified, proven)
+	}
+
+	replica.mutex.Lock()
+	defer replica.mutex.Unlock()
+	r.insert(verified, expectedRoot)
+	if fetchErr != nil {
+		return fmt.Errorf("shard #%d repair stopped after %d blocks: %w", replica.ID, len(verified), fetchErr)
+	}
+	if root := shardTree(replica).GetRootHash(); root != expectedRoot {
+		return fmt.Errorf("%w: shard #%d has root %s, expected %s", ErrRepairFailed, replica.ID, root, expectedRoot)
+	}
+	return nil
+}
+
+// verify checks that a fetched block is the one asked for and is proven to
+// be in the authoritative shard
+func (r *ShardRepairer) verify(hash string, proven ProvenBlock, expectedRoot string) error {
+	b := proven.Block
+	if b.Hash != hash || b.ComputeHash() != hash {
+		return fmt.Errorf("%w: %s does not hash to its contents", ErrUnprovenBlock, hash)
+	}
+	if VerifyBlockProof(expectedRoot, b, proven.Proof) {
+		return nil
+	}
+	if r.accumulator != nil && proven.Witness != nil && r.accumulator.CheckMembership(hash, proven.Witness) == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnprovenBlock, hash)
+}
+
+// insert adds verified blocks to the replica, Merkle-proven ones at their
+// authoritative index in ascending order and the rest at the end; the
+// caller must hold the replica mutex
+func (r *ShardRepairer) insert(verified []ProvenBlock, expectedRoot string) {
+	if len(verified) == 0 {
+		return
+	}
+	sort.SliceStable(verified, func(i, j int) bool {
+		return verified[i].Proof.Index < verified[j].Proof.Index
+	})
+	replica := r.replica
+	var unplaced []Block
+	for _, proven := range verified {
+		b := replica.stripBody(proven.Block)
+		index := proven.Proof.Index
+		if !VerifyBlockProof(expectedRoot, proven.Block, proven.Proof) || index > len(replica.Blocks) {
+			unplaced = append(unplaced, b)
+			continue
+		}
+		replica.Blocks = append(replica.Blocks[:index], append([]Block{b}, replica.Blocks[index:]...)...)
+	}
+	replica.Blocks = append(replica.Blocks, unplaced...)
+	replica.rebuild()
+}
+
+// MissingBlocks returns the hashes of blocks the authoritative replica
+// holds and replica i lacks, in authoritative order, e.g. for a
+// ShardRepairer to backfill after DetectDivergence
+func (rs *ShardReplicaSet) MissingBlocks(i, authoritative int) ([]string, error) {
+	replica, exists := rs.Replica(i)
+	if !exists {
+		return nil, fmt.Errorf("%w: #%d", ErrUnknownReplica, i)
+	}
+	source, exists := rs.Replica(authoritative)
+	if !exists {
+		return nil, fmt.Errorf("%w: #%d", ErrUnknownReplica, authoritative)
+	}
+	replica.mutex.Lock()
+	held := make(map[string]bool, len(replica.Blocks))
+	for _, b := range replica.Blocks {
+		held[b.Hash] = true
+	}
+	replica.mutex.Unlock()
+
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	var missing []string
+	for _, b := range source.Blocks {
+		if !held[b.Hash] {
+			missing = append(missing, b.Hash)
+		}
+	}
+	return missing, nil
+}
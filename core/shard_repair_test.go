@@ -0,0 +1,132 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// tamperingSource serves blocks from a shard, passing each through tamper
+type tamperingSource struct {
+	inner   BlockSource
+	tamper  func(*ProvenBlock)
+	fetched []string
+}
+
+func (s *tamperingSource) FetchBlock(hash string) (ProvenBlock, error) {
+	s.fetched = append(s.fetched, hash)
+	proven, err := s.inner.FetchBlock(hash)
+	if err == nil && s.tamper != nil {
+		s.tamper(&proven)
+	}
+	return proven, err
+}
+
+// laggingReplica returns a replica set of two whose second replica lost
+// the blocks at the given indexes, and the hashes it is missing
+func laggingReplica(t *testing.T, blocks []Block, lost ...int) (*ShardReplicaSet, *Shard, *Shard, []string) {
+	t.Helper()
+	rs := replicatedSet(t, 2, blocks)
+	authoritative, _ := rs.Replica(0)
+	lagging, _ := rs.Replica(1)
+	lagging.mutex.Lock()
+	for i := len(lost) - 1; i >= 0; i-- {
+		lagging.Blocks = append(lagging.Blocks[:lost[i]], lagging.Blocks[lost[i]+1:]...)
+	}
+	lagging.rebuild()
+	lagging.mutex.Unlock()
+
+	if diverged, ok := rs.DetectDivergence(); !ok || len(diverged) != 1 || diverged[0] != 1 {
+		t.Fatalf("divergence %v", diverged)
+	}
+	missing, err := rs.MissingBlocks(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != len(lost) {
+		t.Fatalf("%d blocks missing, lost %d", len(missing), len(lost))
+	}
+	return rs, authoritative, lagging, missing
+}
+
+func TestFetchAndVerifyRepairsReplica(t *testing.T) {
+	blocks := fixedBlocks(10)
+	rs, authoritative, lagging, missing := laggingReplica(t, blocks, 1, 4, 5, 9)
+	expected := replicaRoot(authoritative)
+
+	if err := NewShardRepairer(lagging).FetchAndVerify(missing, NewShardBlockSource(authoritative), expected); err != nil {
+		t.Fatal(err)
+	}
+	checkConverged(t, rs, blocks)
+}
+
+func TestFetchAndVerifyRejectsTamperedBlock(t *testing.T) {
+	blocks := fixedBlocks(10)
+	rs, authoritative, lagging, missing := laggingReplica(t, blocks, 2, 5, 6)
+	expected := replicaRoot(authoritative)
+	tampered := missing[1]
+
+	for name, tamper := range map[string]func(*ProvenBlock){
+		"data":  func(p *ProvenBlock) { p.Block.Data = "tampered" },
+		"block": func(p *ProvenBlock) { p.Block.Data = "tampered"; p.Block.Hash = p.Block.ComputeHash() },
+		"proof": func(p *ProvenBlock) { p.Proof.Siblings = p.Proof.Siblings[1:] },
+	} {
+		source := &tamperingSource{inner: NewShardBlockSource(authoritative), tamper: func(p *ProvenBlock) {
+			if p.Block.Hash == tampered {
+				tamper(p)
+			}
+		}}
+		err := NewShardRepairer(lagging).FetchAndVerify(missing, source, expected)
+		if !errors.Is(err, ErrUnprovenBlock) {
+			t.Fatalf("block with tampered %s: %v", name, err)
+		}
+		// Blocks verified before the tampered one are kept, the rest wait
+		view := lagging.View()
+		if len(view.Blocks) != len(blocks)-2 || view.Blocks[2].Hash != missing[0] {
+			t.Fatalf("tampered %s: replica holds %d blocks", name, len(view.Blocks))
+		}
+		for _, b := range view.Blocks {
+			if b.Hash == tampered || b.Data == "tampered" {
+				t.Fatalf("tampered %s inserted", name)
+			}
+		}
+	}
+
+	// Resuming with an honest source fetches only what is still missing
+	source := &tamperingSource{inner: NewShardBlockSource(authoritative)}
+	if err := NewShardRepairer(lagging).FetchAndVerify(missing, source, expected); err != nil {
+		t.Fatal(err)
+	}
+	if len(source.fetched) != 2 || source.fetched[0] != tampered {
+		t.Fatalf("resumed repair fetched %v", source.fetched)
+	}
+	checkConverged(t, rs, blocks)
+}
+
+func TestFetchAndVerifyAcceptsAccumulatorWitness(t *testing.T) {
+	blocks := fixedBlocks(6)
+	authoritative, lagging := NewShard(0), NewShard(0)
+	authoritative.Accumulator = NewRSAAccumulator()
+	for i, b := range blocks {
+		authoritative.AddBlock(b)
+		if i < len(blocks)-1 {
+			lagging.AddBlock(b)
+		}
+	}
+	missing := []string{blocks[len(blocks)-1].Hash}
+	expected := replicaRoot(authoritative)
+	// A source that cannot serve Merkle paths, only witnesses
+	source := &tamperingSource{inner: NewShardBlockSource(authoritative), tamper: func(p *ProvenBlock) {
+		p.Proof = MerkleProof{}
+	}}
+
+	if err := NewShardRepairer(lagging).FetchAndVerify(missing, source, expected); !errors.Is(err, ErrUnprovenBlock) {
+		t.Fatalf("witness accepted without a trusted accumulator: %v", err)
+	}
+	repairer := NewShardRepairer(lagging, WithTrustedAccumulator(authoritative.Accumulator))
+	if err := repairer.FetchAndVerify(missing, source, expected); err != nil {
+		t.Fatal(err)
+	}
+	if replicaRoot(lagging) != expected {
+		t.Fatal("replica root differs after the repair")
+	}
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// SplitStrategy decides which blocks move to the new shard when an
+// oversized shard is split
+type SplitStrategy string
+
+const (
+	// MidpointSplit appends blocks to the last shard and splits shards at
+	// half their block count, so layouts depend on arrival order
+	MidpointSplit SplitStrategy = "midpoint"
+	// HashRangeSplit gives every shard a range of block hashes. Blocks go
+	// to the shard covering their hash, kept in hash order, and a shard is
+	// split at the middle of its range until each half is small enough. A
+	// range splits once it holds more than the threshold, whatever the
+	// order its blocks arrived in, so a block's home shard depends only on
+	// its hash and the set of blocks ingested.
+	HashRangeSplit SplitStrategy = "hash_range"
+)
+
+// Bounds of the hash space covered by the first shard under HashRangeSplit
+var (
+	minBlockHash = strings.Repeat("0", 64)
+	maxBlockHash = strings.Repeat("f", 64)
+)
+
+// WithSplitStrategy sets how oversized shards are split; the default is
+// MidpointSplit
+func WithSplitStrategy(strategy SplitStrategy) ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.split = strategy
+	}
+}
+
+// SplitStrategy returns how the manager splits oversized shards
+func (sm *ShardManager) SplitStrategy() SplitStrategy {
+	if sm.split == "" {
+		return MidpointSplit
+	}
+	return sm.split
+}
+
+// splitHashRange returns the last hash of the lower half of [start, end],
+// or false if the range holds a single hash
+func splitHashRange(start, end string) (string, bool) {
+	low, okLow := new(big.Int).SetString(start, 16)
+	high, okHigh := new(big.Int).SetString(end, 16)
+	if !okLow || !okHigh || low.Cmp(high) >= 0 {
+		return "", false
+	}
+	mid := low.Add(low, high)
+	mid.Rsh(mid, 1)
+	return fmt.Sprintf("%064x", mid), true
+}
+
+// nextHash returns the hash following h
+func nextHash(h string) string {
+	n, _ := new(big.Int).SetString(h, 16)
+	return fmt.Sprintf("%064x", n.Add(n, big.NewInt(1)))
+}
+
+// indexRanges orders the shards by hash range for homeShard; the caller
+// must hold the manager lock
+func (sm *ShardManager) indexRanges() {
+	if sm.SplitStrategy() != HashRangeSplit {
+		sm.byRange = nil
+		return
+	}
+	sm.byRange = sm.Shards.GetAllShards()
+	sort.Slice(sm.byRange, func(i, j int) bool {
+		return sm.byRange[i].RangeStart < sm.byRange[j].RangeStart
+	})
+}
+
+// homeShard returns the shard a block with the given hash is distributed
+// to: the last shard under MidpointSplit, otherwise the shard whose range
+// covers the hash. The caller must hold the manager lock.
+func (sm *ShardManager) homeShard(hash string) *Shard {
+	if sm.SplitStrategy() != HashRangeSplit || len(sm.byRange) == 0 {
+		shards := sm.Shards.GetAllShards()
+		return shards[len(shards)-1]
+	}
+	i := sort.Search(len(sm.byRange), func(i int) bool {
+		return sm.byRange[i].RangeStart > hash
+	})
+	if i == 0 {
+		return sm.byRange[0]
+	}
+	return sm.byRange[i-1]
+}
+
+// FindBlockShard returns the shard holding the block with the given hash.
+// Under HashRangeSplit it looks in the shard covering the hash first,
+// found by binary search over the ranges; blocks moved elsewhere by
+// transfers, and every block under MidpointSplit, are found by scanning.
+func (sm *ShardManager) FindBlockShard(hash string) (*Shard, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	var home *Shard
+	if sm.SplitStrategy() == HashRangeSplit {
+		home = sm.homeShard(hash)
+		if home.holds(hash) {
+			return home, true
+		}
+	}
+	for _, shard := range sm.Shards.GetAllShards() {
+		if shard != home && shard.holds(hash) {
+			return shard, true
+		}
+	}
+	return nil, false
+}
+
+// holds reports whether the shard has a block with the given hash
+func (s *Shard) holds(hash string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.containsBlock(hash)
+}
+
+// insertByHash adds blocks keeping the shard's blocks in hash order. Blocks
+// sorting after all current ones are appended incrementally; otherwise the
+// tree is rebuilt. The caller must hold the shard mutex.
+func (s *Shard) insertByHash(blocks []Block) {
+	sorted := append([]Block{}, blocks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Hash < sorted[j].Hash })
+	if len(s.Blocks) == 0 || sorted[0].Hash >= s.Blocks[len(s.Blocks)-1].Hash {
+		for _, b := range sorted {
+			s.addBlock(b)
+		}
+		return
+	}
+
+	merged := make([]Block, 0, len(s.Blocks)+len(sorted))
+	i := 0
+	for _, b := range sorted {
+		for i < len(s.Blocks) && s.Blocks[i].Hash <= b.Hash {
+			merged = append(merged, s.Blocks[i])
+			i++
+		}
+		merged = append(merged, s.stripBody(b))
+	}
+	s.Blocks = append(merged, s.Blocks[i:]...)
+	s.rebuild()
+}
+
+// rebalanceByHash splits every shard above max blocks at the middle of its
+// hash range, repeatedly, until each is at most at max or covers a single
+// hash. Split shards are replaced by new ones,
+// so the tree swapped in is the only change readers can see. The caller must
+// hold the manager lock.
+func (sm *ShardManager) rebalanceByHash(max int) error {
+	shards := sm.Shards.GetAllShards()
+	newTree := NewShardIndex()
+	shardIDCounter := sm.Shards.NextID()
+	for i := 0; i < len(shards); i++ {
+		shard := shards[i]
+		for len(shard.Blocks) > max {
+			left, right := sm.splitByHash(shard, shardIDCounter)
+			if right == nil {
+				break
+			}
+			shard = left
+			shards = append(shards, right)
+			shardIDCounter++
+		}
+		newTree.Insert(shard)
+	}
+	if shardIDCounter > sm.Shards.NextID() {
+		if err := injectFault(sm.faults, FaultMidRebalance); err != nil {
+			return err
+		}
+	}
+	sm.Shards = newTree
+	sm.indexRanges()
+	return nil
+}
+
+// splitByHash returns new shards covering the lower half of a shard's hash
+// range, under the shard's ID, and the upper half, under id. The shard is
+// left unchanged. It returns nils if the range cannot be split.
+func (sm *ShardManager) splitByHash(shard *Shard, id int) (*Shard, *Shard) {
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	mid, ok := splitHashRange(shard.RangeStart, shard.RangeEnd)
+	if !ok {
+		return nil, nil
+	}
+
+	var left, right []Block
+	for _, b := range shard.Blocks {
+		if b.Hash <= mid {
+			left = append(left, b)
+		} else {
+			right = append(right, b)
+		}
+	}
+	oldState := shard.StateManager
+
+	// The new shard owns no accounts until the address space is
+	// partitioned again
+	newShard := sm.newShard(id)
+	newShard.AddrLow, newShard.AddrHigh = 1, 0
+	newShard.RangeStart, newShard.RangeEnd = nextHash(mid), shard.RangeEnd
+	for _, b := range right {
+		newShard.AddBlock(b)
+	}
+	if oldState != nil {
+		newShard.StateManager = rebuildStateManager(oldState.MaxActiveCount, right, oldState)
+	}
+
+	lower := shard.withBlocks(left)
+	lower.RangeEnd = mid
+	return lower, newShard
+}
+
+// mergeHashRanges returns the hash range covering two adjacent shards
+func mergeHashRanges(a, b *Shard) (string, string) {
+	start, end := a.RangeStart, a.RangeEnd
+	if b.RangeStart < start {
+		start = b.RangeStart
+	}
+	if b.RangeEnd > end {
+		end = b.RangeEnd
+	}
+	return start, end
+}
+
+// shardsInOrder returns the shards by hash range under HashRangeSplit, so
+// that neighbours cover adjacent ranges, and by ID otherwise; the caller
+// must hold the manager lock
+func (sm *ShardManager) shardsInOrder() []*Shard {
+	if sm.SplitStrategy() == HashRangeSplit {
+		return append([]*Shard{}, sm.byRange...)
+	}
+	return sm.Shards.GetAllShards()
+}
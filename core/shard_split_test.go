@@ -0,0 +1,126 @@
+package core
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// rangeLayout lists each shard's hash range, root and blocks in range order
+func rangeLayout(sm *ShardManager) []string {
+	var layout []string
+	for _, shard := range sm.Shards.GetAllShards() {
+		view := shard.View()
+		hashes := make([]string, len(view.Blocks))
+		for i, b := range view.Blocks {
+			hashes[i] = b.Hash
+		}
+		layout = append(layout, shard.RangeStart+"-"+shard.RangeEnd+" "+view.Root+" "+strings.Join(hashes, ","))
+	}
+	sort.Strings(layout)
+	return layout
+}
+
+func TestHashRangeLayoutIndependentOfOrder(t *testing.T) {
+	blocks := fixedBlocks(200)
+	ordered := NewShardManager(WithSplitStrategy(HashRangeSplit))
+	for _, b := range blocks {
+		ordered.DistributeBlock(b)
+	}
+	want := rangeLayout(ordered)
+	if ordered.Shards.Size() < 10 {
+		t.Fatalf("only %d shards for 200 blocks", ordered.Shards.Size())
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	for run := 0; run < 5; run++ {
+		shuffled := append([]Block{}, blocks...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		sm := NewShardManager(WithSplitStrategy(HashRangeSplit))
+		for _, b := range shuffled {
+			sm.DistributeBlock(b)
+		}
+		got := rangeLayout(sm)
+		if len(got) != len(want) {
+			t.Fatalf("run %d: %d layout entries, want %d", run, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("run %d: layout differs at %d:\n%s\nwant\n%s", run, i, got[i], want[i])
+			}
+		}
+	}
+
+	// Midpoint splits depend on arrival order
+	reversed := NewShardManager()
+	for i := len(blocks) - 1; i >= 0; i-- {
+		reversed.DistributeBlock(blocks[i])
+	}
+	forward := NewShardManager()
+	for _, b := range blocks {
+		forward.DistributeBlock(b)
+	}
+	if reflect.DeepEqual(shardContents(forward, false), shardContents(reversed, false)) {
+		t.Fatal("midpoint layout did not depend on order")
+	}
+}
+
+func TestHashRangesPartitionHashSpace(t *testing.T) {
+	sm := NewShardManager(WithSplitStrategy(HashRangeSplit))
+	blocks := fixedBlocks(150)
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+
+	sm.mutex.RLock()
+	ranges := append([]*Shard{}, sm.byRange...)
+	sm.mutex.RUnlock()
+	if len(ranges) != sm.Shards.Size() {
+		t.Fatalf("%d ranges indexed for %d shards", len(ranges), sm.Shards.Size())
+	}
+	if ranges[0].RangeStart != minBlockHash || ranges[len(ranges)-1].RangeEnd != maxBlockHash {
+		t.Fatalf("ranges cover %s to %s", ranges[0].RangeStart, ranges[len(ranges)-1].RangeEnd)
+	}
+	for i, shard := range ranges {
+		if i > 0 && shard.RangeStart != nextHash(ranges[i-1].RangeEnd) {
+			t.Fatalf("shard #%d starts at %s after %s", shard.ID, shard.RangeStart, ranges[i-1].RangeEnd)
+		}
+		for j, b := range shard.Blocks {
+			if b.Hash < shard.RangeStart || b.Hash > shard.RangeEnd {
+				t.Fatalf("block %s outside shard #%d range", b.Hash, shard.ID)
+			}
+			if j > 0 && shard.Blocks[j-1].Hash > b.Hash {
+				t.Fatalf("shard #%d blocks out of hash order", shard.ID)
+			}
+		}
+		if len(shard.Blocks) > MaxBlocksPerShard {
+			t.Fatalf("shard #%d holds %d blocks", shard.ID, len(shard.Blocks))
+		}
+	}
+
+	// The home shard found by binary search holds every block
+	for _, b := range blocks {
+		shard, found := sm.FindBlockShard(b.Hash)
+		if !found || b.Hash < shard.RangeStart || b.Hash > shard.RangeEnd {
+			t.Fatalf("block #%d found %v in shard %+v", b.Index, found, shard)
+		}
+	}
+	if _, found := sm.FindBlockShard(minBlockHash); found {
+		t.Fatal("found a block never distributed")
+	}
+}
+
+func TestSplitHashRange(t *testing.T) {
+	mid, ok := splitHashRange(minBlockHash, maxBlockHash)
+	if !ok || mid != "7"+maxBlockHash[1:] {
+		t.Fatalf("whole space splits at %s, %v", mid, ok)
+	}
+	if nextHash(mid) != "8"+minBlockHash[1:] {
+		t.Fatalf("upper half starts at %s", nextHash(mid))
+	}
+	if _, ok := splitHashRange(mid, mid); ok {
+		t.Fatal("single hash range split")
+	}
+}
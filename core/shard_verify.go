@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Errors reported by Shard.VerifySelf
+var (
+	ErrShardRootMismatch = errors.New("shard root does not match its blocks")
+	ErrBlockOrder        = errors.New("shard blocks out of order")
+)
+
+// DefaultVerifyWorkers is how many shards strict mode verifies at once
+const DefaultVerifyWorkers = 4
+
+// ShardVerificationError lists every discrepancy VerifySelf found in a
+// shard; errors.Is matches any of them
+type ShardVerificationError struct {
+	ShardID  int
+	Problems []error
+}
+
+// Error implements error
+func (e *ShardVerificationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Error()
+	}
+	return fmt.Sprintf("shard #%d: %d discrepancies: %s", e.ShardID, len(e.Problems), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the individual discrepancies
+func (e *ShardVerificationError) Unwrap() []error {
+	return e.Problems
+}
+
+// WithStrictMode verifies every shard with VerifyAll after merges and after
+// rebalances that split a shard, reporting failures as
+// ShardVerificationFailed events and through LastVerification
+func WithStrictMode() ShardManagerOption {
+	return func(sm *ShardManager) {
+		sm.strict = true
+	}
+}
+
+// VerifySelf re-verifies the shard's contents: that its tree root matches
+// a tree rebuilt from Blocks, that every block hashes to its Hash, with
+// bodies fetched back for header-only blocks, and that blocks are ordered
+// by strictly increasing index, or by hash for shards covering a hash
+// range. It reports every discrepancy found, as a *ShardVerificationError,
+// rather than stopping at the first. Transfers append blocks at the end,
+// so a shard that received an older block reports ErrBlockOrder; match it
+// with errors.Is to tell ordering apart from corruption.
+func (s *Shard) VerifySelf() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var problems []error
+	switch {
+	case s.Tree == nil && len(s.Blocks) > 0:
+		problems = append(problems, fmt.Errorf("%w: no tree over %d blocks", ErrShardRootMismatch, len(s.Blocks)))
+	case s.Tree != nil:
+		leaves := make([]string, len(s.Blocks))
+		for i, b := range s.Blocks {
+			leaves[i] = s.Tree.leafOf(b)
+		}
+		if root := buildMerkleTree(s.Tree.hasher(), s.Tree.version(), leaves); root != s.Tree.GetRootHash() {
+			problems = append(problems, fmt.Errorf("%w: stored %s, rebuilt %s", ErrShardRootMismatch, s.Tree.GetRootHash(), root))
+		}
+	}
+
+	byHash := s.RangeEnd != ""
+	for i, b := range s.Blocks {
+		full, err := s.fullBlock(i)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("block #%d at position %d: %w", b.Index, i, err))
+		} else if full.ComputeHash() != b.Hash {
+			problems = append(problems, fmt.Errorf("%w: block #%d at position %d does not hash to %s", ErrInvalidBlock, b.Index, i, b.Hash))
+		}
+		if i == 0 {
+			continue
+		}
+		prev := s.Blocks[i-1]
+		if byHash && b.Hash < prev.Hash {
+			problems = append(problems, fmt.Errorf("%w: hash %s at position %d follows %s", ErrBlockOrder, b.Hash, i, prev.Hash))
+		} else if !byHash && b.Index <= prev.Index {
+			problems = append(problems, fmt.Errorf("%w: block #%d at position %d follows #%d", ErrBlockOrder, b.Index, i, prev.Index))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ShardVerificationError{ShardID: s.ID, Problems: problems}
+	}
+	return nil
+}
+
+// VerifyAll runs VerifySelf on every shard with a pool of parallel
+// workers, at least one, and returns the errors of the shards that failed
+// by shard ID
+func (sm *ShardManager) VerifyAll(parallel int) map[int]error {
+	sm.mutex.RLock()
+	shards := sm.Shards.GetAllShards()
+	sm.mutex.RUnlock()
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	jobs := make(chan *Shard)
+	failed := make(map[int]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range jobs {
+				if err := shard.VerifySelf(); err != nil {
+					mu.Lock()
+					failed[shard.ID] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, shard := range shards {
+		jobs <- shard
+	}
+	close(jobs)
+	wg.Wait()
+	return failed
+}
+
+// LastVerification returns the failures found by the most recent strict
+// mode verification, empty if it passed or none has run
+func (sm *ShardManager) LastVerification() map[int]error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	failed := make(map[int]error, len(sm.lastVerify))
+	for id, err := range sm.lastVerify {
+		failed[id] = err
+	}
+	return failed
+}
+
+// verifyIfStrict runs VerifyAll in strict mode and reports the failures;
+// the caller must not hold the manager lock
+func (sm *ShardManager) verifyIfStrict() {
+	if !sm.strict {
+		return
+	}
+	failed := sm.VerifyAll(DefaultVerifyWorkers)
+	sm.mutex.Lock()
+	sm.lastVerify = failed
+	sm.mutex.Unlock()
+
+	if sm.onEvent != nil {
+		for id, err := range failed {
+			sm.onEvent(ShardEvent{Type: ShardVerificationFailed, ShardID: id, Error: err.Error()})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// verifiedShard returns a shard holding n blocks that verifies
+func verifiedShard(t *testing.T, n int) *Shard {
+	t.Helper()
+	shard := NewShard(5)
+	for _, b := range fixedBlocks(n) {
+		shard.AddBlock(b)
+	}
+	if err := shard.VerifySelf(); err != nil {
+		t.Fatal(err)
+	}
+	return shard
+}
+
+// problems returns the discrepancies of a VerifySelf error
+func problems(t *testing.T, err error) []error {
+	t.Helper()
+	var verr *ShardVerificationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("error %v is not a ShardVerificationError", err)
+	}
+	return verr.Problems
+}
+
+func TestVerifySelfReportsEachCorruption(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		corrupt func(s *Shard)
+		want    []error
+	}{
+		// Tree leaves cover the data and hash, so the root no longer matches either
+		{"data", func(s *Shard) { s.Blocks[2].Data = "corrupted" }, []error{ErrShardRootMismatch, ErrInvalidBlock}},
+		{"stored root", func(s *Shard) { s.Tree.Root = HashBlockData("forged") }, []error{ErrShardRootMismatch}},
+		{"block hash", func(s *Shard) { s.Blocks[3].Hash = HashBlockData("forged") }, []error{ErrShardRootMismatch, ErrInvalidBlock}},
+		{"order", func(s *Shard) { s.Blocks[1], s.Blocks[4] = s.Blocks[4], s.Blocks[1] }, []error{ErrShardRootMismatch, ErrBlockOrder, ErrBlockOrder}},
+		{"no tree", func(s *Shard) { s.Tree = nil }, []error{ErrShardRootMismatch}},
+	} {
+		shard := verifiedShard(t, 6)
+		tc.corrupt(shard)
+		err := shard.VerifySelf()
+		got := problems(t, err)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: %d discrepancies, want %d: %v", tc.name, len(got), len(tc.want), err)
+		}
+		for i, want := range tc.want {
+			if !errors.Is(got[i], want) || !errors.Is(err, want) {
+				t.Fatalf("%s: discrepancy %d is %v, want %v", tc.name, i, got[i], want)
+			}
+		}
+	}
+
+	// Every corruption at once is reported together
+	shard := verifiedShard(t, 6)
+	shard.Blocks[0].Data = "corrupted"
+	shard.Blocks[5].Hash = HashBlockData("forged")
+	shard.Tree.Root = HashBlockData("forged")
+	if got := problems(t, shard.VerifySelf()); len(got) != 3 {
+		t.Fatalf("%d discrepancies, want 3: %v", len(got), got)
+	}
+}
+
+func TestVerifyAllReportsOnlyBrokenShards(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range fixedBlocks(30) {
+		sm.DistributeBlock(b)
+	}
+	shards := sm.Shards.GetAllShards()
+	if len(shards) < 4 {
+		t.Fatalf("%d shards", len(shards))
+	}
+	for _, parallel := range []int{0, 1, 3, 100} {
+		if failed := sm.VerifyAll(parallel); len(failed) != 0 {
+			t.Fatalf("%d workers: %v", parallel, failed)
+		}
+	}
+
+	broken := map[int]bool{shards[0].ID: true, shards[len(shards)-1].ID: true}
+	for _, shard := range shards {
+		if broken[shard.ID] {
+			shard.mutex.Lock()
+			shard.Blocks[0].Data = "corrupted"
+			shard.mutex.Unlock()
+		}
+	}
+	failed := sm.VerifyAll(3)
+	if len(failed) != len(broken) {
+		t.Fatalf("%d shards failed, want %d", len(failed), len(broken))
+	}
+	for id, err := range failed {
+		if !broken[id] || !errors.Is(err, ErrInvalidBlock) {
+			t.Fatalf("shard #%d: %v", id, err)
+		}
+	}
+}
+
+func TestStrictModeVerifiesAfterMergesAndSplits(t *testing.T) {
+	var mu sync.Mutex
+	var events []ShardEvent
+	sm := NewShardManager(WithStrictMode(), WithShardEvents(func(e ShardEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	blocks := fixedBlocks(12)
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+	if failed := sm.LastVerification(); len(failed) != 0 {
+		t.Fatalf("healthy shards failed strict verification: %v", failed)
+	}
+
+	shard := sm.Shards.GetAllShards()[0]
+	shard.mutex.Lock()
+	shard.Blocks[0].Data = "corrupted"
+	shard.mutex.Unlock()
+	sm.MergeShards(100)
+	failed := sm.LastVerification()
+	if len(failed) != 1 {
+		t.Fatalf("%d shards failed after merging, want 1", len(failed))
+	}
+	for id, err := range failed {
+		if !errors.Is(err, ErrInvalidBlock) {
+			t.Fatalf("shard #%d: %v", id, err)
+		}
+		mu.Lock()
+		reported := len(events) > 0 && events[len(events)-1].Type == ShardVerificationFailed && events[len(events)-1].ShardID == id
+		mu.Unlock()
+		if !reported {
+			t.Fatalf("no event for shard #%d in %v", id, events)
+		}
+	}
+
+	// A distribution that splits a shard verifies too
+	split := NewShardManager(WithStrictMode())
+	if err := split.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks[:4] {
+		split.DistributeBlock(b)
+	}
+	split.Shards.GetAllShards()[0].Blocks[0].Data = "corrupted"
+	for _, b := range blocks[4:] {
+		if len(split.LastVerification()) > 0 {
+			break
+		}
+		split.DistributeBlock(b)
+	}
+	if split.Shards.Size() < 2 || len(split.LastVerification()) == 0 {
+		t.Fatalf("%d shards, no failure after the split", split.Shards.Size())
+	}
+
+	// Without strict mode nothing is verified
+	lax := NewShardManager()
+	for _, b := range blocks {
+		lax.DistributeBlock(b)
+	}
+	lax.Shards.GetAllShards()[0].Blocks[0].Data = "corrupted"
+	lax.MergeShards(100)
+	if failed := lax.LastVerification(); len(failed) != 0 {
+		t.Fatalf("verified without strict mode: %v", failed)
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidMutation is returned when a state mutation has an unknown
+// operation or no key
+var ErrInvalidMutation = errors.New("invalid state mutation")
+
+// appKeyPrefix is the trie key prefix of application state, keeping it
+// apart from account fields and receipts
+const appKeyPrefix = "app/"
+
+// MutationOp is the operation of a StateMutation
+type MutationOp string
+
+const (
+	MutationPut    MutationOp = "put"
+	MutationDelete MutationOp = "delete"
+)
+
+// StateMutation is one write of application state carried by a block
+type StateMutation struct {
+	Op    MutationOp `json:"op"`
+	Key   string     `json:"key"`
+	Value string     `json:"value,omitempty"` // Ignored for deletes
+}
+
+// Hash returns the mutation's hash, a leaf of MutationRoot
+func (m StateMutation) Hash() string {
+	record := fmt.Sprintf("%s|%q|%q", m.Op, m.Key, m.Value)
+	hash := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(hash[:])
+}
+
+// check rejects mutations ApplyMutations cannot apply
+func (m StateMutation) check() error {
+	if m.Key == "" {
+		return fmt.Errorf("%w: empty key", ErrInvalidMutation)
+	}
+	if m.Op != MutationPut && m.Op != MutationDelete {
+		return fmt.Errorf("%w: unknown operation %q on %q", ErrInvalidMutation, m.Op, m.Key)
+	}
+	return nil
+}
+
+// MutationRoot returns the Merkle root over the hashes of mutations, in
+// order, which a block commits to in place of the mutations themselves
+func MutationRoot(mutations []StateMutation) string {
+	hashes := make([]string, len(mutations))
+	for i, m := range mutations {
+		hashes[i] = m.Hash()
+	}
+	return NewMerkleTree(hashes).GetRootHash()
+}
+
+// checkMutationRoot verifies a block's MutationRoot against its mutations;
+// blocks carrying neither pass
+func checkMutationRoot(b Block) error {
+	if (b.MutationRoot != "" || len(b.Mutations) > 0) && MutationRoot(b.Mutations) != b.MutationRoot {
+		return fmt.Errorf("%w: block #%d mutations do not match its MutationRoot", ErrInvalidBlock, b.Index)
+	}
+	return nil
+}
+
+// Put stores value under an application key. The trie does not hold empty
+// values, so putting "" deletes the key.
+func (ls *LedgerState) Put(key, value string) {
+	if value == "" {
+		ls.Delete(key)
+		return
+	}
+	ls.trie.Insert(appKeyPrefix+key, value)
+}
+
+// Get returns the value of an application key
+func (ls *LedgerState) Get(key string) (string, bool) {
+	return ls.trie.Get(appKeyPrefix + key)
+}
+
+// Delete removes an application key, reporting whether it was set
+func (ls *LedgerState) Delete(key string) bool {
+	return ls.trie.Delete(appKeyPrefix + key)
+}
+
+// AppState returns every application key and its value
+func (ls *LedgerState) AppState() map[string]string {
+	state := make(map[string]string)
+	for key, value := range ls.trie.GetByPrefix(appKeyPrefix) {
+		state[strings.TrimPrefix(key, appKeyPrefix)] = value
+	}
+	return state
+}
+
+// ApplyMutations applies a block's mutations in order. They are all checked
+// first, so the state is unchanged on error.
+func (ls *LedgerState) ApplyMutations(mutations []StateMutation) error {
+	for i, m := range mutations {
+		if err := m.check(); err != nil {
+			return fmt.Errorf("mutation %d: %w", i, err)
+		}
+	}
+	for _, m := range mutations {
+		switch m.Op {
+		case MutationPut:
+			ls.Put(m.Key, m.Value)
+		case MutationDelete:
+			ls.Delete(m.Key)
+		}
+	}
+	return nil
+}
+
+// ProveState returns the value of an application key with an inclusion
+// proof against the current state root, for VerifyStateProof. A key that is
+// not set gives an empty value and proof.
+func (ls *LedgerState) ProveState(key string) (value string, proof TrieProof, root string) {
+	root = ls.StateRoot()
+	value, ok := ls.Get(key)
+	if !ok {
+		return "", TrieProof{}, root
+	}
+	proof, err := ls.trie.Prove(appKeyPrefix + key)
+	if err != nil {
+		return "", TrieProof{}, root
+	}
+	return value, proof, root
+}
+
+// VerifyStateProof checks a proof from ProveState that an application key
+// holds value in the state with the given root, e.g. a block's StateRoot
+func VerifyStateProof(root, key, value string, proof TrieProof) bool {
+	return VerifyTrieProof(root, appKeyPrefix+key, value, proof)
+}
+
+// GenerateStateBlock creates the successor of prevBlock like
+// GenerateTransactionBlock, additionally carrying mutations, applied after
+// txs and committed to through the block's MutationRoot
+func GenerateStateBlock(prevBlock Block, txs []Transaction, mutations []StateMutation, stateRoot string) (Block, error) {
+	block, err := GenerateTransactionBlock(prevBlock, txs, stateRoot)
+	if err != nil {
+		return Block{}, err
+	}
+	if len(mutations) > 0 {
+		block.Mutations = append([]StateMutation{}, mutations...)
+		block.MutationRoot = MutationRoot(mutations)
+		block.Hash = calculateHash(block)
+	}
+	return block, nil
+}
+
+// AddBlockWithMutations appends a block applying txs and then mutations to
+// the tip state, committing to the resulting state root
+func (bc *Blockchain) AddBlockWithMutations(txs []Transaction, mutations []StateMutation) (Block, error) {
+	return bc.addTransactionBlock(txs, mutations, nil)
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAppStateKeys(t *testing.T) {
+	ls := NewLedgerState()
+	ls.Credit("~alice", 10)
+	ls.Put("~alice", "app value")
+	ls.Put("config/fee", "3")
+	if ls.BalanceOf("~alice") != 10 {
+		t.Fatal("application key overwrote an account")
+	}
+	if value, ok := ls.Get("~alice"); !ok || value != "app value" {
+		t.Fatalf("get: %q, %v", value, ok)
+	}
+	root := ls.StateRoot()
+	ls.Put("config/fee", "")
+	if _, ok := ls.Get("config/fee"); ok || ls.StateRoot() == root {
+		t.Fatal("putting an empty value kept the key")
+	}
+	if !ls.Delete("~alice") || ls.Delete("~alice") {
+		t.Fatal("deleting reports the wrong key state")
+	}
+	if len(ls.AppState()) != 0 || ls.BalanceOf("~alice") != 10 {
+		t.Fatalf("app state %v after deleting every key", ls.AppState())
+	}
+
+	before := ls.StateRoot()
+	err := ls.ApplyMutations([]StateMutation{{Op: MutationPut, Key: "a", Value: "1"}, {Op: "append", Key: "b"}})
+	if !errors.Is(err, ErrInvalidMutation) || ls.StateRoot() != before {
+		t.Fatalf("unknown operation: %v", err)
+	}
+	if err := ls.ApplyMutations([]StateMutation{{Op: MutationPut, Value: "1"}}); !errors.Is(err, ErrInvalidMutation) {
+		t.Fatalf("empty key: %v", err)
+	}
+}
+
+// mutationChain returns a chain of 20 blocks writing application state,
+// some also moving funds, with its genesis state and the app state expected
+func mutationChain(t *testing.T) (*Blockchain, *LedgerState, map[string]string) {
+	t.Helper()
+	genesis := NewLedgerState()
+	genesis.Credit("~alice", 100)
+	bc := NewBlockchain()
+	if err := bc.SetGenesisState(genesis); err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 1; i <= 20; i++ {
+		var mutations []StateMutation
+		for j := 0; j < 3; j++ {
+			key := fmt.Sprintf("key-%d", (i*7+j)%11)
+			if (i+j)%4 == 0 {
+				mutations = append(mutations, StateMutation{Op: MutationDelete, Key: key})
+				delete(want, key)
+			} else {
+				value := fmt.Sprintf("value-%d-%d", i, j)
+				mutations = append(mutations, StateMutation{Op: MutationPut, Key: key, Value: value})
+				want[key] = value
+			}
+		}
+		var txs []Transaction
+		if i%3 == 0 {
+			txs = []Transaction{{From: "~alice", To: "~bob", Amount: 1, Nonce: bc.State().NonceOf("~alice")}}
+		}
+		if _, err := bc.AddBlockWithMutations(txs, mutations); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc, genesis, want
+}
+
+func TestReplayedMutationsReachCommittedRoot(t *testing.T) {
+	bc, genesis, want := mutationChain(t)
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if fmt.Sprint(bc.State().AppState()) != fmt.Sprint(want) || bc.State().StateRoot() != tip.StateRoot {
+		t.Fatalf("app state %v, want %v", bc.State().AppState(), want)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A node replaying the exported chain from genesis reaches the root the
+	// tip committed to
+	var out bytes.Buffer
+	if err := bc.StreamExport(&out, 1); err != nil {
+		t.Fatal(err)
+	}
+	replica := NewBlockchain()
+	if err := replica.SetGenesisState(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if appended, err := replica.StreamImport(&out); err != nil || appended != 20 {
+		t.Fatalf("replayed %d blocks: %v", appended, err)
+	}
+	if replica.State().StateRoot() != tip.StateRoot || fmt.Sprint(replica.State().AppState()) != fmt.Sprint(want) {
+		t.Fatal("replayed state differs from the committed one")
+	}
+	if replica.State().BalanceOf("~bob") != 6 {
+		t.Fatalf("~bob has %d", replica.State().BalanceOf("~bob"))
+	}
+
+	// Reads are proven against a block's committed root
+	for key, value := range want {
+		got, proof, root := replica.State().ProveState(key)
+		if got != value || root != tip.StateRoot || !VerifyStateProof(tip.StateRoot, key, value, proof) {
+			t.Fatalf("proof of %s", key)
+		}
+		if VerifyStateProof(tip.StateRoot, key, value+"x", proof) || VerifyStateProof(bc.Blocks[10].StateRoot, key, value, proof) {
+			t.Fatalf("proof of %s verified a forged read", key)
+		}
+	}
+	if value, proof, _ := replica.State().ProveState("never-set"); value != "" || len(proof.Steps) != 0 {
+		t.Fatal("proof of a key never set")
+	}
+}
+
+func TestTamperedMutationsRejected(t *testing.T) {
+	bc, genesis, _ := mutationChain(t)
+	fresh := func(upTo int) *Blockchain {
+		replica := NewBlockchain()
+		if err := replica.SetGenesisState(genesis); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range bc.Blocks[1:upTo] {
+			if err := replica.AddBlockCandidate(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return replica
+	}
+
+	// Mutations are committed through the block hash
+	edited := bc.Blocks[12]
+	edited.Mutations = append([]StateMutation{}, edited.Mutations...)
+	edited.Mutations[1].Value = "forged"
+	if err := fresh(12).AddBlockCandidate(edited); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("edited mutation: %v", err)
+	}
+	edited.MutationRoot = MutationRoot(edited.Mutations)
+	if err := fresh(12).AddBlockCandidate(edited); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("edited mutation with its root: %v", err)
+	}
+	if edited.ComputeHash() == bc.Blocks[12].Hash {
+		t.Fatal("block hash does not cover MutationRoot")
+	}
+
+	// Rehashed, the block no longer reaches the root it commits to
+	edited.Hash = calculateHash(edited)
+	if err := fresh(12).AddBlockCandidate(edited); !errors.Is(err, ErrStateRootMismatch) {
+		t.Fatalf("rehashed block with edited mutations: %v", err)
+	}
+	reordered := bc.Blocks[12]
+	reordered.Mutations = []StateMutation{reordered.Mutations[2], reordered.Mutations[0], reordered.Mutations[1]}
+	if err := fresh(12).AddBlockCandidate(reordered); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("reordered mutations: %v", err)
+	}
+
+	// A block with an invalid mutation is never made
+	replica := fresh(21)
+	if _, err := replica.AddBlockWithMutations(nil, []StateMutation{{Op: MutationPut, Value: "v"}}); !errors.Is(err, ErrInvalidMutation) || len(replica.Blocks) != 21 {
+		t.Fatalf("mutation without a key: %v", err)
+	}
+
+	// Validation checks the mutations of blocks already held
+	bc.Blocks[20].Mutations = bc.Blocks[12].Mutations
+	if err := bc.Validate(); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("validating a chain with edited mutations: %v", err)
+	}
+}
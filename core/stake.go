@@ -0,0 +1,322 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors returned by the stake registry
+var (
+	ErrZeroStake         = errors.New("stake amount must be positive")
+	ErrInsufficientStake = errors.New("insufficient bonded stake")
+	ErrStakeOverflow     = errors.New("stake overflow")
+)
+
+// DefaultUnbondingDelay is how long unbonded stake stays slashable before
+// it can be withdrawn
+const DefaultUnbondingDelay = 24 * time.Hour
+
+// EquivocationSlashPercent is the share of a node's stake, bonded and
+// unbonding, burned for equivocating
+const EquivocationSlashPercent = 50
+
+// unbonding is stake leaving the registry once its delay has passed
+type unbonding struct {
+	amount  uint64
+	release time.Time
+}
+
+// StakeRegistry tracks the stake bonded by each node. Unbonded stake no
+// longer counts for selection but can still be slashed until it is released.
+type StakeRegistry struct {
+	bonded    map[int]uint64
+	unbonding map[int][]unbonding
+	delay     time.Duration
+	now       func() time.Time
+	mutex     sync.RWMutex
+}
+
+// StakeOption configures a StakeRegistry
+type StakeOption func(*StakeRegistry)
+
+// WithUnbondingDelay sets how long unbonded stake waits before withdrawal
+func WithUnbondingDelay(delay time.Duration) StakeOption {
+	return func(r *StakeRegistry) {
+		r.delay = delay
+	}
+}
+
+// WithStakeClock replaces the clock used for unbonding delays
+func WithStakeClock(now func() time.Time) StakeOption {
+	return func(r *StakeRegistry) {
+		r.now = now
+	}
+}
+
+// NewStakeRegistry creates an empty registry
+func NewStakeRegistry(opts ...StakeOption) *StakeRegistry {
+	r := &StakeRegistry{
+		bonded:    make(map[int]uint64),
+		unbonding: make(map[int][]unbonding),
+		delay:     DefaultUnbondingDelay,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Bond adds stake for a node
+func (r *StakeRegistry) Bond(nodeID int, amount uint64) error {
+	if amount == 0 {
+		return ErrZeroStake
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.bonded[nodeID] > math.MaxUint64-amount {
+		return fmt.Errorf("%w: node #%d", ErrStakeOverflow, nodeID)
+	}
+	r.bonded[nodeID] += amount
+	return nil
+}
+
+// Unbond starts releasing stake from a node. The amount stops counting for
+// selection at once and can be withdrawn after the unbonding delay.
+func (r *StakeRegistry) Unbond(nodeID int, amount uint64) error {
+	if amount == 0 {
+		return ErrZeroStake
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.bonded[nodeID] < amount {
+		return fmt.Errorf("%w: node #%d has %d, needs %d", ErrInsufficientStake, nodeID, r.bonded[nodeID], amount)
+	}
+	r.bonded[nodeID] -= amount
+	if r.bonded[nodeID] == 0 {
+		delete(r.bonded, nodeID)
+	}
+	r.unbonding[nodeID] = append(r.unbonding[nodeID], unbonding{amount: amount, release: r.now().Add(r.delay)})
+	return nil
+}
+
+// Withdraw releases a node's unbonding stake whose delay has passed and
+// returns the amount
+func (r *StakeRegistry) Withdraw(nodeID int) uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.now()
+	var released uint64
+	pending := r.unbonding[nodeID][:0]
+	for _, u := range r.unbonding[nodeID] {
+		if now.Before(u.release) {
+			pending = append(pending, u)
+		} else {
+			released += u.amount
+		}
+	}
+	if len(pending) == 0 {
+		delete(r.unbonding, nodeID)
+	} else {
+		r.unbonding[nodeID] = pending
+	}
+	return released
+}
+
+// Slash burns percent of a node's bonded and unbonding stake and returns
+// the amount burned
+func (r *StakeRegistry) Slash(nodeID int, percent uint64) uint64 {
+	if percent > 100 {
+		percent = 100
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	cut := func(amount uint64) uint64 {
+		// Split to avoid overflowing amount*percent
+		return amount/100*percent + amount%100*percent/100
+	}
+
+	slashed := cut(r.bonded[nodeID])
+	r.bonded[nodeID] -= slashed
+	if r.bonded[nodeID] == 0 {
+		delete(r.bonded, nodeID)
+	}
+	for i := range r.unbonding[nodeID] {
+		c := cut(r.unbonding[nodeID][i].amount)
+		r.unbonding[nodeID][i].amount -= c
+		slashed += c
+	}
+	return slashed
+}
+
+// Stake returns a node's bonded stake
+func (r *StakeRegistry) Stake(nodeID int) uint64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.bonded[nodeID]
+}
+
+// Unbonding returns a node's stake waiting to be withdrawn
+func (r *StakeRegistry) Unbonding(nodeID int) uint64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var total uint64
+	for _, u := range r.unbonding[nodeID] {
+		total += u.amount
+	}
+	return total
+}
+
+// TotalStake returns the stake bonded across all nodes
+func (r *StakeRegistry) TotalStake() uint64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var total uint64
+	for _, amount := range r.bonded {
+		total += amount
+	}
+	return total
+}
+
+// ParticipantSelector picks the consensus participants and the leader for
+// a round from the VRF nonce
+type ParticipantSelector interface {
+	SelectParticipants(nodes []*Node, nonce string) []*Node
+	SelectLeader(nodes []*Node, nonce string) *Node
+}
+
+// ReputationSelector picks honest nodes with reputation above
+// MinParticipantReputation and elects the leader by reputation under
+// ReputationWeighted
+type ReputationSelector struct {
+	Mode       ElectionMode
+	MinWeight  float64             // Weight floor under ReputationWeighted
+	Reputation func(*Node) float64 // Optional, e.g. BFTManager.Reputation; nil reads Node.Reputation
+}
+
+// SelectParticipants implements ParticipantSelector
+func (s ReputationSelector) SelectParticipants(nodes []*Node, _ string) []*Node {
+	var selected []*Node
+	for _, node := range nodes {
+		if !node.Byzantine && s.reputation(node) > MinParticipantReputation {
+			selected = append(selected, node)
+		}
+	}
+	return selected
+}
+
+// SelectLeader implements ParticipantSelector by picking the honest node
+// with the highest VRF score for nonce. Under ReputationWeighted the score
+// u is raised to 1/weight, which makes each node's chance of the highest
+// score proportional to its weight.
+func (s ReputationSelector) SelectLeader(nodes []*Node, nonce string) *Node {
+	var leader *Node
+	highestScore := -1.0
+	for _, node := range nodes {
+		if node.Byzantine {
+			continue
+		}
+		score := vrfScore(nonce, node.ID)
+		if s.Mode == ReputationWeighted {
+			score = math.Pow(score, 1/s.weight(node))
+		}
+		if score > highestScore {
+			highestScore = score
+			leader = node
+		}
+	}
+	return leader
+}
+
+func (s ReputationSelector) String() string {
+	return s.Mode.String()
+}
+
+// weight is a node's reputation clamped to [MinWeight, 1]
+func (s ReputationSelector) weight(node *Node) float64 {
+	return math.Max(math.Min(s.reputation(node), 1), s.MinWeight)
+}
+
+// reputation returns a node's reputation as the selector sees it
+func (s ReputationSelector) reputation(node *Node) float64 {
+	if s.Reputation != nil {
+		return s.Reputation(node)
+	}
+	return node.Reputation
+}
+
+// StakeWeightedSelector samples participants and the leader with chances
+// proportional to bonded stake. Nodes without stake are never picked.
+type StakeWeightedSelector struct {
+	Registry  *StakeRegistry
+	Committee int // Participants per round; 0 selects every staked node
+}
+
+func (s StakeWeightedSelector) String() string {
+	return "StakeWeighted"
+}
+
+// SelectParticipants implements ParticipantSelector. Participants are the
+// Committee nodes with the highest stake-weighted VRF scores, which samples
+// without replacement in proportion to stake.
+func (s StakeWeightedSelector) SelectParticipants(nodes []*Node, nonce string) []*Node {
+	type scored struct {
+		node  *Node
+		score float64
+	}
+	var candidates []scored
+	for _, node := range nodes {
+		if score, ok := s.score(node, nonce); ok {
+			candidates = append(candidates, scored{node, score})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	if s.Committee > 0 && len(candidates) > s.Committee {
+		candidates = candidates[:s.Committee]
+	}
+	selected := make([]*Node, len(candidates))
+	for i, c := range candidates {
+		selected[i] = c.node
+	}
+	return selected
+}
+
+// SelectLeader implements ParticipantSelector
+func (s StakeWeightedSelector) SelectLeader(nodes []*Node, nonce string) *Node {
+	var leader *Node
+	highestScore := -1.0
+	for _, node := range nodes {
+		if score, ok := s.score(node, "leader:"+nonce); ok && score > highestScore {
+			highestScore = score
+			leader = node
+		}
+	}
+	return leader
+}
+
+// score is u^(1/stake) for the node's VRF fraction u, compared in log space
+// so that large stakes keep their precision
+func (s StakeWeightedSelector) score(node *Node, nonce string) (float64, bool) {
+	stake := s.Registry.Stake(node.ID)
+	if stake == 0 {
+		return 0, false
+	}
+	u := vrfScore(nonce, node.ID)
+	if u == 0 {
+		return math.Inf(-1), true
+	}
+	return math.Log(u) / float64(stake), true
+}
+
+// vrfScore hashes the nonce and a node ID into a uniform fraction in [0, 1)
+func vrfScore(nonce string, nodeID int) float64 {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", nonce, nodeID)))
+	// Top 53 bits give a uniform float64 fraction
+	return float64(binary.BigEndian.Uint64(hash[:8])>>11) / (1 << 53)
+}
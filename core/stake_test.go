@@ -0,0 +1,167 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// stakedNodes bonds 100, 200 and 700 to nodes 0-2 and leaves node 3 unstaked
+func stakedNodes(t *testing.T) (*StakeRegistry, []*Node) {
+	t.Helper()
+	registry := NewStakeRegistry()
+	for id, amount := range []uint64{100, 200, 700} {
+		if err := registry.Bond(id, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return registry, []*Node{{ID: 0}, {ID: 1}, {ID: 2}, {ID: 3}}
+}
+
+func TestStakeWeightedLeaderFrequencies(t *testing.T) {
+	const elections, tolerance = 20000, 0.02
+	registry, nodes := stakedNodes(t)
+	cm := NewConsensusManager(&BFTManager{Nodes: nodes}, WithParticipantSelector(StakeWeightedSelector{Registry: registry}))
+
+	freq := electionFrequencies(cm, elections)
+	for id, want := range map[int]float64{0: 0.1, 1: 0.2, 2: 0.7, 3: 0} {
+		if math.Abs(freq[id]-want) > tolerance {
+			t.Errorf("node #%d elected %.3f of rounds, want %.2f", id, freq[id], want)
+		}
+	}
+
+	// Swapping the strategy back restores reputation-based election
+	cm.SetParticipantSelector(nil)
+	for _, node := range nodes {
+		node.Reputation = 1
+	}
+	if freq := electionFrequencies(cm, elections); math.Abs(freq[3]-0.25) > tolerance {
+		t.Fatalf("unstaked node elected %.3f of rounds by reputation", freq[3])
+	}
+}
+
+func TestStakeWeightedCommitteeSampling(t *testing.T) {
+	const rounds = 20000
+	registry, nodes := stakedNodes(t)
+	selector := StakeWeightedSelector{Registry: registry, Committee: 2}
+
+	included := make(map[int]int)
+	for i := 0; i < rounds; i++ {
+		committee := selector.SelectParticipants(nodes, fmt.Sprintf("round-%d", i))
+		if len(committee) != 2 || committee[0].ID == committee[1].ID {
+			t.Fatalf("round %d committee %v", i, committee)
+		}
+		for _, node := range committee {
+			included[node.ID]++
+		}
+	}
+	if included[3] != 0 {
+		t.Fatal("unstaked node sampled into a committee")
+	}
+	if included[2] <= included[1] || included[1] <= included[0] {
+		t.Fatalf("inclusion counts %v do not follow stake", included)
+	}
+	// Sampling 2 of 3 without replacement, the 700 stake is left out only
+	// when both others are drawn first: .1*.2/.9 + .2*.1/.8 ≈ 0.047
+	if left := 1 - float64(included[2])/rounds; math.Abs(left-0.047) > 0.01 {
+		t.Fatalf("largest staker left out of %.3f of committees", left)
+	}
+
+	// The same nonce always yields the same committee
+	a, b := selector.SelectParticipants(nodes, "nonce"), selector.SelectParticipants(nodes, "nonce")
+	if a[0] != b[0] || a[1] != b[1] {
+		t.Fatal("committee selection is not deterministic")
+	}
+	if all := (StakeWeightedSelector{Registry: registry}).SelectParticipants(nodes, "nonce"); len(all) != 3 {
+		t.Fatalf("uncapped committee has %d members", len(all))
+	}
+}
+
+func TestStakeBondingAndUnbonding(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	registry := NewStakeRegistry(WithUnbondingDelay(time.Hour), WithStakeClock(func() time.Time { return now }))
+
+	if err := registry.Bond(1, 0); !errors.Is(err, ErrZeroStake) {
+		t.Fatalf("zero bond: %v", err)
+	}
+	if err := registry.Bond(1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Bond(1, math.MaxUint64); !errors.Is(err, ErrStakeOverflow) {
+		t.Fatalf("overflowing bond: %v", err)
+	}
+	if err := registry.Unbond(1, 1001); !errors.Is(err, ErrInsufficientStake) {
+		t.Fatalf("unbonding more than bonded: %v", err)
+	}
+	if err := registry.Unbond(1, 400); err != nil {
+		t.Fatal(err)
+	}
+	if registry.Stake(1) != 600 || registry.Unbonding(1) != 400 || registry.TotalStake() != 600 {
+		t.Fatalf("stake %d, unbonding %d", registry.Stake(1), registry.Unbonding(1))
+	}
+
+	now = now.Add(time.Hour - time.Nanosecond)
+	if got := registry.Withdraw(1); got != 0 {
+		t.Fatalf("withdrew %d before the delay", got)
+	}
+	now = now.Add(time.Nanosecond)
+	if got := registry.Withdraw(1); got != 400 || registry.Unbonding(1) != 0 {
+		t.Fatalf("withdrew %d after the delay", got)
+	}
+}
+
+func TestEquivocationSlashesStake(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	registry := NewStakeRegistry(WithUnbondingDelay(time.Hour), WithStakeClock(func() time.Time { return now }))
+	bft := behaviorBFT(5, WithStakeRegistry(registry))
+	for _, node := range bft.Nodes {
+		if err := registry.Bond(node.ID, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unbonding does not escape slashing while the delay runs
+	if err := registry.Unbond(0, 400); err != nil {
+		t.Fatal(err)
+	}
+
+	// Equivocation caught by the BFT layer during a round slashes the node
+	bft.Nodes[0].Behavior = EquivocatingBehavior{}
+	if _, err := bft.Decide(0, 1, "block-1"); err != nil {
+		t.Fatal(err)
+	}
+	if registry.Stake(0) != 300 || registry.Unbonding(0) != 200 {
+		t.Fatalf("slashed node has %d bonded and %d unbonding", registry.Stake(0), registry.Unbonding(0))
+	}
+	for _, node := range bft.Nodes[1:] {
+		if registry.Stake(node.ID) != 1000 {
+			t.Fatalf("honest node #%d slashed to %d", node.ID, registry.Stake(node.ID))
+		}
+	}
+	now = now.Add(time.Hour)
+	if got := registry.Withdraw(0); got != 200 {
+		t.Fatalf("withdrew %d after slashing", got)
+	}
+
+	// Evidence submitted directly slashes again; forged evidence does not
+	node := bft.Nodes[1]
+	action := EquivocatingBehavior{}.OnPropose(node, 2, 2, "block-2")
+	if err := bft.RecordEquivocation(EquivocationEvidence{First: action.Votes[0], Second: action.Votes[1]}); err != nil {
+		t.Fatal(err)
+	}
+	if registry.Stake(1) != 500 {
+		t.Fatalf("node #1 has %d after slashing", registry.Stake(1))
+	}
+	forged := bft.Nodes[3].SignVote(PrepareVote, 2, 2, "other")
+	forged.NodeID = 2
+	if err := bft.RecordEquivocation(EquivocationEvidence{First: bft.Nodes[2].SignVote(PrepareVote, 2, 2, "block-2"), Second: forged}); err == nil {
+		t.Fatal("forged evidence accepted")
+	}
+	if registry.Stake(2) != 1000 {
+		t.Fatalf("node #2 slashed to %d by forged evidence", registry.Stake(2))
+	}
+	if got := registry.Slash(3, 250); got != 1000 || registry.Stake(3) != 0 {
+		t.Fatalf("slashing over 100%% burned %d", got)
+	}
+}
@@ -1,57 +1,216 @@
 package core
 
 import (
+	"crypto/ed25519"
 	"fmt"
+	"io"
+	"time"
 )
 
 type ArchivedBlock struct {
-	Index int
-	Data  string
-	Hash  string
+	Index     int
+	Data      string // Empty when the body was moved to the body archive
+	DataHash  string `json:",omitempty"` // Set when only the header was kept
+	Hash      string
+	Timestamp time.Time
 }
 
 type StateManager struct {
 	PrunedBlocks   []ArchivedBlock
 	ActiveBlocks   []Block
 	ActiveTrie     *SuccinctTrie // Trie for active blocks
-	ArchiveTrie    *SuccinctTrie // Trie for archived blocks
+	ArchiveTrie    Trie          // Trie for archived blocks
 	MaxActiveCount int
+	Bodies         BodyStore    // Optional; archived blocks keep only their header and move their body here
+	Segments       []SegmentRef // Archive segments sealed by CompactArchive, oldest first
+	Sink           ArchiveSink  // Optional; where sealed segments are written
+	segmentKey     ed25519.PrivateKey
+	policy         ArchivePolicy     // Which active block to archive on overflow
+	accesses       map[string]uint64 // Reads per block hash, see AccessStats
+	lastUsed       map[string]uint64 // Tick of each active block's latest read or insertion
+	ticks          uint64
 }
 
-func NewStateManager(maxActive int) *StateManager {
-	return &StateManager{
+// StateManagerOption configures a StateManager
+type StateManagerOption func(*StateManager)
+
+// WithArchiveTrie stores archived blocks in t, e.g. a LazyTrie backed by disk
+func WithArchiveTrie(t Trie) StateManagerOption {
+	return func(sm *StateManager) {
+		sm.ArchiveTrie = t
+	}
+}
+
+// WithBodyArchive moves the bodies of archived blocks into store, keeping
+// only their headers in PrunedBlocks and the archive trie
+func WithBodyArchive(store BodyStore) StateManagerOption {
+	return func(sm *StateManager) {
+		sm.Bodies = store
+	}
+}
+
+func NewStateManager(maxActive int, opts ...StateManagerOption) *StateManager {
+	sm := &StateManager{
 		PrunedBlocks:   []ArchivedBlock{},
 		ActiveBlocks:   []Block{},
 		ActiveTrie:     NewSuccinctTrie(),
 		ArchiveTrie:    NewSuccinctTrie(),
 		MaxActiveCount: maxActive,
 	}
+	for _, opt := range opts {
+		opt(sm)
+	}
+	return sm
 }
 
 // AddBlock adds a new block and prunes if limit exceeded
 func (sm *StateManager) AddBlock(block Block) {
 	sm.ActiveBlocks = append(sm.ActiveBlocks, block)
 	// Insert block into active trie (key: block hash, value: block data)
-	sm.ActiveTrie.Insert(block.Hash, block.Data)
+	sm.ActiveTrie.Insert(block.Hash, stateValue(block))
+	sm.markUsed(block.Hash)
 
 	if len(sm.ActiveBlocks) > sm.MaxActiveCount {
-		archived := sm.ActiveBlocks[0]
-		sm.PrunedBlocks = append(sm.PrunedBlocks, ArchivedBlock{
-			Index: archived.Index,
-			Data:  archived.Data,
-			Hash:  archived.Hash,
-		})
-		// Move to archive trie
-		sm.ArchiveTrie.Insert(archived.Hash, archived.Data)
+		sm.archiveAt(sm.coldest())
+	}
+}
+
+// emptyTrieLike returns an empty trie of t's kind with its hash function; a
+// LazyTrie keeps writing to t's node store
+func emptyTrieLike(t Trie) Trie {
+	switch t := t.(type) {
+	case *LazyTrie:
+		lazy := &LazyTrie{store: t.store, hashFn: t.hashFn}
+		lazy.root, lazy.err = lazy.save(lazyNode{})
+		return lazy
+	case *SuccinctTrie:
+		return NewSuccinctTrie(WithTrieHasher(t.hashFn))
+	}
+	return NewSuccinctTrie()
+}
+
+// rebuildStateManager builds a StateManager over blocks, archiving the ones
+// any of the sources had archived and then enforcing maxActive under the
+// sources' archive policy and read counts. The archive trie is of the first
+// source's kind, so a disk-backed archive stays on disk.
+func rebuildStateManager(maxActive int, blocks []Block, sources ...*StateManager) *StateManager {
+	archived := make(map[string]bool)
+	sealed := make(map[string]bool)
+	held := make(map[string]bool, len(blocks))
+	for _, b := range blocks {
+		held[b.Hash] = true
+	}
+	sm := NewStateManager(maxActive)
+	carried := false
+	for _, source := range sources {
+		if source == nil {
+			continue
+		}
+		if !carried && source.ArchiveTrie != nil {
+			sm.ArchiveTrie = emptyTrieLike(source.ArchiveTrie)
+			carried = true
+		}
+		for _, b := range source.PrunedBlocks {
+			archived[b.Hash] = true
+		}
+		for _, ref := range source.Segments {
+			if !sealed[ref.Root] {
+				sealed[ref.Root] = true
+				sm.Segments = append(sm.Segments, ref)
+			}
+		}
+		if sm.Bodies == nil {
+			sm.Bodies = source.Bodies
+		}
+		if sm.Sink == nil {
+			sm.Sink = source.Sink
+		}
+		if sm.segmentKey == nil {
+			sm.segmentKey = source.segmentKey
+		}
+		if sm.policy == "" {
+			sm.policy = source.policy
+		}
+		for hash, count := range source.accesses {
+			if held[hash] {
+				sm.recordAccesses(hash, count)
+			}
+		}
+	}
+
+	for _, b := range blocks {
+		if sm.compacted(b.Index) {
+			continue
+		}
+		if archived[b.Hash] {
+			sm.archive(b)
+			continue
+		}
+		sm.AddBlock(b)
+	}
+	return sm
+}
+
+// archiveOldest moves the oldest active block into the archive
+func (sm *StateManager) archiveOldest() {
+	sm.archiveAt(0)
+}
+
+// archiveAt moves the active block at position i into the archive
+func (sm *StateManager) archiveAt(i int) {
+	archived := sm.ActiveBlocks[i]
+	sm.archive(archived)
+	sm.ActiveTrie.Delete(archived.Hash)
+	delete(sm.lastUsed, archived.Hash)
+	if i == 0 {
 		sm.ActiveBlocks = sm.ActiveBlocks[1:]
-		// Remove from active trie (optional, or keep for history)
-		// For simplicity, we keep it in active trie but rely on ActiveBlocks for state
+		return
 	}
+	sm.ActiveBlocks = append(sm.ActiveBlocks[:i:i], sm.ActiveBlocks[i+1:]...)
+}
+
+// archive records a block in the archive list and trie, moving its body to
+// the body archive if there is one
+func (sm *StateManager) archive(b Block) {
+	if sm.Bodies != nil && !b.HeaderOnly() {
+		if err := sm.Bodies.PutBody(b.Hash, b.Body()); err == nil {
+			b = b.Header().Block()
+		}
+	}
+	sm.PrunedBlocks = append(sm.PrunedBlocks, ArchivedBlock{
+		Index:     b.Index,
+		Data:      b.Data,
+		DataHash:  b.DataHash,
+		Hash:      b.Hash,
+		Timestamp: b.Timestamp,
+	})
+	sm.ArchiveTrie.Insert(b.Hash, stateValue(b))
+}
+
+// stateValue is what the tries store for a block: its data, or its
+// DataHash when only the header is kept
+func stateValue(b Block) string {
+	if b.HeaderOnly() {
+		return b.DataHash
+	}
+	return b.Data
+}
+
+// ArchiveOlderThan archives active blocks older than maxAge and returns the count
+func (sm *StateManager) ArchiveOlderThan(maxAge time.Duration) int {
+	count := 0
+	for len(sm.ActiveBlocks) > 0 && sm.ActiveBlocks[0].Age() > maxAge {
+		sm.archiveOldest()
+		count++
+	}
+	return count
 }
 
 // UpdateArchiveRoot sets the archive root to the trie’s Merkle root
 func (sm *StateManager) UpdateArchiveRoot() {
-	sm.ArchiveTrie.updateHashes(sm.ArchiveTrie.Root)
+	if trie, ok := sm.ArchiveTrie.(*SuccinctTrie); ok {
+		trie.updateHashes(trie.Root)
+	}
 }
 
 // GetActiveRoot returns the Merkle root of active blocks
@@ -59,24 +218,78 @@ func (sm *StateManager) GetActiveRoot() string {
 	return sm.ActiveTrie.GetMerkleRoot()
 }
 
-// GetArchiveRoot returns the Merkle root of archived blocks
+// GetArchiveRoot returns the Merkle root of archived blocks. Once segments
+// have been compacted, it is the root of a Merkle tree over the segment
+// roots followed by the live archive trie's root.
 func (sm *StateManager) GetArchiveRoot() string {
-	return sm.ArchiveTrie.GetMerkleRoot()
+	if len(sm.Segments) == 0 {
+		return sm.ArchiveTrie.GetMerkleRoot()
+	}
+	roots := make([]string, 0, len(sm.Segments)+1)
+	for _, ref := range sm.Segments {
+		roots = append(roots, ref.Root)
+	}
+	return NewMerkleTree(append(roots, sm.ArchiveTrie.GetMerkleRoot())).Root
+}
+
+// ActiveKeys returns the hashes of active blocks in key order
+func (sm *StateManager) ActiveKeys() []string {
+	return sm.ActiveTrie.Keys()
+}
+
+// ArchivedKeys returns the hashes of archived blocks in key order
+func (sm *StateManager) ArchivedKeys() []string {
+	return sm.ArchiveTrie.Keys()
+}
+
+// StateEntry is one active block in a StateReport
+type StateEntry struct {
+	Hash string `json:"hash"`
+	Data string `json:"data"`
+}
+
+// StateReport is a serializable snapshot of the active and archived state
+type StateReport struct {
+	ActiveCount   int          `json:"active_count"`
+	ActiveRoot    string       `json:"active_root"`
+	ArchivedCount int          `json:"archived_count"`
+	ArchiveRoot   string       `json:"archive_root"`
+	ActiveBlocks  []StateEntry `json:"active_blocks"` // In trie key order
+	ActiveTrie    TrieDump     `json:"active_trie"`
+	ArchiveTrie   TrieDump     `json:"archive_trie"`
+}
+
+// State returns the current active state and archive status
+func (sm *StateManager) State() StateReport {
+	report := StateReport{
+		ActiveRoot:  sm.GetActiveRoot(),
+		ArchiveRoot: sm.GetArchiveRoot(),
+		ActiveTrie:  sm.ActiveTrie.Dump(),
+		ArchiveTrie: sm.ArchiveTrie.Dump(),
+	}
+	sm.ActiveTrie.Iterate(func(hash, data string) bool {
+		report.ActiveBlocks = append(report.ActiveBlocks, StateEntry{Hash: hash, Data: data})
+		return true
+	})
+	report.ActiveCount = len(report.ActiveBlocks)
+	report.ArchivedCount = len(sm.ArchivedKeys())
+	return report
 }
 
-// PrintState displays current active state and archive status
-func (sm *StateManager) PrintState() {
-	fmt.Println("\n--- State Manager ---")
-	fmt.Printf("Active Blocks: %d\n", len(sm.ActiveBlocks))
-	for _, b := range sm.ActiveBlocks {
-		fmt.Printf("Block #%d - Hash: %s\n", b.Index, b.Hash)
+// PrintState writes the current active state and archive status to w
+func (sm *StateManager) PrintState(w io.Writer) {
+	report := sm.State()
+	fmt.Fprintln(w, "\n--- State Manager ---")
+	fmt.Fprintf(w, "Active Blocks: %d\n", report.ActiveCount)
+	for _, entry := range report.ActiveBlocks {
+		fmt.Fprintf(w, "Block %s - Data: %s\n", entry.Hash, entry.Data)
 	}
-	fmt.Printf("Active Trie Merkle Root: %s\n", sm.GetActiveRoot())
-	fmt.Printf("Archived Blocks: %d\n", len(sm.PrunedBlocks))
-	fmt.Printf("Archive Trie Merkle Root: %s\n", sm.GetArchiveRoot())
+	fmt.Fprintf(w, "Active Trie Merkle Root: %s\n", report.ActiveRoot)
+	fmt.Fprintf(w, "Archived Blocks: %d\n", report.ArchivedCount)
+	fmt.Fprintf(w, "Archive Trie Merkle Root: %s\n", report.ArchiveRoot)
 	// Print trie structures for debugging
-	fmt.Println("\nActive Trie Structure:")
-	sm.ActiveTrie.PrintTrie()
-	fmt.Println("\nArchive Trie Structure:")
-	sm.ArchiveTrie.PrintTrie()
+	fmt.Fprintln(w, "\nActive Trie Structure:")
+	writeTrieNode(w, report.ActiveTrie.Root, 0)
+	fmt.Fprintln(w, "\nArchive Trie Structure:")
+	writeTrieNode(w, report.ArchiveTrie.Root, 0)
 }
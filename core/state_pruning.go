@@ -1,9 +1,12 @@
 package core
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -12,14 +15,26 @@ type PruningPolicy struct {
 	MaxHeight      int
 	RetentionCount int
 	UseCheckpoints bool
+	MinAge         time.Duration // Blocks younger than this are never pruned (0 disables)
 }
 
+// Integrity proof signature schemes
+const (
+	SchemeEd25519 = "ed25519"
+	SchemeHMAC    = "hmac"
+)
+
 // IntegrityProof represents cryptographic proof of pruned state
 type IntegrityProof struct {
-	RootHash    string
-	PrunedCount int
-	Timestamp   time.Time
-	Signature   string
+	RootHash         string
+	PrunedCount      int
+	Timestamp        time.Time
+	Signature        string // Hex ed25519 signature, or an HMAC commitment
+	Scheme           string // SchemeEd25519 or SchemeHMAC; empty for legacy HMAC proofs
+	KeyFingerprint   string // Fingerprint of the ed25519 public key that signed
+	AccumulatorState string `json:",omitempty"` // Hex global commitment state when pruned, see WithGlobalCommitment
+	Sharded          bool   `json:",omitempty"` // Set by PruneShard; RootHash is then a shard tree root
+	ShardID          int    `json:",omitempty"` // Shard the blocks were pruned from, if Sharded
 }
 
 // StatePruner manages blockchain state pruning with integrity proofs
@@ -27,38 +42,133 @@ type StatePruner struct {
 	policy          PruningPolicy
 	integrityProofs []IntegrityProof
 	merkleRoot      string
-	secretKey       string
+	signer          ed25519.PrivateKey            // Signs integrity proofs
+	auth            *HomomorphicAuthenticator     // HMAC keyring used instead of signer when set
+	commitments     *GlobalCommitmentManager      // Optional; its state is recorded in integrity proofs
+	onShardPrune    func(IntegrityProof, []Block) // Optional; receives blocks removed by PruneShard
 }
 
-// NewStatePruner creates a new state pruner
-func NewStatePruner(maxHeight, retention int, useCheckpoints bool) *StatePruner {
-	return &StatePruner{
+// DefaultPruningKey is the key used to sign integrity proofs in HMAC mode
+const DefaultPruningKey = "pruning-integrity-key"
+
+// StatePrunerOption configures a StatePruner
+type StatePrunerOption func(*StatePruner)
+
+// WithSigningKey signs integrity proofs with an existing ed25519 key instead
+// of a freshly generated one
+func WithSigningKey(key ed25519.PrivateKey) StatePrunerOption {
+	return func(sp *StatePruner) {
+		sp.signer = key
+	}
+}
+
+// WithHMACKeyring signs integrity proofs with a shared-secret keyring, as
+// pruners did before ed25519 signatures. Verifiers then need the secret too.
+func WithHMACKeyring(auth *HomomorphicAuthenticator) StatePrunerOption {
+	return func(sp *StatePruner) {
+		sp.auth = auth
+	}
+}
+
+// WithGlobalCommitment records the state of g in every integrity proof,
+// after accumulating the current forest root, so forest roots from before
+// the pruning stay provable against a signed accumulator state
+func WithGlobalCommitment(g *GlobalCommitmentManager) StatePrunerOption {
+	return func(sp *StatePruner) {
+		sp.commitments = g
+	}
+}
+
+// NewStatePruner creates a new state pruner signing integrity proofs with
+// ed25519, so auditors can verify them with only the public key
+func NewStatePruner(maxHeight, retention int, useCheckpoints bool, opts ...StatePrunerOption) *StatePruner {
+	sp := &StatePruner{
 		policy: PruningPolicy{
 			MaxHeight:      maxHeight,
 			RetentionCount: retention,
 			UseCheckpoints: useCheckpoints,
 		},
 		integrityProofs: []IntegrityProof{},
-		secretKey:       "pruning-integrity-key", // In production, use secure key management
 	}
+	for _, opt := range opts {
+		opt(sp)
+	}
+	if sp.auth == nil && sp.signer == nil {
+		// In production, load the key from secure key management
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(fmt.Sprintf("generating pruning key: %v", err))
+		}
+		sp.signer = key
+	}
+	return sp
+}
+
+// NewStatePrunerWithKeyring creates a state pruner signing integrity proofs
+// with an HMAC keyring, so proofs from before a key rotation stay verifiable
+func NewStatePrunerWithKeyring(maxHeight, retention int, useCheckpoints bool, auth *HomomorphicAuthenticator) *StatePruner {
+	return NewStatePruner(maxHeight, retention, useCheckpoints, WithHMACKeyring(auth))
+}
+
+// PublicKey returns the key auditors use to verify this pruner's proofs, or
+// nil in HMAC mode
+func (sp *StatePruner) PublicKey() ed25519.PublicKey {
+	if sp.auth != nil {
+		return nil
+	}
+	return sp.signer.Public().(ed25519.PublicKey)
+}
+
+// KeyFingerprint identifies an ed25519 public key by the first 8 bytes of
+// its SHA-256 hash
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	hash := sha256.Sum256(pub)
+	return hex.EncodeToString(hash[:8])
+}
+
+// SetMinAge configures age-based retention for pruning
+func (sp *StatePruner) SetMinAge(minAge time.Duration) {
+	sp.policy.MinAge = minAge
 }
 
 // createIntegrityProof generates a cryptographic proof for pruned data
 func (sp *StatePruner) createIntegrityProof(rootHash string, count int) IntegrityProof {
-	h := sha256.New()
-	h.Write([]byte(rootHash))
-	h.Write([]byte(fmt.Sprintf("%d", count)))
-	h.Write([]byte(sp.secretKey))
-	signature := hex.EncodeToString(h.Sum(nil))
-	
-	return IntegrityProof{
+	sp.updateCommitments()
+	return sp.signIntegrityProof(IntegrityProof{
 		RootHash:    rootHash,
 		PrunedCount: count,
-		Timestamp:   time.Now(),
-		Signature:   signature,
+	})
+}
+
+// updateCommitments accumulates the current forest root, if a global
+// commitment is configured, so that proofs record a state covering the
+// ledger as it was before pruning. It reads every shard, so callers must
+// not hold a shard lock.
+func (sp *StatePruner) updateCommitments() {
+	if sp.commitments != nil {
+		sp.commitments.Update()
 	}
 }
 
+// signIntegrityProof timestamps and signs a proof, recording the global
+// commitment state first if one is configured
+func (sp *StatePruner) signIntegrityProof(proof IntegrityProof) IntegrityProof {
+	proof.Timestamp = time.Now().Round(0)
+	if sp.commitments != nil {
+		proof.AccumulatorState = sp.commitments.State()
+	}
+	if sp.auth != nil {
+		proof.Scheme = SchemeHMAC
+		proof.Signature = sp.auth.AuthenticateData(integrityMessage(proof))
+		return proof
+	}
+
+	proof.Scheme = SchemeEd25519
+	proof.KeyFingerprint = KeyFingerprint(sp.PublicKey())
+	proof.Signature = hex.EncodeToString(ed25519.Sign(sp.signer, signedIntegrityMessage(proof)))
+	return proof
+}
+
 // PruneBlockchain prunes old states while maintaining cryptographic integrity
 func (sp *StatePruner) PruneBlockchain(bc *Blockchain) int {
 	if len(bc.Blocks) <= sp.policy.RetentionCount {
@@ -71,14 +181,40 @@ func (sp *StatePruner) PruneBlockchain(bc *Blockchain) int {
 		prunableCount = prunableCount - (prunableCount % sp.policy.MaxHeight)
 	}
 	
+	if sp.policy.MinAge > 0 {
+		// Only prune blocks that have aged past the retention window
+		for prunableCount > 0 && bc.Blocks[prunableCount-1].Age() < sp.policy.MinAge {
+			prunableCount--
+		}
+	}
+	
+	if cm := bc.Checkpoints(); cm != nil {
+		// Peers can only sync from exported checkpoints, so keep every block
+		// after the latest one
+		exported := cm.ExportedHeight()
+		for prunableCount > 0 && bc.Blocks[prunableCount-1].Index > exported {
+			prunableCount--
+		}
+	}
+
+	if ft := bc.Finality(); ft != nil {
+		// Blocks that are not final may still be reorganized away
+		final := ft.LastFinalHeight()
+		for prunableCount > 0 && bc.Blocks[prunableCount-1].Index > final {
+			prunableCount--
+		}
+	}
+
 	if prunableCount <= 0 {
 		return 0
 	}
 	
 	// Calculate hash of pruned blocks for integrity proof
 	h := sha256.New()
+	it := bc.Iterator(0)
 	for i := 0; i < prunableCount; i++ {
-		h.Write([]byte(bc.Blocks[i].Hash))
+		b, _ := it.Next()
+		h.Write([]byte(b.Hash))
 	}
 	rootHash := hex.EncodeToString(h.Sum(nil))
 	
@@ -97,13 +233,68 @@ func (sp *StatePruner) PruneBlockchain(bc *Blockchain) int {
 
 // VerifyIntegrity checks if the blockchain has been tampered with after pruning
 func (sp *StatePruner) VerifyIntegrity(proof IntegrityProof) bool {
-	h := sha256.New()
-	h.Write([]byte(proof.RootHash))
-	h.Write([]byte(fmt.Sprintf("%d", proof.PrunedCount)))
-	h.Write([]byte(sp.secretKey))
-	expectedSig := hex.EncodeToString(h.Sum(nil))
-	
-	return expectedSig == proof.Signature
+	if sp.auth != nil {
+		return VerifyIntegrityProofWithKeyring(proof, sp.auth)
+	}
+	return VerifyIntegrityProof(sp.PublicKey(), proof)
+}
+
+// integrityMessage is the data an HMAC integrity proof covers, with the
+// accumulator state appended when one was recorded and the shard ID for
+// shard proofs
+func integrityMessage(proof IntegrityProof) string {
+	message := fmt.Sprintf("%s:%d", proof.RootHash, proof.PrunedCount)
+	if proof.AccumulatorState != "" {
+		message += ":" + proof.AccumulatorState
+	}
+	if proof.Sharded {
+		message += ":shard:" + strconv.Itoa(proof.ShardID)
+	}
+	return message
+}
+
+// signedIntegrityMessage is the data an ed25519 integrity proof signs:
+// RootHash || PrunedCount || Timestamp, then AccumulatorState if recorded
+// and the shard ID for shard proofs
+func signedIntegrityMessage(proof IntegrityProof) []byte {
+	message := fmt.Sprintf("%s:%d:%s", proof.RootHash, proof.PrunedCount, encodeTimestamp(proof.Timestamp))
+	if proof.AccumulatorState != "" {
+		message += ":" + proof.AccumulatorState
+	}
+	if proof.Sharded {
+		message += ":shard:" + strconv.Itoa(proof.ShardID)
+	}
+	return []byte(message)
+}
+
+// VerifyIntegrityProof checks an ed25519 integrity proof against the
+// pruner's public key; no secret is needed
+func VerifyIntegrityProof(pub ed25519.PublicKey, proof IntegrityProof) bool {
+	if proof.Scheme != SchemeEd25519 || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	if proof.KeyFingerprint != KeyFingerprint(pub) {
+		return false
+	}
+	signature, err := hex.DecodeString(proof.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, signedIntegrityMessage(proof), signature)
+}
+
+// VerifyIntegrityProofHMAC checks an HMAC integrity proof against a pruning key
+func VerifyIntegrityProofHMAC(proof IntegrityProof, key string) bool {
+	return VerifyIntegrityProofWithKeyring(proof, NewHomomorphicAuthenticator(key))
+}
+
+// VerifyIntegrityProofWithKeyring checks an HMAC integrity proof against any
+// key in a keyring
+func VerifyIntegrityProofWithKeyring(proof IntegrityProof, auth *HomomorphicAuthenticator) bool {
+	if proof.Scheme != "" && proof.Scheme != SchemeHMAC {
+		return false
+	}
+	return auth.VerifyAuthentication(integrityMessage(proof), proof.Signature)
 }
 
 // GetLatestProof returns the most recent integrity proof
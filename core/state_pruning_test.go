@@ -0,0 +1,125 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// prunedProof prunes a fresh chain of n blocks with sp and returns the
+// integrity proof as another process would receive it, through JSON
+func prunedProof(t *testing.T, sp *StatePruner, n int) IntegrityProof {
+	t.Helper()
+	bc := NewBlockchain()
+	for _, data := range testLeaves(n) {
+		if err := bc.AddBlock(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sp.PruneBlockchain(bc) == 0 {
+		t.Fatal("nothing pruned")
+	}
+	encoded, err := json.Marshal(sp.GetLatestProof())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proof IntegrityProof
+	if err := json.Unmarshal(encoded, &proof); err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestIntegrityProofCrossVerification(t *testing.T) {
+	prover := NewStatePruner(1, 2, false)
+	other := NewStatePruner(1, 2, false)
+	proof := prunedProof(t, prover, 6)
+	if proof.Scheme != SchemeEd25519 || proof.KeyFingerprint != KeyFingerprint(prover.PublicKey()) {
+		t.Fatalf("proof scheme %q, fingerprint %q", proof.Scheme, proof.KeyFingerprint)
+	}
+
+	// The verifier holds only the prover's public key
+	published, err := json.Marshal(prover.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub ed25519.PublicKey
+	if err := json.Unmarshal(published, &pub); err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyIntegrityProof(pub, proof) || !prover.VerifyIntegrity(proof) {
+		t.Fatal("proof rejected under the prover's public key")
+	}
+	if other.VerifyIntegrity(proof) || VerifyIntegrityProof(other.PublicKey(), proof) {
+		t.Fatal("proof verified under another pruner's key")
+	}
+
+	// A second instance loaded with the same key verifies the first's proofs
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewStatePruner(1, 2, false, WithSigningKey(key))
+	auditor := NewStatePruner(1, 2, false, WithSigningKey(key))
+	if !auditor.VerifyIntegrity(prunedProof(t, signer, 5)) {
+		t.Fatal("proof rejected by a second instance with the same key")
+	}
+}
+
+func TestIntegrityProofRejectsTampering(t *testing.T) {
+	prover := NewStatePruner(1, 2, false)
+	proof := prunedProof(t, prover, 6)
+	pub := prover.PublicKey()
+
+	tampered := map[string]func(*IntegrityProof){
+		"root":        func(p *IntegrityProof) { p.RootHash = "00" + p.RootHash[2:] },
+		"count":       func(p *IntegrityProof) { p.PrunedCount++ },
+		"timestamp":   func(p *IntegrityProof) { p.Timestamp = p.Timestamp.Add(time.Second) },
+		"fingerprint": func(p *IntegrityProof) { p.KeyFingerprint = "0000000000000000" },
+		"scheme":      func(p *IntegrityProof) { p.Scheme = SchemeHMAC },
+		"signature":   func(p *IntegrityProof) { p.Signature = "not hex" },
+		"shard":       func(p *IntegrityProof) { p.Sharded = true },
+	}
+	for name, tamper := range tampered {
+		forged := proof
+		tamper(&forged)
+		if VerifyIntegrityProof(pub, forged) {
+			t.Errorf("proof with a tampered %s verified", name)
+		}
+	}
+	if VerifyIntegrityProof(pub[:16], proof) {
+		t.Error("proof verified under a truncated key")
+	}
+}
+
+func TestIntegrityProofHMACMode(t *testing.T) {
+	prover := NewStatePruner(1, 2, false, WithHMACKeyring(NewHomomorphicAuthenticator("shared")))
+	if prover.PublicKey() != nil {
+		t.Fatal("HMAC pruner exposes a public key")
+	}
+	proof := prunedProof(t, prover, 6)
+	if proof.Scheme != SchemeHMAC || proof.KeyFingerprint != "" {
+		t.Fatalf("proof scheme %q, fingerprint %q", proof.Scheme, proof.KeyFingerprint)
+	}
+
+	verifier := NewStatePrunerWithKeyring(1, 2, false, NewHomomorphicAuthenticator("shared"))
+	if !verifier.VerifyIntegrity(proof) || !VerifyIntegrityProofHMAC(proof, "shared") {
+		t.Fatal("HMAC proof rejected by a verifier with the shared key")
+	}
+	if VerifyIntegrityProofHMAC(proof, "other") {
+		t.Fatal("HMAC proof verified under the wrong key")
+	}
+
+	// Legacy proofs carry no scheme and still verify; neither mode accepts
+	// the other's proofs
+	legacy := proof
+	legacy.Scheme = ""
+	if !VerifyIntegrityProofHMAC(legacy, "shared") {
+		t.Fatal("legacy HMAC proof rejected")
+	}
+	signed := prunedProof(t, NewStatePruner(1, 2, false), 6)
+	if verifier.VerifyIntegrity(signed) || NewStatePruner(1, 2, false).VerifyIntegrity(proof) {
+		t.Fatal("proof verified under the other scheme")
+	}
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// stateChain returns n blocks following genesis
+func stateChain(t *testing.T, n int) []Block {
+	t.Helper()
+	blocks := []Block{GenesisBlock()}
+	for _, data := range testLeaves(n) {
+		blocks = append(blocks, mustGenerateBlock(t, blocks[len(blocks)-1], data))
+	}
+	return blocks[1:]
+}
+
+func TestStateManagerListsKeysInOrder(t *testing.T) {
+	sm := NewStateManager(4)
+	blocks := stateChain(t, 10)
+	for _, b := range blocks {
+		sm.AddBlock(b)
+	}
+	active, archived := sm.ActiveKeys(), sm.ArchivedKeys()
+	if len(active) != 4 || len(archived) != 6 {
+		t.Fatalf("%d active and %d archived keys, want 4 and 6", len(active), len(archived))
+	}
+	if !sort.StringsAreSorted(active) || !sort.StringsAreSorted(archived) {
+		t.Fatal("keys not sorted")
+	}
+	for _, b := range sm.ActiveBlocks {
+		if i := sort.SearchStrings(active, b.Hash); i == len(active) || active[i] != b.Hash {
+			t.Fatalf("active block %s not listed", b.Hash)
+		}
+	}
+
+	var out strings.Builder
+	sm.PrintState(&out)
+	for _, hash := range active {
+		if !strings.Contains(out.String(), "Block "+hash) {
+			t.Fatalf("PrintState omits active block %s", hash)
+		}
+	}
+	if !strings.Contains(out.String(), "Archived Blocks: 6\n") {
+		t.Fatal("PrintState miscounts archived blocks")
+	}
+}
+
+// archivedHashes returns the set of blocks a StateManager has archived
+func archivedHashes(sm *StateManager) map[string]bool {
+	hashes := make(map[string]bool)
+	for _, b := range sm.PrunedBlocks {
+		hashes[b.Hash] = true
+	}
+	return hashes
+}
+
+func TestGlobalStateRootIsDeterministic(t *testing.T) {
+	blocks := stateChain(t, 15)
+	build := func() *ShardManager {
+		sm := NewShardManager(WithShardState(2))
+		for _, b := range blocks {
+			sm.DistributeBlock(b)
+		}
+		return sm
+	}
+	a, b := build(), build()
+	if a.GlobalStateRoot() != b.GlobalStateRoot() {
+		t.Fatal("same blocks gave different global state roots")
+	}
+
+	// Any shard's state changing moves the global root
+	before := a.GlobalStateRoot()
+	extra := mustGenerateBlock(t, blocks[len(blocks)-1], "extra")
+	a.DistributeBlock(extra)
+	if a.GlobalStateRoot() == before {
+		t.Fatal("global root unchanged by a new block")
+	}
+	for _, shard := range b.Shards.GetAllShards() {
+		state, ok := b.GetShardState(shard.ID)
+		if !ok {
+			t.Fatalf("shard #%d has no state manager", shard.ID)
+		}
+		before := b.GlobalStateRoot()
+		state.AddBlock(mustGenerateBlock(t, extra, fmt.Sprintf("shard-%d", shard.ID)))
+		if b.GlobalStateRoot() == before {
+			t.Fatalf("global root unchanged by shard #%d's state", shard.ID)
+		}
+	}
+	if _, ok := a.GetShardState(a.Shards.NextID()); ok {
+		t.Fatal("state found for a missing shard")
+	}
+}
+
+func TestShardStateFollowsBlocksThroughRebalancing(t *testing.T) {
+	sm := NewShardManager(WithShardState(2))
+	if err := sm.SetThresholds(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	blocks := stateChain(t, 12)
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+	archived := make(map[string]bool)
+	for _, shard := range sm.Shards.GetAllShards() {
+		for hash := range archivedHashes(shard.StateManager) {
+			archived[hash] = true
+		}
+	}
+	if err := sm.SetThresholds(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := sm.RebalanceShards(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sm.Shards.GetAllShards()) < 4 {
+		t.Fatalf("%d shards after rebalancing", len(sm.Shards.GetAllShards()))
+	}
+	for _, shard := range sm.Shards.GetAllShards() {
+		state := shard.StateManager
+		if len(state.ActiveBlocks)+len(state.PrunedBlocks) != len(shard.Blocks) {
+			t.Fatalf("shard #%d holds %d blocks, its state %d", shard.ID, len(shard.Blocks), len(state.ActiveBlocks)+len(state.PrunedBlocks))
+		}
+		for _, b := range state.PrunedBlocks {
+			if !archived[b.Hash] {
+				t.Fatalf("block %s archived by the rebuild", b.Hash)
+			}
+		}
+		for _, b := range state.ActiveBlocks {
+			if archived[b.Hash] {
+				t.Fatalf("archived block %s active again", b.Hash)
+			}
+		}
+	}
+}
+
+func TestRebuildKeepsArchiveTrieKind(t *testing.T) {
+	store := NewMemoryNodeStore()
+	lazy, err := NewLazyTrie(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewStateManager(2, WithArchiveTrie(lazy))
+	plain := NewStateManager(2)
+	blocks := stateChain(t, 10)
+	for _, b := range blocks {
+		source.AddBlock(b)
+		plain.AddBlock(b)
+	}
+
+	left := rebuildStateManager(2, blocks[:6], source)
+	right := rebuildStateManager(2, blocks[6:], source)
+	for _, rebuilt := range []*StateManager{left, right} {
+		archive, ok := rebuilt.ArchiveTrie.(*LazyTrie)
+		if !ok {
+			t.Fatalf("archive rebuilt as %T", rebuilt.ArchiveTrie)
+		}
+		if archive == lazy || archive.store != store {
+			t.Fatal("rebuilt archive does not write to the source's store")
+		}
+		if err := archive.Err(); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range rebuilt.PrunedBlocks {
+			if _, ok := archive.Get(b.Hash); !ok {
+				t.Fatalf("archived block %s missing from the rebuilt archive", b.Hash)
+			}
+		}
+	}
+	if want := rebuildStateManager(2, blocks[:6], plain).GetArchiveRoot(); left.GetArchiveRoot() != want {
+		t.Fatal("lazy and in-memory archives rebuilt to different roots")
+	}
+	if len(left.PrunedBlocks)+len(right.PrunedBlocks) != len(source.PrunedBlocks) {
+		t.Fatal("archived blocks lost by the split")
+	}
+
+	// Reclaiming one half keeps the nodes the other still uses
+	leftArchive, rightArchive := left.ArchiveTrie.(*LazyTrie), right.ArchiveTrie.(*LazyTrie)
+	if _, err := leftArchive.Reclaim(rightArchive); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range right.PrunedBlocks {
+		if _, ok := rightArchive.Get(b.Hash); !ok {
+			t.Fatalf("block %s lost by reclaiming a sibling archive", b.Hash)
+		}
+	}
+
+	hashed := NewStateManager(2, WithArchiveTrie(NewSuccinctTrie(WithTrieHasher(SHA512_256Hasher{}))))
+	for _, b := range blocks {
+		hashed.AddBlock(b)
+	}
+	if got := rebuildStateManager(2, blocks, hashed).ArchiveTrie.(*SuccinctTrie).HashFunction(); got != HashSHA512256 {
+		t.Fatalf("archive rebuilt with %s", got)
+	}
+}
@@ -0,0 +1,130 @@
+package core
+
+import "fmt"
+
+// ArchivePolicy chooses which active block a StateManager archives when it
+// holds more than MaxActiveCount
+type ArchivePolicy string
+
+const (
+	OldestPolicy ArchivePolicy = "oldest" // The earliest added; also the meaning of an empty policy
+	LRUPolicy    ArchivePolicy = "lru"    // The least recently read, or added if never read
+	LFUPolicy    ArchivePolicy = "lfu"    // The least often read, oldest among equals
+)
+
+// WithArchivePolicy sets which active block is archived when the active
+// set overflows, so frequently read blocks can stay hot
+func WithArchivePolicy(policy ArchivePolicy) StateManagerOption {
+	return func(sm *StateManager) {
+		sm.policy = policy
+	}
+}
+
+// Get returns an active block by hash, counting the read
+func (sm *StateManager) Get(hash string) (Block, bool) {
+	for _, b := range sm.ActiveBlocks {
+		if b.Hash == hash {
+			sm.recordAccess(hash)
+			return b, true
+		}
+	}
+	return Block{}, false
+}
+
+// GetBlockAnywhere returns a block by hash from the active set or, failing
+// that, the archive as RestoreBlock does, counting the read. Archived
+// blocks come back with only the fields ArchivedBlock keeps.
+func (sm *StateManager) GetBlockAnywhere(hash string) (Block, error) {
+	if b, ok := sm.Get(hash); ok {
+		return b, nil
+	}
+	archived, err := sm.RestoreBlock(hash)
+	if err != nil {
+		return Block{}, err
+	}
+	sm.recordAccess(hash)
+	return Block{
+		Index:     archived.Index,
+		Timestamp: archived.Timestamp,
+		Hash:      archived.Hash,
+		Data:      archived.Data,
+		DataHash:  archived.DataHash,
+	}, nil
+}
+
+// ProveBlock proves a block's entry in the active trie, or in the archive
+// trie if it is archived, counting the read; active reports which
+func (sm *StateManager) ProveBlock(hash string) (proof TrieProof, active bool, err error) {
+	if _, ok := sm.ActiveTrie.Get(hash); ok {
+		proof, err = sm.ActiveTrie.Prove(hash)
+		active = true
+	} else if _, ok := sm.ArchiveTrie.Get(hash); ok {
+		proof, err = sm.ArchiveTrie.Prove(hash)
+	} else {
+		return TrieProof{}, false, fmt.Errorf("%w: %s in state", ErrBlockNotFound, hash)
+	}
+	if err == nil {
+		sm.recordAccess(hash)
+	}
+	return proof, active, err
+}
+
+// AccessStats returns how often each block has been read through Get,
+// GetBlockAnywhere and ProveBlock, or proven by its shard's
+// ProveInclusion, omitting blocks never read. Like the
+// tries, the counters rely on the owner's locking, e.g. the shard mutex.
+func (sm *StateManager) AccessStats() map[string]uint64 {
+	stats := make(map[string]uint64, len(sm.accesses))
+	for hash, count := range sm.accesses {
+		stats[hash] = count
+	}
+	return stats
+}
+
+// recordAccess counts a read of a block and, if active, marks it most
+// recently used
+func (sm *StateManager) recordAccess(hash string) {
+	sm.recordAccesses(hash, 1)
+	if _, active := sm.ActiveTrie.Get(hash); active {
+		sm.markUsed(hash)
+	}
+}
+
+// recordAccesses adds count reads of a block
+func (sm *StateManager) recordAccesses(hash string, count uint64) {
+	if sm.accesses == nil {
+		sm.accesses = make(map[string]uint64)
+	}
+	sm.accesses[hash] += count
+}
+
+// markUsed stamps a block with the next tick of the LRU clock
+func (sm *StateManager) markUsed(hash string) {
+	if sm.lastUsed == nil {
+		sm.lastUsed = make(map[string]uint64)
+	}
+	sm.ticks++
+	sm.lastUsed[hash] = sm.ticks
+}
+
+// coldest returns the position in ActiveBlocks of the block to archive
+// next under the policy
+func (sm *StateManager) coldest() int {
+	coldest := 0
+	for i, b := range sm.ActiveBlocks {
+		current := sm.ActiveBlocks[coldest]
+		switch sm.policy {
+		case LRUPolicy:
+			if sm.lastUsed[b.Hash] < sm.lastUsed[current.Hash] {
+				coldest = i
+			}
+		case LFUPolicy:
+			if sm.accesses[b.Hash] < sm.accesses[current.Hash] {
+				coldest = i
+			}
+		default:
+			return 0
+		}
+	}
+	return coldest
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHotBlockSurvivesArchiving(t *testing.T) {
+	for _, tc := range []struct {
+		policy  ArchivePolicy
+		survive bool
+	}{{LRUPolicy, true}, {LFUPolicy, true}, {OldestPolicy, false}, {"", false}} {
+		blocks := stateChain(t, 12)
+		hot := blocks[0]
+		sm := NewStateManager(3, WithArchivePolicy(tc.policy))
+		sm.AddBlock(hot)
+
+		// The oldest block is read before every addition; the newer ones never
+		for _, b := range blocks[1:] {
+			if _, ok := sm.Get(hot.Hash); !ok && tc.survive {
+				t.Fatalf("%s: hot block archived before #%d was added", tc.policy, b.Index)
+			}
+			sm.AddBlock(b)
+		}
+		if _, ok := sm.ActiveTrie.Get(hot.Hash); ok != tc.survive {
+			t.Fatalf("%s: hot block active %v after %d archiving rounds", tc.policy, ok, len(sm.PrunedBlocks))
+		}
+		if !tc.survive {
+			continue
+		}
+		if len(sm.PrunedBlocks) != 9 {
+			t.Fatalf("%s: %d blocks archived", tc.policy, len(sm.PrunedBlocks))
+		}
+		// The cold ones went in the order they arrived
+		for i, archived := range sm.PrunedBlocks {
+			if archived.Hash != blocks[i+1].Hash {
+				t.Fatalf("%s: archived #%d in round %d", tc.policy, archived.Index, i)
+			}
+		}
+		if len(sm.ActiveBlocks) != 3 || sm.ActiveBlocks[0].Hash != hot.Hash {
+			t.Fatalf("%s: active blocks %d", tc.policy, len(sm.ActiveBlocks))
+		}
+	}
+}
+
+func TestLRUAndLFUDiffer(t *testing.T) {
+	// One block is read often long ago, another once just now
+	blocks := stateChain(t, 4)
+	run := func(policy ArchivePolicy) *StateManager {
+		sm := NewStateManager(3, WithArchivePolicy(policy))
+		for _, b := range blocks[:3] {
+			sm.AddBlock(b)
+		}
+		for i := 0; i < 5; i++ {
+			sm.Get(blocks[0].Hash)
+		}
+		sm.Get(blocks[1].Hash)
+		sm.Get(blocks[2].Hash)
+		sm.Get(blocks[2].Hash)
+		sm.AddBlock(blocks[3])
+		return sm
+	}
+	if lru := run(LRUPolicy); lru.PrunedBlocks[0].Hash != blocks[0].Hash {
+		t.Fatalf("LRU archived #%d, want the least recently read", lru.PrunedBlocks[0].Index)
+	}
+	lfu := run(LFUPolicy)
+	if _, ok := lfu.ActiveTrie.Get(blocks[0].Hash); !ok {
+		t.Fatal("LFU archived the most often read block")
+	}
+	if lfu.PrunedBlocks[0].Hash != blocks[3].Hash {
+		t.Fatalf("LFU archived #%d, want the block never read", lfu.PrunedBlocks[0].Index)
+	}
+}
+
+func TestAccessStats(t *testing.T) {
+	blocks := stateChain(t, 5)
+	sm := NewStateManager(2)
+	for _, b := range blocks {
+		sm.AddBlock(b)
+	}
+	if stats := sm.AccessStats(); len(stats) != 0 {
+		t.Fatalf("stats before any read: %v", stats)
+	}
+
+	sm.Get(blocks[4].Hash)
+	sm.Get(blocks[4].Hash)
+	if _, err := sm.GetBlockAnywhere(blocks[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, active, err := sm.ProveBlock(blocks[1].Hash); err != nil || active {
+		t.Fatalf("proving an archived block: active %v, %v", active, err)
+	}
+	if _, active, err := sm.ProveBlock(blocks[4].Hash); err != nil || !active {
+		t.Fatalf("proving an active block: active %v, %v", active, err)
+	}
+	// Misses count nothing
+	sm.Get("missing")
+	sm.GetBlockAnywhere("missing")
+	sm.ProveBlock("missing")
+
+	stats := sm.AccessStats()
+	want := map[string]uint64{blocks[4].Hash: 3, blocks[0].Hash: 1, blocks[1].Hash: 1}
+	if len(stats) != len(want) {
+		t.Fatalf("stats %v", stats)
+	}
+	for hash, count := range want {
+		if stats[hash] != count {
+			t.Fatalf("%s read %d times, want %d", hash, stats[hash], count)
+		}
+	}
+	stats[blocks[2].Hash] = 10
+	if sm.AccessStats()[blocks[2].Hash] != 0 {
+		t.Fatal("AccessStats returned the live counters")
+	}
+}
+
+func TestShardCountsProofsUnderItsLock(t *testing.T) {
+	sm := NewShardManager(WithShardState(4))
+	if err := sm.SetThresholds(1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	blocks := stateChain(t, 8)
+	for _, b := range blocks {
+		sm.DistributeBlock(b)
+	}
+	shard := sm.Shards.GetAllShards()[0]
+	hot := blocks[len(blocks)-1].Hash
+
+	// Proofs race additions; the race detector checks the counters
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if _, _, err := shard.ProveInclusion(hot); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for _, b := range stateChain(t, 20)[8:] {
+			shard.AddBlock(b)
+		}
+	}()
+	wg.Wait()
+
+	state, _ := sm.GetShardState(shard.ID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if got := state.AccessStats()[hot]; got != 50 {
+		t.Fatalf("%d proofs counted, want 50", got)
+	}
+}
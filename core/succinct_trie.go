@@ -1,72 +1,184 @@
 package core
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
 )
 
-// TrieNode represents a node in the succinct trie
+// TrieNode represents a node in the succinct trie. Edges are path-compressed:
+// each node stores the label of the edge leading to it, so chains of
+// single-child nodes collapse into one node as in a radix tree.
+//
+// A node's hash is the trie's hash function, SHA-256 by default, over, in
+// order:
+//
+//	uvarint(len(Value)) || Value
+//	for each child sorted by the first byte of its label:
+//	    uvarint(len(Label)) || Label || child hash (32 raw bytes)
+//
+// and is stored hex-encoded. A node's own label is committed by its parent,
+// so splitting or merging edges never changes the hash of the subtree below.
 type TrieNode struct {
-	Children map[byte]*TrieNode // Map of child nodes by byte
-	Value    string             // Stored value (block data) for leaf nodes
+	Children map[byte]*TrieNode // Children keyed by the first byte of their label
+	Label    []byte             // Edge label from the parent to this node
+	Value    string             // Stored value (block data); empty if no key ends here
 	Hash     string             // Hash of the node (for Merkle root)
 }
 
 // SuccinctTrie represents a compact state trie
 type SuccinctTrie struct {
-	Root *TrieNode
+	Root   *TrieNode
+	hashFn Hasher // nil is SHA-256
+}
+
+// TrieOption configures a SuccinctTrie
+type TrieOption func(*SuccinctTrie)
+
+// WithTrieHasher hashes the trie's nodes with a function other than
+// SHA-256. Its proofs and serialized form name the function.
+func WithTrieHasher(h Hasher) TrieOption {
+	return func(st *SuccinctTrie) {
+		st.hashFn = h
+	}
 }
 
 // NewSuccinctTrie creates a new succinct trie
-func NewSuccinctTrie() *SuccinctTrie {
-	return &SuccinctTrie{
-		Root: &TrieNode{
-			Children: make(map[byte]*TrieNode),
-			Value:    "",
-		},
+func NewSuccinctTrie(opts ...TrieOption) *SuccinctTrie {
+	st := &SuccinctTrie{Root: &TrieNode{}}
+	for _, opt := range opts {
+		opt(st)
+	}
+	st.Root.Hash = st.computeNodeHash(st.Root)
+	return st
+}
+
+// hasher returns the trie's hash function, defaulting to SHA-256
+func (st *SuccinctTrie) hasher() Hasher {
+	if st.hashFn == nil {
+		return defaultHasher
 	}
+	return st.hashFn
 }
 
-// Insert adds a key-value pair (block hash, block data) to the trie
+// HashFunction returns the name of the hash function of the trie's nodes
+func (st *SuccinctTrie) HashFunction() string {
+	return st.hasher().Name()
+}
+
+// Insert adds a key-value pair (block hash, block data) to the trie,
+// rehashing only the nodes on the key's path
 func (st *SuccinctTrie) Insert(key, value string) {
-	current := st.Root
-	keyBytes := []byte(key)
-
-	// Traverse/create path based on key bytes
-	for _, b := range keyBytes {
-		if _, exists := current.Children[b]; !exists {
-			current.Children[b] = &TrieNode{
-				Children: make(map[byte]*TrieNode),
-				Value:    "",
+	st.insert(st.Root, []byte(key), value)
+}
+
+func (st *SuccinctTrie) insert(node *TrieNode, key []byte, value string) {
+	if len(key) == 0 {
+		node.Value = value
+		node.Hash = st.computeNodeHash(node)
+		return
+	}
+
+	child, exists := node.Children[key[0]]
+	if !exists {
+		leaf := &TrieNode{Label: append([]byte{}, key...), Value: value}
+		leaf.Hash = st.computeNodeHash(leaf)
+		if node.Children == nil {
+			node.Children = make(map[byte]*TrieNode)
+		}
+		node.Children[key[0]] = leaf
+	} else {
+		common := commonPrefixLen(child.Label, key)
+		if common < len(child.Label) {
+			// Split the edge where the new key diverges
+			mid := &TrieNode{
+				Children: map[byte]*TrieNode{child.Label[common]: child},
+				Label:    child.Label[:common:common],
 			}
+			child.Label = child.Label[common:]
+			node.Children[key[0]] = mid
+			child = mid
 		}
-		current = current.Children[b]
+		st.insert(child, key[common:], value)
 	}
+	node.Hash = st.computeNodeHash(node)
+}
 
-	// Store value at leaf and compute hash
-	current.Value = value
-	current.Hash = st.computeNodeHash(current)
-	st.updateHashes(st.Root)
+// commonPrefixLen returns the length of the shared prefix of a and b
+func commonPrefixLen(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
 }
 
 // Get retrieves the value associated with a key
 func (st *SuccinctTrie) Get(key string) (string, bool) {
+	node := st.find([]byte(key))
+	if node != nil && node.Value != "" {
+		return node.Value, true
+	}
+	return "", false
+}
+
+// find returns the node a key ends at, or nil if the key falls inside or off an edge
+func (st *SuccinctTrie) find(key []byte) *TrieNode {
 	current := st.Root
-	keyBytes := []byte(key)
+	for len(key) > 0 {
+		next, exists := current.Children[key[0]]
+		if !exists || !bytes.HasPrefix(key, next.Label) {
+			return nil
+		}
+		key = key[len(next.Label):]
+		current = next
+	}
+	return current
+}
 
-	for _, b := range keyBytes {
-		if next, exists := current.Children[b]; exists {
-			current = next
-		} else {
-			return "", false
+// Delete removes a key and reports whether it was present. Nodes left without
+// a value are pruned or merged with their only child to keep edges compressed.
+func (st *SuccinctTrie) Delete(key string) bool {
+	return st.delete(st.Root, []byte(key))
+}
+
+func (st *SuccinctTrie) delete(node *TrieNode, key []byte) bool {
+	if len(key) == 0 {
+		if node.Value == "" {
+			return false
 		}
+		node.Value = ""
+		node.Hash = st.computeNodeHash(node)
+		return true
 	}
 
-	if current.Value != "" {
-		return current.Value, true
+	child, exists := node.Children[key[0]]
+	if !exists || !bytes.HasPrefix(key, child.Label) {
+		return false
 	}
-	return "", false
+	if !st.delete(child, key[len(child.Label):]) {
+		return false
+	}
+
+	if child.Value == "" {
+		switch len(child.Children) {
+		case 0:
+			delete(node.Children, key[0])
+		case 1:
+			// Merge the child with its only descendant; the descendant's hash
+			// already covers the merged subtree
+			for _, grandchild := range child.Children {
+				label := make([]byte, 0, len(child.Label)+len(grandchild.Label))
+				label = append(label, child.Label...)
+				grandchild.Label = append(label, grandchild.Label...)
+				node.Children[key[0]] = grandchild
+			}
+		}
+	}
+	node.Hash = st.computeNodeHash(node)
+	return true
 }
 
 // GetMerkleRoot returns the Merkle root of the trie
@@ -74,22 +186,50 @@ func (st *SuccinctTrie) GetMerkleRoot() string {
 	return st.Root.Hash
 }
 
-// computeNodeHash calculates the hash of a node
+// computeNodeHash calculates the hash of a node from its value and children
 func (st *SuccinctTrie) computeNodeHash(node *TrieNode) string {
 	if node == nil {
 		return ""
 	}
 
-	data := node.Value
-	for b, child := range node.Children {
-		data += string(b) + child.Hash
+	children := make([]TrieProofChild, 0, len(node.Children))
+	for _, child := range sortedChildren(node) {
+		children = append(children, TrieProofChild{Label: string(child.Label), Hash: child.Hash})
 	}
+	// Child hashes were computed by the trie itself, so they are well formed
+	hash, _ := hashTrieNode(st.hasher(), node.Value, children)
+	return hash
+}
 
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
+// TrieProofChild is the part of a child that its parent's hash commits to
+type TrieProofChild struct {
+	Label string
+	Hash  string
 }
 
-// updateHashes recomputes hashes bottom-up after insertion
+// hashTrieNode applies the node hash documented on TrieNode; children must
+// be sorted by the first byte of their label and carry well-formed hashes
+func hashTrieNode(h Hasher, value string, children []TrieProofChild) (string, error) {
+	data, err := encodeTrieNode(value, children, hashSize(h))
+	if err != nil {
+		return "", err
+	}
+	return hexSum(h, data), nil
+}
+
+// sortedChildren returns a node's children ordered by the first label byte
+func sortedChildren(node *TrieNode) []*TrieNode {
+	children := make([]*TrieNode, 0, len(node.Children))
+	for _, child := range node.Children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Label[0] < children[j].Label[0]
+	})
+	return children
+}
+
+// updateHashes recomputes every hash in a subtree bottom-up
 func (st *SuccinctTrie) updateHashes(node *TrieNode) {
 	if node == nil {
 		return
@@ -102,30 +242,236 @@ func (st *SuccinctTrie) updateHashes(node *TrieNode) {
 	node.Hash = st.computeNodeHash(node)
 }
 
-// PrintTrie displays the trie structure (for debugging)
-func (st *SuccinctTrie) PrintTrie() {
-	fmt.Println("\n--- Succinct Trie State ---")
-	st.printNode(st.Root, 0)
+// TrieProofStep is one node on the path from the root to a proven key
+type TrieProofStep struct {
+	Value    string           // Value stored at this node
+	Children []TrieProofChild // Children in hash order; the next step's hash is left empty
+}
+
+// TrieProof proves a key-value pair against a trie root
+type TrieProof struct {
+	Steps  []TrieProofStep // Root first, the key's node last
+	Hasher string          `json:",omitempty"` // Hash function name, empty for SHA-256
+}
+
+// Prove returns an inclusion proof for a stored key
+func (st *SuccinctTrie) Prove(key string) (TrieProof, error) {
+	proof := TrieProof{Hasher: hasherName(st.hasher())}
+	current := st.Root
+	rest := []byte(key)
+	for {
+		var next *TrieNode
+		if len(rest) > 0 {
+			next = current.Children[rest[0]]
+			if next == nil || !bytes.HasPrefix(rest, next.Label) {
+				return TrieProof{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+			}
+		}
+
+		step := TrieProofStep{Value: current.Value}
+		for _, child := range sortedChildren(current) {
+			entry := TrieProofChild{Label: string(child.Label), Hash: child.Hash}
+			if child == next {
+				entry.Hash = ""
+			}
+			step.Children = append(step.Children, entry)
+		}
+		proof.Steps = append(proof.Steps, step)
+
+		if next == nil {
+			break
+		}
+		rest = rest[len(next.Label):]
+		current = next
+	}
+	if current.Value == "" {
+		return TrieProof{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+	return proof, nil
+}
+
+// VerifyTrieProof checks that key maps to value in the trie with the given root
+func VerifyTrieProof(root, key, value string, proof TrieProof) bool {
+	steps := proof.Steps
+	if len(steps) == 0 || value == "" || steps[len(steps)-1].Value != value {
+		return false
+	}
+	h, err := LookupHasher(proof.Hasher)
+	if err != nil {
+		return false
+	}
+
+	// Walk down to check the path labels spell out the key
+	rest := key
+	path := make([]int, len(steps)-1)
+	for i := 0; i < len(steps)-1; i++ {
+		path[i] = -1
+		for j, child := range steps[i].Children {
+			if child.Hash == "" {
+				if path[i] >= 0 || child.Label == "" || !strings.HasPrefix(rest, child.Label) {
+					return false
+				}
+				path[i] = j
+				rest = rest[len(child.Label):]
+			}
+		}
+		if path[i] < 0 {
+			return false
+		}
+	}
+	if rest != "" {
+		return false
+	}
+
+	// Hash back up to the root
+	hash, err := hashTrieNode(h, steps[len(steps)-1].Value, steps[len(steps)-1].Children)
+	if err != nil {
+		return false
+	}
+	for i := len(steps) - 2; i >= 0; i-- {
+		children := append([]TrieProofChild{}, steps[i].Children...)
+		children[path[i]].Hash = hash
+		if hash, err = hashTrieNode(h, steps[i].Value, children); err != nil {
+			return false
+		}
+	}
+	return hash == root
+}
+
+// Iterate visits every stored key-value pair in lexicographic key order
+// until fn returns false
+func (st *SuccinctTrie) Iterate(fn func(key, value string) bool) {
+	iterateNode(st.Root, nil, fn)
+}
+
+// iterateNode walks a subtree in key order, reporting whether to continue
+func iterateNode(node *TrieNode, path []byte, fn func(key, value string) bool) bool {
+	if node.Value != "" && !fn(string(path), node.Value) {
+		return false
+	}
+	for _, child := range sortedChildren(node) {
+		if !iterateNode(child, append(path[:len(path):len(path)], child.Label...), fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns every stored key in lexicographic order
+func (st *SuccinctTrie) Keys() []string {
+	var keys []string
+	st.Iterate(func(key, _ string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
 }
 
-func (st *SuccinctTrie) printNode(node *TrieNode, level int) {
+// GetByPrefix returns every key-value pair whose key starts with prefix
+func (st *SuccinctTrie) GetByPrefix(prefix string) map[string]string {
+	results := make(map[string]string)
+
+	// Descend to the node covering the prefix, which may end mid-edge
+	node := st.Root
+	path := []byte{}
+	rest := []byte(prefix)
+	for len(rest) > 0 {
+		child, exists := node.Children[rest[0]]
+		if !exists {
+			return results
+		}
+		common := commonPrefixLen(child.Label, rest)
+		if common < len(rest) && common < len(child.Label) {
+			return results
+		}
+		path = append(path, child.Label...)
+		rest = rest[common:]
+		node = child
+	}
+
+	iterateNode(node, path, func(key, value string) bool {
+		results[key] = value
+		return true
+	})
+	return results
+}
+
+// Clone returns a deep copy of the trie
+func (st *SuccinctTrie) Clone() *SuccinctTrie {
+	return &SuccinctTrie{Root: cloneNode(st.Root), hashFn: st.hashFn}
+}
+
+func cloneNode(node *TrieNode) *TrieNode {
 	if node == nil {
-		return
+		return nil
+	}
+	clone := &TrieNode{
+		Label: node.Label, // Labels are never modified in place
+		Value: node.Value,
+		Hash:  node.Hash,
 	}
+	if len(node.Children) > 0 {
+		clone.Children = make(map[byte]*TrieNode, len(node.Children))
+		for b, child := range node.Children {
+			clone.Children[b] = cloneNode(child)
+		}
+	}
+	return clone
+}
 
-	prefix := ""
-	for i := 0; i < level; i++ {
-		prefix += "  "
+// TrieNodeDump is a serializable view of one trie node and its subtree
+type TrieNodeDump struct {
+	Label    string         `json:"label"` // Edge label from the parent; empty at the root
+	Value    string         `json:"value,omitempty"`
+	Hash     string         `json:"hash"`
+	Children []TrieNodeDump `json:"children,omitempty"` // Ordered by first label byte
+}
+
+// TrieDump is a serializable view of a whole trie
+type TrieDump struct {
+	RootHash string       `json:"root_hash"`
+	Keys     int          `json:"keys"`
+	Root     TrieNodeDump `json:"root"`
+}
+
+// Dump returns the trie structure as plain data
+func (st *SuccinctTrie) Dump() TrieDump {
+	dump := TrieDump{RootHash: st.GetMerkleRoot(), Root: dumpNode(st.Root)}
+	st.Iterate(func(_, _ string) bool {
+		dump.Keys++
+		return true
+	})
+	return dump
+}
+
+func dumpNode(node *TrieNode) TrieNodeDump {
+	dump := TrieNodeDump{Label: string(node.Label), Value: node.Value, Hash: node.Hash}
+	for _, child := range sortedChildren(node) {
+		dump.Children = append(dump.Children, dumpNode(child))
 	}
+	return dump
+}
 
+// PrintTrie writes the trie structure to w (for debugging)
+func (st *SuccinctTrie) PrintTrie(w io.Writer) {
+	writeTrieDump(w, "Succinct Trie", st.Dump())
+}
+
+// writeTrieDump formats a trie dump as an indented tree
+func writeTrieDump(w io.Writer, title string, dump TrieDump) {
+	fmt.Fprintf(w, "\n--- %s State ---\n", title)
+	writeTrieNode(w, dump.Root, 0)
+}
+
+func writeTrieNode(w io.Writer, node TrieNodeDump, level int) {
+	prefix := strings.Repeat("  ", level)
 	if node.Value != "" {
-		fmt.Printf("%sLeaf: Value=%s, Hash=%s\n", prefix, node.Value, node.Hash)
+		fmt.Fprintf(w, "%sLeaf: Value=%s, Hash=%s\n", prefix, node.Value, node.Hash)
 	} else {
-		fmt.Printf("%sNode: Hash=%s\n", prefix, node.Hash)
+		fmt.Fprintf(w, "%sNode: Hash=%s\n", prefix, node.Hash)
 	}
-
-	for b, child := range node.Children {
-		fmt.Printf("%sChild [%c]:\n", prefix+"  ", b)
-		st.printNode(child, level+1)
+	for _, child := range node.Children {
+		fmt.Fprintf(w, "%sEdge [%s]:\n", prefix+"  ", child.Label)
+		writeTrieNode(w, child, level+1)
 	}
 }
@@ -0,0 +1,275 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTrieMatchesMapModel(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	var keys []string
+	for i := 0; i < 300; i++ {
+		// Short keys with shared prefixes force edge splits and merges
+		keys = append(keys, fmt.Sprintf("%x%d", r.Intn(40), r.Intn(5)))
+	}
+	keys = append(keys, "a", "ab", "abc")
+
+	st := NewSuccinctTrie()
+	model := make(map[string]string)
+	for op := 0; op < 5000; op++ {
+		key := keys[r.Intn(len(keys))]
+		if r.Intn(3) == 0 {
+			_, want := model[key]
+			if got := st.Delete(key); got != want {
+				t.Fatalf("op %d: Delete(%q) = %v, want %v", op, key, got, want)
+			}
+			delete(model, key)
+		} else {
+			value := fmt.Sprint(op)
+			st.Insert(key, value)
+			model[key] = value
+		}
+		if op%250 != 0 {
+			continue
+		}
+
+		// The root depends only on the stored pairs, not on the history
+		fresh := NewSuccinctTrie()
+		for k, v := range model {
+			fresh.Insert(k, v)
+		}
+		if fresh.GetMerkleRoot() != st.GetMerkleRoot() {
+			t.Fatalf("op %d: root %s, rebuilt trie has %s", op, st.GetMerkleRoot(), fresh.GetMerkleRoot())
+		}
+		for k, v := range model {
+			if got, ok := st.Get(k); !ok || got != v {
+				t.Fatalf("op %d: Get(%q) = %q, %v, want %q", op, k, got, ok, v)
+			}
+			proof, err := st.Prove(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyTrieProof(st.GetMerkleRoot(), k, v, proof) {
+				t.Fatalf("op %d: proof of %q rejected", op, k)
+			}
+			if VerifyTrieProof(st.GetMerkleRoot(), k+"x", v, proof) || VerifyTrieProof(st.GetMerkleRoot(), k, v+"x", proof) {
+				t.Fatalf("op %d: proof of %q accepted for another pair", op, k)
+			}
+		}
+		if got := len(st.Keys()); got != len(model) {
+			t.Fatalf("op %d: %d keys, model has %d", op, got, len(model))
+		}
+	}
+}
+
+func TestTrieEdgesStayCompressed(t *testing.T) {
+	st := NewSuccinctTrie()
+	st.Insert("abcdef", "1")
+	st.Insert("abcxyz", "2")
+	if len(st.Root.Children) != 1 || string(st.Root.Children['a'].Label) != "abc" {
+		t.Fatal("diverging keys did not split the edge at the shared prefix")
+	}
+	st.Delete("abcxyz")
+	if child := st.Root.Children['a']; string(child.Label) != "abcdef" || len(child.Children) != 0 {
+		t.Fatalf("deleting a key left the edge split at %q", child.Label)
+	}
+	single := NewSuccinctTrie()
+	single.Insert("abcdef", "1")
+	if st.GetMerkleRoot() != single.GetMerkleRoot() {
+		t.Fatal("merged edge hashes differently from a never-split one")
+	}
+}
+
+func TestVerifyTrieProofRejectsMalformedHashes(t *testing.T) {
+	st := NewSuccinctTrie()
+	for _, data := range testLeaves(20) {
+		st.Insert(data, "value-"+data)
+	}
+	proof, err := st.Prove("tx-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, child := -1, -1
+	for i, s := range proof.Steps {
+		for j, c := range s.Children {
+			if c.Hash != "" {
+				step, child = i, j
+			}
+		}
+	}
+	if step < 0 {
+		t.Fatal("proof has no sibling hashes")
+	}
+	valid := proof.Steps[step].Children[child].Hash
+	for name, hash := range map[string]string{
+		"not hex":   "zz" + valid[2:],
+		"odd":       valid[1:],
+		"truncated": valid[:62],
+		"extended":  valid + "00",
+		"empty":     "",
+	} {
+		forged := TrieProof{Hasher: proof.Hasher}
+		for _, s := range proof.Steps {
+			forged.Steps = append(forged.Steps, TrieProofStep{Value: s.Value, Children: append([]TrieProofChild{}, s.Children...)})
+		}
+		forged.Steps[step].Children[child].Hash = hash
+		if VerifyTrieProof(st.GetMerkleRoot(), "tx-7", "value-tx-7", forged) {
+			t.Errorf("%s sibling hash accepted", name)
+		}
+		if _, err := hashTrieNode(defaultHasher, "", forged.Steps[step].Children); err == nil {
+			t.Errorf("%s sibling hash encoded", name)
+		}
+	}
+	if !VerifyTrieProof(st.GetMerkleRoot(), "tx-7", "value-tx-7", proof) {
+		t.Fatal("valid proof rejected")
+	}
+}
+
+// byteTrieNode is the uncompressed trie the radix edges replaced, with one
+// node per key byte, kept as the baseline of BenchmarkTrieMemory
+type byteTrieNode struct {
+	children map[byte]*byteTrieNode
+	value    string
+	hash     string
+}
+
+func (n *byteTrieNode) insert(key, value string) {
+	for i := 0; i < len(key); i++ {
+		if n.children == nil {
+			n.children = make(map[byte]*byteTrieNode)
+		}
+		child, exists := n.children[key[i]]
+		if !exists {
+			child = &byteTrieNode{hash: strings.Repeat("0", 64)}
+			n.children[key[i]] = child
+		}
+		n = child
+	}
+	n.value = value
+}
+
+// randomBlockHashes returns n hex-encoded random 32-byte keys
+func randomBlockHashes(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	keys := make([]string, n)
+	raw := make([]byte, 32)
+	for i := range keys {
+		r.Read(raw)
+		keys[i] = fmt.Sprintf("%x", raw)
+	}
+	return keys
+}
+
+// heapGrowth returns the live heap bytes build adds
+func heapGrowth(build func() any) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	kept := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(kept)
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+func BenchmarkTrieMemory(b *testing.B) {
+	keys := randomBlockHashes(100000)
+	b.ResetTimer()
+	var radix, perByte uint64
+	for i := 0; i < b.N; i++ {
+		radix = heapGrowth(func() any {
+			st := NewSuccinctTrie()
+			for _, key := range keys {
+				st.Insert(key, "block")
+			}
+			return st
+		})
+		b.StopTimer()
+		perByte = heapGrowth(func() any {
+			root := &byteTrieNode{}
+			for _, key := range keys {
+				root.insert(key, "block")
+			}
+			return root
+		})
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(radix)/(1<<20), "radix-MB")
+	b.ReportMetric(float64(perByte)/(1<<20), "per-byte-MB")
+	if radix >= perByte {
+		b.Fatalf("radix trie uses %d bytes, per-byte trie %d", radix, perByte)
+	}
+}
+
+func TestTrieIterationIsSortedByKeyBytes(t *testing.T) {
+	keys := randomBlockHashes(200)
+	st := NewSuccinctTrie()
+	for i := len(keys) - 1; i >= 0; i-- {
+		st.Insert(keys[i], "v")
+	}
+	got := st.Keys()
+	want := append([]string{}, keys...)
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatal("Keys not in byte order")
+	}
+	if all := st.GetByPrefix(""); len(all) != len(keys) {
+		t.Fatalf("empty prefix matched %d keys, want %d", len(all), len(keys))
+	}
+
+	visited := 0
+	st.Iterate(func(key, _ string) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Fatalf("iteration continued to %d keys after fn returned false", visited)
+	}
+	if NewSuccinctTrie().Keys() != nil {
+		t.Fatal("empty trie has keys")
+	}
+}
+
+func TestTrieGetByPrefixWithSharedHashPrefixes(t *testing.T) {
+	st := NewSuccinctTrie()
+	keys := []string{
+		"00ab12" + strings.Repeat("0", 58),
+		"00ab12" + strings.Repeat("f", 58),
+		"00ab9f" + strings.Repeat("1", 58),
+		"00ac00" + strings.Repeat("2", 58),
+		"ff0000" + strings.Repeat("3", 58),
+	}
+	for i, key := range keys {
+		st.Insert(key, fmt.Sprint(i))
+	}
+	for prefix, want := range map[string][]int{
+		"00":          {0, 1, 2, 3},
+		"00a":         {0, 1, 2, 3},
+		"00ab":        {0, 1, 2},
+		"00ab1":       {0, 1}, // Ends inside the compressed edge
+		"00ab12f":     {1},
+		keys[2]:       {2},
+		"ff":          {4},
+		"01":          nil,
+		"00ab13":      nil,
+		keys[4] + "0": nil,
+	} {
+		got := st.GetByPrefix(prefix)
+		if len(got) != len(want) {
+			t.Errorf("prefix %q matched %d keys, want %d", prefix, len(got), len(want))
+			continue
+		}
+		for _, i := range want {
+			if got[keys[i]] != fmt.Sprint(i) {
+				t.Errorf("prefix %q missed %q", prefix, keys[i])
+			}
+		}
+	}
+}
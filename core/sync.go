@@ -1,9 +1,19 @@
 package core
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 )
 
+// Errors returned by cross-shard synchronization
+var (
+	ErrIndexOutOfRange = errors.New("block index out of range")
+	ErrSameShard       = errors.New("source and destination are the same shard")
+	ErrEmptySource     = errors.New("source shard has no blocks")
+	ErrDuplicateBlock  = errors.New("block already in destination shard")
+)
+
 // SyncManager handles basic cross-shard synchronization
 type SyncManager struct {
 	mutex sync.Mutex
@@ -14,32 +24,110 @@ func NewSyncManager() *SyncManager {
 	return &SyncManager{}
 }
 
-// SyncBlock transfers a block between shards
-func (sm *SyncManager) SyncBlock(source, destination *Shard, blockIndex int) bool {
+// lockShards acquires both shard mutexes in ID order to avoid deadlocks and
+// returns a function releasing them
+func lockShards(a, b *Shard) func() {
+	first, second := a, b
+	if second.ID < first.ID {
+		first, second = second, first
+	}
+	first.mutex.Lock()
+	second.mutex.Lock()
+	return func() {
+		second.mutex.Unlock()
+		first.mutex.Unlock()
+	}
+}
+
+// SyncBlock transfers a block between shards, refusing blocks the
+// destination already holds
+func (sm *SyncManager) SyncBlock(source, destination *Shard, blockIndex int) error {
+	if source == destination || source.ID == destination.ID {
+		return ErrSameShard
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
+	unlock := lockShards(source, destination)
+	defer unlock()
 
-	if blockIndex < 0 || blockIndex >= len(source.Blocks) {
-		return false
+	return moveBlock(source, destination, blockIndex)
+}
+
+// SyncBlockByHash transfers the block with the given hash between shards
+func (sm *SyncManager) SyncBlockByHash(source, destination *Shard, hash string) error {
+	if source == destination || source.ID == destination.ID {
+		return ErrSameShard
 	}
 
-	// Transfer block
-	block := source.Blocks[blockIndex]
-	destination.AddBlock(block)
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	unlock := lockShards(source, destination)
+	defer unlock()
 
-	// Remove from source
-	source.Blocks = append(source.Blocks[:blockIndex], source.Blocks[blockIndex+1:]...)
+	for i, b := range source.Blocks {
+		if b.Hash == hash {
+			return moveBlock(source, destination, i)
+		}
+	}
+	return fmt.Errorf("%w: %s in shard #%d", ErrBlockNotFound, hash, source.ID)
+}
 
-	// Rebuild Merkle trees
-	var sourceData, destData []string
-	for _, b := range source.Blocks {
-		sourceData = append(sourceData, b.Data)
+// SyncBlocks moves every source block that the destination's filter (as
+// returned by its BlockFilter) says it does not have, returning how many were
+// moved and skipped. A false positive leaves a block on the source rather
+// than losing it.
+func (sm *SyncManager) SyncBlocks(source, destination *Shard, filter *BloomFilter) (int, int, error) {
+	if source == destination || source.ID == destination.ID {
+		return 0, 0, ErrSameShard
 	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	unlock := lockShards(source, destination)
+	defer unlock()
+
+	moved, skipped := 0, 0
+	for i := 0; i < len(source.Blocks); {
+		if filter.Test(source.Blocks[i].Hash) {
+			skipped++
+			i++
+			continue
+		}
+		if err := moveBlock(source, destination, i); err != nil {
+			return moved, skipped, err
+		}
+		moved++
+	}
+	return moved, skipped, nil
+}
+
+// moveBlock moves a block between shards; both shard mutexes must be held
+func moveBlock(source, destination *Shard, blockIndex int) error {
+	if len(source.Blocks) == 0 {
+		return fmt.Errorf("%w: shard #%d", ErrEmptySource, source.ID)
+	}
+	if blockIndex < 0 || blockIndex >= len(source.Blocks) {
+		return fmt.Errorf("%w: %d for shard #%d (block count: %d)", ErrIndexOutOfRange, blockIndex, source.ID, len(source.Blocks))
+	}
+
+	hash := source.Blocks[blockIndex].Hash
 	for _, b := range destination.Blocks {
-		destData = append(destData, b.Data)
+		if b.Hash == hash {
+			return fmt.Errorf("%w: %s in shard #%d", ErrDuplicateBlock, hash, destination.ID)
+		}
 	}
-	source.Tree = NewMerkleTree(sourceData)
-	destination.Tree = NewMerkleTree(destData)
 
-	return true
+	// Carry the body along, since the destination may not share the
+	// source's body store
+	block, err := source.fullBlock(blockIndex)
+	if err != nil {
+		return err
+	}
+
+	// Transfer block (appending updates the destination tree incrementally)
+	source.removeBlockAt(blockIndex)
+	destination.addBlock(block)
+
+	return nil
 }
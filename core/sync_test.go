@@ -0,0 +1,97 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fillShard adds n distinct blocks to shard, each a successor of prev
+func fillShard(t testing.TB, shard *Shard, prev Block, prefix string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		shard.AddBlock(mustGenerateBlock(t, prev, fmt.Sprintf("%s-%d", prefix, i)))
+	}
+}
+
+func TestSyncBlockRacesWithAddBlock(t *testing.T) {
+	source, destination := NewShard(0), NewShard(1)
+	genesis := GenesisBlock()
+	fillShard(t, source, genesis, "initial", 50)
+
+	sm := NewSyncManager()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		fillShard(t, source, genesis, "added", 200)
+	}()
+	moved := 0
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if err := sm.SyncBlock(source, destination, 0); err == nil {
+				moved++
+			} else if !errors.Is(err, ErrEmptySource) {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if len(source.Blocks)+len(destination.Blocks) != 250 || len(destination.Blocks) != moved {
+		t.Fatalf("source %d + destination %d blocks after moving %d, want 250", len(source.Blocks), len(destination.Blocks), moved)
+	}
+	for _, shard := range []*Shard{source, destination} {
+		if want := newBlockTree(shard.Blocks).GetRootHash(); shard.GetRoot() != want {
+			t.Errorf("shard #%d root %s, rebuilt %s", shard.ID, shard.GetRoot(), want)
+		}
+	}
+}
+
+func TestSyncBlockErrors(t *testing.T) {
+	source, destination := NewShard(0), NewShard(1)
+	sm := NewSyncManager()
+	if err := sm.SyncBlock(source, destination, 0); !errors.Is(err, ErrEmptySource) {
+		t.Errorf("empty source: %v", err)
+	}
+
+	fillShard(t, source, GenesisBlock(), "block", 3)
+	if err := sm.SyncBlock(source, source, 0); !errors.Is(err, ErrSameShard) {
+		t.Errorf("same shard: %v", err)
+	}
+	for _, index := range []int{-1, 3} {
+		if err := sm.SyncBlock(source, destination, index); !errors.Is(err, ErrIndexOutOfRange) {
+			t.Errorf("index %d: %v", index, err)
+		}
+	}
+	if err := sm.SyncBlockByHash(source, destination, "missing"); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("unknown hash: %v", err)
+	}
+
+	block := source.Blocks[1]
+	if err := sm.SyncBlockByHash(source, destination, block.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if len(destination.Blocks) != 1 || destination.Blocks[0].Hash != block.Hash {
+		t.Fatal("block moved by hash not in the destination")
+	}
+	source.AddBlock(block)
+	if err := sm.SyncBlockByHash(source, destination, block.Hash); !errors.Is(err, ErrDuplicateBlock) {
+		t.Errorf("duplicate block: %v", err)
+	}
+}
+
+func TestEnhancedTransferPropagatesSyncErrors(t *testing.T) {
+	source, destination := NewShard(0), NewShard(1)
+	fillShard(t, source, GenesisBlock(), "block", 2)
+	esm := NewEnhancedSyncManager("key")
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 5); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("out-of-range transfer: %v", err)
+	}
+	if err := esm.CreateAuthenticatedTransfer(source, source, 0); !errors.Is(err, ErrSameShard) {
+		t.Errorf("transfer within one shard: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ConsistencySeverity ranks a finding of CheckSystemConsistency
+type ConsistencySeverity string
+
+const (
+	SeverityInfo     ConsistencySeverity = "info"     // Expected after pruning or archiving
+	SeverityWarning  ConsistencySeverity = "warning"  // Redundant or unreferenced data; safe to start
+	SeverityCritical ConsistencySeverity = "critical" // A view lost or altered blocks; do not start
+)
+
+// ConsistencyFindingKind names how the views of the ledger disagree
+type ConsistencyFindingKind string
+
+const (
+	FindingChainUnreadable ConsistencyFindingKind = "chain unreadable" // The main chain could not be iterated
+	FindingUnshardedBlock  ConsistencyFindingKind = "unsharded block"  // Main-chain block in no shard and not archived
+	FindingArchivedBlock   ConsistencyFindingKind = "archived block"   // Main-chain block in no shard, archived by the state manager
+	FindingOffChainBlock   ConsistencyFindingKind = "off-chain block"  // Shard block at a height the chain holds, not on the main chain
+	FindingUnprovenPrune   ConsistencyFindingKind = "unproven prune"   // Shard block pruned from the chain with no archive record
+	FindingPrunedBlock     ConsistencyFindingKind = "pruned block"     // Shard block pruned from the chain, archived by the state manager
+	FindingDuplicateBlock  ConsistencyFindingKind = "duplicate block"  // Block held by more than one shard
+	FindingStateOrphan     ConsistencyFindingKind = "state orphan"     // State manager block off the main chain and in no shard
+	FindingShardRoot       ConsistencyFindingKind = "shard root"       // Shard failed VerifySelf
+	FindingActiveTrie      ConsistencyFindingKind = "active trie"      // Active trie does not match the active blocks
+	FindingArchiveTrie     ConsistencyFindingKind = "archive trie"     // Archive trie does not match the archived blocks
+)
+
+// findingSeverity classifies each kind of finding
+var findingSeverity = map[ConsistencyFindingKind]ConsistencySeverity{
+	FindingChainUnreadable: SeverityCritical,
+	FindingUnshardedBlock:  SeverityCritical,
+	FindingArchivedBlock:   SeverityInfo,
+	FindingOffChainBlock:   SeverityCritical,
+	FindingUnprovenPrune:   SeverityCritical,
+	FindingPrunedBlock:     SeverityInfo,
+	FindingDuplicateBlock:  SeverityWarning,
+	FindingStateOrphan:     SeverityWarning,
+	FindingShardRoot:       SeverityCritical,
+	FindingActiveTrie:      SeverityCritical,
+	FindingArchiveTrie:     SeverityCritical,
+}
+
+// severityRank orders findings with the most severe first
+var severityRank = map[ConsistencySeverity]int{SeverityCritical: 0, SeverityWarning: 1, SeverityInfo: 2}
+
+// ConsistencyFinding is one disagreement between the chain, the shards and
+// the state manager
+type ConsistencyFinding struct {
+	Kind     ConsistencyFindingKind `json:"kind"`
+	Severity ConsistencySeverity    `json:"severity"`
+	ShardID  int                    `json:"shard_id"` // -1 when not about one shard
+	Index    int                    `json:"index"`    // Block index, -1 when not about one block
+	Hash     string                 `json:"hash,omitempty"`
+	Detail   string                 `json:"detail,omitempty"`
+}
+
+// String describes the finding
+func (f ConsistencyFinding) String() string {
+	s := fmt.Sprintf("[%s] %s", f.Severity, f.Kind)
+	if f.ShardID >= 0 {
+		s += fmt.Sprintf(" in shard #%d", f.ShardID)
+	}
+	if f.Index >= 0 {
+		s += fmt.Sprintf(": block #%d %s", f.Index, f.Hash)
+	}
+	if f.Detail != "" {
+		s += ": " + f.Detail
+	}
+	return s
+}
+
+// ConsistencyReport is the result of CheckSystemConsistency
+type ConsistencyReport struct {
+	ChainBlocks int                  `json:"chain_blocks"` // Main-chain blocks held
+	ShardBlocks int                  `json:"shard_blocks"` // Blocks held across shards
+	StateBlocks int                  `json:"state_blocks"` // Active and archived blocks in memory
+	Findings    []ConsistencyFinding `json:"findings"`     // Most severe first, then by kind, shard and index
+}
+
+// Count returns how many findings have the given severity
+func (r ConsistencyReport) Count(severity ConsistencySeverity) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Critical reports whether any finding is critical
+func (r ConsistencyReport) Critical() bool {
+	return r.Count(SeverityCritical) > 0
+}
+
+// CheckSystemConsistency cross-references the blocks held by the main chain,
+// the shards and the state manager, and verifies every shard root and the
+// state manager's active and archive tries, e.g. after loading persisted
+// state. The state manager's archive is what vouches for blocks pruned from
+// the chain or the shards: a block missing from one view but archived is
+// reported as info, and one missing without a record as critical. Nil
+// arguments are left out of the comparison.
+func CheckSystemConsistency(bc *Blockchain, sm *ShardManager, st *StateManager) ConsistencyReport {
+	var report ConsistencyReport
+	add := func(kind ConsistencyFindingKind, shardID, index int, hash, detail string) {
+		report.Findings = append(report.Findings, ConsistencyFinding{
+			Kind: kind, Severity: findingSeverity[kind], ShardID: shardID, Index: index, Hash: hash, Detail: detail,
+		})
+	}
+
+	// Main-chain blocks by hash, and the lowest height still held
+	chain := make(map[string]int)
+	chainFirst := 0
+	if bc != nil {
+		it := bc.Iterator(0)
+		for b, ok := it.Next(); ok; b, ok = it.Next() {
+			if len(chain) == 0 {
+				chainFirst = b.Index
+			}
+			chain[b.Hash] = b.Index
+		}
+		if err := it.Err(); err != nil {
+			add(FindingChainUnreadable, -1, -1, "", err.Error())
+		}
+		report.ChainBlocks = len(chain)
+	}
+
+	// Blocks the state manager holds or archived; compacted segments only
+	// keep index ranges
+	held := make(map[string]int)
+	archived := make(map[string]bool)
+	if st != nil {
+		for _, b := range st.ActiveBlocks {
+			held[b.Hash] = b.Index
+		}
+		for _, b := range st.PrunedBlocks {
+			held[b.Hash] = b.Index
+			archived[b.Hash] = true
+		}
+		report.StateBlocks = len(held)
+	}
+	isArchived := func(hash string, index int) bool {
+		if st == nil {
+			return false
+		}
+		if archived[hash] {
+			return true
+		}
+		for _, ref := range st.Segments {
+			if index >= ref.FirstIndex && index <= ref.LastIndex {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Shard blocks by hash, with the shards holding each
+	sharded := make(map[string][]int)
+	if sm != nil {
+		for _, view := range sm.GetAllShardViews() {
+			for _, b := range view.Blocks {
+				if owners := sharded[b.Hash]; len(owners) == 1 {
+					add(FindingDuplicateBlock, view.ID, b.Index, b.Hash, fmt.Sprintf("also in shard #%d", owners[0]))
+				}
+				if len(sharded[b.Hash]) == 0 {
+					report.ShardBlocks++
+				}
+				sharded[b.Hash] = append(sharded[b.Hash], view.ID)
+				if bc == nil {
+					continue
+				}
+				if _, onChain := chain[b.Hash]; onChain || len(sharded[b.Hash]) > 1 {
+					continue
+				}
+				switch {
+				case b.Index >= chainFirst:
+					add(FindingOffChainBlock, view.ID, b.Index, b.Hash, "not on the main chain")
+				case isArchived(b.Hash, b.Index):
+					add(FindingPrunedBlock, view.ID, b.Index, b.Hash, "pruned from the chain, archived by the state manager")
+				default:
+					add(FindingUnprovenPrune, view.ID, b.Index, b.Hash, "pruned from the chain with no archive record")
+				}
+			}
+		}
+		for id, err := range sm.VerifyAll(1) {
+			add(FindingShardRoot, id, -1, "", err.Error())
+		}
+	}
+
+	if bc != nil && sm != nil {
+		for hash, index := range chain {
+			if _, exists := sharded[hash]; exists {
+				continue
+			}
+			if isArchived(hash, index) {
+				add(FindingArchivedBlock, -1, index, hash, "in no shard, archived by the state manager")
+			} else {
+				add(FindingUnshardedBlock, -1, index, hash, "in no shard and not archived")
+			}
+		}
+	}
+
+	if st != nil {
+		for hash, index := range held {
+			_, onChain := chain[hash]
+			_, inShard := sharded[hash]
+			if bc != nil && !onChain && !inShard && index >= chainFirst {
+				add(FindingStateOrphan, -1, index, hash, "off the main chain and in no shard")
+			}
+		}
+		active := make(map[string]string, len(st.ActiveBlocks))
+		for _, b := range st.ActiveBlocks {
+			active[b.Hash] = stateValue(b)
+		}
+		if err := checkTrieEntries(st.ActiveTrie, active); err != nil {
+			add(FindingActiveTrie, -1, -1, "", err.Error())
+		}
+		archive := make(map[string]string, len(st.PrunedBlocks))
+		for _, b := range st.PrunedBlocks {
+			value := b.Data
+			if value == "" {
+				value = b.DataHash
+			}
+			archive[b.Hash] = value
+		}
+		if err := checkTrieEntries(st.ArchiveTrie, archive); err != nil {
+			add(FindingArchiveTrie, -1, -1, "", err.Error())
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if severityRank[a.Severity] != severityRank[b.Severity] {
+			return severityRank[a.Severity] < severityRank[b.Severity]
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.ShardID != b.ShardID {
+			return a.ShardID < b.ShardID
+		}
+		return a.Index < b.Index
+	})
+	return report
+}
+
+// checkTrieEntries checks that a trie holds exactly want, and for a
+// SuccinctTrie that its root is the one rebuilt from want. Empty values
+// are not stored, so they are left out.
+func checkTrieEntries(t Trie, want map[string]string) error {
+	for key, value := range want {
+		if value == "" {
+			delete(want, key)
+		}
+	}
+	if t == nil {
+		if len(want) > 0 {
+			return fmt.Errorf("no trie over %d blocks", len(want))
+		}
+		return nil
+	}
+	var problem error
+	entries := 0
+	t.Iterate(func(key, value string) bool {
+		entries++
+		if expected, exists := want[key]; !exists {
+			problem = fmt.Errorf("holds %s, which is not among its blocks", key)
+		} else if value != expected {
+			problem = fmt.Errorf("value of %s does not match its block", key)
+		}
+		return problem == nil
+	})
+	if problem != nil {
+		return problem
+	}
+	if entries != len(want) {
+		return fmt.Errorf("holds %d entries for %d blocks", entries, len(want))
+	}
+	if st, ok := t.(*SuccinctTrie); ok {
+		rebuilt := NewSuccinctTrie(WithTrieHasher(st.hasher()))
+		for key, value := range want {
+			rebuilt.Insert(key, value)
+		}
+		if root := rebuilt.GetMerkleRoot(); root != st.GetMerkleRoot() {
+			return fmt.Errorf("stored root %s, rebuilt %s", st.GetMerkleRoot(), root)
+		}
+	}
+	return nil
+}
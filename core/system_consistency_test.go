@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// consistentSystem returns a chain of 12 blocks after genesis, a forest and
+// a state manager holding all of them, the oldest nine archived
+func consistentSystem(t *testing.T) (*Blockchain, *ShardManager, *StateManager) {
+	t.Helper()
+	bc := longChain(t, 12)
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	st := NewStateManager(4)
+	for _, b := range bc.Blocks {
+		sm.DistributeBlock(b)
+		st.AddBlock(b)
+	}
+	if len(st.PrunedBlocks) != 9 || sm.Shards.Size() < 3 {
+		t.Fatalf("%d blocks archived, %d shards", len(st.PrunedBlocks), sm.Shards.Size())
+	}
+	return bc, sm, st
+}
+
+// shardHolding returns the shard holding the block at index and the
+// block's position in it
+func shardHolding(t *testing.T, sm *ShardManager, index int) (*Shard, int) {
+	t.Helper()
+	for _, shard := range sm.Shards.GetAllShards() {
+		for i, b := range shard.Blocks {
+			if b.Index == index {
+				return shard, i
+			}
+		}
+	}
+	t.Fatalf("no shard holds block #%d", index)
+	return nil, 0
+}
+
+// findingKinds returns "severity kind" for each finding, sorted
+func findingKinds(report ConsistencyReport) []string {
+	var kinds []string
+	for _, f := range report.Findings {
+		kinds = append(kinds, fmt.Sprintf("%s %s", f.Severity, f.Kind))
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestConsistentSystemHasNoFindings(t *testing.T) {
+	bc, sm, st := consistentSystem(t)
+	report := CheckSystemConsistency(bc, sm, st)
+	if len(report.Findings) != 0 {
+		t.Fatalf("findings %v", report.Findings)
+	}
+	if report.ChainBlocks != 13 || report.ShardBlocks != 13 || report.StateBlocks != 13 {
+		t.Fatalf("report %+v", report)
+	}
+	if report := CheckSystemConsistency(nil, nil, nil); len(report.Findings) != 0 || report.Critical() {
+		t.Fatalf("nothing to check: %+v", report)
+	}
+}
+
+func TestCheckSystemConsistencyClassifiesFindings(t *testing.T) {
+	for name, tc := range map[string]struct {
+		inject   func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager)
+		want     []string
+		critical bool
+	}{
+		"block lost from its shard": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			shard, i := shardHolding(t, sm, 11)
+			shard.removeBlockAt(i)
+		}, []string{"critical unsharded block"}, true},
+		"archived block dropped from its shard": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			shard, i := shardHolding(t, sm, 2)
+			shard.removeBlockAt(i)
+		}, []string{"info archived block"}, false},
+		"shard block off the chain": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			sm.Shards.GetAllShards()[0].AddBlock(offChain(t))
+		}, []string{"critical off-chain block"}, true},
+		"archived block pruned from the chain": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			bc.Blocks = bc.Blocks[3:]
+		}, []string{"info pruned block", "info pruned block", "info pruned block"}, false},
+		"block in two shards": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			shard, i := shardHolding(t, sm, 7)
+			for _, other := range sm.Shards.GetAllShards() {
+				if other != shard {
+					other.AddBlock(shard.Blocks[i])
+					return
+				}
+			}
+		}, []string{"warning duplicate block"}, false},
+		"state block nowhere else": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			st.AddBlock(offChain(t))
+		}, []string{"warning state orphan"}, false},
+		"shard block altered": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			shard, i := shardHolding(t, sm, 4)
+			shard.Blocks[i].Data = "altered"
+		}, []string{"critical shard root"}, true},
+		"active trie altered": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			st.ActiveTrie.Insert(st.ActiveBlocks[0].Hash, "altered")
+		}, []string{"critical active trie"}, true},
+		"archive trie altered": {func(t *testing.T, bc *Blockchain, sm *ShardManager, st *StateManager) {
+			st.ArchiveTrie.Insert("unknown", "block")
+		}, []string{"critical archive trie"}, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			bc, sm, st := consistentSystem(t)
+			tc.inject(t, bc, sm, st)
+			report := CheckSystemConsistency(bc, sm, st)
+			if got := findingKinds(report); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("findings %v, want %v", report.Findings, tc.want)
+			}
+			if report.Critical() != tc.critical {
+				t.Fatalf("critical %v: %v", report.Critical(), report.Findings)
+			}
+		})
+	}
+}
+
+func TestUnprovenPruneIsCritical(t *testing.T) {
+	bc, sm, _ := consistentSystem(t)
+
+	// A state manager that archived nothing cannot vouch for pruned blocks
+	st := NewStateManager(100)
+	for _, b := range bc.Blocks {
+		st.AddBlock(b)
+	}
+	bc.Blocks = bc.Blocks[3:]
+	shard, i := shardHolding(t, sm, 12)
+	shard.removeBlockAt(i)
+	sm.Shards.GetAllShards()[0].AddBlock(offChain(t))
+
+	report := CheckSystemConsistency(bc, sm, st)
+	want := []string{
+		"critical off-chain block",
+		"critical unproven prune", "critical unproven prune", "critical unproven prune",
+		"critical unsharded block",
+	}
+	if got := findingKinds(report); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("findings %v", report.Findings)
+	}
+	if report.Count(SeverityCritical) != 5 || report.Count(SeverityWarning) != 0 {
+		t.Fatalf("counts %d critical, %d warning", report.Count(SeverityCritical), report.Count(SeverityWarning))
+	}
+
+	// The most severe findings come first, and each names its block
+	st.AddBlock(fixedBlocks(9)[8])
+	report = CheckSystemConsistency(bc, sm, st)
+	last := report.Findings[len(report.Findings)-1]
+	if report.Findings[0].Severity != SeverityCritical || last.Severity != SeverityWarning {
+		t.Fatalf("findings out of order: %v", report.Findings)
+	}
+	for _, f := range report.Findings {
+		if f.Kind == FindingUnprovenPrune && (f.Index > 2 || !strings.Contains(f.String(), "[critical] unproven prune in shard #")) {
+			t.Fatalf("finding %s", f)
+		}
+	}
+}
+
+// offChain returns a block at height 11 that is not the chain's
+func offChain(t *testing.T) Block {
+	t.Helper()
+	b := fixedBlocks(6)[5]
+	b.Index = 11
+	b.Hash = calculateHash(b)
+	return b
+}
@@ -0,0 +1,316 @@
+package core
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Errors returned by threshold signing
+var (
+	ErrNoThresholdKey        = errors.New("threshold key has not been generated")
+	ErrNotEnoughSigners      = errors.New("not enough signers")
+	ErrUnknownSigner         = errors.New("signer is not a participant")
+	ErrInvalidSignatureShare = errors.New("invalid signature share")
+	ErrInvalidKeyShare       = errors.New("key share does not match dealer commitments")
+	ErrInvalidThreshold      = errors.New("threshold must be between 1 and the number of participants")
+)
+
+// thresholdCurve is the group threshold signatures are computed in
+var thresholdCurve = elliptic.P256()
+
+// PublicKey is a point on P-256
+type PublicKey struct {
+	X, Y *big.Int
+}
+
+// Bytes returns the compressed encoding of the point
+func (pk PublicKey) Bytes() []byte {
+	if pk.X == nil || pk.Y == nil {
+		return nil
+	}
+	return elliptic.MarshalCompressed(thresholdCurve, pk.X, pk.Y)
+}
+
+// Signature is a Schnorr signature (R, z) over P-256, verified by
+// z*G == R + c*Y with c = H(R, Y, message)
+type Signature struct {
+	R PublicKey
+	Z *big.Int
+}
+
+// signingNonces are one signer's secret nonces for a signing session
+type signingNonces struct {
+	d, e *big.Int
+}
+
+// signingCommitment is one signer's public nonce commitment (D, E)
+type signingCommitment struct {
+	id   int
+	d, e PublicKey
+}
+
+// checkThreshold rejects a threshold no signer set of n participants can meet,
+// or that any empty set would
+func checkThreshold(threshold, n int) error {
+	if threshold < 1 || threshold > n {
+		return fmt.Errorf("%w: %d of %d", ErrInvalidThreshold, threshold, n)
+	}
+	return nil
+}
+
+// GenerateThresholdKey runs a Feldman verifiable distributed key generation
+// among the participants: each deals a random polynomial of degree
+// Threshold-1, every participant checks the shares it receives against the
+// dealer's commitments and sums them into its key share. The group secret
+// is the sum of the dealers' constant terms and is never assembled.
+func (mpc *MPCProtocol) GenerateThresholdKey() (PublicKey, error) {
+	if err := checkThreshold(mpc.Threshold, len(mpc.Participants)); err != nil {
+		return PublicKey{}, err
+	}
+	n := thresholdCurve.Params().N
+	keyShares := make(map[int]*big.Int, len(mpc.Participants))
+	for _, p := range mpc.Participants {
+		keyShares[p.ID] = big.NewInt(0)
+	}
+	var group PublicKey
+
+	for range mpc.Participants {
+		coeffs, err := randomPolynomial(n, mpc.Threshold-1)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		commitments := make([]PublicKey, len(coeffs))
+		for k, a := range coeffs {
+			commitments[k] = scalarBaseMult(a)
+		}
+		for _, p := range mpc.Participants {
+			share := evaluateModN(coeffs, p.ID+1, n)
+			if !pointsEqual(scalarBaseMult(share), commitmentAt(commitments, p.ID+1)) {
+				return PublicKey{}, fmt.Errorf("%w: participant #%d", ErrInvalidKeyShare, p.ID)
+			}
+			keyShares[p.ID].Add(keyShares[p.ID], share).Mod(keyShares[p.ID], n)
+		}
+		group = addPoints(group, commitments[0])
+	}
+
+	mpc.keyShares = keyShares
+	mpc.publicShares = make(map[int]PublicKey, len(keyShares))
+	for id, share := range keyShares {
+		mpc.publicShares[id] = scalarBaseMult(share)
+	}
+	mpc.groupKey = group
+	return group, nil
+}
+
+// GroupPublicKey returns the key threshold signatures verify against
+func (mpc *MPCProtocol) GroupPublicKey() PublicKey {
+	return mpc.groupKey
+}
+
+// SignThreshold produces a signature over message from at least Threshold
+// participants, each signing with its own key share. Signers commit to
+// nonces, then each returns a share z_i = d_i + e_i*rho_i + lambda_i*s_i*c
+// that is checked against its public key share before the shares are
+// summed. A faulty share is reported with ErrInvalidSignatureShare.
+func (mpc *MPCProtocol) SignThreshold(message string, participantIDs []int) (Signature, error) {
+	if mpc.keyShares == nil {
+		return Signature{}, ErrNoThresholdKey
+	}
+	signers := make([]int, 0, len(participantIDs))
+	seen := make(map[int]bool, len(participantIDs))
+	for _, id := range participantIDs {
+		if _, ok := mpc.keyShares[id]; !ok {
+			return Signature{}, fmt.Errorf("%w: #%d", ErrUnknownSigner, id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			signers = append(signers, id)
+		}
+	}
+	if len(signers) < mpc.Threshold {
+		return Signature{}, fmt.Errorf("%w: need %d, have %d", ErrNotEnoughSigners, mpc.Threshold, len(signers))
+	}
+	n := thresholdCurve.Params().N
+
+	// Round one: every signer commits to a pair of nonces
+	nonces := make(map[int]signingNonces, len(signers))
+	commitments := make([]signingCommitment, 0, len(signers))
+	for _, id := range signers {
+		d, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return Signature{}, err
+		}
+		e, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return Signature{}, err
+		}
+		nonces[id] = signingNonces{d: d, e: e}
+		commitments = append(commitments, signingCommitment{id: id, d: scalarBaseMult(d), e: scalarBaseMult(e)})
+	}
+
+	// Binding factors tie each nonce to this message and signer set
+	rho := make(map[int]*big.Int, len(signers))
+	var r PublicKey
+	for _, c := range commitments {
+		rho[c.id] = bindingFactor(c.id, message, commitments)
+		r = addPoints(r, addPoints(c.d, scalarMult(c.e, rho[c.id])))
+	}
+	challenge := thresholdChallenge(r, mpc.groupKey, message)
+
+	// Round two: every signer returns its share, verified before use
+	z := big.NewInt(0)
+	for _, c := range commitments {
+		lambda := lagrangeAtZero(c.id, signers, n)
+		zi := new(big.Int).Mul(lambda, mpc.keyShares[c.id])
+		zi.Mul(zi, challenge)
+		zi.Add(zi, new(big.Int).Mul(nonces[c.id].e, rho[c.id]))
+		zi.Add(zi, nonces[c.id].d)
+		zi.Mod(zi, n)
+
+		expected := addPoints(addPoints(c.d, scalarMult(c.e, rho[c.id])),
+			scalarMult(mpc.publicShares[c.id], new(big.Int).Mul(lambda, challenge)))
+		if !pointsEqual(scalarBaseMult(zi), expected) {
+			return Signature{}, fmt.Errorf("%w: participant #%d", ErrInvalidSignatureShare, c.id)
+		}
+		z.Add(z, zi).Mod(z, n)
+	}
+	return Signature{R: r, Z: z}, nil
+}
+
+// VerifyThresholdSignature checks a threshold signature against the group key
+func VerifyThresholdSignature(pub PublicKey, message string, sig Signature) bool {
+	if !onCurve(pub) || !onCurve(sig.R) || sig.Z == nil {
+		return false
+	}
+	n := thresholdCurve.Params().N
+	if sig.Z.Sign() < 0 || sig.Z.Cmp(n) >= 0 {
+		return false
+	}
+	challenge := thresholdChallenge(sig.R, pub, message)
+	return pointsEqual(scalarBaseMult(sig.Z), addPoints(sig.R, scalarMult(pub, challenge)))
+}
+
+// randomPolynomial returns degree+1 random coefficients modulo n
+func randomPolynomial(n *big.Int, degree int) ([]*big.Int, error) {
+	coeffs := make([]*big.Int, degree+1)
+	for i := range coeffs {
+		c, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return nil, err
+		}
+		coeffs[i] = c
+	}
+	return coeffs, nil
+}
+
+// evaluateModN computes f(x) mod n by Horner's rule
+func evaluateModN(coeffs []*big.Int, x int, n *big.Int) *big.Int {
+	result := big.NewInt(0)
+	xBig := big.NewInt(int64(x))
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		result.Mul(result, xBig)
+		result.Add(result, coeffs[i])
+		result.Mod(result, n)
+	}
+	return result
+}
+
+// commitmentAt computes f(x)*G from the Feldman commitments a_k*G
+func commitmentAt(commitments []PublicKey, x int) PublicKey {
+	var result PublicKey
+	power := big.NewInt(1)
+	xBig := big.NewInt(int64(x))
+	for _, c := range commitments {
+		result = addPoints(result, scalarMult(c, power))
+		power = new(big.Int).Mul(power, xBig)
+	}
+	return result
+}
+
+// lagrangeAtZero is the coefficient of participant id's share when
+// interpolating at zero over the signer set (x = id+1)
+func lagrangeAtZero(id int, signers []int, n *big.Int) *big.Int {
+	num, den := big.NewInt(1), big.NewInt(1)
+	xi := big.NewInt(int64(id + 1))
+	for _, other := range signers {
+		if other == id {
+			continue
+		}
+		xj := big.NewInt(int64(other + 1))
+		num.Mul(num, xj).Mod(num, n)
+		den.Mul(den, new(big.Int).Sub(xj, xi)).Mod(den, n)
+	}
+	return num.Mul(num, new(big.Int).ModInverse(den, n)).Mod(num, n)
+}
+
+// bindingFactor hashes a signer's ID with the message and every commitment
+func bindingFactor(id int, message string, commitments []signingCommitment) *big.Int {
+	h := sha256.New()
+	fmt.Fprintf(h, "rho:%d:%s", id, message)
+	for _, c := range commitments {
+		fmt.Fprintf(h, ":%d", c.id)
+		h.Write(c.d.Bytes())
+		h.Write(c.e.Bytes())
+	}
+	return hashToScalar(h.Sum(nil))
+}
+
+// thresholdChallenge is c = H(R, Y, message)
+func thresholdChallenge(r, pub PublicKey, message string) *big.Int {
+	h := sha256.New()
+	h.Write([]byte("challenge:"))
+	h.Write(r.Bytes())
+	h.Write(pub.Bytes())
+	h.Write([]byte(message))
+	return hashToScalar(h.Sum(nil))
+}
+
+func hashToScalar(digest []byte) *big.Int {
+	return new(big.Int).Mod(new(big.Int).SetBytes(digest), thresholdCurve.Params().N)
+}
+
+// The helpers below treat a PublicKey with nil coordinates as the identity,
+// which crypto/elliptic represents as (0, 0)
+
+func point(x, y *big.Int) PublicKey {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return PublicKey{}
+	}
+	return PublicKey{X: x, Y: y}
+}
+
+func scalarBaseMult(k *big.Int) PublicKey {
+	return point(thresholdCurve.ScalarBaseMult(new(big.Int).Mod(k, thresholdCurve.Params().N).Bytes()))
+}
+
+func scalarMult(p PublicKey, k *big.Int) PublicKey {
+	if p.X == nil {
+		return p
+	}
+	return point(thresholdCurve.ScalarMult(p.X, p.Y, new(big.Int).Mod(k, thresholdCurve.Params().N).Bytes()))
+}
+
+func addPoints(a, b PublicKey) PublicKey {
+	if a.X == nil {
+		return b
+	}
+	if b.X == nil {
+		return a
+	}
+	return point(thresholdCurve.Add(a.X, a.Y, b.X, b.Y))
+}
+
+func pointsEqual(a, b PublicKey) bool {
+	if a.X == nil || b.X == nil {
+		return a.X == nil && b.X == nil
+	}
+	return a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+}
+
+func onCurve(p PublicKey) bool {
+	return p.X != nil && p.Y != nil && thresholdCurve.IsOnCurve(p.X, p.Y)
+}
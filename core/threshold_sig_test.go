@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// mpcParticipants returns n participant nodes with IDs 0..n-1
+func mpcParticipants(n int) []*Node {
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		nodes[i] = &Node{ID: i}
+	}
+	return nodes
+}
+
+// thresholdProtocol returns a t-of-n protocol with its group key generated
+func thresholdProtocol(t *testing.T, threshold, n int) (*MPCProtocol, PublicKey) {
+	t.Helper()
+	mpc, err := NewMPCProtocol(mpcParticipants(n), threshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := mpc.GenerateThresholdKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mpc, group
+}
+
+func TestThresholdSigningTOfN(t *testing.T) {
+	mpc, group := thresholdProtocol(t, 3, 5)
+	const message = "block 42"
+
+	// Every signer set of at least t participants produces a valid signature
+	for _, signers := range [][]int{{0, 1, 2}, {2, 3, 4}, {0, 2, 4}, {4, 1, 3}, {0, 1, 2, 3, 4}} {
+		sig, err := mpc.SignThreshold(message, signers)
+		if err != nil {
+			t.Fatalf("signers %v: %v", signers, err)
+		}
+		if !VerifyThresholdSignature(group, message, sig) {
+			t.Fatalf("signers %v: signature rejected", signers)
+		}
+		if VerifyThresholdSignature(group, "block 43", sig) {
+			t.Fatalf("signers %v: signature verified for another message", signers)
+		}
+	}
+
+	// t-1 signers, even with a repeated ID, cannot sign
+	for _, signers := range [][]int{{0, 1}, {3, 3, 4}} {
+		if _, err := mpc.SignThreshold(message, signers); !errors.Is(err, ErrNotEnoughSigners) {
+			t.Fatalf("signers %v: %v", signers, err)
+		}
+	}
+	if _, err := mpc.SignThreshold(message, []int{0, 1, 9}); !errors.Is(err, ErrUnknownSigner) {
+		t.Fatalf("unknown signer: %v", err)
+	}
+
+	_, other := thresholdProtocol(t, 3, 5)
+	sig, _ := mpc.SignThreshold(message, []int{0, 1, 2})
+	if VerifyThresholdSignature(other, message, sig) {
+		t.Fatal("signature verified under another group key")
+	}
+	tampered := Signature{R: sig.R, Z: new(big.Int).Add(sig.Z, big.NewInt(1))}
+	if VerifyThresholdSignature(group, message, tampered) {
+		t.Fatal("tampered signature verified")
+	}
+}
+
+func TestThresholdOutOfRangeRejected(t *testing.T) {
+	for _, threshold := range []int{-1, 0, 6} {
+		if _, err := NewMPCProtocol(mpcParticipants(5), threshold); !errors.Is(err, ErrInvalidThreshold) {
+			t.Errorf("threshold %d of 5: %v", threshold, err)
+		}
+	}
+	if _, err := NewMPCProtocol(nil, 1); !errors.Is(err, ErrInvalidThreshold) {
+		t.Errorf("protocol without participants: %v", err)
+	}
+	for _, threshold := range []int{1, 5} {
+		if _, err := NewMPCProtocol(mpcParticipants(5), threshold); err != nil {
+			t.Errorf("threshold %d of 5: %v", threshold, err)
+		}
+	}
+
+	// The field is exported, so key generation checks it again
+	mpc, _ := NewMPCProtocol(mpcParticipants(3), 2)
+	mpc.Threshold = 0
+	if _, err := mpc.GenerateThresholdKey(); !errors.Is(err, ErrInvalidThreshold) {
+		t.Fatalf("key generation with threshold 0: %v", err)
+	}
+	if _, err := mpc.SignThreshold("m", []int{0}); !errors.Is(err, ErrNoThresholdKey) {
+		t.Fatalf("signing without a key: %v", err)
+	}
+}
+
+func TestCorruptedKeyShareRejected(t *testing.T) {
+	mpc, group := thresholdProtocol(t, 3, 5)
+	mpc.keyShares[1] = new(big.Int).Add(mpc.keyShares[1], big.NewInt(1))
+
+	if _, err := mpc.SignThreshold("block", []int{0, 1, 2}); !errors.Is(err, ErrInvalidSignatureShare) {
+		t.Fatalf("signing with a corrupted share: %v", err)
+	}
+
+	// Signers without the corrupted share are unaffected
+	sig, err := mpc.SignThreshold("block", []int{0, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyThresholdSignature(group, "block", sig) {
+		t.Fatal("signature from intact shares rejected")
+	}
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Transaction moves Amount from one address to another. Priority and then
+// Fee set its place in the mempool and Nonce orders transactions from one
+// sender.
+type Transaction struct {
+	From     string
+	To       string
+	Amount   uint64
+	Nonce    uint64
+	Fee      uint64
+	Data     string
+	Priority Priority `json:",omitempty"`
+}
+
+// Hash returns the transaction identifier
+func (tx Transaction) Hash() string {
+	record := fmt.Sprintf("%s|%s|%d|%d|%d|%s", tx.From, tx.To, tx.Amount, tx.Nonce, tx.Fee, tx.Data)
+	if priority := tx.Priority.hashed(); priority != "" {
+		record += "|priority:" + priority
+	}
+	hash := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(hash[:])
+}
+
+// transactionHashes lists the hashes of a batch of transactions
+func transactionHashes(txs []Transaction) []string {
+	hashes := make([]string, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	return hashes
+}
+
+// TransactionRoot returns the Merkle root covering a batch of transactions
+func TransactionRoot(txs []Transaction) string {
+	return NewMerkleTree(transactionHashes(txs)).GetRootHash()
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidReceipt is returned when a transfer receipt does not verify or
+// is presented to the wrong shard
+var ErrInvalidReceipt = errors.New("invalid transfer receipt")
+
+// TransferReceipt is the portable proof that a value transfer debited the
+// source shard. The destination shard records redeemed receipts in its
+// state, so a receipt credits the recipient at most once.
+type TransferReceipt struct {
+	TransferID       string `json:"transfer_id"` // Hash of the transaction
+	SourceShard      int    `json:"source_shard"`
+	DestShard        int    `json:"dest_shard"`
+	Nonce            string `json:"nonce"` // Random nonce bound into the debit commitment
+	Recipient        string `json:"recipient"`
+	Amount           uint64 `json:"amount"`
+	AmountCommitment string `json:"amount_commitment"` // Range-proven Pedersen commitment to Amount
+	SourceRoot       string `json:"source_root"`       // Source shard Merkle root at commit
+	SourceStateRoot  string `json:"source_state_root"` // Source account state root after the debit
+	Signature        string `json:"signature"`         // Hex ed25519 signature of the sync manager over the fields above
+
+	Certificate *CommitCertificate `json:"certificate,omitempty"` // Destination committee's decision, see AssignCommittee
+}
+
+// ReceiptMessage is the canonical encoding a receipt signature covers
+func ReceiptMessage(r TransferReceipt) string {
+	return fmt.Sprintf("receipt|%s|%d|%d|%s|%s|%d|%s|%s|%s", r.TransferID, r.SourceShard, r.DestShard, r.Nonce,
+		r.Recipient, r.Amount, r.AmountCommitment, r.SourceRoot, r.SourceStateRoot)
+}
+
+// VerifyReceiptSignature checks a receipt against the public receipt key of
+// the sync manager that issued it, see ReceiptPublicKey. Holding the public
+// key is not enough to sign receipts.
+func VerifyReceiptSignature(pub ed25519.PublicKey, receipt TransferReceipt) bool {
+	signature, err := hex.DecodeString(receipt.Signature)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(pub, []byte(ReceiptMessage(receipt)), signature)
+}
+
+// SetReceiptKey makes the manager sign transfer receipts with key, e.g. one
+// kept across restarts so receipts stay verifiable
+func (esm *EnhancedSyncManager) SetReceiptKey(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("receipt key must be %d bytes, got %d", ed25519.PrivateKeySize, len(key))
+	}
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	esm.receiptKey = key
+	return nil
+}
+
+// ReceiptPublicKey returns the key light clients and other shards verify
+// this manager's receipts with
+func (esm *EnhancedSyncManager) ReceiptPublicKey() ed25519.PublicKey {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	return esm.receiptKey.Public().(ed25519.PublicKey)
+}
+
+// VerifyReceipt reports whether the receipt was signed by this manager's receipt key
+func (esm *EnhancedSyncManager) VerifyReceipt(receipt TransferReceipt) bool {
+	return VerifyReceiptSignature(esm.ReceiptPublicKey(), receipt)
+}
+
+// signReceipt fills in a receipt's signature
+func (esm *EnhancedSyncManager) signReceipt(receipt *TransferReceipt) {
+	esm.mutex.Lock()
+	key := esm.receiptKey
+	esm.mutex.Unlock()
+	receipt.Signature = hex.EncodeToString(ed25519.Sign(key, []byte(ReceiptMessage(*receipt))))
+}
+
+// RedeemReceipt credits a receipt's amount to its recipient on the
+// destination shard, e.g. when the receipt is presented after the transfer
+// that issued it. Receipts already credited are refused with
+// ErrReceiptRedeemed.
+func (esm *EnhancedSyncManager) RedeemReceipt(toShard *Shard, receipt TransferReceipt) error {
+	toShard.mutex.Lock()
+	defer toShard.mutex.Unlock()
+	return esm.redeemReceipt(toShard, receipt)
+}
+
+// redeemReceipt implements RedeemReceipt; the caller must hold the shard mutex
+func (esm *EnhancedSyncManager) redeemReceipt(toShard *Shard, receipt TransferReceipt) error {
+	if !esm.VerifyReceipt(receipt) {
+		return fmt.Errorf("%w: %s signature", ErrInvalidReceipt, receipt.TransferID)
+	}
+	if receipt.DestShard != toShard.ID {
+		return fmt.Errorf("%w: %s is for shard #%d, not #%d", ErrInvalidReceipt, receipt.TransferID, receipt.DestShard, toShard.ID)
+	}
+	if !toShard.OwnsAddress(receipt.Recipient) {
+		return fmt.Errorf("%w: recipient %s in shard #%d", ErrAddressNotOwned, receipt.Recipient, toShard.ID)
+	}
+	return toShard.State.RedeemReceipt(receipt.TransferID, receipt.Recipient, receipt.Amount)
+}
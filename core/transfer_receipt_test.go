@@ -0,0 +1,156 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// receiptTransfer moves 30 from a sender to a recipient on another shard
+// and returns the receipt
+func receiptTransfer(t *testing.T, esm *EnhancedSyncManager) (*ShardManager, *Shard, *Shard, TransferReceipt) {
+	t.Helper()
+	sm := partitionedManager(t)
+	from, _ := sm.ShardForAddress("\x01alice")
+	to, _ := sm.ShardForAddress("~bob")
+	if from == to {
+		t.Fatal("sender and recipient on one shard")
+	}
+	if err := from.Credit("\x01alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := esm.TransferValueWithReceipt(from, to, Transaction{From: "\x01alice", To: "~bob", Amount: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm, from, to, receipt
+}
+
+func TestTransferReceiptReplayRefused(t *testing.T) {
+	esm := NewEnhancedSyncManager("key")
+	_, from, to, receipt := receiptTransfer(t, esm)
+	if to.BalanceOf("~bob") != 30 || from.BalanceOf("\x01alice") != 70 {
+		t.Fatalf("balances %d and %d after the transfer", from.BalanceOf("\x01alice"), to.BalanceOf("~bob"))
+	}
+	if receipt.SourceShard != from.ID || receipt.DestShard != to.ID || receipt.Amount != 30 || receipt.SourceRoot != from.GetRoot() {
+		t.Fatalf("receipt %+v", receipt)
+	}
+	if !esm.VerifyReceipt(receipt) {
+		t.Fatal("receipt rejected by its issuer")
+	}
+	if !to.State.HasRedeemed(receipt.TransferID) {
+		t.Fatal("credit did not record the receipt")
+	}
+
+	// The transfer credited the receipt, so presenting it again is refused
+	stateRoot := to.State.StateRoot()
+	if err := esm.RedeemReceipt(to, receipt); !errors.Is(err, ErrReceiptRedeemed) {
+		t.Fatalf("replayed receipt: %v", err)
+	}
+	if to.BalanceOf("~bob") != 30 || to.State.StateRoot() != stateRoot {
+		t.Fatal("replayed receipt changed the recipient's state")
+	}
+}
+
+func TestTransferReceiptSurvivesSerialization(t *testing.T) {
+	esm := NewEnhancedSyncManager("key")
+	_, _, _, receipt := receiptTransfer(t, esm)
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded TransferReceipt
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !esm.VerifyReceipt(decoded) {
+		t.Fatal("receipt rejected after a JSON round trip")
+	}
+
+	// Each signed field is covered
+	for name, tamper := range map[string]func(*TransferReceipt){
+		"amount":      func(r *TransferReceipt) { r.Amount++ },
+		"recipient":   func(r *TransferReceipt) { r.Recipient = "~mallory" },
+		"destination": func(r *TransferReceipt) { r.DestShard++ },
+		"source root": func(r *TransferReceipt) { r.SourceRoot = "root" },
+		"commitment":  func(r *TransferReceipt) { r.AmountCommitment = "00" },
+	} {
+		tampered := decoded
+		tamper(&tampered)
+		if esm.VerifyReceipt(tampered) {
+			t.Errorf("receipt with tampered %s verified", name)
+		}
+	}
+	if NewEnhancedSyncManager("key").VerifyReceipt(decoded) {
+		t.Fatal("receipt verified under another manager's key")
+	}
+}
+
+func TestReceiptKeyIsPublic(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esm := NewEnhancedSyncManager("key")
+	if err := esm.SetReceiptKey(key[:10]); err == nil {
+		t.Fatal("short receipt key accepted")
+	}
+	if err := esm.SetReceiptKey(key); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, receipt := receiptTransfer(t, esm)
+
+	// A verifier needs only the public key, and a restarted manager holding
+	// the same key still accepts the receipt
+	pub := esm.ReceiptPublicKey()
+	if !VerifyReceiptSignature(pub, receipt) || !pub.Equal(key.Public()) {
+		t.Fatal("receipt rejected under the public key")
+	}
+	restarted := NewEnhancedSyncManager("key")
+	restarted.SetReceiptKey(key)
+	if !restarted.VerifyReceipt(receipt) {
+		t.Fatal("receipt rejected after a restart with the same key")
+	}
+
+	// The shared transfer key no longer signs receipts
+	forged := receipt
+	forged.Amount = 3000
+	forged.Signature = NewHomomorphicAuthenticator("key").AuthenticateData(ReceiptMessage(forged))
+	if VerifyReceiptSignature(pub, forged) || VerifyReceiptSignature(nil, receipt) {
+		t.Fatal("receipt verified without the receipt key's signature")
+	}
+}
+
+func TestRedeemReceiptChecks(t *testing.T) {
+	esm := NewEnhancedSyncManager("key")
+	sm, from, to, receipt := receiptTransfer(t, esm)
+
+	// A receipt the destination has not seen yet credits once
+	fresh := TransferReceipt{TransferID: "later", SourceShard: from.ID, DestShard: to.ID, Recipient: "~carol", Amount: 5}
+	esm.signReceipt(&fresh)
+	if err := esm.RedeemReceipt(to, fresh); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.RedeemReceipt(to, fresh); !errors.Is(err, ErrReceiptRedeemed) || to.BalanceOf("~carol") != 5 {
+		t.Fatalf("second redemption: %v, balance %d", err, to.BalanceOf("~carol"))
+	}
+
+	forged := fresh
+	forged.TransferID, forged.Amount = "forged", 500
+	if err := esm.RedeemReceipt(to, forged); !errors.Is(err, ErrInvalidReceipt) {
+		t.Fatalf("unsigned receipt: %v", err)
+	}
+	if err := esm.RedeemReceipt(from, receipt); !errors.Is(err, ErrInvalidReceipt) {
+		t.Fatalf("receipt presented to the wrong shard: %v", err)
+	}
+
+	// Merging the destination away keeps its redeemed receipts
+	if err := sm.MergeShards(1000); err != nil {
+		t.Fatal(err)
+	}
+	merged, _ := sm.ShardForAddress("~bob")
+	if got := merged.State.RedeemedReceipts(); len(got) != 2 || !merged.State.HasRedeemed(receipt.TransferID) {
+		t.Fatalf("redeemed receipts after merging: %v", got)
+	}
+}
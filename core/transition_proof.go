@@ -0,0 +1,91 @@
+package core
+
+// TransitionProof shows that a shard root NewRoot is OldRoot with exactly
+// one block appended. The old leaves are hidden behind the frontier of the
+// old tree: the roots of its perfect subtrees, which fold into OldRoot and,
+// after pushing the block's leaf, into NewRoot. Only the appended block's
+// header is revealed, so the verifier can bind its leaf to BlockHash.
+type TransitionProof struct {
+	OldRoot   string      `json:"old_root"`
+	NewRoot   string      `json:"new_root"`
+	BlockHash string      `json:"block_hash"`
+	OldSize   int         `json:"old_size"`
+	Frontier  []string    `json:"frontier"` // Old perfect subtree roots indexed by height ("" if absent)
+	Version   TreeVersion `json:"version"`
+	Hasher    string      `json:"hasher,omitempty"` // Hash function name, empty for SHA-256
+	Block     Block       `json:"block"`            // Header of the appended block, without transactions
+}
+
+// TransitionSource supplies the proofs a ZKProver packages, e.g. a Shard
+// with transition proofs enabled
+type TransitionSource interface {
+	TransitionProof(oldRoot, newRoot, blockHash string) (TransitionProof, bool)
+}
+
+// proveAppend builds the proof for appending block to tree, before the
+// caller appends it
+func proveAppend(tree *MerkleTree, block Block) TransitionProof {
+	frontier := tree.frontier()
+	header := block
+	header.Transactions = nil
+	return TransitionProof{
+		OldRoot:   tree.GetRootHash(),
+		NewRoot:   foldFrontier(tree.hasher(), tree.version(), pushFrontier(tree.hasher(), tree.version(), tree.frontier(), tree.leafOf(block))),
+		BlockHash: block.Hash,
+		OldSize:   len(tree.Leaves),
+		Frontier:  frontier,
+		Version:   tree.version(),
+		Hasher:    hasherName(tree.hasher()),
+		Block:     header,
+	}
+}
+
+// ProveStateTransition returns the proof that newRoot is oldRoot with the
+// block blockHash appended, taken from the prover's Source. Without a
+// matching transition it returns a proof that does not verify.
+func (zk *ZKProver) ProveStateTransition(oldRoot, newRoot, blockHash string) TransitionProof {
+	if zk.Source != nil {
+		if proof, ok := zk.Source.TransitionProof(oldRoot, newRoot, blockHash); ok {
+			return proof
+		}
+	}
+	return TransitionProof{OldRoot: oldRoot, NewRoot: newRoot, BlockHash: blockHash}
+}
+
+// VerifyStateTransition checks a proof from ProveStateTransition
+func (zk *ZKProver) VerifyStateTransition(oldRoot, newRoot, blockHash string, proof TransitionProof) bool {
+	return VerifyStateTransition(oldRoot, newRoot, blockHash, proof)
+}
+
+// VerifyStateTransition checks that newRoot is oldRoot with exactly the block
+// blockHash appended as leaf OldSize
+func VerifyStateTransition(oldRoot, newRoot, blockHash string, proof TransitionProof) bool {
+	if proof.OldRoot != oldRoot || proof.NewRoot != newRoot || proof.BlockHash != blockHash {
+		return false
+	}
+	if proof.Block.Hash != blockHash || proof.Block.ComputeHash() != blockHash {
+		return false
+	}
+	if proof.Version != TreeV1 && proof.Version != TreeV2 {
+		return false
+	}
+	h, err := LookupHasher(proof.Hasher)
+	if err != nil {
+		return false
+	}
+	// The frontier's shape is fixed by the old size: one peak per set bit
+	if proof.OldSize < 0 || len(proof.Frontier) > 63 || proof.OldSize>>uint(len(proof.Frontier)) != 0 {
+		return false
+	}
+	for height, peak := range proof.Frontier {
+		if (peak != "") != (proof.OldSize>>uint(height)&1 == 1) {
+			return false
+		}
+	}
+	if foldFrontier(h, proof.Version, proof.Frontier) != oldRoot {
+		return false
+	}
+	frontier := append([]string{}, proof.Frontier...)
+	leaf := hashLeaf(h, proof.Version, proof.Block.Header().leafData())
+	return foldFrontier(h, proof.Version, pushFrontier(h, proof.Version, frontier, leaf)) == newRoot
+}
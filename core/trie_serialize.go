@@ -0,0 +1,226 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Errors returned when decoding or loading trie nodes
+var (
+	ErrCorruptNode  = errors.New("corrupt trie node")
+	ErrNodeNotFound = errors.New("trie node not found")
+)
+
+// trieMagic starts every serialized SHA-256 trie; tries hashed with another
+// function start with trieHasherMagic and the function's name
+const (
+	trieMagic       = "STRIE\x01"
+	trieHasherMagic = "STRIE\x02"
+)
+
+// encodeTrieNode gives the binary form of a node, which is exactly the
+// preimage of its hash (see TrieNode), so encoded nodes are content-addressed.
+// Child hashes that are not hex digests of hashSize bytes are rejected, so a
+// malformed proof cannot hash to the same preimage as a valid one.
+func encodeTrieNode(value string, children []TrieProofChild, hashSize int) ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(len(value)))
+	buf = append(buf, value...)
+	for _, child := range children {
+		raw, err := hex.DecodeString(child.Hash)
+		if err != nil || len(raw) != hashSize {
+			return nil, fmt.Errorf("%w: child %q has malformed hash %q", ErrCorruptNode, child.Label, child.Hash)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(child.Label)))
+		buf = append(buf, child.Label...)
+		buf = append(buf, raw...)
+	}
+	return buf, nil
+}
+
+// decodeTrieNode parses an encoded node whose child hashes are hashSize
+// bytes back into its value and children
+func decodeTrieNode(data []byte, hashSize int) (string, []TrieProofChild, error) {
+	valueLen, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < valueLen {
+		return "", nil, fmt.Errorf("%w: bad value length", ErrCorruptNode)
+	}
+	data = data[n:]
+	value := string(data[:valueLen])
+	data = data[valueLen:]
+
+	var children []TrieProofChild
+	for len(data) > 0 {
+		labelLen, n := binary.Uvarint(data)
+		if n <= 0 || labelLen == 0 || uint64(len(data)-n) < labelLen+uint64(hashSize) {
+			return "", nil, fmt.Errorf("%w: bad child entry", ErrCorruptNode)
+		}
+		data = data[n:]
+		label := string(data[:labelLen])
+		if len(children) > 0 && label[0] <= children[len(children)-1].Label[0] {
+			return "", nil, fmt.Errorf("%w: children out of order", ErrCorruptNode)
+		}
+		hash := hex.EncodeToString(data[labelLen : labelLen+uint64(hashSize)])
+		children = append(children, TrieProofChild{Label: label, Hash: hash})
+		data = data[labelLen+uint64(hashSize):]
+	}
+	return value, children, nil
+}
+
+// Serialize writes the trie as a magic header followed by length-prefixed
+// node encodings in pre-order. Tries not hashed with SHA-256 name their hash
+// function after the header.
+func (st *SuccinctTrie) Serialize(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	header := []byte(trieMagic)
+	if name := hasherName(st.hasher()); name != "" {
+		header = append([]byte(trieHasherMagic), binary.AppendUvarint(nil, uint64(len(name)))...)
+		header = append(header, name...)
+	}
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	if err := st.serializeNode(bw, st.Root); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (st *SuccinctTrie) serializeNode(w *bufio.Writer, node *TrieNode) error {
+	children := sortedChildren(node)
+	entries := make([]TrieProofChild, len(children))
+	for i, child := range children {
+		entries[i] = TrieProofChild{Label: string(child.Label), Hash: child.Hash}
+	}
+	record, err := encodeTrieNode(node.Value, entries, hashSize(st.hasher()))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(record)))); err != nil {
+		return err
+	}
+	if _, err := w.Write(record); err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := st.serializeNode(w, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeserializeTrie reads a trie written by Serialize, checking every child
+// against the hash its parent committed to. The trie must have been hashed
+// with the hash function opts select, SHA-256 unless WithTrieHasher is given.
+func DeserializeTrie(r io.Reader, opts ...TrieOption) (*SuccinctTrie, error) {
+	st := &SuccinctTrie{}
+	for _, opt := range opts {
+		opt(st)
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(trieMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("%w: missing trie header", ErrCorruptNode)
+	}
+	var name string
+	switch string(magic) {
+	case trieMagic:
+	case trieHasherMagic:
+		size, err := binary.ReadUvarint(br)
+		if err != nil || size == 0 || size > 255 {
+			return nil, fmt.Errorf("%w: bad hash function name", ErrCorruptNode)
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptNode, err)
+		}
+		name = string(raw)
+	default:
+		return nil, fmt.Errorf("%w: missing trie header", ErrCorruptNode)
+	}
+	if want := hasherName(st.hasher()); name != want {
+		return nil, fmt.Errorf("%w: trie uses %q, want %q", ErrHasherMismatch, name, want)
+	}
+
+	root, err := deserializeNode(st.hasher(), br, nil)
+	if err != nil {
+		return nil, err
+	}
+	st.Root = root
+	return st, nil
+}
+
+// readRecord reads a record of size bytes, growing the buffer only as the
+// bytes arrive, so a corrupt length cannot force a huge allocation
+func readRecord(r io.Reader, size uint64) ([]byte, error) {
+	if size > math.MaxInt32 {
+		return nil, fmt.Errorf("record of %d bytes is too large", size)
+	}
+	record, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(record)) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return record, nil
+}
+
+func deserializeNode(h Hasher, r *bufio.Reader, label []byte) (*TrieNode, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptNode, err)
+	}
+	record, err := readRecord(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptNode, err)
+	}
+	value, entries, err := decodeTrieNode(record, hashSize(h))
+	if err != nil {
+		return nil, err
+	}
+
+	node := &TrieNode{Label: label, Value: value, Hash: hexSum(h, record)}
+	for _, entry := range entries {
+		child, err := deserializeNode(h, r, []byte(entry.Label))
+		if err != nil {
+			return nil, err
+		}
+		if child.Hash != entry.Hash {
+			return nil, fmt.Errorf("%w: child %q hash mismatch", ErrCorruptNode, entry.Label)
+		}
+		if node.Children == nil {
+			node.Children = make(map[byte]*TrieNode)
+		}
+		node.Children[entry.Label[0]] = child
+	}
+	return node, nil
+}
+
+// Persist writes every node of the trie to a NodeStore so it can be opened
+// as a LazyTrie with OpenLazyTrie(store, st.GetMerkleRoot()), passing
+// WithTrieHasher to both if the trie is not hashed with SHA-256
+func (st *SuccinctTrie) Persist(store NodeStore) error {
+	return persistNode(store, st.Root, hashSize(st.hasher()))
+}
+
+func persistNode(store NodeStore, node *TrieNode, size int) error {
+	children := sortedChildren(node)
+	entries := make([]TrieProofChild, len(children))
+	for i, child := range children {
+		if err := persistNode(store, child, size); err != nil {
+			return err
+		}
+		entries[i] = TrieProofChild{Label: string(child.Label), Hash: child.Hash}
+	}
+	record, err := encodeTrieNode(node.Value, entries, size)
+	if err != nil {
+		return err
+	}
+	return store.PutNode(node.Hash, record)
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Shape of the transaction Bloom filter in block headers. Validators
+// recompute the filter, so these are consensus parameters: changing them
+// makes every node reject the others' filters.
+const (
+	TxBloomBits   = 2048
+	TxBloomHashes = 3
+)
+
+// newTxBloom creates an empty filter of the consensus shape
+func newTxBloom() *BloomFilter {
+	return NewBloomFilter(TxBloomBits, TxBloomHashes)
+}
+
+// TransactionBloom returns the hex-encoded filter over the hashes, senders
+// and recipients of txs that a block carrying them stores in TxBloom. It is
+// empty for no transactions.
+func TransactionBloom(txs []Transaction) string {
+	if len(txs) == 0 {
+		return ""
+	}
+	bf := newTxBloom()
+	for _, tx := range txs {
+		bf.Add(tx.Hash())
+		bf.Add(tx.From)
+		bf.Add(tx.To)
+	}
+	data, _ := bf.MarshalBinary()
+	return hex.EncodeToString(data)
+}
+
+// checkTxBloom verifies a block's TxBloom against its transactions; blocks
+// without a filter pass
+func checkTxBloom(b Block) error {
+	if b.TxBloom != "" && b.TxBloom != TransactionBloom(b.Transactions) {
+		return fmt.Errorf("%w: block #%d transactions do not match its TxBloom", ErrInvalidBlock, b.Index)
+	}
+	return nil
+}
+
+// txBloomMightContain tests the bits locs of an item against a header's
+// filter. Without a filter only blocks carrying no transactions are ruled
+// out; an undecodable filter rules out nothing.
+func txBloomMightContain(h BlockHeader, locs []uint64) bool {
+	if h.TxBloom == "" {
+		return h.TxRoot != ""
+	}
+	data, err := hex.DecodeString(h.TxBloom)
+	if err != nil {
+		return true
+	}
+	bf := &BloomFilter{}
+	if bf.UnmarshalBinary(data) != nil || bf.size != TxBloomBits || bf.hashFuncs != TxBloomHashes {
+		return true
+	}
+	return bf.hasLocations(locs)
+}
+
+// MightContain reports whether the block may hold a transaction with hash,
+// sender or recipient item, checking only the header's TxBloom. False is
+// certain; true may be a false positive.
+func (b Block) MightContain(item string) bool {
+	if b.TxRoot == "" && len(b.Transactions) > 0 {
+		return true
+	}
+	return txBloomMightContain(b.Header(), newTxBloom().locations(item))
+}
+
+// FindBlocksTouching returns the indices of main-chain blocks holding a
+// transaction from or to addr. Headers are scanned first; only blocks whose
+// TxBloom might contain addr have their bodies checked.
+func (bc *Blockchain) FindBlocksTouching(addr string) []int {
+	locs := newTxBloom().locations(addr)
+	var indices []int
+	it := bc.Iterator(0)
+	for b, ok := it.Next(); ok; b, ok = it.Next() {
+		if !txBloomMightContain(b.Header(), locs) {
+			continue
+		}
+		for _, tx := range b.Body().Transactions {
+			if tx.From == addr || tx.To == addr {
+				indices = append(indices, b.Index)
+				break
+			}
+		}
+	}
+	return indices
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// bloomChain produces blocks of four transfers each, block i moving funds
+// from ~sender-i to ~recipient-i, and returns the chain and blocks
+func bloomChain(t *testing.T, blocks int) (*Blockchain, []Block) {
+	t.Helper()
+	balances := make(map[string]uint64)
+	for i := 0; i < blocks; i++ {
+		balances[fmt.Sprintf("~sender-%d", i)] = 100
+	}
+	bc := fundedChain(t, balances)
+	mp := NewMempool(100)
+	bp := NewBlockProducer(bc, mp, 4)
+	var produced []Block
+	for i := 0; i < blocks; i++ {
+		for n := 0; n < 4; n++ {
+			submitAll(t, mp, Transaction{From: fmt.Sprintf("~sender-%d", i), To: fmt.Sprintf("~recipient-%d", i), Amount: 1, Nonce: uint64(n)})
+		}
+		b, err := bp.ProduceBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		produced = append(produced, b)
+	}
+	return bc, produced
+}
+
+func TestProducedBlocksCarryTxBloom(t *testing.T) {
+	bc, blocks := bloomChain(t, 10)
+	for i, b := range blocks {
+		if b.TxBloom == "" || b.TxBloom != TransactionBloom(b.Transactions) {
+			t.Fatalf("block #%d has filter %q", b.Index, b.TxBloom)
+		}
+		items := []string{fmt.Sprintf("~sender-%d", i), fmt.Sprintf("~recipient-%d", i), b.Transactions[2].Hash()}
+		for _, item := range items {
+			// The header alone is enough
+			if !b.MightContain(item) || !b.Header().Block().MightContain(item) {
+				t.Fatalf("block #%d rules out %s", b.Index, item)
+			}
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		want := fmt.Sprint([]int{blocks[i].Index})
+		if got := fmt.Sprint(bc.FindBlocksTouching(fmt.Sprintf("~recipient-%d", i))); got != want {
+			t.Fatalf("blocks touching ~recipient-%d: %s, want %s", i, got, want)
+		}
+	}
+	if got := bc.FindBlocksTouching("~nobody"); len(got) != 0 {
+		t.Fatalf("blocks touching an unknown address: %v", got)
+	}
+
+	// Data blocks hold no transactions; blocks without a filter rule out nothing
+	if GenesisBlock().MightContain("~sender-0") {
+		t.Fatal("genesis might hold a transaction")
+	}
+	unfiltered := blocks[0]
+	unfiltered.TxBloom = ""
+	if !unfiltered.MightContain("~nobody") || !unfiltered.Header().Block().MightContain("~nobody") {
+		t.Fatal("block without a filter ruled out an address")
+	}
+}
+
+func TestTxBloomFalsePositiveRate(t *testing.T) {
+	// A block of 100 transactions sets at most 300 items in the filter
+	var txs []Transaction
+	for i := 0; i < 100; i++ {
+		txs = append(txs, Transaction{From: fmt.Sprintf("~from-%d", i), To: fmt.Sprintf("~to-%d", i), Amount: 1})
+	}
+	b := Block{Index: 1, Transactions: txs, TxRoot: TransactionRoot(txs), TxBloom: TransactionBloom(txs)}
+	for _, tx := range txs {
+		if !b.MightContain(tx.From) || !b.MightContain(tx.To) || !b.MightContain(tx.Hash()) {
+			t.Fatal("false negative")
+		}
+	}
+
+	const trials = 10000
+	positives := 0
+	for i := 0; i < trials; i++ {
+		if b.MightContain(fmt.Sprintf("~absent-%d", i)) {
+			positives++
+		}
+	}
+	k, n, m := float64(TxBloomHashes), float64(3*len(txs)), float64(TxBloomBits)
+	expected := math.Pow(1-math.Exp(-k*n/m), k)
+	rate := float64(positives) / trials
+	t.Logf("false positive rate %.4f, expected %.4f", rate, expected)
+	if rate > 2*expected+0.005 {
+		t.Fatalf("false positive rate %.4f, expected about %.4f", rate, expected)
+	}
+
+	// A block of few transactions rules out nearly everything
+	small := Block{Index: 1, Transactions: txs[:4], TxRoot: TransactionRoot(txs[:4]), TxBloom: TransactionBloom(txs[:4])}
+	positives = 0
+	for i := 0; i < trials; i++ {
+		if small.MightContain(fmt.Sprintf("~absent-%d", i)) {
+			positives++
+		}
+	}
+	if positives > trials/1000 {
+		t.Fatalf("%d false positives in %d over four transactions", positives, trials)
+	}
+}
+
+func TestMismatchedTxBloomFailsValidation(t *testing.T) {
+	_, blocks := bloomChain(t, 2)
+	honest := blocks[0]
+	forged := honest
+	forged.TxBloom = blocks[1].TxBloom
+	forged.Hash = calculateHash(forged)
+
+	// A fresh chain over the same genesis accepts the honest block only
+	fresh := fundedChain(t, map[string]uint64{"~sender-0": 100, "~sender-1": 100})
+	if err := fresh.AddBlockCandidate(forged); !errors.Is(err, ErrInvalidBlock) || !strings.Contains(err.Error(), "TxBloom") {
+		t.Fatalf("block with another block's filter: %v", err)
+	}
+	if err := fresh.AddBlockCandidate(honest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Validation recomputes the filter of blocks already held
+	fresh.Blocks[len(fresh.Blocks)-1] = forged
+	if err := fresh.Validate(); !errors.Is(err, ErrInvalidBlock) || !strings.Contains(err.Error(), "TxBloom") {
+		t.Fatalf("validating a chain with a forged filter: %v", err)
+	}
+
+	// The filter is committed to by the hash
+	unhashed := honest
+	unhashed.TxBloom = blocks[1].TxBloom
+	if unhashed.ComputeHash() == honest.Hash {
+		t.Fatal("block hash does not cover TxBloom")
+	}
+}
@@ -0,0 +1,247 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned when changing the validator set
+var (
+	ErrDuplicateValidator     = errors.New("validator already in the set")
+	ErrTooFewValidators       = errors.New("validator set would fall below the fault tolerance minimum")
+	ErrInvalidValidatorChange = errors.New("invalid validator change")
+)
+
+// MinValidators is the smallest set RemoveNode leaves behind: 3f+1 nodes
+// tolerating f = 1 fault
+const MinValidators = 4
+
+// ValidatorChangeType says whether a change adds or removes a validator
+type ValidatorChangeType string
+
+const (
+	ValidatorAdd    ValidatorChangeType = "add"
+	ValidatorRemove ValidatorChangeType = "remove"
+)
+
+// ValidatorChange is one membership change recorded in a block. Additions
+// carry the new node's vote key so every replica can verify its votes.
+type ValidatorChange struct {
+	Type      ValidatorChangeType `json:"type"`
+	NodeID    int                 `json:"node_id"`
+	PublicKey string              `json:"public_key,omitempty"` // Hex ed25519 key, additions only
+}
+
+// encodeValidatorChanges gives the deterministic form of changes used for
+// hashing
+func encodeValidatorChanges(changes []ValidatorChange) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		parts[i] = fmt.Sprintf("%s:%d:%s", c.Type, c.NodeID, c.PublicKey)
+	}
+	return strings.Join(parts, ";")
+}
+
+// GenerateValidatorBlockAt creates the successor of prevBlock recording
+// membership changes, which take effect from the height after it
+func GenerateValidatorBlockAt(prevBlock Block, changes []ValidatorChange, timestamp time.Time) (Block, error) {
+	if len(changes) == 0 {
+		return Block{}, fmt.Errorf("%w: no changes", ErrInvalidValidatorChange)
+	}
+	block, err := GenerateBlockAt(prevBlock, "validators:"+encodeValidatorChanges(changes), timestamp)
+	if err != nil {
+		return Block{}, err
+	}
+	block.ValidatorChanges = append([]ValidatorChange{}, changes...)
+	block.Hash = calculateHash(block)
+	return block, nil
+}
+
+// NewNode creates a validator whose vote key derives from an ed25519 seed,
+// for adding to a running BFTManager through AddNode
+func NewNode(id int, seed []byte) (*Node, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("node #%d: seed must be %d bytes", id, ed25519.SeedSize)
+	}
+	signer := ed25519.NewKeyFromSeed(seed)
+	return &Node{ID: id, PublicKey: signer.Public().(ed25519.PublicKey), signer: signer}, nil
+}
+
+// applyValidatorChanges returns the vote keys of the set that results from
+// applying changes in order to keys, which is left unchanged
+func applyValidatorChanges(keys map[int]ed25519.PublicKey, changes []ValidatorChange) (map[int]ed25519.PublicKey, error) {
+	next := make(map[int]ed25519.PublicKey, len(keys)+len(changes))
+	for id, pub := range keys {
+		next[id] = pub
+	}
+	removed := false
+	for _, c := range changes {
+		switch c.Type {
+		case ValidatorAdd:
+			if _, exists := next[c.NodeID]; exists {
+				return nil, fmt.Errorf("%w: #%d", ErrDuplicateValidator, c.NodeID)
+			}
+			pub, err := hex.DecodeString(c.PublicKey)
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("%w: key of node #%d", ErrInvalidValidatorChange, c.NodeID)
+			}
+			next[c.NodeID] = ed25519.PublicKey(pub)
+		case ValidatorRemove:
+			if _, exists := next[c.NodeID]; !exists {
+				return nil, fmt.Errorf("%w: #%d", ErrUnknownNode, c.NodeID)
+			}
+			delete(next, c.NodeID)
+			removed = true
+		default:
+			return nil, fmt.Errorf("%w: type %q", ErrInvalidValidatorChange, c.Type)
+		}
+	}
+	if removed && len(next) < MinValidators {
+		return nil, fmt.Errorf("%w: %d validators, need %d", ErrTooFewValidators, len(next), MinValidators)
+	}
+	return next, nil
+}
+
+// ValidatorSetHash identifies a validator set by the hash of its node IDs
+// and vote keys in ID order
+func ValidatorSetHash(keys map[int]ed25519.PublicKey) string {
+	ids := make([]int, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	h := sha256.New()
+	for _, id := range ids {
+		h.Write([]byte(strconv.Itoa(id) + ":" + hex.EncodeToString(keys[id]) + ";"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validatorEpoch is a validator set that was replaced from height until
+type validatorEpoch struct {
+	until int
+	nodes []*Node
+}
+
+// AddNode schedules n to join the validator set. The change is proposed
+// through PendingValidatorChanges and takes effect once a block recording
+// it is applied with ApplyValidatorChanges, from the height after it.
+func (bft *BFTManager) AddNode(n *Node) error {
+	if n == nil || len(n.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: node has no vote key", ErrInvalidValidatorChange)
+	}
+	change := ValidatorChange{Type: ValidatorAdd, NodeID: n.ID, PublicKey: hex.EncodeToString(n.PublicKey)}
+	if err := bft.schedule(change); err != nil {
+		return err
+	}
+	if bft.joining == nil {
+		bft.joining = make(map[int]*Node)
+	}
+	bft.joining[n.ID] = n
+	return nil
+}
+
+// RemoveNode schedules a node to leave the validator set, like AddNode. It
+// is rejected if the set would drop below MinValidators.
+func (bft *BFTManager) RemoveNode(id int) error {
+	return bft.schedule(ValidatorChange{Type: ValidatorRemove, NodeID: id})
+}
+
+// schedule queues a change if it applies on top of the pending ones
+func (bft *BFTManager) schedule(change ValidatorChange) error {
+	changes := append(bft.PendingValidatorChanges(), change)
+	if _, err := applyValidatorChanges(bft.PublicKeys(), changes); err != nil {
+		return err
+	}
+	bft.pending = changes
+	return nil
+}
+
+// PendingValidatorChanges returns the changes scheduled by AddNode and
+// RemoveNode that no applied block has recorded yet, for the next proposal
+func (bft *BFTManager) PendingValidatorChanges() []ValidatorChange {
+	return append([]ValidatorChange{}, bft.pending...)
+}
+
+// ApplyValidatorChanges applies the membership changes recorded in a
+// committed block, so that every replica switches validator sets at the
+// same height: heights up to the block's keep the old set, and later ones
+// use the new set and its quorum. Blocks without changes are ignored.
+func (bft *BFTManager) ApplyValidatorChanges(b Block) error {
+	if len(b.ValidatorChanges) == 0 {
+		return nil
+	}
+	if n := len(bft.history); n > 0 && bft.history[n-1].until > b.Index {
+		return fmt.Errorf("%w: block #%d is before the current set", ErrInvalidValidatorChange, b.Index)
+	}
+	keys, err := applyValidatorChanges(bft.PublicKeys(), b.ValidatorChanges)
+	if err != nil {
+		return fmt.Errorf("block #%d: %w", b.Index, err)
+	}
+
+	var nodes []*Node
+	for _, node := range bft.Nodes {
+		if _, kept := keys[node.ID]; kept {
+			nodes = append(nodes, node)
+		}
+	}
+	applied := make(map[ValidatorChange]bool, len(b.ValidatorChanges))
+	for _, c := range b.ValidatorChanges {
+		applied[c] = true
+		if c.Type != ValidatorAdd {
+			continue
+		}
+		if _, kept := keys[c.NodeID]; !kept {
+			continue // Added and removed again by the same block
+		}
+		node := bft.joining[c.NodeID]
+		if node == nil || !node.PublicKey.Equal(keys[c.NodeID]) {
+			// Joined through another replica; only its key is known here
+			node = &Node{ID: c.NodeID, PublicKey: keys[c.NodeID]}
+		}
+		delete(bft.joining, c.NodeID)
+		nodes = append(nodes, node)
+	}
+
+	bft.history = append(bft.history, validatorEpoch{until: b.Index + 1, nodes: bft.Nodes})
+	bft.Nodes = nodes
+	var pending []ValidatorChange
+	for _, c := range bft.pending {
+		if !applied[c] {
+			pending = append(pending, c)
+		}
+	}
+	bft.pending = pending
+	return nil
+}
+
+// ValidatorsAt returns the validator set that decides a height
+func (bft *BFTManager) ValidatorsAt(height int) []*Node {
+	for _, epoch := range bft.history {
+		if height < epoch.until {
+			return epoch.nodes
+		}
+	}
+	return bft.Nodes
+}
+
+// ValidatorKeysAt returns the vote keys of the validator set deciding a
+// height, for verifying its commit certificates
+func (bft *BFTManager) ValidatorKeysAt(height int) map[int]ed25519.PublicKey {
+	return nodeKeys(bft.ValidatorsAt(height))
+}
+
+func nodeKeys(nodes []*Node) map[int]ed25519.PublicKey {
+	keys := make(map[int]ed25519.PublicKey, len(nodes))
+	for _, node := range nodes {
+		keys[node.ID] = node.PublicKey
+	}
+	return keys
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// certifyBlock appends b to bc, has bft decide it at its height and applies
+// any membership changes it records
+func certifyBlock(t *testing.T, bc *Blockchain, bft *BFTManager, b Block) CommitCertificate {
+	t.Helper()
+	if err := bc.AddBlockCandidate(b); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := bft.Decide(0, b.Index, b.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AttachCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := bft.ApplyValidatorChanges(b); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// nextBlock generates the successor of the chain's tip one second later
+func nextBlock(t *testing.T, bc *Blockchain, data string) Block {
+	t.Helper()
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	b, err := GenerateBlockAt(tip, data, tip.Timestamp.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// voters returns the IDs of the nodes that signed a certificate
+func voters(cert CommitCertificate) map[int]bool {
+	ids := make(map[int]bool, len(cert.Votes))
+	for _, v := range cert.Votes {
+		ids[v.NodeID] = true
+	}
+	return ids
+}
+
+func TestNodeAddedMidRunJoinsNextHeight(t *testing.T) {
+	bft := behaviorBFT(4)
+	genesisKeys := bft.PublicKeys()
+	bc := NewBlockchain()
+	certifyBlock(t, bc, bft, nextBlock(t, bc, "before"))
+
+	joiner, err := NewNode(4, bytes.Repeat([]byte{4}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bft.AddNode(joiner); err != nil {
+		t.Fatal(err)
+	}
+	if err := bft.AddNode(joiner); !errors.Is(err, ErrDuplicateValidator) {
+		t.Fatalf("adding the node twice: %v", err)
+	}
+
+	// The change block itself is still decided by the old set
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	change, err := GenerateValidatorBlockAt(tip, bft.PendingValidatorChanges(), tip.Timestamp.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeCert := certifyBlock(t, bc, bft, change)
+	if voters(changeCert)[4] || changeCert.ValidatorSet != ValidatorSetHash(genesisKeys) {
+		t.Fatalf("change block certified by %v", voters(changeCert))
+	}
+	if len(bft.PendingValidatorChanges()) != 0 {
+		t.Fatal("applied change still pending")
+	}
+
+	// From the next height the new node votes and the quorum is 4 of 5
+	cert := certifyBlock(t, bc, bft, nextBlock(t, bc, "after"))
+	if !voters(cert)[4] || len(cert.Votes) != 5 {
+		t.Fatalf("certificate after the change signed by %v", voters(cert))
+	}
+	newKeys := bft.ValidatorKeysAt(cert.Height)
+	if len(newKeys) != 5 || cert.ValidatorSet != ValidatorSetHash(newKeys) {
+		t.Fatalf("height %d validated by %d keys", cert.Height, len(newKeys))
+	}
+	if len(bft.ValidatorKeysAt(change.Index)) != 4 {
+		t.Fatal("change height reports the new set")
+	}
+	trimmed := cert
+	trimmed.Votes = trimmed.Votes[:CertificateQuorum(5)-1]
+	if err := VerifyCommitCertificate(trimmed, newKeys); !errors.Is(err, ErrInsufficientSignatures) {
+		t.Fatalf("3 of 5 signatures: %v", err)
+	}
+
+	// Each certificate verifies only against its own height's set
+	if err := VerifyCommitCertificate(cert, genesisKeys); !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("new certificate against the old set: %v", err)
+	}
+	if err := VerifyCommitCertificate(changeCert, newKeys); !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("old certificate against the new set: %v", err)
+	}
+	if err := bc.Validate(WithCertificateKeys(genesisKeys)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicasFollowRecordedChanges(t *testing.T) {
+	leader, replica := behaviorBFT(5), behaviorBFT(5)
+	for i, node := range replica.Nodes {
+		*node = *leader.Nodes[i]
+	}
+	if err := leader.RemoveNode(2); err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockchain()
+	tip := bc.Blocks[0]
+	change, err := GenerateValidatorBlockAt(tip, leader.PendingValidatorChanges(), tip.Timestamp.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certifyBlock(t, bc, leader, change)
+
+	// A replica that never scheduled the change applies it from the block
+	if err := replica.ApplyValidatorChanges(change); err != nil {
+		t.Fatal(err)
+	}
+	if ValidatorSetHash(replica.ValidatorKeysAt(2)) != ValidatorSetHash(leader.ValidatorKeysAt(2)) {
+		t.Fatal("replicas disagree on the set after the change")
+	}
+	if _, kept := replica.ValidatorKeysAt(2)[2]; kept {
+		t.Fatal("removed node still validates")
+	}
+	if err := replica.ApplyValidatorChanges(Block{Index: 0, ValidatorChanges: change.ValidatorChanges}); !errors.Is(err, ErrInvalidValidatorChange) {
+		t.Fatalf("change applied below the current set: %v", err)
+	}
+}
+
+func TestRemovalBelowMinimumRejected(t *testing.T) {
+	bft := behaviorBFT(MinValidators)
+	if err := bft.RemoveNode(0); !errors.Is(err, ErrTooFewValidators) {
+		t.Fatalf("removing from %d validators: %v", MinValidators, err)
+	}
+
+	bft = behaviorBFT(MinValidators + 1)
+	if err := bft.RemoveNode(0); err != nil {
+		t.Fatal(err)
+	}
+	// The pending removal counts towards the minimum
+	if err := bft.RemoveNode(1); !errors.Is(err, ErrTooFewValidators) {
+		t.Fatalf("second removal: %v", err)
+	}
+	if err := bft.RemoveNode(42); !errors.Is(err, ErrUnknownNode) {
+		t.Fatalf("removing an unknown node: %v", err)
+	}
+	if err := bft.AddNode(&Node{ID: 9}); !errors.Is(err, ErrInvalidValidatorChange) {
+		t.Fatalf("adding a node without a key: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ErrAddressNotOwned is returned when an account lies outside a shard's partition
+var ErrAddressNotOwned = errors.New("address not owned by shard")
+
+// receiptData is the authenticated record of a debit on the source shard
+func receiptData(source, destination *Shard, tx Transaction, nonce string, sequence uint64) string {
+	return fmt.Sprintf("%d:%d:%s:%s:%d:%s:%d", source.ID, destination.ID, tx.Hash(), tx.To, tx.Amount, nonce, sequence)
+}
+
+// TransferValue moves tx.Amount from an account on fromShard to one on toShard
+// with a two-phase debit-then-credit. The source is debited and a receipt
+// commitment recorded, along with a Pedersen commitment to the amount and a
+// proof that it lies in [0, 2^RangeProofBits). The destination is credited
+// only once the receipt and range proof verify, and the debit is rolled back
+// if the credit fails. If the destination has a committee, it must also
+// decide the transfer before the credit. When capabilities are required,
+// tokens must grant RightTransfer on both shards.
+func (esm *EnhancedSyncManager) TransferValue(fromShard, toShard *Shard, tx Transaction, tokens ...Token) error {
+	_, err := esm.TransferValueWithReceipt(fromShard, toShard, tx, tokens...)
+	return err
+}
+
+// TransferValueWithReceipt is TransferValue returning the signed receipt of
+// the debit. The credit redeems the receipt on the destination, which then
+// refuses to credit it again; see RedeemReceipt.
+func (esm *EnhancedSyncManager) TransferValueWithReceipt(fromShard, toShard *Shard, tx Transaction, tokens ...Token) (TransferReceipt, error) {
+	if fromShard == toShard || fromShard.ID == toShard.ID {
+		return TransferReceipt{}, ErrSameShard
+	}
+	if err := esm.checkTransferCapabilities(fromShard, toShard, tokens); err != nil {
+		return TransferReceipt{}, err
+	}
+
+	unlock := lockShards(fromShard, toShard)
+	defer unlock()
+
+	if !fromShard.OwnsAddress(tx.From) {
+		return TransferReceipt{}, fmt.Errorf("%w: sender %s in shard #%d", ErrAddressNotOwned, tx.From, fromShard.ID)
+	}
+	if !toShard.OwnsAddress(tx.To) {
+		return TransferReceipt{}, fmt.Errorf("%w: recipient %s in shard #%d", ErrAddressNotOwned, tx.To, toShard.ID)
+	}
+
+	txHash := tx.Hash()
+	record := &TransferRecord{
+		ID:               txHash,
+		Kind:             "value",
+		StartedAt:        time.Now(),
+		SourceID:         fromShard.ID,
+		DestID:           toShard.ID,
+		TxHash:           txHash,
+		Phases:           []TransferPhase{PhasePrepare},
+		SourceRootBefore: fromShard.State.StateRoot(),
+		DestRootBefore:   toShard.State.StateRoot(),
+	}
+	finish := func(outcome TransferOutcome, reason RollbackReason, err error) {
+		record.SourceRootAfter = fromShard.State.StateRoot()
+		record.DestRootAfter = toShard.State.StateRoot()
+		esm.finishRecord(record, outcome, reason, err)
+	}
+
+	// Phase 1: debit the sender and record the receipt on the source
+	snapshot := fromShard.State.Clone()
+	if err := fromShard.State.debitSender(tx); err != nil {
+		finish(OutcomeAborted, ReasonPrepareFailed, err)
+		return TransferReceipt{}, err
+	}
+	nonce, sequence := esm.nextTransferNonce()
+	debit := receiptData(fromShard, toShard, tx, nonce, sequence)
+	fromShard.Receipts[txHash] = esm.authenticator.AuthenticateData(debit)
+	record.Nonce, record.Sequence = nonce, sequence
+	record.Commitment = fromShard.Receipts[txHash]
+	blinding := randomScalar()
+	amount := new(big.Int).SetUint64(tx.Amount)
+	amountCommitment := Commit(amount, blinding)
+	rangeProof := ProveRange(amount, blinding, RangeProofBits)
+	record.AmountCommitment = amountCommitment.String()
+	record.Phases = append(record.Phases, PhasePrepared, PhaseCommit)
+
+	// Phase 2: credit the recipient once the receipt and range proof verify
+	var creditErr error
+	reason := ReasonCreditFailed
+	receipt := TransferReceipt{
+		TransferID:       txHash,
+		SourceShard:      fromShard.ID,
+		DestShard:        toShard.ID,
+		Nonce:            nonce,
+		Recipient:        tx.To,
+		Amount:           tx.Amount,
+		AmountCommitment: amountCommitment.String(),
+		SourceRoot:       fromShard.GetRoot(),
+		SourceStateRoot:  fromShard.State.StateRoot(),
+	}
+	esm.signReceipt(&receipt)
+	if !esm.authenticator.VerifyAuthentication(debit, fromShard.Receipts[txHash]) {
+		creditErr = ErrInvalidCommitment
+		reason = ReasonCommitmentMismatch
+	} else if !VerifyRange(amountCommitment, rangeProof, RangeProofBits) {
+		creditErr = fmt.Errorf("%w: amount %d", ErrInvalidRangeProof, tx.Amount)
+		reason = ReasonRangeProofFailed
+	} else if receipt.Certificate, creditErr = esm.decideTransfer(toShard, txHash, record.Commitment); creditErr != nil {
+		reason = ReasonCommitteeRejected
+	} else {
+		record.Certificate = receipt.Certificate
+		creditErr = esm.redeemReceipt(toShard, receipt)
+	}
+	if creditErr == nil {
+		finish(OutcomeCommitted, "", nil)
+		return receipt, nil
+	}
+
+	// Rollback: restore the source state and drop the receipt
+	fromShard.State = snapshot
+	delete(fromShard.Receipts, txHash)
+	record.Phases = append(record.Phases, PhaseRollback)
+	finish(OutcomeRolledBack, reason, creditErr)
+	return TransferReceipt{}, fmt.Errorf("value transfer %s rolled back: %w", txHash, creditErr)
+}
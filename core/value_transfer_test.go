@@ -0,0 +1,163 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+// totalSupply sums balances over every shard of a manager
+func totalSupply(sm *ShardManager) uint64 {
+	var total uint64
+	for _, shard := range sm.Shards.GetAllShards() {
+		shard.mutex.Lock()
+		total += shard.State.TotalSupply()
+		shard.mutex.Unlock()
+	}
+	return total
+}
+
+// partitionedManager returns a manager of several shards splitting the
+// address space between them
+func partitionedManager(t *testing.T) *ShardManager {
+	t.Helper()
+	sm := NewShardManager()
+	for _, data := range testLeaves(12) {
+		sm.DistributeBlock(mustGenerateBlock(t, GenesisBlock(), data))
+	}
+	sm.PartitionAddressSpace()
+	if len(sm.Shards.GetAllShards()) < 4 {
+		t.Fatalf("%d shards, want at least 4", len(sm.Shards.GetAllShards()))
+	}
+	return sm
+}
+
+func TestTransferValueRollsBackFailedCredit(t *testing.T) {
+	source, destination := NewShard(1), NewShard(2)
+	source.SetAddressRange(0, 127)
+	destination.SetAddressRange(128, 255)
+	if err := source.Credit("alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := destination.State.Credit("\xffbob", math.MaxUint64); err != nil {
+		t.Fatal(err)
+	}
+	sourceRoot := source.State.StateRoot()
+
+	esm := NewEnhancedSyncManager("key")
+	err := esm.TransferValue(source, destination, Transaction{From: "alice", To: "\xffbob", Amount: 10})
+	if !errors.Is(err, ErrBalanceOverflow) {
+		t.Fatalf("credit overflowing the recipient: %v", err)
+	}
+	if source.State.StateRoot() != sourceRoot || source.BalanceOf("alice") != 100 || source.State.NonceOf("alice") != 0 {
+		t.Fatal("debit not rolled back")
+	}
+	if len(source.Receipts) != 0 {
+		t.Fatal("receipt of a rolled back transfer kept")
+	}
+	if journal := esm.Journal(); len(journal) != 1 || journal[0].Outcome == OutcomeCommitted {
+		t.Fatalf("journal %+v, want one rolled back transfer", journal)
+	}
+
+	// The same nonce goes through once the credit can succeed
+	if err := esm.TransferValue(source, destination, Transaction{From: "alice", To: "\xffcarol", Amount: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if source.BalanceOf("alice") != 90 || destination.BalanceOf("\xffcarol") != 10 {
+		t.Fatal("committed transfer did not move the amount")
+	}
+	if err := esm.TransferValue(source, destination, Transaction{From: "alice", To: "bob", Amount: 10, Nonce: 1}); !errors.Is(err, ErrAddressNotOwned) {
+		t.Fatalf("recipient outside the destination: %v", err)
+	}
+	if err := esm.TransferValue(source, destination, Transaction{From: "alice", To: "\xffcarol", Amount: 91, Nonce: 1}); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("overdraft: %v", err)
+	}
+	if source.BalanceOf("alice")+destination.BalanceOf("\xffcarol") != 100 {
+		t.Fatal("failed transfers changed the supply")
+	}
+}
+
+func TestConcurrentTransfersPreserveTotalSupply(t *testing.T) {
+	sm := partitionedManager(t)
+	accounts := []string{"\x01a", "\x50b", "\x90c", "\xf0d"}
+	for _, addr := range accounts {
+		shard, ok := sm.ShardForAddress(addr)
+		if !ok {
+			t.Fatalf("no shard owns %q", addr)
+		}
+		if err := shard.Credit(addr, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	esm := NewEnhancedSyncManager("key")
+	var wg sync.WaitGroup
+	for i, from := range accounts {
+		wg.Add(1)
+		go func(i int, from string) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				tx := Transaction{From: from, To: accounts[(i+n+1)%len(accounts)], Amount: uint64(n % 7), Nonce: uint64(n)}
+				if err := sm.TransferValue(esm, tx); err != nil {
+					t.Errorf("transfer %d from %q: %v", n, from, err)
+					return
+				}
+			}
+		}(i, from)
+	}
+
+	// Merging shards while transfers run must neither lose nor mint value
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := sm.MergeShards(100); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if got := totalSupply(sm); got != 4000 {
+		t.Fatalf("total supply %d after concurrent transfers, want 4000", got)
+	}
+	for _, addr := range accounts {
+		shard, _ := sm.ShardForAddress(addr)
+		if nonce := shard.State.NonceOf(addr); nonce != 200 {
+			t.Errorf("%q sent %d transfers, want 200", addr, nonce)
+		}
+	}
+}
+
+func TestMergeShardsAbortsOnStateMergeError(t *testing.T) {
+	sm := partitionedManager(t)
+	shards := sm.Shards.GetAllShards()
+	left, right := shards[0], shards[1]
+	if err := left.State.Credit("overflow", math.MaxUint64); err != nil {
+		t.Fatal(err)
+	}
+	if err := right.State.Credit("overflow", 1); err != nil {
+		t.Fatal(err)
+	}
+	before := make([]string, len(shards))
+	for i, shard := range shards {
+		before[i] = fmt.Sprintf("%d:%s", shard.ID, shard.GetRoot())
+	}
+
+	err := sm.MergeShards(100)
+	if !errors.Is(err, ErrBalanceOverflow) {
+		t.Fatalf("merge overflowing a balance: %v", err)
+	}
+	after := sm.Shards.GetAllShards()
+	if len(after) != len(shards) {
+		t.Fatalf("%d shards after an aborted merge, had %d", len(after), len(shards))
+	}
+	for i, shard := range after {
+		if got := fmt.Sprintf("%d:%s", shard.ID, shard.GetRoot()); got != before[i] || shard != shards[i] {
+			t.Fatalf("shard %s replaced by %s", before[i], got)
+		}
+	}
+	if left.State.BalanceOf("overflow") != math.MaxUint64 || right.State.BalanceOf("overflow") != 1 {
+		t.Fatal("aborted merge changed balances")
+	}
+}
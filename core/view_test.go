@@ -0,0 +1,207 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// checkView fails the test if a view's fields disagree with its blocks
+func checkView(t testing.TB, view ShardView) {
+	t.Helper()
+	if view.BlockCount != len(view.Blocks) {
+		t.Errorf("shard #%d view counts %d of %d blocks", view.ID, view.BlockCount, len(view.Blocks))
+	}
+	if view.BlockCount > 0 && view.Root != newBlockTree(view.Blocks).GetRootHash() {
+		t.Errorf("shard #%d view root does not match its blocks", view.ID)
+	}
+	if view.ChainCommitment != chainCommitment(view.Blocks) {
+		t.Errorf("shard #%d view chain commitment does not match its blocks", view.ID)
+	}
+}
+
+func TestShardViewIsDetached(t *testing.T) {
+	shard := NewShard(0)
+	fillShard(t, shard, GenesisBlock(), "block", 3)
+	view := shard.View()
+	root := view.Root
+
+	view.Blocks[0].Data = "mutated"
+	if shard.Blocks[0].Data == "mutated" {
+		t.Fatal("writing a view's blocks changed the shard")
+	}
+	fillShard(t, shard, GenesisBlock(), "later", 2)
+	if view.BlockCount != 3 || len(view.Blocks) != 3 || view.Root != root {
+		t.Fatal("view changed when the shard gained blocks")
+	}
+}
+
+func TestShardViewsDuringRebalance(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(2, 8); err != nil {
+		t.Fatal(err)
+	}
+	genesis := GenesisBlock()
+	const total = 200
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < total; i++ {
+			sm.DistributeBlock(mustGenerateBlock(t, genesis, fmt.Sprintf("block-%d", i)))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		seen := 0
+		for seen < total {
+			views := sm.GetAllShardViews()
+			count := 0
+			hashes := make(map[string]bool)
+			for _, view := range views {
+				checkView(t, view)
+				for _, b := range view.Blocks {
+					if hashes[b.Hash] {
+						t.Errorf("block %s in two shards of one snapshot", b.Hash)
+					}
+					hashes[b.Hash] = true
+				}
+				count += view.BlockCount
+			}
+
+			// Blocks are only added, so a snapshot never holds fewer
+			// than the one before it
+			if count < seen {
+				t.Errorf("snapshot holds %d blocks after one holding %d", count, seen)
+			}
+			seen = count
+			if t.Failed() {
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if len(sm.GetAllShardViews()) < 2 {
+		t.Fatal("no shard was split")
+	}
+}
+
+func TestShardViewHeldAcrossSplit(t *testing.T) {
+	sm := NewShardManager()
+	if err := sm.SetThresholds(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	genesis := GenesisBlock()
+	for i := 0; i < 6; i++ {
+		sm.DistributeBlock(mustGenerateBlock(t, genesis, fmt.Sprintf("block-%d", i)))
+	}
+	before := sm.GetAllShardViews()
+	if len(before) != 1 || before[0].BlockCount != 6 {
+		t.Fatalf("%d shards before the split, want one holding 6 blocks", len(before))
+	}
+	root := before[0].Root
+
+	if err := sm.SetThresholds(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.RebalanceShards(); err != nil {
+		t.Fatal(err)
+	}
+	after := sm.GetAllShardViews()
+	if len(after) <= len(before) {
+		t.Fatalf("rebalance left %d shards, had %d", len(after), len(before))
+	}
+	checkView(t, before[0])
+	if before[0].BlockCount != 6 || before[0].Root != root {
+		t.Error("view held across a split changed")
+	}
+	if got, ok := sm.GetShardView(after[len(after)-1].ID); !ok || got.Root != after[len(after)-1].Root {
+		t.Error("GetShardView disagrees with GetAllShardViews")
+	}
+	if _, ok := sm.GetShardView(-1); ok {
+		t.Error("view of an unknown shard")
+	}
+}
+
+func TestReconstructStateDuringRebalances(t *testing.T) {
+	sm := NewShardManager(WithSplitStrategy(HashRangeSplit))
+	if err := sm.SetThresholds(2, 8); err != nil {
+		t.Fatal(err)
+	}
+	blocks := fixedBlocks(1000)
+
+	// Every state a reader can observe follows some rebalance, so the
+	// writer records the root of each shard's block set after each one
+	consistent := make(map[int]map[string]bool)
+	record := func() {
+		for _, view := range sm.GetAllShardViews() {
+			checkView(t, view)
+			if consistent[view.ID] == nil {
+				consistent[view.ID] = make(map[string]bool)
+			}
+			consistent[view.ID][view.Root] = true
+		}
+	}
+	record()
+
+	type observed struct {
+		id   int
+		root string
+	}
+	done := make(chan struct{})
+	results := make(chan []observed)
+	go func() {
+		var seen []observed
+		for {
+			select {
+			case <-done:
+				results <- seen
+				return
+			default:
+			}
+			for id := range sm.ShardAssignments() {
+				root, ok := sm.ReconstructState(id)
+				if !ok {
+					t.Errorf("shard #%d could not be reconstructed", id)
+					results <- seen
+					return
+				}
+				seen = append(seen, observed{id, root})
+			}
+		}
+	}()
+
+	for i, b := range blocks {
+		sm.DistributeBlock(b) // Rebalances after adding the block
+		record()
+		if i%100 == 99 {
+			// Split every shard down, then let them grow again
+			if err := sm.SetThresholds(2, 4); err != nil {
+				t.Fatal(err)
+			}
+			if err := sm.RebalanceShards(); err != nil {
+				t.Fatal(err)
+			}
+			record()
+			if err := sm.SetThresholds(2, 8); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	close(done)
+	seen := <-results
+
+	if len(seen) == 0 {
+		t.Fatal("no state reconstructed")
+	}
+	for _, o := range seen {
+		if !consistent[o.id][o.root] {
+			t.Fatalf("shard #%d reconstructed as %s, a root of no consistent block set", o.id, o.root)
+		}
+	}
+	if sm.Shards.Size() < 100 {
+		t.Fatalf("%d shards after 1,000 blocks", sm.Shards.Size())
+	}
+}
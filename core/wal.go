@@ -0,0 +1,190 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WALRecordType marks the phase a write-ahead log record belongs to
+type WALRecordType string
+
+const (
+	WALPrepare WALRecordType = "PREPARE"
+	WALCommit  WALRecordType = "COMMIT"
+	WALAbort   WALRecordType = "ABORT"
+)
+
+// WALRecord is one line of the transfer write-ahead log
+type WALRecord struct {
+	Type       WALRecordType `json:"type"`
+	TransferID string        `json:"transfer_id"`
+	SourceID   int           `json:"source_id"`
+	DestID     int           `json:"dest_id"`
+	BlockIndex int           `json:"block_index"`
+	BlockHash  string        `json:"block_hash,omitempty"`
+	Nonce      string        `json:"nonce,omitempty"`
+	Sequence   uint64        `json:"sequence,omitempty"`
+	Commitment string        `json:"commitment,omitempty"`
+}
+
+// SetWAL injects the writer transfer records are logged to (nil disables logging)
+func (esm *EnhancedSyncManager) SetWAL(w io.Writer) {
+	esm.mutex.Lock()
+	defer esm.mutex.Unlock()
+	esm.wal = w
+}
+
+// writeWAL appends a record to the log as a single JSON line
+func (esm *EnhancedSyncManager) writeWAL(record WALRecord) error {
+	if esm.wal == nil {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := injectFault(esm.faults, FaultBeforeWALFlush); err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+	if _, err := esm.wal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// RecoverPendingTransfers replays a write-ahead log and rolls back every
+// transfer with a PREPARE record but no COMMIT or ABORT. It returns the IDs
+// of the transfers it rolled back.
+func RecoverPendingTransfers(r io.Reader, sm *ShardManager) ([]string, error) {
+	pending := make(map[string]WALRecord)
+	var order []string
+
+	err := scanWAL(r, func(record WALRecord) {
+		switch record.Type {
+		case WALPrepare:
+			pending[record.TransferID] = record
+			order = append(order, record.TransferID)
+		case WALCommit, WALAbort:
+			delete(pending, record.TransferID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var recovered []string
+	for _, id := range order {
+		record, exists := pending[id]
+		if !exists {
+			continue
+		}
+		if err := rollbackFromWAL(record, sm); err != nil {
+			return recovered, fmt.Errorf("recover transfer %s: %w", id, err)
+		}
+		delete(pending, id)
+		recovered = append(recovered, id)
+	}
+	return recovered, nil
+}
+
+// LoadCompletedTransfers replays a write-ahead log into the record of
+// finished keyed transfers, so that retries after a restart return the
+// recorded outcome instead of moving blocks again. Transfers left pending
+// in the log count as aborted, since RecoverPendingTransfers rolls them
+// back. It returns how many transfers were loaded.
+func (esm *EnhancedSyncManager) LoadCompletedTransfers(r io.Reader) (int, error) {
+	outcomes := make(map[string]TransferOutcome)
+	var order []string
+	err := scanWAL(r, func(record WALRecord) {
+		if !strings.Contains(record.TransferID, "/") {
+			return // Unkeyed IDs are reused, so their outcomes are not replayed
+		}
+		if _, seen := outcomes[record.TransferID]; !seen {
+			order = append(order, record.TransferID)
+		}
+		switch record.Type {
+		case WALPrepare:
+			outcomes[record.TransferID] = OutcomeAborted
+		case WALCommit:
+			outcomes[record.TransferID] = OutcomeCommitted
+		case WALAbort:
+			outcomes[record.TransferID] = OutcomeAborted
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range order {
+		esm.completed.add(id, outcomes[id], nil)
+	}
+	return len(order), nil
+}
+
+// scanWAL decodes log records in order, stopping at a torn final line
+func scanWAL(r io.Reader, visit func(WALRecord)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var record WALRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn final line is expected after a crash mid-write
+			break
+		}
+		visit(record)
+	}
+	return scanner.Err()
+}
+
+// rollbackFromWAL puts the transferred block back in its source shard
+func rollbackFromWAL(record WALRecord, sm *ShardManager) error {
+	source, err := sm.FindShard(record.SourceID)
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	destination, err := sm.FindShard(record.DestID)
+	if err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+
+	unlock := lockShards(source, destination)
+	defer unlock()
+	return undoBlockMove(source, destination, record.BlockHash, record.BlockIndex)
+}
+
+// undoBlockMove removes every copy of the block with hash from the
+// destination and, unless the source still holds it, puts it back in the
+// source at index. Nothing else in either shard is touched. The caller
+// must hold both shard locks.
+func undoBlockMove(source, destination *Shard, hash string, index int) error {
+	var block Block
+	found := false
+	for i := len(destination.Blocks) - 1; i >= 0; i-- {
+		if destination.Blocks[i].Hash != hash {
+			continue
+		}
+		if !found {
+			block, found = destination.Blocks[i], true
+			if full, err := destination.fullBlock(i); err == nil {
+				block = full
+			}
+		}
+		destination.removeBlockAt(i)
+	}
+
+	if source.containsBlock(hash) {
+		return nil // Source was never modified
+	}
+	if !found {
+		return fmt.Errorf("%w: %s in shard #%d or #%d", ErrBlockNotFound, hash, source.ID, destination.ID)
+	}
+
+	// Restore the block at its original position in the source
+	if index < 0 || index > len(source.Blocks) {
+		index = len(source.Blocks)
+	}
+	source.Blocks = append(source.Blocks[:index], append([]Block{source.stripBody(block)}, source.Blocks[index:]...)...)
+	source.rebuild()
+	return nil
+}
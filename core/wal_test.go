@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// transferSetup distributes a short chain over a new shard manager and
+// returns it with two of its shards
+func transferSetup(t testing.TB) (*ShardManager, *Shard, *Shard) {
+	t.Helper()
+	sm := NewShardManager()
+	bc := NewBlockchain()
+	for i := 0; i < 6; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks {
+		sm.DistributeBlock(b)
+	}
+	shards := sm.Shards.GetAllShards()
+	if len(shards) < 2 {
+		t.Fatalf("chain distributed over %d shards, want at least 2", len(shards))
+	}
+	return sm, shards[0], shards[1]
+}
+
+// walLines splits a log into its records
+func walLines(log []byte) []string {
+	return strings.SplitAfter(strings.TrimSuffix(string(log), "\n"), "\n")
+}
+
+func TestRecoverRollsBackTransferTruncatedAfterPrepare(t *testing.T) {
+	sm, source, destination := transferSetup(t)
+	sourceRoot, destRoot := source.GetRoot(), destination.GetRoot()
+	sourceCount, destCount := len(source.Blocks), len(destination.Blocks)
+
+	var log bytes.Buffer
+	esm := NewEnhancedSyncManager("key")
+	esm.SetWAL(&log)
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	lines := walLines(log.Bytes())
+	if len(lines) != 2 || !strings.Contains(lines[0], `"PREPARE"`) || !strings.Contains(lines[1], `"COMMIT"`) {
+		t.Fatalf("log %q, want PREPARE then COMMIT", lines)
+	}
+
+	// The full log leaves the committed transfer alone
+	if ids, err := RecoverPendingTransfers(bytes.NewReader(log.Bytes()), sm); err != nil || len(ids) != 0 {
+		t.Fatalf("recovered %v, %v from a complete log", ids, err)
+	}
+	if len(source.Blocks) != sourceCount-1 || len(destination.Blocks) != destCount+1 {
+		t.Fatal("recovery undid a committed transfer")
+	}
+
+	// A crash before COMMIT reached the log: the block moved but the log
+	// ends after PREPARE, some of the COMMIT line torn off
+	for _, truncated := range []string{lines[0], lines[0] + lines[1][:len(lines[1])/2]} {
+		ids, err := RecoverPendingTransfers(strings.NewReader(truncated), sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != TransferID("", source.ID, destination.ID, 1) {
+			t.Fatalf("recovered %v, want the one prepared transfer", ids)
+		}
+		if source.GetRoot() != sourceRoot || destination.GetRoot() != destRoot {
+			t.Fatal("recovery did not restore both shards")
+		}
+		if len(source.Blocks) != sourceCount || len(destination.Blocks) != destCount {
+			t.Fatalf("recovered shards hold %d and %d blocks, want %d and %d", len(source.Blocks), len(destination.Blocks), sourceCount, destCount)
+		}
+
+		// Replaying the same log again finds the block already home
+		if _, err := RecoverPendingTransfers(strings.NewReader(truncated), sm); err != nil {
+			t.Fatal(err)
+		}
+		if source.GetRoot() != sourceRoot || destination.GetRoot() != destRoot {
+			t.Fatal("second recovery changed the shards")
+		}
+		if errs := sm.VerifyAll(1); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+
+		// Redo the move for the next truncation
+		if err := NewSyncManager().SyncBlockByHash(source, destination, destinationHash(t, lines[0])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// destinationHash reads the block hash of a PREPARE line
+func destinationHash(t *testing.T, line string) string {
+	t.Helper()
+	var hash string
+	err := scanWAL(strings.NewReader(line), func(record WALRecord) {
+		hash = record.BlockHash
+	})
+	if err != nil || hash == "" {
+		t.Fatalf("no block hash in %q: %v", line, err)
+	}
+	return hash
+}
+
+func TestRecoverLeavesAbortedTransfersAlone(t *testing.T) {
+	sm, source, destination := transferSetup(t)
+	var log bytes.Buffer
+	esm := NewEnhancedSyncManager("key")
+	esm.SetWAL(&log)
+	esm.SetFaultInjector(FailNth(FaultBeforeCommit, 1))
+	if err := esm.CreateAuthenticatedTransfer(source, destination, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := esm.VerifyAndApplyTransfer(source, destination, 1); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("transfer failing before commit: %v", err)
+	}
+	sourceRoot, destRoot := source.GetRoot(), destination.GetRoot()
+	if !strings.Contains(log.String(), `"ABORT"`) {
+		t.Fatalf("log %q has no ABORT record", log.String())
+	}
+	if ids, err := RecoverPendingTransfers(bytes.NewReader(log.Bytes()), sm); err != nil || len(ids) != 0 {
+		t.Fatalf("recovered %v, %v after an abort", ids, err)
+	}
+	if source.GetRoot() != sourceRoot || destination.GetRoot() != destRoot {
+		t.Fatal("recovery changed shards after an abort")
+	}
+}
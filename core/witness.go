@@ -0,0 +1,96 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Errors returned when collecting additions to update witnesses with
+var (
+	ErrUnknownAccumulatorState = errors.New("accumulator state not in addition log")
+	ErrWitnessInvalidated      = errors.New("element removed from accumulator; witnesses must be reissued")
+)
+
+// WitnessService brings accumulator membership witnesses up to date with
+// elements added since they were issued, without the accumulator itself:
+// adding an element with prime p raises the state to the power p, so
+// raising a witness to p keeps it verifying. Removals cannot be followed
+// this way.
+type WitnessService struct {
+	n      *big.Int
+	hashFn Hasher // nil is SHA-256
+}
+
+// NewWitnessService updates witnesses of accumulators sharing acc's modulus
+// and element hash function
+func NewWitnessService(acc *RSAAccumulator) *WitnessService {
+	return &WitnessService{
+		n:      new(big.Int).Set(acc.N),
+		hashFn: acc.hashFn,
+	}
+}
+
+// UpdateWitness returns oldWitness raised to the primes of newElements,
+// given in the order they were added
+func (ws *WitnessService) UpdateWitness(oldWitness *big.Int, newElements []string) *big.Int {
+	witness := new(big.Int).Set(oldWitness)
+	for _, element := range newElements {
+		witness.Exp(witness, hashToPrime(ws.hashFn, element), ws.n)
+	}
+	return witness
+}
+
+// UpdateWitnesses updates the witness of every element in witnesses at
+// once, returning new witnesses keyed the same way. As with AddElement,
+// re-adding an element leaves its own witness unchanged.
+func (ws *WitnessService) UpdateWitnesses(witnesses map[string]*big.Int, newElements []string) map[string]*big.Int {
+	product := big.NewInt(1)
+	added := make(map[string]bool, len(newElements))
+	for _, element := range newElements {
+		product.Mul(product, hashToPrime(ws.hashFn, element))
+		added[element] = true
+	}
+
+	updated := make(map[string]*big.Int, len(witnesses))
+	for element, witness := range witnesses {
+		if !added[element] {
+			updated[element] = new(big.Int).Exp(witness, product, ws.n)
+			continue
+		}
+		var others []string
+		for _, e := range newElements {
+			if e != element {
+				others = append(others, e)
+			}
+		}
+		updated[element] = ws.UpdateWitness(witness, others)
+	}
+	return updated
+}
+
+// AdditionsSince returns the elements added to an accumulator after it was
+// in state, the hex state a client last synced, from its addition log. It
+// fails with ErrUnknownAccumulatorState if the log does not pass through
+// state, and with ErrWitnessInvalidated if an element was removed since.
+func AdditionsSince(log []AdditionEntry, state string) ([]string, error) {
+	if len(log) == 0 || log[len(log)-1].NewState == state {
+		return []string{}, nil
+	}
+	start := len(log) - 1
+	for start >= 0 && log[start].PriorState != state {
+		start--
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAccumulatorState, state)
+	}
+
+	elements := make([]string, 0, len(log)-start)
+	for _, entry := range log[start:] {
+		if entry.Removed {
+			return nil, fmt.Errorf("%w: %s", ErrWitnessInvalidated, entry.Element)
+		}
+		elements = append(elements, entry.Element)
+	}
+	return elements, nil
+}
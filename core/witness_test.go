@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// wideAccumulator returns an accumulator over a modulus of two Mersenne
+// primes, wide enough that a skipped addition does not leave a witness
+// valid by chance, as it can under the default demo modulus
+func wideAccumulator() *RSAAccumulator {
+	acc := NewRSAAccumulator()
+	one := big.NewInt(1)
+	p := new(big.Int).Sub(new(big.Int).Lsh(one, 127), one)
+	q := new(big.Int).Sub(new(big.Int).Lsh(one, 89), one)
+	acc.N = p.Mul(p, q)
+	return acc
+}
+
+func TestWitnessUpdatedThroughAdditions(t *testing.T) {
+	acc := wideAccumulator()
+	for i := 1; i <= 3; i++ {
+		acc.AddElement(fmt.Sprintf("element-%d", i))
+	}
+	witness := new(big.Int).Set(acc.Proofs["element-3"])
+	synced := acc.State.Text(16)
+	for i := 4; i <= 53; i++ {
+		acc.AddElement(fmt.Sprintf("element-%d", i))
+	}
+
+	additions, err := AdditionsSince(acc.AdditionLog(), synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(additions) != 50 || additions[0] != "element-4" {
+		t.Fatalf("%d additions since the sync, starting %v", len(additions), additions[:1])
+	}
+	if err := acc.CheckMembership("element-3", witness); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("stale witness: %v", err)
+	}
+	ws := NewWitnessService(acc)
+	updated := ws.UpdateWitness(witness, additions)
+	if err := acc.CheckMembership("element-3", updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Cmp(acc.Proofs["element-3"]) != 0 {
+		t.Fatal("updated witness differs from the accumulator's own")
+	}
+
+	// Every skipped addition leaves the witness stale
+	for _, skip := range []int{0, 25, 49} {
+		skipped := append(append([]string(nil), additions[:skip]...), additions[skip+1:]...)
+		if err := acc.CheckMembership("element-3", ws.UpdateWitness(witness, skipped)); !errors.Is(err, ErrInvalidProof) {
+			t.Fatalf("witness updated without addition %d: %v", skip, err)
+		}
+	}
+}
+
+func TestUpdateWitnessesInBatch(t *testing.T) {
+	acc := wideAccumulator()
+	witnesses := make(map[string]*big.Int)
+	for _, e := range []string{"a", "b", "c"} {
+		acc.AddElement(e)
+	}
+	for e, proof := range acc.Proofs {
+		witnesses[e] = new(big.Int).Set(proof)
+	}
+	synced := acc.State.Text(16)
+	for _, e := range []string{"d", "e", "f"} {
+		acc.AddElement(e)
+	}
+
+	additions, err := AdditionsSince(acc.AdditionLog(), synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := NewWitnessService(acc).UpdateWitnesses(witnesses, additions)
+	if len(updated) != len(witnesses) {
+		t.Fatalf("%d witnesses updated of %d", len(updated), len(witnesses))
+	}
+	for e, witness := range updated {
+		if err := acc.CheckMembership(e, witness); err != nil {
+			t.Fatalf("batched witness of %s: %v", e, err)
+		}
+		if witness.Cmp(acc.Proofs[e]) != 0 {
+			t.Fatalf("batched witness of %s differs from the accumulator's own", e)
+		}
+	}
+	if witnesses["a"].Cmp(updated["a"]) == 0 {
+		t.Fatal("input witness updated in place")
+	}
+}
+
+func TestAdditionsSince(t *testing.T) {
+	acc := NewRSAAccumulator()
+	acc.AddElement("a")
+	acc.AddElement("b")
+	if additions, err := AdditionsSince(acc.AdditionLog(), acc.State.Text(16)); err != nil || len(additions) != 0 {
+		t.Fatalf("additions since the current state: %v, %v", additions, err)
+	}
+	if _, err := AdditionsSince(acc.AdditionLog(), "abc"); !errors.Is(err, ErrUnknownAccumulatorState) {
+		t.Fatalf("additions since an unknown state: %v", err)
+	}
+
+	synced := acc.State.Text(16)
+	acc.AddElement("c")
+	acc.RemoveElement("a")
+	acc.AddElement("d")
+	if _, err := AdditionsSince(acc.AdditionLog(), synced); !errors.Is(err, ErrWitnessInvalidated) {
+		t.Fatalf("additions across a removal: %v", err)
+	}
+}
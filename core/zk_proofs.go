@@ -4,10 +4,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 )
 
 // ZKProver simulates creating a zero-knowledge proof
-type ZKProver struct{}
+type ZKProver struct {
+	Source TransitionSource // Optional source of shard state transition proofs
+}
 
 // ProveKnowledge simulates proving knowledge of a value without revealing it
 func (zk *ZKProver) ProveKnowledge(secret string) string {
@@ -40,4 +43,10 @@ func (zk *ZKProver) TestZKP() {
 
 	fmt.Println("Proof Valid?", valid)
 	fmt.Println("Proof Valid with Wrong Data?", invalid)
+
+	// Range proof over a hidden transfer amount
+	amount, blinding := big.NewInt(1500), randomScalar()
+	rangeProof := ProveRange(amount, blinding, RangeProofBits)
+	fmt.Printf("Range Proof Valid for committed amount in [0, 2^%d)? %v\n",
+		RangeProofBits, VerifyRange(Commit(amount, blinding), rangeProof, RangeProofBits))
 }
@@ -0,0 +1,452 @@
+package lightclient
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"blockchain-system/core"
+)
+
+// Errors returned by the light client
+var (
+	ErrUnknownShard     = errors.New("unknown shard")
+	ErrBadAttestation   = errors.New("header attestation does not verify")
+	ErrStaleHeader      = errors.New("header is older than the current one")
+	ErrNoAccumulator    = errors.New("shard header has no accumulator state")
+	ErrHashMismatch     = errors.New("block header does not match hash")
+	ErrLeafCountChanged = errors.New("proof leaf count does not match shard header")
+	ErrBadTransition    = errors.New("state transition proof does not verify")
+	ErrNeedTransition   = errors.New("header update requires a transition proof")
+	ErrNoReceiptKey     = errors.New("no transfer receipt key trusted")
+	ErrBadAdditions     = errors.New("additions do not lead to the header's accumulator state")
+	ErrBadWitness       = errors.New("witness does not verify against the shard header")
+	ErrNoPruningProof   = errors.New("no verified pruning proof for shard")
+	ErrBadMergeProof    = errors.New("merge proof does not verify against tracked shard roots")
+	ErrNeedCertificate  = errors.New("shard root updates require a committee certificate")
+	ErrBadCertificate   = errors.New("committee certificate does not verify")
+)
+
+// ShardHeader is the per-shard commitment a full node publishes to light clients
+type ShardHeader struct {
+	ShardID          int
+	Sequence         uint64 // Increases with every update of this shard
+	Root             string // Shard Merkle root (as from ReconstructState)
+	BlockCount       int
+	AccumulatorState string // Hex RSA accumulator state, empty if disabled
+	Attestation      string // Hex ed25519 signature of the source over the fields above
+}
+
+// message is the canonical encoding covered by the attestation
+func (h ShardHeader) message() string {
+	return fmt.Sprintf("%d:%d:%s:%d:%s", h.ShardID, h.Sequence, h.Root, h.BlockCount, h.AccumulatorState)
+}
+
+// MerkleProof proves a block is in a shard: the block header lets the client
+// recompute the hash, and the path links the block's leaf to the shard root
+type MerkleProof struct {
+	Block core.Block
+	Path  core.MerkleProof
+}
+
+// NewShardHeader builds a header from a full node's shard view, signed with
+// the source's private key
+func NewShardHeader(view core.ShardView, sequence uint64, source ed25519.PrivateKey) ShardHeader {
+	header := ShardHeader{
+		ShardID:          view.ID,
+		Sequence:         sequence,
+		Root:             view.Root,
+		BlockCount:       view.BlockCount,
+		AccumulatorState: view.AccumulatorState,
+	}
+	header.Attestation = hex.EncodeToString(ed25519.Sign(source, []byte(header.message())))
+	return header
+}
+
+// ProveBlock produces a light-client inclusion proof from a full node
+func ProveBlock(sm *core.ShardManager, shardID int, blockHash string) (MerkleProof, error) {
+	shard, err := sm.FindShard(shardID)
+	if err != nil {
+		return MerkleProof{}, fmt.Errorf("%w: %v", ErrUnknownShard, err)
+	}
+	block, path, err := shard.ProveInclusion(blockHash)
+	if err != nil {
+		return MerkleProof{}, err
+	}
+	return MerkleProof{Block: block, Path: path}, nil
+}
+
+// Client verifies shard contents while storing only headers and pruning proofs
+type Client struct {
+	source        ed25519.PublicKey
+	pruningKey    ed25519.PublicKey
+	headers       map[int]ShardHeader
+	pruningProofs []core.IntegrityProof
+	transitions   bool                              // Require transition proofs for known shards
+	receipts      ed25519.PublicKey                 // Sync manager key transfer receipts are signed with
+	witnesses     map[int]map[string]*big.Int       // Tracked membership witnesses by shard and block hash
+	updater       *core.WitnessService              // Keeps tracked witnesses current
+	committees    map[int]map[int]ed25519.PublicKey // Trusted committee keys by shard ID, see TrustCommittee
+	mu            sync.RWMutex
+}
+
+// NewClient creates a light client trusting headers signed by the holder of
+// sourceKey and pruning proofs signed by the holder of pruningKey. Only
+// public keys are held, so a client cannot forge the headers it accepts.
+func NewClient(sourceKey, pruningKey ed25519.PublicKey) *Client {
+	return &Client{
+		source:     sourceKey,
+		pruningKey: pruningKey,
+		headers:    make(map[int]ShardHeader),
+		witnesses:  make(map[int]map[string]*big.Int),
+		updater:    core.NewWitnessService(core.NewRSAAccumulator()),
+		committees: make(map[int]map[int]ed25519.PublicKey),
+	}
+}
+
+// RequireTransitionProofs makes Update reject headers for shards the client
+// already tracks with blocks, so their roots only advance through
+// UpdateWithTransition. An empty shard has no tree to prove appends to.
+func (c *Client) RequireTransitionProofs(required bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transitions = required
+}
+
+// checkAttestation verifies a header's signature against the source key
+func (c *Client) checkAttestation(header ShardHeader) error {
+	signature, err := hex.DecodeString(header.Attestation)
+	if err != nil || len(c.source) != ed25519.PublicKeySize || !ed25519.Verify(c.source, []byte(header.message()), signature) {
+		return fmt.Errorf("%w: shard #%d", ErrBadAttestation, header.ShardID)
+	}
+	return nil
+}
+
+// Update accepts a new shard header after checking its attestation
+func (c *Client) Update(header ShardHeader) error {
+	if err := c.checkAttestation(header); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, certified := c.committees[header.ShardID]; certified {
+		return fmt.Errorf("%w: shard #%d", ErrNeedCertificate, header.ShardID)
+	}
+	current, exists := c.headers[header.ShardID]
+	if exists && header.Sequence <= current.Sequence {
+		return fmt.Errorf("%w: shard #%d sequence %d <= %d", ErrStaleHeader, header.ShardID, header.Sequence, current.Sequence)
+	}
+	if exists && c.transitions && current.BlockCount > 0 {
+		return fmt.Errorf("%w: shard #%d", ErrNeedTransition, header.ShardID)
+	}
+	c.headers[header.ShardID] = header
+	return nil
+}
+
+// UpdateWithTransition accepts a header for a tracked shard only if proof
+// shows its root is the current root with exactly one block appended
+func (c *Client) UpdateWithTransition(header ShardHeader, proof core.TransitionProof) error {
+	if err := c.checkAttestation(header); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, certified := c.committees[header.ShardID]; certified {
+		return fmt.Errorf("%w: shard #%d", ErrNeedCertificate, header.ShardID)
+	}
+	current, exists := c.headers[header.ShardID]
+	if !exists {
+		return fmt.Errorf("%w: #%d", ErrUnknownShard, header.ShardID)
+	}
+	if header.Sequence <= current.Sequence {
+		return fmt.Errorf("%w: shard #%d sequence %d <= %d", ErrStaleHeader, header.ShardID, header.Sequence, current.Sequence)
+	}
+	if proof.OldSize != current.BlockCount || header.BlockCount != current.BlockCount+1 ||
+		!core.VerifyStateTransition(current.Root, header.Root, proof.BlockHash, proof) {
+		return fmt.Errorf("%w: shard #%d", ErrBadTransition, header.ShardID)
+	}
+	c.headers[header.ShardID] = header
+	return nil
+}
+
+// UpdateWithAdditions accepts a header for a tracked shard whose
+// accumulator state is the current one with additions applied in order, as
+// returned by core.AdditionsSince for the current state, and brings every
+// tracked witness for the shard up to date with them
+func (c *Client) UpdateWithAdditions(header ShardHeader, additions []string) error {
+	if err := c.checkAttestation(header); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, certified := c.committees[header.ShardID]; certified {
+		return fmt.Errorf("%w: shard #%d", ErrNeedCertificate, header.ShardID)
+	}
+	current, exists := c.headers[header.ShardID]
+	if !exists {
+		return fmt.Errorf("%w: #%d", ErrUnknownShard, header.ShardID)
+	}
+	if header.Sequence <= current.Sequence {
+		return fmt.Errorf("%w: shard #%d sequence %d <= %d", ErrStaleHeader, header.ShardID, header.Sequence, current.Sequence)
+	}
+	if c.transitions && current.BlockCount > 0 {
+		return fmt.Errorf("%w: shard #%d", ErrNeedTransition, header.ShardID)
+	}
+	state, ok := new(big.Int).SetString(current.AccumulatorState, 16)
+	if !ok || header.AccumulatorState == "" {
+		return fmt.Errorf("%w: shard #%d", ErrNoAccumulator, header.ShardID)
+	}
+	if c.updater.UpdateWitness(state, additions).Text(16) != header.AccumulatorState {
+		return fmt.Errorf("%w: shard #%d", ErrBadAdditions, header.ShardID)
+	}
+	if tracked := c.witnesses[header.ShardID]; len(tracked) > 0 {
+		c.witnesses[header.ShardID] = c.updater.UpdateWitnesses(tracked, additions)
+	}
+	c.headers[header.ShardID] = header
+	return nil
+}
+
+// ApplyMerge accepts the header of a shard formed by a merge, checking
+// that proof verifies, that its input roots are the tracked roots of any
+// input shards the client follows, and that its merged root is the
+// header's. The right input shard is then no longer tracked, and tracked
+// witnesses of both inputs are dropped, since the merge rebuilds the
+// accumulator.
+func (c *Client) ApplyMerge(header ShardHeader, proof core.MergeProof) error {
+	if err := c.checkAttestation(header); err != nil {
+		return err
+	}
+	if header.ShardID != proof.MergedID || header.Root != proof.MergedRoot ||
+		header.BlockCount != len(proof.MergedLeaves) || !core.VerifyMergeProof(proof) {
+		return fmt.Errorf("%w: shard #%d", ErrBadMergeProof, header.ShardID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, certified := c.committees[header.ShardID]; certified {
+		return fmt.Errorf("%w: shard #%d", ErrNeedCertificate, header.ShardID)
+	}
+	if left, exists := c.headers[proof.LeftID]; exists && left.Root != proof.LeftRoot {
+		return fmt.Errorf("%w: shard #%d root %s, proof %s", ErrBadMergeProof, proof.LeftID, left.Root, proof.LeftRoot)
+	}
+	if right, exists := c.headers[proof.RightID]; exists && right.Root != proof.RightRoot {
+		return fmt.Errorf("%w: shard #%d root %s, proof %s", ErrBadMergeProof, proof.RightID, right.Root, proof.RightRoot)
+	}
+	if current, exists := c.headers[header.ShardID]; exists && header.Sequence <= current.Sequence {
+		return fmt.Errorf("%w: shard #%d sequence %d <= %d", ErrStaleHeader, header.ShardID, header.Sequence, current.Sequence)
+	}
+	delete(c.headers, proof.RightID)
+	delete(c.witnesses, proof.LeftID)
+	delete(c.witnesses, proof.RightID)
+	c.headers[header.ShardID] = header
+	return nil
+}
+
+// TrustCommittee makes the client accept roots of a shard only through
+// UpdateCertified, with certificates signed by 2f+1 of pubkeys, e.g. the
+// PublicKeys of the shard's BFTManager
+func (c *Client) TrustCommittee(shardID int, pubkeys map[int]ed25519.PublicKey) {
+	keys := make(map[int]ed25519.PublicKey, len(pubkeys))
+	for id, pub := range pubkeys {
+		keys[id] = pub
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committees[shardID] = keys
+}
+
+// UpdateCertified accepts a shard root co-signed by the shard's trusted
+// committee, as published by a core.RootCertifier. Headers no higher than
+// the current one are rejected as stale, so old headers cannot be replayed.
+// Certified headers carry no accumulator state, so tracked witnesses of the
+// shard are dropped.
+func (c *Client) UpdateCertified(header core.ShardHeader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pubkeys, trusted := c.committees[header.ShardID]
+	if !trusted {
+		return fmt.Errorf("%w: no committee trusted for shard #%d", ErrBadCertificate, header.ShardID)
+	}
+	if err := core.VerifyShardHeader(header, pubkeys); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadCertificate, err)
+	}
+	if current, exists := c.headers[header.ShardID]; exists && header.Height <= current.Sequence {
+		return fmt.Errorf("%w: shard #%d height %d <= %d", ErrStaleHeader, header.ShardID, header.Height, current.Sequence)
+	}
+	c.headers[header.ShardID] = ShardHeader{
+		ShardID:    header.ShardID,
+		Sequence:   header.Height,
+		Root:       header.Root,
+		BlockCount: header.BlockCount,
+	}
+	delete(c.witnesses, header.ShardID)
+	return nil
+}
+
+// TrackWitness keeps a block's membership witness, which must verify
+// against the shard's current header, fresh through UpdateWithAdditions.
+// Headers accepted by Update or UpdateWithTransition leave it stale.
+func (c *Client) TrackWitness(shardID int, blockHash string, witness *big.Int) error {
+	ok, err := c.VerifyBlockMembership(shardID, blockHash, witness)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s in shard #%d", ErrBadWitness, blockHash, shardID)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.witnesses[shardID] == nil {
+		c.witnesses[shardID] = make(map[string]*big.Int)
+	}
+	c.witnesses[shardID][blockHash] = new(big.Int).Set(witness)
+	return nil
+}
+
+// Witness returns the tracked witness of a block
+func (c *Client) Witness(shardID int, blockHash string) (*big.Int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	witness, exists := c.witnesses[shardID][blockHash]
+	if !exists {
+		return nil, false
+	}
+	return new(big.Int).Set(witness), true
+}
+
+// TrustReceiptKey accepts transfer receipts signed by the sync manager
+// whose ReceiptPublicKey is key. Only the public key is held, so the client
+// cannot forge the receipts it accepts.
+func (c *Client) TrustReceiptKey(key ed25519.PublicKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.receipts = key
+}
+
+// VerifyReceipt checks a value transfer receipt using only the attested
+// shard roots: it must be signed with the trusted receipt key and name the
+// root in the source shard's current header
+func (c *Client) VerifyReceipt(receipt core.TransferReceipt) (bool, error) {
+	c.mu.RLock()
+	key := c.receipts
+	c.mu.RUnlock()
+	if key == nil {
+		return false, ErrNoReceiptKey
+	}
+	header, exists := c.Header(receipt.SourceShard)
+	if !exists {
+		return false, fmt.Errorf("%w: #%d", ErrUnknownShard, receipt.SourceShard)
+	}
+	if _, exists := c.Header(receipt.DestShard); !exists {
+		return false, fmt.Errorf("%w: #%d", ErrUnknownShard, receipt.DestShard)
+	}
+	return receipt.SourceRoot == header.Root && core.VerifyReceiptSignature(key, receipt), nil
+}
+
+// Header returns the current header for a shard
+func (c *Client) Header(shardID int) (ShardHeader, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	header, exists := c.headers[shardID]
+	return header, exists
+}
+
+// VerifyBlockInclusion checks a block belongs to a shard using only the
+// attested shard root
+func (c *Client) VerifyBlockInclusion(shardID int, blockHash string, proof MerkleProof) (bool, error) {
+	header, exists := c.Header(shardID)
+	if !exists {
+		return false, fmt.Errorf("%w: #%d", ErrUnknownShard, shardID)
+	}
+	if proof.Block.Hash != blockHash || proof.Block.ComputeHash() != blockHash {
+		return false, ErrHashMismatch
+	}
+	if proof.Path.LeafCount != header.BlockCount {
+		return false, fmt.Errorf("%w: %d != %d", ErrLeafCountChanged, proof.Path.LeafCount, header.BlockCount)
+	}
+	return core.VerifyBlockProof(header.Root, proof.Block, proof.Path), nil
+}
+
+// VerifyInclusionProof checks a proof from ShardManager.ProveBlockInclusion
+// against the attested header of the block's shard, and that the proof's
+// shard root is committed to by its forest root
+func (c *Client) VerifyInclusionProof(p core.InclusionProof) (bool, error) {
+	header, exists := c.Header(p.ShardID)
+	if !exists {
+		return false, fmt.Errorf("%w: #%d", ErrUnknownShard, p.ShardID)
+	}
+	if p.ShardRoot != header.Root {
+		return false, nil
+	}
+	ok, err := c.VerifyBlockInclusion(p.ShardID, p.Block.Hash, MerkleProof{Block: p.Block, Path: p.BlockProof})
+	if !ok || err != nil {
+		return ok, err
+	}
+	return core.VerifyInclusionProof(p.ForestRoot, p), nil
+}
+
+// VerifyBlockMembership checks an accumulator witness against the attested
+// accumulator state, without needing the block itself
+func (c *Client) VerifyBlockMembership(shardID int, blockHash string, witness *big.Int) (bool, error) {
+	header, exists := c.Header(shardID)
+	if !exists {
+		return false, fmt.Errorf("%w: #%d", ErrUnknownShard, shardID)
+	}
+	if header.AccumulatorState == "" {
+		return false, ErrNoAccumulator
+	}
+	state, ok := new(big.Int).SetString(header.AccumulatorState, 16)
+	if !ok || witness == nil {
+		return false, nil
+	}
+	acc := core.NewRSAAccumulator()
+	acc.State = state
+	return acc.CheckMembership(blockHash, witness) == nil, nil
+}
+
+// VerifyPrunedHistory checks a pruning integrity proof and remembers it
+func (c *Client) VerifyPrunedHistory(proof core.IntegrityProof) bool {
+	if !core.VerifyIntegrityProof(c.pruningKey, proof) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruningProofs = append(c.pruningProofs, proof)
+	return true
+}
+
+// VerifyPrunedBlockInclusion checks a block pruned from a shard belongs to
+// it using only the verified pruning proofs of the shard, with a path from
+// core.ProvePrunedBlock
+func (c *Client) VerifyPrunedBlockInclusion(shardID int, blockHash string, proof MerkleProof) (bool, error) {
+	if proof.Block.Hash != blockHash || proof.Block.ComputeHash() != blockHash {
+		return false, ErrHashMismatch
+	}
+	found := false
+	for _, pruning := range c.PruningProofs() {
+		if !pruning.Sharded || pruning.ShardID != shardID {
+			continue
+		}
+		found = true
+		if core.VerifyPrunedBlock(pruning, proof.Block, proof.Path) {
+			return true, nil
+		}
+	}
+	if !found {
+		return false, fmt.Errorf("%w: #%d", ErrNoPruningProof, shardID)
+	}
+	return false, nil
+}
+
+// PruningProofs returns the verified pruning proofs held by the client
+func (c *Client) PruningProofs() []core.IntegrityProof {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]core.IntegrityProof{}, c.pruningProofs...)
+}
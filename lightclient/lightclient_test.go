@@ -0,0 +1,505 @@
+package lightclient
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"blockchain-system/core"
+)
+
+// fullNode distributes n blocks over a shard manager keeping accumulators
+func fullNode(t *testing.T, n int) *core.ShardManager {
+	t.Helper()
+	sm := core.NewShardManager(core.WithShardAccumulators())
+	bc := core.NewBlockchain()
+	for i := 0; i < n; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks {
+		sm.DistributeBlock(b)
+	}
+	return sm
+}
+
+func mustGenerateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestEndToEndBlockInclusion(t *testing.T) {
+	sm := fullNode(t, 20)
+	sourcePub, sourceKey := mustGenerateKey(t)
+	pruningPub, _ := mustGenerateKey(t)
+	client := NewClient(sourcePub, pruningPub)
+
+	views := sm.GetAllShardViews()
+	for _, view := range views {
+		if err := client.Update(NewShardHeader(view, 1, sourceKey)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, view := range views {
+		shard, err := sm.FindShard(view.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range view.Blocks {
+			proof, err := ProveBlock(sm, view.ID, b.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := client.VerifyBlockInclusion(view.ID, b.Hash, proof); !ok || err != nil {
+				t.Fatalf("block %s in shard #%d: %v, %v", b.Hash, view.ID, ok, err)
+			}
+			witness, err := shard.ProveBlockMembership(b.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := client.VerifyBlockMembership(view.ID, b.Hash, witness); !ok || err != nil {
+				t.Fatalf("witness of %s in shard #%d: %v, %v", b.Hash, view.ID, ok, err)
+			}
+
+			// The proof does not carry over to another shard
+			for _, other := range views {
+				if other.ID != view.ID {
+					if ok, _ := client.VerifyBlockInclusion(other.ID, b.Hash, proof); ok {
+						t.Fatalf("block of shard #%d verified in shard #%d", view.ID, other.ID)
+					}
+				}
+			}
+		}
+	}
+
+	proof, err := ProveBlock(sm, views[0].ID, views[0].Blocks[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := proof
+	tampered.Block.Data = "tampered"
+	if _, err := client.VerifyBlockInclusion(views[0].ID, proof.Block.Hash, tampered); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("tampered block: %v", err)
+	}
+	if _, err := client.VerifyBlockInclusion(99, proof.Block.Hash, proof); !errors.Is(err, ErrUnknownShard) {
+		t.Errorf("unknown shard: %v", err)
+	}
+}
+
+func TestUpdateRequiresSourceSignature(t *testing.T) {
+	sm := fullNode(t, 5)
+	sourcePub, sourceKey := mustGenerateKey(t)
+	_, otherKey := mustGenerateKey(t)
+	pruningPub, _ := mustGenerateKey(t)
+	client := NewClient(sourcePub, pruningPub)
+	view := sm.GetAllShardViews()[0]
+
+	if err := client.Update(NewShardHeader(view, 1, otherKey)); !errors.Is(err, ErrBadAttestation) {
+		t.Errorf("header signed by another key: %v", err)
+	}
+	forged := NewShardHeader(view, 1, sourceKey)
+	forged.Root = core.NewMerkleTree([]string{"forged"}).GetRootHash()
+	if err := client.Update(forged); !errors.Is(err, ErrBadAttestation) {
+		t.Errorf("header with a swapped root: %v", err)
+	}
+	garbled := NewShardHeader(view, 1, sourceKey)
+	garbled.Attestation = "not hex"
+	if err := client.Update(garbled); !errors.Is(err, ErrBadAttestation) {
+		t.Errorf("header with a malformed signature: %v", err)
+	}
+	if _, exists := client.Header(view.ID); exists {
+		t.Fatal("rejected header stored")
+	}
+
+	if err := client.Update(NewShardHeader(view, 2, sourceKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Update(NewShardHeader(view, 2, sourceKey)); !errors.Is(err, ErrStaleHeader) {
+		t.Errorf("replayed header: %v", err)
+	}
+	if err := NewClient(nil, pruningPub).Update(NewShardHeader(view, 1, sourceKey)); !errors.Is(err, ErrBadAttestation) {
+		t.Errorf("client without a source key: %v", err)
+	}
+}
+
+func TestUpdateWithTransition(t *testing.T) {
+	sourcePub, sourceKey := mustGenerateKey(t)
+	pruningPub, _ := mustGenerateKey(t)
+	client := NewClient(sourcePub, pruningPub)
+	client.RequireTransitionProofs(true)
+	shard := core.NewShard(3)
+	shard.EnableTransitionProofs(true)
+	if err := client.Update(NewShardHeader(shard.View(), 1, sourceKey)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		b := core.Block{Index: i, Timestamp: time.Unix(int64(i), 0), Data: fmt.Sprint(i)}
+		b.Hash = b.ComputeHash()
+		old := shard.GetRoot()
+		shard.AddBlock(b)
+		header := NewShardHeader(shard.View(), uint64(i+2), sourceKey)
+		if i == 0 {
+			if err := client.Update(header); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := client.Update(header); !errors.Is(err, ErrNeedTransition) {
+			t.Fatalf("root advanced without a transition proof: %v", err)
+		}
+		proof := (&core.ZKProver{Source: shard}).ProveStateTransition(old, shard.GetRoot(), b.Hash)
+		if err := client.UpdateWithTransition(header, proof); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEndToEndPrunedHistory(t *testing.T) {
+	pruningPub, pruningKey := mustGenerateKey(t)
+	sourcePub, _ := mustGenerateKey(t)
+	archive := map[int][]core.Block{}
+	pruner := core.NewStatePruner(1, 2, false, core.WithSigningKey(pruningKey), core.WithPrunedBlocks(func(p core.IntegrityProof, blocks []core.Block) {
+		archive[p.ShardID] = blocks
+	}))
+	sm := core.NewShardManager()
+	if err := sm.SetThresholds(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		b := core.Block{Index: i, Timestamp: time.Unix(int64(i), 0), Data: fmt.Sprint(i)}
+		b.Hash = b.ComputeHash()
+		sm.DistributeBlock(b)
+	}
+	if pruned := sm.PruneAllShards(pruner, 2); pruned[0] != 4 {
+		t.Fatalf("pruned %v, want 4 blocks of shard #0", pruned)
+	}
+	proof := *pruner.GetLatestProof()
+
+	client := NewClient(sourcePub, pruningPub)
+	b, path, err := core.ProvePrunedBlock(archive[0], archive[0][2].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.VerifyPrunedBlockInclusion(0, b.Hash, MerkleProof{Block: b, Path: path}); !errors.Is(err, ErrNoPruningProof) {
+		t.Fatalf("pruned block verified without a pruning proof: %v", err)
+	}
+	forged := proof
+	forged.ShardID = 7
+	if client.VerifyPrunedHistory(forged) {
+		t.Fatal("pruning proof with a rewritten shard ID accepted")
+	}
+	if NewClient(sourcePub, sourcePub).VerifyPrunedHistory(proof) {
+		t.Fatal("pruning proof accepted under another key")
+	}
+	if !client.VerifyPrunedHistory(proof) {
+		t.Fatal("pruning proof rejected")
+	}
+	if ok, err := client.VerifyPrunedBlockInclusion(0, b.Hash, MerkleProof{Block: b, Path: path}); !ok || err != nil {
+		t.Fatalf("pruned block: %v, %v", ok, err)
+	}
+}
+
+func TestVerifyReceiptAgainstShardRoots(t *testing.T) {
+	sm := fullNode(t, 12)
+	sm.PartitionAddressSpace()
+	from, fromOK := sm.ShardForAddress("\x01alice")
+	to, toOK := sm.ShardForAddress("~bob")
+	if !fromOK || !toOK || from == to {
+		t.Fatal("sender and recipient not on two shards")
+	}
+	if err := from.Credit("\x01alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	esm := core.NewEnhancedSyncManager("receipt-key")
+	receipt, err := esm.TransferValueWithReceipt(from, to, core.Transaction{From: "\x01alice", To: "~bob", Amount: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourcePub, sourceKey := mustGenerateKey(t)
+	pruningPub, _ := mustGenerateKey(t)
+	client := NewClient(sourcePub, pruningPub)
+	if _, err := client.VerifyReceipt(receipt); !errors.Is(err, ErrNoReceiptKey) {
+		t.Fatalf("receipt without a trusted key: %v", err)
+	}
+	client.TrustReceiptKey(esm.ReceiptPublicKey())
+	if _, err := client.VerifyReceipt(receipt); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("receipt before any header: %v", err)
+	}
+	for _, view := range sm.GetAllShardViews() {
+		if err := client.Update(NewShardHeader(view, 1, sourceKey)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := client.VerifyReceipt(receipt); !ok || err != nil {
+		t.Fatalf("receipt against the attested roots: %v, %v", ok, err)
+	}
+
+	tampered := receipt
+	tampered.Amount = 3000
+	if ok, _ := client.VerifyReceipt(tampered); ok {
+		t.Fatal("receipt with a tampered amount verified")
+	}
+	other := NewClient(sourcePub, pruningPub)
+	other.TrustReceiptKey(core.NewEnhancedSyncManager("receipt-key").ReceiptPublicKey())
+	for _, view := range sm.GetAllShardViews() {
+		if err := other.Update(NewShardHeader(view, 1, sourceKey)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, _ := other.VerifyReceipt(receipt); ok {
+		t.Fatal("receipt verified under another key")
+	}
+
+	// Once the source shard's attested root moves on, the receipt no longer
+	// matches it
+	view, _ := sm.GetShardView(from.ID)
+	next, err := core.GenerateBlock(view.Blocks[len(view.Blocks)-1], "after the transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from.AddBlock(next)
+	view, _ = sm.GetShardView(from.ID)
+	if err := client.Update(NewShardHeader(view, 2, sourceKey)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := client.VerifyReceipt(receipt); ok {
+		t.Fatal("receipt verified against a later source root")
+	}
+}
+
+func TestTrackedWitnessFollowsAdditions(t *testing.T) {
+	sourcePub, sourceKey := mustGenerateKey(t)
+	pruningPub, _ := mustGenerateKey(t)
+	client := NewClient(sourcePub, pruningPub)
+	shard := core.NewShard(0)
+	shard.Accumulator = core.NewRSAAccumulator()
+	addBlocks := func(from, to int) {
+		for i := from; i < to; i++ {
+			b := core.Block{Index: i, Timestamp: time.Unix(int64(i), 0), Data: fmt.Sprint(i)}
+			b.Hash = b.ComputeHash()
+			shard.AddBlock(b)
+		}
+	}
+	addBlocks(0, 3)
+	tracked := shard.View().Blocks[2].Hash
+	if err := client.Update(NewShardHeader(shard.View(), 1, sourceKey)); err != nil {
+		t.Fatal(err)
+	}
+	witness, err := shard.ProveBlockMembership(tracked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TrackWitness(0, tracked, new(big.Int).Add(witness, big.NewInt(1))); !errors.Is(err, ErrBadWitness) {
+		t.Fatalf("tracking a witness that does not verify: %v", err)
+	}
+	if err := client.TrackWitness(0, tracked, witness); err != nil {
+		t.Fatal(err)
+	}
+
+	synced := shard.View().AccumulatorState
+	addBlocks(3, 13)
+	additions, err := core.AdditionsSince(shard.Accumulator.AdditionLog(), synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := NewShardHeader(shard.View(), 2, sourceKey)
+
+	// A header whose additions skip one is refused and changes nothing
+	if err := client.UpdateWithAdditions(header, additions[1:]); !errors.Is(err, ErrBadAdditions) {
+		t.Fatalf("additions missing one: %v", err)
+	}
+	if current, _ := client.Header(0); current.Sequence != 1 {
+		t.Fatalf("header at sequence %d after refused additions", current.Sequence)
+	}
+
+	if err := client.UpdateWithAdditions(header, additions); err != nil {
+		t.Fatal(err)
+	}
+	updated, ok := client.Witness(0, tracked)
+	if !ok {
+		t.Fatal("tracked witness lost")
+	}
+	if ok, err := client.VerifyBlockMembership(0, tracked, updated); !ok || err != nil {
+		t.Fatalf("updated witness: %v, %v", ok, err)
+	}
+	if ok, _ := client.VerifyBlockMembership(0, tracked, witness); ok {
+		t.Fatal("stale witness verified against the new header")
+	}
+}
+
+func TestApplyMergeFollowsMergedShards(t *testing.T) {
+	sm := core.NewShardManager()
+	if err := sm.SetThresholds(1, 4); err != nil {
+		t.Fatal(err)
+	}
+	bc := core.NewBlockchain()
+	for i := 0; i < 12; i++ {
+		if err := bc.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range bc.Blocks {
+		sm.DistributeBlock(b)
+	}
+	sourcePub, sourceKey := mustGenerateKey(t)
+	pruningPub, _ := mustGenerateKey(t)
+	client := NewClient(sourcePub, pruningPub)
+	for _, view := range sm.GetAllShardViews() {
+		if err := client.Update(NewShardHeader(view, 1, sourceKey)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sm.MergeShards(100)
+	proofs := sm.MergeProofs()
+	if len(proofs) == 0 {
+		t.Fatal("no shards merged")
+	}
+	proof := proofs[0]
+	view, _ := sm.GetShardView(proof.MergedID)
+	header := NewShardHeader(view, 2, sourceKey)
+
+	// A merge that dropped a block, a header for another root or one not
+	// signed by the source are refused and change nothing
+	dropped := proof
+	dropped.MergedLeaves = dropped.MergedLeaves[1:]
+	dropped.MergedRoot = core.NewMerkleTreeFromLeaves(dropped.MergedLeaves).GetRootHash()
+	droppedView := view
+	droppedView.Root, droppedView.BlockCount = dropped.MergedRoot, len(dropped.MergedLeaves)
+	_, otherKey := mustGenerateKey(t)
+	for name, tc := range map[string]struct {
+		header ShardHeader
+		proof  core.MergeProof
+		want   error
+	}{
+		"dropped block": {NewShardHeader(droppedView, 2, sourceKey), dropped, ErrBadMergeProof},
+		"other root":    {NewShardHeader(droppedView, 2, sourceKey), proof, ErrBadMergeProof},
+		"unsigned":      {NewShardHeader(view, 2, otherKey), proof, ErrBadAttestation},
+	} {
+		if err := client.ApplyMerge(tc.header, tc.proof); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v", name, err)
+		}
+		if _, exists := client.Header(proof.RightID); !exists {
+			t.Fatalf("%s: right input dropped by a refused merge", name)
+		}
+	}
+
+	// A valid merge of inputs other than the ones tracked is refused too
+	behind := NewClient(sourcePub, pruningPub)
+	left := core.ShardView{ID: proof.LeftID, Root: proof.RightRoot, BlockCount: len(proof.RightLeaves)}
+	if err := behind.Update(NewShardHeader(left, 1, sourceKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err := behind.ApplyMerge(header, proof); !errors.Is(err, ErrBadMergeProof) {
+		t.Errorf("merge of an untracked left root: %v", err)
+	}
+
+	if err := client.ApplyMerge(header, proof); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := client.Header(proof.RightID); exists {
+		t.Fatal("right input still tracked after the merge")
+	}
+	current, _ := client.Header(proof.MergedID)
+	if current.Root != view.Root || current.Sequence != 2 {
+		t.Fatalf("tracked header %+v after the merge", current)
+	}
+	if err := client.ApplyMerge(header, proof); !errors.Is(err, ErrBadMergeProof) && !errors.Is(err, ErrStaleHeader) {
+		t.Fatalf("merge applied twice: %v", err)
+	}
+
+	// Blocks of both inputs prove against the merged root
+	for _, b := range view.Blocks {
+		p, err := ProveBlock(sm, view.ID, b.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := client.VerifyBlockInclusion(view.ID, b.Hash, p); !ok || err != nil {
+			t.Fatalf("block %s after the merge: %v, %v", b.Hash, ok, err)
+		}
+	}
+}
+
+func TestUpdateCertifiedNeedsCommitteeQuorum(t *testing.T) {
+	sm := fullNode(t, 6)
+	view := sm.GetAllShardViews()[0]
+	committee := core.NewBFTManager(4)
+	for _, node := range committee.Nodes {
+		node.Byzantine = false
+	}
+	esm := core.NewEnhancedSyncManager("key")
+	esm.AssignCommittee(view.ID, committee)
+	rc := core.NewRootCertifier(sm, esm)
+	certified, failed := rc.Certify()
+	if len(failed) != 0 || len(certified) != 1 {
+		t.Fatalf("%d headers certified, failures %v", len(certified), failed)
+	}
+	header := certified[0]
+
+	sourcePub, sourceKey := mustGenerateKey(t)
+	pruningPub, _ := mustGenerateKey(t)
+	client := NewClient(sourcePub, pruningPub)
+	if err := client.UpdateCertified(header); !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("header of a shard without a trusted committee: %v", err)
+	}
+	client.TrustCommittee(view.ID, committee.PublicKeys())
+
+	forged := header
+	cert := *header.Certificate
+	cert.Votes = append([]core.Vote{}, cert.Votes...)
+	cert.Votes[0].Signature = cert.Votes[1].Signature
+	forged.Certificate = &cert
+	if err := client.UpdateCertified(forged); !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("forged signature: %v", err)
+	}
+	swapped := header
+	swapped.Root = core.NewMerkleTree([]string{"forged"}).GetRootHash()
+	if err := client.UpdateCertified(swapped); !errors.Is(err, ErrBadCertificate) {
+		t.Fatalf("swapped root: %v", err)
+	}
+	if _, exists := client.Header(view.ID); exists {
+		t.Fatal("forged header stored")
+	}
+
+	if err := client.UpdateCertified(header); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := client.Header(view.ID); current.Root != view.Root || current.Sequence != header.Height {
+		t.Fatalf("tracked header %+v", current)
+	}
+	if err := client.UpdateCertified(header); !errors.Is(err, ErrStaleHeader) {
+		t.Fatalf("replayed header: %v", err)
+	}
+	// Source-signed headers no longer move a certified shard
+	if err := client.Update(NewShardHeader(view, 10, sourceKey)); !errors.Is(err, ErrNeedCertificate) {
+		t.Fatalf("uncertified header: %v", err)
+	}
+
+	// A newer certified root replaces the old one, which cannot come back
+	shard, err := sm.FindShard(view.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := core.Block{Index: 100, Timestamp: time.Now(), Data: "newer"}
+	b.Hash = b.ComputeHash()
+	shard.AddBlock(b)
+	certified, _ = rc.Certify()
+	if len(certified) != 1 {
+		t.Fatalf("%d headers certified after a new block", len(certified))
+	}
+	if err := client.UpdateCertified(certified[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UpdateCertified(header); !errors.Is(err, ErrStaleHeader) {
+		t.Fatalf("older header after a newer one: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package p2p
+
+import (
+	"blockchain-system/core"
+)
+
+// MessageType identifies the kind of message exchanged between peers
+type MessageType string
+
+const (
+	MsgHello         MessageType = "Hello"
+	MsgNewBlock      MessageType = "NewBlock"
+	MsgRequestBlocks MessageType = "RequestBlocks"
+	MsgBlocks        MessageType = "Blocks"
+	MsgGetHeight     MessageType = "GetHeight"
+	MsgHeight        MessageType = "Height"
+)
+
+// Message is the envelope sent over the wire as length-prefixed JSON
+type Message struct {
+	Type    MessageType  `json:"type"`
+	ChainID string       `json:"chain_id,omitempty"` // Hello
+	Genesis string       `json:"genesis,omitempty"`  // Hello: genesis block hash
+	Block   *core.Block  `json:"block,omitempty"`    // NewBlock
+	From    int          `json:"from,omitempty"`     // RequestBlocks: first index (inclusive)
+	To      int          `json:"to,omitempty"`       // RequestBlocks: last index (inclusive)
+	Blocks  []core.Block `json:"blocks,omitempty"`   // Blocks response
+	Height  int          `json:"height,omitempty"`   // Height response
+}
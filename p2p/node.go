@@ -0,0 +1,330 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"blockchain-system/core"
+)
+
+// Node wraps a Blockchain and ShardManager, gossiping blocks with its peers
+type Node struct {
+	chain       *core.Blockchain
+	shards      *core.ShardManager
+	listener    net.Listener
+	peers       []*Peer
+	distributed map[string]int     // Index of each main-chain block handed to the shards
+	shardedTip  int                // Highest index handed to the shards
+	syncBatch   int                // Blocks requested per batch during sync
+	onProgress  func(SyncProgress) // Optional sync progress callback
+	mu          sync.Mutex
+}
+
+// NewNode creates a node over an existing chain and shard manager
+func NewNode(chain *core.Blockchain, shards *core.ShardManager) *Node {
+	n := &Node{
+		chain:       chain,
+		shards:      shards,
+		distributed: make(map[string]int),
+		syncBatch:   DefaultSyncBatchSize,
+	}
+	for _, b := range chain.Blocks {
+		shards.DistributeBlock(b)
+		n.distributed[b.Hash] = b.Index
+		n.shardedTip = b.Index
+	}
+	return n
+}
+
+// Listen accepts peer connections on addr (e.g. "127.0.0.1:0")
+func (n *Node) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	n.listener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			n.addPeer(NewPeer(conn))
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the node listens on
+func (n *Node) Addr() string {
+	if n.listener == nil {
+		return ""
+	}
+	return n.listener.Addr().String()
+}
+
+// Connect dials a remote node and starts exchanging messages with it
+func (n *Node) Connect(addr string) (*Peer, error) {
+	peer, err := Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	n.addPeer(peer)
+	return peer, nil
+}
+
+// addPeer introduces the node to a peer, registers it and starts its read
+// loop. The hello is sent before any gossip can reach the peer.
+func (n *Node) addPeer(peer *Peer) {
+	if err := peer.Send(n.hello()); err != nil {
+		peer.Close()
+		return
+	}
+	n.mu.Lock()
+	n.peers = append(n.peers, peer)
+	n.mu.Unlock()
+
+	go n.readLoop(peer)
+}
+
+// hello announces the node's chain to a peer
+func (n *Node) hello() Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Message{Type: MsgHello, ChainID: n.chain.ChainID(), Genesis: n.chain.GenesisHash()}
+}
+
+// checkHello verifies that a peer's hello announces the node's chain
+func (n *Node) checkHello(msg Message) error {
+	if msg.Type != MsgHello {
+		return fmt.Errorf("expected %s, got %s", MsgHello, msg.Type)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.chain.CheckChain(msg.ChainID, msg.Genesis)
+}
+
+// removePeer forgets a peer after its connection fails
+func (n *Node) removePeer(peer *Peer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, p := range n.peers {
+		if p == peer {
+			n.peers = append(n.peers[:i], n.peers[i+1:]...)
+			break
+		}
+	}
+}
+
+// readLoop handles messages from a peer until the connection closes. The
+// first message must be a hello for the same chain.
+func (n *Node) readLoop(peer *Peer) {
+	defer n.removePeer(peer)
+	msg, err := peer.Receive()
+	if err != nil || n.checkHello(msg) != nil {
+		peer.Close()
+		return
+	}
+	for {
+		msg, err := peer.Receive()
+		if err != nil {
+			peer.Close()
+			return
+		}
+		n.handleMessage(peer, msg)
+	}
+}
+
+// handleMessage dispatches a message received from a peer
+func (n *Node) handleMessage(peer *Peer, msg Message) {
+	switch msg.Type {
+	case MsgNewBlock:
+		if msg.Block == nil {
+			return
+		}
+		relay, missingFrom := n.applyBlock(*msg.Block)
+		for i := range relay {
+			n.broadcast(Message{Type: MsgNewBlock, Block: &relay[i]}, peer)
+		}
+		if missingFrom >= 0 {
+			// Parent unknown: ask the sender for the gap
+			peer.Send(Message{Type: MsgRequestBlocks, From: missingFrom, To: msg.Block.Index})
+		}
+	case MsgRequestBlocks:
+		peer.Send(Message{Type: MsgBlocks, Blocks: n.servedRange(msg.From, msg.To)})
+	case MsgGetHeight:
+		peer.Send(Message{Type: MsgHeight, Height: n.Height()})
+	case MsgBlocks:
+		for _, b := range msg.Blocks {
+			relay, _ := n.applyBlock(b)
+			for i := range relay {
+				n.broadcast(Message{Type: MsgNewBlock, Block: &relay[i]}, peer)
+			}
+		}
+	}
+}
+
+// applyBlock validates and stores a block received from a peer. It returns
+// the blocks worth relaying (the block itself plus any orphans it connected)
+// and, when the block is an orphan, the first index we are missing (-1 otherwise).
+func (n *Node) applyBlock(b core.Block) ([]core.Block, int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.knows(b.Hash) {
+		return nil, -1
+	}
+	if err := n.chain.AddBlockCandidate(b); err != nil {
+		return nil, -1
+	}
+	if _, _, err := n.chain.ResolveForks(); err != nil {
+		return nil, -1
+	}
+	if !n.knows(b.Hash) {
+		// Held as an orphan until its ancestors arrive
+		return nil, len(n.chain.Blocks)
+	}
+
+	relay := []core.Block{b}
+	for _, added := range n.distributeNew() {
+		if added.Hash != b.Hash {
+			relay = append(relay, added)
+		}
+	}
+	return relay, -1
+}
+
+// knows reports whether a block is on the main chain or a side branch
+func (n *Node) knows(hash string) bool {
+	for _, b := range n.chain.Blocks {
+		if b.Hash == hash {
+			return true
+		}
+	}
+	return n.chain.HasSideBlock(hash)
+}
+
+// distributeNew hands main-chain blocks not yet seen by the shards to the
+// ShardManager and returns them. Blocks a reorganization rolled back are
+// removed from their shards first.
+func (n *Node) distributeNew() []core.Block {
+	start := len(n.chain.Blocks)
+	for start > 0 {
+		if _, seen := n.distributed[n.chain.Blocks[start-1].Hash]; seen {
+			break
+		}
+		start--
+	}
+
+	// Blocks handed over above the last one still on the main chain were
+	// rolled back
+	fork := n.chain.Blocks[0].Index - 1
+	if start > 0 {
+		fork = n.chain.Blocks[start-1].Index
+	}
+	if n.shardedTip > fork {
+		for hash, index := range n.distributed {
+			if index > fork {
+				n.shards.RemoveBlock(hash)
+				delete(n.distributed, hash)
+			}
+		}
+		n.shardedTip = fork
+	}
+
+	added := append([]core.Block{}, n.chain.Blocks[start:]...)
+	for _, b := range added {
+		n.shards.DistributeBlock(b)
+		n.distributed[b.Hash] = b.Index
+		n.shardedTip = b.Index
+	}
+	return added
+}
+
+// AddBlock mines a block locally and broadcasts it to all peers
+func (n *Node) AddBlock(data string) (core.Block, error) {
+	n.mu.Lock()
+	if err := n.chain.AddBlock(data); err != nil {
+		n.mu.Unlock()
+		return core.Block{}, err
+	}
+	block := n.chain.Blocks[len(n.chain.Blocks)-1]
+	n.distributeNew()
+	n.mu.Unlock()
+
+	n.broadcast(Message{Type: MsgNewBlock, Block: &block}, nil)
+	return block, nil
+}
+
+// broadcast sends a message to every peer except the one it came from
+func (n *Node) broadcast(msg Message, except *Peer) {
+	n.mu.Lock()
+	peers := append([]*Peer{}, n.peers...)
+	n.mu.Unlock()
+
+	for _, p := range peers {
+		if p != except {
+			p.Send(msg)
+		}
+	}
+}
+
+// BlockRange returns main-chain blocks with indices in [from, to]
+func (n *Node) BlockRange(from, to int) []core.Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var blocks []core.Block
+	for _, b := range n.chain.Blocks {
+		if b.Index >= from && b.Index <= to {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// servedRange returns the blocks a peer asked for in [from, to], at most a
+// sync batch of them, so no request makes a reply outgrow MaxMessageSize.
+// The peer asks again for the rest.
+func (n *Node) servedRange(from, to int) []core.Block {
+	n.mu.Lock()
+	batch := n.syncBatch
+	n.mu.Unlock()
+	if from < 0 {
+		from = 0
+	}
+	if to-from >= batch {
+		to = from + batch - 1
+	}
+	return n.BlockRange(from, to)
+}
+
+// Height returns the index of the node's tip
+func (n *Node) Height() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.chain.Blocks[len(n.chain.Blocks)-1].Index
+}
+
+// Tip returns the last block of the node's main chain
+func (n *Node) Tip() core.Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.chain.Blocks[len(n.chain.Blocks)-1]
+}
+
+// Close stops listening and disconnects all peers
+func (n *Node) Close() {
+	if n.listener != nil {
+		n.listener.Close()
+	}
+	n.mu.Lock()
+	peers := n.peers
+	n.peers = nil
+	n.mu.Unlock()
+	for _, p := range peers {
+		p.Close()
+	}
+}
@@ -0,0 +1,247 @@
+package p2p
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"blockchain-system/core"
+)
+
+// listeningNode starts a node on a fresh chain listening on 127.0.0.1
+func listeningNode(t *testing.T, genesis core.GenesisConfig) *Node {
+	t.Helper()
+	n := NewNode(core.NewBlockchainFromGenesis(genesis), core.NewShardManager())
+	if err := n.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+// waitForHeight polls until a node reaches height, failing after a few seconds
+func waitForHeight(t *testing.T, n *Node, height int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for n.Height() != height {
+		if time.Now().After(deadline) {
+			t.Fatalf("node at height %d, want %d", n.Height(), height)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForPeers polls until a node has registered n peers
+func waitForPeers(t *testing.T, node *Node, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		node.mu.Lock()
+		count := len(node.peers)
+		node.mu.Unlock()
+		if count >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node has %d peers, want %d", count, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestThreeNodeGossip(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	a, b, c := listeningNode(t, genesis), listeningNode(t, genesis), listeningNode(t, genesis)
+
+	// A line topology, so C only hears about A's blocks through B
+	if _, err := b.Connect(a.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Connect(b.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitForPeers(t, a, 1)
+	waitForPeers(t, b, 2)
+
+	block, err := a.AddBlock("from a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForHeight(t, b, 1)
+	waitForHeight(t, c, 1)
+	for _, n := range []*Node{b, c} {
+		if n.Tip().Hash != block.Hash {
+			t.Fatalf("tip %s, want A's block %s", n.Tip().Hash, block.Hash)
+		}
+	}
+
+	// Blocks mined on C flow back to A
+	if _, err := c.AddBlock("from c"); err != nil {
+		t.Fatal(err)
+	}
+	waitForHeight(t, a, 2)
+	if a.Tip().Hash != c.Tip().Hash || b.Tip().Hash != c.Tip().Hash {
+		t.Fatal("nodes disagree on the tip")
+	}
+	for _, n := range []*Node{a, b, c} {
+		total := 0
+		for _, view := range n.shards.GetAllShardViews() {
+			total += view.BlockCount
+		}
+		if total != 3 {
+			t.Errorf("node's shards hold %d blocks, want 3", total)
+		}
+	}
+}
+
+func TestDuplicateAndOutOfOrderDelivery(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	a, c := listeningNode(t, genesis), listeningNode(t, genesis)
+	if _, err := a.Connect(c.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitForPeers(t, c, 1)
+
+	// Build three blocks offline and deliver them to C child first, twice
+	offline := core.NewBlockchainFromGenesis(genesis)
+	for _, data := range []string{"x", "y", "z"} {
+		if err := offline.AddBlock(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := Dial(c.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Send(Message{Type: MsgHello, ChainID: offline.ChainID(), Genesis: offline.GenesisHash()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{3, 3, 2, 1, 2, 1} {
+		if err := p.Send(Message{Type: MsgNewBlock, Block: &offline.Blocks[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForHeight(t, c, 3)
+	waitForHeight(t, a, 3)
+	for _, n := range []*Node{a, c} {
+		if n.Tip().Hash != offline.Blocks[3].Hash {
+			t.Fatal("tip is not the last offline block")
+		}
+		if blocks := n.BlockRange(0, 10); len(blocks) != 4 {
+			t.Fatalf("node holds %d main-chain blocks, want 4", len(blocks))
+		}
+	}
+}
+
+func TestReorgRemovesRolledBackBlocksFromShards(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	n := listeningNode(t, genesis)
+	var rolledBack []core.Block
+	for _, data := range []string{"a1", "a2"} {
+		b, err := n.AddBlock(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rolledBack = append(rolledBack, b)
+	}
+
+	// A longer branch from genesis arrives and wins
+	fork := core.NewBlockchainFromGenesis(genesis)
+	for _, data := range []string{"b1", "b2", "b3"} {
+		if err := fork.AddBlock(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range fork.Blocks[1:] {
+		n.applyBlock(b)
+	}
+	if n.Tip().Hash != fork.Blocks[3].Hash {
+		t.Fatal("node did not switch to the longer branch")
+	}
+
+	total := 0
+	for _, view := range n.shards.GetAllShardViews() {
+		total += view.BlockCount
+	}
+	if total != 4 {
+		t.Fatalf("shards hold %d blocks, want the 4 on the main chain", total)
+	}
+	for _, b := range rolledBack {
+		if _, held := n.shards.FindBlockShard(b.Hash); held {
+			t.Fatalf("rolled back block %s still sharded", b.Data)
+		}
+	}
+	for _, b := range fork.Blocks {
+		if _, held := n.shards.FindBlockShard(b.Hash); !held {
+			t.Fatalf("main-chain block %s not sharded", b.Data)
+		}
+	}
+}
+
+func TestGossipIgnoresOtherChains(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	other := genesis
+	other.ChainID = "other"
+	a, b := listeningNode(t, genesis), listeningNode(t, other)
+	if _, err := b.Connect(a.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddBlock("a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if b.Height() != 0 {
+		t.Fatal("block gossiped across chains")
+	}
+}
+
+func TestReceivedBlocksRunChainValidators(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	c := listeningNode(t, genesis)
+	c.mu.Lock()
+	c.chain.AddBlockValidator(core.BlockValidatorFunc(func(b core.Block) error {
+		if b.Data == "forbidden" {
+			return errors.New("forbidden payload")
+		}
+		return nil
+	}))
+	c.mu.Unlock()
+
+	// Two rival blocks at height 1, the first of which breaks the rule
+	rejected, err := core.GenerateBlock(c.Tip(), "forbidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := core.GenerateBlock(c.Tip(), "allowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Dial(c.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Send(Message{Type: MsgHello, ChainID: c.chain.ChainID(), Genesis: c.chain.GenesisHash()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []core.Block{rejected, accepted} {
+		b := b
+		if err := p.Send(Message{Type: MsgNewBlock, Block: &b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Messages on one connection are handled in order, so once the valid
+	// block is in the invalid one was already refused
+	waitForHeight(t, c, 1)
+	if c.Tip().Hash != accepted.Hash {
+		t.Fatal("tip is not the block passing the validator")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.knows(rejected.Hash) {
+		t.Fatal("block breaking a validator was stored")
+	}
+}
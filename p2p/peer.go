@@ -0,0 +1,87 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// MaxMessageSize bounds a single message to protect against bogus length prefixes
+const MaxMessageSize = 16 << 20
+
+// Peer is a connection to a remote node exchanging length-prefixed JSON messages
+type Peer struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+// NewPeer wraps an established connection
+func NewPeer(conn net.Conn) *Peer {
+	return &Peer{conn: conn}
+}
+
+// Dial connects to a remote node
+func Dial(addr string) (*Peer, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewPeer(conn), nil
+}
+
+// Addr returns the remote address of the peer
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// Send writes a message prefixed with its 4-byte big-endian length
+func (p *Peer) Send(msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MaxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds limit", len(payload))
+	}
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	var prefix [4]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(len(payload)))
+	if _, err := p.conn.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err = p.conn.Write(payload)
+	return err
+}
+
+// Receive blocks until the next message arrives
+func (p *Peer) Receive() (Message, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(p.conn, prefix[:]); err != nil {
+		return Message{}, err
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size > MaxMessageSize {
+		return Message{}, fmt.Errorf("message of %d bytes exceeds limit", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(p.conn, payload); err != nil {
+		return Message{}, err
+	}
+	var msg Message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+// Close terminates the connection
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
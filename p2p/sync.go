@@ -0,0 +1,152 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+
+	"blockchain-system/core"
+)
+
+// DefaultSyncBatchSize is the number of blocks requested per batch
+const DefaultSyncBatchSize = 500
+
+// SyncProgress reports how far a chain sync has progressed
+type SyncProgress struct {
+	Current int
+	Target  int
+}
+
+// SetSyncBatchSize configures how many blocks are requested per batch
+func (n *Node) SetSyncBatchSize(size int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if size > 0 {
+		n.syncBatch = size
+	}
+}
+
+// OnSyncProgress registers a callback invoked after every applied batch
+func (n *Node) OnSyncProgress(fn func(SyncProgress)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onProgress = fn
+}
+
+// SyncFromPeer catches the local chain up with a peer. The peer must be a
+// dedicated connection (not one registered through Connect) since responses
+// are read directly. Each batch is validated before it is appended, so after
+// a dropped connection calling again resumes from the last good block.
+// Peers on a different chain are rejected with core.ErrChainMismatch.
+func (n *Node) SyncFromPeer(p *Peer) error {
+	if err := p.Send(n.hello()); err != nil {
+		return err
+	}
+	hello, err := receiveType(p, MsgHello)
+	if err != nil {
+		return err
+	}
+	if err := n.checkHello(hello); err != nil {
+		return fmt.Errorf("peer %s: %w", p.Addr(), err)
+	}
+
+	if err := p.Send(Message{Type: MsgGetHeight}); err != nil {
+		return err
+	}
+	reply, err := receiveType(p, MsgHeight)
+	if err != nil {
+		return err
+	}
+	target := reply.Height
+
+	for {
+		n.mu.Lock()
+		current := n.chain.Blocks[len(n.chain.Blocks)-1].Index
+		batch := n.syncBatch
+		progress := n.onProgress
+		n.mu.Unlock()
+
+		if progress != nil {
+			progress(SyncProgress{Current: current, Target: target})
+		}
+		if current >= target {
+			return nil
+		}
+
+		to := current + batch
+		if to > target {
+			to = target
+		}
+		if err := p.Send(Message{Type: MsgRequestBlocks, From: current + 1, To: to}); err != nil {
+			return err
+		}
+		reply, err := receiveType(p, MsgBlocks)
+		if err != nil {
+			return err
+		}
+		if len(reply.Blocks) == 0 {
+			return fmt.Errorf("peer %s returned no blocks for %d-%d", p.Addr(), current+1, to)
+		}
+		if err := n.appendBatch(reply.Blocks); err != nil {
+			return err
+		}
+	}
+}
+
+// SyncFromAddress dials a peer and syncs from it, redialing and resuming
+// after dropped connections up to maxRetries times
+func (n *Node) SyncFromAddress(addr string, maxRetries int) error {
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		p, err := Dial(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		lastErr = n.SyncFromPeer(p)
+		p.Close()
+		if lastErr == nil || errors.Is(lastErr, core.ErrChainMismatch) {
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+// appendBatch validates hash links and recomputed hashes for a whole batch
+// before appending any of it to the chain
+func (n *Node) appendBatch(blocks []core.Block) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	prev := n.chain.Blocks[len(n.chain.Blocks)-1]
+	for _, b := range blocks {
+		if b.Index != prev.Index+1 || b.PrevHash != prev.Hash {
+			return fmt.Errorf("block #%d does not link to block #%d", b.Index, prev.Index)
+		}
+		if b.ComputeHash() != b.Hash {
+			return fmt.Errorf("block #%d has invalid hash %s", b.Index, b.Hash)
+		}
+		prev = b
+	}
+
+	for _, b := range blocks {
+		if err := n.chain.AddBlockCandidate(b); err != nil {
+			return err
+		}
+	}
+	n.distributeNew()
+	return nil
+}
+
+// receiveType reads messages until one of the wanted type arrives, ignoring
+// unsolicited gossip on the connection
+func receiveType(p *Peer, want MessageType) (Message, error) {
+	for {
+		msg, err := p.Receive()
+		if err != nil {
+			return Message{}, err
+		}
+		if msg.Type == want {
+			return msg, nil
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"blockchain-system/core"
+)
+
+// largeShards returns a shard manager splitting shards only past 1,000
+// blocks, so that long chains do not spend the test in rebalancing
+func largeShards(t *testing.T) *core.ShardManager {
+	t.Helper()
+	sm := core.NewShardManager()
+	if err := sm.SetThresholds(1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+// chainNode starts a listening node holding a chain of n blocks
+func chainNode(t *testing.T, genesis core.GenesisConfig, n int) *Node {
+	t.Helper()
+	chain := core.NewBlockchainFromGenesis(genesis)
+	for i := 0; i < n; i++ {
+		if err := chain.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := NewNode(chain, largeShards(t))
+	if err := node.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Close)
+	return node
+}
+
+func TestSyncFromPeerResumesAfterDisconnect(t *testing.T) {
+	const height = 5000
+	genesis := core.DefaultGenesisConfig()
+	source := chainNode(t, genesis, height)
+	fresh := NewNode(core.NewBlockchainFromGenesis(genesis), largeShards(t))
+	fresh.SetSyncBatchSize(250)
+
+	p, err := Dial(source.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []SyncProgress
+	fresh.OnSyncProgress(func(progress SyncProgress) {
+		reports = append(reports, progress)
+		if progress.Current >= 2000 {
+			p.Close() // Drop the connection mid-sync
+		}
+	})
+	if err := fresh.SyncFromPeer(p); err == nil {
+		t.Fatal("sync over a dropped connection succeeded")
+	}
+	reached := fresh.Height()
+	if reached < 2000 || reached >= height {
+		t.Fatalf("sync stopped at %d, want between 2000 and %d", reached, height)
+	}
+	if err := fresh.chain.Validate(); err != nil {
+		t.Fatalf("partially synced chain invalid: %v", err)
+	}
+
+	// Resume on a new connection from the last good block
+	reports = nil
+	fresh.OnSyncProgress(func(progress SyncProgress) {
+		reports = append(reports, progress)
+	})
+	p, err = Dial(source.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := fresh.SyncFromPeer(p); err != nil {
+		t.Fatal(err)
+	}
+	if reports[0].Current != reached || reports[0].Target != height {
+		t.Fatalf("resumed at %+v, want %d of %d", reports[0], reached, height)
+	}
+	for i := 1; i < len(reports); i++ {
+		if step := reports[i].Current - reports[i-1].Current; step <= 0 || step > 250 {
+			t.Fatalf("progress moved by %d blocks in one batch", step)
+		}
+	}
+	if last := reports[len(reports)-1]; last.Current != height {
+		t.Fatalf("final progress %+v, want %d", last, height)
+	}
+	if fresh.Tip().Hash != source.Tip().Hash {
+		t.Fatal("synced tip differs from the source's")
+	}
+	if err := fresh.chain.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestBlocksServesAtMostABatch(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	server := chainNode(t, genesis, 30)
+	server.SetSyncBatchSize(10)
+	p, err := Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Send(server.hello()); err != nil {
+		t.Fatal(err)
+	}
+
+	// However much is asked for, one reply holds a batch
+	for _, req := range [][2]int{{0, 1 << 40}, {-1 << 40, 1 << 40}, {25, 100}} {
+		if err := p.Send(Message{Type: MsgRequestBlocks, From: req[0], To: req[1]}); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := receiveType(p, MsgBlocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from := req[0]
+		if from < 0 {
+			from = 0
+		}
+		want := 10
+		if from+want > 31 {
+			want = 31 - from
+		}
+		if len(reply.Blocks) != want || reply.Blocks[0].Index != from {
+			t.Fatalf("request %v served %d blocks", req, len(reply.Blocks))
+		}
+	}
+
+	// A client syncing with a larger batch still catches up
+	client := NewNode(core.NewBlockchainFromGenesis(genesis), largeShards(t))
+	client.SetSyncBatchSize(1000)
+	if err := client.SyncFromAddress(server.Addr(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if client.Height() != 30 {
+		t.Fatalf("client at height %d", client.Height())
+	}
+}
+
+func TestSyncFromAddressRetriesUntilCaughtUp(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	source := chainNode(t, genesis, 300)
+	fresh := NewNode(core.NewBlockchainFromGenesis(genesis), core.NewShardManager())
+	fresh.SetSyncBatchSize(64)
+	if err := fresh.SyncFromAddress(source.Addr(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Tip().Hash != source.Tip().Hash {
+		t.Fatal("synced tip differs from the source's")
+	}
+}
+
+func TestAppendBatchRejectsBrokenLinks(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	offline := core.NewBlockchainFromGenesis(genesis)
+	for i := 0; i < 4; i++ {
+		if err := offline.AddBlock(fmt.Sprintf("block-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := NewNode(core.NewBlockchainFromGenesis(genesis), core.NewShardManager())
+
+	unlinked := append([]core.Block{}, offline.Blocks[1:]...)
+	unlinked[2].PrevHash = unlinked[0].Hash
+	if err := node.appendBatch(unlinked); err == nil {
+		t.Error("batch with a broken PrevHash link accepted")
+	}
+	rehashed := append([]core.Block{}, offline.Blocks[1:]...)
+	rehashed[1].Data = "tampered"
+	if err := node.appendBatch(rehashed); err == nil {
+		t.Error("batch with a wrong hash accepted")
+	}
+	if node.Height() != 0 {
+		t.Fatal("invalid batch partly appended")
+	}
+	if err := node.appendBatch(offline.Blocks[1:]); err != nil || node.Height() != 4 {
+		t.Fatalf("valid batch: %v, height %d", err, node.Height())
+	}
+}
+
+func TestSyncRejectsPeerOnAnotherChain(t *testing.T) {
+	genesis := core.DefaultGenesisConfig()
+	other := genesis
+	other.ChainID = "other"
+	source := chainNode(t, other, 20)
+	fresh := NewNode(core.NewBlockchainFromGenesis(genesis), largeShards(t))
+	if err := fresh.SyncFromAddress(source.Addr(), 3); !errors.Is(err, core.ErrChainMismatch) {
+		t.Fatalf("sync from another chain: %v", err)
+	}
+	if fresh.Height() != 0 {
+		t.Fatalf("synced %d blocks from another chain", fresh.Height())
+	}
+}
@@ -0,0 +1,291 @@
+package simnet
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+	"time"
+
+	"blockchain-system/core"
+)
+
+// Topics used by the message-driven BFT protocol; every payload is a
+// core.ConsensusMessage
+const (
+	TopicPropose = "bft/propose"
+	TopicPrepare = "bft/prepare"
+	TopicCommit  = "bft/commit"
+)
+
+// Proposal is the leader's announcement of a block for a view
+type Proposal struct {
+	View      int
+	Height    int
+	BlockHash string
+}
+
+// BFTCluster runs the prepare and commit phases of core.BFTManager's nodes
+// as messages over a Network, so rounds are subject to its latency, losses
+// and partitions. Each node acts through its core.Behavior, and the
+// manager's reputations are updated after every round. Messages are signed
+// envelopes checked by a core.MessageGuard at each node; invalid, replayed
+// or relayed ones are dropped and reported against the node that sent them
+// through core.BFTManager.RecordMisbehavior.
+type BFTCluster struct {
+	network  *Network
+	bft      *core.BFTManager
+	members  []*core.Node // Validator set of the round in progress
+	keys     map[int]ed25519.PublicKey
+	replicas map[int]*replica
+	faulty   map[int]bool                // Nodes caught equivocating
+	evidence []core.EquivocationEvidence // Not yet reported to the manager
+	offenses map[int]map[string]bool     // Digests of rejected messages by sending node, not yet reported
+	mutex    sync.Mutex
+}
+
+// replica is one node's view of the rounds in progress
+type replica struct {
+	node      *core.Node
+	sim       *Node
+	guard     *core.MessageGuard     // Checks signatures and drops replays
+	seen      map[voteSlot]core.Vote // First vote received per node and phase
+	prepares  map[Proposal]map[int]core.Vote
+	commits   map[Proposal]map[int]core.Vote
+	committed map[Proposal]bool // Rounds this replica has cast its commit vote for
+	decided   map[Proposal]core.CommitCertificate
+}
+
+// voteSlot identifies where a node may cast at most one vote
+type voteSlot struct {
+	nodeID int
+	phase  core.VoteType
+	view   int
+	height int
+}
+
+// NewBFTCluster attaches a simulated node for every node of bft. Nodes
+// joining bft's validator set later are attached by the first round of a
+// height they validate.
+func NewBFTCluster(network *Network, bft *core.BFTManager) (*BFTCluster, error) {
+	c := &BFTCluster{
+		network:  network,
+		bft:      bft,
+		members:  bft.Nodes,
+		keys:     bft.PublicKeys(),
+		replicas: make(map[int]*replica),
+		faulty:   make(map[int]bool),
+		offenses: make(map[int]map[string]bool),
+	}
+	for _, node := range bft.Nodes {
+		if err := c.attach(node); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// attach adds a simulated node running node's replica
+func (c *BFTCluster) attach(node *core.Node) error {
+	sim, err := c.network.AddNode(node.ID)
+	if err != nil {
+		return err
+	}
+	r := &replica{
+		node:      node,
+		sim:       sim,
+		guard:     core.NewMessageGuard(c.bft, 0),
+		seen:      make(map[voteSlot]core.Vote),
+		prepares:  make(map[Proposal]map[int]core.Vote),
+		commits:   make(map[Proposal]map[int]core.Vote),
+		committed: make(map[Proposal]bool),
+		decided:   make(map[Proposal]core.CommitCertificate),
+	}
+	c.replicas[node.ID] = r
+	for _, topic := range []string{TopicPropose, TopicPrepare, TopicCommit} {
+		sim.Handle(topic, func(m Message) { c.onMessage(r, m) })
+	}
+	return nil
+}
+
+// join makes the validator set of a height the round's members, attaching
+// nodes that joined since the last round
+func (c *BFTCluster) join(height int) error {
+	members := c.bft.ValidatorsAt(height)
+	for _, node := range members {
+		if _, exists := c.replicas[node.ID]; !exists {
+			if err := c.attach(node); err != nil {
+				return err
+			}
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.members = members
+	c.keys = c.bft.ValidatorKeysAt(height)
+	return nil
+}
+
+// Leader returns the node that proposes in a view
+func (c *BFTCluster) Leader(view int) *core.Node {
+	return c.bft.Nodes[view%len(c.bft.Nodes)]
+}
+
+// RunRound has the view's leader propose blockHash and runs the network for
+// timeout of virtual time. It returns the certificate of an honest replica
+// that gathered 2f+1 commit votes, or core.ErrQuorumNotMet. The round is
+// run by the validator set of the height; nodes outside it neither lead nor
+// have their votes counted. Equivocation seen during the round is reported
+// to the BFT manager either way.
+func (c *BFTCluster) RunRound(view, height int, blockHash string, timeout time.Duration) (core.CommitCertificate, error) {
+	if err := c.join(height); err != nil {
+		return core.CommitCertificate{}, err
+	}
+	proposal := Proposal{View: view, Height: height, BlockHash: blockHash}
+	leader := c.replicas[c.members[view%len(c.members)].ID]
+	if !leader.node.Byzantine {
+		leader.sim.Broadcast(TopicPropose, leader.node.SignProposal(view, height, blockHash))
+		c.onPropose(leader, proposal)
+	}
+	c.network.RunFor(timeout)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, evidence := range c.evidence {
+		c.bft.RecordEquivocation(evidence)
+	}
+	c.evidence = nil
+	for nodeID, messages := range c.offenses {
+		for range messages {
+			c.bft.RecordMisbehavior(nodeID)
+		}
+	}
+	c.offenses = make(map[int]map[string]bool)
+
+	candidates := append([]*core.Node{leader.node}, c.members...)
+	for _, node := range candidates {
+		if cert, ok := c.replicas[node.ID].decided[proposal]; ok && !c.faulty[node.ID] {
+			voters := make(map[int]bool, len(cert.Votes))
+			for _, vote := range cert.Votes {
+				voters[vote.NodeID] = true
+			}
+			c.bft.RecordRound(voters)
+			return cert, nil
+		}
+	}
+	c.bft.TimeoutRound(view)
+	return core.CommitCertificate{}, fmt.Errorf("%w: view %d for block #%d", core.ErrQuorumNotMet, view, height)
+}
+
+// Faulty reports whether a node has been caught equivocating
+func (c *BFTCluster) Faulty(nodeID int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.faulty[nodeID]
+}
+
+// onMessage checks a message delivered to a replica and passes its proposal
+// or vote on. A message must come from the node that signed it; anything
+// else is recorded as an offense of the node that sent it.
+func (c *BFTCluster) onMessage(r *replica, m Message) {
+	msg, ok := m.Payload.(core.ConsensusMessage)
+	if !ok || msg.Sender != m.From || r.guard.Check(msg) != nil {
+		c.mutex.Lock()
+		if c.offenses[m.From] == nil {
+			c.offenses[m.From] = make(map[string]bool)
+		}
+		c.offenses[m.From][msg.Digest()] = true
+		c.mutex.Unlock()
+		return
+	}
+
+	if msg.Type == core.ProposeMessage {
+		c.onPropose(r, Proposal{View: msg.View, Height: msg.Height, BlockHash: msg.BlockHash})
+		return
+	}
+	c.onVote(r, *msg.Vote)
+}
+
+// onPropose answers a proposal with the node's prepare votes
+func (c *BFTCluster) onPropose(r *replica, p Proposal) {
+	c.send(r, r.node.EffectiveBehavior().OnPropose(r.node, p.View, p.Height, p.BlockHash))
+}
+
+// send broadcasts an action's votes after its delay and counts them locally
+func (c *BFTCluster) send(r *replica, action core.Action) {
+	for _, vote := range action.Votes {
+		topic := TopicPrepare
+		if vote.Type == core.CommitVote {
+			topic = TopicCommit
+		}
+		r.sim.BroadcastAfter(action.Delay, topic, r.node.SignVoteMessage(vote))
+		c.onVote(r, vote)
+	}
+}
+
+// onVote records a verified vote and advances the replica's round once a
+// phase reaches core.CertificateQuorum votes from nodes not caught
+// equivocating
+func (c *BFTCluster) onVote(r *replica, v core.Vote) {
+	pub, known := c.keys[v.NodeID]
+	if !known || !core.VerifyVote(pub, v) {
+		return
+	}
+	p := Proposal{View: v.View, Height: v.Height, BlockHash: v.BlockHash}
+
+	c.mutex.Lock()
+	quorum := core.CertificateQuorum(len(c.members))
+	slot := voteSlot{nodeID: v.NodeID, phase: v.Type, view: v.View, height: v.Height}
+	if first, seen := r.seen[slot]; !seen {
+		r.seen[slot] = v
+	} else if first.BlockHash != v.BlockHash && !c.faulty[v.NodeID] {
+		c.faulty[v.NodeID] = true
+		c.evidence = append(c.evidence, core.EquivocationEvidence{First: first, Second: v})
+	}
+	if c.faulty[v.NodeID] {
+		c.mutex.Unlock()
+		return
+	}
+
+	votes := r.prepares
+	if v.Type == core.CommitVote {
+		votes = r.commits
+	}
+	if votes[p] == nil {
+		votes[p] = make(map[int]core.Vote)
+	}
+	votes[p][v.NodeID] = v
+
+	var commit *core.Action
+	if v.Type == core.PrepareVote && c.count(votes[p]) >= quorum && !r.committed[p] {
+		r.committed[p] = true
+		own := r.node.SignVote(core.CommitVote, p.View, p.Height, p.BlockHash)
+		action := r.node.EffectiveBehavior().OnVote(r.node, own)
+		commit = &action
+	}
+	if _, done := r.decided[p]; v.Type == core.CommitVote && c.count(votes[p]) >= quorum && !done {
+		cert := core.CommitCertificate{View: p.View, Height: p.Height, BlockHash: p.BlockHash, ValidatorSet: core.ValidatorSetHash(c.keys)}
+		for _, node := range c.members {
+			if vote, ok := votes[p][node.ID]; ok && !c.faulty[node.ID] {
+				cert.Votes = append(cert.Votes, vote)
+			}
+		}
+		r.decided[p] = cert
+	}
+	c.mutex.Unlock()
+
+	if commit != nil {
+		c.send(r, *commit)
+	}
+}
+
+// count returns the votes cast by nodes not caught equivocating; the mutex
+// must be held
+func (c *BFTCluster) count(votes map[int]core.Vote) int {
+	n := 0
+	for id := range votes {
+		if !c.faulty[id] {
+			n++
+		}
+	}
+	return n
+}
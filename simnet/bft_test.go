@@ -0,0 +1,152 @@
+package simnet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"blockchain-system/core"
+)
+
+// honestCluster runs n honest BFT nodes over a seeded network
+func honestCluster(t *testing.T, n int) (*Network, *BFTCluster, *core.BFTManager) {
+	t.Helper()
+	network, _ := seededNetwork(t, 0)
+	bft := core.NewBFTManager(n)
+	for _, node := range bft.Nodes {
+		node.Byzantine = false
+	}
+	cluster, err := NewBFTCluster(network, bft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return network, cluster, bft
+}
+
+func TestPartitionPreventsQuorumUntilHealed(t *testing.T) {
+	network, cluster, bft := honestCluster(t, 4)
+	keys := bft.PublicKeys()
+
+	// Neither half of 2+2 reaches the quorum of 3
+	network.Partition([]int{0, 1}, []int{2, 3})
+	if _, err := cluster.RunRound(0, 1, "block-1", time.Second); !errors.Is(err, core.ErrQuorumNotMet) {
+		t.Fatalf("round across the partition: %v", err)
+	}
+
+	network.Heal()
+	cert, err := cluster.RunRound(1, 1, "block-1", time.Second)
+	if err != nil {
+		t.Fatalf("round after healing: %v", err)
+	}
+	if cert.View != 1 || cert.BlockHash != "block-1" {
+		t.Fatalf("certificate %+v", cert)
+	}
+	if err := core.VerifyCommitCertificate(cert, keys); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMinorityPartitionKeepsQuorum(t *testing.T) {
+	network, cluster, bft := honestCluster(t, 4)
+	network.Partition([]int{3}, []int{0, 1, 2})
+	cert, err := cluster.RunRound(0, 1, "block-1", time.Second)
+	if err != nil {
+		t.Fatalf("round with one node cut off: %v", err)
+	}
+	if err := core.VerifyCommitCertificate(cert, bft.PublicKeys()); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range cert.Votes {
+		if v.NodeID == 3 {
+			t.Fatal("partitioned node's vote in the certificate")
+		}
+	}
+}
+
+func TestSilencedLeaderLosesItsView(t *testing.T) {
+	network, cluster, bft := honestCluster(t, 4)
+	leader := cluster.Leader(0)
+	for _, id := range network.NodeIDs() {
+		if id != leader.ID {
+			network.SetLink(leader.ID, id, LinkConfig{Latency: Fixed(DefaultLatency), DropRate: 1})
+		}
+	}
+	if _, err := cluster.RunRound(0, 1, "block-1", time.Second); !errors.Is(err, core.ErrQuorumNotMet) {
+		t.Fatalf("round led by a node whose messages are all lost: %v", err)
+	}
+
+	// The next leader is heard, and three voters still make the quorum
+	cert, err := cluster.RunRound(1, 1, "block-1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.VerifyCommitCertificate(cert, bft.PublicKeys()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEquivocationOverTheNetwork(t *testing.T) {
+	_, cluster, bft := honestCluster(t, 5)
+	bft.Nodes[4].Behavior = core.EquivocatingBehavior{}
+	cert, err := cluster.RunRound(0, 1, "block-1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cluster.Faulty(4) || bft.Nodes[4].Reputation != 0 {
+		t.Fatalf("equivocating node faulty %v with reputation %v", cluster.Faulty(4), bft.Nodes[4].Reputation)
+	}
+	for _, v := range cert.Votes {
+		if v.NodeID == 4 {
+			t.Fatal("equivocating node's vote in the certificate")
+		}
+	}
+}
+
+func TestReplayedCommitIgnoredAndPenalized(t *testing.T) {
+	network, cluster, bft := honestCluster(t, 4)
+
+	// An observer records every commit message of the first round
+	observer, err := network.AddNode(99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var captured []core.ConsensusMessage
+	observer.Handle(TopicCommit, func(m Message) {
+		captured = append(captured, m.Payload.(core.ConsensusMessage))
+	})
+	if _, err := cluster.RunRound(0, 1, "block-1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var old core.ConsensusMessage
+	for _, m := range captured {
+		if m.Sender == 3 {
+			old = m
+		}
+	}
+	if old.Type != core.CommitMessage {
+		t.Fatal("no commit message from node 3 captured")
+	}
+
+	// In a later view node 3 stays silent but replays its old commit, and
+	// node 2 relays it as if from node 3
+	bft.Nodes[3].Behavior = core.SilentBehavior{}
+	replayer, _ := network.Node(3)
+	replayer.Broadcast(TopicCommit, old)
+	relayer, _ := network.Node(2)
+	relayer.Send(0, TopicCommit, old)
+	cert, err := cluster.RunRound(1, 2, "block-2", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range cert.Votes {
+		if v.NodeID == 3 {
+			t.Fatal("replayed commit counted")
+		}
+	}
+	// One offense each, however many replicas dropped the message
+	for id, want := range map[int]int{0: 0, 1: 0, 2: 1, 3: 1} {
+		if got := bft.Nodes[id].Misbehavior; got != want {
+			t.Errorf("node %d misbehaved %d times, want %d", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package simnet
+
+import (
+	"blockchain-system/core"
+)
+
+// TopicCapacity carries AdaptiveCapacityManager state between nodes
+const TopicCapacity = "capacity/sync"
+
+// CapacityUpdate is one node's latest metrics for every node it knows of,
+// and its tombstones for decommissioned nodes
+type CapacityUpdate struct {
+	Metrics    map[string]core.NetworkMetrics
+	Clock      *core.VectorClock
+	Tombstones []core.Tombstone
+}
+
+// CapacityGossip exchanges capacity metrics between simulated nodes, each
+// with its own AdaptiveCapacityManager, over a Network
+type CapacityGossip struct {
+	network  *Network
+	managers map[int]*core.AdaptiveCapacityManager
+}
+
+// NewCapacityGossip attaches a manager to each node ID, adding nodes the
+// network does not have yet. Managers should use the network's virtual
+// clock, e.g. core.WithCapacityClock(network.Now).
+func NewCapacityGossip(network *Network, managers map[int]*core.AdaptiveCapacityManager) (*CapacityGossip, error) {
+	g := &CapacityGossip{network: network, managers: managers}
+	for id, acm := range managers {
+		node, exists := network.Node(id)
+		if !exists {
+			var err error
+			if node, err = network.AddNode(id); err != nil {
+				return nil, err
+			}
+		}
+		acm := acm
+		node.Handle(TopicCapacity, func(m Message) {
+			update := m.Payload.(CapacityUpdate)
+			acm.MergeTombstones(update.Tombstones)
+			acm.SyncWithPeer(update.Metrics, update.Clock)
+		})
+	}
+	return g, nil
+}
+
+// RecordObserved has every node record the metrics the network observed for
+// its own traffic
+func (g *CapacityGossip) RecordObserved() {
+	for id, acm := range g.managers {
+		acm.RecordMetrics(g.network.Metrics(id))
+	}
+}
+
+// Round sends every node's latest metrics to all other nodes. Updates are
+// applied as the network delivers them.
+func (g *CapacityGossip) Round() {
+	for id, acm := range g.managers {
+		node, exists := g.network.Node(id)
+		if !exists {
+			continue
+		}
+		node.Broadcast(TopicCapacity, snapshot(acm))
+	}
+}
+
+// snapshot collects a manager's latest metrics for every node it knows of
+func snapshot(acm *core.AdaptiveCapacityManager) CapacityUpdate {
+	update := CapacityUpdate{
+		Metrics:    make(map[string]core.NetworkMetrics),
+		Clock:      acm.GetVectorClock(),
+		Tombstones: acm.Tombstones(),
+	}
+	for nodeID := range acm.GetGlobalView() {
+		if metrics, ok := acm.GetLatestMetrics(nodeID); ok {
+			update.Metrics[nodeID] = metrics
+		}
+	}
+	return update
+}
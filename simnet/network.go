@@ -0,0 +1,443 @@
+// Package simnet simulates a message-passing network with configurable
+// latency, message loss and partitions. Time is virtual: messages are
+// delivered in order of their arrival time when the network is stepped, so
+// runs with a seeded random source are reproducible.
+package simnet
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"blockchain-system/core"
+)
+
+// Errors returned by the simulated network
+var (
+	ErrUnknownNode   = errors.New("unknown node")
+	ErrDuplicateNode = errors.New("node already exists")
+)
+
+// DefaultLatency is the latency of links without their own configuration
+const DefaultLatency = 10 * time.Millisecond
+
+// LatencyDistribution draws the delay of one message on a link
+type LatencyDistribution interface {
+	Sample(rng core.RandomSource) time.Duration
+}
+
+// Fixed delays every message by the same amount
+type Fixed time.Duration
+
+// Sample implements LatencyDistribution
+func (f Fixed) Sample(core.RandomSource) time.Duration {
+	return time.Duration(f)
+}
+
+// UniformLatency delays messages uniformly between Min and Max
+type UniformLatency struct {
+	Min, Max time.Duration
+}
+
+// Sample implements LatencyDistribution
+func (u UniformLatency) Sample(rng core.RandomSource) time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + time.Duration(rng.Float64()*float64(u.Max-u.Min))
+}
+
+// NormalLatency delays messages by a normal distribution clamped at zero
+type NormalLatency struct {
+	Mean, StdDev time.Duration
+}
+
+// Sample implements LatencyDistribution
+func (n NormalLatency) Sample(rng core.RandomSource) time.Duration {
+	// Box-Muller transform; 1-Float64 keeps the logarithm finite
+	z := math.Sqrt(-2*math.Log(1-rng.Float64())) * math.Cos(2*math.Pi*rng.Float64())
+	d := n.Mean + time.Duration(z*float64(n.StdDev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// LinkConfig describes one direction of a link between two nodes
+type LinkConfig struct {
+	Latency  LatencyDistribution
+	DropRate float64 // Probability in [0,1] that a message is lost
+}
+
+// Message is a payload in flight between two nodes
+type Message struct {
+	From      int
+	To        int
+	Topic     string
+	Payload   interface{}
+	SentAt    time.Time
+	DeliverAt time.Time
+	seq       uint64 // Breaks ties between messages arriving at the same time
+}
+
+// Handler processes a message delivered to a node
+type Handler func(Message)
+
+// LinkStats counts the traffic observed on one direction of a link
+type LinkStats struct {
+	Sent        int
+	Delivered   int
+	Dropped     int // Lost to the drop rate or a partition
+	MeanLatency time.Duration
+	total       time.Duration
+}
+
+type linkKey struct {
+	from, to int
+}
+
+// Network routes messages between simulated nodes
+type Network struct {
+	nodes       map[int]*Node
+	links       map[linkKey]LinkConfig
+	defaultLink LinkConfig
+	blocked     map[linkKey]bool // Directions cut by a partition
+	stats       map[linkKey]*LinkStats
+	queue       messageQueue
+	seq         uint64
+	start       time.Time
+	now         time.Time
+	rng         core.RandomSource
+	mutex       sync.Mutex
+}
+
+// Option configures a Network
+type Option func(*Network)
+
+// WithRandom draws latencies and drops from src
+func WithRandom(src core.RandomSource) Option {
+	return func(n *Network) {
+		n.rng = src
+	}
+}
+
+// WithDefaultLink applies cfg to every link without its own configuration
+func WithDefaultLink(cfg LinkConfig) Option {
+	return func(n *Network) {
+		n.defaultLink = cfg
+	}
+}
+
+// WithStartTime sets the virtual time the simulation starts at
+func WithStartTime(t time.Time) Option {
+	return func(n *Network) {
+		n.start = t
+	}
+}
+
+// New creates an empty network
+func New(opts ...Option) *Network {
+	n := &Network{
+		nodes:       make(map[int]*Node),
+		links:       make(map[linkKey]LinkConfig),
+		defaultLink: LinkConfig{Latency: Fixed(DefaultLatency)},
+		blocked:     make(map[linkKey]bool),
+		stats:       make(map[linkKey]*LinkStats),
+		start:       time.Now(),
+		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.now = n.start
+	return n
+}
+
+// AddNode attaches a node with the given ID
+func (n *Network) AddNode(id int) (*Node, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, exists := n.nodes[id]; exists {
+		return nil, fmt.Errorf("%w: #%d", ErrDuplicateNode, id)
+	}
+	node := &Node{ID: id, network: n, handlers: make(map[string]Handler)}
+	n.nodes[id] = node
+	return node, nil
+}
+
+// Node returns an attached node
+func (n *Network) Node(id int) (*Node, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	node, exists := n.nodes[id]
+	return node, exists
+}
+
+// NodeIDs returns the IDs of every attached node
+func (n *Network) NodeIDs() []int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	ids := make([]int, 0, len(n.nodes))
+	for id := range n.nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// SetLink configures the link from one node to another
+func (n *Network) SetLink(from, to int, cfg LinkConfig) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.links[linkKey{from, to}] = cfg
+}
+
+// Partition cuts every link between a node in a and a node in b, in both
+// directions. Messages already in flight across the cut are lost.
+func (n *Network) Partition(a, b []int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, x := range a {
+		for _, y := range b {
+			n.blocked[linkKey{x, y}] = true
+			n.blocked[linkKey{y, x}] = true
+		}
+	}
+}
+
+// Heal removes every partition
+func (n *Network) Heal() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.blocked = make(map[linkKey]bool)
+}
+
+// Now returns the current virtual time
+func (n *Network) Now() time.Time {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.now
+}
+
+// Pending returns the number of messages in flight
+func (n *Network) Pending() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.queue.Len()
+}
+
+// send queues a message delay after the current time, applying the link's
+// drop rate and latency
+func (n *Network) send(from, to int, topic string, payload interface{}, delay time.Duration) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, exists := n.nodes[to]; !exists {
+		return fmt.Errorf("%w: #%d", ErrUnknownNode, to)
+	}
+
+	key := linkKey{from, to}
+	stats := n.linkStats(key)
+	stats.Sent++
+	cfg, exists := n.links[key]
+	if !exists {
+		cfg = n.defaultLink
+	}
+	if n.blocked[key] || (cfg.DropRate > 0 && n.rng.Float64() < cfg.DropRate) {
+		stats.Dropped++
+		return nil
+	}
+
+	var latency time.Duration
+	if cfg.Latency != nil {
+		latency = cfg.Latency.Sample(n.rng)
+	}
+	sentAt := n.now.Add(delay)
+	n.seq++
+	heap.Push(&n.queue, &Message{
+		From:      from,
+		To:        to,
+		Topic:     topic,
+		Payload:   payload,
+		SentAt:    sentAt,
+		DeliverAt: sentAt.Add(latency),
+		seq:       n.seq,
+	})
+	return nil
+}
+
+// linkStats returns the counters for a link; the mutex must be held
+func (n *Network) linkStats(key linkKey) *LinkStats {
+	stats, exists := n.stats[key]
+	if !exists {
+		stats = &LinkStats{}
+		n.stats[key] = stats
+	}
+	return stats
+}
+
+// Step delivers the next message, advancing virtual time to its arrival.
+// It returns false when no messages are in flight.
+func (n *Network) Step() bool {
+	return n.step(time.Time{})
+}
+
+// step delivers the next message arriving no later than deadline, or the
+// next message at all if deadline is zero
+func (n *Network) step(deadline time.Time) bool {
+	n.mutex.Lock()
+	if n.queue.Len() == 0 || (!deadline.IsZero() && n.queue[0].DeliverAt.After(deadline)) {
+		n.mutex.Unlock()
+		return false
+	}
+	msg := heap.Pop(&n.queue).(*Message)
+	n.now = msg.DeliverAt
+
+	key := linkKey{msg.From, msg.To}
+	stats := n.linkStats(key)
+	if n.blocked[key] {
+		stats.Dropped++
+		n.mutex.Unlock()
+		return true
+	}
+	stats.Delivered++
+	stats.total += msg.DeliverAt.Sub(msg.SentAt)
+	stats.MeanLatency = stats.total / time.Duration(stats.Delivered)
+	handler := n.nodes[msg.To].handlers[msg.Topic]
+	n.mutex.Unlock()
+
+	// Handlers run unlocked so they can send replies
+	if handler != nil {
+		handler(*msg)
+	}
+	return true
+}
+
+// Run delivers messages until none are in flight
+func (n *Network) Run() {
+	for n.Step() {
+	}
+}
+
+// RunFor delivers every message arriving within d of the current virtual
+// time, then advances the clock by d
+func (n *Network) RunFor(d time.Duration) {
+	deadline := n.Now().Add(d)
+	for n.step(deadline) {
+	}
+	n.mutex.Lock()
+	n.now = deadline
+	n.mutex.Unlock()
+}
+
+// LinkStats returns the traffic observed from one node to another
+func (n *Network) LinkStats(from, to int) LinkStats {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if stats, exists := n.stats[linkKey{from, to}]; exists {
+		return *stats
+	}
+	return LinkStats{}
+}
+
+// NodeName is the node ID used in core.NetworkMetrics for a simulated node
+func NodeName(id int) string {
+	return fmt.Sprintf("node%d", id)
+}
+
+// Metrics derives a node's NetworkMetrics from the traffic it has sent:
+// mean observed latency, the fraction of messages lost and delivered
+// messages per virtual second
+func (n *Network) Metrics(id int) core.NetworkMetrics {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	var sent, delivered, dropped int
+	var total time.Duration
+	for key, stats := range n.stats {
+		if key.from != id {
+			continue
+		}
+		sent += stats.Sent
+		delivered += stats.Delivered
+		dropped += stats.Dropped
+		total += stats.total
+	}
+
+	metrics := core.NetworkMetrics{NodeID: NodeName(id), Timestamp: n.now}
+	if delivered > 0 {
+		metrics.Latency = total / time.Duration(delivered)
+	}
+	if sent > 0 {
+		metrics.ErrorRate = float64(dropped) / float64(sent)
+	}
+	if elapsed := n.now.Sub(n.start).Seconds(); elapsed > 0 {
+		metrics.Throughput = float64(delivered) / elapsed
+	}
+	return metrics
+}
+
+// Node is one endpoint of the simulated network
+type Node struct {
+	ID       int
+	network  *Network
+	handlers map[string]Handler // Guarded by the network mutex
+}
+
+// Handle registers the handler for messages on a topic
+func (nd *Node) Handle(topic string, h Handler) {
+	nd.network.mutex.Lock()
+	defer nd.network.mutex.Unlock()
+	nd.handlers[topic] = h
+}
+
+// Send queues a message to another node
+func (nd *Node) Send(to int, topic string, payload interface{}) error {
+	return nd.network.send(nd.ID, to, topic, payload, 0)
+}
+
+// SendAfter queues a message that leaves the node after delay
+func (nd *Node) SendAfter(delay time.Duration, to int, topic string, payload interface{}) error {
+	return nd.network.send(nd.ID, to, topic, payload, delay)
+}
+
+// Broadcast queues a message to every other node
+func (nd *Node) Broadcast(topic string, payload interface{}) {
+	nd.BroadcastAfter(0, topic, payload)
+}
+
+// BroadcastAfter queues a message to every other node that leaves after delay
+func (nd *Node) BroadcastAfter(delay time.Duration, topic string, payload interface{}) {
+	for _, id := range nd.network.NodeIDs() {
+		if id != nd.ID {
+			nd.SendAfter(delay, id, topic, payload)
+		}
+	}
+}
+
+// messageQueue orders messages by arrival time
+type messageQueue []*Message
+
+func (q messageQueue) Len() int { return len(q) }
+
+func (q messageQueue) Less(i, j int) bool {
+	if q[i].DeliverAt.Equal(q[j].DeliverAt) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].DeliverAt.Before(q[j].DeliverAt)
+}
+
+func (q messageQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *messageQueue) Push(x interface{}) { *q = append(*q, x.(*Message)) }
+
+func (q *messageQueue) Pop() interface{} {
+	old := *q
+	msg := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return msg
+}
@@ -0,0 +1,222 @@
+package simnet
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"blockchain-system/core"
+)
+
+var simStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// seededNetwork returns a network of n nodes with a fixed random source and
+// start time, recording every delivered message by receiver
+func seededNetwork(t *testing.T, n int, opts ...Option) (*Network, map[int][]Message) {
+	t.Helper()
+	opts = append([]Option{WithRandom(rand.New(rand.NewSource(1))), WithStartTime(simStart)}, opts...)
+	network := New(opts...)
+	received := make(map[int][]Message)
+	for id := 0; id < n; id++ {
+		node, err := network.AddNode(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := id
+		node.Handle("test", func(m Message) { received[id] = append(received[id], m) })
+	}
+	return network, received
+}
+
+func TestNetworkDeliversInArrivalOrder(t *testing.T) {
+	network, received := seededNetwork(t, 3)
+	network.SetLink(0, 2, LinkConfig{Latency: Fixed(50 * time.Millisecond)})
+	node0, _ := network.Node(0)
+	node1, _ := network.Node(1)
+	node0.Send(2, "test", "slow")
+	node1.Send(2, "test", "fast")
+	node1.SendAfter(time.Second, 2, "test", "late")
+
+	network.RunFor(100 * time.Millisecond)
+	if len(received[2]) != 2 || received[2][0].Payload != "fast" || received[2][1].Payload != "slow" {
+		t.Fatalf("received %+v", received[2])
+	}
+	if got := received[2][1].DeliverAt.Sub(simStart); got != 50*time.Millisecond {
+		t.Fatalf("slow link delivered after %v", got)
+	}
+	if network.Now() != simStart.Add(100*time.Millisecond) || network.Pending() != 1 {
+		t.Fatalf("virtual time %v with %d pending", network.Now().Sub(simStart), network.Pending())
+	}
+	network.Run()
+	if len(received[2]) != 3 || received[2][2].DeliverAt != simStart.Add(time.Second+DefaultLatency) {
+		t.Fatalf("late message %+v", received[2])
+	}
+
+	if _, err := network.AddNode(0); err == nil {
+		t.Fatal("duplicate node added")
+	}
+	if err := node0.Send(9, "test", nil); err == nil {
+		t.Fatal("message sent to an unknown node")
+	}
+}
+
+func TestPartitionAndHeal(t *testing.T) {
+	network, received := seededNetwork(t, 4)
+	node0, _ := network.Node(0)
+	node2, _ := network.Node(2)
+
+	// A message in flight across the cut is lost too
+	node0.Send(2, "test", "in flight")
+	network.Partition([]int{0, 1}, []int{2, 3})
+	node0.Broadcast("test", "partitioned")
+	node2.Send(0, "test", "partitioned")
+	network.Run()
+	if len(received[1]) != 1 || len(received[2]) != 0 || len(received[3]) != 0 || len(received[0]) != 0 {
+		t.Fatalf("delivered across the partition: %v", received)
+	}
+	if stats := network.LinkStats(0, 2); stats.Sent != 2 || stats.Dropped != 2 || stats.Delivered != 0 {
+		t.Fatalf("link 0→2 stats %+v", stats)
+	}
+
+	network.Heal()
+	node0.Send(2, "test", "healed")
+	node2.Send(0, "test", "healed")
+	network.Run()
+	if len(received[2]) != 1 || len(received[0]) != 1 {
+		t.Fatalf("healed network delivered %v", received)
+	}
+}
+
+func TestDropRateAndLatencyDistributions(t *testing.T) {
+	network, received := seededNetwork(t, 2, WithDefaultLink(LinkConfig{
+		Latency:  UniformLatency{Min: 10 * time.Millisecond, Max: 30 * time.Millisecond},
+		DropRate: 0.25,
+	}))
+	node0, _ := network.Node(0)
+	for i := 0; i < 4000; i++ {
+		node0.Send(1, "test", i)
+	}
+	network.Run()
+
+	stats := network.LinkStats(0, 1)
+	if rate := float64(stats.Dropped) / float64(stats.Sent); rate < 0.22 || rate > 0.28 {
+		t.Fatalf("dropped %.3f of messages, want 0.25", rate)
+	}
+	if stats.MeanLatency < 19*time.Millisecond || stats.MeanLatency > 21*time.Millisecond {
+		t.Fatalf("mean latency %v, want 20ms", stats.MeanLatency)
+	}
+	for _, m := range received[1] {
+		if d := m.DeliverAt.Sub(m.SentAt); d < 10*time.Millisecond || d >= 30*time.Millisecond {
+			t.Fatalf("message delayed %v outside [10ms, 30ms)", d)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	normal := NormalLatency{Mean: 5 * time.Millisecond, StdDev: 10 * time.Millisecond}
+	for i := 0; i < 1000; i++ {
+		if d := normal.Sample(rng); d < 0 {
+			t.Fatalf("normal latency sampled %v", d)
+		}
+	}
+}
+
+func TestMetricsFromObservedTraffic(t *testing.T) {
+	network, _ := seededNetwork(t, 3)
+	network.SetLink(0, 1, LinkConfig{Latency: Fixed(20 * time.Millisecond)})
+	network.SetLink(0, 2, LinkConfig{Latency: Fixed(40 * time.Millisecond), DropRate: 1})
+	node0, _ := network.Node(0)
+	for i := 0; i < 3; i++ {
+		node0.Broadcast("test", i)
+	}
+	network.RunFor(time.Second)
+
+	metrics := network.Metrics(0)
+	if metrics.NodeID != NodeName(0) || metrics.Timestamp != simStart.Add(time.Second) {
+		t.Fatalf("metrics %+v", metrics)
+	}
+	if metrics.Latency != 20*time.Millisecond || metrics.ErrorRate != 0.5 || metrics.Throughput != 3 {
+		t.Fatalf("latency %v, error rate %v, throughput %v", metrics.Latency, metrics.ErrorRate, metrics.Throughput)
+	}
+	if idle := network.Metrics(1); idle.Latency != 0 || idle.ErrorRate != 0 {
+		t.Fatalf("idle node metrics %+v", idle)
+	}
+}
+
+func TestCapacityGossipSpreadsObservedMetrics(t *testing.T) {
+	network, _ := seededNetwork(t, 0)
+	managers := make(map[int]*core.AdaptiveCapacityManager)
+	for id := 0; id < 3; id++ {
+		managers[id] = core.NewAdaptiveCapacityManager(NodeName(id), core.WithCapacityClock(network.Now))
+	}
+	gossip, err := NewCapacityGossip(network, managers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.SetLink(2, 0, LinkConfig{Latency: Fixed(300 * time.Millisecond)})
+	network.SetLink(2, 1, LinkConfig{Latency: Fixed(300 * time.Millisecond)})
+
+	for round := 0; round < 2; round++ {
+		gossip.Round()
+		network.RunFor(time.Second)
+		gossip.RecordObserved()
+	}
+	gossip.Round()
+	network.Run()
+
+	for id, acm := range managers {
+		for peer := 0; peer < 3; peer++ {
+			if _, ok := acm.GetLatestMetrics(NodeName(peer)); !ok {
+				t.Fatalf("node %d has no metrics for node %d", id, peer)
+			}
+		}
+		slow, _ := acm.GetLatestMetrics(NodeName(2))
+		if slow.Latency != 300*time.Millisecond {
+			t.Fatalf("node %d sees node 2 at %v", id, slow.Latency)
+		}
+	}
+}
+
+func TestCapacityGossipKeepsDecommissionedNodeGone(t *testing.T) {
+	network, _ := seededNetwork(t, 0)
+	managers := make(map[int]*core.AdaptiveCapacityManager)
+	for id := 0; id < 3; id++ {
+		managers[id] = core.NewAdaptiveCapacityManager(NodeName(id), core.WithCapacityClock(network.Now))
+	}
+	gossip, err := NewCapacityGossip(network, managers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 2; round++ {
+		gossip.Round()
+		network.RunFor(time.Second)
+		gossip.RecordObserved()
+	}
+
+	// Node 2 shuts down and node 0 decommissions it, while node 1 still
+	// holds its latest metrics and gossips them
+	gone := NodeName(2)
+	if _, ok := managers[1].GetLatestMetrics(gone); !ok {
+		t.Fatal("node 1 never heard from node 2")
+	}
+	delete(managers, 2)
+	network.RunFor(time.Second)
+	managers[0].DecommissionNode(gone)
+	for round := 0; round < 3; round++ {
+		gossip.Round()
+		network.RunFor(time.Second)
+		gossip.RecordObserved()
+	}
+	network.Run()
+
+	for id, acm := range managers {
+		if _, ok := acm.GetLatestMetrics(gone); ok {
+			t.Fatalf("node %d has metrics for the decommissioned node", id)
+		}
+		if _, ok := acm.GetGlobalView()[gone]; ok || !acm.IsDecommissioned(gone) {
+			t.Fatalf("node %d still knows the decommissioned node", id)
+		}
+		if _, ok := acm.GetLatestMetrics(NodeName(1 - id)); !ok {
+			t.Fatalf("node %d lost metrics for its live peer", id)
+		}
+	}
+}
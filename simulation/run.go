@@ -0,0 +1,328 @@
+package simulation
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"blockchain-system/core"
+	"blockchain-system/simnet"
+)
+
+// ErrTransferLost is the failure injected into cross-shard transfers at the
+// network's error rate
+var ErrTransferLost = errors.New("transfer lost in the network")
+
+// Sample is the state of the run after one proposal
+type Sample struct {
+	Proposal    int                   `json:"proposal"`
+	ChainHeight int                   `json:"chain_height"`
+	Shards      int                   `json:"shards"`
+	Level       core.ConsistencyLevel `json:"level"`
+	MinBlocks   int                   `json:"min_blocks"` // Shard thresholds in effect
+	MaxBlocks   int                   `json:"max_blocks"`
+}
+
+// TransferStats counts cross-shard block transfers
+type TransferStats struct {
+	Attempted   int     `json:"attempted"`
+	Committed   int     `json:"committed"`
+	Failed      int     `json:"failed"`
+	SuccessRate float64 `json:"success_rate"`
+}
+
+// ConsensusStats counts BFT views. A proposal is skipped when none of its
+// views reaches a commit certificate.
+type ConsensusStats struct {
+	Rounds         int `json:"rounds"`
+	Decided        int `json:"decided"`
+	Skipped        int `json:"skipped"`
+	ByzantineNodes int `json:"byzantine_nodes"`
+}
+
+// PruningStats summarizes the pruning passes that removed blocks
+type PruningStats struct {
+	Passes           int    `json:"passes"`
+	PrunedBlocks     int    `json:"pruned_blocks"`
+	LastRoot         string `json:"last_root,omitempty"`         // Hash over the last pass's pruned blocks
+	AccumulatorState string `json:"accumulator_state,omitempty"` // Global commitment at the last pass
+}
+
+// Report is the outcome of a run. It holds no wall-clock data, so runs of
+// the same scenario produce identical reports.
+type Report struct {
+	Seed        int64          `json:"seed"`
+	ChainHeight int            `json:"chain_height"`
+	Timeline    []Sample       `json:"timeline"`
+	Transfers   TransferStats  `json:"transfers"`
+	Consensus   ConsensusStats `json:"consensus"`
+	Pruning     PruningStats   `json:"pruning"`
+	FinalShards int            `json:"final_shards"`
+	ForestRoot  string         `json:"forest_root"`
+}
+
+// run is the state of one Scenario.Run
+type run struct {
+	scenario     Scenario
+	rng          *rand.Rand // Workload: payloads, transfers, Byzantine nodes
+	network      *simnet.Network
+	cluster      *simnet.BFTCluster
+	nodeIDs      []int
+	chain        *core.Blockchain
+	shards       *core.ShardManager
+	esm          *core.EnhancedSyncManager
+	links        *lossySyncer
+	orchestrator *core.ConsistencyOrchestrator
+	capacity     *core.AdaptiveCapacityManager
+	thresholds   *core.AdaptiveThresholdController
+	pruner       *core.StatePruner
+	phase        *NetworkPhase // In effect on the network's links
+	view         int
+	report       Report
+}
+
+// Run executes the scenario. Each proposal advances virtual time by the
+// block interval and then grows the chain by one block once BFT consensus
+// decides it, distributes the block over the shards, attempts the
+// scenario's cross-shard transfers and, every prune interval, prunes the
+// chain. Network conditions are applied before each proposal, and the
+// shard thresholds follow them through an AdaptiveThresholdController.
+func (s Scenario) Run() (Report, error) {
+	if err := s.Validate(); err != nil {
+		return Report{}, err
+	}
+	r, err := newRun(s)
+	if err != nil {
+		return Report{}, err
+	}
+	for proposal := 1; proposal <= s.Blocks; proposal++ {
+		r.applyPhase(s.Network.phaseAt(proposal))
+		if err := r.propose(); err != nil {
+			return Report{}, fmt.Errorf("proposal %d: %w", proposal, err)
+		}
+		for i := 0; i < s.TransfersPerBlock; i++ {
+			r.transfer()
+		}
+		if s.PruneInterval > 0 && proposal%s.PruneInterval == 0 {
+			r.prune()
+		}
+		r.report.Timeline = append(r.report.Timeline, r.sample(proposal))
+	}
+
+	state := r.shards.State()
+	r.report.ChainHeight = r.tip().Index
+	r.report.FinalShards = len(state.Shards)
+	r.report.ForestRoot = state.ForestRoot
+	if t := &r.report.Transfers; t.Attempted > 0 {
+		t.SuccessRate = float64(t.Committed) / float64(t.Attempted)
+	}
+	return r.report, nil
+}
+
+// newRun wires the components of a run together
+func newRun(s Scenario) (*run, error) {
+	r := &run{
+		scenario:     s,
+		rng:          rand.New(rand.NewSource(s.Seed)),
+		chain:        core.NewBlockchain(),
+		orchestrator: core.NewOrchestrator(),
+		report:       Report{Seed: s.Seed},
+	}
+	r.network = simnet.New(
+		simnet.WithRandom(rand.New(rand.NewSource(s.Seed+1))),
+		simnet.WithStartTime(r.chain.Blocks[0].Timestamp),
+	)
+	r.capacity = core.NewAdaptiveCapacityManager("simulation", core.WithCapacityClock(r.network.Now))
+
+	bft := core.NewBFTManager(s.Nodes, core.WithBFTRandom(r.rng), core.WithRoundTimeout(s.RoundTimeout))
+	for _, node := range bft.Nodes {
+		node.Byzantine = false
+	}
+	byzantine := int(s.ByzantineFraction * float64(s.Nodes))
+	for _, i := range r.rng.Perm(s.Nodes)[:byzantine] {
+		bft.Nodes[i].Byzantine = true
+	}
+	r.report.Consensus.ByzantineNodes = byzantine
+	cluster, err := simnet.NewBFTCluster(r.network, bft)
+	if err != nil {
+		return nil, err
+	}
+	r.cluster = cluster
+	r.nodeIDs = r.network.NodeIDs()
+
+	r.shards = core.NewShardManager()
+	if err := r.shards.SetThresholds(s.MinBlocksPerShard, s.MaxBlocksPerShard); err != nil {
+		return nil, err
+	}
+	r.thresholds = core.NewAdaptiveThresholdController(r.shards, r.orchestrator, r.capacity)
+
+	r.esm = core.NewEnhancedSyncManager(fmt.Sprintf("simulation-%d", s.Seed))
+	r.links = &lossySyncer{syncer: core.NewSyncManager(), rng: r.rng}
+	r.esm.SetSyncManager(r.links)
+
+	seed := make([]byte, ed25519.SeedSize)
+	r.rng.Read(seed)
+	r.pruner = core.NewStatePruner(s.PruneInterval, s.PruneRetention, false,
+		core.WithSigningKey(ed25519.NewKeyFromSeed(seed)),
+		core.WithGlobalCommitment(core.NewGlobalCommitmentManager(r.shards)))
+	return r, nil
+}
+
+// applyPhase reconfigures every link when the network phase changes and
+// reports the phase's conditions to the orchestrator and capacity manager,
+// letting the threshold controller rescale the shard thresholds
+func (r *run) applyPhase(phase NetworkPhase) {
+	if r.phase == nil || *r.phase != phase {
+		var latency simnet.LatencyDistribution = simnet.Fixed(phase.Latency)
+		if phase.Jitter > 0 {
+			low := phase.Latency - phase.Jitter
+			if low < 0 {
+				low = 0
+			}
+			latency = simnet.UniformLatency{Min: low, Max: phase.Latency + phase.Jitter}
+		}
+		for _, from := range r.nodeIDs {
+			for _, to := range r.nodeIDs {
+				if from != to {
+					r.network.SetLink(from, to, simnet.LinkConfig{Latency: latency, DropRate: phase.ErrorRate})
+				}
+			}
+		}
+		r.links.errorRate = phase.ErrorRate
+		r.phase = &phase
+	}
+
+	r.orchestrator.EvaluateNetwork(phase.Latency, phase.ErrorRate)
+	for _, id := range r.nodeIDs {
+		r.capacity.RecordMetrics(core.NetworkMetrics{
+			NodeID:    simnet.NodeName(id),
+			Latency:   phase.Latency,
+			ErrorRate: phase.ErrorRate,
+		})
+	}
+	r.thresholds.Evaluate()
+}
+
+// propose generates the next block and runs consensus views on it until
+// one decides or the scenario's views run out. A decided block is added to
+// the chain with its certificate and distributed over the shards.
+func (r *run) propose() error {
+	r.network.RunFor(r.scenario.BlockInterval)
+	tip := r.tip()
+	block, err := core.GenerateBlockAt(tip, r.payload(), r.network.Now())
+	if err != nil {
+		return err
+	}
+
+	for v := 0; v < r.scenario.MaxViews; v++ {
+		r.report.Consensus.Rounds++
+		cert, err := r.cluster.RunRound(r.view, block.Index, block.Hash, r.scenario.RoundTimeout)
+		r.view++
+		if errors.Is(err, core.ErrQuorumNotMet) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.chain.AddBlockCandidate(block); err != nil {
+			return err
+		}
+		if err := r.chain.AttachCertificate(cert); err != nil {
+			return err
+		}
+		r.shards.DistributeBlock(block)
+		r.report.Consensus.Decided++
+		return nil
+	}
+	r.report.Consensus.Skipped++
+	return nil
+}
+
+// payload returns random block data of a size drawn from the scenario
+func (r *run) payload() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	data := make([]byte, r.scenario.BlockSize.sample(r.rng))
+	for i := range data {
+		data[i] = alphabet[r.rng.Intn(len(alphabet))]
+	}
+	return string(data)
+}
+
+// transfer moves a random block of a random non-empty shard to another
+// random shard
+func (r *run) transfer() {
+	views := r.shards.GetAllShardViews()
+	var sources []core.ShardView
+	for _, view := range views {
+		if view.BlockCount > 0 {
+			sources = append(sources, view)
+		}
+	}
+	if len(views) < 2 || len(sources) == 0 {
+		return
+	}
+	source := sources[r.rng.Intn(len(sources))]
+	var destinations []int
+	for _, view := range views {
+		if view.ID != source.ID {
+			destinations = append(destinations, view.ID)
+		}
+	}
+	destination := destinations[r.rng.Intn(len(destinations))]
+
+	r.report.Transfers.Attempted++
+	if err := r.shards.TransferBlock(r.esm, source.ID, destination, r.rng.Intn(source.BlockCount)); err != nil {
+		r.report.Transfers.Failed++
+		return
+	}
+	r.report.Transfers.Committed++
+}
+
+// prune runs one pruning pass over the chain
+func (r *run) prune() {
+	pruned := r.pruner.PruneBlockchain(r.chain)
+	if pruned == 0 {
+		return
+	}
+	stats := &r.report.Pruning
+	stats.Passes++
+	stats.PrunedBlocks += pruned
+	if proof := r.pruner.GetLatestProof(); proof != nil {
+		stats.LastRoot = proof.RootHash
+		stats.AccumulatorState = proof.AccumulatorState
+	}
+}
+
+// sample records the run's state after a proposal
+func (r *run) sample(proposal int) Sample {
+	min, max := r.shards.Thresholds()
+	return Sample{
+		Proposal:    proposal,
+		ChainHeight: r.tip().Index,
+		Shards:      r.shards.Shards.Size(),
+		Level:       r.orchestrator.Level(),
+		MinBlocks:   min,
+		MaxBlocks:   max,
+	}
+}
+
+func (r *run) tip() core.Block {
+	return r.chain.Blocks[len(r.chain.Blocks)-1]
+}
+
+// lossySyncer fails block moves at the current network error rate, as if
+// the block were lost in transit; failed moves leave both shards untouched
+type lossySyncer struct {
+	syncer    core.BlockSyncer
+	rng       core.RandomSource
+	errorRate float64
+}
+
+// SyncBlock implements core.BlockSyncer
+func (l *lossySyncer) SyncBlock(source, destination *core.Shard, blockIndex int) error {
+	if l.errorRate > 0 && l.rng.Float64() < l.errorRate {
+		return fmt.Errorf("%w: shard #%d to #%d", ErrTransferLost, source.ID, destination.ID)
+	}
+	return l.syncer.SyncBlock(source, destination, blockIndex)
+}
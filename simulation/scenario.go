@@ -0,0 +1,266 @@
+// Package simulation runs the whole ledger pipeline - chain growth,
+// sharding, cross-shard transfers, BFT consensus and pruning - from a
+// Scenario and summarizes it in a Report. Every random choice is drawn from
+// the scenario's seed and time is virtual, so two runs of the same scenario
+// produce the same report.
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"blockchain-system/core"
+)
+
+// ErrInvalidScenario is returned for scenarios that cannot be run
+var ErrInvalidScenario = errors.New("invalid scenario")
+
+// Kinds of SizeDistribution
+const (
+	SizeFixed   = "fixed"
+	SizeUniform = "uniform"
+	SizeNormal  = "normal"
+)
+
+// Defaults of NewScenario
+const (
+	DefaultBlocks            = 50
+	DefaultBlockSize         = 64
+	DefaultNodes             = 4
+	DefaultTransfersPerBlock = 1
+	DefaultPruneInterval     = 10
+	DefaultPruneRetention    = 20
+	DefaultMaxViews          = 4
+	DefaultBlockInterval     = 10 * time.Second
+)
+
+// SizeDistribution draws the payload size in bytes of each block. Fixed
+// blocks are Mean bytes, uniform ones between Min and Max, and normal ones
+// around Mean with StdDev clamped to [Min, Max] when Max is set.
+type SizeDistribution struct {
+	Kind   string `json:"kind"`
+	Min    int    `json:"min,omitempty"`
+	Max    int    `json:"max,omitempty"`
+	Mean   int    `json:"mean,omitempty"`
+	StdDev int    `json:"std_dev,omitempty"`
+}
+
+// NetworkPhase is the network condition from proposal FromBlock until the
+// next phase. Latency and ErrorRate feed the ConsistencyOrchestrator
+// and AdaptiveCapacityManager; consensus messages are delayed by Latency
+// give or take Jitter and lost at ErrorRate, as are cross-shard transfers.
+type NetworkPhase struct {
+	FromBlock int           `json:"from_block"`
+	Latency   time.Duration `json:"latency"`
+	Jitter    time.Duration `json:"jitter,omitempty"`
+	ErrorRate float64       `json:"error_rate"`
+}
+
+// NetworkProfile schedules network conditions over the run
+type NetworkProfile struct {
+	Phases []NetworkPhase `json:"phases"` // Ascending by FromBlock
+}
+
+// phaseAt returns the phase in effect at a block height, or a healthy
+// network before the first phase
+func (p NetworkProfile) phaseAt(height int) NetworkPhase {
+	phase := NetworkPhase{Latency: 10 * time.Millisecond}
+	for _, next := range p.Phases {
+		if next.FromBlock > height {
+			break
+		}
+		phase = next
+	}
+	return phase
+}
+
+// Scenario describes one simulation run
+type Scenario struct {
+	Seed              int64            `json:"seed"`
+	Blocks            int              `json:"blocks"` // Heights to propose
+	BlockSize         SizeDistribution `json:"block_size"`
+	BlockInterval     time.Duration    `json:"block_interval"` // Virtual time between proposals
+	MinBlocksPerShard int              `json:"min_blocks_per_shard"`
+	MaxBlocksPerShard int              `json:"max_blocks_per_shard"`
+	Nodes             int              `json:"nodes"`
+	ByzantineFraction float64          `json:"byzantine_fraction"` // Share of nodes that stay silent
+	Network           NetworkProfile   `json:"network"`
+	TransfersPerBlock int              `json:"transfers_per_block"`
+	PruneInterval     int              `json:"prune_interval"`  // Heights between pruning passes; 0 disables
+	PruneRetention    int              `json:"prune_retention"` // Blocks kept by each pass, at least 1
+	RoundTimeout      time.Duration    `json:"round_timeout"`
+	MaxViews          int              `json:"max_views"` // Views tried per height before it is skipped
+}
+
+// Option configures a Scenario
+type Option func(*Scenario)
+
+// WithSeed sets the seed every random choice is drawn from
+func WithSeed(seed int64) Option {
+	return func(s *Scenario) {
+		s.Seed = seed
+	}
+}
+
+// WithBlocks sets the number of heights to propose
+func WithBlocks(n int) Option {
+	return func(s *Scenario) {
+		s.Blocks = n
+	}
+}
+
+// WithBlockSize sets the distribution of block payload sizes
+func WithBlockSize(dist SizeDistribution) Option {
+	return func(s *Scenario) {
+		s.BlockSize = dist
+	}
+}
+
+// WithShardThresholds sets the base merge and split thresholds, which the
+// adaptive threshold controller scales as the network degrades
+func WithShardThresholds(min, max int) Option {
+	return func(s *Scenario) {
+		s.MinBlocksPerShard, s.MaxBlocksPerShard = min, max
+	}
+}
+
+// WithNodes sets the number of consensus nodes and the share of them that
+// are Byzantine
+func WithNodes(n int, byzantineFraction float64) Option {
+	return func(s *Scenario) {
+		s.Nodes, s.ByzantineFraction = n, byzantineFraction
+	}
+}
+
+// WithNetworkProfile sets the schedule of network conditions
+func WithNetworkProfile(profile NetworkProfile) Option {
+	return func(s *Scenario) {
+		s.Network = profile
+	}
+}
+
+// WithTransfers sets how many cross-shard transfers are attempted per height
+func WithTransfers(perBlock int) Option {
+	return func(s *Scenario) {
+		s.TransfersPerBlock = perBlock
+	}
+}
+
+// WithPruning prunes the chain down to retention blocks every interval
+// heights; an interval of 0 disables pruning
+func WithPruning(interval, retention int) Option {
+	return func(s *Scenario) {
+		s.PruneInterval, s.PruneRetention = interval, retention
+	}
+}
+
+// WithConsensusTimeout sets the virtual time each view waits for a decision
+// and how many views a height gets
+func WithConsensusTimeout(timeout time.Duration, maxViews int) Option {
+	return func(s *Scenario) {
+		s.RoundTimeout, s.MaxViews = timeout, maxViews
+	}
+}
+
+// NewScenario creates a scenario from the defaults and opts
+func NewScenario(opts ...Option) Scenario {
+	s := Scenario{
+		Seed:              1,
+		Blocks:            DefaultBlocks,
+		BlockSize:         SizeDistribution{Kind: SizeFixed, Mean: DefaultBlockSize},
+		BlockInterval:     DefaultBlockInterval,
+		MinBlocksPerShard: core.MinBlocksPerShard,
+		MaxBlocksPerShard: core.MaxBlocksPerShard,
+		Nodes:             DefaultNodes,
+		TransfersPerBlock: DefaultTransfersPerBlock,
+		PruneInterval:     DefaultPruneInterval,
+		PruneRetention:    DefaultPruneRetention,
+		RoundTimeout:      core.DefaultRoundTimeout,
+		MaxViews:          DefaultMaxViews,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// LoadScenario decodes a JSON scenario, taking fields it leaves out from the
+// defaults of NewScenario
+func LoadScenario(r io.Reader) (Scenario, error) {
+	s := NewScenario()
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return Scenario{}, fmt.Errorf("decoding scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return Scenario{}, err
+	}
+	return s, nil
+}
+
+// Validate reports the first setting that makes the scenario unrunnable
+func (s Scenario) Validate() error {
+	switch {
+	case s.Blocks < 1:
+		return fmt.Errorf("%w: %d blocks", ErrInvalidScenario, s.Blocks)
+	case s.MinBlocksPerShard < 1 || s.MaxBlocksPerShard < s.MinBlocksPerShard:
+		return fmt.Errorf("%w: shard thresholds %d-%d", ErrInvalidScenario, s.MinBlocksPerShard, s.MaxBlocksPerShard)
+	case s.Nodes < 1:
+		return fmt.Errorf("%w: %d nodes", ErrInvalidScenario, s.Nodes)
+	case s.ByzantineFraction < 0 || s.ByzantineFraction >= 1:
+		return fmt.Errorf("%w: Byzantine fraction %.2f", ErrInvalidScenario, s.ByzantineFraction)
+	case s.TransfersPerBlock < 0:
+		return fmt.Errorf("%w: %d transfers per block", ErrInvalidScenario, s.TransfersPerBlock)
+	case s.PruneInterval < 0 || (s.PruneInterval > 0 && s.PruneRetention < 1):
+		return fmt.Errorf("%w: pruning every %d keeping %d", ErrInvalidScenario, s.PruneInterval, s.PruneRetention)
+	case s.BlockInterval < 0 || s.RoundTimeout <= 0 || s.MaxViews < 1:
+		return fmt.Errorf("%w: %d views of %s", ErrInvalidScenario, s.MaxViews, s.RoundTimeout)
+	}
+	switch s.BlockSize.Kind {
+	case SizeFixed, SizeUniform, SizeNormal:
+	default:
+		return fmt.Errorf("%w: block size distribution %q", ErrInvalidScenario, s.BlockSize.Kind)
+	}
+	for i, phase := range s.Network.Phases {
+		if phase.ErrorRate < 0 || phase.ErrorRate > 1 || phase.Latency < 0 || phase.Jitter < 0 {
+			return fmt.Errorf("%w: network phase %d", ErrInvalidScenario, i)
+		}
+		if i > 0 && phase.FromBlock < s.Network.Phases[i-1].FromBlock {
+			return fmt.Errorf("%w: network phases out of order at %d", ErrInvalidScenario, i)
+		}
+	}
+	return nil
+}
+
+// sample draws one block payload size
+func (d SizeDistribution) sample(rng core.RandomSource) int {
+	var size int
+	switch d.Kind {
+	case SizeUniform:
+		size = d.Min
+		if d.Max > d.Min {
+			size += rng.Intn(d.Max - d.Min + 1)
+		}
+	case SizeNormal:
+		// Box-Muller transform; 1-Float64 keeps the logarithm finite
+		z := math.Sqrt(-2*math.Log(1-rng.Float64())) * math.Cos(2*math.Pi*rng.Float64())
+		size = d.Mean + int(z*float64(d.StdDev))
+		if d.Max > 0 && size > d.Max {
+			size = d.Max
+		}
+		if size < d.Min {
+			size = d.Min
+		}
+	default:
+		size = d.Mean
+	}
+	if size < 0 {
+		return 0
+	}
+	return size
+}
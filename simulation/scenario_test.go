@@ -0,0 +1,116 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stormyScenario exercises every stage: variable block sizes, a network
+// that degrades mid-run, Byzantine nodes, transfers and pruning
+func stormyScenario(seed int64) Scenario {
+	return NewScenario(
+		WithSeed(seed),
+		WithBlocks(25),
+		WithBlockSize(SizeDistribution{Kind: SizeNormal, Min: 8, Max: 256, Mean: 64, StdDev: 32}),
+		WithShardThresholds(2, 6),
+		WithNodes(7, 0.25),
+		WithNetworkProfile(NetworkProfile{Phases: []NetworkPhase{
+			{FromBlock: 8, Latency: 200 * time.Millisecond, Jitter: 50 * time.Millisecond, ErrorRate: 0.2},
+			{FromBlock: 18, Latency: 20 * time.Millisecond},
+		}}),
+		WithTransfers(2),
+		WithPruning(8, 10),
+	)
+}
+
+// mustRun runs a scenario and fails the test on error
+func mustRun(t *testing.T, s Scenario) Report {
+	t.Helper()
+	report, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestRunIsDeterministicForSeed(t *testing.T) {
+	first, second := mustRun(t, stormyScenario(42)), mustRun(t, stormyScenario(42))
+	if !reflect.DeepEqual(first, second) {
+		a, _ := json.Marshal(first)
+		b, _ := json.Marshal(second)
+		t.Fatalf("reports differ:\n%s\n%s", a, b)
+	}
+
+	if len(first.Timeline) != 25 || first.ForestRoot == "" || first.ChainHeight == 0 {
+		t.Fatalf("report %+v", first)
+	}
+	// Transfers need two shards, so the first proposals attempt none
+	if first.Transfers.Attempted == 0 || first.Transfers.Attempted > 50 || first.Transfers.Committed+first.Transfers.Failed != first.Transfers.Attempted {
+		t.Fatalf("transfers %+v", first.Transfers)
+	}
+	if first.Consensus.ByzantineNodes != 1 || first.Consensus.Decided+first.Consensus.Skipped != 25 {
+		t.Fatalf("consensus %+v", first.Consensus)
+	}
+	if first.Pruning.Passes == 0 || first.Pruning.AccumulatorState == "" {
+		t.Fatalf("pruning %+v", first.Pruning)
+	}
+
+	if other := mustRun(t, stormyScenario(43)); reflect.DeepEqual(first, other) {
+		t.Fatal("another seed produced the same report")
+	}
+}
+
+func TestDegradedNetworkLowersTransferSuccess(t *testing.T) {
+	healthy := mustRun(t, NewScenario(WithBlocks(30), WithTransfers(2)))
+	lossy := mustRun(t, NewScenario(WithBlocks(30), WithTransfers(2), WithNetworkProfile(NetworkProfile{
+		Phases: []NetworkPhase{{Latency: 50 * time.Millisecond, ErrorRate: 0.5}},
+	})))
+	if lossy.Transfers.SuccessRate >= healthy.Transfers.SuccessRate {
+		t.Fatalf("success rate %.2f on a lossy network, %.2f on a healthy one", lossy.Transfers.SuccessRate, healthy.Transfers.SuccessRate)
+	}
+	for _, sample := range lossy.Timeline {
+		if sample.MinBlocks < 1 || sample.MaxBlocks < sample.MinBlocks {
+			t.Fatalf("thresholds %d-%d at proposal %d", sample.MinBlocks, sample.MaxBlocks, sample.Proposal)
+		}
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	s, err := LoadScenario(strings.NewReader(`{"seed": 9, "blocks": 12, "nodes": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewScenario(WithSeed(9), WithBlocks(12), WithNodes(5, 0))
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("loaded %+v, want %+v", s, want)
+	}
+	if _, err := LoadScenario(strings.NewReader(`{"blocks": 12, "shards": 3}`)); err == nil {
+		t.Fatal("unknown field accepted")
+	}
+	if _, err := LoadScenario(strings.NewReader(`{"blocks": 0}`)); !errors.Is(err, ErrInvalidScenario) {
+		t.Fatalf("zero blocks: %v", err)
+	}
+}
+
+func TestValidateRejectsUnrunnableScenarios(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"no blocks":          WithBlocks(0),
+		"inverted shards":    WithShardThresholds(5, 2),
+		"no nodes":           WithNodes(0, 0),
+		"all Byzantine":      WithNodes(4, 1),
+		"negative transfers": WithTransfers(-1),
+		"no retention":       WithPruning(5, 0),
+		"no views":           WithConsensusTimeout(time.Second, 0),
+		"unknown sizes":      WithBlockSize(SizeDistribution{Kind: "zipf"}),
+		"bad error rate":     WithNetworkProfile(NetworkProfile{Phases: []NetworkPhase{{ErrorRate: 1.5}}}),
+		"unordered phases":   WithNetworkProfile(NetworkProfile{Phases: []NetworkPhase{{FromBlock: 5}, {FromBlock: 2}}}),
+	} {
+		if _, err := NewScenario(opt).Run(); !errors.Is(err, ErrInvalidScenario) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}